@@ -10,9 +10,11 @@ const usage = `gocode-router is a secure OpenAI-compatible proxy.
 
 Usage:
   gocode-router serve [flags]
+  gocode-router validate [flags]
 
 Commands:
-  serve    Start the HTTP server
+  serve     Start the HTTP server
+  validate  Check a configuration and list the models it serves
 
 Flags:
   -h, --help  Show this help message`
@@ -26,6 +28,8 @@ func Execute(ctx context.Context, args []string) error {
 	switch args[0] {
 	case "serve":
 		return serve(ctx, args[1:])
+	case "validate":
+		return validate(ctx, args[1:])
 	case "help", "-h", "--help":
 		return printUsage()
 	default:
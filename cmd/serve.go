@@ -6,23 +6,33 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"gocode-router/internal/config"
+	"gocode-router/internal/plugin"
 	"gocode-router/internal/provider"
 	providerfactory "gocode-router/internal/provider/factory"
 	"gocode-router/internal/router"
 	"gocode-router/internal/server"
+	"gocode-router/internal/tokens"
 )
 
 const serveUsage = `Usage:
   gocode-router serve --config <path> [--port <port>]
+  gocode-router serve --config-url <url> [--config-poll <interval>] [--port <port>]
 
 Flags:
-  --config string   Path to YAML configuration file (required)
-  --port   int      Override server port from configuration`
+  --config      string     Path to YAML configuration file
+  --config-url  string     HTTP(S) URL of a shared configuration (object storage, Consul KV ?raw)
+  --config-poll duration   How often to poll --config-url for a new version (default 15s)
+  --port        int        Override server port from configuration`
+
+const defaultConfigPoll = 15 * time.Second
 
 func serve(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
@@ -30,9 +40,12 @@ func serve(ctx context.Context, args []string) error {
 		fmt.Fprintln(os.Stderr, serveUsage)
 	}
 
-	var cfgPath string
+	var cfgPath, cfgURL string
 	var overridePort int
+	var pollInterval time.Duration
 	fs.StringVar(&cfgPath, "config", "", "path to configuration file")
+	fs.StringVar(&cfgURL, "config-url", "", "url of shared configuration")
+	fs.DurationVar(&pollInterval, "config-poll", defaultConfigPoll, "remote configuration poll interval")
 	fs.IntVar(&overridePort, "port", 0, "override server port")
 
 	if err := fs.Parse(args); err != nil {
@@ -42,11 +55,27 @@ func serve(ctx context.Context, args []string) error {
 		return fmt.Errorf("parse serve flags: %w", err)
 	}
 
-	if cfgPath == "" {
-		return errors.New("serve command requires --config <path>")
+	if (cfgPath == "") == (cfgURL == "") {
+		return errors.New("serve command requires exactly one of --config <path> or --config-url <url>")
+	}
+	if pollInterval <= 0 {
+		return fmt.Errorf("config poll interval %s must be positive", pollInterval)
 	}
 
-	cfg, err := config.Load(cfgPath)
+	var (
+		cfg    config.Config
+		remote *config.Remote
+		err    error
+	)
+	if cfgURL != "" {
+		remote, err = config.NewRemote(cfgURL, &http.Client{Timeout: 30 * time.Second})
+		if err != nil {
+			return err
+		}
+		cfg, _, err = remote.Fetch(ctx)
+	} else {
+		cfg, err = config.Load(cfgPath)
+	}
 	if err != nil {
 		return err
 	}
@@ -68,17 +97,25 @@ func serve(ctx context.Context, args []string) error {
 		return err
 	}
 
+	reloads := make(chan chan error)
+	srv.SetReloader(requestReload(reloads))
+
+	if remote != nil {
+		srv.SetConfigVersion(remote.Version())
+		go watchRemoteConfig(ctx, srv, remote, pollInterval, overridePort, reloads)
+		return srv.Run(ctx)
+	}
+
 	absCfgPath, err := filepath.Abs(cfgPath)
 	if err != nil {
 		return fmt.Errorf("resolve config path: %w", err)
 	}
 
-	info, err := os.Stat(absCfgPath)
-	if err != nil {
+	if _, err := os.Stat(absCfgPath); err != nil {
 		return fmt.Errorf("stat config file: %w", err)
 	}
 
-	go watchConfigFile(ctx, srv, absCfgPath, info.ModTime(), overridePort)
+	go watchConfigFile(ctx, srv, absCfgPath, overridePort, reloads)
 
 	return srv.Run(ctx)
 }
@@ -88,51 +125,128 @@ func buildRouter(ctx context.Context, cfg config.Config) (*router.Router, error)
 	if err := providerfactory.RegisterConfiguredProviders(ctx, cfg, registry); err != nil {
 		return nil, err
 	}
-	return router.New(registry), nil
+	plugins, err := plugin.Load(ctx, cfg.Routing.Plugins)
+	if err != nil {
+		return nil, err
+	}
+	tokenizers, err := tokens.Load(cfg.Tokenizers)
+	if err != nil {
+		return nil, err
+	}
+	rt := router.New(registry, cfg.Routing)
+	rt.UsePlugins(plugins)
+	rt.UseTokenizers(tokenizers)
+	limits := make(map[string]config.ConcurrencyConfig)
+	for name, p := range cfg.Providers.Named() {
+		limits[name] = p.Concurrency
+	}
+	rt.UseProviderConcurrency(limits)
+	return rt, nil
+}
+
+// requestReload returns a reloader for the admin API that asks the config
+// watcher to reload through requests and waits for the outcome.
+func requestReload(requests chan<- chan error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		reply := make(chan error, 1)
+		select {
+		case requests <- reply:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case err := <-reply:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// reloadConfigFile loads the configuration file and serves it. A
+// configuration that fails to load or build leaves the running one in
+// place.
+func reloadConfigFile(ctx context.Context, srv *server.Server, cfgPath string, overridePort int) error {
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		slog.Warn("config reload failed", "path", cfgPath, "error", err)
+		return err
+	}
+
+	if overridePort != 0 {
+		cfg.Server.Port = overridePort
+	}
+
+	rt, err := buildRouter(ctx, cfg)
+	if err != nil {
+		slog.Warn("provider rebuild failed", "error", err)
+		return err
+	}
+
+	srv.UpdateRouting(cfg, rt)
+	slog.Info("configuration reloaded", "path", cfgPath)
+	return nil
 }
 
-func watchConfigFile(ctx context.Context, srv *server.Server, cfgPath string, lastMod time.Time, overridePort int) {
-	ticker := time.NewTicker(2 * time.Second)
+// watchRemoteConfig polls the remote configuration every interval, on
+// SIGHUP and when the admin API asks through reloads, and serves each new
+// version.
+func watchRemoteConfig(ctx context.Context, srv *server.Server, remote *config.Remote, interval time.Duration, overridePort int, reloads <-chan chan error) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	slog.Info("hot reload enabled", "path", cfgPath)
+	// SIGHUP polls at once rather than waiting for the next tick.
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	slog.Info("remote config polling enabled", "interval", interval, "version", remote.Version())
 
 	for {
+		var reply chan error
 		select {
 		case <-ctx.Done():
-			slog.Debug("config watcher shutting down", "path", cfgPath)
+			slog.Debug("remote config watcher shutting down")
 			return
+		case <-hangup:
+			slog.Info("SIGHUP received, polling remote configuration")
+		case reply = <-reloads:
+			slog.Info("reload requested through the admin API, polling remote configuration")
 		case <-ticker.C:
-			info, err := os.Stat(cfgPath)
-			if err != nil {
-				slog.Warn("config watcher stat failed", "path", cfgPath, "error", err)
-				continue
-			}
-
-			modTime := info.ModTime()
-			if !modTime.After(lastMod) {
-				continue
-			}
-
-			cfg, err := config.Load(cfgPath)
-			if err != nil {
-				slog.Warn("config reload failed", "path", cfgPath, "error", err)
-				continue
-			}
-
-			if overridePort != 0 {
-				cfg.Server.Port = overridePort
-			}
-
-			rt, err := buildRouter(ctx, cfg)
-			if err != nil {
-				slog.Warn("provider rebuild failed", "error", err)
-				continue
-			}
-
-			srv.UpdateRouting(cfg, rt)
-			slog.Info("configuration reloaded", "path", cfgPath)
-			lastMod = modTime
 		}
+
+		err := reloadRemoteConfig(ctx, srv, remote, overridePort)
+		if reply != nil {
+			reply <- err
+		}
+	}
+}
+
+// reloadRemoteConfig fetches the remote configuration and serves it if it
+// changed. A configuration that fails to fetch or build leaves the running
+// one in place.
+func reloadRemoteConfig(ctx context.Context, srv *server.Server, remote *config.Remote, overridePort int) error {
+	cfg, changed, err := remote.Fetch(ctx)
+	if err != nil {
+		slog.Warn("remote config fetch failed", "error", err)
+		return err
 	}
+	if !changed {
+		return nil
+	}
+
+	if overridePort != 0 {
+		cfg.Server.Port = overridePort
+	}
+
+	rt, err := buildRouter(ctx, cfg)
+	if err != nil {
+		slog.Warn("provider rebuild failed", "version", remote.Version(), "error", err)
+		return err
+	}
+
+	srv.UpdateRouting(cfg, rt)
+	srv.SetConfigVersion(remote.Version())
+	slog.Info("configuration reloaded", "version", remote.Version())
+	return nil
 }
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/provider"
+	providerfactory "gocode-router/internal/provider/factory"
+)
+
+const validateUsage = `Usage:
+  gocode-router validate --config <path> [--check] [--timeout <duration>]
+
+Flags:
+  --config   string     Path to YAML configuration file
+  --check               Also contact each provider to confirm it is reachable and accepts its credentials
+  --timeout  duration   Time allowed for each provider check (default 10s)`
+
+const defaultCheckTimeout = 10 * time.Second
+
+func validate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, validateUsage)
+	}
+
+	var cfgPath string
+	var check bool
+	var timeout time.Duration
+	fs.StringVar(&cfgPath, "config", "", "path to configuration file")
+	fs.BoolVar(&check, "check", false, "check provider connectivity and credentials")
+	fs.DurationVar(&timeout, "timeout", defaultCheckTimeout, "timeout of each provider check")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return fmt.Errorf("parse validate flags: %w", err)
+	}
+	if cfgPath == "" {
+		return errors.New("validate command requires --config <path>")
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("check timeout %s must be positive", timeout)
+	}
+
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	// Providers are built as serve would build them, which also fetches the
+	// model lists of providers with discovery enabled.
+	registry := provider.NewRegistry()
+	if err := providerfactory.RegisterConfiguredProviders(ctx, cfg, registry); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: configuration is valid\n\n", cfgPath)
+	printModels(os.Stdout, registry)
+
+	if !check {
+		return nil
+	}
+	fmt.Println()
+	failed := checkProviders(ctx, os.Stdout, registry, cfg, timeout)
+	if failed > 0 {
+		return fmt.Errorf("%d provider check(s) failed", failed)
+	}
+	return nil
+}
+
+// printModels lists every model and alias the registry would serve.
+func printModels(out io.Writer, registry *provider.Registry) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tPROVIDER\tAPI STYLE\tMAX OUTPUT TOKENS")
+	for _, model := range registry.Models() {
+		providers := model.Provider
+		if deployments := registry.Deployments(model.ID); deployments != nil {
+			names := make([]string, len(deployments))
+			for i, d := range deployments {
+				names[i] = d.Provider.Name()
+			}
+			providers = strings.Join(names, ",")
+		}
+		maxOutput := "-"
+		if model.MaxOutputTokens > 0 {
+			maxOutput = strconv.Itoa(model.MaxOutputTokens)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", model.ID, providers, model.APIStyle, maxOutput)
+	}
+	w.Flush()
+}
+
+// checkProviders contacts each provider and returns how many failed. Listing
+// the upstream models needs valid credentials, so it is preferred; providers
+// that cannot list them are only probed for reachability.
+func checkProviders(ctx context.Context, out io.Writer, registry *provider.Registry, cfg config.Config, timeout time.Duration) int {
+	named := cfg.Providers.Named()
+	failed := 0
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tCHECK\tRESULT")
+	for _, p := range registry.Providers() {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		kind, err := checkProvider(checkCtx, p, named[p.Name()])
+		cancel()
+
+		result := "ok"
+		switch {
+		case kind == "":
+			kind, result = "-", "skipped"
+		case err != nil:
+			failed++
+			result = "FAILED: " + firstLine(err.Error())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name(), kind, result)
+	}
+	w.Flush()
+	return failed
+}
+
+func checkProvider(ctx context.Context, p provider.Provider, cfg config.ProviderConfig) (string, error) {
+	if d, ok := p.(provider.Discoverer); ok && cfg.Type != config.ProviderTypeAzure {
+		models, err := d.DiscoverModels(ctx)
+		if err != nil {
+			return "list models", err
+		}
+		return fmt.Sprintf("list models (%d)", len(models)), nil
+	}
+	if prober, ok := p.(provider.Prober); ok {
+		return "probe", prober.Probe(ctx)
+	}
+	return "", nil
+}
+
+// firstLine keeps an error on one table row; upstream errors can carry a
+// whole HTML page.
+func firstLine(s string) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	if len(s) > 120 {
+		s = s[:120] + "..."
+	}
+	return s
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"gocode-router/internal/server"
+)
+
+const (
+	// configDebounce is how long a config file must stay unchanged after
+	// a change before it is reloaded, so an editor's burst of writes
+	// causes one reload.
+	configDebounce = 250 * time.Millisecond
+	// configPollInterval is how often the file is checked where change
+	// notifications are unavailable.
+	configPollInterval = 2 * time.Second
+)
+
+// watchFileChanges sends on changes whenever the file at path may have
+// changed, until ctx ends. It polls unless the platform can notify.
+var watchFileChanges = pollFileChanges
+
+// pollFileChanges signals a change whenever the file's modification time
+// or size differs from the last check.
+func pollFileChanges(ctx context.Context, path string, changes chan<- struct{}) error {
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	var last os.FileInfo
+	if info, err := os.Stat(path); err == nil {
+		last = info
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				slog.Warn("config watcher stat failed", "path", path, "error", err)
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+			notifyChange(changes)
+		}
+	}
+}
+
+// notifyChange sends on changes without blocking; one pending change is
+// as good as many.
+func notifyChange(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// watchConfigFile reloads the configuration when the file changes, however
+// it is written: in place, or replaced by a rename as editors and
+// Kubernetes config maps do. Changes are debounced, and a file whose
+// content is what was last loaded is not reloaded. SIGHUP and requests
+// from the admin API on reloads reload the file at once.
+func watchConfigFile(ctx context.Context, srv *server.Server, cfgPath string, overridePort int, reloads <-chan chan error) {
+	changes := make(chan struct{}, 1)
+	go func() {
+		if err := watchFileChanges(ctx, cfgPath, changes); err != nil {
+			slog.Warn("config change notifications unavailable, polling instead", "path", cfgPath, "error", err)
+			_ = pollFileChanges(ctx, cfgPath, changes)
+		}
+	}()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	slog.Info("hot reload enabled", "path", cfgPath)
+
+	loaded := fileDigest(cfgPath)
+	var debounce <-chan time.Time
+	for {
+		var reply chan error
+		select {
+		case <-ctx.Done():
+			slog.Debug("config watcher shutting down", "path", cfgPath)
+			return
+		case <-changes:
+			debounce = time.After(configDebounce)
+			continue
+		case <-hangup:
+			slog.Info("SIGHUP received, reloading configuration", "path", cfgPath)
+		case reply = <-reloads:
+			slog.Info("reload requested through the admin API", "path", cfgPath)
+		case <-debounce:
+			if bytes.Equal(fileDigest(cfgPath), loaded) {
+				continue
+			}
+		}
+		debounce = nil
+
+		digest := fileDigest(cfgPath)
+		err := reloadConfigFile(ctx, srv, cfgPath, overridePort)
+		if err == nil {
+			loaded = digest
+		}
+		if reply != nil {
+			reply <- err
+		}
+	}
+}
+
+// fileDigest hashes the file's content, or returns nil if it cannot be
+// read.
+func fileDigest(path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
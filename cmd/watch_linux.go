@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	watchFileChanges = inotifyFileChanges
+}
+
+// inotifyWatchMask covers a file written in place and a file replaced by a
+// rename or by a new file.
+const inotifyWatchMask = unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_CREATE | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// inotifyFileChanges watches the directory holding path, not the file: an
+// atomic write replaces the file, and a watch on it would end with it. Any
+// change in the directory counts, so a config map swapping a symlink is
+// seen too; the caller compares content before reloading.
+func inotifyFileChanges(ctx context.Context, path string, changes chan<- struct{}) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify init: %w", err)
+	}
+	// A non-blocking descriptor is read through the runtime poller, so
+	// closing it ends a pending read.
+	file := os.NewFile(uintptr(fd), "inotify")
+	defer file.Close()
+
+	dir := filepath.Dir(path)
+	if _, err := unix.InotifyAddWatch(fd, dir, inotifyWatchMask); err != nil {
+		return fmt.Errorf("watch %s: %w", dir, err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { file.Close() })
+	defer stop()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := file.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("read inotify events: %w", err)
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			if event.Mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0 {
+				return fmt.Errorf("directory %s was removed or moved", dir)
+			}
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+		}
+		// Every event, a queue overflow included, may be a change.
+		notifyChange(changes)
+	}
+}
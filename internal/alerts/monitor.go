@@ -0,0 +1,253 @@
+// Package alerts watches spend, error rates and usage growth in the
+// background and posts alerts to a webhook.
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/usage"
+)
+
+// Alert kinds.
+const (
+	KindSpend     = "spend"
+	KindErrorRate = "error_rate"
+	KindAnomaly   = "anomaly"
+)
+
+// Alert is a single notification.
+type Alert struct {
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	Message   string    `json:"message"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// UsageSource is the part of the usage aggregator the monitor reads.
+type UsageSource interface {
+	Query(ctx context.Context, q usage.Query) ([]usage.Bucket, error)
+}
+
+// Monitor evaluates the configured alerts on an interval. It reads the
+// configuration on every pass, so reloads take effect without a restart.
+type Monitor struct {
+	config func() config.Config
+	usage  UsageSource
+	client *http.Client
+	errors *errorWindow
+	now    func() time.Time
+
+	mu    sync.Mutex
+	fired map[string]time.Time
+}
+
+// NewMonitor constructs a monitor. source may be nil when usage recording
+// is disabled, in which case spend and anomaly alerts are skipped.
+func NewMonitor(cfg func() config.Config, source UsageSource) *Monitor {
+	return &Monitor{
+		config: cfg,
+		usage:  source,
+		client: &http.Client{Timeout: 10 * time.Second},
+		errors: newErrorWindow(),
+		now:    time.Now,
+		fired:  make(map[string]time.Time),
+	}
+}
+
+// ObserveRequest counts a finished request towards the error rate.
+func (m *Monitor) ObserveRequest(failed bool) {
+	m.errors.add(m.now(), failed)
+}
+
+// Run evaluates the alerts until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context) error {
+	timer := time.NewTimer(m.config().Alerts.EvaluateEvery())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-timer.C:
+		}
+		cfg := m.config()
+		if cfg.Alerts.Enabled {
+			m.evaluate(ctx, cfg)
+		}
+		timer.Reset(cfg.Alerts.EvaluateEvery())
+	}
+}
+
+func (m *Monitor) evaluate(ctx context.Context, cfg config.Config) {
+	now := m.now()
+	var alerts []Alert
+	if m.usage != nil {
+		alerts = append(alerts, m.spendAlerts(ctx, cfg, now)...)
+		alerts = append(alerts, m.anomalyAlerts(ctx, cfg, now)...)
+	}
+	if alert, ok := m.errorRateAlert(cfg.Alerts.ErrorRate, now); ok {
+		alerts = append(alerts, alert)
+	}
+	for _, alert := range alerts {
+		if err := m.post(ctx, cfg.Alerts.WebhookURL, alert); err != nil {
+			slog.Warn("alert delivery failed", "kind", alert.Kind, "name", alert.Name, "error", err)
+			continue
+		}
+		slog.Info("alert sent", "kind", alert.Kind, "name", alert.Name, "message", alert.Message)
+	}
+}
+
+// due records that the alert identified by id fires at now, reporting false
+// when it already fired since notBefore.
+func (m *Monitor) due(id string, now, notBefore time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if last, ok := m.fired[id]; ok && !last.Before(notBefore) {
+		return false
+	}
+	m.fired[id] = now
+	return true
+}
+
+// spendAlerts checks each spend rule against the current hour or day. A
+// rule fires at most once per period.
+func (m *Monitor) spendAlerts(ctx context.Context, cfg config.Config, now time.Time) []Alert {
+	var alerts []Alert
+	for i, rule := range cfg.Alerts.Spend {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("spend[%d]", i)
+		}
+		granularity := usage.Daily
+		if rule.AlertPeriod() == config.AlertPeriodHour {
+			granularity = usage.Hourly
+		}
+		start := granularity.Truncate(now)
+		q := usage.Query{Granularity: granularity, Provider: rule.Provider, From: start}
+		if rule.Key != "" {
+			q.Key = usage.KeyID(rule.Key)
+		}
+		buckets, err := m.usage.Query(ctx, q)
+		if err != nil {
+			slog.Warn("alert usage query failed", "alert", name, "error", err)
+			continue
+		}
+
+		var tokens int
+		var cost float64
+		for _, b := range buckets {
+			tokens += b.TotalTokens
+			if pricing, ok := cfg.Providers.Pricing(b.Provider, b.Model); ok {
+				cost += pricing.Cost(b.PromptTokens, b.CompletionTokens)
+			}
+		}
+
+		alert := Alert{Kind: KindSpend, Name: name, Key: q.Key, Provider: rule.Provider, Time: now}
+		switch {
+		case rule.Cost > 0 && cost > rule.Cost:
+			alert.Value, alert.Threshold = cost, rule.Cost
+			alert.Message = fmt.Sprintf("spend alert %s: $%.2f spent this %s, over the $%.2f threshold", name, cost, rule.AlertPeriod(), rule.Cost)
+		case rule.Tokens > 0 && tokens > rule.Tokens:
+			alert.Value, alert.Threshold = float64(tokens), float64(rule.Tokens)
+			alert.Message = fmt.Sprintf("spend alert %s: %d tokens used this %s, over the %d token threshold", name, tokens, rule.AlertPeriod(), rule.Tokens)
+		default:
+			continue
+		}
+		if m.due(KindSpend+"/"+name, now, start) {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// anomalyAlerts compares each key's tokens in the current hour with its
+// hourly average over the trailing baseline.
+func (m *Monitor) anomalyAlerts(ctx context.Context, cfg config.Config, now time.Time) []Alert {
+	policy := cfg.Alerts.Anomaly
+	if policy.Factor <= 0 {
+		return nil
+	}
+	current := usage.Hourly.Truncate(now)
+	baselineStart := current.Add(-time.Duration(policy.Baseline()) * time.Hour)
+	buckets, err := m.usage.Query(ctx, usage.Query{Granularity: usage.Hourly, From: baselineStart})
+	if err != nil {
+		slog.Warn("alert usage query failed", "alert", KindAnomaly, "error", err)
+		return nil
+	}
+
+	nowTokens := make(map[string]int)
+	pastTokens := make(map[string]int)
+	for _, b := range buckets {
+		if b.Start.Equal(current) {
+			nowTokens[b.Key] += b.TotalTokens
+		} else {
+			pastTokens[b.Key] += b.TotalTokens
+		}
+	}
+
+	keys := make([]string, 0, len(nowTokens))
+	for key := range nowTokens {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var alerts []Alert
+	cooldown := now.Add(-cfg.Alerts.CooldownPeriod())
+	for _, key := range keys {
+		tokens := nowTokens[key]
+		if tokens < policy.TokenFloor() {
+			continue
+		}
+		// Hours without traffic count towards the average as zero.
+		baseline := float64(pastTokens[key]) / float64(policy.Baseline())
+		if float64(tokens) <= policy.Factor*baseline {
+			continue
+		}
+		if !m.due(KindAnomaly+"/"+key, now, cooldown) {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			Kind:      KindAnomaly,
+			Key:       key,
+			Message:   fmt.Sprintf("usage anomaly: key %s used %d tokens this hour against a trailing average of %.0f per hour", key, tokens, baseline),
+			Value:     float64(tokens),
+			Threshold: policy.Factor * baseline,
+			Time:      now,
+		})
+	}
+	return alerts
+}
+
+func (m *Monitor) errorRateAlert(policy config.ErrorRateAlertConfig, now time.Time) (Alert, bool) {
+	if policy.Threshold <= 0 {
+		return Alert{}, false
+	}
+	total, failed := m.errors.count(now, policy.WindowLength())
+	if total < policy.RequestFloor() {
+		return Alert{}, false
+	}
+	rate := float64(failed) / float64(total)
+	if rate <= policy.Threshold {
+		return Alert{}, false
+	}
+	if !m.due(KindErrorRate, now, now.Add(-m.config().Alerts.CooldownPeriod())) {
+		return Alert{}, false
+	}
+	return Alert{
+		Kind:      KindErrorRate,
+		Message:   fmt.Sprintf("error rate alert: %.1f%% of %d requests failed in the last %s", rate*100, total, policy.WindowLength()),
+		Value:     rate,
+		Threshold: policy.Threshold,
+		Time:      now,
+	}, true
+}
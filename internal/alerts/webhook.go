@@ -0,0 +1,88 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhookPayload is accepted by Slack incoming webhooks, which read text
+// and ignore the rest, and carries the structured alert for other
+// receivers.
+type webhookPayload struct {
+	Text  string `json:"text"`
+	Alert Alert  `json:"alert"`
+}
+
+func (m *Monitor) post(ctx context.Context, url string, alert Alert) error {
+	body, err := json.Marshal(webhookPayload{Text: alert.Message, Alert: alert})
+	if err != nil {
+		return fmt.Errorf("marshal alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// errorWindow counts requests and failures per minute over the last hour.
+type errorWindow struct {
+	mu      sync.Mutex
+	minutes [60]minuteCount
+}
+
+type minuteCount struct {
+	minute int64
+	total  int
+	failed int
+}
+
+func newErrorWindow() *errorWindow {
+	return &errorWindow{}
+}
+
+func (w *errorWindow) add(now time.Time, failed bool) {
+	minute := now.Unix() / 60
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	slot := &w.minutes[minute%60]
+	if slot.minute != minute {
+		*slot = minuteCount{minute: minute}
+	}
+	slot.total++
+	if failed {
+		slot.failed++
+	}
+}
+
+// count sums the minutes overlapping the window ending at now.
+func (w *errorWindow) count(now time.Time, window time.Duration) (total, failed int) {
+	last := now.Unix() / 60
+	first := last - int64(window/time.Minute) + 1
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, slot := range w.minutes {
+		if slot.minute >= first && slot.minute <= last {
+			total += slot.total
+			failed += slot.failed
+		}
+	}
+	return total, failed
+}
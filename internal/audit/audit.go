@@ -0,0 +1,100 @@
+// Package audit records every API request with its outcome, for compliance
+// review, to a JSONL file, standard output or a webhook.
+package audit
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"gocode-router/internal/capture"
+	"gocode-router/internal/models"
+)
+
+// Record is one audited request: a line of the audit log.
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method"`
+	Endpoint  string    `json:"endpoint"`
+	Status    int       `json:"status"`
+	LatencyMS int64     `json:"latency_ms"`
+	KeyID     string    `json:"key_id,omitempty"`
+	RemoteIP  string    `json:"remote_ip,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	// Model is the model the client asked for; UpstreamModel is the one
+	// that answered, after routing.
+	Model         string            `json:"model,omitempty"`
+	Provider      string            `json:"provider,omitempty"`
+	UpstreamModel string            `json:"upstream_model,omitempty"`
+	Messages      []capture.Message `json:"messages,omitempty"`
+	Prompt        string            `json:"prompt,omitempty"`
+	Response      string            `json:"response,omitempty"`
+	FinishReason  string            `json:"finish_reason,omitempty"`
+	Usage         *capture.Usage    `json:"usage,omitempty"`
+	Cost          float64           `json:"cost,omitempty"`
+}
+
+// Entry collects what the handlers of a request learn about it until the
+// request finishes. Its methods do nothing on a nil Entry, so handlers
+// need not know whether auditing is on.
+type Entry struct {
+	mu  sync.Mutex
+	rec Record
+}
+
+type entryKey struct{}
+
+// WithEntry returns a context carrying a new entry for a request.
+func WithEntry(ctx context.Context) (context.Context, *Entry) {
+	e := &Entry{}
+	return context.WithValue(ctx, entryKey{}, e), e
+}
+
+// FromContext returns the request's entry, or nil when it is not audited.
+func FromContext(ctx context.Context) *Entry {
+	e, _ := ctx.Value(entryKey{}).(*Entry)
+	return e
+}
+
+// SetExchange records the prompt and response of an exchange, taken from
+// its capture record.
+func (e *Entry) SetExchange(rec capture.Record) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rec.Model = rec.Model
+	e.rec.Provider, e.rec.UpstreamModel = rec.Provider, rec.UpstreamModel
+	e.rec.Messages = slices.Clone(rec.Messages)
+	e.rec.Prompt = rec.Prompt
+	e.rec.Response = rec.Response
+	e.rec.FinishReason = rec.FinishReason
+}
+
+// AddUsage records the model that served the request and adds the tokens
+// it used and their cost.
+func (e *Entry) AddUsage(modelInfo models.Model, u models.Usage, cost float64) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rec.Provider, e.rec.UpstreamModel = modelInfo.Provider, modelInfo.ID
+	if e.rec.Usage == nil {
+		e.rec.Usage = &capture.Usage{}
+	}
+	e.rec.Usage.PromptTokens += u.PromptTokens
+	e.rec.Usage.CompletionTokens += u.CompletionTokens
+	e.rec.Usage.TotalTokens += u.TotalTokens
+	e.rec.Cost += cost
+}
+
+// Record returns what the entry collected.
+func (e *Entry) Record() Record {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.rec
+}
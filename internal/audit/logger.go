@@ -0,0 +1,225 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gocode-router/internal/capture"
+	"gocode-router/internal/config"
+)
+
+const (
+	// webhookQueue is how many records wait for the webhook before new
+	// ones are dropped.
+	webhookQueue = 4096
+	// webhookBatch is the most records posted at once; a partial batch is
+	// posted after webhookFlush.
+	webhookBatch   = 100
+	webhookFlush   = time.Second
+	webhookTimeout = 10 * time.Second
+)
+
+// Logger writes audit records to the configured sink.
+type Logger struct {
+	sink   sink
+	policy atomic.Pointer[policy]
+}
+
+// policy strips records of what must not be kept.
+type policy struct {
+	redact      *capture.Redactor
+	omitContent bool
+}
+
+type sink interface {
+	write(rec Record) error
+	close() error
+}
+
+// Open starts the sink of a valid, enabled audit configuration. The sink
+// is fixed for the lifetime of the process; Configure changes what is kept.
+func Open(cfg config.AuditConfig) (*Logger, error) {
+	l := &Logger{}
+	switch cfg.SinkName() {
+	case config.AuditSinkStdout:
+		l.sink = &streamSink{w: os.Stdout}
+	case config.AuditSinkWebhook:
+		l.sink = newWebhookSink(cfg.URL, cfg.Headers)
+	default:
+		recorder, err := capture.Open(cfg.Path, cfg.MaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("open audit log: %w", err)
+		}
+		l.sink = fileSink{recorder}
+	}
+	l.Configure(cfg)
+	return l, nil
+}
+
+// Configure applies the redaction and content settings of cfg to records
+// written from now on.
+func (l *Logger) Configure(cfg config.AuditConfig) {
+	l.policy.Store(&policy{redact: capture.NewRedactor(cfg.Redact), omitContent: cfg.OmitContent})
+}
+
+// Write strips a record as configured and hands it to the sink.
+func (l *Logger) Write(rec Record) error {
+	p := l.policy.Load()
+	if p.omitContent {
+		rec.Messages, rec.Prompt, rec.Response = nil, "", ""
+	} else if !p.redact.Empty() {
+		for i := range rec.Messages {
+			rec.Messages[i].Content = p.redact.Redact(rec.Messages[i].Content)
+		}
+		rec.Prompt = p.redact.Redact(rec.Prompt)
+		rec.Response = p.redact.Redact(rec.Response)
+	}
+	return l.sink.write(rec)
+}
+
+// Close flushes the sink and closes it.
+func (l *Logger) Close() error {
+	return l.sink.close()
+}
+
+type fileSink struct {
+	recorder *capture.Recorder
+}
+
+func (s fileSink) write(rec Record) error { return s.recorder.Write(rec) }
+func (s fileSink) close() error           { return s.recorder.Close() }
+
+// streamSink writes records as JSON lines to a stream such as stdout.
+type streamSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *streamSink) write(rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal audit record: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *streamSink) close() error { return nil }
+
+// webhookSink posts records in the background as JSONL batches, so a slow
+// receiver never holds up requests. Records that find the queue full are
+// dropped and counted in the log.
+type webhookSink struct {
+	url     string
+	headers config.Headers
+	client  *http.Client
+	queue   chan Record
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+func newWebhookSink(url string, headers config.Headers) *webhookSink {
+	s := &webhookSink{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: webhookTimeout},
+		queue:   make(chan Record, webhookQueue),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *webhookSink) write(rec Record) error {
+	select {
+	case s.queue <- rec:
+		return nil
+	default:
+		s.dropped.Add(1)
+		return errors.New("audit webhook queue is full")
+	}
+}
+
+// close posts the records still queued.
+func (s *webhookSink) close() error {
+	close(s.queue)
+	<-s.done
+	return nil
+}
+
+func (s *webhookSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(webhookFlush)
+	defer ticker.Stop()
+
+	var batch bytes.Buffer
+	count := 0
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		if err := s.post(batch.Bytes()); err != nil {
+			slog.Warn("audit webhook delivery failed", "records", count, "error", err)
+		}
+		if n := s.dropped.Swap(0); n > 0 {
+			slog.Warn("audit records dropped while the webhook fell behind", "records", n)
+		}
+		batch.Reset()
+		count = 0
+	}
+	for {
+		select {
+		case rec, ok := <-s.queue:
+			if !ok {
+				flush()
+				return
+			}
+			data, err := json.Marshal(rec)
+			if err != nil {
+				slog.Warn("failed to marshal audit record", "request_id", rec.RequestID, "error", err)
+				continue
+			}
+			batch.Write(append(data, '\n'))
+			if count++; count >= webhookBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *webhookSink) post(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	pruneInterval      = time.Minute
+	conversationPrefix = "conversation:"
+)
+
+// ConversationTracker accumulates token usage per conversation ID. Entries
+// that have been idle for longer than the configured TTL are forgotten.
+type ConversationTracker struct {
+	counter Counter
+}
+
+// NewConversationTracker constructs a tracker storing totals in counter.
+func NewConversationTracker(counter Counter) (*ConversationTracker, error) {
+	if counter == nil {
+		return nil, errors.New("counter must not be nil")
+	}
+	return &ConversationTracker{counter: counter}, nil
+}
+
+// Used reports the tokens consumed so far by the conversation.
+func (t *ConversationTracker) Used(ctx context.Context, id string) (int, error) {
+	used, err := t.counter.Get(ctx, conversationPrefix+id)
+	return int(used), err
+}
+
+// Add records additional token usage and returns the new running total.
+func (t *ConversationTracker) Add(ctx context.Context, id string, tokens int, ttl time.Duration) (int, error) {
+	if tokens < 0 {
+		tokens = 0
+	}
+	used, err := t.counter.Add(ctx, conversationPrefix+id, int64(tokens), ttl)
+	return int(used), err
+}
@@ -0,0 +1,156 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/redis"
+)
+
+// Counter accumulates integer totals under string keys. Implementations
+// backed by shared storage let every router instance see the same totals.
+type Counter interface {
+	// Get returns the current total for key, or zero when it is unset or expired.
+	Get(ctx context.Context, key string) (int64, error)
+	// Add increments key by delta and returns the new total. A positive ttl
+	// (re)starts the key's expiry, so idle keys are forgotten.
+	Add(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// NewCounter builds the counter selected by the state configuration.
+func NewCounter(state config.StateConfig) (Counter, error) {
+	if state.Backend != config.StateBackendRedis {
+		return NewMemoryCounter(), nil
+	}
+
+	client, err := newRedisClient(state)
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisCounter(client, state.Redis.Prefix())
+}
+
+func newRedisClient(state config.StateConfig) (*redis.Client, error) {
+	client, err := redis.New(redis.Options{
+		Addr:     state.Redis.Addr,
+		Password: state.Redis.Password,
+		DB:       state.Redis.DB,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("initialise redis client: %w", err)
+	}
+	return client, nil
+}
+
+type memoryEntry struct {
+	value   int64
+	expires time.Time
+}
+
+// MemoryCounter is a process-local Counter.
+type MemoryCounter struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewMemoryCounter constructs an empty in-process counter.
+func NewMemoryCounter() *MemoryCounter {
+	return &MemoryCounter{
+		entries: make(map[string]*memoryEntry),
+		now:     time.Now,
+	}
+}
+
+// Get implements Counter.
+func (m *MemoryCounter) Get(_ context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok || entry.expired(m.now()) {
+		return 0, nil
+	}
+	return entry.value, nil
+}
+
+// Add implements Counter.
+func (m *MemoryCounter) Add(_ context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.prune(now)
+
+	entry, ok := m.entries[key]
+	if !ok || entry.expired(now) {
+		entry = &memoryEntry{}
+		m.entries[key] = entry
+	}
+	entry.value += delta
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+	return entry.value, nil
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+func (m *MemoryCounter) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < pruneInterval {
+		return
+	}
+	m.lastPrune = now
+	for key, entry := range m.entries {
+		if entry.expired(now) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+// RedisCounter is a Counter stored in Redis so totals hold cluster-wide.
+type RedisCounter struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisCounter constructs a counter that namespaces keys with prefix.
+func NewRedisCounter(client *redis.Client, prefix string) (*RedisCounter, error) {
+	if client == nil {
+		return nil, errors.New("redis client must not be nil")
+	}
+	return &RedisCounter{client: client, prefix: prefix}, nil
+}
+
+// Get implements Counter.
+func (r *RedisCounter) Get(ctx context.Context, key string) (int64, error) {
+	value, err := r.client.Int(ctx, "GET", r.prefix+key)
+	if errors.Is(err, redis.ErrNil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get counter %q: %w", key, err)
+	}
+	return value, nil
+}
+
+// Add implements Counter.
+func (r *RedisCounter) Add(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	value, err := r.client.Int(ctx, "INCRBY", r.prefix+key, delta)
+	if err != nil {
+		return 0, fmt.Errorf("increment counter %q: %w", key, err)
+	}
+	if ttl > 0 {
+		if _, err := r.client.Do(ctx, "PEXPIRE", r.prefix+key, ttl.Milliseconds()); err != nil {
+			return value, fmt.Errorf("expire counter %q: %w", key, err)
+		}
+	}
+	return value, nil
+}
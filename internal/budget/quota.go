@@ -0,0 +1,190 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+const (
+	quotaPrefix = "quota:"
+	// microdollars scales spend to the integers counters hold.
+	microdollars = 1e6
+)
+
+// Amount is usage charged against a quota. Cost is in US dollars.
+type Amount struct {
+	Requests int64   `json:"requests"`
+	Tokens   int64   `json:"tokens"`
+	Cost     float64 `json:"cost"`
+}
+
+// QuotaState is a key's standing in the current window of a quota.
+type QuotaState struct {
+	Quota string `json:"quota"`
+	Key   string `json:"key"`
+	// Window is the calendar window or the rolling duration.
+	Window string    `json:"window"`
+	Start  time.Time `json:"window_start"`
+	// Reset is when the usage counted now has expired: the start of the
+	// next calendar window, or the time a rolling window has fully drained.
+	// Rolling windows free up gradually before then.
+	Reset time.Time `json:"resets_at"`
+	Limit Amount    `json:"limit"`
+	Used  Amount    `json:"used"`
+}
+
+// Remaining returns what is left of each configured limit.
+func (s QuotaState) Remaining() Amount {
+	return Amount{
+		Requests: max(s.Limit.Requests-s.Used.Requests, 0),
+		Tokens:   max(s.Limit.Tokens-s.Used.Tokens, 0),
+		Cost:     math.Max(s.Limit.Cost-s.Used.Cost, 0),
+	}
+}
+
+// Exceeded reports whether any configured limit is used up.
+func (s QuotaState) Exceeded() bool {
+	return (s.Limit.Requests > 0 && s.Used.Requests >= s.Limit.Requests) ||
+		(s.Limit.Tokens > 0 && s.Used.Tokens >= s.Limit.Tokens) ||
+		(s.Limit.Cost > 0 && s.Used.Cost >= s.Limit.Cost)
+}
+
+// QuotaTracker counts usage against quota windows in a Counter, so quotas
+// hold across instances sharing the Redis state backend.
+type QuotaTracker struct {
+	counter Counter
+	now     func() time.Time
+}
+
+// NewQuotaTracker constructs a tracker storing window totals in counter.
+func NewQuotaTracker(counter Counter) (*QuotaTracker, error) {
+	if counter == nil {
+		return nil, errors.New("counter must not be nil")
+	}
+	return &QuotaTracker{counter: counter, now: time.Now}, nil
+}
+
+// window is one fixed period of a quota. Rolling quotas are approximated
+// from the current fixed period and the one before it, weighted by how much
+// of it still falls inside the trailing duration.
+type window struct {
+	start, end time.Time
+	prevStart  time.Time
+	prevWeight float64
+}
+
+func windowAt(q config.QuotaConfig, now time.Time) (window, error) {
+	if q.Rolling > 0 {
+		start := now.Truncate(q.Rolling)
+		elapsed := now.Sub(start)
+		return window{
+			start:      start,
+			end:        start.Add(q.Rolling),
+			prevStart:  start.Add(-q.Rolling),
+			prevWeight: 1 - float64(elapsed)/float64(q.Rolling),
+		}, nil
+	}
+
+	loc, err := q.Location()
+	if err != nil {
+		return window{}, err
+	}
+	t := now.In(loc)
+	var start, end time.Time
+	switch q.Window {
+	case config.QuotaWindowMinute:
+		start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+		end = start.Add(time.Minute)
+	case config.QuotaWindowHour:
+		start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		end = start.Add(time.Hour)
+	case config.QuotaWindowDay:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 0, 1)
+	case config.QuotaWindowMonth:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 1, 0)
+	default:
+		return window{}, fmt.Errorf("unknown quota window %q", q.Window)
+	}
+	return window{start: start, end: end}, nil
+}
+
+// State reads the key's usage in the current window of q.
+func (t *QuotaTracker) State(ctx context.Context, q config.QuotaConfig, keyID string) (QuotaState, error) {
+	now := t.now()
+	w, err := windowAt(q, now)
+	if err != nil {
+		return QuotaState{}, err
+	}
+
+	state := QuotaState{
+		Quota:  q.Name,
+		Key:    keyID,
+		Window: q.Window,
+		Start:  w.start,
+		Reset:  w.end,
+		Limit:  Amount{Requests: int64(q.Requests), Tokens: int64(q.Tokens), Cost: q.Cost},
+	}
+	if q.Rolling > 0 {
+		state.Window = q.Rolling.String()
+		state.Start = now.Add(-q.Rolling)
+		state.Reset = w.end.Add(q.Rolling)
+	}
+
+	var errs []error
+	read := func(metric string, limited bool) float64 {
+		if !limited {
+			return 0
+		}
+		used, err := t.counter.Get(ctx, quotaKey(q.Name, keyID, metric, w.start))
+		errs = append(errs, err)
+		if w.prevWeight > 0 {
+			prev, err := t.counter.Get(ctx, quotaKey(q.Name, keyID, metric, w.prevStart))
+			errs = append(errs, err)
+			return float64(used) + float64(prev)*w.prevWeight
+		}
+		return float64(used)
+	}
+	state.Used.Requests = int64(math.Ceil(read("requests", q.Requests > 0)))
+	state.Used.Tokens = int64(math.Ceil(read("tokens", q.Tokens > 0)))
+	state.Used.Cost = read("cost", q.Cost > 0) / microdollars
+	return state, errors.Join(errs...)
+}
+
+// Charge adds usage to the key's current window of q. Only the amounts q
+// limits are stored.
+func (t *QuotaTracker) Charge(ctx context.Context, q config.QuotaConfig, keyID string, amount Amount) error {
+	w, err := windowAt(q, t.now())
+	if err != nil {
+		return err
+	}
+	// Totals expire once they stop counting, with some slack for clock skew
+	// between instances. A rolling total still counts as the previous one.
+	ttl := w.end.Sub(w.start) + time.Hour
+	if q.Rolling > 0 {
+		ttl = 2*q.Rolling + time.Minute
+	}
+
+	var errs []error
+	add := func(metric string, limited bool, delta int64) {
+		if !limited || delta <= 0 {
+			return
+		}
+		_, err := t.counter.Add(ctx, quotaKey(q.Name, keyID, metric, w.start), delta, ttl)
+		errs = append(errs, err)
+	}
+	add("requests", q.Requests > 0, amount.Requests)
+	add("tokens", q.Tokens > 0, amount.Tokens)
+	add("cost", q.Cost > 0, int64(math.Round(amount.Cost*microdollars)))
+	return errors.Join(errs...)
+}
+
+func quotaKey(name, keyID, metric string, start time.Time) string {
+	return fmt.Sprintf("%s%s:%s:%s:%d", quotaPrefix, name, keyID, metric, start.Unix())
+}
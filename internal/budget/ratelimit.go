@@ -0,0 +1,142 @@
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/redis"
+)
+
+const rateLimitPrefix = "rate:"
+
+// RateLimiter paces requests per key with a token bucket. Buckets are kept
+// as the generic cell rate algorithm's theoretical arrival time, which
+// behaves as a token bucket but needs only one value per key.
+type RateLimiter interface {
+	// Take removes a token from key's bucket, which refills perMinute
+	// tokens a minute and holds burst. When the bucket is empty it returns
+	// false and how long until a token is free.
+	Take(ctx context.Context, key string, perMinute, burst int) (bool, time.Duration, error)
+}
+
+// NewRateLimiter builds the rate limiter selected by the state
+// configuration.
+func NewRateLimiter(state config.StateConfig) (RateLimiter, error) {
+	if state.Backend != config.StateBackendRedis {
+		return NewMemoryRateLimiter(), nil
+	}
+	client, err := newRedisClient(state)
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisRateLimiter(client, state.Redis.Prefix())
+}
+
+// emissionInterval is the time one token takes to refill.
+func emissionInterval(perMinute int) time.Duration {
+	return time.Minute / time.Duration(perMinute)
+}
+
+// MemoryRateLimiter is a process-local RateLimiter.
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	arrivals  map[string]time.Time
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewMemoryRateLimiter constructs a limiter with every bucket full.
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{arrivals: make(map[string]time.Time), now: time.Now}
+}
+
+// Take implements RateLimiter.
+func (m *MemoryRateLimiter) Take(_ context.Context, key string, perMinute, burst int) (bool, time.Duration, error) {
+	if perMinute <= 0 {
+		return true, 0, nil
+	}
+	interval := emissionInterval(perMinute)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.prune(now)
+
+	tat := m.arrivals[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	// A request is allowed while the bucket is not yet burst tokens in
+	// debt.
+	if wait := tat.Add(interval - time.Duration(burst)*interval).Sub(now); wait > 0 {
+		return false, wait, nil
+	}
+	m.arrivals[key] = tat.Add(interval)
+	return true, 0, nil
+}
+
+// prune forgets buckets that have refilled completely.
+func (m *MemoryRateLimiter) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < pruneInterval {
+		return
+	}
+	m.lastPrune = now
+	for key, tat := range m.arrivals {
+		if tat.Before(now) {
+			delete(m.arrivals, key)
+		}
+	}
+}
+
+// RedisRateLimiter is a RateLimiter stored in Redis, so keys are paced
+// across every instance. Buckets are updated by a script on the Redis
+// clock, so instances need not agree on the time.
+type RedisRateLimiter struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisRateLimiter constructs a limiter that namespaces keys with
+// prefix.
+func NewRedisRateLimiter(client *redis.Client, prefix string) (*RedisRateLimiter, error) {
+	if client == nil {
+		return nil, errors.New("redis client must not be nil")
+	}
+	return &RedisRateLimiter{client: client, prefix: prefix}, nil
+}
+
+// takeScript returns 0 when a token was taken and otherwise the
+// microseconds until one is free. ARGV holds the emission interval and
+// burst, in microseconds and tokens.
+const takeScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then tat = now end
+local wait = tat + interval - tonumber(ARGV[2]) * interval - now
+if wait > 0 then return wait end
+tat = tat + interval
+redis.call('SET', KEYS[1], string.format('%.0f', tat), 'PX', math.ceil((tat - now) / 1000) + 1000)
+return 0
+`
+
+// Take implements RateLimiter.
+func (r *RedisRateLimiter) Take(ctx context.Context, key string, perMinute, burst int) (bool, time.Duration, error) {
+	if perMinute <= 0 {
+		return true, 0, nil
+	}
+	interval := emissionInterval(perMinute).Microseconds()
+	wait, err := r.client.Int(ctx, "EVAL", takeScript, 1, r.prefix+rateLimitPrefix+key, interval, burst)
+	if err != nil {
+		return false, 0, fmt.Errorf("take rate limit token %q: %w", key, err)
+	}
+	if wait > 0 {
+		return false, time.Duration(wait) * time.Microsecond, nil
+	}
+	return true, 0, nil
+}
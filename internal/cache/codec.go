@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"gocode-router/internal/models"
+)
+
+// codecVersion is bumped whenever the serialized layout changes so stale
+// entries written by older instances are ignored instead of misread.
+const codecVersion = 1
+
+type chatEntry struct {
+	Version  int
+	Model    models.Model
+	Response models.UnifiedChatResponse
+}
+
+type completionEntry struct {
+	Version  int
+	Model    models.Model
+	Response models.UnifiedCompletionResponse
+}
+
+// ChatKey derives a cache key from the parts of a chat request that affect
+// the answer. encoding/json sorts map keys, so option order is irrelevant.
+func ChatKey(req models.UnifiedChatRequest) (string, error) {
+	data, err := json.Marshal(struct {
+		Kind     string
+		Model    string
+		Messages []models.Message
+		Tools    []models.ToolDefinition
+		Options  map[string]any
+	}{"chat", req.Model, req.Messages, req.Tools, req.Options})
+	if err != nil {
+		return "", fmt.Errorf("derive cache key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// EncodeChat serializes a unified chat response together with the model
+// that served it.
+func EncodeChat(modelInfo models.Model, resp *models.UnifiedChatResponse) ([]byte, error) {
+	data, err := json.Marshal(chatEntry{Version: codecVersion, Model: modelInfo, Response: *resp})
+	if err != nil {
+		return nil, fmt.Errorf("encode cached chat response: %w", err)
+	}
+	return data, nil
+}
+
+// DecodeChat restores a response written by EncodeChat.
+func DecodeChat(data []byte) (*models.UnifiedChatResponse, models.Model, error) {
+	var entry chatEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, models.Model{}, fmt.Errorf("decode cached chat response: %w", err)
+	}
+	if entry.Version != codecVersion {
+		return nil, models.Model{}, fmt.Errorf("cached chat response has version %d, want %d", entry.Version, codecVersion)
+	}
+	return &entry.Response, entry.Model, nil
+}
+
+// CompletionKey derives a cache key from the parts of a legacy completion
+// request that affect the answer.
+func CompletionKey(req models.UnifiedCompletionRequest) (string, error) {
+	data, err := json.Marshal(struct {
+		Kind        string
+		Model       string
+		Prompt      string
+		MaxTokens   int
+		Temperature float64
+		Options     map[string]any
+	}{"completion", req.Model, req.Prompt, req.MaxTokens, req.Temperature, req.Options})
+	if err != nil {
+		return "", fmt.Errorf("derive cache key: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// EncodeCompletion serializes a unified completion response together with
+// the model that served it.
+func EncodeCompletion(modelInfo models.Model, resp *models.UnifiedCompletionResponse) ([]byte, error) {
+	data, err := json.Marshal(completionEntry{Version: codecVersion, Model: modelInfo, Response: *resp})
+	if err != nil {
+		return nil, fmt.Errorf("encode cached completion response: %w", err)
+	}
+	return data, nil
+}
+
+// DecodeCompletion restores a response written by EncodeCompletion.
+func DecodeCompletion(data []byte) (*models.UnifiedCompletionResponse, models.Model, error) {
+	var entry completionEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, models.Model{}, fmt.Errorf("decode cached completion response: %w", err)
+	}
+	if entry.Version != codecVersion {
+		return nil, models.Model{}, fmt.Errorf("cached completion response has version %d, want %d", entry.Version, codecVersion)
+	}
+	return &entry.Response, entry.Model, nil
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const memcachedTimeout = 5 * time.Second
+
+// MemcachedStore talks the memcached text protocol. Each operation uses a
+// short-lived connection, which keeps the implementation simple and is cheap
+// next to an upstream model call.
+type MemcachedStore struct {
+	addr string
+}
+
+// NewMemcachedStore constructs a store for the memcached server at addr.
+func NewMemcachedStore(addr string) (*MemcachedStore, error) {
+	if strings.TrimSpace(addr) == "" {
+		return nil, errors.New("memcached address must not be empty")
+	}
+	return &MemcachedStore{addr: addr}, nil
+}
+
+// Get implements Store.
+func (m *MemcachedStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "get %s\r\n", key); err != nil {
+		return nil, false, fmt.Errorf("memcached get: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, false, fmt.Errorf("memcached get: %w", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "END" {
+		return nil, false, nil
+	}
+
+	// VALUE <key> <flags> <bytes>
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[0] != "VALUE" {
+		return nil, false, fmt.Errorf("memcached get: unexpected reply %q", line)
+	}
+	size, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return nil, false, fmt.Errorf("memcached get: malformed length %q", fields[3])
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, false, fmt.Errorf("memcached get: %w", err)
+	}
+	return data[:size], true, nil
+}
+
+// Set implements Store.
+func (m *MemcachedStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	seconds := int(ttl / time.Second)
+	if seconds <= 0 {
+		seconds = 1
+	}
+	if _, err := fmt.Fprintf(conn, "set %s 0 %d %d\r\n%s\r\n", key, seconds, len(value), value); err != nil {
+		return fmt.Errorf("memcached set: %w", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("memcached set: %w", err)
+	}
+	if reply := strings.TrimRight(line, "\r\n"); reply != "STORED" {
+		return fmt.Errorf("memcached set: unexpected reply %q", reply)
+	}
+	return nil
+}
+
+func (m *MemcachedStore) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: memcachedTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return nil, fmt.Errorf("memcached dial %s: %w", m.addr, err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(memcachedTimeout)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("memcached set deadline: %w", err)
+	}
+	return conn, nil
+}
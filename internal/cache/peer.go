@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PeerPath is the route under which each instance serves its share of a
+// partitioned cache to the other instances.
+const PeerPath = "/internal/cache/"
+
+// Headers exchanged between peers.
+const (
+	PeerSecretHeader = "X-Cache-Peer-Secret"
+	PeerTTLHeader    = "X-Cache-TTL-Ms"
+)
+
+const maxPeerValueBytes = 8 << 20
+
+// PeerStore partitions a cache across router instances. Each key belongs to
+// exactly one instance on a consistent-hash ring; keys owned locally are
+// served from the local store and the rest are fetched from their owner, so
+// adding replicas grows the cache instead of splitting its hits.
+type PeerStore struct {
+	self   string
+	local  Store
+	ring   *Ring
+	secret string
+	client *http.Client
+}
+
+// NewPeerStore builds a store for the instance reachable at self. The peer
+// list must include self.
+func NewPeerStore(local Store, self string, peers []string, virtualNodes int, secret string, client *http.Client) (*PeerStore, error) {
+	if local == nil {
+		return nil, errors.New("local cache store must not be nil")
+	}
+	if client == nil {
+		return nil, errors.New("http client must not be nil")
+	}
+
+	self = strings.TrimRight(self, "/")
+	nodes := make([]string, 0, len(peers))
+	found := false
+	for _, peer := range peers {
+		peer = strings.TrimRight(peer, "/")
+		if peer == self {
+			found = true
+		}
+		nodes = append(nodes, peer)
+	}
+	if !found {
+		return nil, fmt.Errorf("cache peers must include this instance %q", self)
+	}
+
+	return &PeerStore{
+		self:   self,
+		local:  local,
+		ring:   NewRing(virtualNodes, nodes...),
+		secret: secret,
+		client: client,
+	}, nil
+}
+
+// Get implements Store.
+func (p *PeerStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	owner := p.ring.Owner(key)
+	if owner == p.self {
+		return p.local.Get(ctx, key)
+	}
+
+	req, err := p.newRequest(ctx, http.MethodGet, owner, key, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("peer cache get from %s: %w", owner, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		value, err := io.ReadAll(io.LimitReader(resp.Body, maxPeerValueBytes))
+		if err != nil {
+			return nil, false, fmt.Errorf("peer cache get from %s: %w", owner, err)
+		}
+		return value, true, nil
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("peer cache get from %s: status %d", owner, resp.StatusCode)
+	}
+}
+
+// Set implements Store.
+func (p *PeerStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	owner := p.ring.Owner(key)
+	if owner == p.self {
+		return p.local.Set(ctx, key, value, ttl)
+	}
+
+	req, err := p.newRequest(ctx, http.MethodPut, owner, key, value)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(PeerTTLHeader, strconv.FormatInt(ttl.Milliseconds(), 10))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("peer cache set on %s: %w", owner, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer cache set on %s: status %d", owner, resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *PeerStore) newRequest(ctx context.Context, method, owner, key string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, owner+PeerPath+url.PathEscape(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("construct peer cache request: %w", err)
+	}
+	if p.secret != "" {
+		req.Header.Set(PeerSecretHeader, p.secret)
+	}
+	return req, nil
+}
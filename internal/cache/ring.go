@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+const defaultVirtualNodes = 64
+
+// Ring assigns keys to nodes by consistent hashing, so adding or removing a
+// node only moves the keys that node owned.
+type Ring struct {
+	hashes []uint32
+	owners map[uint32]string
+}
+
+// NewRing places each node on the ring virtualNodes times to even out the
+// share of keys each one owns.
+func NewRing(virtualNodes int, nodes ...string) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+	r := &Ring{owners: make(map[uint32]string, len(nodes)*virtualNodes)}
+	for _, node := range nodes {
+		for i := 0; i < virtualNodes; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + node))
+			if _, taken := r.owners[h]; taken {
+				continue
+			}
+			r.owners[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Owner returns the node responsible for key, or "" for an empty ring.
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
@@ -0,0 +1,151 @@
+// Package cache stores serialized responses so identical requests can be
+// answered without calling an upstream provider.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/redis"
+)
+
+const defaultMaxEntries = 1024
+
+// Store is a byte-oriented cache backend.
+type Store interface {
+	// Get returns the value stored under key and whether it was found.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key for ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// NewStore builds the backend selected by the cache configuration.
+func NewStore(cfg config.CacheConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", config.CacheBackendMemory:
+		return NewMemoryStore(cfg.MaxEntries), nil
+	case config.CacheBackendRedis:
+		client, err := redis.New(redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("initialise redis cache: %w", err)
+		}
+		return NewRedisStore(client, cfg.Redis.Prefix()+"cache:")
+	case config.CacheBackendMemcached:
+		return NewMemcachedStore(cfg.Memcached.Addr)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+	}
+}
+
+type memoryItem struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// MemoryStore is a process-local LRU cache.
+type MemoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	items      map[string]*list.Element
+	now        func() time.Time
+}
+
+// NewMemoryStore constructs an LRU store holding at most maxEntries values.
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// Get implements Store.
+func (m *MemoryStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	item := elem.Value.(*memoryItem)
+	if m.now().After(item.expires) {
+		m.order.Remove(elem)
+		delete(m.items, key)
+		return nil, false, nil
+	}
+	m.order.MoveToFront(elem)
+	return item.value, true, nil
+}
+
+// Set implements Store.
+func (m *MemoryStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expires := m.now().Add(ttl)
+	if elem, ok := m.items[key]; ok {
+		item := elem.Value.(*memoryItem)
+		item.value = value
+		item.expires = expires
+		m.order.MoveToFront(elem)
+		return nil
+	}
+
+	m.items[key] = m.order.PushFront(&memoryItem{key: key, value: value, expires: expires})
+	for m.order.Len() > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryItem).key)
+	}
+	return nil
+}
+
+// RedisStore keeps cached values in Redis so replicas share hits.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore constructs a store that namespaces keys with prefix.
+func NewRedisStore(client *redis.Client, prefix string) (*RedisStore, error) {
+	if client == nil {
+		return nil, errors.New("redis client must not be nil")
+	}
+	return &RedisStore{client: client, prefix: prefix}, nil
+}
+
+// Get implements Store.
+func (r *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.String(ctx, "GET", r.prefix+key)
+	if errors.Is(err, redis.ErrNil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("redis cache get: %w", err)
+	}
+	return []byte(value), true, nil
+}
+
+// Set implements Store.
+func (r *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if _, err := r.client.Do(ctx, "SET", r.prefix+key, value, "PX", ttl.Milliseconds()); err != nil {
+		return fmt.Errorf("redis cache set: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package capture records sampled request/response pairs as JSONL, to build
+// evaluation datasets for comparing candidate models offline.
+package capture
+
+import (
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/telemetry"
+)
+
+// OptOutHeader excludes a request from capture whatever the configuration.
+const OptOutHeader = "X-Router-No-Capture"
+
+const defaultReplacement = "[REDACTED]"
+
+// Record is one captured exchange: a line of the capture file.
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Endpoint  string    `json:"endpoint"`
+	KeyID     string    `json:"key_id,omitempty"`
+	// Model is the model the client asked for; UpstreamModel is the one
+	// that answered, after routing.
+	Model         string `json:"model"`
+	Provider      string `json:"provider"`
+	UpstreamModel string `json:"upstream_model"`
+	// MirrorOf is set on the exchanges of mirrored calls to the model the
+	// client asked for; Model is then the mirror.
+	MirrorOf     string         `json:"mirror_of,omitempty"`
+	Messages     []Message      `json:"messages,omitempty"`
+	Prompt       string         `json:"prompt,omitempty"`
+	Options      map[string]any `json:"options,omitempty"`
+	Metadata     any            `json:"metadata,omitempty"`
+	Response     string         `json:"response"`
+	FinishReason string         `json:"finish_reason,omitempty"`
+	Usage        Usage          `json:"usage"`
+}
+
+// Message is a captured chat message.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Name    string `json:"name,omitempty"`
+}
+
+// Usage is the token usage of a captured exchange.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Messages converts chat messages for a record.
+func Messages(messages []models.Message) []Message {
+	out := make([]Message, len(messages))
+	for i, m := range messages {
+		out[i] = Message{Role: m.Role, Content: m.Content, Name: m.Name}
+	}
+	return out
+}
+
+// UsageOf converts token usage for a record.
+func UsageOf(u models.Usage) Usage {
+	return Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens, TotalTokens: u.TotalTokens}
+}
+
+// Policy decides which exchanges are captured and strips them of what must
+// not be kept.
+type Policy struct {
+	cfg    config.CaptureConfig
+	redact *Redactor
+}
+
+// NewPolicy compiles the capture configuration, which must be valid.
+func NewPolicy(cfg config.CaptureConfig) *Policy {
+	return &Policy{cfg: cfg, redact: NewRedactor(cfg.Redact)}
+}
+
+// Redactor replaces the text matching redaction rules.
+type Redactor struct {
+	rules []redaction
+}
+
+type redaction struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// NewRedactor compiles redaction rules, which must be valid.
+func NewRedactor(rules []config.RedactRule) *Redactor {
+	r := &Redactor{}
+	for _, rule := range rules {
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = defaultReplacement
+		}
+		r.rules = append(r.rules, redaction{pattern: regexp.MustCompile(rule.Pattern), replacement: replacement})
+	}
+	return r
+}
+
+// Empty reports whether there is nothing to redact.
+func (r *Redactor) Empty() bool {
+	return len(r.rules) == 0
+}
+
+// Redact returns text with every match of each rule replaced.
+func (r *Redactor) Redact(text string) string {
+	for _, rule := range r.rules {
+		text = rule.pattern.ReplaceAllString(text, rule.replacement)
+	}
+	return text
+}
+
+// Wants reports whether an exchange is captured: capture is enabled, the
+// key consented, the model matches and the request is sampled. Sampling
+// hashes the request ID, so a request is captured or not consistently.
+func (p *Policy) Wants(requestID, keyID, model string) bool {
+	if p == nil || !p.cfg.Enabled {
+		return false
+	}
+	if len(p.cfg.Keys) > 0 && !slices.Contains(p.cfg.Keys, keyID) {
+		return false
+	}
+	if len(p.cfg.Models) > 0 && !slices.ContainsFunc(p.cfg.Models, func(pattern string) bool {
+		ok, _ := path.Match(pattern, model)
+		return ok
+	}) {
+		return false
+	}
+	return telemetry.Sampled(requestID, p.cfg.Rate())
+}
+
+// Apply drops the omitted fields from rec and redacts its text.
+func (p *Policy) Apply(rec *Record) {
+	for _, field := range p.cfg.Omit {
+		switch field {
+		case config.CaptureFieldSystem:
+			rec.Messages = slices.DeleteFunc(rec.Messages, func(m Message) bool {
+				return strings.EqualFold(m.Role, "system")
+			})
+		case config.CaptureFieldOptions:
+			rec.Options = nil
+		case config.CaptureFieldMetadata:
+			rec.Metadata = nil
+		case config.CaptureFieldKey:
+			rec.KeyID = ""
+		}
+	}
+	if p.redact.Empty() {
+		return
+	}
+	for i := range rec.Messages {
+		rec.Messages[i].Content = p.redact.Redact(rec.Messages[i].Content)
+	}
+	rec.Prompt = p.redact.Redact(rec.Prompt)
+	rec.Response = p.redact.Redact(rec.Response)
+}
+
+// SplitOptions separates the request metadata from the other options.
+func SplitOptions(options map[string]any) (map[string]any, any) {
+	if len(options) == 0 {
+		return nil, nil
+	}
+	rest := maps.Clone(options)
+	metadata := rest["metadata"]
+	delete(rest, "metadata")
+	if len(rest) == 0 {
+		rest = nil
+	}
+	return rest, metadata
+}
@@ -0,0 +1,110 @@
+package capture
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorder appends records to a JSONL file, rotating it when it outgrows
+// its size limit. Rotated files are renamed with a timestamp beside the
+// original and never written again, so they can be shipped to object
+// storage as they appear.
+type Recorder struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	file     *os.File
+	size     int64
+}
+
+// Open opens or creates the JSONL file at path.
+func Open(path string, maxBytes int64) (*Recorder, error) {
+	if path == "" {
+		return nil, errors.New("record file path must not be empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create record directory: %w", err)
+	}
+	r := &Recorder{path: path, maxBytes: maxBytes}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) open() error {
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open record file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("open record file: %w", err)
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+// Write appends a record, such as a Record, as a line of JSON.
+func (r *Recorder) Write(rec any) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+	data = append(data, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return errors.New("recorder is closed")
+	}
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(data)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+	return nil
+}
+
+// rotate renames the current file to <name>-<timestamp><ext> and starts a
+// new one.
+func (r *Recorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("close record file: %w", err)
+	}
+	r.file = nil
+	ext := filepath.Ext(r.path)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(r.path, ext), time.Now().UTC().Format("20060102T150405.000000000"), ext)
+	renameErr := os.Rename(r.path, rotated)
+	if err := r.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("rotate record file: %w", renameErr)
+	}
+	return nil
+}
+
+// Close closes the file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -0,0 +1,100 @@
+// Package chaos decides which artificial faults to inject into a request,
+// for testing how clients cope with slow, failing and broken upstreams.
+package chaos
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+// Faults are the faults chosen for one request.
+type Faults struct {
+	// Delay is added before the upstream call.
+	Delay time.Duration
+	// Status, when set, fails the request with it instead of calling the
+	// upstream.
+	Status int
+	// TruncateAt cuts a streamed response off half way through its n-th
+	// event, counting from 1; zero leaves it whole.
+	TruncateAt int
+	// MalformAt corrupts the JSON of the n-th event; zero corrupts none.
+	MalformAt int
+}
+
+// Error is an injected failure.
+type Error struct {
+	Status int
+	Model  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("chaos: injected %d for model %s", e.Status, e.Model)
+}
+
+// Injector chooses faults under the configured rules. The admin API can
+// override whether chaos is on; the override outlives config reloads.
+type Injector struct {
+	mu       sync.RWMutex
+	override *bool
+}
+
+// NewInjector returns an injector that follows the configuration.
+func NewInjector() *Injector {
+	return &Injector{}
+}
+
+// Override switches chaos on or off regardless of the configuration; nil
+// returns control to the configuration.
+func (i *Injector) Override(enabled *bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.override = enabled
+}
+
+// Overridden returns the admin override, if any.
+func (i *Injector) Overridden() *bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.override
+}
+
+// Enabled reports whether faults are injected.
+func (i *Injector) Enabled(cfg config.ChaosConfig) bool {
+	if override := i.Overridden(); override != nil {
+		return *override
+	}
+	return cfg.Enabled
+}
+
+// Faults rolls the faults for a request to model.
+func (i *Injector) Faults(cfg config.ChaosConfig, model string) Faults {
+	if !i.Enabled(cfg) {
+		return Faults{}
+	}
+	for _, rule := range cfg.Rules {
+		if !rule.Matches(model) {
+			continue
+		}
+		var f Faults
+		f.Delay = rule.Latency
+		if rule.Jitter > 0 {
+			f.Delay += rand.N(rule.Jitter)
+		}
+		if rand.Float64() < rule.ErrorRate {
+			f.Status = rule.Status()
+		}
+		// Streams replayed by the router have at least three events.
+		if rand.Float64() < rule.TruncateRate {
+			f.TruncateAt = 1 + rand.IntN(3)
+		}
+		if rand.Float64() < rule.MalformRate {
+			f.MalformAt = 1 + rand.IntN(3)
+		}
+		return f
+	}
+	return Faults{}
+}
@@ -0,0 +1,162 @@
+package completions
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileStore appends completions to a JSONL file and indexes them in memory.
+// The file is replayed on open; a partially written final line is skipped.
+// Expired completions are dropped on open and by a periodic sweep, each of
+// which rewrites the file without them.
+type FileStore struct {
+	mu          sync.Mutex
+	path        string
+	retention   time.Duration
+	file        *os.File
+	completions map[string]Completion
+	saves       int
+}
+
+// OpenFileStore opens or creates the completions file at path, keeping
+// completions for retention.
+func OpenFileStore(path string, retention time.Duration) (*FileStore, error) {
+	if path == "" {
+		return nil, errors.New("completions path must not be empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create completions directory: %w", err)
+	}
+
+	s := &FileStore{path: path, retention: retention, completions: make(map[string]Completion)}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	sweep(s.completions, retention, time.Now())
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save implements Store.
+func (s *FileStore) Save(_ context.Context, completion Completion) error {
+	data, err := json.Marshal(completion)
+	if err != nil {
+		return fmt.Errorf("marshal completion: %w", err)
+	}
+	data = append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errors.New("completions store is closed")
+	}
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("write completion: %w", err)
+	}
+	s.completions[completion.ID] = completion
+	if s.saves++; s.saves%SweepEvery == 0 && sweep(s.completions, s.retention, time.Now()) > 0 {
+		return s.compact()
+	}
+	return nil
+}
+
+// Get implements Store.
+func (s *FileStore) Get(_ context.Context, id string) (Completion, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	completion, ok := s.completions[id]
+	if !ok || Expired(completion.CreatedAt, s.retention, time.Now()) {
+		return Completion{}, false, nil
+	}
+	return completion, true, nil
+}
+
+// Close implements Store.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileStore) replay() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open completions file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var completion Completion
+		if err := json.Unmarshal(scanner.Bytes(), &completion); err != nil {
+			slog.Warn("skipping unreadable stored completion", "path", s.path, "error", err)
+			continue
+		}
+		s.completions[completion.ID] = completion
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read completions file: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the file with the live completions and reopens it for
+// appending. The new file is written beside the old one and renamed over
+// it, so a crash mid-compaction leaves the previous file in place.
+func (s *FileStore) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("create completions file: %w", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, completion := range s.completions {
+		data, err := json.Marshal(completion)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("marshal completion: %w", err)
+		}
+		writer.Write(data)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write completions file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close completions file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace completions file: %w", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open completions file: %w", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	return nil
+}
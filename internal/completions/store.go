@@ -0,0 +1,96 @@
+// Package completions keeps chat completions that clients asked to store
+// with store=true, so they can be retrieved later by ID.
+package completions
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Completion is a stored chat completion.
+type Completion struct {
+	ID        string          `json:"id"`
+	KeyID     string          `json:"key_id,omitempty"`
+	Model     string          `json:"model"`
+	Metadata  map[string]any  `json:"metadata,omitempty"`
+	Response  json.RawMessage `json:"response"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// SweepEvery is how many saves pass between deletions of expired
+// completions.
+const SweepEvery = 1000
+
+// Store persists completions. Completions are immutable once saved and
+// expire once they are older than the store's retention period.
+type Store interface {
+	// Save records a completion, replacing any with the same ID.
+	Save(ctx context.Context, completion Completion) error
+	// Get returns the completion with the given ID and whether it exists
+	// and has not expired.
+	Get(ctx context.Context, id string) (Completion, bool, error)
+	// Close releases resources held by the store.
+	Close() error
+}
+
+// Expired reports whether a completion created at createdAt is past
+// retention at now.
+func Expired(createdAt time.Time, retention time.Duration, now time.Time) bool {
+	return !createdAt.After(now.Add(-retention))
+}
+
+// MemoryStore keeps completions in process memory. They are lost on
+// restart.
+type MemoryStore struct {
+	mu          sync.Mutex
+	retention   time.Duration
+	completions map[string]Completion
+	saves       int
+}
+
+// NewMemoryStore constructs an empty in-memory store keeping completions
+// for retention.
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{retention: retention, completions: make(map[string]Completion)}
+}
+
+// Save implements Store.
+func (m *MemoryStore) Save(_ context.Context, completion Completion) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.completions[completion.ID] = completion
+	if m.saves++; m.saves%SweepEvery == 0 {
+		sweep(m.completions, m.retention, time.Now())
+	}
+	return nil
+}
+
+// Get implements Store.
+func (m *MemoryStore) Get(_ context.Context, id string) (Completion, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	completion, ok := m.completions[id]
+	if !ok || Expired(completion.CreatedAt, m.retention, time.Now()) {
+		return Completion{}, false, nil
+	}
+	return completion, true, nil
+}
+
+// Close implements Store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// sweep deletes the expired completions and reports how many it deleted.
+func sweep(completions map[string]Completion, retention time.Duration, now time.Time) int {
+	var n int
+	for id, completion := range completions {
+		if Expired(completion.CreatedAt, retention, now) {
+			delete(completions, id)
+			n++
+		}
+	}
+	return n
+}
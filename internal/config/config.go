@@ -1,12 +1,26 @@
 package config
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	// Schedules name IANA time zones, which minimal images lack.
+	_ "time/tzdata"
 
 	"gopkg.in/yaml.v3"
+
+	"gocode-router/internal/expr"
 )
 
 const (
@@ -16,122 +30,3235 @@ const (
 
 // Config represents the application configuration parsed from YAML.
 type Config struct {
-	Server    ServerConfig    `yaml:"server"`
-	Providers ProvidersConfig `yaml:"providers"`
+	Server        ServerConfig               `yaml:"server"`
+	Providers     ProvidersConfig            `yaml:"providers"`
+	MCP           MCPConfig                  `yaml:"mcp"`
+	Copilot       CopilotConfig              `yaml:"copilot"`
+	Budgets       BudgetsConfig              `yaml:"budgets"`
+	Limits        LimitsConfig               `yaml:"limits"`
+	Provenance    ProvenanceConfig           `yaml:"provenance"`
+	Routing       RoutingConfig              `yaml:"routing"`
+	State         StateConfig                `yaml:"state"`
+	Cache         CacheConfig                `yaml:"cache"`
+	Jobs          JobsConfig                 `yaml:"jobs"`
+	Usage         UsageConfig                `yaml:"usage"`
+	Storage       StorageConfig              `yaml:"storage"`
+	Observability ObservabilityConfig        `yaml:"observability"`
+	Keys          map[string]ClientKeyConfig `yaml:"keys"`
+	Tags          TagsConfig                 `yaml:"tags"`
+	Alerts        AlertsConfig               `yaml:"alerts"`
+	Admin         AdminConfig                `yaml:"admin"`
+	Auth          AuthConfig                 `yaml:"auth"`
+	Tokenizers    []TokenizerConfig          `yaml:"tokenizers"`
+	Health        HealthConfig               `yaml:"health"`
+	Chaos         ChaosConfig                `yaml:"chaos"`
+	Capture       CaptureConfig              `yaml:"capture"`
+	Audit         AuditConfig                `yaml:"audit"`
+}
+
+// CaptureConfig records sampled request/response pairs to a JSONL file, to
+// build evaluation datasets for comparing models offline.
+type CaptureConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Path is the JSONL file; it is opened at startup.
+	Path string `yaml:"path"`
+	// MaxBytes rotates the file once it grows past this size, renaming it
+	// with a timestamp so it can be shipped elsewhere. Zero never rotates.
+	MaxBytes int64 `yaml:"max_bytes"`
+	// SampleRate is the fraction of eligible requests captured; zero
+	// captures all of them.
+	SampleRate float64 `yaml:"sample_rate"`
+	// Models are path.Match patterns on the upstream model ID; empty
+	// captures every model.
+	Models []string `yaml:"models"`
+	// Keys are the client key IDs that consented to capture; empty
+	// captures every key.
+	Keys []string `yaml:"keys"`
+	// Redact replaces matches of each regular expression in captured text.
+	Redact []RedactRule `yaml:"redact"`
+	// Omit drops fields from records: "system" (system messages),
+	// "options", "metadata" and "key".
+	Omit []string `yaml:"omit"`
+}
+
+// AuditConfig records every API request, failed ones included, with its
+// outcome, latency, tokens, serving model and client key, for compliance
+// review.
+type AuditConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Sink is where records go: file (the default), stdout or webhook.
+	Sink string `yaml:"sink"`
+	// Path is the JSONL file of the file sink; it is opened at startup.
+	// MaxBytes rotates it as capture.max_bytes does.
+	Path     string `yaml:"path"`
+	MaxBytes int64  `yaml:"max_bytes"`
+	// URL receives batches of records as JSONL from the webhook sink, with
+	// Headers added to each request.
+	URL     string  `yaml:"url"`
+	Headers Headers `yaml:"headers"`
+	// Redact replaces matches of each regular expression in prompts and
+	// responses.
+	Redact []RedactRule `yaml:"redact"`
+	// OmitContent drops prompts and responses, keeping the metadata.
+	OmitContent bool `yaml:"omit_content"`
+}
+
+// Audit sinks.
+const (
+	AuditSinkFile    = "file"
+	AuditSinkStdout  = "stdout"
+	AuditSinkWebhook = "webhook"
+)
+
+// SinkName returns the sink records go to.
+func (a AuditConfig) SinkName() string {
+	if a.Sink == "" {
+		return AuditSinkFile
+	}
+	return a.Sink
+}
+
+// RedactRule replaces text matching Pattern with Replacement, which
+// defaults to "[REDACTED]".
+type RedactRule struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// Fields that capture.omit can drop.
+const (
+	CaptureFieldSystem   = "system"
+	CaptureFieldOptions  = "options"
+	CaptureFieldMetadata = "metadata"
+	CaptureFieldKey      = "key"
+)
+
+// Rate returns the fraction of eligible requests captured.
+func (c CaptureConfig) Rate() float64 {
+	if c.SampleRate <= 0 {
+		return 1
+	}
+	return c.SampleRate
+}
+
+// ChaosConfig injects faults into responses so clients can test their
+// retry and streaming handling. It is for test environments only. The
+// admin API can switch it on and off at runtime.
+type ChaosConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Rules are tried in order; the first whose models match applies.
+	Rules []ChaosRule `yaml:"rules"`
+}
+
+// ChaosRule describes the faults injected for some models. Each rate is the
+// probability, from 0 to 1, that a request gets that fault.
+type ChaosRule struct {
+	// Models are path.Match patterns on the upstream model ID; empty
+	// matches every model.
+	Models []string `yaml:"models"`
+	// Latency is added before the upstream call, plus up to Jitter more.
+	Latency time.Duration `yaml:"latency"`
+	Jitter  time.Duration `yaml:"jitter"`
+	// ErrorRate fails requests with ErrorStatus (default 503) instead of
+	// calling the upstream.
+	ErrorRate   float64 `yaml:"error_rate"`
+	ErrorStatus int     `yaml:"error_status"`
+	// TruncateRate cuts streamed responses off part way through an event.
+	TruncateRate float64 `yaml:"truncate_rate"`
+	// MalformRate corrupts the JSON of one event of streamed responses.
+	MalformRate float64 `yaml:"malform_rate"`
+}
+
+// Matches reports whether the rule applies to a model.
+func (r ChaosRule) Matches(model string) bool {
+	if len(r.Models) == 0 {
+		return true
+	}
+	for _, pattern := range r.Models {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the status of injected errors.
+func (r ChaosRule) Status() int {
+	if r.ErrorStatus == 0 {
+		return 503
+	}
+	return r.ErrorStatus
+}
+
+// HealthConfig controls how upstream health is tracked.
+type HealthConfig struct {
+	Probe ProbeConfig `yaml:"probe"`
+}
+
+// ProbeConfig enables background probes of every provider. A provider that
+// fails Failures probes in a row is considered down, and requests for its
+// models fail fast until a probe succeeds again.
+type ProbeConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval is the time between probes; zero means 15s.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout bounds one probe; zero means 5s.
+	Timeout time.Duration `yaml:"timeout"`
+	// Failures is how many failed probes in a row mark a provider down;
+	// zero means 2.
+	Failures int `yaml:"failures"`
+}
+
+// Every returns the time between probes.
+func (p ProbeConfig) Every() time.Duration {
+	if p.Interval <= 0 {
+		return 15 * time.Second
+	}
+	return p.Interval
+}
+
+// ProbeTimeout returns the bound on one probe.
+func (p ProbeConfig) ProbeTimeout() time.Duration {
+	if p.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return p.Timeout
+}
+
+// FailureThreshold returns how many failed probes in a row mark a provider
+// down.
+func (p ProbeConfig) FailureThreshold() int {
+	if p.Failures <= 0 {
+		return 2
+	}
+	return p.Failures
+}
+
+// TokenizerConfig assigns a local BPE tokenizer, read from a tiktoken rank
+// file, to the models whose IDs match one of Models (path.Match patterns
+// such as "gpt-4o*"). The first matching entry wins; other models use the
+// built-in approximate tokenizer.
+type TokenizerConfig struct {
+	Name   string   `yaml:"name"`
+	File   string   `yaml:"file"`
+	Models []string `yaml:"models"`
+}
+
+// AdminConfig protects the /admin API. Leaving Token empty disables it.
+type AdminConfig struct {
+	Token string `yaml:"token"`
+}
+
+// AuthConfig lists the client keys allowed to call the /v1 API. With no
+// keys and no key file, the API is open.
+type AuthConfig struct {
+	Keys []string `yaml:"keys"`
+	// KeyFile holds further keys, one per line. Blank lines and lines
+	// starting with # are skipped.
+	KeyFile string `yaml:"key_file"`
+	// Required enforces client keys even when none are configured, for
+	// deployments whose keys are all issued through the admin API.
+	Required bool `yaml:"required"`
+}
+
+// Enabled reports whether client keys are enforced.
+func (a AuthConfig) Enabled() bool {
+	return a.Required || len(a.Keys) > 0 || a.KeyFile != ""
 }
 
 // ServerConfig defines listener configuration.
 type ServerConfig struct {
-	Port int `yaml:"port"`
+	Port      int             `yaml:"port"`
+	Streaming StreamingConfig `yaml:"streaming"`
+	// DisabledRoutes turns routes off. Entries are route patterns as
+	// registered, such as /v1/engines/:engine/completions, or path.Match
+	// globs against the request path, such as /admin/*.
+	DisabledRoutes []string `yaml:"disabled_routes"`
+	// TLS serves HTTPS on Port instead of plain HTTP.
+	TLS TLSConfig `yaml:"tls"`
+	// CORS lets browser-based clients on other origins call the router.
+	CORS CORSConfig `yaml:"cors"`
+	// MaxBodyBytes caps request bodies; zero means 1 MiB.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// BodyLimits override MaxBodyBytes for some routes; the first that
+	// matches applies.
+	BodyLimits []BodyLimitConfig `yaml:"body_limits"`
+}
+
+// BodyLimitConfig caps the request bodies of Route, a route pattern as
+// registered or a path.Match glob against the request path, as in
+// DisabledRoutes.
+type BodyLimitConfig struct {
+	Route    string `yaml:"route"`
+	MaxBytes int64  `yaml:"max_bytes"`
+}
+
+// BodyLimit returns the request body cap for a request to path, served
+// by the route registered as route.
+func (s ServerConfig) BodyLimit(route, requestPath string) int64 {
+	for _, limit := range s.BodyLimits {
+		if limit.Route == route {
+			return limit.MaxBytes
+		}
+		if ok, _ := path.Match(limit.Route, requestPath); ok {
+			return limit.MaxBytes
+		}
+	}
+	if s.MaxBodyBytes <= 0 {
+		return 1 << 20
+	}
+	return s.MaxBodyBytes
+}
+
+// CORSConfig answers cross-origin requests from AllowedOrigins, which may
+// hold "*" or wildcard patterns such as https://*.example.com. CORS is off
+// while AllowedOrigins is empty. CORS settings are read at startup.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowedMethods defaults to the methods the requested route serves.
+	AllowedMethods []string `yaml:"allowed_methods"`
+	// AllowedHeaders defaults to the headers a preflight request asks for.
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// AllowCredentials lets browsers send cookies and Authorization
+	// headers they manage; it can't be combined with the "*" origin.
+	AllowCredentials bool `yaml:"allow_credentials"`
+	// MaxAge is how long browsers may cache a preflight answer; zero
+	// leaves it to the browser.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Enabled reports whether cross-origin requests are answered.
+func (c CORSConfig) Enabled() bool {
+	return len(c.AllowedOrigins) > 0
 }
 
-// ProvidersConfig catalogues configured upstream providers.
+// TLSConfig serves HTTPS with a certificate read from CertFile and KeyFile,
+// or obtained from an ACME authority such as Let's Encrypt. TLS settings
+// are read at startup, but certificate files are read again when they
+// change, so renewed certificates need no restart.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile enables mutual TLS: clients must present a certificate
+	// signed by one of the PEM certificates in the file.
+	ClientCAFile string     `yaml:"client_ca_file"`
+	ACME         ACMEConfig `yaml:"acme"`
+}
+
+// Enabled reports whether the server terminates TLS.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || len(t.ACME.Domains) > 0
+}
+
+// ACMEConfig obtains and renews certificates for Domains automatically.
+// Challenges are answered on the TLS port itself (TLS-ALPN-01), so the
+// port must be reachable as 443, and over HTTP-01 on HTTPPort when set.
+type ACMEConfig struct {
+	Domains []string `yaml:"domains"`
+	// Email is the contact address registered with the authority.
+	Email string `yaml:"email"`
+	// CacheDir keeps the account key and certificates across restarts;
+	// empty means acme-cache.
+	CacheDir string `yaml:"cache_dir"`
+	// DirectoryURL selects the ACME authority; empty means Let's Encrypt.
+	DirectoryURL string `yaml:"directory_url"`
+	// HTTPPort, when set, serves HTTP-01 challenges and redirects other
+	// plain HTTP requests to HTTPS.
+	HTTPPort int `yaml:"http_port"`
+}
+
+// Cache returns the directory certificates are cached in.
+func (a ACMEConfig) Cache() string {
+	if a.CacheDir == "" {
+		return "acme-cache"
+	}
+	return a.CacheDir
+}
+
+// Streaming flush policies.
+const (
+	StreamFlushEvent    = "event"
+	StreamFlushCoalesce = "coalesce"
+)
+
+// StreamingConfig controls when streamed responses are flushed to the
+// client. Flushing every event gives the lowest latency; coalescing saves
+// syscalls and packets at high concurrency.
+type StreamingConfig struct {
+	// Disabled rejects every request for a streamed response.
+	Disabled bool `yaml:"disabled"`
+	// Flush is "event" (the default) or "coalesce".
+	Flush string `yaml:"flush"`
+	// FlushInterval is the longest a coalesced event waits; zero means 20ms.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// FlushBytes flushes coalesced events once this much is pending; zero
+	// means 4096.
+	FlushBytes int `yaml:"flush_bytes"`
+}
+
+// Coalesce reports whether events are coalesced.
+func (s StreamingConfig) Coalesce() bool {
+	return s.Flush == StreamFlushCoalesce
+}
+
+// Interval returns the coalescing interval.
+func (s StreamingConfig) Interval() time.Duration {
+	if s.FlushInterval <= 0 {
+		return 20 * time.Millisecond
+	}
+	return s.FlushInterval
+}
+
+// Bytes returns the coalescing size threshold.
+func (s StreamingConfig) Bytes() int {
+	if s.FlushBytes <= 0 {
+		return 4096
+	}
+	return s.FlushBytes
+}
+
+// Provider types, which select the client used to reach an upstream.
+const (
+	ProviderTypeOpenAI = "openai"
+	ProviderTypeClaude = "claude"
+	ProviderTypeNVIDIA = "nvidia"
+	ProviderTypeAzure  = "azure"
+	ProviderTypeVertex = "vertex"
+	ProviderTypeGroq   = "groq"
+)
+
+// GroqBaseURL is the OpenAI-compatible API root of Groq providers that
+// set no base_url.
+const GroqBaseURL = "https://api.groq.com/openai/v1"
+
+// ProvidersConfig catalogues configured upstream providers. Any key other
+// than fixture names a provider, so several endpoints of one type, such as
+// two OpenAI-compatible services, can sit side by side.
 type ProvidersConfig struct {
-	OpenAI ProviderConfig  `yaml:"openai"`
-	Claude ProviderConfig  `yaml:"claude"`
-	NVIDIA *ProviderConfig `yaml:"nvidia"`
+	Upstreams map[string]ProviderConfig `yaml:",inline"`
+	// Fixture serves canned responses from disk instead of an upstream.
+	Fixture *FixtureProviderConfig `yaml:"fixture"`
 }
 
-// ProviderConfig captures authentication and routing info for a provider.
-type ProviderConfig struct {
-	APIKey  string            `yaml:"api_key"`
-	BaseURL string            `yaml:"base_url"`
+// FixtureProviderConfig configures the fixture provider, which answers from
+// files so clients can be tested end to end without network access. A
+// request is answered from <dir>/<model>/<hash>.txt, where hash identifies
+// its prompt, and otherwise from the default.tmpl template of the model's
+// directory or of dir itself.
+type FixtureProviderConfig struct {
+	Dir     string            `yaml:"dir"`
 	Models  []ModelConfig     `yaml:"models"`
-	Headers Headers           `yaml:"headers"`
 	Aliases map[string]string `yaml:"aliases"`
 }
 
-// Headers contains additional HTTP headers to send with a provider request.
-type Headers map[string]string
+// Named returns the configured providers keyed by provider name. Providers
+// named openai, claude or nvidia default to the type of the same name.
+// Vertex AI providers default to Google auth, and Groq providers to
+// Groq's API.
+func (p ProvidersConfig) Named() map[string]ProviderConfig {
+	providers := make(map[string]ProviderConfig, len(p.Upstreams))
+	for name, provider := range p.Upstreams {
+		if provider.Type == "" && isProviderType(name) {
+			provider.Type = name
+		}
+		if provider.Type == ProviderTypeVertex && provider.Auth.Type == "" {
+			provider.Auth.Type = AuthTypeGoogle
+		}
+		if provider.Type == ProviderTypeGroq && provider.BaseURL == "" {
+			provider.BaseURL = GroqBaseURL
+		}
+		providers[name] = provider
+	}
+	return providers
+}
 
-// ModelConfig describes a model exposed by a provider.
-type ModelConfig struct {
-	ID       string `yaml:"id"`
-	APIStyle string `yaml:"api_style"`
+func isProviderType(providerType string) bool {
+	switch providerType {
+	case ProviderTypeOpenAI, ProviderTypeClaude, ProviderTypeNVIDIA, ProviderTypeAzure, ProviderTypeVertex, ProviderTypeGroq:
+		return true
+	default:
+		return false
+	}
 }
 
-// Load reads YAML configuration from disk and validates the result.
-func Load(path string) (Config, error) {
-	absPath, err := filepath.Abs(path)
-	if err != nil {
-		return Config{}, fmt.Errorf("resolve config path: %w", err)
+// Pricing returns the configured price of a provider's model.
+func (p ProvidersConfig) Pricing(providerName, modelID string) (PricingConfig, bool) {
+	var modelConfigs []ModelConfig
+	if provider, ok := p.Named()[providerName]; ok {
+		modelConfigs = provider.Models
+	} else if providerName == "fixture" && p.Fixture != nil {
+		modelConfigs = p.Fixture.Models
+	}
+	for _, model := range modelConfigs {
+		if model.ID == modelID && model.Pricing != nil {
+			return *model.Pricing, true
+		}
+	}
+	if model, ok := MatchModelPattern(modelConfigs, modelID); ok && model.Pricing != nil {
+		return *model.Pricing, true
 	}
+	return PricingConfig{}, false
+}
 
-	data, err := os.ReadFile(absPath)
-	if err != nil {
-		return Config{}, fmt.Errorf("read config file %q: %w", absPath, err)
+// HasPassthrough reports whether any provider forwards a model's requests
+// untranslated.
+func (p ProvidersConfig) HasPassthrough() bool {
+	for _, provider := range p.Named() {
+		for _, model := range provider.Models {
+			if model.Passthrough {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return Config{}, fmt.Errorf("parse config file %q: %w", absPath, err)
+// MatchModelPattern returns the most specific pattern model serving an ID;
+// the first listed wins a tie.
+func MatchModelPattern(modelConfigs []ModelConfig, id string) (ModelConfig, bool) {
+	var best ModelConfig
+	found := false
+	for _, model := range modelConfigs {
+		if !model.IsPattern() || !model.Matches(id) {
+			continue
+		}
+		if !found || model.Specificity() > best.Specificity() {
+			best, found = model, true
+		}
 	}
+	return best, found
+}
 
-	if err := cfg.Validate(); err != nil {
-		return Config{}, err
+// ProviderConfig captures authentication and routing info for a provider.
+type ProviderConfig struct {
+	// Type is one of openai, claude, nvidia, azure, vertex or groq.
+	Type   string `yaml:"type"`
+	APIKey string `yaml:"api_key"`
+	// APIKeyEnv names an environment variable holding the API key, in
+	// place of api_key.
+	APIKeyEnv string            `yaml:"api_key_env"`
+	BaseURL   string            `yaml:"base_url"`
+	Models    []ModelConfig     `yaml:"models"`
+	Headers   Headers           `yaml:"headers"`
+	Aliases   map[string]string `yaml:"aliases"`
+	// Organization and Project attribute OpenAI-style requests for billing.
+	Organization string `yaml:"organization"`
+	Project      string `yaml:"project"`
+	// Auth replaces the static API key with tokens acquired at runtime.
+	Auth ProviderAuthConfig `yaml:"auth"`
+	// Discovery adds the models the upstream lists to the configured ones.
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	// APIVersion is the api-version query parameter of Azure OpenAI
+	// requests.
+	APIVersion string `yaml:"api_version"`
+	// Retry resends requests that failed with a transient error.
+	Retry RetryConfig `yaml:"retry"`
+	// Vertex locates the Google Cloud project and region of a Vertex AI
+	// provider.
+	Vertex VertexConfig `yaml:"vertex"`
+	// RateLimits governs how the rate-limit headers of upstream responses
+	// hold requests back.
+	RateLimits RateLimitConfig `yaml:"rate_limits"`
+	// Timeout bounds a request to the provider, all retries included, or
+	// a stream's wait for its first chunk; it defaults to 60s.
+	// StreamIdleTimeout bounds the wait for each later chunk and defaults
+	// to Timeout. Models may override both. ConnectTimeout bounds opening
+	// a connection and defaults to 10s.
+	Timeout           time.Duration `yaml:"timeout"`
+	ConnectTimeout    time.Duration `yaml:"connect_timeout"`
+	StreamIdleTimeout time.Duration `yaml:"stream_idle_timeout"`
+	// Concurrency caps the requests in flight to all of the provider's
+	// models together, so a slow upstream cannot tie up the server.
+	Concurrency ConcurrencyConfig `yaml:"concurrency"`
+}
+
+// RateLimitConfig keeps requests from a provider whose rate-limit headers
+// (x-ratelimit-* or anthropic-ratelimit-*) show its budget spent, until
+// the budget resets, so they fail over before the upstream answers 429.
+type RateLimitConfig struct {
+	// Ignore sends requests whatever the headers say.
+	Ignore bool `yaml:"ignore"`
+	// ReserveRequests and ReserveTokens hold requests back once no more
+	// than this many requests or tokens remain, leaving headroom for
+	// other clients of the same account.
+	ReserveRequests int `yaml:"reserve_requests"`
+	ReserveTokens   int `yaml:"reserve_tokens"`
+}
+
+// VertexConfig addresses Vertex AI, which serves Claude models through
+// Anthropic's publisher endpoints and Gemini models through an
+// OpenAI-compatible endpoint, both scoped to a project and region.
+type VertexConfig struct {
+	Project string `yaml:"project"`
+	// Region is a Google Cloud region such as us-east5, or global.
+	Region string `yaml:"region"`
+}
+
+// Endpoint returns the Vertex AI API host of the region.
+func (v VertexConfig) Endpoint() string {
+	if v.Region == "global" {
+		return "https://aiplatform.googleapis.com"
 	}
-	return cfg, nil
+	return "https://" + v.Region + "-aiplatform.googleapis.com"
 }
 
-// Validate performs strict sanity checks on the configuration.
-func (c Config) Validate() error {
-	if c.Server.Port <= 0 || c.Server.Port > 65535 {
-		return fmt.Errorf("server.port must be a valid TCP port, got %d", c.Server.Port)
+// LocationPath returns the path of the project's region under the API
+// root, which every Vertex AI model endpoint starts with.
+func (v VertexConfig) LocationPath() string {
+	return "/v1/projects/" + url.PathEscape(v.Project) + "/locations/" + url.PathEscape(v.Region)
+}
+
+// RetryConfig retries upstream requests that failed before a response
+// arrived or with a retryable status, backing off exponentially between
+// attempts.
+type RetryConfig struct {
+	// Attempts counts every try, the first included; below 2 disables
+	// retries.
+	Attempts   int           `yaml:"attempts"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// Jitter randomises each wait by up to this fraction of it.
+	Jitter float64 `yaml:"jitter"`
+	// Statuses are the response codes retried, by default 429, 500, 502,
+	// 503 and 504.
+	Statuses []int `yaml:"statuses"`
+}
+
+// Enabled reports whether any request is retried.
+func (r RetryConfig) Enabled() bool {
+	return r.Attempts > 1
+}
+
+// InitialBackoff returns the wait before the first retry, defaulting to
+// half a second.
+func (r RetryConfig) InitialBackoff() time.Duration {
+	if r.Backoff <= 0 {
+		return 500 * time.Millisecond
 	}
+	return r.Backoff
+}
 
-	providers := map[string]ProviderConfig{
-		"openai": c.Providers.OpenAI,
-		"claude": c.Providers.Claude,
+// BackoffLimit returns the longest wait between attempts, defaulting to
+// ten seconds. A Retry-After beyond it is not waited for.
+func (r RetryConfig) BackoffLimit() time.Duration {
+	if r.MaxBackoff <= 0 {
+		return 10 * time.Second
 	}
+	return r.MaxBackoff
+}
 
-	if c.Providers.NVIDIA != nil {
-		providers["nvidia"] = *c.Providers.NVIDIA
+// RetryStatuses returns the response codes that are retried.
+func (r RetryConfig) RetryStatuses() []int {
+	if len(r.Statuses) == 0 {
+		return []int{429, 500, 502, 503, 504}
 	}
+	return r.Statuses
+}
 
-	for name, provider := range providers {
-		if err := validateProvider(name, provider); err != nil {
-			return err
-		}
+// DiscoveryConfig controls model auto-discovery. The upstream model list is
+// fetched at startup and every Refresh after; models it stops listing are
+// dropped again. Configured models always win over discovered ones.
+type DiscoveryConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Refresh time.Duration `yaml:"refresh"`
+}
+
+// RefreshInterval returns how often the model list is refetched, defaulting
+// to ten minutes.
+func (d DiscoveryConfig) RefreshInterval() time.Duration {
+	if d.Refresh <= 0 {
+		return 10 * time.Minute
 	}
+	return d.Refresh
+}
 
-	return nil
+// Provider authentication types.
+const (
+	AuthTypeAPIKey  = "api_key"
+	AuthTypeAzureAD = "azure_ad"
+	AuthTypeGoogle  = "google"
+)
+
+// ProviderAuthConfig selects how a provider authenticates upstream. The
+// default is the provider's static api_key.
+type ProviderAuthConfig struct {
+	Type   string           `yaml:"type"`
+	Azure  AzureAuthConfig  `yaml:"azure"`
+	Google GoogleAuthConfig `yaml:"google"`
 }
 
-func validateProvider(name string, provider ProviderConfig) error {
-	if strings.TrimSpace(provider.APIKey) == "" {
-		return fmt.Errorf("provider %s: api_key must be provided", name)
+// GoogleAuthConfig acquires Google OAuth2 access tokens. Without a
+// credentials file, Application Default Credentials are used: the
+// GOOGLE_APPLICATION_CREDENTIALS file, then the metadata server.
+type GoogleAuthConfig struct {
+	// CredentialsFile is a service account key or a workload identity
+	// federation (external_account) configuration.
+	CredentialsFile string   `yaml:"credentials_file"`
+	Scopes          []string `yaml:"scopes"`
+}
+
+// TokenScopes returns the scopes tokens are requested for, defaulting to
+// cloud-platform.
+func (g GoogleAuthConfig) TokenScopes() []string {
+	if len(g.Scopes) == 0 {
+		return []string{"https://www.googleapis.com/auth/cloud-platform"}
 	}
-	if strings.TrimSpace(provider.BaseURL) == "" {
-		return fmt.Errorf("provider %s: base_url must be provided", name)
+	return g.Scopes
+}
+
+// UsesAPIKey reports whether the provider authenticates with api_key.
+func (a ProviderAuthConfig) UsesAPIKey() bool {
+	return a.Type == "" || a.Type == AuthTypeAPIKey
+}
+
+// AzureAuthConfig acquires Microsoft Entra ID tokens, either with a client
+// secret or from the managed identity of the Azure host.
+type AzureAuthConfig struct {
+	TenantID     string `yaml:"tenant_id"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// ManagedIdentity uses the host's identity; ClientID then selects a
+	// user-assigned identity and may be left empty for the system one.
+	ManagedIdentity bool   `yaml:"managed_identity"`
+	Scope           string `yaml:"scope"`
+}
+
+// TokenScope returns the scope tokens are requested for, defaulting to
+// Azure OpenAI.
+func (a AzureAuthConfig) TokenScope() string {
+	if a.Scope == "" {
+		return "https://cognitiveservices.azure.com/.default"
 	}
-	if len(provider.Models) == 0 {
-		return fmt.Errorf("provider %s: at least one model must be configured", name)
+	return a.Scope
+}
+
+// Headers contains additional HTTP headers to send with a provider request.
+type Headers map[string]string
+
+// ClientKeyConfig holds settings applied to requests presenting a specific
+// client key.
+type ClientKeyConfig struct {
+	// Attribution overrides the organization and project per provider name,
+	// so each key is billed to its own OpenAI organization or project.
+	Attribution map[string]AttributionConfig `yaml:"attribution"`
+}
+
+// AttributionConfig names an upstream organization and project.
+type AttributionConfig struct {
+	Organization string `yaml:"organization"`
+	Project      string `yaml:"project"`
+}
+
+// Alert periods accepted by alerts.spend[].period.
+const (
+	AlertPeriodHour = "hour"
+	AlertPeriodDay  = "day"
+)
+
+// AlertsConfig controls the background monitor that posts alerts to a
+// webhook when spend crosses a threshold, errors spike or usage grows
+// anomalously. Spend and anomaly alerts read the usage store.
+type AlertsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// WebhookURL receives a Slack-compatible JSON payload per alert.
+	WebhookURL string `yaml:"webhook_url"`
+	// Interval is how often the monitor evaluates; zero means one minute.
+	Interval time.Duration `yaml:"interval"`
+	// Cooldown silences a repeated error rate or anomaly alert; zero means
+	// one hour. Spend alerts fire once per period.
+	Cooldown  time.Duration        `yaml:"cooldown"`
+	Spend     []SpendAlertConfig   `yaml:"spend"`
+	ErrorRate ErrorRateAlertConfig `yaml:"error_rate"`
+	Anomaly   AnomalyAlertConfig   `yaml:"anomaly"`
+}
+
+// EvaluateEvery returns the evaluation interval.
+func (a AlertsConfig) EvaluateEvery() time.Duration {
+	if a.Interval <= 0 {
+		return time.Minute
 	}
+	return a.Interval
+}
 
-	for _, model := range provider.Models {
-		if strings.TrimSpace(model.ID) == "" {
-			return fmt.Errorf("provider %s: model id must not be empty", name)
-		}
-		if err := validateAPIStyle(name, model.APIStyle); err != nil {
-			return err
-		}
+// CooldownPeriod returns how long a repeated alert stays quiet.
+func (a AlertsConfig) CooldownPeriod() time.Duration {
+	if a.Cooldown <= 0 {
+		return time.Hour
 	}
+	return a.Cooldown
+}
 
-	for headerKey := range provider.Headers {
-		if !isCanonicalHTTPHeader(headerKey) {
-			return fmt.Errorf("provider %s: header %q is not a valid canonical HTTP header", name, headerKey)
-		}
+// SpendAlertConfig fires when the usage matching Key and Provider in the
+// current hour or day exceeds Cost (US dollars, from model pricing) or
+// Tokens. Empty filters match everything.
+type SpendAlertConfig struct {
+	Name string `yaml:"name"`
+	// Key is a client key as listed under keys.
+	Key      string  `yaml:"key"`
+	Provider string  `yaml:"provider"`
+	Period   string  `yaml:"period"`
+	Cost     float64 `yaml:"cost"`
+	Tokens   int     `yaml:"tokens"`
+}
+
+// AlertPeriod returns the period, defaulting to a day.
+func (s SpendAlertConfig) AlertPeriod() string {
+	if s.Period == "" {
+		return AlertPeriodDay
 	}
+	return s.Period
+}
 
-	for alias, target := range provider.Aliases {
-		if strings.TrimSpace(alias) == "" {
-			return fmt.Errorf("provider %s: alias name must not be empty", name)
-		}
-		if strings.TrimSpace(target) == "" {
-			return fmt.Errorf("provider %s: alias %q target must not be empty", name, alias)
+// ErrorRateAlertConfig fires when the share of /v1 requests failing with a
+// 5xx status over Window exceeds Threshold. A zero threshold disables it.
+type ErrorRateAlertConfig struct {
+	Threshold float64       `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	// MinRequests keeps quiet periods from alerting on a handful of
+	// failures; zero means 20.
+	MinRequests int `yaml:"min_requests"`
+}
+
+// WindowLength returns the error rate window, defaulting to five minutes.
+func (e ErrorRateAlertConfig) WindowLength() time.Duration {
+	if e.Window <= 0 {
+		return 5 * time.Minute
+	}
+	return e.Window
+}
+
+// RequestFloor returns the fewest requests evaluated.
+func (e ErrorRateAlertConfig) RequestFloor() int {
+	if e.MinRequests <= 0 {
+		return 20
+	}
+	return e.MinRequests
+}
+
+// AnomalyAlertConfig fires when a key's tokens in the current hour exceed
+// Factor times its hourly average over the trailing baseline. A zero factor
+// disables it.
+type AnomalyAlertConfig struct {
+	Factor        float64 `yaml:"factor"`
+	BaselineHours int     `yaml:"baseline_hours"`
+	// MinTokens ignores keys using fewer tokens this hour; zero means
+	// 10000.
+	MinTokens int `yaml:"min_tokens"`
+}
+
+// Baseline returns the trailing baseline in hours, defaulting to a day.
+func (a AnomalyAlertConfig) Baseline() int {
+	if a.BaselineHours <= 0 {
+		return 24
+	}
+	return a.BaselineHours
+}
+
+// TokenFloor returns the fewest tokens evaluated.
+func (a AnomalyAlertConfig) TokenFloor() int {
+	if a.MinTokens <= 0 {
+		return 10000
+	}
+	return a.MinTokens
+}
+
+// MaxTags bounds the tags kept per request; further tags are dropped.
+const MaxTags = 16
+
+// TagsConfig controls request tags: labels such as team, repo or feature
+// that clients send in the X-Router-Tags header or in request metadata so
+// usage can be attributed end to end.
+type TagsConfig struct {
+	// Allowed lists the accepted tag names. Empty accepts any name.
+	Allowed []string `yaml:"allowed"`
+	// Metrics lists the tags counted in the tagged token metric. Values past
+	// observability.metrics.max_series fold into "other".
+	Metrics []string `yaml:"metrics"`
+	// Upstream copies the tags into the metadata of upstream requests, for
+	// providers that accept arbitrary metadata.
+	Upstream bool `yaml:"upstream"`
+}
+
+// Accepts reports whether tags named name are kept.
+func (t TagsConfig) Accepts(name string) bool {
+	return len(t.Allowed) == 0 || slices.Contains(t.Allowed, name)
+}
+
+// ValidTagName reports whether name can be used as a tag: 1 to 64 letters,
+// digits, '_', '-' or '.'.
+func ValidTagName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
 		}
 	}
+	return true
+}
+
+// MCPConfig controls the Model Context Protocol endpoint.
+type MCPConfig struct {
+	Enabled bool            `yaml:"enabled"`
+	Tools   []MCPToolConfig `yaml:"tools"`
+}
+
+// MCPToolConfig exposes a configured model as an MCP tool.
+type MCPToolConfig struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	Model       string `yaml:"model"`
+	System      string `yaml:"system"`
+	MaxTokens   int    `yaml:"max_tokens"`
+}
+
+// CopilotConfig controls the Copilot-compatible engine completions endpoint.
+type CopilotConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	DefaultModel string            `yaml:"default_model"`
+	Engines      map[string]string `yaml:"engines"`
+}
+
+// BudgetsConfig groups token budget policies.
+type BudgetsConfig struct {
+	Conversation ConversationBudgetConfig `yaml:"conversation"`
+	Quotas       []QuotaConfig            `yaml:"quotas"`
+}
+
+// Calendar quota windows accepted by budgets.quotas[].window.
+const (
+	QuotaWindowMinute = "minute"
+	QuotaWindowHour   = "hour"
+	QuotaWindowDay    = "day"
+	QuotaWindowMonth  = "month"
+)
+
+// QuotaConfig limits the requests, tokens or spend of each client key over
+// a window. The window is either calendar-aligned (Window, reset at the
+// start of each minute, hour, day or month in Timezone) or rolling
+// (Rolling, the trailing duration).
+type QuotaConfig struct {
+	Name string `yaml:"name"`
+	// Keys restricts the quota to the listed client keys; empty applies it
+	// to every key, each counted separately.
+	Keys     []string      `yaml:"keys"`
+	Window   string        `yaml:"window"`
+	Rolling  time.Duration `yaml:"rolling"`
+	Timezone string        `yaml:"timezone"`
+	Requests int           `yaml:"requests"`
+	Tokens   int           `yaml:"tokens"`
+	// Cost is in US dollars, priced from models[].pricing.
+	Cost float64 `yaml:"cost"`
+}
+
+// Location returns the time zone calendar windows reset in.
+func (q QuotaConfig) Location() (*time.Location, error) {
+	if q.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(q.Timezone)
+}
+
+// ConversationBudgetConfig caps cumulative token usage per conversation ID.
+type ConversationBudgetConfig struct {
+	MaxTokens int           `yaml:"max_tokens"`
+	WarnRatio float64       `yaml:"warn_ratio"`
+	TTL       time.Duration `yaml:"ttl"`
+	Header    string        `yaml:"header"`
+}
+
+// Enabled reports whether a conversation ceiling is configured.
+func (b ConversationBudgetConfig) Enabled() bool {
+	return b.MaxTokens > 0
+}
+
+// HeaderName returns the request header carrying the conversation ID.
+func (b ConversationBudgetConfig) HeaderName() string {
+	if strings.TrimSpace(b.Header) == "" {
+		return "X-Conversation-Id"
+	}
+	return b.Header
+}
+
+// WarnThreshold returns the token count after which responses carry a warning.
+func (b ConversationBudgetConfig) WarnThreshold() int {
+	ratio := b.WarnRatio
+	if ratio <= 0 {
+		ratio = 0.8
+	}
+	return int(float64(b.MaxTokens) * ratio)
+}
+
+// LimitsConfig defines server-side request limits enforced regardless of
+// what clients ask for.
+type LimitsConfig struct {
+	MaxOutputTokens int `yaml:"max_output_tokens"`
+	// RequestsPerMinute paces each client key with a token bucket that
+	// refills at this rate and holds Burst requests, defaulting to a
+	// minute's worth. Zero leaves requests unpaced.
+	RequestsPerMinute int                        `yaml:"requests_per_minute"`
+	Burst             int                        `yaml:"burst"`
+	Keys              map[string]KeyLimitsConfig `yaml:"keys"`
+}
 
+// RateFor returns the request rate and burst that apply to a client key.
+// A key's own rate replaces the default, and its burst defaults to its
+// rate.
+func (l LimitsConfig) RateFor(key string) (perMinute, burst int) {
+	perMinute, burst = l.RequestsPerMinute, l.Burst
+	if keyLimits, ok := l.Keys[key]; ok && key != "" && keyLimits.RequestsPerMinute > 0 {
+		perMinute, burst = keyLimits.RequestsPerMinute, keyLimits.Burst
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return perMinute, burst
+}
+
+// KeyLimitsConfig overrides limits for requests presenting a specific client key.
+type KeyLimitsConfig struct {
+	MaxOutputTokens   int `yaml:"max_output_tokens"`
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	Burst             int `yaml:"burst"`
+}
+
+// ProvenanceConfig controls annotations identifying which upstream
+// generated each response.
+type ProvenanceConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Metadata bool   `yaml:"metadata"`
+	LogFile  string `yaml:"log_file"`
+}
+
+// Log levels accepted by observability.log_level.
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+// ObservabilityConfig controls logging verbosity and how much per-request
+// telemetry is kept.
+type ObservabilityConfig struct {
+	LogLevel string         `yaml:"log_level"`
+	Sampling SamplingConfig `yaml:"sampling"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+}
+
+// Client key labels accepted by observability.metrics.key_label.
+const (
+	MetricsKeyLabelNone   = "none"
+	MetricsKeyLabelPrefix = "prefix"
+	MetricsKeyLabelHash   = "hash"
+)
+
+// MetricsConfig controls the Prometheus endpoint and how far its labels are
+// collapsed to keep the number of series bounded in multi-tenant
+// deployments.
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// KeyLabel reports client keys by a short hash prefix (the default), by
+	// their full hash, or not at all.
+	KeyLabel        string `yaml:"key_label"`
+	KeyPrefixLength int    `yaml:"key_prefix_length"`
+	// Models lists the models reported by name; any other model is reported
+	// as "other". An empty list reports every model.
+	Models []string `yaml:"models"`
+	// RawPaths labels requests with the request path rather than the route
+	// template, which multiplies series for routes carrying IDs.
+	RawPaths bool `yaml:"raw_paths"`
+	// MaxSeries caps the label combinations kept per metric; later ones are
+	// folded into a single "other" series.
+	MaxSeries int `yaml:"max_series"`
+}
+
+// KeyLabelMode returns how client keys are labelled, defaulting to a hash
+// prefix.
+func (m MetricsConfig) KeyLabelMode() string {
+	if m.KeyLabel == "" {
+		return MetricsKeyLabelPrefix
+	}
+	return m.KeyLabel
+}
+
+// PrefixLength returns how many hex digits of the key hash are kept by the
+// prefix mode.
+func (m MetricsConfig) PrefixLength() int {
+	if m.KeyPrefixLength <= 0 {
+		return 6
+	}
+	return m.KeyPrefixLength
+}
+
+// SeriesLimit returns the per-metric series cap.
+func (m MetricsConfig) SeriesLimit() int {
+	if m.MaxSeries <= 0 {
+		return 10000
+	}
+	return m.MaxSeries
+}
+
+// TracingConfig exports OpenTelemetry spans over OTLP/HTTP. Traces follow
+// the sampling policy, decided once the request has finished.
+type TracingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint is the OTLP/HTTP traces URL, such as
+	// http://localhost:4318/v1/traces.
+	Endpoint    string  `yaml:"endpoint"`
+	Headers     Headers `yaml:"headers"`
+	ServiceName string  `yaml:"service_name"`
+	// Timeout bounds each export request.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Service returns the service.name reported with spans.
+func (t TracingConfig) Service() string {
+	if t.ServiceName == "" {
+		return "gocode-router"
+	}
+	return t.ServiceName
+}
+
+// ExportTimeout returns the export request timeout, defaulting to ten
+// seconds.
+func (t TracingConfig) ExportTimeout() time.Duration {
+	if t.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return t.Timeout
+}
+
+// SamplingConfig keeps a fraction of request logs and traces. Failed
+// requests are always kept unless SampleErrors is set.
+type SamplingConfig struct {
+	// Rate is unset rather than zero when omitted, so that rate: 0 keeps
+	// no successful request at all.
+	Rate         *float64 `yaml:"rate"`
+	SampleErrors bool     `yaml:"sample_errors"`
+}
+
+// SampleRate returns the fraction of requests kept, defaulting to all.
+func (s SamplingConfig) SampleRate() float64 {
+	if s.Rate == nil {
+		return 1
+	}
+	return *s.Rate
+}
+
+// Experiment bucketing subjects accepted by routing.experiments bucket_by.
+const (
+	ExperimentBucketUser = "user"
+	ExperimentBucketKey  = "key"
+)
+
+// Deployment selection strategies accepted by routing.deployment_selection.
+const (
+	DeploymentSelectionWeighted   = "weighted"
+	DeploymentSelectionRoundRobin = "round_robin"
+)
+
+// RoutingConfig declares virtual models resolved by routing policies rather
+// than a single upstream model.
+type RoutingConfig struct {
+	Ensembles   map[string]EnsembleConfig    `yaml:"ensembles"`
+	Consensus   map[string]ConsensusConfig   `yaml:"consensus"`
+	DraftVerify map[string]DraftVerifyConfig `yaml:"draft_verify"`
+	Schedules   map[string]ScheduleConfig    `yaml:"schedules"`
+	Balancers   map[string]BalancerConfig    `yaml:"balancers"`
+	Experiments map[string]ExperimentConfig  `yaml:"experiments"`
+
+	DegenerateRetry DegenerateRetryConfig `yaml:"degenerate_retry"`
+
+	// Fallbacks maps a model to the models tried in turn when it fails with
+	// a 429, a 5xx or a timeout.
+	Fallbacks map[string][]string `yaml:"fallbacks"`
+
+	// Hedging sends a request for a model to a second model as well when the
+	// first has not started answering in time, and keeps whichever answers
+	// first.
+	Hedging map[string]HedgeConfig `yaml:"hedging"`
+
+	// Mirrors copies a sample of the requests for a model to another model
+	// in the background. Clients only ever see the original model's answer;
+	// the mirrored responses are logged and captured for comparison.
+	Mirrors map[string]MirrorConfig `yaml:"mirrors"`
+
+	// DeploymentSelection picks among the providers serving the same model
+	// ID: weighted (random, the default) or round_robin.
+	DeploymentSelection string `yaml:"deployment_selection"`
+
+	// ChoiceEmulation answers n > 1 for models whose API has no n, such as
+	// Claude, with one upstream call per choice.
+	ChoiceEmulation ChoiceEmulationConfig `yaml:"n_emulation"`
+
+	// Hooks rewrite requests and responses, in order, before any other
+	// routing policy sees them.
+	Hooks []HookConfig `yaml:"hooks"`
+
+	// Plugins run WASM modules, HTTP services or compiled-in Go hooks, in
+	// order, after the hooks. See package plugin for the ABI they
+	// implement.
+	Plugins []PluginConfig `yaml:"plugins"`
+}
+
+// PluginConfig loads request and response middleware: a WASM module, an
+// HTTP service or a Go hook compiled into the router. Exactly one of Path,
+// URL and Builtin is set.
+type PluginConfig struct {
+	Name string `yaml:"name"`
+	// Path is the .wasm file. It is reloaded when the configuration is and
+	// the file has changed.
+	Path string `yaml:"path"`
+	// URL is an HTTP service each call is POSTed to, with Headers.
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	// Phases limits the calls made to an HTTP service to request or
+	// response; empty means both.
+	Phases []string `yaml:"phases"`
+	// Builtin names a hook registered with plugin.Register.
+	Builtin string `yaml:"builtin"`
+	// Timeout bounds each call into the plugin; zero means 250ms.
+	Timeout time.Duration `yaml:"timeout"`
+	// FailOpen lets requests through when the module fails or times out
+	// instead of rejecting them.
+	FailOpen bool `yaml:"fail_open"`
+	// Config is passed to the module with every call.
+	Config map[string]any `yaml:"config"`
+}
+
+// CallTimeout returns the per-call timeout.
+func (p PluginConfig) CallTimeout() time.Duration {
+	if p.Timeout <= 0 {
+		return 250 * time.Millisecond
+	}
+	return p.Timeout
+}
+
+// ExperimentConfig splits a model name's traffic between arms by
+// percentage. Requests are bucketed by a hash of the experiment name and
+// their subject, so the same user keeps getting the same arm.
+type ExperimentConfig struct {
+	Arms []ExperimentArm `yaml:"arms"`
+	// BucketBy is user, the request's user field falling back to the
+	// client key when it has none, or key; empty means user.
+	BucketBy string `yaml:"bucket_by"`
+}
+
+// ExperimentArm is one side of an experiment. The arms' percentages add up
+// to 100.
+type ExperimentArm struct {
+	Name    string  `yaml:"name"`
+	Model   string  `yaml:"model"`
+	Percent float64 `yaml:"percent"`
+}
+
+// Bucket returns the subject requests are bucketed by.
+func (e ExperimentConfig) Bucket() string {
+	if e.BucketBy == "" {
+		return ExperimentBucketUser
+	}
+	return e.BucketBy
+}
+
+// HedgeConfig names the model a slow request is hedged with.
+type HedgeConfig struct {
+	Model string `yaml:"model"`
+	// After is how long the first model has to start answering before the
+	// request is hedged; zero means 500ms.
+	After time.Duration `yaml:"after"`
+}
+
+// Delay returns how long to wait before hedging.
+func (h HedgeConfig) Delay() time.Duration {
+	if h.After <= 0 {
+		return 500 * time.Millisecond
+	}
+	return h.After
+}
+
+// MirrorConfig shadows a model's traffic to another model.
+type MirrorConfig struct {
+	MirrorTo string `yaml:"mirror_to"`
+	// SampleRate is the fraction of requests mirrored; zero means all.
+	SampleRate float64 `yaml:"sample_rate"`
+	// MaxInFlight caps the mirrored calls running at once; requests beyond
+	// it are not mirrored. Zero means 16.
+	MaxInFlight int `yaml:"max_in_flight"`
+}
+
+// Rate returns the fraction of requests mirrored.
+func (m MirrorConfig) Rate() float64 {
+	if m.SampleRate <= 0 {
+		return 1
+	}
+	return m.SampleRate
+}
+
+// Limit returns the maximum number of mirrored calls in flight.
+func (m MirrorConfig) Limit() int {
+	if m.MaxInFlight <= 0 {
+		return 16
+	}
+	return m.MaxInFlight
+}
+
+// BalancerConfig spreads a virtual model's requests across target models in
+// proportion to their weights. With Adaptive enabled the weights follow
+// each target's recent success rate and latency.
+type BalancerConfig struct {
+	Targets  []BalancerTarget `yaml:"targets"`
+	Adaptive AdaptiveConfig   `yaml:"adaptive"`
+}
+
+// BalancerTarget is one model behind a balancer.
+type BalancerTarget struct {
+	Model string `yaml:"model"`
+	// Weight is the share of traffic before adaptation; zero means 1.
+	Weight float64 `yaml:"weight"`
+	// Pinned keeps Weight as configured, overriding adaptation.
+	Pinned bool `yaml:"pinned"`
+}
+
+// BaseWeight returns the configured weight.
+func (t BalancerTarget) BaseWeight() float64 {
+	if t.Weight <= 0 {
+		return 1
+	}
+	return t.Weight
+}
+
+// AdaptiveConfig bounds how far adaptation moves a target's weight.
+type AdaptiveConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// HalfLife is how quickly old outcomes fade; zero means one minute.
+	HalfLife time.Duration `yaml:"half_life"`
+	// MinSamples is the recent outcomes a target needs before its weight
+	// adapts; zero means 10.
+	MinSamples int `yaml:"min_samples"`
+	// Floor and Ceiling bound the weight as multiples of the configured
+	// weight; zero means 0.05 and 4. A floor above zero keeps probing
+	// degraded targets so they can recover.
+	Floor   float64 `yaml:"floor"`
+	Ceiling float64 `yaml:"ceiling"`
+}
+
+// DecayHalfLife returns the half-life of recorded outcomes.
+func (a AdaptiveConfig) DecayHalfLife() time.Duration {
+	if a.HalfLife <= 0 {
+		return time.Minute
+	}
+	return a.HalfLife
+}
+
+// SampleFloor returns the outcomes needed before a weight adapts.
+func (a AdaptiveConfig) SampleFloor() int {
+	if a.MinSamples <= 0 {
+		return 10
+	}
+	return a.MinSamples
+}
+
+// Bounds returns the floor and ceiling multipliers.
+func (a AdaptiveConfig) Bounds() (floor, ceiling float64) {
+	floor, ceiling = a.Floor, a.Ceiling
+	if floor <= 0 {
+		floor = 0.05
+	}
+	if ceiling <= 0 {
+		ceiling = 4
+	}
+	return floor, ceiling
+}
+
+// ScheduleConfig routes a virtual model by time of day, for example to an
+// expensive model during business hours and a cheaper one overnight. The
+// first rule whose window contains the current time picks the model;
+// Default applies outside every window.
+type ScheduleConfig struct {
+	// Timezone is an IANA zone such as Europe/Berlin; empty means UTC.
+	Timezone string         `yaml:"timezone"`
+	Rules    []ScheduleRule `yaml:"rules"`
+	Default  string         `yaml:"default"`
+}
+
+// ScheduleRule is a daily window from From to To, written as HH:MM. A window
+// whose end is not after its start runs past midnight.
+type ScheduleRule struct {
+	// Days are the weekdays the window starts on, such as mon, sat or a
+	// range like mon-fri; empty means every day.
+	Days  []string `yaml:"days"`
+	From  string   `yaml:"from"`
+	To    string   `yaml:"to"`
+	Model string   `yaml:"model"`
+}
+
+// Location returns the schedule's time zone.
+func (s ScheduleConfig) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseWeekdays parses day names and ranges such as mon-fri or fri-mon.
+// Empty days select every day.
+func ParseWeekdays(days []string) ([7]bool, error) {
+	var out [7]bool
+	if len(days) == 0 {
+		for i := range out {
+			out[i] = true
+		}
+		return out, nil
+	}
+	for _, spec := range days {
+		from, to, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(spec)), "-")
+		if !isRange {
+			to = from
+		}
+		start, ok := weekdays[strings.TrimSpace(from)]
+		end, endOK := weekdays[strings.TrimSpace(to)]
+		if !ok || !endOK {
+			return out, fmt.Errorf("invalid day %q", spec)
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			out[d] = true
+			if d == end {
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// ParseClock parses an HH:MM time of day into minutes after midnight.
+func ParseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// HookConfig applies its request and response actions to requests matching
+// When, an expression in the syntax of package expr. An empty When matches
+// every request.
+type HookConfig struct {
+	Name     string             `yaml:"name"`
+	When     string             `yaml:"when"`
+	Request  HookRequestConfig  `yaml:"request"`
+	Response HookResponseConfig `yaml:"response"`
+}
+
+// HookRequestConfig mutates a matching request.
+type HookRequestConfig struct {
+	// Model replaces the requested model.
+	Model string `yaml:"model"`
+	// Set overrides request options such as temperature or max_tokens.
+	Set map[string]any `yaml:"set"`
+	// Unset drops request options.
+	Unset []string `yaml:"unset"`
+	// System is prepended to chat requests as a system message.
+	System string `yaml:"system"`
+}
+
+// HookResponseConfig post-processes the response to a matching request.
+type HookResponseConfig struct {
+	// When further restricts the response actions using the response
+	// variables as well.
+	When    string            `yaml:"when"`
+	Replace []HookReplacement `yaml:"replace"`
+	Prepend string            `yaml:"prepend"`
+	Append  string            `yaml:"append"`
+	// Annotate adds entries to router_metadata.
+	Annotate map[string]any `yaml:"annotate"`
+}
+
+// HookReplacement rewrites response text matching a regular expression.
+type HookReplacement struct {
+	Pattern string `yaml:"pattern"`
+	With    string `yaml:"with"`
+}
+
+// Ensemble selection strategies.
+const (
+	EnsembleStrategyJudge       = "judge"
+	EnsembleStrategyLongest     = "longest"
+	EnsembleStrategyLongestJSON = "longest_json"
+)
+
+// EnsembleConfig fans a request out to several models and keeps one answer.
+type EnsembleConfig struct {
+	Models   []string `yaml:"models"`
+	Judge    string   `yaml:"judge"`
+	Strategy string   `yaml:"strategy"`
+}
+
+// SelectionStrategy returns the configured strategy, defaulting to the judge
+// when one is configured and to the longest answer otherwise.
+func (e EnsembleConfig) SelectionStrategy() string {
+	if e.Strategy != "" {
+		return e.Strategy
+	}
+	if e.Judge != "" {
+		return EnsembleStrategyJudge
+	}
+	return EnsembleStrategyLongest
+}
+
+// ConsensusConfig samples one or more models repeatedly and returns the
+// majority answer.
+type ConsensusConfig struct {
+	Models  []string `yaml:"models"`
+	Samples int      `yaml:"samples"`
+}
+
+// SampleModels expands the configured models into one entry per sample.
+func (c ConsensusConfig) SampleModels() []string {
+	samples := c.Samples
+	if samples <= 0 {
+		samples = 1
+	}
+	out := make([]string, 0, len(c.Models)*samples)
+	for _, model := range c.Models {
+		for i := 0; i < samples; i++ {
+			out = append(out, model)
+		}
+	}
+	return out
+}
+
+// DraftVerifyConfig pairs a cheap drafting model with a stronger model that
+// reviews and corrects the draft.
+type DraftVerifyConfig struct {
+	Drafter      string `yaml:"drafter"`
+	Verifier     string `yaml:"verifier"`
+	Instructions string `yaml:"instructions"`
+}
+
+// DegenerateRetryConfig retries once when a response looks degenerate.
+// Empty responses are always treated as degenerate when enabled.
+type DegenerateRetryConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Truncated also retries responses cut off by the token limit.
+	Truncated bool `yaml:"truncated"`
+	// RepetitionThreshold is the share of repeated word 4-grams above which a
+	// response is considered stuck in a loop. Zero disables the check.
+	RepetitionThreshold float64 `yaml:"repetition_threshold"`
+	// Fallbacks maps a model to the model used for the retry; models without
+	// an entry are retried as-is.
+	Fallbacks map[string]string `yaml:"fallbacks"`
+}
+
+// ChoiceEmulationConfig bounds n > 1 emulation. Without it, such requests
+// to Claude-style models are rejected.
+type ChoiceEmulationConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxN is the largest n accepted; zero means 8.
+	MaxN int `yaml:"max_n"`
+	// Parallel caps the calls in flight per request; zero means 4.
+	Parallel int `yaml:"parallel"`
+}
+
+// Limit returns the largest n accepted.
+func (c ChoiceEmulationConfig) Limit() int {
+	if c.MaxN <= 0 {
+		return 8
+	}
+	return c.MaxN
+}
+
+// Concurrency returns how many calls of one request may be in flight.
+func (c ChoiceEmulationConfig) Concurrency() int {
+	if c.Parallel <= 0 {
+		return 4
+	}
+	return c.Parallel
+}
+
+// State backends.
+const (
+	StateBackendMemory = "memory"
+	StateBackendRedis  = "redis"
+)
+
+// StateConfig selects where counters for limits and budgets are kept. The
+// memory backend is per instance; redis shares state across a fleet.
+type StateConfig struct {
+	Backend string      `yaml:"backend"`
+	Redis   RedisConfig `yaml:"redis"`
+}
+
+// RedisConfig describes how to reach a Redis-compatible server.
+type RedisConfig struct {
+	Addr      string `yaml:"addr"`
+	Password  string `yaml:"password"`
+	DB        int    `yaml:"db"`
+	KeyPrefix string `yaml:"key_prefix"`
+}
+
+// Prefix returns the namespace applied to every key the router writes.
+func (r RedisConfig) Prefix() string {
+	if r.KeyPrefix == "" {
+		return "gocode-router:"
+	}
+	return r.KeyPrefix
+}
+
+// Cache backends.
+const (
+	CacheBackendMemory    = "memory"
+	CacheBackendRedis     = "redis"
+	CacheBackendMemcached = "memcached"
+)
+
+// CacheConfig controls caching of non-streaming responses.
+type CacheConfig struct {
+	Enabled    bool             `yaml:"enabled"`
+	Backend    string           `yaml:"backend"`
+	TTL        time.Duration    `yaml:"ttl"`
+	MaxEntries int              `yaml:"max_entries"`
+	Redis      RedisConfig      `yaml:"redis"`
+	Memcached  MemcachedConfig  `yaml:"memcached"`
+	Peers      CachePeersConfig `yaml:"peers"`
+	// MaxEntryBytes skips caching responses larger than this; zero means no
+	// limit.
+	MaxEntryBytes int `yaml:"max_entry_bytes"`
+	// Models overrides the cache policy for requests naming a model or
+	// alias.
+	Models map[string]CacheModelConfig `yaml:"models"`
+}
+
+// CacheModelConfig is the cache policy of one model. Zero values fall back
+// to the cache-wide settings.
+type CacheModelConfig struct {
+	Disabled      bool          `yaml:"disabled"`
+	TTL           time.Duration `yaml:"ttl"`
+	MaxEntryBytes int           `yaml:"max_entry_bytes"`
+}
+
+// CachePeersConfig partitions the memory cache across replicas by
+// consistent hashing on the cache key. Every instance lists the same peers,
+// including itself as Self.
+type CachePeersConfig struct {
+	Self         string   `yaml:"self"`
+	URLs         []string `yaml:"urls"`
+	VirtualNodes int      `yaml:"virtual_nodes"`
+	// Secret is sent between peers and required on the peer endpoint,
+	// which would otherwise let any client read and write cache entries.
+	Secret string `yaml:"secret"`
+}
+
+// Enabled reports whether the cache is partitioned across peers.
+func (p CachePeersConfig) Enabled() bool {
+	return len(p.URLs) > 0
+}
+
+// MemcachedConfig describes how to reach a memcached server.
+type MemcachedConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+// EntryTTL returns how long cached responses stay valid.
+func (c CacheConfig) EntryTTL() time.Duration {
+	if c.TTL <= 0 {
+		return 5 * time.Minute
+	}
+	return c.TTL
+}
+
+// ForModel returns the cache policy of requests for a model: whether they
+// are cached, for how long, and the largest entry kept.
+func (c CacheConfig) ForModel(model string) (enabled bool, ttl time.Duration, maxEntryBytes int) {
+	if !c.Enabled {
+		return false, 0, 0
+	}
+	ttl, maxEntryBytes = c.EntryTTL(), c.MaxEntryBytes
+	override, ok := c.Models[model]
+	if !ok {
+		return true, ttl, maxEntryBytes
+	}
+	if override.TTL > 0 {
+		ttl = override.TTL
+	}
+	if override.MaxEntryBytes > 0 {
+		maxEntryBytes = override.MaxEntryBytes
+	}
+	return !override.Disabled, ttl, maxEntryBytes
+}
+
+// Job store backends.
+const (
+	JobsBackendMemory = "memory"
+	JobsBackendFile   = "file"
+)
+
+// JobsConfig controls asynchronous jobs and the store that keeps them
+// across restarts.
+type JobsConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Backend     string        `yaml:"backend"`
+	Path        string        `yaml:"path"`
+	Retention   time.Duration `yaml:"retention"`
+	Workers     int           `yaml:"workers"`
+	MaxAttempts int           `yaml:"max_attempts"`
+	// PersistRequests queues synchronous requests carrying an
+	// Idempotency-Key as jobs, so they survive a restart and a retry with
+	// the same key returns the stored result.
+	PersistRequests bool          `yaml:"persist_requests"`
+	RequestWait     time.Duration `yaml:"request_wait"`
+}
+
+// RetentionPeriod returns how long finished jobs are kept before pruning.
+func (j JobsConfig) RetentionPeriod() time.Duration {
+	if j.Retention <= 0 {
+		return 24 * time.Hour
+	}
+	return j.Retention
+}
+
+// WorkerCount returns how many jobs run concurrently.
+func (j JobsConfig) WorkerCount() int {
+	if j.Workers <= 0 {
+		return 2
+	}
+	return j.Workers
+}
+
+// WaitTimeout returns how long a persisted synchronous request waits for
+// its job before the client is told to poll instead.
+func (j JobsConfig) WaitTimeout() time.Duration {
+	if j.RequestWait <= 0 {
+		return time.Minute
+	}
+	return j.RequestWait
+}
+
+// Attempts returns how many times a failing job is tried before it is
+// marked failed.
+func (j JobsConfig) Attempts() int {
+	if j.MaxAttempts <= 0 {
+		return 3
+	}
+	return j.MaxAttempts
+}
+
+// Usage store backends.
+const (
+	UsageBackendMemory = "memory"
+	UsageBackendFile   = "file"
+)
+
+// UsageConfig controls recording of per-request token usage and its
+// hourly and daily rollups.
+type UsageConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	Backend        string        `yaml:"backend"`
+	Path           string        `yaml:"path"`
+	RawRetention   time.Duration `yaml:"raw_retention"`
+	RollupInterval time.Duration `yaml:"rollup_interval"`
+}
+
+// RawRetentionPeriod returns how long raw per-request records are kept
+// after they have been rolled up.
+func (u UsageConfig) RawRetentionPeriod() time.Duration {
+	if u.RawRetention <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return u.RawRetention
+}
+
+// FlushInterval returns how often buffered records are stored and rolled up.
+func (u UsageConfig) FlushInterval() time.Duration {
+	if u.RollupInterval <= 0 {
+		return time.Minute
+	}
+	return u.RollupInterval
+}
+
+// Storage backends.
+const (
+	StorageBackendMemory   = "memory"
+	StorageBackendFile     = "file"
+	StorageBackendSQLite   = "sqlite"
+	StorageBackendPostgres = "postgres"
+)
+
+// StorageConfig selects the shared backend used by jobs, usage and the
+// response cache when their own backend is left unset.
+type StorageConfig struct {
+	Backend string `yaml:"backend"`
+	// Path is a directory for the file backend or a database file for SQLite.
+	Path string `yaml:"path"`
+	// DSN is the Postgres connection string.
+	DSN string `yaml:"dsn"`
+	// CompletionRetention is how long completions stored with store=true
+	// are kept.
+	CompletionRetention time.Duration `yaml:"completion_retention"`
+}
+
+// CompletionRetentionPeriod returns how long stored completions are kept,
+// defaulting to 30 days as OpenAI does.
+func (s StorageConfig) CompletionRetentionPeriod() time.Duration {
+	if s.CompletionRetention <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return s.CompletionRetention
+}
+
+// ModelConfig describes a model exposed by a provider.
+type ModelConfig struct {
+	ID string `yaml:"id"`
+	// Pattern or Regex replace ID to serve every model ID matching a glob
+	// (path.Match syntax) or a regular expression that must match the
+	// whole ID. The requested ID is sent upstream.
+	Pattern         string `yaml:"pattern"`
+	Regex           string `yaml:"regex"`
+	APIStyle        string `yaml:"api_style"`
+	MaxOutputTokens int    `yaml:"max_output_tokens"`
+	// ContextWindow is the most tokens the model takes, prompt and output
+	// together. Requests counted above it are rejected before dispatch.
+	ContextWindow int    `yaml:"context_window"`
+	DisplayName   string `yaml:"display_name"`
+	// Deployment is the Azure OpenAI deployment serving the model,
+	// defaulting to its ID.
+	Deployment string `yaml:"deployment"`
+	// Pricing enables cost figures for the model.
+	Pricing     *PricingConfig    `yaml:"pricing"`
+	Concurrency ConcurrencyConfig `yaml:"concurrency"`
+	// Weight is this provider's share of the model's requests when several
+	// providers serve the same model ID; zero means 1.
+	Weight float64 `yaml:"weight"`
+	// Passthrough forwards requests made in the model's own API style
+	// with their body unchanged but for the model ID, keeping fields the
+	// translation drops.
+	Passthrough bool `yaml:"passthrough"`
+	// SystemPrompt is sent as the system message of every chat request to
+	// the model, replacing the client's system messages, or, with Prepend,
+	// ahead of them.
+	SystemPrompt string `yaml:"system_prompt"`
+	Prepend      bool   `yaml:"prepend"`
+	// Defaults fill in the chat request options, such as temperature or
+	// max_tokens, that clients leave out; Overrides replace them whatever
+	// clients send. Options are named as in OpenAI chat requests.
+	Defaults  map[string]any `yaml:"defaults"`
+	Overrides map[string]any `yaml:"overrides"`
+	// Target makes the entry a virtual model: requests for its ID are
+	// served by the provider's model Target, with the entry's system
+	// prompt and options applied. It takes its API style and limits from Target.
+	Target string `yaml:"target"`
+	// Timeout and StreamIdleTimeout override the provider's for the model.
+	Timeout           time.Duration `yaml:"timeout"`
+	StreamIdleTimeout time.Duration `yaml:"stream_idle_timeout"`
+}
+
+// DefaultOptions returns Defaults as a JSON request would decode them,
+// with numbers as float64, so they read like the client's own options.
+func (m ModelConfig) DefaultOptions() map[string]any {
+	return requestOptions(m.Defaults)
+}
+
+// OverrideOptions returns Overrides as a JSON request would decode them.
+func (m ModelConfig) OverrideOptions() map[string]any {
+	return requestOptions(m.Overrides)
+}
+
+func requestOptions(options map[string]any) map[string]any {
+	if len(options) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(options)
+	if err != nil {
+		return options
+	}
+	var out map[string]any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return options
+	}
+	return out
+}
+
+// IsVirtual reports whether the model is served by another of its
+// provider's models.
+func (m ModelConfig) IsVirtual() bool {
+	return m.Target != ""
+}
+
+// IsPattern reports whether the model serves IDs matching a pattern or
+// regex rather than a single ID.
+func (m ModelConfig) IsPattern() bool {
+	return m.Pattern != "" || m.Regex != ""
+}
+
+// Name identifies the model in messages: its ID, pattern or regex.
+func (m ModelConfig) Name() string {
+	switch {
+	case m.Pattern != "":
+		return m.Pattern
+	case m.Regex != "":
+		return m.Regex
+	}
+	return m.ID
+}
+
+// Matches reports whether the model serves a model ID.
+func (m ModelConfig) Matches(id string) bool {
+	switch {
+	case m.Pattern != "":
+		ok, _ := path.Match(m.Pattern, id)
+		return ok
+	case m.Regex != "":
+		re, err := compileModelRegex(m.Regex)
+		return err == nil && re.MatchString(id)
+	}
+	return m.ID == id
+}
+
+// Specificity ranks the patterns matching an ID; the one with the most
+// literal characters wins. For a regex, only its literal prefix counts.
+func (m ModelConfig) Specificity() int {
+	switch {
+	case m.Pattern != "":
+		return len(m.Pattern) - strings.Count(m.Pattern, "*") - strings.Count(m.Pattern, "?")
+	case m.Regex != "":
+		re, err := compileModelRegex(m.Regex)
+		if err != nil {
+			return 0
+		}
+		prefix, _ := re.LiteralPrefix()
+		return len(prefix)
+	}
+	return len(m.ID)
+}
+
+// modelRegexes caches compiled model regexes, which are matched on every
+// request for a model no configured ID claims.
+var modelRegexes sync.Map
+
+func compileModelRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := modelRegexes.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(`^(?:` + expr + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	modelRegexes.Store(expr, re)
+	return re, nil
+}
+
+// ConcurrencyConfig caps the requests in flight to one model, for upstreams
+// such as local GPU servers that only handle a few generations at a time,
+// or to all of a provider's models.
+type ConcurrencyConfig struct {
+	// Max is the cap; zero means unlimited.
+	Max int `yaml:"max"`
+	// Queue is how many requests over the cap may wait for a slot, for up
+	// to QueueTimeout (zero means 30s). Requests beyond it get a 429.
+	Queue        int           `yaml:"queue"`
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+}
+
+// PricingConfig is a model's price in US dollars per million tokens.
+type PricingConfig struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// Cost returns the price of the given token counts.
+func (p PricingConfig) Cost(promptTokens, completionTokens int) float64 {
+	return (float64(promptTokens)*p.Input + float64(completionTokens)*p.Output) / 1e6
+}
+
+// Load reads YAML configuration from disk and validates the result.
+func Load(path string) (Config, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("resolve config path: %w", err)
+	}
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return Config{}, fmt.Errorf("read config file %q: %w", absPath, err)
+	}
+
+	return Parse(data, absPath)
+}
+
+// Parse decodes YAML configuration and validates the result. ${NAME}
+// references in values are replaced with environment variables first. The
+// source is only used to label errors.
+func Parse(data []byte, source string) (Config, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Config{}, fmt.Errorf("parse config %q: %w", source, err)
+	}
+	if err := expandEnv(&doc); err != nil {
+		return Config{}, fmt.Errorf("config %q: %w", source, err)
+	}
+
+	var cfg Config
+	if doc.Kind != 0 {
+		if err := doc.Decode(&cfg); err != nil {
+			return Config{}, fmt.Errorf("parse config %q: %w", source, err)
+		}
+	}
+	if err := resolveAPIKeyEnv(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Validate performs strict sanity checks on the configuration.
+func (c Config) Validate() error {
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("server.port must be a valid TCP port, got %d", c.Server.Port)
+	}
+	if err := validateStreaming(c.Server.Streaming); err != nil {
+		return err
+	}
+	if err := validateTLS(c.Server.TLS, c.Server.Port); err != nil {
+		return err
+	}
+	if err := validateCORS(c.Server.CORS); err != nil {
+		return err
+	}
+	if err := validateChaos(c.Chaos); err != nil {
+		return err
+	}
+	if err := validateCapture(c.Capture); err != nil {
+		return err
+	}
+	if err := validateAudit(c.Audit); err != nil {
+		return err
+	}
+	if p := c.Health.Probe; p.Interval < 0 || p.Timeout < 0 || p.Failures < 0 {
+		return errors.New("health.probe: interval, timeout and failures must not be negative")
+	}
+	if c.Server.MaxBodyBytes < 0 {
+		return fmt.Errorf("server.max_body_bytes must not be negative, got %d", c.Server.MaxBodyBytes)
+	}
+	for i, limit := range c.Server.BodyLimits {
+		if !strings.HasPrefix(limit.Route, "/") {
+			return fmt.Errorf("server.body_limits[%d]: route %q must start with /", i, limit.Route)
+		}
+		if _, err := path.Match(limit.Route, ""); err != nil {
+			return fmt.Errorf("server.body_limits[%d]: route %q: %w", i, limit.Route, err)
+		}
+		if limit.MaxBytes <= 0 {
+			return fmt.Errorf("server.body_limits[%d]: max_bytes must be positive", i)
+		}
+	}
+	for _, route := range c.Server.DisabledRoutes {
+		if !strings.HasPrefix(route, "/") {
+			return fmt.Errorf("server.disabled_routes: %q must start with /", route)
+		}
+		if _, err := path.Match(route, ""); err != nil {
+			return fmt.Errorf("server.disabled_routes: %q: %w", route, err)
+		}
+	}
+
+	providers := c.Providers.Named()
+	if len(providers) == 0 && c.Providers.Fixture == nil {
+		return errors.New("providers: at least one provider must be configured")
+	}
+	for name, provider := range providers {
+		if err := validateProvider(name, provider); err != nil {
+			return err
+		}
+	}
+	if c.Providers.Fixture != nil {
+		if err := validateFixtureProvider(*c.Providers.Fixture); err != nil {
+			return err
+		}
+	}
+
+	if err := validateMCP(c.MCP); err != nil {
+		return err
+	}
+	if err := validateCopilot(c.Copilot); err != nil {
+		return err
+	}
+	if err := validateConversationBudget(c.Budgets.Conversation); err != nil {
+		return err
+	}
+	if err := validateQuotas(c.Budgets.Quotas); err != nil {
+		return err
+	}
+	if err := validateLimits(c.Limits); err != nil {
+		return err
+	}
+	if err := validateRouting(c.Routing); err != nil {
+		return err
+	}
+	if err := validateState(c.State); err != nil {
+		return err
+	}
+	if err := validateCache(c.Cache); err != nil {
+		return err
+	}
+	if err := validateJobs(c.Jobs); err != nil {
+		return err
+	}
+	if err := validateUsage(c.Usage); err != nil {
+		return err
+	}
+	if err := validateStorage(c.Storage); err != nil {
+		return err
+	}
+	if err := validateObservability(c.Observability); err != nil {
+		return err
+	}
+	for i, key := range c.Auth.Keys {
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("auth.keys[%d] must not be empty", i)
+		}
+	}
+	if err := validateKeys(c.Keys, providers); err != nil {
+		return err
+	}
+	if err := validateTags(c.Tags); err != nil {
+		return err
+	}
+	if err := validateAlerts(c.Alerts, c.Usage, c.Keys, providers); err != nil {
+		return err
+	}
+	if err := validateTokenizers(c.Tokenizers); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func validateProvider(name string, provider ProviderConfig) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("providers: provider name must not be empty")
+	}
+	if provider.Type == "" {
+		return fmt.Errorf("provider %s: type must be provided", name)
+	}
+	if !isProviderType(provider.Type) {
+		return fmt.Errorf("provider %s: type %q must be one of %q, %q, %q, %q, %q or %q", name, provider.Type, ProviderTypeOpenAI, ProviderTypeClaude, ProviderTypeNVIDIA, ProviderTypeAzure, ProviderTypeVertex, ProviderTypeGroq)
+	}
+	if provider.Type == ProviderTypeVertex {
+		if err := validateVertex(name, provider); err != nil {
+			return err
+		}
+	}
+	if provider.Auth.UsesAPIKey() && strings.TrimSpace(provider.APIKey) == "" {
+		return fmt.Errorf("provider %s: api_key or api_key_env must be provided", name)
+	}
+	if err := validateProviderAuth(name, provider.Auth); err != nil {
+		return err
+	}
+	if strings.TrimSpace(provider.BaseURL) == "" && provider.Type != ProviderTypeVertex {
+		return fmt.Errorf("provider %s: base_url must be provided", name)
+	}
+	if provider.Discovery.Refresh < 0 {
+		return fmt.Errorf("provider %s: discovery.refresh must not be negative", name)
+	}
+	if err := validateRetry(name, provider.Retry); err != nil {
+		return err
+	}
+	if provider.Timeout < 0 || provider.ConnectTimeout < 0 || provider.StreamIdleTimeout < 0 {
+		return fmt.Errorf("provider %s: timeouts must not be negative", name)
+	}
+	if provider.RateLimits.ReserveRequests < 0 || provider.RateLimits.ReserveTokens < 0 {
+		return fmt.Errorf("provider %s: rate_limits reserves must not be negative", name)
+	}
+	if c := provider.Concurrency; c.Max < 0 || c.Queue < 0 || c.QueueTimeout < 0 {
+		return fmt.Errorf("provider %s: concurrency settings must not be negative", name)
+	}
+	if c := provider.Concurrency; c.Max == 0 && c.Queue > 0 {
+		return fmt.Errorf("provider %s: concurrency.queue needs concurrency.max", name)
+	}
+	if provider.Discovery.Enabled && (provider.Type == ProviderTypeNVIDIA || provider.Type == ProviderTypeAzure || provider.Type == ProviderTypeVertex) {
+		return fmt.Errorf("provider %s: discovery is not supported", name)
+	}
+	if len(provider.Models) == 0 && !provider.Discovery.Enabled {
+		return fmt.Errorf("provider %s: at least one model must be configured", name)
+	}
+
+	for _, model := range provider.Models {
+		if err := validateModel(name, model); err != nil {
+			return err
+		}
+		if model.IsVirtual() && slices.ContainsFunc(provider.Models, func(m ModelConfig) bool { return m.IsVirtual() && m.ID == model.Target }) {
+			return fmt.Errorf("provider %s: model %s targets virtual model %s", name, model.ID, model.Target)
+		}
+	}
+
+	for headerKey := range provider.Headers {
+		if !isCanonicalHTTPHeader(headerKey) {
+			return fmt.Errorf("provider %s: header %q is not a valid canonical HTTP header", name, headerKey)
+		}
+	}
+
+	for alias, target := range provider.Aliases {
+		if strings.TrimSpace(alias) == "" {
+			return fmt.Errorf("provider %s: alias name must not be empty", name)
+		}
+		if strings.TrimSpace(target) == "" {
+			return fmt.Errorf("provider %s: alias %q target must not be empty", name, alias)
+		}
+	}
+
+	return nil
+}
+
+func validateTLS(t TLSConfig, port int) error {
+	if !t.Enabled() {
+		if t.ClientCAFile != "" {
+			return errors.New("server.tls.client_ca_file needs cert_file and key_file or acme.domains")
+		}
+		return nil
+	}
+	files := t.CertFile != "" || t.KeyFile != ""
+	if files && len(t.ACME.Domains) > 0 {
+		return errors.New("server.tls: set either cert_file and key_file or acme.domains, not both")
+	}
+	if files && (t.CertFile == "" || t.KeyFile == "") {
+		return errors.New("server.tls: cert_file and key_file must be set together")
+	}
+	for _, domain := range t.ACME.Domains {
+		if strings.TrimSpace(domain) == "" || strings.ContainsAny(domain, "/:*") {
+			return fmt.Errorf("server.tls.acme.domains: %q is not a host name", domain)
+		}
+	}
+	if p := t.ACME.HTTPPort; p < 0 || p > 65535 || (p != 0 && p == port) {
+		return fmt.Errorf("server.tls.acme.http_port must be a valid TCP port other than server.port, got %d", p)
+	}
+	if t.ACME.HTTPPort != 0 && len(t.ACME.Domains) == 0 {
+		return errors.New("server.tls.acme.http_port needs acme.domains")
+	}
+	return nil
+}
+
+func validateCORS(cors CORSConfig) error {
+	for _, origin := range cors.AllowedOrigins {
+		if strings.TrimSpace(origin) == "" {
+			return errors.New("server.cors.allowed_origins: origins must not be empty")
+		}
+		if origin == "*" && cors.AllowCredentials {
+			return errors.New("server.cors: allow_credentials can't be used with the \"*\" origin; list the origins instead")
+		}
+	}
+	if cors.MaxAge < 0 {
+		return errors.New("server.cors.max_age must not be negative")
+	}
+	return nil
+}
+
+func validateModel(name string, model ModelConfig) error {
+	set := 0
+	for _, v := range []string{model.ID, model.Pattern, model.Regex} {
+		if strings.TrimSpace(v) != "" {
+			set++
+		}
+	}
+	if set == 0 {
+		return fmt.Errorf("provider %s: model id must not be empty", name)
+	}
+	if set > 1 {
+		return fmt.Errorf("provider %s: model %s must set only one of id, pattern and regex", name, model.Name())
+	}
+	if model.Pattern != "" {
+		if _, err := path.Match(model.Pattern, ""); err != nil {
+			return fmt.Errorf("provider %s: model pattern %q: %w", name, model.Pattern, err)
+		}
+	}
+	if model.Regex != "" {
+		if _, err := regexp.Compile(model.Regex); err != nil {
+			return fmt.Errorf("provider %s: model regex %q: %w", name, model.Regex, err)
+		}
+	}
+	for kind, options := range map[string]map[string]any{"defaults": model.Defaults, "overrides": model.Overrides} {
+		if limit, ok := options["max_tokens"]; ok {
+			if n, isInt := limit.(int); !isInt || n <= 0 {
+				return fmt.Errorf("provider %s: model %s %s.max_tokens must be a positive integer", name, model.Name(), kind)
+			}
+		}
+	}
+	if model.Target != "" {
+		return validateVirtualModel(name, model)
+	}
+	if model.Prepend && model.SystemPrompt == "" {
+		return fmt.Errorf("provider %s: model %s prepend needs a system_prompt", name, model.Name())
+	}
+	if err := validateAPIStyle(name, model.APIStyle); err != nil {
+		return err
+	}
+	if model.MaxOutputTokens < 0 {
+		return fmt.Errorf("provider %s: model %s max_output_tokens must not be negative", name, model.Name())
+	}
+	if model.Timeout < 0 || model.StreamIdleTimeout < 0 {
+		return fmt.Errorf("provider %s: model %s timeouts must not be negative", name, model.Name())
+	}
+	if model.ContextWindow < 0 {
+		return fmt.Errorf("provider %s: model %s context_window must not be negative", name, model.Name())
+	}
+	if model.ContextWindow > 0 && model.MaxOutputTokens >= model.ContextWindow {
+		return fmt.Errorf("provider %s: model %s max_output_tokens must be less than its context_window", name, model.Name())
+	}
+	if p := model.Pricing; p != nil && (p.Input < 0 || p.Output < 0) {
+		return fmt.Errorf("provider %s: model %s pricing must not be negative", name, model.Name())
+	}
+	if c := model.Concurrency; c.Max < 0 || c.Queue < 0 || c.QueueTimeout < 0 {
+		return fmt.Errorf("provider %s: model %s concurrency settings must not be negative", name, model.Name())
+	}
+	if c := model.Concurrency; c.Max == 0 && c.Queue > 0 {
+		return fmt.Errorf("provider %s: model %s concurrency.queue needs concurrency.max", name, model.Name())
+	}
+	if model.Weight < 0 {
+		return fmt.Errorf("provider %s: model %s weight must not be negative", name, model.Name())
+	}
+	return nil
+}
+
+// validateVirtualModel checks a model served by another model. Settings
+// it would take from its target may not be set.
+func validateVirtualModel(name string, model ModelConfig) error {
+	if model.IsPattern() {
+		return fmt.Errorf("provider %s: model %s: a target needs a model id, not a pattern", name, model.Name())
+	}
+	if strings.TrimSpace(model.SystemPrompt) == "" && len(model.Defaults) == 0 && len(model.Overrides) == 0 {
+		return fmt.Errorf("provider %s: model %s: a target needs a system_prompt, defaults or overrides", name, model.ID)
+	}
+	if model.Target == model.ID {
+		return fmt.Errorf("provider %s: model %s must not target itself", name, model.ID)
+	}
+	if model.APIStyle != "" || model.MaxOutputTokens != 0 || model.ContextWindow != 0 || model.Timeout != 0 || model.StreamIdleTimeout != 0 || model.Deployment != "" || model.Pricing != nil || model.Concurrency != (ConcurrencyConfig{}) || model.Weight != 0 || model.Passthrough {
+		return fmt.Errorf("provider %s: model %s takes its api_style, limits, pricing and passthrough from target %s", name, model.ID, model.Target)
+	}
+	return nil
+}
+
+func validateFixtureProvider(fixture FixtureProviderConfig) error {
+	if strings.TrimSpace(fixture.Dir) == "" {
+		return errors.New("provider fixture: dir must be provided")
+	}
+	if len(fixture.Models) == 0 {
+		return errors.New("provider fixture: at least one model must be configured")
+	}
+	for _, model := range fixture.Models {
+		if err := validateModel("fixture", model); err != nil {
+			return err
+		}
+		if model.IsPattern() {
+			return fmt.Errorf("provider fixture: model %s: fixtures are recorded per model id, patterns are not supported", model.Name())
+		}
+		if model.IsVirtual() || model.SystemPrompt != "" {
+			return fmt.Errorf("provider fixture: model %s: fixtures answer from disk, system prompts are not supported", model.Name())
+		}
+	}
+	for alias, target := range fixture.Aliases {
+		if strings.TrimSpace(alias) == "" || strings.TrimSpace(target) == "" {
+			return errors.New("provider fixture: aliases need a name and a target")
+		}
+	}
+	return nil
+}
+
+func validateRetry(name string, retry RetryConfig) error {
+	if retry.Attempts < 0 || retry.Backoff < 0 || retry.MaxBackoff < 0 {
+		return fmt.Errorf("provider %s: retry attempts, backoff and max_backoff must not be negative", name)
+	}
+	if retry.Jitter < 0 || retry.Jitter > 1 {
+		return fmt.Errorf("provider %s: retry.jitter must be between 0 and 1, got %g", name, retry.Jitter)
+	}
+	for _, status := range retry.Statuses {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("provider %s: retry.statuses: %d is not an HTTP error status", name, status)
+		}
+	}
+	return nil
+}
+
+// validateVertex checks a Vertex AI provider names its project and region,
+// which its endpoints are built from, and authenticates with Google tokens.
+func validateVertex(name string, provider ProviderConfig) error {
+	if strings.TrimSpace(provider.Vertex.Project) == "" {
+		return fmt.Errorf("provider %s: vertex.project must be provided", name)
+	}
+	if strings.TrimSpace(provider.Vertex.Region) == "" {
+		return fmt.Errorf("provider %s: vertex.region must be provided", name)
+	}
+	if provider.Auth.Type != AuthTypeGoogle {
+		return fmt.Errorf("provider %s: vertex providers need auth.type %q, got %q", name, AuthTypeGoogle, provider.Auth.Type)
+	}
+	return nil
+}
+
+func validateProviderAuth(name string, auth ProviderAuthConfig) error {
+	switch auth.Type {
+	case "", AuthTypeAPIKey:
+	case AuthTypeAzureAD:
+		azure := auth.Azure
+		if azure.ManagedIdentity {
+			return nil
+		}
+		if strings.TrimSpace(azure.TenantID) == "" || strings.TrimSpace(azure.ClientID) == "" || strings.TrimSpace(azure.ClientSecret) == "" {
+			return fmt.Errorf("provider %s: auth.azure needs tenant_id, client_id and client_secret unless managed_identity is set", name)
+		}
+	case AuthTypeGoogle:
+		for _, scope := range auth.Google.Scopes {
+			if strings.TrimSpace(scope) == "" {
+				return fmt.Errorf("provider %s: auth.google.scopes must not contain empty entries", name)
+			}
+		}
+	default:
+		return fmt.Errorf("provider %s: auth.type %q must be one of %q, %q or %q", name, auth.Type, AuthTypeAPIKey, AuthTypeAzureAD, AuthTypeGoogle)
+	}
+	return nil
+}
+
+func validateMCP(mcp MCPConfig) error {
+	seen := make(map[string]struct{}, len(mcp.Tools))
+	for i, tool := range mcp.Tools {
+		name := strings.TrimSpace(tool.Name)
+		if name == "" {
+			return fmt.Errorf("mcp.tools[%d]: name must not be empty", i)
+		}
+		if _, exists := seen[name]; exists {
+			return fmt.Errorf("mcp.tools[%d]: duplicate tool name %q", i, name)
+		}
+		seen[name] = struct{}{}
+
+		if strings.TrimSpace(tool.Model) == "" {
+			return fmt.Errorf("mcp tool %s: model must not be empty", name)
+		}
+		if tool.MaxTokens < 0 {
+			return fmt.Errorf("mcp tool %s: max_tokens must not be negative", name)
+		}
+	}
+	return nil
+}
+
+func validateCopilot(copilot CopilotConfig) error {
+	for engine, model := range copilot.Engines {
+		if strings.TrimSpace(engine) == "" {
+			return errors.New("copilot.engines: engine name must not be empty")
+		}
+		if strings.TrimSpace(model) == "" {
+			return fmt.Errorf("copilot engine %q: model must not be empty", engine)
+		}
+	}
+	return nil
+}
+
+func validateConversationBudget(b ConversationBudgetConfig) error {
+	if b.MaxTokens < 0 {
+		return fmt.Errorf("budgets.conversation.max_tokens must not be negative, got %d", b.MaxTokens)
+	}
+	if b.WarnRatio < 0 || b.WarnRatio > 1 {
+		return fmt.Errorf("budgets.conversation.warn_ratio must be between 0 and 1, got %v", b.WarnRatio)
+	}
+	if b.TTL < 0 {
+		return fmt.Errorf("budgets.conversation.ttl must not be negative, got %s", b.TTL)
+	}
+	if b.Header != "" && !isCanonicalHTTPHeader(b.Header) {
+		return fmt.Errorf("budgets.conversation.header %q is not a valid HTTP header", b.Header)
+	}
+	return nil
+}
+
+func validateQuotas(quotas []QuotaConfig) error {
+	seen := make(map[string]bool, len(quotas))
+	for _, q := range quotas {
+		if strings.TrimSpace(q.Name) == "" {
+			return errors.New("budgets.quotas: name must not be empty")
+		}
+		if seen[q.Name] {
+			return fmt.Errorf("budgets.quotas: duplicate quota %q", q.Name)
+		}
+		seen[q.Name] = true
+
+		switch {
+		case q.Window != "" && q.Rolling != 0:
+			return fmt.Errorf("budgets.quotas[%s]: set either window or rolling, not both", q.Name)
+		case q.Rolling < 0:
+			return fmt.Errorf("budgets.quotas[%s]: rolling must not be negative, got %s", q.Name, q.Rolling)
+		case q.Rolling > 0 && q.Rolling < time.Second:
+			return fmt.Errorf("budgets.quotas[%s]: rolling must be at least 1s, got %s", q.Name, q.Rolling)
+		case q.Rolling == 0:
+			switch q.Window {
+			case QuotaWindowMinute, QuotaWindowHour, QuotaWindowDay, QuotaWindowMonth:
+			default:
+				return fmt.Errorf("budgets.quotas[%s]: window %q must be one of minute, hour, day or month", q.Name, q.Window)
+			}
+		}
+		if _, err := q.Location(); err != nil {
+			return fmt.Errorf("budgets.quotas[%s]: timezone: %w", q.Name, err)
+		}
+		if q.Requests < 0 || q.Tokens < 0 || q.Cost < 0 {
+			return fmt.Errorf("budgets.quotas[%s]: limits must not be negative", q.Name)
+		}
+		if q.Requests == 0 && q.Tokens == 0 && q.Cost == 0 {
+			return fmt.Errorf("budgets.quotas[%s]: set at least one of requests, tokens or cost", q.Name)
+		}
+	}
+	return nil
+}
+
+func validateLimits(limits LimitsConfig) error {
+	if limits.MaxOutputTokens < 0 {
+		return fmt.Errorf("limits.max_output_tokens must not be negative, got %d", limits.MaxOutputTokens)
+	}
+	if limits.RequestsPerMinute < 0 || limits.Burst < 0 {
+		return errors.New("limits: requests_per_minute and burst must not be negative")
+	}
+	for key, keyLimits := range limits.Keys {
+		if strings.TrimSpace(key) == "" {
+			return errors.New("limits.keys: key must not be empty")
+		}
+		if keyLimits.MaxOutputTokens < 0 {
+			return fmt.Errorf("limits.keys: max_output_tokens must not be negative, got %d", keyLimits.MaxOutputTokens)
+		}
+		if keyLimits.RequestsPerMinute < 0 || keyLimits.Burst < 0 {
+			return errors.New("limits.keys: requests_per_minute and burst must not be negative")
+		}
+	}
+	return nil
+}
+
+func validateRouting(routing RoutingConfig) error {
+	switch routing.DeploymentSelection {
+	case "", DeploymentSelectionWeighted, DeploymentSelectionRoundRobin:
+	default:
+		return fmt.Errorf("routing.deployment_selection %q must be %q or %q", routing.DeploymentSelection, DeploymentSelectionWeighted, DeploymentSelectionRoundRobin)
+	}
+
+	// Each virtual model name may only be claimed by a single policy.
+	claimed := make(map[string]string)
+	claim := func(kind, name string) error {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("routing.%s: name must not be empty", kind)
+		}
+		if owner, exists := claimed[name]; exists {
+			return fmt.Errorf("routing %s %s: name already used by routing.%s", kind, name, owner)
+		}
+		claimed[name] = kind
+		return nil
+	}
+
+	for name, ensemble := range routing.Ensembles {
+		if err := claim("ensembles", name); err != nil {
+			return err
+		}
+		if err := validateModelList("ensembles", name, ensemble.Models); err != nil {
+			return err
+		}
+		switch ensemble.SelectionStrategy() {
+		case EnsembleStrategyJudge:
+			if strings.TrimSpace(ensemble.Judge) == "" {
+				return fmt.Errorf("routing ensembles %s: judge strategy requires a judge model", name)
+			}
+		case EnsembleStrategyLongest, EnsembleStrategyLongestJSON:
+		default:
+			return fmt.Errorf("routing ensembles %s: unknown strategy %q", name, ensemble.Strategy)
+		}
+	}
+
+	for name, consensus := range routing.Consensus {
+		if err := claim("consensus", name); err != nil {
+			return err
+		}
+		if err := validateModelList("consensus", name, consensus.Models); err != nil {
+			return err
+		}
+		if consensus.Samples < 0 {
+			return fmt.Errorf("routing consensus %s: samples must not be negative", name)
+		}
+		if len(consensus.SampleModels()) < 2 {
+			return fmt.Errorf("routing consensus %s: needs at least two samples to vote", name)
+		}
+	}
+
+	for name, pair := range routing.DraftVerify {
+		if err := claim("draft_verify", name); err != nil {
+			return err
+		}
+		if strings.TrimSpace(pair.Drafter) == "" {
+			return fmt.Errorf("routing draft_verify %s: drafter must not be empty", name)
+		}
+		if strings.TrimSpace(pair.Verifier) == "" {
+			return fmt.Errorf("routing draft_verify %s: verifier must not be empty", name)
+		}
+	}
+
+	for name, schedule := range routing.Schedules {
+		if err := claim("schedules", name); err != nil {
+			return err
+		}
+		if err := validateSchedule(schedule, routing); err != nil {
+			return fmt.Errorf("routing schedules %s: %w", name, err)
+		}
+	}
+
+	for name, balancer := range routing.Balancers {
+		if err := claim("balancers", name); err != nil {
+			return err
+		}
+		if err := validateBalancer(balancer); err != nil {
+			return fmt.Errorf("routing balancers %s: %w", name, err)
+		}
+	}
+
+	for name, experiment := range routing.Experiments {
+		if err := claim("experiments", name); err != nil {
+			return err
+		}
+		if err := validateExperiment(experiment, routing); err != nil {
+			return fmt.Errorf("routing experiments %s: %w", name, err)
+		}
+	}
+
+	for model, chain := range routing.Fallbacks {
+		if strings.TrimSpace(model) == "" {
+			return errors.New("routing.fallbacks: model name must not be empty")
+		}
+		if owner, exists := claimed[model]; exists {
+			return fmt.Errorf("routing fallbacks %s: name is a routing.%s policy; list fallbacks for its models instead", model, owner)
+		}
+		if err := validateModelList("fallbacks", model, chain); err != nil {
+			return err
+		}
+		if slices.Contains(chain, model) {
+			return fmt.Errorf("routing fallbacks %s: chain must not contain the model itself", model)
+		}
+	}
+
+	for model, hedge := range routing.Hedging {
+		if strings.TrimSpace(model) == "" {
+			return errors.New("routing.hedging: model name must not be empty")
+		}
+		if owner, exists := claimed[model]; exists {
+			return fmt.Errorf("routing hedging %s: name is a routing.%s policy; hedge its models instead", model, owner)
+		}
+		if strings.TrimSpace(hedge.Model) == "" {
+			return fmt.Errorf("routing hedging %s: model is required", model)
+		}
+		if hedge.Model == model {
+			return fmt.Errorf("routing hedging %s: model must differ from the hedged model", model)
+		}
+		if hedge.After < 0 {
+			return fmt.Errorf("routing hedging %s: after must not be negative", model)
+		}
+	}
+
+	for model, mirror := range routing.Mirrors {
+		if strings.TrimSpace(model) == "" {
+			return errors.New("routing.mirrors: model name must not be empty")
+		}
+		if strings.TrimSpace(mirror.MirrorTo) == "" {
+			return fmt.Errorf("routing mirrors %s: mirror_to is required", model)
+		}
+		if mirror.MirrorTo == model {
+			return fmt.Errorf("routing mirrors %s: mirror_to must differ from the model", model)
+		}
+		if mirror.SampleRate < 0 || mirror.SampleRate > 1 {
+			return fmt.Errorf("routing mirrors %s: sample_rate must be between 0 and 1, got %v", model, mirror.SampleRate)
+		}
+		if mirror.MaxInFlight < 0 {
+			return fmt.Errorf("routing mirrors %s: max_in_flight must not be negative", model)
+		}
+	}
+
+	for i, hook := range routing.Hooks {
+		if err := validateHook(hook); err != nil {
+			name := hook.Name
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			return fmt.Errorf("routing.hooks[%s]: %w", name, err)
+		}
+	}
+
+	for i, plugin := range routing.Plugins {
+		name := plugin.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		set := 0
+		for _, v := range []string{plugin.Path, plugin.URL, plugin.Builtin} {
+			if strings.TrimSpace(v) != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("routing.plugins[%s]: exactly one of path, url and builtin must be set", name)
+		}
+		if plugin.URL != "" {
+			if u, err := url.Parse(plugin.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("routing.plugins[%s]: url %q must be an http or https URL", name, plugin.URL)
+			}
+		} else if len(plugin.Headers) > 0 || len(plugin.Phases) > 0 {
+			return fmt.Errorf("routing.plugins[%s]: headers and phases only apply to url plugins", name)
+		}
+		for _, phase := range plugin.Phases {
+			if phase != "request" && phase != "response" {
+				return fmt.Errorf("routing.plugins[%s]: phases must be request or response, got %q", name, phase)
+			}
+		}
+		if plugin.Timeout < 0 {
+			return fmt.Errorf("routing.plugins[%s]: timeout must not be negative, got %s", name, plugin.Timeout)
+		}
+	}
+
+	retry := routing.DegenerateRetry
+	if retry.RepetitionThreshold < 0 || retry.RepetitionThreshold > 1 {
+		return fmt.Errorf("routing.degenerate_retry.repetition_threshold must be between 0 and 1, got %v", retry.RepetitionThreshold)
+	}
+	for model, fallback := range retry.Fallbacks {
+		if strings.TrimSpace(model) == "" || strings.TrimSpace(fallback) == "" {
+			return errors.New("routing.degenerate_retry.fallbacks: model names must not be empty")
+		}
+	}
+	if e := routing.ChoiceEmulation; e.MaxN < 0 || e.Parallel < 0 {
+		return errors.New("routing.n_emulation: max_n and parallel must not be negative")
+	}
+
+	return nil
+}
+
+func validateSchedule(schedule ScheduleConfig, routing RoutingConfig) error {
+	if _, err := schedule.Location(); err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	if len(schedule.Rules) == 0 {
+		return errors.New("at least one rule must be configured")
+	}
+	if strings.TrimSpace(schedule.Default) == "" {
+		return errors.New("default must not be empty")
+	}
+	targets := []string{schedule.Default}
+	for i, rule := range schedule.Rules {
+		if _, err := ParseWeekdays(rule.Days); err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		if _, err := ParseClock(rule.From); err != nil {
+			return fmt.Errorf("rules[%d].from: %w", i, err)
+		}
+		if _, err := ParseClock(rule.To); err != nil {
+			return fmt.Errorf("rules[%d].to: %w", i, err)
+		}
+		if strings.TrimSpace(rule.Model) == "" {
+			return fmt.Errorf("rules[%d]: model must not be empty", i)
+		}
+		targets = append(targets, rule.Model)
+	}
+	// Schedules may route to other policies, but not to another schedule
+	// or an experiment, which keeps resolution from looping.
+	for _, target := range targets {
+		if _, ok := routing.Schedules[target]; ok {
+			return fmt.Errorf("model %q is itself a schedule", target)
+		}
+		if _, ok := routing.Experiments[target]; ok {
+			return fmt.Errorf("model %q is an experiment", target)
+		}
+	}
+	return nil
+}
+
+func validateHook(hook HookConfig) error {
+	for _, when := range []string{hook.When, hook.Response.When} {
+		if strings.TrimSpace(when) == "" {
+			continue
+		}
+		if _, err := expr.Compile(when); err != nil {
+			return err
+		}
+	}
+	for _, option := range hook.Request.Unset {
+		if strings.TrimSpace(option) == "" {
+			return errors.New("request.unset: option names must not be empty")
+		}
+	}
+	for _, r := range hook.Response.Replace {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("response.replace: invalid pattern %q: %w", r.Pattern, err)
+		}
+	}
+	return nil
+}
+
+func validateCapture(capture CaptureConfig) error {
+	if !capture.Enabled {
+		return nil
+	}
+	if capture.Path == "" {
+		return errors.New("capture.path must be set when capture is enabled")
+	}
+	if capture.MaxBytes < 0 {
+		return fmt.Errorf("capture.max_bytes must not be negative, got %d", capture.MaxBytes)
+	}
+	if capture.SampleRate < 0 || capture.SampleRate > 1 {
+		return fmt.Errorf("capture.sample_rate must be between 0 and 1, got %v", capture.SampleRate)
+	}
+	for _, pattern := range capture.Models {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("capture.models: pattern %q: %w", pattern, err)
+		}
+	}
+	for i, rule := range capture.Redact {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("capture.redact[%d]: %w", i, err)
+		}
+	}
+	for _, field := range capture.Omit {
+		switch field {
+		case CaptureFieldSystem, CaptureFieldOptions, CaptureFieldMetadata, CaptureFieldKey:
+		default:
+			return fmt.Errorf("capture.omit: unknown field %q; use %q, %q, %q or %q", field, CaptureFieldSystem, CaptureFieldOptions, CaptureFieldMetadata, CaptureFieldKey)
+		}
+	}
+	return nil
+}
+
+func validateAudit(audit AuditConfig) error {
+	if !audit.Enabled {
+		return nil
+	}
+	switch audit.SinkName() {
+	case AuditSinkFile:
+		if audit.Path == "" {
+			return errors.New("audit.path must be set for the file sink")
+		}
+		if audit.MaxBytes < 0 {
+			return fmt.Errorf("audit.max_bytes must not be negative, got %d", audit.MaxBytes)
+		}
+	case AuditSinkStdout:
+	case AuditSinkWebhook:
+		if u, err := url.Parse(audit.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("audit.url must be an http or https URL for the webhook sink, got %q", audit.URL)
+		}
+	default:
+		return fmt.Errorf("audit.sink %q must be one of %q, %q or %q", audit.Sink, AuditSinkFile, AuditSinkStdout, AuditSinkWebhook)
+	}
+	for i, rule := range audit.Redact {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("audit.redact[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateChaos(chaos ChaosConfig) error {
+	for i, rule := range chaos.Rules {
+		for _, pattern := range rule.Models {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("chaos.rules[%d]: model pattern %q: %w", i, pattern, err)
+			}
+		}
+		if rule.Latency < 0 || rule.Jitter < 0 {
+			return fmt.Errorf("chaos.rules[%d]: latency and jitter must not be negative", i)
+		}
+		for _, rate := range []float64{rule.ErrorRate, rule.TruncateRate, rule.MalformRate} {
+			if rate < 0 || rate > 1 {
+				return fmt.Errorf("chaos.rules[%d]: rates must be between 0 and 1, got %v", i, rate)
+			}
+		}
+		if rule.ErrorStatus != 0 && (rule.ErrorStatus < 400 || rule.ErrorStatus > 599) {
+			return fmt.Errorf("chaos.rules[%d]: error_status must be a 4xx or 5xx status, got %d", i, rule.ErrorStatus)
+		}
+	}
+	return nil
+}
+
+func validateStreaming(streaming StreamingConfig) error {
+	switch streaming.Flush {
+	case "", StreamFlushEvent, StreamFlushCoalesce:
+	default:
+		return fmt.Errorf("server.streaming.flush %q must be one of %q or %q", streaming.Flush, StreamFlushEvent, StreamFlushCoalesce)
+	}
+	if streaming.FlushInterval < 0 {
+		return fmt.Errorf("server.streaming.flush_interval must not be negative, got %s", streaming.FlushInterval)
+	}
+	if streaming.FlushBytes < 0 {
+		return fmt.Errorf("server.streaming.flush_bytes must not be negative, got %d", streaming.FlushBytes)
+	}
+	return nil
+}
+
+func validateState(state StateConfig) error {
+	switch state.Backend {
+	case "", StateBackendMemory:
+	case StateBackendRedis:
+		if strings.TrimSpace(state.Redis.Addr) == "" {
+			return errors.New("state.redis.addr must be provided for the redis backend")
+		}
+		if state.Redis.DB < 0 {
+			return fmt.Errorf("state.redis.db must not be negative, got %d", state.Redis.DB)
+		}
+	default:
+		return fmt.Errorf("state.backend %q must be one of %q or %q", state.Backend, StateBackendMemory, StateBackendRedis)
+	}
+	return nil
+}
+
+func validateCache(cache CacheConfig) error {
+	if cache.TTL < 0 {
+		return fmt.Errorf("cache.ttl must not be negative, got %s", cache.TTL)
+	}
+	if cache.MaxEntries < 0 {
+		return fmt.Errorf("cache.max_entries must not be negative, got %d", cache.MaxEntries)
+	}
+	if cache.MaxEntryBytes < 0 {
+		return fmt.Errorf("cache.max_entry_bytes must not be negative, got %d", cache.MaxEntryBytes)
+	}
+	for model, override := range cache.Models {
+		if override.TTL < 0 {
+			return fmt.Errorf("cache.models.%s.ttl must not be negative, got %s", model, override.TTL)
+		}
+		if override.MaxEntryBytes < 0 {
+			return fmt.Errorf("cache.models.%s.max_entry_bytes must not be negative, got %d", model, override.MaxEntryBytes)
+		}
+	}
+	switch cache.Backend {
+	case "", CacheBackendMemory:
+	case CacheBackendRedis:
+		if strings.TrimSpace(cache.Redis.Addr) == "" {
+			return errors.New("cache.redis.addr must be provided for the redis backend")
+		}
+	case CacheBackendMemcached:
+		if strings.TrimSpace(cache.Memcached.Addr) == "" {
+			return errors.New("cache.memcached.addr must be provided for the memcached backend")
+		}
+	default:
+		return fmt.Errorf("cache.backend %q must be one of %q, %q or %q", cache.Backend, CacheBackendMemory, CacheBackendRedis, CacheBackendMemcached)
+	}
+
+	peers := cache.Peers
+	if !peers.Enabled() {
+		return nil
+	}
+	if cache.Backend != "" && cache.Backend != CacheBackendMemory {
+		return fmt.Errorf("cache.peers requires the %q backend; %q is already shared", CacheBackendMemory, cache.Backend)
+	}
+	if strings.TrimSpace(peers.Secret) == "" {
+		return errors.New("cache.peers.secret must be provided: the peer endpoint is served on the public listener")
+	}
+	if peers.VirtualNodes < 0 {
+		return fmt.Errorf("cache.peers.virtual_nodes must not be negative, got %d", peers.VirtualNodes)
+	}
+	self := strings.TrimRight(strings.TrimSpace(peers.Self), "/")
+	if self == "" {
+		return errors.New("cache.peers.self must be provided when cache.peers.urls is set")
+	}
+	listed := false
+	for i, peer := range peers.URLs {
+		peer = strings.TrimRight(strings.TrimSpace(peer), "/")
+		if !strings.HasPrefix(peer, "http://") && !strings.HasPrefix(peer, "https://") {
+			return fmt.Errorf("cache.peers.urls[%d] %q must be an http or https URL", i, peers.URLs[i])
+		}
+		if peer == self {
+			listed = true
+		}
+	}
+	if !listed {
+		return fmt.Errorf("cache.peers.urls must include cache.peers.self %q", peers.Self)
+	}
+	return nil
+}
+
+func validateJobs(jobs JobsConfig) error {
+	if jobs.Retention < 0 {
+		return fmt.Errorf("jobs.retention must not be negative, got %s", jobs.Retention)
+	}
+	if jobs.Workers < 0 {
+		return fmt.Errorf("jobs.workers must not be negative, got %d", jobs.Workers)
+	}
+	if jobs.MaxAttempts < 0 {
+		return fmt.Errorf("jobs.max_attempts must not be negative, got %d", jobs.MaxAttempts)
+	}
+	if jobs.RequestWait < 0 {
+		return fmt.Errorf("jobs.request_wait must not be negative, got %s", jobs.RequestWait)
+	}
+	if jobs.PersistRequests && !jobs.Enabled {
+		return errors.New("jobs.persist_requests requires jobs.enabled")
+	}
+	switch jobs.Backend {
+	case "", JobsBackendMemory:
+	case JobsBackendFile:
+		if strings.TrimSpace(jobs.Path) == "" {
+			return errors.New("jobs.path must be provided for the file backend")
+		}
+	default:
+		return fmt.Errorf("jobs.backend %q must be one of %q or %q", jobs.Backend, JobsBackendMemory, JobsBackendFile)
+	}
+	return nil
+}
+
+func validateUsage(usage UsageConfig) error {
+	if usage.RawRetention < 0 {
+		return fmt.Errorf("usage.raw_retention must not be negative, got %s", usage.RawRetention)
+	}
+	if usage.RollupInterval < 0 {
+		return fmt.Errorf("usage.rollup_interval must not be negative, got %s", usage.RollupInterval)
+	}
+	switch usage.Backend {
+	case "", UsageBackendMemory:
+	case UsageBackendFile:
+		if strings.TrimSpace(usage.Path) == "" {
+			return errors.New("usage.path must be provided for the file backend")
+		}
+	default:
+		return fmt.Errorf("usage.backend %q must be one of %q or %q", usage.Backend, UsageBackendMemory, UsageBackendFile)
+	}
+	return nil
+}
+
+func validateStorage(storage StorageConfig) error {
+	switch storage.Backend {
+	case "", StorageBackendMemory:
+	case StorageBackendFile, StorageBackendSQLite:
+		if strings.TrimSpace(storage.Path) == "" {
+			return fmt.Errorf("storage.path must be provided for the %s backend", storage.Backend)
+		}
+	case StorageBackendPostgres:
+		if strings.TrimSpace(storage.DSN) == "" {
+			return errors.New("storage.dsn must be provided for the postgres backend")
+		}
+	default:
+		return fmt.Errorf("storage.backend %q must be one of %q, %q, %q or %q", storage.Backend, StorageBackendMemory, StorageBackendFile, StorageBackendSQLite, StorageBackendPostgres)
+	}
+	return nil
+}
+
+func validateObservability(obs ObservabilityConfig) error {
+	switch obs.LogLevel {
+	case "", LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+	default:
+		return fmt.Errorf("observability.log_level %q must be one of %q, %q, %q or %q", obs.LogLevel, LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError)
+	}
+	if rate := obs.Sampling.SampleRate(); rate < 0 || rate > 1 {
+		return fmt.Errorf("observability.sampling.rate must be between 0 and 1, got %g", rate)
+	}
+
+	metrics := obs.Metrics
+	switch metrics.KeyLabel {
+	case "", MetricsKeyLabelNone, MetricsKeyLabelPrefix, MetricsKeyLabelHash:
+	default:
+		return fmt.Errorf("observability.metrics.key_label %q must be one of %q, %q or %q", metrics.KeyLabel, MetricsKeyLabelNone, MetricsKeyLabelPrefix, MetricsKeyLabelHash)
+	}
+	if metrics.KeyPrefixLength < 0 || metrics.KeyPrefixLength > 16 {
+		return fmt.Errorf("observability.metrics.key_prefix_length must be between 0 and 16, got %d", metrics.KeyPrefixLength)
+	}
+	if metrics.MaxSeries < 0 {
+		return fmt.Errorf("observability.metrics.max_series must not be negative, got %d", metrics.MaxSeries)
+	}
+	for i, model := range metrics.Models {
+		if strings.TrimSpace(model) == "" {
+			return fmt.Errorf("observability.metrics.models[%d] must not be empty", i)
+		}
+	}
+
+	tracing := obs.Tracing
+	if tracing.Enabled {
+		endpoint, err := url.Parse(tracing.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("observability.tracing.endpoint %q must be an http(s) URL", tracing.Endpoint)
+		}
+	}
+	if tracing.Timeout < 0 {
+		return fmt.Errorf("observability.tracing.timeout must not be negative, got %s", tracing.Timeout)
+	}
+	return nil
+}
+
+func validateKeys(keys map[string]ClientKeyConfig, providers map[string]ProviderConfig) error {
+	for key, keyCfg := range keys {
+		if strings.TrimSpace(key) == "" {
+			return errors.New("keys: key must not be empty")
+		}
+		for name := range keyCfg.Attribution {
+			if _, ok := providers[name]; !ok {
+				return fmt.Errorf("keys: attribution references unknown provider %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateTokenizers(tokenizers []TokenizerConfig) error {
+	seen := make(map[string]bool, len(tokenizers))
+	for i, t := range tokenizers {
+		if strings.TrimSpace(t.Name) == "" {
+			return fmt.Errorf("tokenizers[%d]: name must not be empty", i)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("tokenizers: duplicate tokenizer %q", t.Name)
+		}
+		seen[t.Name] = true
+		if strings.TrimSpace(t.File) == "" {
+			return fmt.Errorf("tokenizers[%s]: file must be provided", t.Name)
+		}
+		if len(t.Models) == 0 {
+			return fmt.Errorf("tokenizers[%s]: at least one models pattern must be listed", t.Name)
+		}
+		for _, pattern := range t.Models {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("tokenizers[%s]: invalid models pattern %q", t.Name, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+func validateTags(tags TagsConfig) error {
+	for _, name := range tags.Allowed {
+		if !ValidTagName(name) {
+			return fmt.Errorf("tags.allowed: invalid tag name %q", name)
+		}
+	}
+	for _, name := range tags.Metrics {
+		if !ValidTagName(name) {
+			return fmt.Errorf("tags.metrics: invalid tag name %q", name)
+		}
+		if !tags.Accepts(name) {
+			return fmt.Errorf("tags.metrics: tag %q is not in tags.allowed", name)
+		}
+	}
+	return nil
+}
+
+func validateAlerts(alerts AlertsConfig, usage UsageConfig, keys map[string]ClientKeyConfig, providers map[string]ProviderConfig) error {
+	if !alerts.Enabled {
+		return nil
+	}
+	if strings.TrimSpace(alerts.WebhookURL) == "" {
+		return errors.New("alerts.webhook_url must be provided when alerts are enabled")
+	}
+	if alerts.Interval < 0 || alerts.Cooldown < 0 {
+		return errors.New("alerts.interval and alerts.cooldown must not be negative")
+	}
+	if (len(alerts.Spend) > 0 || alerts.Anomaly.Factor > 0) && !usage.Enabled {
+		return errors.New("alerts.spend and alerts.anomaly need usage.enabled")
+	}
+	for i, rule := range alerts.Spend {
+		name := rule.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		switch rule.AlertPeriod() {
+		case AlertPeriodHour, AlertPeriodDay:
+		default:
+			return fmt.Errorf("alerts.spend[%s]: period %q must be %q or %q", name, rule.Period, AlertPeriodHour, AlertPeriodDay)
+		}
+		if rule.Cost <= 0 && rule.Tokens <= 0 {
+			return fmt.Errorf("alerts.spend[%s]: cost or tokens must be positive", name)
+		}
+		if rule.Key != "" {
+			if _, ok := keys[rule.Key]; !ok {
+				return fmt.Errorf("alerts.spend[%s]: key is not listed under keys", name)
+			}
+		}
+		if rule.Provider != "" {
+			if _, ok := providers[rule.Provider]; !ok {
+				return fmt.Errorf("alerts.spend[%s]: unknown provider %q", name, rule.Provider)
+			}
+		}
+	}
+	if rate := alerts.ErrorRate; rate.Threshold < 0 || rate.Threshold > 1 {
+		return fmt.Errorf("alerts.error_rate.threshold must be between 0 and 1, got %v", rate.Threshold)
+	}
+	if alerts.ErrorRate.WindowLength() > time.Hour {
+		return fmt.Errorf("alerts.error_rate.window must be at most 1h, got %s", alerts.ErrorRate.Window)
+	}
+	if alerts.Anomaly.Factor < 0 || (alerts.Anomaly.Factor > 0 && alerts.Anomaly.Factor <= 1) {
+		return fmt.Errorf("alerts.anomaly.factor must be greater than 1, got %v", alerts.Anomaly.Factor)
+	}
+	return nil
+}
+
+func validateExperiment(experiment ExperimentConfig, routing RoutingConfig) error {
+	switch experiment.Bucket() {
+	case ExperimentBucketUser, ExperimentBucketKey:
+	default:
+		return fmt.Errorf("bucket_by %q must be %q or %q", experiment.BucketBy, ExperimentBucketUser, ExperimentBucketKey)
+	}
+	if len(experiment.Arms) < 2 {
+		return errors.New("at least two arms must be configured")
+	}
+	seen := make(map[string]bool, len(experiment.Arms))
+	var total float64
+	for i, arm := range experiment.Arms {
+		if !ValidTagName(arm.Name) {
+			return fmt.Errorf("arms[%d]: name %q must be 1 to 64 letters, digits, '_', '-' or '.'", i, arm.Name)
+		}
+		if seen[arm.Name] {
+			return fmt.Errorf("duplicate arm %s", arm.Name)
+		}
+		seen[arm.Name] = true
+		if strings.TrimSpace(arm.Model) == "" {
+			return fmt.Errorf("arm %s: model must not be empty", arm.Name)
+		}
+		// Arms may route to other policies, but not to schedules or other
+		// experiments, which keeps resolution from looping.
+		if _, ok := routing.Experiments[arm.Model]; ok {
+			return fmt.Errorf("arm %s: model %q is itself an experiment", arm.Name, arm.Model)
+		}
+		if _, ok := routing.Schedules[arm.Model]; ok {
+			return fmt.Errorf("arm %s: model %q is a schedule", arm.Name, arm.Model)
+		}
+		if arm.Percent <= 0 {
+			return fmt.Errorf("arm %s: percent must be positive", arm.Name)
+		}
+		total += arm.Percent
+	}
+	if math.Abs(total-100) > 1e-9 {
+		return fmt.Errorf("arm percentages must add up to 100, got %v", total)
+	}
+	return nil
+}
+
+func validateBalancer(balancer BalancerConfig) error {
+	if len(balancer.Targets) == 0 {
+		return errors.New("at least one target must be configured")
+	}
+	seen := make(map[string]bool, len(balancer.Targets))
+	for _, target := range balancer.Targets {
+		if strings.TrimSpace(target.Model) == "" {
+			return errors.New("target model must not be empty")
+		}
+		if seen[target.Model] {
+			return fmt.Errorf("duplicate target %s", target.Model)
+		}
+		seen[target.Model] = true
+		if target.Weight < 0 {
+			return fmt.Errorf("target %s: weight must not be negative", target.Model)
+		}
+	}
+	adaptive := balancer.Adaptive
+	if adaptive.HalfLife < 0 || adaptive.MinSamples < 0 || adaptive.Floor < 0 || adaptive.Ceiling < 0 {
+		return errors.New("adaptive settings must not be negative")
+	}
+	if floor, ceiling := adaptive.Bounds(); floor > 1 || ceiling < 1 {
+		return fmt.Errorf("adaptive floor (%v) must be at most 1 and ceiling (%v) at least 1", floor, ceiling)
+	}
+	return nil
+}
+
+func validateModelList(kind, name string, modelIDs []string) error {
+	if len(modelIDs) == 0 {
+		return fmt.Errorf("routing %s %s: at least one model must be configured", kind, name)
+	}
+	for _, model := range modelIDs {
+		if strings.TrimSpace(model) == "" {
+			return fmt.Errorf("routing %s %s: model must not be empty", kind, name)
+		}
+	}
 	return nil
 }
 
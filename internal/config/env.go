@@ -0,0 +1,95 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envReference matches ${NAME} in a config value. $${NAME} is kept as the
+// literal text ${NAME}.
+var envReference = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// expandEnv replaces ${NAME} references in the scalar values of a parsed
+// document with the environment. Keys and comments are left alone. Every
+// unset or malformed reference is reported together, by line.
+func expandEnv(node *yaml.Node) error {
+	var problems []string
+	var walk func(n *yaml.Node)
+	walk = func(n *yaml.Node) {
+		switch n.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, child := range n.Content {
+				walk(child)
+			}
+		case yaml.MappingNode:
+			for i := 1; i < len(n.Content); i += 2 {
+				walk(n.Content[i])
+			}
+		case yaml.AliasNode:
+			// The anchored value is expanded where it is defined.
+		case yaml.ScalarNode:
+			if !strings.Contains(n.Value, "${") {
+				return
+			}
+			value := envReference.ReplaceAllStringFunc(n.Value, func(ref string) string {
+				if strings.HasPrefix(ref, "$$") {
+					return ref[1:]
+				}
+				name := ref[2 : len(ref)-1]
+				if !envName.MatchString(name) {
+					problems = append(problems, fmt.Sprintf("line %d: %q is not a valid environment variable reference", n.Line, ref))
+					return ref
+				}
+				v, ok := os.LookupEnv(name)
+				if !ok {
+					problems = append(problems, fmt.Sprintf("line %d: environment variable %s is not set", n.Line, name))
+				}
+				return v
+			})
+			if value == n.Value {
+				return
+			}
+			n.Value = value
+			// A plain scalar is typed by what it expands to, so
+			// port: ${PORT} decodes as a number.
+			if n.Style&(yaml.TaggedStyle|yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+				n.Tag = ""
+			}
+		}
+	}
+	walk(node)
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// resolveAPIKeyEnv reads the api_key of providers that name an environment
+// variable in api_key_env instead.
+func resolveAPIKeyEnv(cfg *Config) error {
+	for name, provider := range cfg.Providers.Upstreams {
+		if provider.APIKeyEnv == "" {
+			continue
+		}
+		if provider.APIKey != "" {
+			return fmt.Errorf("provider %s: set only one of api_key and api_key_env", name)
+		}
+		key, ok := os.LookupEnv(provider.APIKeyEnv)
+		if !ok {
+			return fmt.Errorf("provider %s: api_key_env: environment variable %s is not set", name, provider.APIKeyEnv)
+		}
+		if strings.TrimSpace(key) == "" {
+			return fmt.Errorf("provider %s: api_key_env: environment variable %s is empty", name, provider.APIKeyEnv)
+		}
+		provider.APIKey = key
+		cfg.Providers.Upstreams[name] = provider
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const maxRemoteConfigBytes = 4 << 20 // 4 MiB
+
+// Remote fetches configuration from a shared HTTP(S) source such as an
+// object storage URL or a Consul KV key (`/v1/kv/<key>?raw`). Every instance
+// pointed at the same source converges on the same version.
+type Remote struct {
+	url    string
+	client *http.Client
+
+	etag    string
+	version string
+}
+
+// NewRemote constructs a remote source for url.
+func NewRemote(url string, client *http.Client) (*Remote, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("config url %q must use http or https", url)
+	}
+	if client == nil {
+		return nil, errors.New("http client must not be nil")
+	}
+	return &Remote{url: url, client: client}, nil
+}
+
+// Version returns the version of the last configuration fetched.
+func (r *Remote) Version() string {
+	return r.version
+}
+
+// Fetch downloads the configuration. It reports changed=false, with a zero
+// Config, when the source still serves the version fetched last time.
+func (r *Remote) Fetch(ctx context.Context) (cfg Config, changed bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return Config{}, false, fmt.Errorf("construct config request: %w", err)
+	}
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return Config{}, false, fmt.Errorf("fetch config %q: %w", r.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return Config{}, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Config{}, false, fmt.Errorf("fetch config %q: unexpected status %d", r.url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigBytes+1))
+	if err != nil {
+		return Config{}, false, fmt.Errorf("read config %q: %w", r.url, err)
+	}
+	if len(data) > maxRemoteConfigBytes {
+		return Config{}, false, fmt.Errorf("config %q exceeds %d bytes", r.url, maxRemoteConfigBytes)
+	}
+
+	version := remoteVersion(resp.Header, data)
+	if version == r.version {
+		return Config{}, false, nil
+	}
+
+	cfg, err = Parse(data, r.url)
+	if err != nil {
+		return Config{}, false, err
+	}
+
+	r.etag = resp.Header.Get("ETag")
+	r.version = version
+	return cfg, true, nil
+}
+
+// remoteVersion prefers the source's ETag and falls back to a content hash,
+// so sources without ETags (such as Consul) are still tracked.
+func remoteVersion(header http.Header, data []byte) string {
+	if etag := strings.Trim(header.Get("ETag"), `"`); etag != "" {
+		return etag
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
@@ -0,0 +1,463 @@
+// Package expr implements the small expression language used by config
+// hooks to match requests, such as
+//
+//	model == "gpt-4" && key != "anonymous" && options.temperature > 0.5
+//
+// Expressions combine literals (strings, numbers, true, false, null) and
+// variables with ==, !=, <, <=, >, >=, contains, startsWith, endsWith,
+// matches (a regular expression), !, && and ||. Dotted variables look up
+// nested maps; a missing variable evaluates to null.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Program is a compiled expression.
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses src into a program.
+func Compile(src string) (*Program, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("expression %q: unexpected %q", src, tok.text)
+	}
+	return &Program{source: src, root: root}, nil
+}
+
+// String returns the source the program was compiled from.
+func (p *Program) String() string {
+	return p.source
+}
+
+// Eval evaluates the program against vars.
+func (p *Program) Eval(vars map[string]any) (any, error) {
+	return p.root.eval(vars)
+}
+
+// Bool evaluates the program and requires a boolean result.
+func (p *Program) Bool(vars map[string]any) (bool, error) {
+	v, err := p.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q: result is %T, not a boolean", p.source, v)
+	}
+	return b, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+var symbolOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")"})
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(src) && rune(src[end]) != c {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, errors.New("unterminated string")
+			}
+			text := src[i : end+1]
+			if c == '\'' {
+				text = `"` + strings.ReplaceAll(text[1:len(text)-1], `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", src[i:end+1])
+			}
+			tokens = append(tokens, token{kind: tokString, text: value})
+			i = end + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(src) && unicode.IsDigit(rune(src[i+1]))):
+			end := i + 1
+			for end < len(src) && (unicode.IsDigit(rune(src[end])) || src[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[i:end]})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i + 1
+			for end < len(src) && (unicode.IsLetter(rune(src[end])) || unicode.IsDigit(rune(src[end])) || src[end] == '_' || src[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[i:end]})
+			i = end
+		default:
+			matched := false
+			for _, op := range symbolOps {
+				if strings.HasPrefix(src[i:], op) {
+					tokens = append(tokens, token{kind: tokOp, text: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// wordOps are comparison operators spelled as identifiers.
+var wordOps = map[string]bool{"contains": true, "startsWith": true, "endsWith": true, "matches": true}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == "!" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	isSymbol := tok.kind == tokOp && tok.text != "&&" && tok.text != "||" && tok.text != "!"
+	isWord := tok.kind == tokIdent && wordOps[tok.text]
+	if !isSymbol && !isWord {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	cmp := compareNode{op: tok.text, left: left, right: right}
+	if tok.text == "matches" {
+		lit, ok := right.(literalNode)
+		pattern, isString := lit.value.(string)
+		if !ok || !isString {
+			return nil, errors.New("matches needs a string literal pattern")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		cmp.re = re
+	}
+	return cmp, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokRParen {
+			return nil, errors.New("missing )")
+		}
+		return inner, nil
+	case tokString:
+		return literalNode{value: tok.text}, nil
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", tok.text)
+		}
+		return literalNode{value: n}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null":
+			return literalNode{value: nil}, nil
+		}
+		if wordOps[tok.text] {
+			return nil, fmt.Errorf("unexpected %q", tok.text)
+		}
+		return varNode{path: strings.Split(tok.text, ".")}, nil
+	case tokEOF:
+		return nil, errors.New("unexpected end of expression")
+	default:
+		return nil, fmt.Errorf("unexpected %q", tok.text)
+	}
+}
+
+type node interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type literalNode struct{ value any }
+
+func (n literalNode) eval(map[string]any) (any, error) { return n.value, nil }
+
+type varNode struct{ path []string }
+
+func (n varNode) eval(vars map[string]any) (any, error) {
+	var current any = vars
+	for _, part := range n.path {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return nil, nil
+		}
+		current = m[part]
+	}
+	return normalize(current), nil
+}
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(vars map[string]any) (any, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! needs a boolean, got %T", v)
+	}
+	return !b, nil
+}
+
+type logicalNode struct {
+	and         bool
+	left, right node
+}
+
+func (n logicalNode) eval(vars map[string]any) (any, error) {
+	left, err := truthy(n.left, vars)
+	if err != nil {
+		return nil, err
+	}
+	if n.and != left {
+		// false && x and true || x short-circuit.
+		return left, nil
+	}
+	return truthy(n.right, vars)
+}
+
+func truthy(n node, vars map[string]any) (bool, error) {
+	v, err := n.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("&& and || need booleans, got %T", v)
+	}
+	return b, nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+	re          *regexp.Regexp
+}
+
+func (n compareNode) eval(vars map[string]any) (any, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "matches":
+		s, ok := left.(string)
+		return ok && n.re.MatchString(s), nil
+	case "contains", "startsWith", "endsWith":
+		s, ok := left.(string)
+		sub, subOK := right.(string)
+		if !ok || !subOK {
+			return false, nil
+		}
+		switch n.op {
+		case "contains":
+			return strings.Contains(s, sub), nil
+		case "startsWith":
+			return strings.HasPrefix(s, sub), nil
+		default:
+			return strings.HasSuffix(s, sub), nil
+		}
+	}
+
+	// Ordering comparisons need two numbers or two strings; anything else,
+	// including a missing variable, is simply false.
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false, nil
+		}
+		return order(n.op, compareFloats(l, r)), nil
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false, nil
+		}
+		return order(n.op, strings.Compare(l, r)), nil
+	}
+	return false, nil
+}
+
+// equal compares scalars; lists and maps never equal anything.
+func equal(a, b any) bool {
+	switch a.(type) {
+	case nil, bool, string, float64:
+	default:
+		return false
+	}
+	switch b.(type) {
+	case nil, bool, string, float64:
+	default:
+		return false
+	}
+	return a == b
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func order(op string, cmp int) bool {
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// normalize maps Go numeric types onto float64 so comparisons against
+// number literals behave.
+func normalize(v any) any {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
@@ -0,0 +1,125 @@
+// Package health tracks upstream availability from background probes.
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// scoreWeight is how much the latest probe moves the availability score.
+const scoreWeight = 0.3
+
+// Tracker keeps the probe history of every provider. It outlives config
+// reloads, so providers keep their state when the router is rebuilt.
+type Tracker struct {
+	mu        sync.RWMutex
+	providers map[string]*providerState
+}
+
+type providerState struct {
+	score     float64
+	failures  int
+	down      bool
+	lastProbe time.Time
+	lastError string
+	latency   time.Duration
+}
+
+// NewTracker returns a tracker that considers every provider available.
+func NewTracker() *Tracker {
+	return &Tracker{providers: make(map[string]*providerState)}
+}
+
+// Observe records the outcome of a probe. The provider goes down after
+// threshold failures in a row and comes back with the first success.
+func (t *Tracker) Observe(provider string, latency time.Duration, err error, threshold int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.providers[provider]
+	if !ok {
+		s = &providerState{score: 1}
+		t.providers[provider] = s
+	}
+	s.lastProbe = time.Now()
+	s.latency = latency
+	outcome := 1.0
+	if err != nil {
+		outcome = 0
+		s.failures++
+		s.lastError = err.Error()
+	} else {
+		s.failures = 0
+		s.lastError = ""
+	}
+	s.score = scoreWeight*outcome + (1-scoreWeight)*s.score
+	s.down = s.failures >= threshold
+}
+
+// Down reports whether probes found the provider unreachable. A nil
+// tracker, or one that never probed the provider, reports it up.
+func (t *Tracker) Down(provider string) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	s, ok := t.providers[provider]
+	return ok && s.down
+}
+
+// Reset forgets every provider's history, as when probing is turned off.
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.providers)
+}
+
+// Provider statuses: healthy when the latest probe succeeded, degraded
+// when it failed but too few failed in a row to mark the provider down.
+const (
+	StatusHealthy  = "healthy"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// State reports a provider's probe history.
+type State struct {
+	Provider string `json:"provider"`
+	Status   string `json:"status"`
+	// Score is an exponentially weighted share of successful probes.
+	Score     float64   `json:"score"`
+	Down      bool      `json:"down"`
+	Failures  int       `json:"consecutive_failures"`
+	LastProbe time.Time `json:"last_probe"`
+	LatencyMS int64     `json:"latency_ms"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// States reports every probed provider, sorted by name.
+func (t *Tracker) States() []State {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make([]State, 0, len(t.providers))
+	for name, s := range t.providers {
+		status := StatusHealthy
+		switch {
+		case s.down:
+			status = StatusDown
+		case s.failures > 0:
+			status = StatusDegraded
+		}
+		out = append(out, State{
+			Provider:  name,
+			Status:    status,
+			Score:     s.score,
+			Down:      s.down,
+			Failures:  s.failures,
+			LastProbe: s.lastProbe,
+			LatencyMS: s.latency.Milliseconds(),
+			LastError: s.lastError,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Provider < out[j].Provider })
+	return out
+}
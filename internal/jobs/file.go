@@ -0,0 +1,221 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// compactSlack is how many superseded journal entries are tolerated before
+// the journal is rewritten.
+const compactSlack = 1024
+
+// journalEntry is one line of the job journal.
+type journalEntry struct {
+	Job    *Job   `json:"job,omitempty"`
+	Delete string `json:"delete,omitempty"`
+}
+
+// FileStore keeps jobs in memory and records every change in an
+// append-only journal that is synced to disk before the change is
+// acknowledged. The journal is replayed on open, so a crash loses at most a
+// partially written final entry.
+type FileStore struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	jobs    map[string]Job
+	entries int
+}
+
+// OpenFileStore opens or creates the journal at path.
+func OpenFileStore(path string) (*FileStore, error) {
+	if path == "" {
+		return nil, errors.New("jobs journal path must not be empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create jobs directory: %w", err)
+	}
+
+	s := &FileStore{path: path, jobs: make(map[string]Job)}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save implements Store.
+func (s *FileStore) Save(_ context.Context, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(journalEntry{Job: &job}); err != nil {
+		return err
+	}
+	s.jobs[job.ID] = job
+	return s.maybeCompact()
+}
+
+// Get implements Store.
+func (s *FileStore) Get(_ context.Context, id string) (Job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	return job, ok, nil
+}
+
+// List implements Store.
+func (s *FileStore) List(_ context.Context, statuses ...Status) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return filterJobs(s.jobs, statuses), nil
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(_ context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := s.jobs[id]; !ok {
+			continue
+		}
+		if err := s.append(journalEntry{Delete: id}); err != nil {
+			return err
+		}
+		delete(s.jobs, id)
+	}
+	return s.maybeCompact()
+}
+
+// Close implements Store.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileStore) replay() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open jobs journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn write from a crash leaves an unreadable final entry;
+			// anything before it is intact.
+			slog.Warn("skipping unreadable jobs journal entry", "path", s.path, "line", line, "error", err)
+			continue
+		}
+		switch {
+		case entry.Job != nil:
+			s.jobs[entry.Job.ID] = *entry.Job
+		case entry.Delete != "":
+			delete(s.jobs, entry.Delete)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read jobs journal: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) append(entry journalEntry) error {
+	if s.file == nil {
+		return errors.New("jobs store is closed")
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshal jobs journal entry: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("write jobs journal: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("sync jobs journal: %w", err)
+	}
+	s.entries++
+	return nil
+}
+
+func (s *FileStore) maybeCompact() error {
+	if s.entries <= 2*len(s.jobs)+compactSlack {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact rewrites the journal with one entry per live job. The new journal
+// is written beside the old one and renamed over it, so a crash mid-compaction
+// leaves the previous journal in place.
+func (s *FileStore) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("create jobs journal: %w", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for _, job := range filterJobs(s.jobs, nil) {
+		data, err := json.Marshal(journalEntry{Job: &job})
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("marshal jobs journal entry: %w", err)
+		}
+		writer.Write(data)
+		writer.WriteByte('\n')
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write jobs journal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync jobs journal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close jobs journal: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace jobs journal: %w", err)
+	}
+	if dir, err := os.Open(filepath.Dir(s.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		s.file = nil
+		return fmt.Errorf("open jobs journal: %w", err)
+	}
+	s.file = file
+	s.entries = len(s.jobs)
+	return nil
+}
@@ -0,0 +1,401 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	pruneInterval = 10 * time.Minute
+	retryDelay    = 2 * time.Second
+)
+
+// Handler executes a job payload and returns its result.
+type Handler func(ctx context.Context, payload json.RawMessage) (json.RawMessage, error)
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// Options tunes a Runner.
+type Options struct {
+	Workers     int
+	MaxAttempts int
+	Retention   time.Duration
+}
+
+// Runner executes jobs from a Store with a fixed pool of workers. Jobs left
+// queued or running by a previous process are resumed when Run starts.
+type Runner struct {
+	store Store
+	opts  Options
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	pending  []string
+	waiters  map[string][]chan struct{}
+	wake     chan struct{}
+	now      func() time.Time
+
+	// submitMu serialises SubmitWithID so two requests with the same ID
+	// cannot both create the job.
+	submitMu sync.Mutex
+}
+
+// NewRunner constructs a runner over store.
+func NewRunner(store Store, opts Options) (*Runner, error) {
+	if store == nil {
+		return nil, errors.New("job store must not be nil")
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+	return &Runner{
+		store:    store,
+		opts:     opts,
+		handlers: make(map[string]Handler),
+		waiters:  make(map[string][]chan struct{}),
+		wake:     make(chan struct{}, 1),
+		now:      time.Now,
+	}, nil
+}
+
+// Handle registers the handler for a job kind. Handlers must be registered
+// before Run is called.
+func (r *Runner) Handle(kind string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[kind] = handler
+}
+
+// Submit persists a new job and queues it for execution.
+func (r *Runner) Submit(ctx context.Context, kind string, payload json.RawMessage) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+	job, _, err := r.SubmitWithID(ctx, id, kind, payload)
+	return job, err
+}
+
+// SubmitWithID queues a job under a caller-chosen ID. If a job with that ID
+// already exists it is returned unchanged with created=false, which lets
+// clients retry a request without running it twice.
+func (r *Runner) SubmitWithID(ctx context.Context, id, kind string, payload json.RawMessage) (job Job, created bool, err error) {
+	r.submitMu.Lock()
+	defer r.submitMu.Unlock()
+
+	existing, ok, err := r.store.Get(ctx, id)
+	if err != nil {
+		return Job{}, false, fmt.Errorf("load job: %w", err)
+	}
+	if ok {
+		return existing, false, nil
+	}
+
+	now := r.now()
+	job = Job{
+		ID:        id,
+		Kind:      kind,
+		Payload:   payload,
+		Status:    StatusQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := r.store.Save(ctx, job); err != nil {
+		return Job{}, false, fmt.Errorf("save job: %w", err)
+	}
+	r.enqueue(job.ID)
+	return job, true, nil
+}
+
+// Await blocks until the job finishes or ctx is done, and returns its
+// latest state either way.
+func (r *Runner) Await(ctx context.Context, id string) (Job, error) {
+	done := make(chan struct{})
+	r.mu.Lock()
+	r.waiters[id] = append(r.waiters[id], done)
+	r.mu.Unlock()
+	defer r.dropWaiter(id, done)
+
+	// Check after registering so a job finishing in between is not missed.
+	job, ok, err := r.store.Get(ctx, id)
+	if err != nil {
+		return Job{}, err
+	}
+	if !ok {
+		return Job{}, fmt.Errorf("job %s not found", id)
+	}
+	if job.Finished() {
+		return job, nil
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	job, _, err = r.store.Get(context.WithoutCancel(ctx), id)
+	return job, err
+}
+
+func (r *Runner) dropWaiter(id string, done chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	waiters := r.waiters[id]
+	for i, ch := range waiters {
+		if ch == done {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(r.waiters, id)
+		return
+	}
+	r.waiters[id] = waiters
+}
+
+func (r *Runner) notify(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ch := range r.waiters[id] {
+		close(ch)
+	}
+	delete(r.waiters, id)
+}
+
+// Get returns a job by ID.
+func (r *Runner) Get(ctx context.Context, id string) (Job, bool, error) {
+	return r.store.Get(ctx, id)
+}
+
+// Run resumes unfinished jobs and processes the queue until ctx is
+// cancelled, then closes the store. Jobs interrupted by shutdown stay
+// running in the store and are picked up again by the next process.
+func (r *Runner) Run(ctx context.Context) error {
+	if err := r.resume(ctx); err != nil {
+		r.store.Close()
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work(ctx)
+		}()
+	}
+
+	r.prune(ctx)
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return r.store.Close()
+		case <-ticker.C:
+			r.prune(ctx)
+		}
+	}
+}
+
+func (r *Runner) resume(ctx context.Context) error {
+	unfinished, err := r.store.List(ctx, StatusQueued, StatusRunning)
+	if err != nil {
+		return fmt.Errorf("list unfinished jobs: %w", err)
+	}
+
+	resumed := 0
+	for _, job := range unfinished {
+		if job.Status == StatusRunning {
+			job.UpdatedAt = r.now()
+			if job.Attempts >= r.opts.MaxAttempts {
+				job.Status = StatusFailed
+				job.Error = "interrupted by restart after final attempt"
+			} else {
+				job.Status = StatusQueued
+			}
+			if err := r.store.Save(ctx, job); err != nil {
+				return fmt.Errorf("requeue job %s: %w", job.ID, err)
+			}
+			if job.Finished() {
+				continue
+			}
+		}
+		r.enqueue(job.ID)
+		resumed++
+	}
+	if resumed > 0 {
+		slog.Info("resumed unfinished jobs", "count", resumed)
+	}
+	return nil
+}
+
+func (r *Runner) enqueue(id string) {
+	r.mu.Lock()
+	r.pending = append(r.pending, id)
+	r.mu.Unlock()
+	r.signal()
+}
+
+func (r *Runner) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Runner) next() (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) == 0 {
+		return "", false
+	}
+	id := r.pending[0]
+	r.pending = r.pending[1:]
+	if len(r.pending) > 0 {
+		r.signal()
+	}
+	return id, true
+}
+
+func (r *Runner) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		}
+		for ctx.Err() == nil {
+			id, ok := r.next()
+			if !ok {
+				break
+			}
+			r.execute(ctx, id)
+		}
+	}
+}
+
+func (r *Runner) execute(ctx context.Context, id string) {
+	job, ok, err := r.store.Get(ctx, id)
+	if err != nil {
+		slog.Warn("job lookup failed", "job", id, "error", err)
+		return
+	}
+	if !ok || job.Finished() {
+		return
+	}
+
+	r.mu.Lock()
+	handler, ok := r.handlers[job.Kind]
+	r.mu.Unlock()
+	if !ok {
+		r.finish(ctx, job, nil, fmt.Errorf("no handler for job kind %q", job.Kind))
+		return
+	}
+
+	job.Status = StatusRunning
+	job.Attempts++
+	job.UpdatedAt = r.now()
+	if err := r.store.Save(ctx, job); err != nil {
+		slog.Warn("job state update failed", "job", job.ID, "error", err)
+		return
+	}
+
+	result, err := handler(ctx, job.Payload)
+	if err != nil && ctx.Err() != nil {
+		// Shutting down: leave the job running so the next process resumes it.
+		return
+	}
+
+	var permanent permanentError
+	if err != nil && !errors.As(err, &permanent) && job.Attempts < r.opts.MaxAttempts {
+		slog.Warn("job attempt failed, retrying", "job", job.ID, "kind", job.Kind, "attempt", job.Attempts, "error", err)
+		job.Status = StatusQueued
+		job.Error = err.Error()
+		job.UpdatedAt = r.now()
+		if err := r.store.Save(ctx, job); err != nil {
+			slog.Warn("job state update failed", "job", job.ID, "error", err)
+			return
+		}
+		time.AfterFunc(retryDelay*time.Duration(job.Attempts), func() { r.enqueue(job.ID) })
+		return
+	}
+	r.finish(ctx, job, result, err)
+}
+
+func (r *Runner) finish(ctx context.Context, job Job, result json.RawMessage, err error) {
+	job.UpdatedAt = r.now()
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		slog.Warn("job failed", "job", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", err)
+	} else {
+		job.Status = StatusSucceeded
+		job.Result = result
+		job.Error = ""
+	}
+	if err := r.store.Save(ctx, job); err != nil {
+		slog.Warn("job state update failed", "job", job.ID, "error", err)
+	}
+	r.notify(job.ID)
+}
+
+// prune deletes finished jobs older than the retention period.
+func (r *Runner) prune(ctx context.Context) {
+	if r.opts.Retention <= 0 {
+		return
+	}
+	finished, err := r.store.List(ctx, StatusSucceeded, StatusFailed)
+	if err != nil {
+		slog.Warn("job pruning failed", "error", err)
+		return
+	}
+
+	cutoff := r.now().Add(-r.opts.Retention)
+	var expired []string
+	for _, job := range finished {
+		if job.UpdatedAt.Before(cutoff) {
+			expired = append(expired, job.ID)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	if err := r.store.Delete(ctx, expired...); err != nil {
+		slog.Warn("job pruning failed", "error", err)
+		return
+	}
+	slog.Debug("pruned finished jobs", "count", len(expired))
+}
+
+func newJobID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate job id: %w", err)
+	}
+	return "job_" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,144 @@
+// Package jobs runs asynchronous work and persists it so queued and
+// in-flight jobs survive a restart.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+// Status is the lifecycle state of a job.
+type Status string
+
+// Job states.
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Job is a unit of asynchronous work.
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    Status          `json:"status"`
+	Attempts  int             `json:"attempts"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// Finished reports whether the job reached a terminal state.
+func (j Job) Finished() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed
+}
+
+// Store persists jobs.
+type Store interface {
+	// Save inserts or replaces a job.
+	Save(ctx context.Context, job Job) error
+	// Get returns the job with the given ID and whether it exists.
+	Get(ctx context.Context, id string) (Job, bool, error)
+	// List returns jobs in one of the given states, oldest first. With no
+	// states every job is returned.
+	List(ctx context.Context, statuses ...Status) ([]Job, error)
+	// Delete removes jobs by ID.
+	Delete(ctx context.Context, ids ...string) error
+	// Close releases resources held by the store.
+	Close() error
+}
+
+// NewStore builds the backend selected by the jobs configuration.
+func NewStore(cfg config.JobsConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", config.JobsBackendMemory:
+		return NewMemoryStore(), nil
+	case config.JobsBackendFile:
+		return OpenFileStore(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown jobs backend %q", cfg.Backend)
+	}
+}
+
+// MemoryStore keeps jobs in process memory. Jobs are lost on restart.
+type MemoryStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemoryStore constructs an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[string]Job)}
+}
+
+// Save implements Store.
+func (m *MemoryStore) Save(_ context.Context, job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[job.ID] = job
+	return nil
+}
+
+// Get implements Store.
+func (m *MemoryStore) Get(_ context.Context, id string) (Job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	return job, ok, nil
+}
+
+// List implements Store.
+func (m *MemoryStore) List(_ context.Context, statuses ...Status) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return filterJobs(m.jobs, statuses), nil
+}
+
+// Delete implements Store.
+func (m *MemoryStore) Delete(_ context.Context, ids ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		delete(m.jobs, id)
+	}
+	return nil
+}
+
+// Close implements Store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func filterJobs(jobs map[string]Job, statuses []Status) []Job {
+	out := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if len(statuses) == 0 || hasStatus(statuses, job.Status) {
+			out = append(out, job)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out
+}
+
+func hasStatus(statuses []Status, status Status) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
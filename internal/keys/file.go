@@ -0,0 +1,106 @@
+package keys
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore keeps keys in a JSON file, which is rewritten on every change.
+// Key sets are small and change rarely, so a journal isn't worth it.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	keys map[string]Key
+}
+
+// OpenFileStore opens or creates the keys file at path.
+func OpenFileStore(path string) (*FileStore, error) {
+	if path == "" {
+		return nil, errors.New("keys path must not be empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create keys directory: %w", err)
+	}
+
+	s := &FileStore{path: path, keys: make(map[string]Key)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read keys: %w", err)
+	}
+	var keys []Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("parse keys %q: %w", path, err)
+	}
+	for _, key := range keys {
+		s.keys[key.ID] = key
+	}
+	return s, nil
+}
+
+// Add implements Store.
+func (s *FileStore) Add(_ context.Context, key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, replaced := s.keys[key.ID]
+	s.keys[key.ID] = key
+	if err := s.save(); err != nil {
+		if replaced {
+			s.keys[key.ID] = previous
+		} else {
+			delete(s.keys, key.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.keys, id)
+	if err := s.save(); err != nil {
+		s.keys[id] = key
+		return false, err
+	}
+	return true, nil
+}
+
+// List implements Store.
+func (s *FileStore) List(context.Context) ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sorted(s.keys), nil
+}
+
+// Close implements Store.
+func (s *FileStore) Close() error { return nil }
+
+// save writes the keys beside the current file and renames it into place
+// so a crash never leaves a partial file.
+func (s *FileStore) save() error {
+	data, err := json.Marshal(sorted(s.keys))
+	if err != nil {
+		return fmt.Errorf("marshal keys: %w", err)
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("write keys: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("replace keys: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Package keys keeps the client keys issued through the admin API. Only a
+// hash of each key is stored, never the key itself.
+package keys
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key is an issued client key.
+type Key struct {
+	// ID is the key's usage ID, as shown in /v1/usage and /admin/keys.
+	ID string `json:"id"`
+	// Hash is the hex-encoded SHA-256 of the key.
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Hash returns the hex-encoded SHA-256 of a client key, as kept in Key.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store persists issued keys.
+type Store interface {
+	// Add records a key, replacing any with the same ID.
+	Add(ctx context.Context, key Key) error
+	// Delete removes the key with the given ID and reports whether there
+	// was one.
+	Delete(ctx context.Context, id string) (bool, error)
+	// List returns every key, oldest first.
+	List(ctx context.Context) ([]Key, error)
+	// Close releases resources held by the store.
+	Close() error
+}
+
+// MemoryStore keeps keys in process memory. They are lost on restart.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]Key
+}
+
+// NewMemoryStore constructs an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]Key)}
+}
+
+// Add implements Store.
+func (m *MemoryStore) Add(_ context.Context, key Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key.ID] = key
+	return nil
+}
+
+// Delete implements Store.
+func (m *MemoryStore) Delete(_ context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.keys[id]
+	delete(m.keys, id)
+	return ok, nil
+}
+
+// List implements Store.
+func (m *MemoryStore) List(context.Context) ([]Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sorted(m.keys), nil
+}
+
+// Close implements Store.
+func (m *MemoryStore) Close() error { return nil }
+
+func sorted(keys map[string]Key) []Key {
+	out := make([]Key, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, key)
+	}
+	slices.SortFunc(out, func(a, b Key) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return out
+}
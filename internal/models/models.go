@@ -1,10 +1,79 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // Message represents a single conversational message in the unified schema.
 type Message struct {
 	Role    string
 	Content string
 	Name    string
+	// ToolCalls are the tools an assistant message calls; its Content may
+	// then be empty.
+	ToolCalls []ToolCall
+	// ToolCallID ties a message with the tool role to the call it answers.
+	ToolCallID string
+	// Parts holds the text and images of a message with images, in order;
+	// Content is then its text. Text-only messages leave it empty.
+	Parts []ContentPart
+	// CacheControl marks the end of the message as an Anthropic prompt
+	// cache breakpoint. Providers without prompt caching ignore it.
+	CacheControl *CacheControl
+}
+
+// CacheControl is an Anthropic cache_control marker: everything in the
+// prompt up to it is cached for TTL, or the provider's default when empty.
+type CacheControl struct {
+	Type string
+	TTL  string
+}
+
+// ContentPart is a piece of a message with images: either text or an
+// image.
+type ContentPart struct {
+	Text  string
+	Image *Image
+}
+
+// Image is an image in a message, either at URL or inline as base64 Data
+// of MediaType.
+type Image struct {
+	URL       string
+	MediaType string
+	Data      string
+	// Detail is OpenAI's resolution hint: low, high or auto.
+	Detail string
+}
+
+// HasImages reports whether the message carries images.
+func (m Message) HasImages() bool {
+	for _, part := range m.Parts {
+		if part.Image != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ToolCall is a call of a client-defined tool. Arguments is the JSON
+// encoded input.
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// ToolDefinition is a tool the model may call. Parameters is the JSON
+// schema of its input.
+type ToolDefinition struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+	// CacheControl makes the tool definitions up to this one a prompt
+	// cache breakpoint.
+	CacheControl *CacheControl
 }
 
 // UnifiedChatRequest is the canonical representation of a chat completion.
@@ -12,7 +81,11 @@ type UnifiedChatRequest struct {
 	Model    string
 	Messages []Message
 	Stream   bool
-	Options  map[string]any
+	// Tools are the tools the model may call. How it may call them, the
+	// tool_choice and parallel_tool_calls options, is in Options in OpenAI
+	// form.
+	Tools   []ToolDefinition
+	Options map[string]any
 }
 
 // UnifiedChatResponse captures a provider response in the unified schema.
@@ -21,6 +94,132 @@ type UnifiedChatResponse struct {
 	Usage        Usage
 	FinishReason string
 	ID           string
+	// Model is the model identifier reported by the upstream, which may be
+	// more specific than the requested ID (e.g. a dated snapshot).
+	Model string
+	// Metadata holds router-generated annotations surfaced to clients.
+	Metadata map[string]any
+	// Citations tie spans of Message.Content to their sources.
+	Citations []Citation
+	// ServerTools lists tools the upstream ran on its own, such as web
+	// search, in the order they were called.
+	ServerTools []ServerToolCall
+	// ExtraChoices holds the answers after the first, in order, when the
+	// request asked for more than one. The first is Message.
+	ExtraChoices []Choice
+}
+
+// Choice is one answer to a request for several.
+type Choice struct {
+	Message      Message
+	FinishReason string
+}
+
+// Citation attributes the characters [Start, End) of the message content
+// to a source.
+type Citation struct {
+	// Type is the upstream citation kind, e.g. web_search_result_location
+	// or char_location.
+	Type      string
+	URL       string
+	Title     string
+	CitedText string
+	Start     int
+	End       int
+}
+
+// ServerToolCall is a tool invocation the upstream executed itself. Input
+// and Result are kept in the upstream's own encoding.
+type ServerToolCall struct {
+	ID         string
+	Name       string
+	Input      json.RawMessage
+	ResultType string
+	Result     json.RawMessage
+}
+
+// Annotate attaches a router annotation to the response.
+func (r *UnifiedChatResponse) Annotate(key string, value any) {
+	if r.Metadata == nil {
+		r.Metadata = make(map[string]any)
+	}
+	r.Metadata[key] = value
+}
+
+// AddChunk adds a chunk of a stream to the response being assembled from
+// it.
+func (r *UnifiedChatResponse) AddChunk(c UnifiedChatChunk) {
+	if r.ID == "" {
+		r.ID, r.Model = c.ID, c.Model
+	}
+	if c.Usage != nil {
+		r.Usage = *c.Usage
+	}
+	message, finishReason := &r.Message, &r.FinishReason
+	if c.Index > 0 {
+		for len(r.ExtraChoices) < c.Index {
+			r.ExtraChoices = append(r.ExtraChoices, Choice{})
+		}
+		choice := &r.ExtraChoices[c.Index-1]
+		message, finishReason = &choice.Message, &choice.FinishReason
+	}
+	if c.Role != "" {
+		message.Role = c.Role
+	}
+	message.Content += c.Content
+	for _, call := range c.ToolCalls {
+		for len(message.ToolCalls) <= call.Index {
+			message.ToolCalls = append(message.ToolCalls, ToolCall{})
+		}
+		toolCall := &message.ToolCalls[call.Index]
+		if call.ID != "" {
+			toolCall.ID = call.ID
+		}
+		if call.Name != "" {
+			toolCall.Name = call.Name
+		}
+		toolCall.Arguments += call.Arguments
+	}
+	if c.FinishReason != "" {
+		*finishReason = c.FinishReason
+	}
+}
+
+// UnifiedChatChunk is an increment of a streamed chat response, the one
+// streaming contract shared by providers, the router and the translators.
+// Content and tool call arguments are appended to the choice at Index; the
+// other fields are set when known.
+type UnifiedChatChunk struct {
+	ID    string
+	Model string
+	Index int
+	// Role is set on the first chunk of a choice.
+	Role    string
+	Content string
+	// ToolCalls carry tool calls as they are generated.
+	ToolCalls []ToolCallChunk
+	// FinishReason is set on the last chunk of a choice.
+	FinishReason string
+	// Usage is set once the upstream reports it, usually at the end.
+	Usage *Usage
+}
+
+// ToolCallChunk is part of a tool call. The first chunk of a call has its
+// ID and Name; later ones with the same Index add to its Arguments, a JSON
+// document sent in pieces.
+type ToolCallChunk struct {
+	Index     int
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// UnifiedChatStream delivers a chat response as it is generated. Recv
+// returns io.EOF after the last chunk. Close releases the upstream
+// connection and must be called even after an error.
+type UnifiedChatStream interface {
+	Recv() (UnifiedChatChunk, error)
+	Close() error
 }
 
 // UnifiedCompletionRequest represents a text completion style request.
@@ -39,6 +238,16 @@ type UnifiedCompletionResponse struct {
 	Usage        Usage
 	FinishReason string
 	ID           string
+	Model        string
+	Metadata     map[string]any
+}
+
+// Annotate attaches a router annotation to the response.
+func (r *UnifiedCompletionResponse) Annotate(key string, value any) {
+	if r.Metadata == nil {
+		r.Metadata = make(map[string]any)
+	}
+	r.Metadata[key] = value
 }
 
 // Usage records token accounting information.
@@ -46,11 +255,51 @@ type Usage struct {
 	PromptTokens     int
 	CompletionTokens int
 	TotalTokens      int
+	// CacheCreationTokens and CacheReadTokens are the prompt tokens written
+	// to and read from the provider's prompt cache. Anthropic counts them
+	// apart from PromptTokens; OpenAI's cached tokens are part of it.
+	CacheCreationTokens int
+	CacheReadTokens     int
 }
 
 // Model identifies a known model with provider metadata.
 type Model struct {
-	ID       string
-	Provider string
-	APIStyle string
+	ID              string
+	Provider        string
+	APIStyle        string
+	MaxOutputTokens int
+	// ContextWindow caps prompt and output tokens together; zero means no
+	// cap.
+	ContextWindow int
+	// Timeout and StreamIdleTimeout override the provider's timeouts for
+	// the model; zero means the provider's.
+	Timeout           time.Duration
+	StreamIdleTimeout time.Duration
+	// DisplayName is the human-readable name listed to clients; empty means
+	// the ID is shown.
+	DisplayName string
+	Concurrency ConcurrencyLimit
+	// Weight is the model's share of requests when several providers serve
+	// the same ID; zero means 1.
+	Weight float64
+	// Passthrough forwards requests in the model's API style without
+	// translating them.
+	Passthrough bool
+	// SystemPrompt is the system message chat requests to the model are
+	// sent with: it replaces theirs or, with PrependSystem, comes first.
+	SystemPrompt  string
+	PrependSystem bool
+	// DefaultOptions fill in the request options clients leave out;
+	// OverrideOptions replace them.
+	DefaultOptions  map[string]any
+	OverrideOptions map[string]any
+}
+
+// ConcurrencyLimit caps the requests in flight to a model. Up to Queue
+// further requests wait at most QueueTimeout for a slot. A zero Max means
+// no cap.
+type ConcurrencyLimit struct {
+	Max          int
+	Queue        int
+	QueueTimeout time.Duration
 }
@@ -0,0 +1,144 @@
+// Package plugin runs request and response middleware after the routing
+// hooks: sandboxed WASM modules, so teams can ship policies without
+// rebuilding the router, external HTTP services, and Go hooks compiled in
+// with Register. All three receive a Call and answer with a Verdict.
+//
+// An HTTP plugin is POSTed the JSON-encoded Call and answers with the
+// JSON-encoded Verdict; an empty body or a 204 allows the call unchanged.
+//
+// A WASM module exports linear memory and
+//
+//	gr_alloc(size i32) i32
+//	gr_on_request(ptr i32, len i32) i64
+//	gr_on_response(ptr i32, len i32) i64
+//
+// For each call the host asks gr_alloc for a buffer, writes a JSON-encoded
+// Call into it and invokes gr_on_request or gr_on_response with the buffer.
+// The buffer then belongs to the module. The hook returns the location of
+// a JSON-encoded Verdict packed as ptr<<32 | len; a zero length means allow
+// unchanged. Either hook may be left out to skip that phase. Modules built
+// as WASI reactors have _initialize run once per instance.
+//
+// Instances are pooled and reused between calls, but one instance never
+// serves two calls at once. An instance that traps or times out is
+// discarded.
+package plugin
+
+// ABIVersion is sent with every call. It changes only when the Call or
+// Verdict encoding changes incompatibly.
+const ABIVersion = 1
+
+// Call phases.
+const (
+	PhaseRequest  = "request"
+	PhaseResponse = "response"
+)
+
+// Verdict actions.
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Call is the input to a module hook.
+type Call struct {
+	ABI   int    `json:"abi"`
+	Phase string `json:"phase"`
+	// Kind is "chat" or "completion".
+	Kind string `json:"kind"`
+	// Key is the client key ID, as shown in /v1/usage.
+	Key string `json:"key,omitempty"`
+	// Config is the plugin's config block.
+	Config   map[string]any `json:"config,omitempty"`
+	Request  Request        `json:"request"`
+	Response *Response      `json:"response,omitempty"`
+}
+
+// Message is a chat message.
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	Name       string     `json:"name,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// Parts holds the text and images of a message with images, in order;
+	// Content is then its text. A plugin that rewrites such a message
+	// must keep Parts in step, as they are what the upstream receives.
+	Parts []Part `json:"parts,omitempty"`
+	// CacheControl marks the end of the message as an Anthropic prompt
+	// cache breakpoint.
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+// CacheControl is an Anthropic cache_control marker; TTL is empty for the
+// provider's default.
+type CacheControl struct {
+	Type string `json:"type"`
+	TTL  string `json:"ttl,omitempty"`
+}
+
+// Part is a piece of a message with images: either text or an image.
+type Part struct {
+	Text  string `json:"text,omitempty"`
+	Image *Image `json:"image,omitempty"`
+}
+
+// Image is an image at URL or inline as base64 Data of MediaType. Detail
+// is OpenAI's resolution hint: low, high or auto.
+type Image struct {
+	URL       string `json:"url,omitempty"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// ToolCall is a tool called by an assistant message; Arguments is JSON.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Request is the unified request. Chat requests carry Messages and
+// completion requests carry Prompt.
+type Request struct {
+	Model    string         `json:"model"`
+	Messages []Message      `json:"messages,omitempty"`
+	Prompt   string         `json:"prompt,omitempty"`
+	Stream   bool           `json:"stream"`
+	Options  map[string]any `json:"options,omitempty"`
+}
+
+// Response is the unified response.
+type Response struct {
+	ID           string `json:"id,omitempty"`
+	Model        string `json:"model,omitempty"`
+	Provider     string `json:"provider,omitempty"`
+	Content      string `json:"content"`
+	FinishReason string `json:"finish_reason,omitempty"`
+	Usage        Usage  `json:"usage"`
+}
+
+// Usage is the token accounting of a response.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// Verdict is the output of a module hook.
+type Verdict struct {
+	// Action is "allow" (the default) or "deny".
+	Action string `json:"action,omitempty"`
+	// Status is the HTTP status of a denial; zero means 403.
+	Status  int    `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Request replaces the request in the request phase. Stream cannot be
+	// changed.
+	Request *Request `json:"request,omitempty"`
+	// Response replaces the content and finish reason in the response
+	// phase.
+	Response *Response `json:"response,omitempty"`
+	// Annotations are added to router_metadata.
+	Annotations map[string]any `json:"annotations,omitempty"`
+}
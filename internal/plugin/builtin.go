@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Hook is a plugin compiled into the router. Either function may be nil to
+// skip that phase. They are called concurrently and must not modify the
+// Call they are given; a Verdict replaces the request or response instead.
+type Hook struct {
+	OnRequest  func(ctx context.Context, call Call) (Verdict, error)
+	OnResponse func(ctx context.Context, call Call) (Verdict, error)
+}
+
+var (
+	hooksMu sync.RWMutex
+	hooks   = make(map[string]Hook)
+)
+
+// Register makes a hook available to routing.plugins entries naming it as
+// builtin. It is meant to be called from an init function and panics when
+// the name is taken.
+func Register(name string, hook Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	if _, exists := hooks[name]; exists {
+		panic(fmt.Sprintf("plugin: hook %q registered twice", name))
+	}
+	hooks[name] = hook
+}
+
+// Registered lists the names of the registered hooks.
+func Registered() []string {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	names := make([]string, 0, len(hooks))
+	for name := range hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupHook(name string) (Hook, bool) {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	hook, ok := hooks[name]
+	return hook, ok
+}
+
+// hookModule runs a registered hook through the module interface, so it
+// gets the same timeouts, failure handling and verdicts as WASM modules.
+type hookModule struct {
+	hook Hook
+}
+
+func (m hookModule) exports(name string) bool {
+	switch name {
+	case exportRequest:
+		return m.hook.OnRequest != nil
+	case exportResponse:
+		return m.hook.OnResponse != nil
+	}
+	return false
+}
+
+func (m hookModule) call(ctx context.Context, export string, input []byte) ([]byte, error) {
+	var call Call
+	if err := json.Unmarshal(input, &call); err != nil {
+		return nil, fmt.Errorf("decode call: %w", err)
+	}
+	run := m.hook.OnRequest
+	if export == exportResponse {
+		run = m.hook.OnResponse
+	}
+
+	// The hook is abandoned, not stopped, when it outlives its timeout.
+	type result struct {
+		verdict Verdict
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		verdict, err := run(ctx, call)
+		done <- result{verdict, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		if r.err != nil {
+			return nil, r.err
+		}
+		return json.Marshal(r.verdict)
+	}
+}
+
+func (hookModule) close(context.Context) error { return nil }
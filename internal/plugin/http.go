@@ -0,0 +1,77 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gocode-router/internal/config"
+)
+
+// maxVerdictBytes bounds the verdict read from an HTTP plugin.
+const maxVerdictBytes = 4 << 20
+
+// httpClient is shared by every HTTP plugin, so connections outlive
+// configuration reloads. Each call's context carries the plugin's timeout.
+var httpClient = &http.Client{}
+
+// httpModule calls an external service for each hook. The Call is POSTed
+// as JSON and the response body is the Verdict; an empty body or a 204
+// allows the call unchanged.
+type httpModule struct {
+	url     string
+	headers map[string]string
+	phases  []string
+}
+
+func newHTTPModule(cfg config.PluginConfig) *httpModule {
+	phases := cfg.Phases
+	if len(phases) == 0 {
+		phases = []string{PhaseRequest, PhaseResponse}
+	}
+	return &httpModule{url: cfg.URL, headers: cfg.Headers, phases: phases}
+}
+
+func (m *httpModule) exports(name string) bool {
+	switch name {
+	case exportRequest:
+		return slices.Contains(m.phases, PhaseRequest)
+	case exportResponse:
+		return slices.Contains(m.phases, PhaseResponse)
+	}
+	return false
+}
+
+func (m *httpModule) call(ctx context.Context, export string, input []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(input))
+	if err != nil {
+		return nil, fmt.Errorf("construct request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range m.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxVerdictBytes))
+	if err != nil {
+		return nil, fmt.Errorf("read verdict: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("plugin service answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	return body, nil
+}
+
+func (*httpModule) close(context.Context) error { return nil }
@@ -0,0 +1,287 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+const (
+	exportAlloc    = "gr_alloc"
+	exportRequest  = "gr_on_request"
+	exportResponse = "gr_on_response"
+)
+
+// idleInstances caps the instances each module keeps between calls.
+const idleInstances = 4
+
+// retireAfter is how long a replaced module stays open for calls that were
+// already running when the configuration was reloaded.
+const retireAfter = time.Minute
+
+var (
+	// ErrUnavailable reports a plugin configured in a build without a WASM
+	// runtime.
+	ErrUnavailable = errors.New("WASM plugins need a router built with -tags wazero")
+	// ErrFailed wraps a module that trapped, timed out or returned an
+	// invalid verdict.
+	ErrFailed = errors.New("plugin failed")
+)
+
+// DeniedError is returned when a module denies a request.
+type DeniedError struct {
+	Plugin  string
+	Status  int
+	Message string
+}
+
+func (e *DeniedError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("request denied by plugin %s", e.Plugin)
+	}
+	return fmt.Sprintf("request denied by plugin %s: %s", e.Plugin, e.Message)
+}
+
+// module is a compiled WASM module.
+type module interface {
+	exports(name string) bool
+	call(ctx context.Context, export string, input []byte) ([]byte, error)
+	close(ctx context.Context) error
+}
+
+// compileModule compiles WASM bytes; it is set by the runtime compiled into
+// the binary and nil otherwise.
+var compileModule func(ctx context.Context, wasm []byte) (module, error)
+
+type loadedModule struct {
+	size    int64
+	modTime time.Time
+	mod     module
+}
+
+var (
+	loadedMu sync.Mutex
+	loaded   = make(map[string]*loadedModule)
+)
+
+// Chain runs the configured plugins in order. A nil Chain passes everything
+// through.
+type Chain struct {
+	plugins []plugin
+}
+
+type plugin struct {
+	name string
+	cfg  config.PluginConfig
+	mod  module
+}
+
+// Load compiles the configured modules. Modules whose file is unchanged
+// since the last Load are reused; replaced and removed ones are closed once
+// calls already running on them have had time to finish.
+func Load(ctx context.Context, cfgs []config.PluginConfig) (*Chain, error) {
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+
+	chain := &Chain{}
+	used := make(map[string]bool, len(cfgs))
+	for i, cfg := range cfgs {
+		name := cfg.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		switch {
+		case cfg.Builtin != "":
+			hook, ok := lookupHook(cfg.Builtin)
+			if !ok {
+				return nil, fmt.Errorf("plugin %s: no builtin hook %q is compiled in (registered: %v)", name, cfg.Builtin, Registered())
+			}
+			chain.plugins = append(chain.plugins, plugin{name: name, cfg: cfg, mod: hookModule{hook: hook}})
+			continue
+		case cfg.URL != "":
+			chain.plugins = append(chain.plugins, plugin{name: name, cfg: cfg, mod: newHTTPModule(cfg)})
+			continue
+		}
+
+		if compileModule == nil {
+			return nil, ErrUnavailable
+		}
+		path, err := filepath.Abs(cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", name, err)
+		}
+		mod, err := loadModule(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", name, err)
+		}
+		used[path] = true
+		chain.plugins = append(chain.plugins, plugin{name: name, cfg: cfg, mod: mod})
+	}
+
+	for path, lm := range loaded {
+		if !used[path] {
+			delete(loaded, path)
+			retire(lm.mod)
+		}
+	}
+	if len(chain.plugins) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+// loadModule returns the cached module for path, compiling it again when
+// the file has changed. The caller holds loadedMu.
+func loadModule(ctx context.Context, path string) (module, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if lm, ok := loaded[path]; ok && lm.size == info.Size() && lm.modTime.Equal(info.ModTime()) {
+		return lm.mod, nil
+	}
+
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mod, err := compileModule(ctx, wasm)
+	if err != nil {
+		return nil, fmt.Errorf("compile %s: %w", path, err)
+	}
+	if !mod.exports(exportAlloc) {
+		mod.close(ctx)
+		return nil, fmt.Errorf("%s does not export %s", path, exportAlloc)
+	}
+
+	if old, ok := loaded[path]; ok {
+		retire(old.mod)
+	}
+	loaded[path] = &loadedModule{size: info.Size(), modTime: info.ModTime(), mod: mod}
+	slog.Info("loaded plugin module", "path", path)
+	return mod, nil
+}
+
+func retire(mod module) {
+	time.AfterFunc(retireAfter, func() {
+		if err := mod.close(context.Background()); err != nil {
+			slog.Warn("close plugin module failed", "error", err)
+		}
+	})
+}
+
+// OnRequest runs the request hooks, returning the request to route.
+func (c *Chain) OnRequest(ctx context.Context, call Call) (Request, error) {
+	if c == nil {
+		return call.Request, nil
+	}
+	call.Phase = PhaseRequest
+	for _, p := range c.plugins {
+		verdict, err := p.run(ctx, exportRequest, call)
+		if err != nil {
+			return Request{}, err
+		}
+		if verdict != nil && verdict.Request != nil {
+			stream := call.Request.Stream
+			call.Request = *verdict.Request
+			call.Request.Stream = stream
+		}
+	}
+	return call.Request, nil
+}
+
+// OnResponse runs the response hooks, returning the response to send and
+// the annotations the modules added.
+func (c *Chain) OnResponse(ctx context.Context, call Call, resp Response) (Response, map[string]any, error) {
+	if c == nil {
+		return resp, nil, nil
+	}
+	call.Phase = PhaseResponse
+	var annotations map[string]any
+	for _, p := range c.plugins {
+		call.Response = &resp
+		verdict, err := p.run(ctx, exportResponse, call)
+		if err != nil {
+			return Response{}, nil, err
+		}
+		if verdict == nil {
+			continue
+		}
+		if verdict.Response != nil {
+			resp.Content = verdict.Response.Content
+			resp.FinishReason = verdict.Response.FinishReason
+		}
+		for k, v := range verdict.Annotations {
+			if annotations == nil {
+				annotations = make(map[string]any)
+			}
+			annotations[k] = v
+		}
+	}
+	return resp, annotations, nil
+}
+
+// run calls one hook of the plugin. It returns a nil verdict when the
+// module does not implement the hook or failed open.
+func (p plugin) run(ctx context.Context, export string, call Call) (*Verdict, error) {
+	if !p.mod.exports(export) {
+		return nil, nil
+	}
+	call.ABI = ABIVersion
+	call.Config = p.cfg.Config
+
+	verdict, err := p.invoke(ctx, export, call)
+	if err != nil {
+		if p.cfg.FailOpen {
+			slog.Warn("plugin failed open", "plugin", p.name, "phase", call.Phase, "error", err)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: %s: %v", ErrFailed, p.name, err)
+	}
+	if verdict.Action == ActionDeny {
+		status := verdict.Status
+		if status < 400 || status > 599 {
+			status = http.StatusForbidden
+		}
+		return nil, &DeniedError{Plugin: p.name, Status: status, Message: verdict.Message}
+	}
+	return &verdict, nil
+}
+
+func (p plugin) invoke(ctx context.Context, export string, call Call) (Verdict, error) {
+	input, err := json.Marshal(call)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("encode call: %w", err)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, p.cfg.CallTimeout())
+	defer cancel()
+	output, err := p.mod.call(callCtx, export, input)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	var verdict Verdict
+	if len(output) == 0 {
+		return verdict, nil
+	}
+	if err := json.Unmarshal(output, &verdict); err != nil {
+		return Verdict{}, fmt.Errorf("decode verdict: %w", err)
+	}
+	switch verdict.Action {
+	case "", ActionAllow, ActionDeny:
+	default:
+		return Verdict{}, fmt.Errorf("unknown verdict action %q", verdict.Action)
+	}
+	return verdict, nil
+}
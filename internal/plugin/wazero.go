@@ -0,0 +1,117 @@
+//go:build wazero
+
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+func init() {
+	compileModule = compileWazero
+}
+
+// wazeroModule runs a module on its own wazero runtime, so closing it
+// releases everything the module allocated.
+type wazeroModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	idle     chan api.Module
+}
+
+func compileWazero(ctx context.Context, wasm []byte) (module, error) {
+	// Closing instances when the call context ends is what enforces the
+	// per-call timeout.
+	rt := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		rt.Close(ctx)
+		return nil, fmt.Errorf("instantiate WASI: %w", err)
+	}
+	compiled, err := rt.CompileModule(ctx, wasm)
+	if err != nil {
+		rt.Close(ctx)
+		return nil, err
+	}
+	return &wazeroModule{runtime: rt, compiled: compiled, idle: make(chan api.Module, idleInstances)}, nil
+}
+
+func (m *wazeroModule) exports(name string) bool {
+	_, ok := m.compiled.ExportedFunctions()[name]
+	return ok
+}
+
+func (m *wazeroModule) call(ctx context.Context, export string, input []byte) ([]byte, error) {
+	inst, err := m.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	output, err := invoke(ctx, inst, export, input)
+	if err != nil {
+		// A trapped or interrupted instance may be left in any state.
+		inst.Close(context.Background())
+		return nil, err
+	}
+	select {
+	case m.idle <- inst:
+	default:
+		inst.Close(context.Background())
+	}
+	return output, nil
+}
+
+func (m *wazeroModule) acquire(ctx context.Context) (api.Module, error) {
+	select {
+	case inst := <-m.idle:
+		return inst, nil
+	default:
+	}
+	// Unnamed instances can coexist in one runtime.
+	cfg := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	inst, err := m.runtime.InstantiateModule(ctx, m.compiled, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("instantiate: %w", err)
+	}
+	return inst, nil
+}
+
+func invoke(ctx context.Context, inst api.Module, export string, input []byte) ([]byte, error) {
+	results, err := inst.ExportedFunction(exportAlloc).Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", exportAlloc, err)
+	}
+	ptr := uint32(results[0])
+	if !inst.Memory().Write(ptr, input) {
+		return nil, fmt.Errorf("%s returned a buffer outside memory", exportAlloc)
+	}
+
+	results, err = inst.ExportedFunction(export).Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", export, err)
+	}
+	outPtr, outLen := uint32(results[0]>>32), uint32(results[0])
+	if outLen == 0 {
+		return nil, nil
+	}
+	output, ok := inst.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("%s returned a verdict outside memory", export)
+	}
+	// Read returns a view of guest memory, which the next call reuses.
+	return append([]byte(nil), output...), nil
+}
+
+func (m *wazeroModule) close(ctx context.Context) error {
+	for {
+		select {
+		case inst := <-m.idle:
+			inst.Close(ctx)
+		default:
+			return errors.Join(m.compiled.Close(ctx), m.runtime.Close(ctx))
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package provider
+
+import "context"
+
+// Attribution names the organization and project an upstream request is
+// billed to, for APIs such as OpenAI's that support it.
+type Attribution struct {
+	Organization string `json:"organization,omitempty"`
+	Project      string `json:"project,omitempty"`
+}
+
+type attributionKey struct{}
+
+// WithAttribution returns a context carrying per-request attribution
+// overrides, keyed by provider name.
+func WithAttribution(ctx context.Context, byProvider map[string]Attribution) context.Context {
+	if len(byProvider) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, attributionKey{}, byProvider)
+}
+
+// AttributionFor returns the override carried by ctx for the named provider.
+func AttributionFor(ctx context.Context, providerName string) (Attribution, bool) {
+	byProvider, _ := ctx.Value(attributionKey{}).(map[string]Attribution)
+	a, ok := byProvider[providerName]
+	return a, ok
+}
@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gocode-router/internal/models"
+)
+
+// StreamThreshold is the prompt size, in bytes, from which chat requests
+// stream their body to the upstream rather than encoding it up front.
+const StreamThreshold = 256 << 10
+
+// streamBufferSize is how much encoded body is written to the transport at
+// a time.
+const streamBufferSize = 32 << 10
+
+// PromptBytes returns the size of the messages' content, inline images
+// included.
+func PromptBytes(messages []models.Message) int {
+	var n int
+	for _, m := range messages {
+		n += len(m.Content)
+		for _, part := range m.Parts {
+			n += len(part.Text)
+			if part.Image != nil {
+				n += len(part.Image.Data)
+			}
+		}
+	}
+	return n
+}
+
+// StreamJSON returns a request body that encodes payload with items as its
+// array under key. The items are encoded one at a time as the transport
+// reads the body, so at most one item and a buffer are held in memory, and
+// the body goes out with chunked transfer encoding. In payload the array
+// must be empty, and key must not occur in the encoding before it.
+func StreamJSON[T any](payload any, key string, items []T) (io.ReadCloser, error) {
+	head, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal payload: %w", err)
+	}
+	marker := []byte(`"` + key + `":[]`)
+	i := bytes.Index(head, marker)
+	if i < 0 {
+		return nil, fmt.Errorf("marshal payload: no empty %q array to stream into", key)
+	}
+	// split is the position of the array's closing bracket.
+	split := i + len(marker) - 1
+
+	pr, pw := io.Pipe()
+	go func() {
+		w := bufio.NewWriterSize(pw, streamBufferSize)
+		err := func() error {
+			if _, err := w.Write(head[:split]); err != nil {
+				return err
+			}
+			for j, item := range items {
+				if j > 0 {
+					if err := w.WriteByte(','); err != nil {
+						return err
+					}
+				}
+				data, err := json.Marshal(item)
+				if err != nil {
+					return fmt.Errorf("marshal payload: %w", err)
+				}
+				if _, err := w.Write(data); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(head[split:]); err != nil {
+				return err
+			}
+			return w.Flush()
+		}()
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
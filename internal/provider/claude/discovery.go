@@ -0,0 +1,83 @@
+package claude
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+type modelList struct {
+	Data []struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+	} `json:"data"`
+	HasMore bool   `json:"has_more"`
+	LastID  string `json:"last_id"`
+}
+
+// DiscoverModels lists the models served by the upstream's /v1/models
+// endpoint, following its pages.
+func (p *Provider) DiscoverModels(ctx context.Context) ([]models.Model, error) {
+	var out []models.Model
+	query := url.Values{"limit": {"1000"}}
+	for {
+		httpReq, err := p.newRequest(ctx, http.MethodGet, p.baseURL+"/v1/models?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := p.fetchModelPage(httpReq)
+		if err != nil {
+			return nil, err
+		}
+		for _, model := range list.Data {
+			if model.ID == "" {
+				continue
+			}
+			out = append(out, models.Model{ID: model.ID, Provider: p.name, APIStyle: "claude", DisplayName: model.DisplayName})
+		}
+		if !list.HasMore || list.LastID == "" {
+			return out, nil
+		}
+		query.Set("after_id", list.LastID)
+	}
+}
+
+func (p *Provider) fetchModelPage(httpReq *http.Request) (modelList, error) {
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return modelList{}, fmt.Errorf("claude models request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode >= 400 {
+		return modelList{}, parseAPIError(httpResp)
+	}
+
+	var list modelList
+	if err := decodeJSON(httpResp.Body, &list); err != nil {
+		return modelList{}, err
+	}
+	return list, nil
+}
+
+// Probe implements provider.Prober with a request for one page of the model
+// list.
+func (p *Provider) Probe(ctx context.Context) error {
+	probeURL := p.baseURL + "/v1/models?limit=1"
+	if p.vertex != nil {
+		// Vertex AI lists no models here, but answers once the token is
+		// accepted.
+		probeURL = strings.TrimSuffix(p.vertex.models, "/")
+	}
+	httpReq, err := p.newRequest(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return err
+	}
+	return provider.ProbeRequest(p.client, httpReq)
+}
@@ -0,0 +1,35 @@
+package claude
+
+import (
+	"strings"
+
+	"gocode-router/internal/models"
+)
+
+// imageSource is the source of an Anthropic image block: inline base64
+// data or a URL.
+type imageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// partBlocks renders the text and images of a message as content blocks,
+// in order. Blank text parts are dropped, as Anthropic rejects them.
+func partBlocks(parts []models.ContentPart) []contentBlock {
+	blocks := make([]contentBlock, 0, len(parts))
+	for _, part := range parts {
+		switch {
+		case part.Image == nil:
+			if text := strings.TrimSpace(part.Text); text != "" {
+				blocks = append(blocks, contentBlock{Type: "text", Text: text})
+			}
+		case part.Image.URL != "":
+			blocks = append(blocks, contentBlock{Type: "image", Source: &imageSource{Type: "url", URL: part.Image.URL}})
+		default:
+			blocks = append(blocks, contentBlock{Type: "image", Source: &imageSource{Type: "base64", MediaType: part.Image.MediaType, Data: part.Image.Data}})
+		}
+	}
+	return blocks
+}
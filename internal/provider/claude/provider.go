@@ -7,12 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
+	"unicode/utf8"
 
 	"gocode-router/internal/config"
 	"gocode-router/internal/models"
 	"gocode-router/internal/provider"
+	"gocode-router/internal/provider/credentials"
 )
 
 const (
@@ -30,6 +33,10 @@ type Provider struct {
 	client   *http.Client
 	models   []models.Model
 	messages string
+	// vertex and tokens are set for Claude on Vertex AI, which takes
+	// Google tokens in place of an API key.
+	vertex *vertexEndpoint
+	tokens credentials.TokenSource
 }
 
 // New constructs a Claude provider instance.
@@ -41,16 +48,40 @@ func New(name string, cfg config.ProviderConfig, client *http.Client) (*Provider
 	if baseURL == "" {
 		return nil, errors.New("base url must not be empty")
 	}
+	if !cfg.Auth.UsesAPIKey() {
+		return nil, fmt.Errorf("claude provider %q does not support auth type %q", name, cfg.Auth.Type)
+	}
 
 	modelsList := make([]models.Model, 0, len(cfg.Models))
 	for _, model := range cfg.Models {
 		if model.APIStyle != "claude" {
-			return nil, fmt.Errorf("claude provider %q received model %q with unsupported api_style %q", name, model.ID, model.APIStyle)
+			return nil, fmt.Errorf("claude provider %q received model %q with unsupported api_style %q", name, model.Name(), model.APIStyle)
+		}
+		if model.IsPattern() {
+			// Served through the registry's patterns; the upstream takes
+			// any model ID.
+			continue
 		}
 		modelsList = append(modelsList, models.Model{
-			ID:       model.ID,
-			Provider: name,
-			APIStyle: model.APIStyle,
+			ID:                model.ID,
+			Provider:          name,
+			APIStyle:          model.APIStyle,
+			MaxOutputTokens:   model.MaxOutputTokens,
+			ContextWindow:     model.ContextWindow,
+			Timeout:           model.Timeout,
+			StreamIdleTimeout: model.StreamIdleTimeout,
+			DisplayName:       model.DisplayName,
+			Weight:            model.Weight,
+			Passthrough:       model.Passthrough,
+			SystemPrompt:      model.SystemPrompt,
+			PrependSystem:     model.Prepend,
+			DefaultOptions:    model.DefaultOptions(),
+			OverrideOptions:   model.OverrideOptions(),
+			Concurrency: models.ConcurrencyLimit{
+				Max:          model.Concurrency.Max,
+				Queue:        model.Concurrency.Queue,
+				QueueTimeout: model.Concurrency.QueueTimeout,
+			},
 		})
 	}
 
@@ -69,6 +100,11 @@ func (p *Provider) Name() string {
 	return p.name
 }
 
+// CloseIdleConnections implements provider.IdleCloser.
+func (p *Provider) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
 func (p *Provider) ListModels(ctx context.Context) ([]models.Model, error) {
 	result := make([]models.Model, len(p.models))
 	copy(result, p.models)
@@ -80,12 +116,7 @@ func (p *Provider) Chat(ctx context.Context, req models.UnifiedChatRequest) (*mo
 		return nil, fmt.Errorf("streaming is not yet supported for provider %s: %w", p.name, provider.ErrUnsupportedOperation)
 	}
 
-	payload, err := buildMessagePayload(req)
-	if err != nil {
-		return nil, err
-	}
-
-	httpReq, err := p.newRequest(ctx, http.MethodPost, p.messages, payload)
+	httpReq, err := p.newMessageRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -108,26 +139,147 @@ func (p *Provider) Chat(ctx context.Context, req models.UnifiedChatRequest) (*mo
 	return providerResp.toUnified()
 }
 
+// newMessageRequest builds the upstream request for a chat.
+func (p *Provider) newMessageRequest(ctx context.Context, req models.UnifiedChatRequest) (*http.Request, error) {
+	payload, err := buildMessagePayload(req)
+	if err != nil {
+		return nil, err
+	}
+
+	url := p.messages
+	if p.vertex != nil {
+		url = p.vertex.messagesURL(req.Model, req.Stream)
+		payload.Model = ""
+		payload.AnthropicVersion = vertexAnthropicVersion
+	}
+
+	// Large prompts are encoded as they are sent rather than up front.
+	var body any = payload
+	if provider.PromptBytes(req.Messages) >= provider.StreamThreshold {
+		messages := payload.Messages
+		payload.Messages = []message{}
+		if body, err = provider.StreamJSON(payload, "messages", messages); err != nil {
+			return nil, err
+		}
+	}
+	return p.newRequest(ctx, http.MethodPost, url, body)
+}
+
 func (p *Provider) Completion(ctx context.Context, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, error) {
 	return nil, fmt.Errorf("completions are not supported by provider %s: %w", p.name, provider.ErrUnsupportedOperation)
 }
 
-func (p *Provider) newRequest(ctx context.Context, method, url string, payload any) (*http.Request, error) {
-	body, err := json.Marshal(payload)
+func (p *Provider) CompletionStream(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedChatStream, error) {
+	return nil, fmt.Errorf("completions are not supported by provider %s: %w", p.name, provider.ErrUnsupportedOperation)
+}
+
+// Passthrough implements provider.Passthrougher. A client's
+// anthropic-version or anthropic-beta header replaces the provider's; Vertex
+// AI takes the version in the body instead.
+func (p *Provider) Passthrough(ctx context.Context, req provider.PassthroughRequest) (*http.Response, error) {
+	url, body := p.messages, req.Body
+	if p.vertex != nil {
+		var err error
+		if body, err = vertexBody(body); err != nil {
+			return nil, err
+		}
+		url = p.vertex.messagesURL(req.Model, req.Stream)
+		req.Header = req.Header.Clone()
+		req.Header.Del("anthropic-version")
+	}
+	httpReq, err := p.newRequest(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"anthropic-version", "anthropic-beta"} {
+		if value := req.Header.Get(name); value != "" {
+			httpReq.Header.Set(name, value)
+		}
+	}
+	return provider.SendPassthrough(p.client, httpReq, req)
+}
+
+// CountTokens implements provider.TokenCounter with the messages
+// count_tokens endpoint, which Vertex AI does not serve.
+func (p *Provider) CountTokens(ctx context.Context, model string, body []byte, header http.Header) (int, error) {
+	if p.vertex != nil {
+		return 0, fmt.Errorf("token counting is not supported by provider %s: %w", p.name, provider.ErrUnsupportedOperation)
+	}
+	httpReq, err := p.newRequest(ctx, http.MethodPost, p.messages+"/count_tokens", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range []string{"anthropic-version", "anthropic-beta"} {
+		if value := header.Get(name); value != "" {
+			httpReq.Header.Set(name, value)
+		}
+	}
+
+	httpResp, err := p.client.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("marshal payload: %w", err)
+		return 0, fmt.Errorf("claude count_tokens request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode >= 400 {
+		return 0, parseAPIError(httpResp)
+	}
+
+	var count struct {
+		InputTokens int `json:"input_tokens"`
+	}
+	if err := decodeJSON(httpResp.Body, &count); err != nil {
+		return 0, err
+	}
+	return count.InputTokens, nil
+}
+
+func (p *Provider) newRequest(ctx context.Context, method, url string, payload any) (_ *http.Request, err error) {
+	// A payload that is already a body, such as a streamed one, is sent as
+	// is.
+	var body io.Reader
+	switch payload := payload.(type) {
+	case nil:
+	case io.Reader:
+		body = payload
+		// The transport only closes the body of requests it sends.
+		if closer, ok := payload.(io.Closer); ok {
+			defer func() {
+				if err != nil {
+					closer.Close()
+				}
+			}()
+		}
+	default:
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("marshal payload: %w", err)
+		}
+		body = bytes.NewReader(data)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("construct request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", contentTypeJSON)
+	if body != nil {
+		req.Header.Set("Content-Type", contentTypeJSON)
+	}
 	req.Header.Set("Accept", contentTypeJSON)
 	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("x-api-key", p.apiKey)
-	req.Header.Set("anthropic-version", apiVersion)
+	if p.tokens != nil {
+		token, err := p.tokens.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("acquire upstream token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.Header.Set("x-api-key", p.apiKey)
+	}
+	if p.vertex == nil {
+		req.Header.Set("anthropic-version", apiVersion)
+	}
 
 	for k, v := range p.headers {
 		req.Header.Set(k, v)
@@ -137,15 +289,28 @@ func (p *Provider) newRequest(ctx context.Context, method, url string, payload a
 }
 
 type messagePayload struct {
-	Model         string         `json:"model"`
-	Messages      []message      `json:"messages"`
-	System        string         `json:"system,omitempty"`
-	MaxTokens     int            `json:"max_tokens"`
-	Temperature   *float64       `json:"temperature,omitempty"`
-	TopP          *float64       `json:"top_p,omitempty"`
-	StopSequences []string       `json:"stop_sequences,omitempty"`
-	Metadata      map[string]any `json:"metadata,omitempty"`
-	Stream        bool           `json:"stream,omitempty"`
+	Model string `json:"model,omitempty"`
+	// AnthropicVersion is sent in the body, rather than a header, to
+	// Vertex AI.
+	AnthropicVersion string    `json:"anthropic_version,omitempty"`
+	Messages         []message `json:"messages"`
+	// System is a string, or text blocks when a system prompt is a cache
+	// breakpoint.
+	System        any         `json:"system,omitempty"`
+	MaxTokens     int         `json:"max_tokens"`
+	Temperature   *float64    `json:"temperature,omitempty"`
+	TopP          *float64    `json:"top_p,omitempty"`
+	StopSequences []string    `json:"stop_sequences,omitempty"`
+	Metadata      *metadata   `json:"metadata,omitempty"`
+	Tools         []tool      `json:"tools,omitempty"`
+	ToolChoice    *toolChoice `json:"tool_choice,omitempty"`
+	Stream        bool        `json:"stream,omitempty"`
+}
+
+// metadata is the request metadata Anthropic accepts; any other key is
+// rejected.
+type metadata struct {
+	UserID string `json:"user_id,omitempty"`
 }
 
 type message struct {
@@ -153,14 +318,48 @@ type message struct {
 	Content []contentBlock `json:"content"`
 }
 
+// contentBlock is a text, image, tool_use or tool_result block of a
+// request message.
 type contentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Source    *imageSource    `json:"source,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	// Content is the result of a tool: text, or blocks when it has
+	// images.
+	Content any `json:"content,omitempty"`
+
+	CacheControl *cacheControl `json:"cache_control,omitempty"`
+}
+
+// cacheControl marks a prompt cache breakpoint.
+type cacheControl struct {
 	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
+	TTL  string `json:"ttl,omitempty"`
+}
+
+func convertCacheControl(c *models.CacheControl) *cacheControl {
+	if c == nil {
+		return nil
+	}
+	return &cacheControl{Type: c.Type, TTL: c.TTL}
+}
+
+// markCacheBreakpoint puts a message's cache breakpoint on its last block.
+func markCacheBreakpoint(blocks []contentBlock, c *models.CacheControl) {
+	if c != nil && len(blocks) > 0 {
+		blocks[len(blocks)-1].CacheControl = convertCacheControl(c)
+	}
 }
 
 func buildMessagePayload(req models.UnifiedChatRequest) (messagePayload, error) {
 	messages := make([]message, 0, len(req.Messages))
 	var systemParts []string
+	var systemBlocks []contentBlock
+	systemCached := false
 
 	for _, msg := range req.Messages {
 		role := strings.ToLower(strings.TrimSpace(msg.Role))
@@ -168,18 +367,45 @@ func buildMessagePayload(req models.UnifiedChatRequest) (messagePayload, error)
 		case "system":
 			if strings.TrimSpace(msg.Content) != "" {
 				systemParts = append(systemParts, msg.Content)
+				systemBlocks = append(systemBlocks, contentBlock{Type: "text", Text: msg.Content, CacheControl: convertCacheControl(msg.CacheControl)})
+				systemCached = systemCached || msg.CacheControl != nil
 			}
 		case "user", "assistant":
 			text := strings.TrimSpace(msg.Content)
-			if text == "" {
+			if text == "" && len(msg.ToolCalls) == 0 && !msg.HasImages() {
 				return messagePayload{}, errors.New("claude messages must not be empty")
 			}
-			messages = append(messages, message{
-				Role: role,
-				Content: []contentBlock{
-					{Type: "text", Text: text},
-				},
-			})
+			var blocks []contentBlock
+			if msg.HasImages() {
+				blocks = partBlocks(msg.Parts)
+			} else if text != "" {
+				blocks = append(blocks, contentBlock{Type: "text", Text: text})
+			}
+			for _, call := range msg.ToolCalls {
+				input, err := toolInput(call.Arguments)
+				if err != nil {
+					return messagePayload{}, err
+				}
+				blocks = append(blocks, contentBlock{Type: "tool_use", ID: toolUseID(call.ID), Name: call.Name, Input: input})
+			}
+			markCacheBreakpoint(blocks, msg.CacheControl)
+			messages = appendMessage(messages, role, blocks)
+		case "tool":
+			if msg.ToolCallID == "" {
+				return messagePayload{}, errors.New("claude tool messages need a tool_call_id")
+			}
+			result := contentBlock{
+				Type:         "tool_result",
+				ToolUseID:    toolUseID(msg.ToolCallID),
+				CacheControl: convertCacheControl(msg.CacheControl),
+			}
+			switch {
+			case msg.HasImages():
+				result.Content = partBlocks(msg.Parts)
+			case msg.Content != "":
+				result.Content = msg.Content
+			}
+			messages = appendToolResult(messages, result)
 		default:
 			return messagePayload{}, fmt.Errorf("claude provider does not support role %q", msg.Role)
 		}
@@ -204,7 +430,10 @@ func buildMessagePayload(req models.UnifiedChatRequest) (messagePayload, error)
 		Stream:    req.Stream,
 	}
 
-	if len(systemParts) > 0 {
+	switch {
+	case systemCached:
+		payload.System = systemBlocks
+	case len(systemParts) > 0:
 		payload.System = strings.Join(systemParts, "\n\n")
 	}
 	if v, ok := extractFloat(req.Options, "temperature"); ok {
@@ -216,25 +445,72 @@ func buildMessagePayload(req models.UnifiedChatRequest) (messagePayload, error)
 	if stops, ok := extractStringSlice(req.Options, "stop"); ok {
 		payload.StopSequences = stops
 	}
-	if metadata, ok := extractMap(req.Options, "metadata"); ok {
-		payload.Metadata = metadata
+	if userID := endUserID(req.Options); userID != "" {
+		payload.Metadata = &metadata{UserID: userID}
+	}
+	tools, err := convertTools(req.Tools)
+	if err != nil {
+		return messagePayload{}, err
+	}
+	payload.Tools = tools
+
+	rawChoice, _ := extractRaw(req.Options, "tool_choice")
+	var parallel *bool
+	if v, ok := extractBool(req.Options, "parallel_tool_calls"); ok {
+		parallel = &v
+	}
+	choice, err := convertToolChoice(rawChoice, parallel)
+	if err != nil {
+		return messagePayload{}, err
+	}
+	payload.ToolChoice = choice
+
+	if format, ok := extractMap(req.Options, "response_format"); ok {
+		if err := applyResponseFormat(&payload, format); err != nil {
+			return messagePayload{}, err
+		}
 	}
 
 	return payload, nil
 }
 
 type messageResponse struct {
-	ID         string         `json:"id"`
-	Role       string         `json:"role"`
-	Content    []contentBlock `json:"content"`
-	Usage      usageBlock     `json:"usage"`
-	StopReason string         `json:"stop_reason"`
-	Error      *apiError      `json:"error,omitempty"`
+	ID         string          `json:"id"`
+	Model      string          `json:"model"`
+	Role       string          `json:"role"`
+	Content    []responseBlock `json:"content"`
+	Usage      usageBlock      `json:"usage"`
+	StopReason string          `json:"stop_reason"`
+	Error      *apiError       `json:"error,omitempty"`
+}
+
+// responseBlock is a content block in a response. Besides text, Claude
+// returns calls of client tools, and server tool calls such as web search
+// followed by their results.
+type responseBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	Citations []citation      `json:"citations"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+}
+
+type citation struct {
+	Type          string `json:"type"`
+	CitedText     string `json:"cited_text"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	DocumentTitle string `json:"document_title"`
 }
 
 type usageBlock struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
 }
 
 func (r messageResponse) toUnified() (*models.UnifiedChatResponse, error) {
@@ -243,11 +519,54 @@ func (r messageResponse) toUnified() (*models.UnifiedChatResponse, error) {
 	}
 
 	text := strings.Builder{}
+	var citations []models.Citation
+	var serverTools []models.ServerToolCall
+	var toolCalls []models.ToolCall
+	var structured string
+	// Citation offsets count characters, as OpenAI annotations do.
+	offset := 0
 	for _, block := range r.Content {
-		if block.Type != "text" {
-			return nil, fmt.Errorf("claude returned unsupported content block type %q", block.Type)
+		switch {
+		case block.Type == "text":
+			text.WriteString(block.Text)
+			end := offset + utf8.RuneCountInString(block.Text)
+			for _, c := range block.Citations {
+				title := c.Title
+				if title == "" {
+					title = c.DocumentTitle
+				}
+				citations = append(citations, models.Citation{
+					Type:      c.Type,
+					URL:       c.URL,
+					Title:     title,
+					CitedText: c.CitedText,
+					Start:     offset,
+					End:       end,
+				})
+			}
+			offset = end
+		case block.Type == "tool_use" && block.Name == outputTool:
+			// The JSON answer to a response_format request replaces any
+			// text around it.
+			structured = string(block.Input)
+		case block.Type == "tool_use":
+			arguments := "{}"
+			if len(block.Input) > 0 {
+				arguments = string(block.Input)
+			}
+			toolCalls = append(toolCalls, models.ToolCall{ID: block.ID, Name: block.Name, Arguments: arguments})
+		case block.Type == "server_tool_use":
+			serverTools = append(serverTools, models.ServerToolCall{ID: block.ID, Name: block.Name, Input: block.Input})
+		case strings.HasSuffix(block.Type, "_tool_result") && block.ToolUseID != "":
+			for i := range serverTools {
+				if serverTools[i].ID == block.ToolUseID {
+					serverTools[i].ResultType = block.Type
+					serverTools[i].Result = block.Content
+				}
+			}
+		default:
+			slog.Debug("skipping unsupported claude content block", "type", block.Type)
 		}
-		text.WriteString(block.Text)
 	}
 
 	totalTokens := r.Usage.InputTokens + r.Usage.OutputTokens
@@ -255,19 +574,32 @@ func (r messageResponse) toUnified() (*models.UnifiedChatResponse, error) {
 	if role == "" {
 		role = "assistant"
 	}
+	content, stopReason := text.String(), r.StopReason
+	if structured != "" {
+		content, citations = structured, nil
+		if len(toolCalls) == 0 {
+			stopReason = structuredStopReason(stopReason)
+		}
+	}
 
 	return &models.UnifiedChatResponse{
-		ID: r.ID,
+		ID:    r.ID,
+		Model: r.Model,
 		Message: models.Message{
-			Role:    role,
-			Content: text.String(),
+			Role:      role,
+			Content:   content,
+			ToolCalls: toolCalls,
 		},
-		FinishReason: r.StopReason,
+		FinishReason: stopReason,
 		Usage: models.Usage{
-			PromptTokens:     r.Usage.InputTokens,
-			CompletionTokens: r.Usage.OutputTokens,
-			TotalTokens:      totalTokens,
+			PromptTokens:        r.Usage.InputTokens,
+			CompletionTokens:    r.Usage.OutputTokens,
+			TotalTokens:         totalTokens,
+			CacheCreationTokens: r.Usage.CacheCreationInputTokens,
+			CacheReadTokens:     r.Usage.CacheReadInputTokens,
 		},
+		Citations:   citations,
+		ServerTools: serverTools,
 	}, nil
 }
 
@@ -289,10 +621,10 @@ func parseAPIError(resp *http.Response) error {
 
 	var apiErr apiErrorResponse
 	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error.Message != "" {
-		return fmt.Errorf("claude error (%s): %s", apiErr.Error.Type, apiErr.Error.Message)
+		return &provider.StatusError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("claude error (%s): %s", apiErr.Error.Type, apiErr.Error.Message)}
 	}
 
-	return fmt.Errorf("upstream error status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	return &provider.StatusError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("upstream error status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))}
 }
 
 func decodeJSON(reader io.Reader, target any) error {
@@ -369,6 +701,20 @@ func extractStringSlice(options map[string]any, key string) ([]string, bool) {
 	return nil, false
 }
 
+// endUserID returns the end-user identifier, taken from the OpenAI user
+// option or, failing that, a user_id metadata entry.
+func endUserID(options map[string]any) string {
+	if user, ok := options["user"].(string); ok && user != "" {
+		return user
+	}
+	if m, ok := extractMap(options, "metadata"); ok {
+		if userID, ok := m["user_id"].(string); ok {
+			return userID
+		}
+	}
+	return ""
+}
+
 func extractMap(options map[string]any, key string) (map[string]any, bool) {
 	if options == nil {
 		return nil, false
@@ -380,3 +726,32 @@ func extractMap(options map[string]any, key string) (map[string]any, bool) {
 	}
 	return nil, false
 }
+
+func extractBool(options map[string]any, key string) (bool, bool) {
+	if options == nil {
+		return false, false
+	}
+	if value, ok := options[key]; ok {
+		if b, ok := value.(bool); ok {
+			return b, true
+		}
+	}
+	return false, false
+}
+
+func extractRaw(options map[string]any, key string) (json.RawMessage, bool) {
+	if options == nil {
+		return nil, false
+	}
+	if value, ok := options[key]; ok {
+		switch v := value.(type) {
+		case json.RawMessage:
+			return v, true
+		case []byte:
+			return json.RawMessage(v), true
+		case string:
+			return json.RawMessage(v), true
+		}
+	}
+	return nil, false
+}
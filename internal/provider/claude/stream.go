@@ -0,0 +1,185 @@
+package claude
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+const contentTypeEventStream = "text/event-stream"
+
+// ChatStream implements provider.Provider, streaming the text and tool
+// calls of the upstream message as they arrive.
+func (p *Provider) ChatStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, error) {
+	req.Stream = true
+	httpReq, err := p.newMessageRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", contentTypeEventStream)
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("claude chat stream request failed: %w", err)
+	}
+	if httpResp.StatusCode >= 400 {
+		defer httpResp.Body.Close()
+		return nil, parseAPIError(httpResp)
+	}
+	return &chatStream{body: httpResp.Body, events: provider.NewEventReader(httpResp.Body), output: -1}, nil
+}
+
+// streamEvent holds the fields of the Anthropic stream events the router
+// reads; each event type sets a few of them.
+type streamEvent struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Message struct {
+		ID    string     `json:"id"`
+		Model string     `json:"model"`
+		Role  string     `json:"role"`
+		Usage usageBlock `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *usageBlock `json:"usage"`
+	Error *apiError   `json:"error"`
+}
+
+// chatStream turns Anthropic stream events into unified chunks:
+// message_start gives the ID and input tokens, text deltas the content,
+// tool_use blocks and their input_json deltas the tool calls, and
+// message_delta the stop reason and output tokens.
+type chatStream struct {
+	mu     sync.Mutex
+	body   io.ReadCloser
+	events *provider.EventReader
+	id     string
+	model  string
+	// start is the usage message_start reports: the input tokens, which
+	// message_delta may leave out, and the prompt cache counts.
+	start usageBlock
+	// tools maps the content block index of each tool_use block to the
+	// index of its tool call.
+	tools map[int]int
+	// output is the content block index of the response_format output
+	// tool, whose input is streamed as content, or -1.
+	output int
+	done   bool
+}
+
+func (s *chatStream) Recv() (models.UnifiedChatChunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for !s.done {
+		_, data, err := s.events.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return models.UnifiedChatChunk{}, fmt.Errorf("read claude stream: %w", err)
+		}
+
+		var event streamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return models.UnifiedChatChunk{}, fmt.Errorf("decode claude stream event: %w", err)
+		}
+		switch event.Type {
+		case "message_start":
+			s.id, s.model, s.start = event.Message.ID, event.Message.Model, event.Message.Usage
+			role := event.Message.Role
+			if role == "" {
+				role = "assistant"
+			}
+			return s.chunk(models.UnifiedChatChunk{Role: role, Usage: &models.Usage{
+				PromptTokens:        s.start.InputTokens,
+				TotalTokens:         s.start.InputTokens,
+				CacheCreationTokens: s.start.CacheCreationInputTokens,
+				CacheReadTokens:     s.start.CacheReadInputTokens,
+			}}), nil
+		case "content_block_start":
+			if event.ContentBlock.Type != "tool_use" {
+				continue
+			}
+			if event.ContentBlock.Name == outputTool {
+				s.output = event.Index
+				continue
+			}
+			if s.tools == nil {
+				s.tools = make(map[int]int)
+			}
+			call := len(s.tools)
+			s.tools[event.Index] = call
+			return s.chunk(models.UnifiedChatChunk{ToolCalls: []models.ToolCallChunk{{Index: call, ID: event.ContentBlock.ID, Name: event.ContentBlock.Name}}}), nil
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				return s.chunk(models.UnifiedChatChunk{Content: event.Delta.Text}), nil
+			case "input_json_delta":
+				if event.Index == s.output {
+					return s.chunk(models.UnifiedChatChunk{Content: event.Delta.PartialJSON}), nil
+				}
+				call, ok := s.tools[event.Index]
+				if !ok {
+					continue
+				}
+				return s.chunk(models.UnifiedChatChunk{ToolCalls: []models.ToolCallChunk{{Index: call, Arguments: event.Delta.PartialJSON}}}), nil
+			default:
+				slog.Debug("skipping unsupported claude stream delta", "type", event.Delta.Type)
+			}
+		case "message_delta":
+			d := models.UnifiedChatChunk{FinishReason: event.Delta.StopReason}
+			if s.output >= 0 && len(s.tools) == 0 {
+				d.FinishReason = structuredStopReason(d.FinishReason)
+			}
+			if event.Usage != nil {
+				u := *event.Usage
+				u.InputTokens = cmp.Or(u.InputTokens, s.start.InputTokens)
+				u.CacheCreationInputTokens = cmp.Or(u.CacheCreationInputTokens, s.start.CacheCreationInputTokens)
+				u.CacheReadInputTokens = cmp.Or(u.CacheReadInputTokens, s.start.CacheReadInputTokens)
+				d.Usage = &models.Usage{
+					PromptTokens:        u.InputTokens,
+					CompletionTokens:    u.OutputTokens,
+					TotalTokens:         u.InputTokens + u.OutputTokens,
+					CacheCreationTokens: u.CacheCreationInputTokens,
+					CacheReadTokens:     u.CacheReadInputTokens,
+				}
+			}
+			return s.chunk(d), nil
+		case "message_stop":
+			s.done = true
+		case "error":
+			if event.Error != nil {
+				return models.UnifiedChatChunk{}, fmt.Errorf("claude error (%s): %s", event.Error.Type, event.Error.Message)
+			}
+			return models.UnifiedChatChunk{}, fmt.Errorf("claude stream error: %s", data)
+		}
+	}
+	s.done = true
+	return models.UnifiedChatChunk{}, io.EOF
+}
+
+func (s *chatStream) chunk(c models.UnifiedChatChunk) models.UnifiedChatChunk {
+	c.ID, c.Model = s.id, s.model
+	return c
+}
+
+func (s *chatStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,77 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gocode-router/internal/provider"
+)
+
+// outputTool is the tool a Claude model is made to call when a request asks
+// for JSON output. Claude has no response_format; the input it gives the
+// tool, which the API checks against the schema, is the answer.
+const outputTool = "json_response"
+
+// objectSchema accepts any JSON object, as response_format json_object
+// does.
+var objectSchema = json.RawMessage(`{"type":"object"}`)
+
+// applyResponseFormat adds the output tool for an OpenAI response_format of
+// json_object or json_schema and makes the model call it. Alongside the
+// client's own tools, the model must call one of them or answer through
+// the output tool.
+func applyResponseFormat(payload *messagePayload, format map[string]any) error {
+	out := tool{Name: outputTool, Description: "Respond with the final answer as JSON.", InputSchema: objectSchema}
+	switch format["type"] {
+	case nil, "", "text":
+		return nil
+	case "json_object":
+	case "json_schema":
+		spec, _ := format["json_schema"].(map[string]any)
+		schema, ok := spec["schema"].(map[string]any)
+		if !ok {
+			return fmt.Errorf("response_format json_schema requires a schema: %w", provider.ErrUnsupportedOperation)
+		}
+		if t, ok := schema["type"]; ok && t != "object" {
+			return fmt.Errorf("claude models only return JSON objects, but the response_format schema has type %v: %w", t, provider.ErrUnsupportedOperation)
+		}
+		raw, err := json.Marshal(schema)
+		if err != nil {
+			return fmt.Errorf("encode response_format schema: %w", err)
+		}
+		out.InputSchema = raw
+		if description, ok := spec["description"].(string); ok && description != "" {
+			out.Description = description
+		}
+	default:
+		return fmt.Errorf("unsupported response_format type %v: %w", format["type"], provider.ErrUnsupportedOperation)
+	}
+
+	for _, t := range payload.Tools {
+		if t.Name == outputTool {
+			return fmt.Errorf("tool name %q is reserved for response_format on claude models: %w", outputTool, provider.ErrUnsupportedOperation)
+		}
+	}
+	choice := payload.ToolChoice
+	switch {
+	case choice != nil && choice.Type == "none":
+		return fmt.Errorf("response_format cannot be combined with tool_choice none on claude models: %w", provider.ErrUnsupportedOperation)
+	case len(payload.Tools) == 0:
+		payload.ToolChoice = &toolChoice{Type: "tool", Name: outputTool}
+	case choice == nil:
+		payload.ToolChoice = &toolChoice{Type: "any"}
+	case choice.Type == "auto":
+		choice.Type = "any"
+	}
+	payload.Tools = append(payload.Tools, out)
+	return nil
+}
+
+// structuredStopReason reports an answer given through the output tool as
+// a finished turn rather than a tool call.
+func structuredStopReason(stopReason string) string {
+	if stopReason == "tool_use" {
+		return "end_turn"
+	}
+	return stopReason
+}
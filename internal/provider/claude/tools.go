@@ -0,0 +1,160 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gocode-router/internal/models"
+)
+
+// tool is an Anthropic tool definition.
+type tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+
+	CacheControl *cacheControl `json:"cache_control,omitempty"`
+}
+
+// toolChoice is Anthropic's tool_choice.
+type toolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+// emptySchema is sent for functions without parameters; Anthropic requires
+// an input schema on every tool.
+var emptySchema = json.RawMessage(`{"type":"object","properties":{}}`)
+
+// convertTools rewrites tool definitions as Anthropic tools.
+func convertTools(tools []models.ToolDefinition) ([]tool, error) {
+	if len(tools) == 0 {
+		return nil, nil
+	}
+	out := make([]tool, 0, len(tools))
+	for i, t := range tools {
+		if strings.TrimSpace(t.Name) == "" {
+			return nil, fmt.Errorf("tools[%d] is missing a function name", i)
+		}
+		schema := t.Parameters
+		if len(schema) == 0 || string(schema) == "null" {
+			schema = emptySchema
+		}
+		out = append(out, tool{Name: t.Name, Description: t.Description, InputSchema: schema, CacheControl: convertCacheControl(t.CacheControl)})
+	}
+	return out, nil
+}
+
+// toolInput decodes the arguments of a tool call into a tool_use input,
+// which Anthropic requires to be a JSON object.
+func toolInput(arguments string) (json.RawMessage, error) {
+	if strings.TrimSpace(arguments) == "" {
+		return json.RawMessage(`{}`), nil
+	}
+	var input map[string]any
+	if err := json.Unmarshal([]byte(arguments), &input); err != nil {
+		return nil, fmt.Errorf("tool call arguments must be a JSON object: %w", err)
+	}
+	return json.RawMessage(arguments), nil
+}
+
+// appendMessage adds blocks to the conversation as a turn of role. Blocks
+// that follow tool results in a user turn join that turn, as Anthropic
+// expects the results of parallel calls in a single message.
+func appendMessage(messages []message, role string, blocks []contentBlock) []message {
+	if n := len(messages); n > 0 && role == "user" && messages[n-1].Role == "user" && hasToolResult(messages[n-1].Content) {
+		messages[n-1].Content = append(messages[n-1].Content, blocks...)
+		return messages
+	}
+	return append(messages, message{Role: role, Content: blocks})
+}
+
+// appendToolResult adds a tool result to the user turn that answers the
+// calls. Anthropic expects the results to open that turn, so they go ahead
+// of any text the client sent between the calls and their results.
+func appendToolResult(messages []message, result contentBlock) []message {
+	n := len(messages)
+	if n == 0 || messages[n-1].Role != "user" {
+		return append(messages, message{Role: "user", Content: []contentBlock{result}})
+	}
+	turn := &messages[n-1]
+	i := 0
+	for i < len(turn.Content) && turn.Content[i].Type == "tool_result" {
+		i++
+	}
+	turn.Content = slices.Insert(turn.Content, i, result)
+	return messages
+}
+
+// toolUseID returns id with the characters Anthropic does not allow in
+// tool use IDs replaced, so calls made through other providers, whose IDs
+// may hold dots or colons, can be answered.
+func toolUseID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+func hasToolResult(blocks []contentBlock) bool {
+	for _, block := range blocks {
+		if block.Type == "tool_result" {
+			return true
+		}
+	}
+	return false
+}
+
+// convertToolChoice maps OpenAI's tool_choice onto Anthropic's: auto and
+// none carry over, required becomes any and a named function becomes tool.
+// parallel_tool_calls=false becomes disable_parallel_tool_use, which
+// Anthropic only accepts alongside a choice, so auto is assumed without one.
+func convertToolChoice(raw json.RawMessage, parallel *bool) (*toolChoice, error) {
+	var choice *toolChoice
+	if len(raw) > 0 && string(raw) != "null" {
+		choice = &toolChoice{}
+
+		var mode string
+		if err := json.Unmarshal(raw, &mode); err == nil {
+			switch mode {
+			case "auto", "none":
+				choice.Type = mode
+			case "required":
+				choice.Type = "any"
+			default:
+				return nil, fmt.Errorf("unsupported tool_choice %q", mode)
+			}
+		} else {
+			var named struct {
+				Type     string `json:"type"`
+				Function struct {
+					Name string `json:"name"`
+				} `json:"function"`
+			}
+			if err := json.Unmarshal(raw, &named); err != nil {
+				return nil, fmt.Errorf("decode tool_choice: %w", err)
+			}
+			if named.Type != "function" || strings.TrimSpace(named.Function.Name) == "" {
+				return nil, errors.New("tool_choice must name a function")
+			}
+			choice.Type = "tool"
+			choice.Name = named.Function.Name
+		}
+	}
+
+	if parallel != nil && !*parallel {
+		if choice == nil {
+			choice = &toolChoice{Type: "auto"}
+		}
+		if choice.Type != "none" {
+			choice.DisableParallelToolUse = true
+		}
+	}
+	return choice, nil
+}
@@ -0,0 +1,67 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/provider/credentials"
+)
+
+// vertexAnthropicVersion is the API version Vertex AI takes in the body of
+// Claude requests.
+const vertexAnthropicVersion = "vertex-2023-10-16"
+
+// vertexEndpoint addresses Claude on Vertex AI, which serves each model at
+// its own URL under Anthropic's publisher path and names the model in the
+// URL rather than the body.
+type vertexEndpoint struct {
+	// models is the URL of the publisher's models.
+	models string
+}
+
+// NewVertex creates a provider for Claude models on Vertex AI, reached at
+// baseURL, the regional API host, with Google tokens.
+func NewVertex(name string, cfg config.ProviderConfig, client *http.Client) (*Provider, error) {
+	tokens, err := credentials.New(cfg.Auth, client)
+	if err != nil {
+		return nil, fmt.Errorf("claude provider %q: %w", name, err)
+	}
+	if tokens == nil {
+		return nil, fmt.Errorf("claude provider %q: vertex ai needs google auth", name)
+	}
+
+	cfg.Auth = config.ProviderAuthConfig{}
+	p, err := New(name, cfg, client)
+	if err != nil {
+		return nil, err
+	}
+	p.tokens = tokens
+	p.vertex = &vertexEndpoint{models: p.baseURL + cfg.Vertex.LocationPath() + "/publishers/anthropic/models/"}
+	return p, nil
+}
+
+// messagesURL returns the URL of a model's messages endpoint.
+func (v *vertexEndpoint) messagesURL(model string, stream bool) string {
+	method := ":rawPredict"
+	if stream {
+		method = ":streamRawPredict"
+	}
+	return v.models + url.PathEscape(model) + method
+}
+
+// vertexBody adapts the body of an Anthropic messages request for Vertex
+// AI: the model moves to the URL and the API version into the body.
+func vertexBody(body []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("decode passthrough body: %w", err)
+	}
+	delete(fields, "model")
+	if _, ok := fields["anthropic_version"]; !ok {
+		fields["anthropic_version"] = json.RawMessage(`"` + vertexAnthropicVersion + `"`)
+	}
+	return json.Marshal(fields)
+}
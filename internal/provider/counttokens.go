@@ -0,0 +1,15 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+)
+
+// TokenCounter is implemented by providers whose upstream counts the input
+// tokens of a Claude messages request without running it.
+type TokenCounter interface {
+	// CountTokens returns the input tokens of body, a Claude messages
+	// request for the upstream model that already names it. Header holds
+	// client headers to forward, such as anthropic-beta.
+	CountTokens(ctx context.Context, model string, body []byte, header http.Header) (int, error)
+}
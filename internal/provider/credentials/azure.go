@@ -0,0 +1,135 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+const (
+	azureAuthority = "https://login.microsoftonline.com/"
+	// azureIMDSEndpoint is the instance metadata service reachable from VMs
+	// and AKS nodes.
+	azureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// newAzureSource returns a source of Microsoft Entra ID tokens, obtained
+// with client credentials or from the managed identity of the host.
+func newAzureSource(cfg config.AzureAuthConfig, client *http.Client) (TokenSource, error) {
+	if client == nil {
+		return nil, errors.New("http client must not be nil")
+	}
+	scope := cfg.TokenScope()
+
+	if cfg.ManagedIdentity {
+		resource := strings.TrimSuffix(scope, "/.default")
+		return newCachedSource(func(ctx context.Context) (string, time.Time, error) {
+			return fetchManagedIdentityToken(ctx, client, resource, cfg.ClientID)
+		}), nil
+	}
+
+	tokenURL := azureAuthority + url.PathEscape(cfg.TenantID) + "/oauth2/v2.0/token"
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {cfg.ClientID},
+		"client_secret": {cfg.ClientSecret},
+		"scope":         {scope},
+	}
+	return newCachedSource(func(ctx context.Context) (string, time.Time, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("construct entra token request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return doAzureTokenRequest(client, req)
+	}), nil
+}
+
+// fetchManagedIdentityToken asks the host for a token. App Service and
+// Container Apps expose their own endpoint through the environment; other
+// Azure compute answers on the instance metadata service.
+func fetchManagedIdentityToken(ctx context.Context, client *http.Client, resource, clientID string) (string, time.Time, error) {
+	query := url.Values{"resource": {resource}}
+	if clientID != "" {
+		query.Set("client_id", clientID)
+	}
+
+	var req *http.Request
+	var err error
+	if endpoint, secret := os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER"); endpoint != "" && secret != "" {
+		query.Set("api-version", "2019-08-01")
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+		if err == nil {
+			req.Header.Set("X-IDENTITY-HEADER", secret)
+		}
+	} else {
+		query.Set("api-version", "2018-02-01")
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, azureIMDSEndpoint+"?"+query.Encode(), nil)
+		if err == nil {
+			req.Header.Set("Metadata", "true")
+		}
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("construct managed identity token request: %w", err)
+	}
+	return doAzureTokenRequest(client, req)
+}
+
+// azureTokenResponse covers both token endpoints: Entra reports expires_in
+// as a number, the managed identity endpoints as strings with expires_on.
+type azureTokenResponse struct {
+	AccessToken string          `json:"access_token"`
+	ExpiresIn   json.RawMessage `json:"expires_in"`
+	ExpiresOn   json.RawMessage `json:"expires_on"`
+	Error       string          `json:"error"`
+	Description string          `json:"error_description"`
+}
+
+func doAzureTokenRequest(client *http.Client, req *http.Request) (string, time.Time, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("azure token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("read azure token response: %w", err)
+	}
+
+	var token azureTokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", time.Time{}, fmt.Errorf("azure token endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode >= 400 || token.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("azure token endpoint returned status %d: %s %s", resp.StatusCode, token.Error, token.Description)
+	}
+
+	if on, ok := parseJSONInt(token.ExpiresOn); ok {
+		return token.AccessToken, time.Unix(on, 0), nil
+	}
+	if in, ok := parseJSONInt(token.ExpiresIn); ok {
+		return token.AccessToken, time.Now().Add(time.Duration(in) * time.Second), nil
+	}
+	return "", time.Time{}, errors.New("azure token response carried no expiry")
+}
+
+// parseJSONInt reads an integer sent either as a number or a string.
+func parseJSONInt(raw json.RawMessage) (int64, bool) {
+	if len(raw) == 0 {
+		return 0, false
+	}
+	text := strings.Trim(string(raw), `"`)
+	n, err := strconv.ParseInt(text, 10, 64)
+	return n, err == nil
+}
@@ -0,0 +1,71 @@
+// Package credentials acquires and refreshes the short-lived tokens some
+// upstreams require instead of a static API key.
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+// refreshMargin is how long before expiry a token is replaced, so requests
+// never go out with a token that lapses in flight.
+const refreshMargin = 5 * time.Minute
+
+// TokenSource supplies bearer tokens for upstream requests.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// fetchFunc obtains a fresh token and its expiry.
+type fetchFunc func(ctx context.Context) (string, time.Time, error)
+
+// cachedSource reuses a token until it nears expiry.
+type cachedSource struct {
+	fetch fetchFunc
+	now   func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newCachedSource(fetch fetchFunc) *cachedSource {
+	return &cachedSource{fetch: fetch, now: time.Now}
+}
+
+// Token implements TokenSource.
+func (c *cachedSource) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && c.now().Add(refreshMargin).Before(c.expires) {
+		return c.token, nil
+	}
+
+	token, expires, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expires = token, expires
+	return token, nil
+}
+
+// New builds the token source selected by a provider's auth configuration.
+// It returns nil when the provider authenticates with its static API key.
+func New(cfg config.ProviderAuthConfig, client *http.Client) (TokenSource, error) {
+	switch cfg.Type {
+	case "", config.AuthTypeAPIKey:
+		return nil, nil
+	case config.AuthTypeAzureAD:
+		return newAzureSource(cfg.Azure, client)
+	case config.AuthTypeGoogle:
+		return newGoogleSource(cfg.Google, client)
+	default:
+		return nil, fmt.Errorf("unknown auth type %q", cfg.Type)
+	}
+}
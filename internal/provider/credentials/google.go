@@ -0,0 +1,326 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+const (
+	googleTokenURL        = "https://oauth2.googleapis.com/token"
+	googleMetadataToken   = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	googleJWTBearerGrant  = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	googleTokenExchange   = "urn:ietf:params:oauth:grant-type:token-exchange"
+	googleAccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+	googleAssertionTTL    = time.Hour
+)
+
+// googleCredentialsFile covers the two JSON credential formats gcloud and
+// the console produce: service account keys and workload identity
+// federation configurations.
+type googleCredentialsFile struct {
+	Type string `json:"type"`
+
+	// service_account
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+
+	// external_account
+	Audience                       string `json:"audience"`
+	SubjectTokenType               string `json:"subject_token_type"`
+	TokenURL                       string `json:"token_url"`
+	ServiceAccountImpersonationURL string `json:"service_account_impersonation_url"`
+	CredentialSource               struct {
+		File    string            `json:"file"`
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+		Format  struct {
+			Type                  string `json:"type"`
+			SubjectTokenFieldName string `json:"subject_token_field_name"`
+		} `json:"format"`
+	} `json:"credential_source"`
+}
+
+// newGoogleSource follows Application Default Credentials: an explicit
+// credentials file, then GOOGLE_APPLICATION_CREDENTIALS, then the metadata
+// server of the GCE, GKE or Cloud Run host, which also serves tokens for
+// GKE workload identity.
+func newGoogleSource(cfg config.GoogleAuthConfig, client *http.Client) (TokenSource, error) {
+	if client == nil {
+		return nil, errors.New("http client must not be nil")
+	}
+	scopes := cfg.TokenScopes()
+
+	path := cfg.CredentialsFile
+	if path == "" {
+		path = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if path == "" {
+		return newCachedSource(func(ctx context.Context) (string, time.Time, error) {
+			return fetchGoogleMetadataToken(ctx, client, scopes)
+		}), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read google credentials: %w", err)
+	}
+	var creds googleCredentialsFile
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("parse google credentials %q: %w", path, err)
+	}
+
+	switch creds.Type {
+	case "service_account":
+		key, err := parseRSAPrivateKey(creds.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("google credentials %q: %w", path, err)
+		}
+		return newCachedSource(func(ctx context.Context) (string, time.Time, error) {
+			return fetchGoogleServiceAccountToken(ctx, client, creds, key, scopes)
+		}), nil
+	case "external_account":
+		return newCachedSource(func(ctx context.Context) (string, time.Time, error) {
+			return fetchGoogleExternalAccountToken(ctx, client, creds, scopes)
+		}), nil
+	default:
+		return nil, fmt.Errorf("google credentials %q: unsupported type %q", path, creds.Type)
+	}
+}
+
+// fetchGoogleServiceAccountToken exchanges a self-signed JWT for an access
+// token.
+func fetchGoogleServiceAccountToken(ctx context.Context, client *http.Client, creds googleCredentialsFile, key *rsa.PrivateKey, scopes []string) (string, time.Time, error) {
+	tokenURL := creds.TokenURI
+	if tokenURL == "" {
+		tokenURL = googleTokenURL
+	}
+
+	now := time.Now()
+	assertion, err := signJWT(key, creds.PrivateKeyID, map[string]any{
+		"iss":   creds.ClientEmail,
+		"scope": strings.Join(scopes, " "),
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(googleAssertionTTL).Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	form := url.Values{"grant_type": {googleJWTBearerGrant}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("construct google token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doGoogleTokenRequest(client, req)
+}
+
+// fetchGoogleExternalAccountToken trades a token issued by another identity
+// provider for a Google access token at the STS endpoint, then optionally
+// impersonates a service account with it.
+func fetchGoogleExternalAccountToken(ctx context.Context, client *http.Client, creds googleCredentialsFile, scopes []string) (string, time.Time, error) {
+	subject, err := readSubjectToken(ctx, client, creds)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	form := url.Values{
+		"grant_type":           {googleTokenExchange},
+		"audience":             {creds.Audience},
+		"scope":                {strings.Join(scopes, " ")},
+		"requested_token_type": {googleAccessTokenType},
+		"subject_token_type":   {creds.SubjectTokenType},
+		"subject_token":        {subject},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, creds.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("construct google sts request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	federated, expires, err := doGoogleTokenRequest(client, req)
+	if err != nil || creds.ServiceAccountImpersonationURL == "" {
+		return federated, expires, err
+	}
+
+	body, err := json.Marshal(map[string]any{"scope": scopes, "lifetime": "3600s"})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("marshal impersonation request: %w", err)
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, creds.ServiceAccountImpersonationURL, bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("construct impersonation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+federated)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("google impersonation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	var impersonated struct {
+		AccessToken string    `json:"accessToken"`
+		ExpireTime  time.Time `json:"expireTime"`
+	}
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return "", time.Time{}, fmt.Errorf("google impersonation returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&impersonated); err != nil {
+		return "", time.Time{}, fmt.Errorf("decode impersonation response: %w", err)
+	}
+	return impersonated.AccessToken, impersonated.ExpireTime, nil
+}
+
+// readSubjectToken loads the external identity token from a file or URL,
+// as plain text or from a field of a JSON document.
+func readSubjectToken(ctx context.Context, client *http.Client, creds googleCredentialsFile) (string, error) {
+	source := creds.CredentialSource
+
+	var data []byte
+	var err error
+	switch {
+	case source.File != "":
+		data, err = os.ReadFile(source.File)
+		if err != nil {
+			return "", fmt.Errorf("read subject token: %w", err)
+		}
+	case source.URL != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.URL, nil)
+		if err != nil {
+			return "", fmt.Errorf("construct subject token request: %w", err)
+		}
+		for k, v := range source.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("fetch subject token: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return "", fmt.Errorf("fetch subject token: status %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, 64*1024)); err != nil {
+			return "", fmt.Errorf("read subject token: %w", err)
+		}
+	default:
+		return "", errors.New("external account credential_source needs a file or url")
+	}
+
+	if source.Format.Type != "json" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("parse subject token: %w", err)
+	}
+	token, _ := doc[source.Format.SubjectTokenFieldName].(string)
+	if token == "" {
+		return "", fmt.Errorf("subject token field %q missing", source.Format.SubjectTokenFieldName)
+	}
+	return token, nil
+}
+
+func fetchGoogleMetadataToken(ctx context.Context, client *http.Client, scopes []string) (string, time.Time, error) {
+	endpoint := googleMetadataToken + "?" + url.Values{"scopes": {strings.Join(scopes, ",")}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("construct metadata token request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return doGoogleTokenRequest(client, req)
+}
+
+func doGoogleTokenRequest(client *http.Client, req *http.Request) (string, time.Time, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("google token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("read google token response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return "", time.Time{}, fmt.Errorf("google token endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", time.Time{}, fmt.Errorf("decode google token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, errors.New("google token response carried no access token")
+	}
+	return token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn) * time.Second), nil
+}
+
+func parseRSAPrivateKey(pemData string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// signJWT produces an RS256-signed JSON Web Token.
+func signJWT(key *rsa.PrivateKey, keyID string, claims map[string]any) (string, error) {
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if keyID != "" {
+		header["kid"] = keyID
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("marshal jwt header: %w", err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("marshal jwt claims: %w", err)
+	}
+
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(headerJSON) + "." + enc.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign jwt: %w", err)
+	}
+	return signingInput + "." + enc.EncodeToString(signature), nil
+}
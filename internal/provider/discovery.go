@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gocode-router/internal/models"
+)
+
+// discoveryTimeout bounds one fetch of an upstream model list.
+const discoveryTimeout = 30 * time.Second
+
+// discoveryRetry is how soon a failed fetch is retried, unless the refresh
+// interval is shorter.
+const discoveryRetry = 30 * time.Second
+
+// Discoverer is implemented by providers that can list the models their
+// upstream serves.
+type Discoverer interface {
+	DiscoverModels(ctx context.Context) ([]models.Model, error)
+}
+
+// discovery tracks the refresh schedule of one provider and the models it
+// added to the registry.
+type discovery struct {
+	provider Discoverer
+	every    time.Duration
+	next     time.Time
+	models   map[string]bool
+}
+
+// EnableDiscovery fetches the upstream model list of a registered provider
+// and schedules it to be refetched every interval. A failed first fetch is
+// logged and retried by RefreshModels, so the configured models still serve.
+func (r *Registry) EnableDiscovery(ctx context.Context, name string, every time.Duration) error {
+	r.mu.Lock()
+	p, ok := r.byName[name]
+	if !ok {
+		r.mu.Unlock()
+		return fmt.Errorf("provider %q is not registered", name)
+	}
+	d, ok := p.(Discoverer)
+	if !ok {
+		r.mu.Unlock()
+		return fmt.Errorf("provider %q does not support model discovery", name)
+	}
+	if r.discovery == nil {
+		r.discovery = make(map[string]*discovery)
+	}
+	r.discovery[name] = &discovery{provider: d, every: every, models: make(map[string]bool)}
+	r.mu.Unlock()
+
+	r.refresh(ctx, name, time.Now())
+	return nil
+}
+
+// RefreshModels refetches the model list of every provider whose refresh is
+// due, adding the models upstream started listing and dropping the ones it
+// stopped listing.
+func (r *Registry) RefreshModels(ctx context.Context) {
+	now := time.Now()
+	r.mu.RLock()
+	var due []string
+	for name, d := range r.discovery {
+		if !now.Before(d.next) {
+			due = append(due, name)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, name := range due {
+		r.refresh(ctx, name, now)
+	}
+}
+
+func (r *Registry) refresh(ctx context.Context, name string, now time.Time) {
+	r.mu.RLock()
+	d := r.discovery[name]
+	p := r.byName[name]
+	r.mu.RUnlock()
+
+	fetchCtx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	listed, err := d.provider.DiscoverModels(fetchCtx)
+	cancel()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		d.next = now.Add(min(d.every, discoveryRetry))
+		slog.Warn("model discovery failed", "provider", name, "error", err)
+		return
+	}
+	d.next = now.Add(d.every)
+
+	seen := make(map[string]bool, len(listed))
+	var added, removed []string
+	for _, model := range listed {
+		seen[model.ID] = true
+		if d.models[model.ID] {
+			continue
+		}
+		// Configured models, aliases and other providers' models keep
+		// their entries.
+		if _, exists := r.models[model.ID]; exists {
+			continue
+		}
+		model.Provider = name
+		r.models[model.ID] = modelEntry{model: model, provider: p}
+		d.models[model.ID] = true
+		added = append(added, model.ID)
+	}
+	for id := range d.models {
+		if !seen[id] {
+			delete(r.models, id)
+			delete(d.models, id)
+			removed = append(removed, id)
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		slog.Info("model discovery updated models", "provider", name, "added", added, "removed", removed)
+	}
+}
@@ -1,22 +1,29 @@
 package factory
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"net"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"gocode-router/internal/config"
+	"gocode-router/internal/models"
 	"gocode-router/internal/provider"
 	claudeProvider "gocode-router/internal/provider/claude"
+	fixtureProvider "gocode-router/internal/provider/fixture"
 	nvidiaProvider "gocode-router/internal/provider/nvidia"
 	openaiProvider "gocode-router/internal/provider/openai"
+	vertexProvider "gocode-router/internal/provider/vertex"
+	"gocode-router/internal/telemetry"
 )
 
 const (
-	defaultHTTPTimeout     = 60 * time.Second
 	defaultDialTimeout     = 10 * time.Second
 	defaultKeepAlive       = 30 * time.Second
 	defaultIdleConnTimeout = 90 * time.Second
@@ -28,42 +35,164 @@ func RegisterConfiguredProviders(ctx context.Context, cfg config.Config, registr
 		return errors.New("registry must not be nil")
 	}
 
-	openAIClient := newHTTPClient(defaultHTTPTimeout)
-	openAIProvider, err := openaiProvider.New("openai", cfg.Providers.OpenAI, openAIClient)
-	if err != nil {
-		return fmt.Errorf("initialise openai provider: %w", err)
+	// Aliases are wired once every provider is registered, so they may
+	// point at another provider's models.
+	providers := cfg.Providers.Named()
+	names := slices.Sorted(maps.Keys(providers))
+	for _, name := range names {
+		providerCfg := providers[name]
+		// Virtual models are registered over the provider's own models.
+		servedCfg := providerCfg
+		servedCfg.Models = slices.DeleteFunc(slices.Clone(providerCfg.Models), config.ModelConfig.IsVirtual)
+		p, err := newProvider(name, servedCfg, registry.RateLimits())
+		if err != nil {
+			return fmt.Errorf("initialise %s provider: %w", name, err)
+		}
+		registry.SetTimeouts(name, provider.Timeouts{Request: providerCfg.Timeout, StreamIdle: providerCfg.StreamIdleTimeout})
+		if err := registry.RegisterProvider(ctx, p, nil); err != nil {
+			return fmt.Errorf("register %s provider: %w", name, err)
+		}
+		if err := registerPatterns(registry, p, providerCfg.Models); err != nil {
+			return fmt.Errorf("register %s model patterns: %w", name, err)
+		}
+		if err := registerVirtualModels(ctx, registry, p, providerCfg.Models); err != nil {
+			return fmt.Errorf("register %s virtual models: %w", name, err)
+		}
+		if discovery := providerCfg.Discovery; discovery.Enabled {
+			if err := registry.EnableDiscovery(ctx, name, discovery.RefreshInterval()); err != nil {
+				return fmt.Errorf("enable %s model discovery: %w", name, err)
+			}
+		}
 	}
-	if err := registry.RegisterProvider(ctx, openAIProvider, cfg.Providers.OpenAI.Aliases); err != nil {
-		return fmt.Errorf("register openai provider: %w", err)
+	for _, name := range names {
+		if err := registry.RegisterAliases(providers[name].Aliases); err != nil {
+			return fmt.Errorf("register %s aliases: %w", name, err)
+		}
 	}
 
-	claudeClient := newHTTPClient(defaultHTTPTimeout)
-	claudeProvider, err := claudeProvider.New("claude", cfg.Providers.Claude, claudeClient)
-	if err != nil {
-		return fmt.Errorf("initialise claude provider: %w", err)
-	}
-	if err := registry.RegisterProvider(ctx, claudeProvider, cfg.Providers.Claude.Aliases); err != nil {
-		return fmt.Errorf("register claude provider: %w", err)
+	if cfg.Providers.Fixture != nil {
+		fixtureProvider, err := fixtureProvider.New("fixture", *cfg.Providers.Fixture)
+		if err != nil {
+			return fmt.Errorf("initialise fixture provider: %w", err)
+		}
+		if err := registry.RegisterProvider(ctx, fixtureProvider, cfg.Providers.Fixture.Aliases); err != nil {
+			return fmt.Errorf("register fixture provider: %w", err)
+		}
 	}
 
-	if cfg.Providers.NVIDIA != nil {
-		nvidiaClient := newHTTPClient(defaultHTTPTimeout)
-		nvidiaProvider, err := nvidiaProvider.New("nvidia", *cfg.Providers.NVIDIA, nvidiaClient)
-		if err != nil {
-			return fmt.Errorf("initialise nvidia provider: %w", err)
+	return nil
+}
+
+// registerPatterns registers the models a provider serves by pattern.
+func registerPatterns(registry *provider.Registry, p provider.Provider, modelConfigs []config.ModelConfig) error {
+	for _, model := range modelConfigs {
+		if !model.IsPattern() {
+			continue
+		}
+		pattern := provider.ModelPattern{
+			Match:       model.Matches,
+			Specificity: model.Specificity(),
+			Model:       patternModel(p, model),
 		}
-		if err := registry.RegisterProvider(ctx, nvidiaProvider, cfg.Providers.NVIDIA.Aliases); err != nil {
-			return fmt.Errorf("register nvidia provider: %w", err)
+		if err := registry.RegisterPattern(p, pattern); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// patternModel describes the models a pattern serves, but for their ID.
+func patternModel(p provider.Provider, model config.ModelConfig) models.Model {
+	return models.Model{
+		Provider:          p.Name(),
+		APIStyle:          strings.TrimSpace(strings.ToLower(model.APIStyle)),
+		MaxOutputTokens:   model.MaxOutputTokens,
+		ContextWindow:     model.ContextWindow,
+		Timeout:           model.Timeout,
+		StreamIdleTimeout: model.StreamIdleTimeout,
+		Weight:            model.Weight,
+		Passthrough:       model.Passthrough,
+		SystemPrompt:      model.SystemPrompt,
+		PrependSystem:     model.Prepend,
+		DefaultOptions:    model.DefaultOptions(),
+		OverrideOptions:   model.OverrideOptions(),
+		Concurrency: models.ConcurrencyLimit{
+			Max:          model.Concurrency.Max,
+			Queue:        model.Concurrency.Queue,
+			QueueTimeout: model.Concurrency.QueueTimeout,
+		},
+	}
+}
 
+// registerVirtualModels registers the models a provider serves through
+// another of its models, configured by ID or pattern, with their own
+// system prompt.
+func registerVirtualModels(ctx context.Context, registry *provider.Registry, p provider.Provider, modelConfigs []config.ModelConfig) error {
+	var listed []models.Model
+	for _, model := range modelConfigs {
+		if !model.IsVirtual() {
+			continue
+		}
+		if listed == nil {
+			var err error
+			if listed, err = p.ListModels(ctx); err != nil {
+				return err
+			}
+		}
+		i := slices.IndexFunc(listed, func(m models.Model) bool { return m.ID == model.Target })
+		var target models.Model
+		if i >= 0 {
+			target = listed[i]
+		} else if pattern, ok := config.MatchModelPattern(modelConfigs, model.Target); ok {
+			target = patternModel(p, pattern)
+			target.ID = model.Target
+		} else {
+			return fmt.Errorf("model %s: target %s is not a model of the provider", model.ID, model.Target)
+		}
+		target.DisplayName = model.DisplayName
+		target.SystemPrompt = model.SystemPrompt
+		target.PrependSystem = model.Prepend
+		target.DefaultOptions = model.DefaultOptions()
+		target.OverrideOptions = model.OverrideOptions()
+		if err := registry.RegisterVirtual(p, model.ID, target); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func newHTTPClient(timeout time.Duration) *http.Client {
+// newProvider constructs a provider of the configured type.
+func newProvider(name string, cfg config.ProviderConfig, limits *provider.RateLimits) (provider.Provider, error) {
+	var observe func(http.RoundTripper) http.RoundTripper
+	if !cfg.RateLimits.Ignore {
+		limits.SetReserve(name, provider.RateLimitReserve{Requests: cfg.RateLimits.ReserveRequests, Tokens: cfg.RateLimits.ReserveTokens})
+		observe = func(base http.RoundTripper) http.RoundTripper { return limits.Transport(name, base) }
+	}
+	client := newHTTPClient(cmp.Or(cfg.ConnectTimeout, defaultDialTimeout), cfg.Retry, observe)
+	switch cfg.Type {
+	case config.ProviderTypeOpenAI, config.ProviderTypeGroq:
+		return openaiProvider.New(name, cfg, client)
+	case config.ProviderTypeClaude:
+		return claudeProvider.New(name, cfg, client)
+	case config.ProviderTypeNVIDIA:
+		return nvidiaProvider.New(name, cfg, client)
+	case config.ProviderTypeAzure:
+		return openaiProvider.NewAzure(name, cfg, client)
+	case config.ProviderTypeVertex:
+		return vertexProvider.New(name, cfg, client)
+	default:
+		return nil, fmt.Errorf("unknown provider type %q", cfg.Type)
+	}
+}
+
+// newHTTPClient builds a provider's client. Retries wrap tracing, so each
+// attempt is a span of its own. observe, when set, wraps each attempt too,
+// so every response is seen. Requests are bounded by the router, per model,
+// rather than by the client.
+func newHTTPClient(connectTimeout time.Duration, retry config.RetryConfig, observe func(http.RoundTripper) http.RoundTripper) *http.Client {
 	transport := &http.Transport{
 		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           (&net.Dialer{Timeout: defaultDialTimeout, KeepAlive: defaultKeepAlive}).DialContext,
+		DialContext:           (&net.Dialer{Timeout: connectTimeout, KeepAlive: defaultKeepAlive}).DialContext,
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          50,
 		IdleConnTimeout:       defaultIdleConnTimeout,
@@ -71,8 +200,11 @@ func newHTTPClient(timeout time.Duration) *http.Client {
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
+	var attempt http.RoundTripper = &telemetry.Transport{Base: transport}
+	if observe != nil {
+		attempt = observe(attempt)
+	}
 	return &http.Client{
-		Timeout:   timeout,
-		Transport: transport,
+		Transport: &provider.RetryTransport{Base: attempt, Config: retry},
 	}
 }
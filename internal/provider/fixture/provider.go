@@ -0,0 +1,221 @@
+// Package fixture implements a provider that answers from files on disk, so
+// clients can be tested end to end against the router without network
+// access.
+package fixture
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+	"gocode-router/internal/tokens"
+)
+
+const (
+	fixtureExt      = ".txt"
+	defaultTemplate = "default.tmpl"
+)
+
+// ErrNoFixture reports that neither a fixture file nor a template matched a
+// request.
+var ErrNoFixture = errors.New("no fixture matches the request")
+
+// Provider answers requests from a fixture directory.
+type Provider struct {
+	name   string
+	dir    string
+	models []models.Model
+}
+
+// New constructs a fixture provider serving the configured models from
+// cfg.Dir.
+func New(name string, cfg config.FixtureProviderConfig) (*Provider, error) {
+	info, err := os.Stat(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("fixture provider %q: %w", name, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("fixture provider %q: %s is not a directory", name, cfg.Dir)
+	}
+
+	modelsList := make([]models.Model, 0, len(cfg.Models))
+	for _, model := range cfg.Models {
+		modelsList = append(modelsList, models.Model{
+			ID:                model.ID,
+			Provider:          name,
+			APIStyle:          model.APIStyle,
+			MaxOutputTokens:   model.MaxOutputTokens,
+			ContextWindow:     model.ContextWindow,
+			Timeout:           model.Timeout,
+			StreamIdleTimeout: model.StreamIdleTimeout,
+			DisplayName:       model.DisplayName,
+			Weight:            model.Weight,
+			Concurrency: models.ConcurrencyLimit{
+				Max:          model.Concurrency.Max,
+				Queue:        model.Concurrency.Queue,
+				QueueTimeout: model.Concurrency.QueueTimeout,
+			},
+		})
+	}
+
+	return &Provider{name: name, dir: cfg.Dir, models: modelsList}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+func (p *Provider) ListModels(ctx context.Context) ([]models.Model, error) {
+	result := make([]models.Model, len(p.models))
+	copy(result, p.models)
+	return result, nil
+}
+
+func (p *Provider) Chat(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, error) {
+	if req.Stream {
+		return nil, fmt.Errorf("streaming is not yet supported for provider %s: %w", p.name, provider.ErrUnsupportedOperation)
+	}
+	hash := Hash(req.Model, req.Messages)
+	text, err := p.respond(req.Model, hash, req.Messages)
+	if err != nil {
+		return nil, err
+	}
+	usage := usageFor(tokens.CountMessages(tokens.Approximate, req.Messages), text)
+	return &models.UnifiedChatResponse{
+		Message:      models.Message{Role: "assistant", Content: text},
+		Usage:        usage,
+		FinishReason: "stop",
+		ID:           "fixture-" + hash,
+		Model:        req.Model,
+	}, nil
+}
+
+// ChatStream implements provider.Provider, replaying the fixture answer as
+// a stream.
+func (p *Provider) ChatStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, error) {
+	req.Stream = false
+	resp, err := p.Chat(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return provider.ReplayStream(resp), nil
+}
+
+// Completion answers a prompt as a chat with a single user message, so the
+// two share fixtures.
+func (p *Provider) Completion(ctx context.Context, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, error) {
+	if req.Stream {
+		return nil, fmt.Errorf("streaming is not yet supported for provider %s: %w", p.name, provider.ErrUnsupportedOperation)
+	}
+	messages := []models.Message{{Role: "user", Content: req.Prompt}}
+	hash := Hash(req.Model, messages)
+	text, err := p.respond(req.Model, hash, messages)
+	if err != nil {
+		return nil, err
+	}
+	return &models.UnifiedCompletionResponse{
+		Text:         text,
+		Usage:        usageFor(len(tokens.Approximate.Encode(req.Prompt)), text),
+		FinishReason: "stop",
+		ID:           "fixture-" + hash,
+		Model:        req.Model,
+	}, nil
+}
+
+// CompletionStream implements provider.Provider, replaying the fixture
+// answer as a stream.
+func (p *Provider) CompletionStream(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedChatStream, error) {
+	req.Stream = false
+	resp, err := p.Completion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return provider.ReplayCompletion(resp), nil
+}
+
+// Hash identifies a prompt: the first 16 hex digits of the SHA-256 of the
+// model and each message's role and content, NUL-separated.
+func Hash(model string, messages []models.Message) string {
+	h := sha256.New()
+	h.Write([]byte(model))
+	for _, m := range messages {
+		h.Write([]byte{0})
+		h.Write([]byte(m.Role))
+		h.Write([]byte{0})
+		h.Write([]byte(m.Content))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// templateData is what default.tmpl templates render with.
+type templateData struct {
+	Model    string
+	Hash     string
+	Prompt   string
+	Messages []models.Message
+}
+
+// respond looks for <model>/<hash>.txt, then <model>/default.tmpl, then
+// default.tmpl at the root of the fixture directory.
+func (p *Provider) respond(model, hash string, messages []models.Message) (string, error) {
+	modelDir := filepath.Join(p.dir, filepath.FromSlash(model))
+	fixture := filepath.Join(modelDir, hash+fixtureExt)
+	data, err := os.ReadFile(fixture)
+	if err == nil {
+		return string(data), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	for _, file := range []string{filepath.Join(modelDir, defaultTemplate), filepath.Join(p.dir, defaultTemplate)} {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		tmpl, err := template.New(filepath.Base(file)).Parse(string(data))
+		if err != nil {
+			return "", fmt.Errorf("fixture template %s: %w", file, err)
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, templateData{Model: model, Hash: hash, Prompt: lastUserMessage(messages), Messages: messages}); err != nil {
+			return "", fmt.Errorf("fixture template %s: %w", file, err)
+		}
+		return out.String(), nil
+	}
+
+	rel, _ := filepath.Rel(p.dir, fixture)
+	return "", fmt.Errorf("%w: model %s, prompt hash %s; add %s", ErrNoFixture, model, hash, filepath.ToSlash(rel))
+}
+
+func lastUserMessage(messages []models.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if strings.EqualFold(messages[i].Role, "user") {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+func usageFor(promptTokens int, text string) models.Usage {
+	completionTokens := len(tokens.Approximate.Encode(text))
+	return models.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
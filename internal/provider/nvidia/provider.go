@@ -24,6 +24,8 @@ type Provider struct {
 	name        string
 	models      []models.Model
 	modelStyles map[string]string
+	// patterns give the API style of models no ID is configured for.
+	patterns []config.ModelConfig
 
 	openaiAdapter *openaiProvider.Provider
 	claudeAdapter *claudeProvider.Provider
@@ -45,18 +47,39 @@ func New(name string, cfg config.ProviderConfig, client *http.Client) (*Provider
 		claudeModels []config.ModelConfig
 		allModels    []models.Model
 		modelStyles  = make(map[string]string)
+		patterns     []config.ModelConfig
 	)
 
 	for _, model := range cfg.Models {
 		style := strings.TrimSpace(strings.ToLower(model.APIStyle))
 
 		// Track for routing.
-		allModels = append(allModels, models.Model{
-			ID:       model.ID,
-			Provider: name,
-			APIStyle: style,
-		})
-		modelStyles[model.ID] = style
+		if model.IsPattern() {
+			patterns = append(patterns, model)
+		} else {
+			modelStyles[model.ID] = style
+			allModels = append(allModels, models.Model{
+				ID:                model.ID,
+				Provider:          name,
+				APIStyle:          style,
+				MaxOutputTokens:   model.MaxOutputTokens,
+				ContextWindow:     model.ContextWindow,
+				Timeout:           model.Timeout,
+				StreamIdleTimeout: model.StreamIdleTimeout,
+				DisplayName:       model.DisplayName,
+				Weight:            model.Weight,
+				Passthrough:       model.Passthrough,
+				SystemPrompt:      model.SystemPrompt,
+				PrependSystem:     model.Prepend,
+				DefaultOptions:    model.DefaultOptions(),
+				OverrideOptions:   model.OverrideOptions(),
+				Concurrency: models.ConcurrencyLimit{
+					Max:          model.Concurrency.Max,
+					Queue:        model.Concurrency.Queue,
+					QueueTimeout: model.Concurrency.QueueTimeout,
+				},
+			})
+		}
 
 		switch style {
 		case apiStyleOpenAI:
@@ -64,7 +87,7 @@ func New(name string, cfg config.ProviderConfig, client *http.Client) (*Provider
 		case apiStyleClaude:
 			claudeModels = append(claudeModels, model)
 		default:
-			return nil, fmt.Errorf("model %s: unsupported api_style %q", model.ID, model.APIStyle)
+			return nil, fmt.Errorf("model %s: unsupported api_style %q", model.Name(), model.APIStyle)
 		}
 	}
 
@@ -72,6 +95,7 @@ func New(name string, cfg config.ProviderConfig, client *http.Client) (*Provider
 		name:        name,
 		models:      allModels,
 		modelStyles: modelStyles,
+		patterns:    patterns,
 	}
 
 	if len(openaiModels) > 0 {
@@ -101,10 +125,34 @@ func New(name string, cfg config.ProviderConfig, client *http.Client) (*Provider
 	return p, nil
 }
 
+// style returns the API style of a model, falling back to the patterns
+// matching it.
+func (p *Provider) style(model string) (string, bool) {
+	if style, ok := p.modelStyles[model]; ok {
+		return style, true
+	}
+	pattern, ok := config.MatchModelPattern(p.patterns, model)
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSpace(strings.ToLower(pattern.APIStyle)), true
+}
+
 func (p *Provider) Name() string {
 	return p.name
 }
 
+// CloseIdleConnections implements provider.IdleCloser through the
+// configured adapters.
+func (p *Provider) CloseIdleConnections() {
+	if p.openaiAdapter != nil {
+		p.openaiAdapter.CloseIdleConnections()
+	}
+	if p.claudeAdapter != nil {
+		p.claudeAdapter.CloseIdleConnections()
+	}
+}
+
 func (p *Provider) ListModels(ctx context.Context) ([]models.Model, error) {
 	result := make([]models.Model, len(p.models))
 	copy(result, p.models)
@@ -112,7 +160,7 @@ func (p *Provider) ListModels(ctx context.Context) ([]models.Model, error) {
 }
 
 func (p *Provider) Chat(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, error) {
-	style, ok := p.modelStyles[req.Model]
+	style, ok := p.style(req.Model)
 	if !ok {
 		return nil, fmt.Errorf("%w: %s", provider.ErrUnknownModel, req.Model)
 	}
@@ -133,8 +181,32 @@ func (p *Provider) Chat(ctx context.Context, req models.UnifiedChatRequest) (*mo
 	}
 }
 
+// ChatStream implements provider.Provider through the adapter for the
+// model's API style.
+func (p *Provider) ChatStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, error) {
+	style, ok := p.style(req.Model)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", provider.ErrUnknownModel, req.Model)
+	}
+
+	switch style {
+	case apiStyleOpenAI:
+		if p.openaiAdapter == nil {
+			return nil, fmt.Errorf("model %s configured as openai style but adapter missing", req.Model)
+		}
+		return p.openaiAdapter.ChatStream(ctx, req)
+	case apiStyleClaude:
+		if p.claudeAdapter == nil {
+			return nil, fmt.Errorf("model %s configured as claude style but adapter missing", req.Model)
+		}
+		return p.claudeAdapter.ChatStream(ctx, req)
+	default:
+		return nil, fmt.Errorf("model %s has unsupported api style %q", req.Model, style)
+	}
+}
+
 func (p *Provider) Completion(ctx context.Context, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, error) {
-	style, ok := p.modelStyles[req.Model]
+	style, ok := p.style(req.Model)
 	if !ok {
 		return nil, fmt.Errorf("%w: %s", provider.ErrUnknownModel, req.Model)
 	}
@@ -151,3 +223,72 @@ func (p *Provider) Completion(ctx context.Context, req models.UnifiedCompletionR
 		return nil, fmt.Errorf("model %s has unsupported api style %q", req.Model, style)
 	}
 }
+
+// CompletionStream implements provider.Provider through the OpenAI-style
+// adapter, as Completion does.
+func (p *Provider) CompletionStream(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedChatStream, error) {
+	style, ok := p.style(req.Model)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", provider.ErrUnknownModel, req.Model)
+	}
+
+	switch style {
+	case apiStyleOpenAI:
+		if p.openaiAdapter == nil {
+			return nil, fmt.Errorf("model %s configured as openai style but adapter missing", req.Model)
+		}
+		return p.openaiAdapter.CompletionStream(ctx, req)
+	case apiStyleClaude:
+		return nil, fmt.Errorf("model %s uses claude api style which does not support completions: %w", req.Model, provider.ErrUnsupportedOperation)
+	default:
+		return nil, fmt.Errorf("model %s has unsupported api style %q", req.Model, style)
+	}
+}
+
+// Passthrough implements provider.Passthrougher through the adapter for the
+// model's API style.
+func (p *Provider) Passthrough(ctx context.Context, req provider.PassthroughRequest) (*http.Response, error) {
+	style, ok := p.style(req.Model)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", provider.ErrUnknownModel, req.Model)
+	}
+
+	switch style {
+	case apiStyleOpenAI:
+		if p.openaiAdapter == nil {
+			return nil, fmt.Errorf("model %s configured as openai style but adapter missing", req.Model)
+		}
+		return p.openaiAdapter.Passthrough(ctx, req)
+	case apiStyleClaude:
+		if p.claudeAdapter == nil {
+			return nil, fmt.Errorf("model %s configured as claude style but adapter missing", req.Model)
+		}
+		return p.claudeAdapter.Passthrough(ctx, req)
+	default:
+		return nil, fmt.Errorf("model %s has unsupported api style %q", req.Model, style)
+	}
+}
+
+// CountTokens implements provider.TokenCounter for Claude-style models.
+func (p *Provider) CountTokens(ctx context.Context, model string, body []byte, header http.Header) (int, error) {
+	style, ok := p.style(model)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", provider.ErrUnknownModel, model)
+	}
+	if style != apiStyleClaude || p.claudeAdapter == nil {
+		return 0, fmt.Errorf("token counting is not supported for model %s: %w", model, provider.ErrUnsupportedOperation)
+	}
+	return p.claudeAdapter.CountTokens(ctx, model, body, header)
+}
+
+// Probe implements provider.Prober through whichever adapter is configured;
+// both reach the same upstream.
+func (p *Provider) Probe(ctx context.Context) error {
+	if p.openaiAdapter != nil {
+		return p.openaiAdapter.Probe(ctx)
+	}
+	if p.claudeAdapter != nil {
+		return p.claudeAdapter.Probe(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package openai
+
+import (
+	"net/http"
+	"net/url"
+
+	"gocode-router/internal/config"
+)
+
+// defaultAzureAPIVersion is the api-version sent to Azure OpenAI when none
+// is configured.
+const defaultAzureAPIVersion = "2024-10-21"
+
+// azureEndpoint addresses Azure OpenAI, which serves each model from a
+// deployment at /openai/deployments/<deployment> and takes the API version
+// as a query parameter.
+type azureEndpoint struct {
+	apiVersion  string
+	deployments map[string]string
+	// patterns map the models they match to their deployment, if set.
+	patterns []config.ModelConfig
+}
+
+// NewAzure creates a provider for Azure OpenAI. Static keys are sent in the
+// api-key header; Entra ID tokens as bearer tokens.
+func NewAzure(name string, cfg config.ProviderConfig, client *http.Client) (*Provider, error) {
+	p, err := New(name, cfg, client)
+	if err != nil {
+		return nil, err
+	}
+	azure := &azureEndpoint{apiVersion: cfg.APIVersion, deployments: make(map[string]string, len(cfg.Models))}
+	if azure.apiVersion == "" {
+		azure.apiVersion = defaultAzureAPIVersion
+	}
+	for _, model := range cfg.Models {
+		switch {
+		case model.IsPattern():
+			azure.patterns = append(azure.patterns, model)
+		case model.Deployment != "":
+			azure.deployments[model.ID] = model.Deployment
+		}
+	}
+	p.azure = azure
+	return p, nil
+}
+
+// deploymentURL returns the URL of an operation on the deployment serving
+// model.
+func (a *azureEndpoint) deploymentURL(baseURL, model, operation string) string {
+	deployment, ok := a.deployments[model]
+	if !ok {
+		deployment = model
+		if pattern, ok := config.MatchModelPattern(a.patterns, model); ok && pattern.Deployment != "" {
+			deployment = pattern.Deployment
+		}
+	}
+	return baseURL + "/openai/deployments/" + url.PathEscape(deployment) + operation + a.query()
+}
+
+// modelsURL returns the URL listing the models of the resource.
+func (a *azureEndpoint) modelsURL(baseURL string) string {
+	return baseURL + "/openai/models" + a.query()
+}
+
+func (a *azureEndpoint) query() string {
+	return "?" + url.Values{"api-version": {a.apiVersion}}.Encode()
+}
@@ -0,0 +1,61 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+type modelList struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+// DiscoverModels lists the models served by the upstream's /models endpoint.
+func (p *Provider) DiscoverModels(ctx context.Context) ([]models.Model, error) {
+	httpReq, err := p.newRequest(ctx, http.MethodGet, p.baseURL+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("openai models request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode >= 400 {
+		return nil, parseAPIError(httpResp)
+	}
+
+	var list modelList
+	if err := decodeJSON(httpResp.Body, &list); err != nil {
+		return nil, err
+	}
+
+	out := make([]models.Model, 0, len(list.Data))
+	for _, model := range list.Data {
+		if model.ID == "" {
+			continue
+		}
+		out = append(out, models.Model{ID: model.ID, Provider: p.name, APIStyle: "openai"})
+	}
+	return out, nil
+}
+
+// Probe implements provider.Prober with a request for the model list.
+func (p *Provider) Probe(ctx context.Context) error {
+	url := p.baseURL + "/models"
+	if p.azure != nil {
+		url = p.azure.modelsURL(p.baseURL)
+	}
+	httpReq, err := p.newRequest(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return provider.ProbeRequest(p.client, httpReq)
+}
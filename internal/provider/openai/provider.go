@@ -7,12 +7,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"slices"
 	"strings"
 
 	"gocode-router/internal/config"
 	"gocode-router/internal/models"
 	"gocode-router/internal/provider"
+	"gocode-router/internal/provider/credentials"
 )
 
 const (
@@ -22,14 +25,17 @@ const (
 
 // Provider implements the Provider interface for OpenAI-compatible APIs.
 type Provider struct {
-	name      string
-	apiKey    string
-	baseURL   string
-	headers   map[string]string
-	client    *http.Client
-	models    []models.Model
-	chatURL   string
-	legacyURL string
+	name         string
+	apiKey       string
+	baseURL      string
+	headers      map[string]string
+	organization string
+	project      string
+	tokens       credentials.TokenSource
+	client       *http.Client
+	models       []models.Model
+	// azure is set for Azure OpenAI, which is addressed by deployment.
+	azure *azureEndpoint
 }
 
 // New creates a new OpenAI provider.
@@ -46,24 +52,51 @@ func New(name string, cfg config.ProviderConfig, client *http.Client) (*Provider
 	modelsList := make([]models.Model, 0, len(cfg.Models))
 	for _, model := range cfg.Models {
 		if model.APIStyle != "openai" {
-			return nil, fmt.Errorf("openai provider %q received model %q with unsupported api_style %q", name, model.ID, model.APIStyle)
+			return nil, fmt.Errorf("openai provider %q received model %q with unsupported api_style %q", name, model.Name(), model.APIStyle)
+		}
+		if model.IsPattern() {
+			// Served through the registry's patterns; the upstream takes
+			// any model ID.
+			continue
 		}
 		modelsList = append(modelsList, models.Model{
-			ID:       model.ID,
-			Provider: name,
-			APIStyle: model.APIStyle,
+			ID:                model.ID,
+			Provider:          name,
+			APIStyle:          model.APIStyle,
+			MaxOutputTokens:   model.MaxOutputTokens,
+			ContextWindow:     model.ContextWindow,
+			Timeout:           model.Timeout,
+			StreamIdleTimeout: model.StreamIdleTimeout,
+			DisplayName:       model.DisplayName,
+			Weight:            model.Weight,
+			Passthrough:       model.Passthrough,
+			SystemPrompt:      model.SystemPrompt,
+			PrependSystem:     model.Prepend,
+			DefaultOptions:    model.DefaultOptions(),
+			OverrideOptions:   model.OverrideOptions(),
+			Concurrency: models.ConcurrencyLimit{
+				Max:          model.Concurrency.Max,
+				Queue:        model.Concurrency.Queue,
+				QueueTimeout: model.Concurrency.QueueTimeout,
+			},
 		})
 	}
 
+	tokens, err := credentials.New(cfg.Auth, client)
+	if err != nil {
+		return nil, fmt.Errorf("openai provider %q: %w", name, err)
+	}
+
 	return &Provider{
-		name:      name,
-		apiKey:    cfg.APIKey,
-		baseURL:   baseURL,
-		headers:   cfg.Headers,
-		client:    client,
-		models:    modelsList,
-		chatURL:   baseURL + "/chat/completions",
-		legacyURL: baseURL + "/completions",
+		name:         name,
+		apiKey:       cfg.APIKey,
+		baseURL:      baseURL,
+		headers:      cfg.Headers,
+		organization: cfg.Organization,
+		project:      cfg.Project,
+		tokens:       tokens,
+		client:       client,
+		models:       modelsList,
 	}, nil
 }
 
@@ -71,6 +104,11 @@ func (p *Provider) Name() string {
 	return p.name
 }
 
+// CloseIdleConnections implements provider.IdleCloser.
+func (p *Provider) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
 func (p *Provider) ListModels(ctx context.Context) ([]models.Model, error) {
 	result := make([]models.Model, len(p.models))
 	copy(result, p.models)
@@ -82,12 +120,7 @@ func (p *Provider) Chat(ctx context.Context, req models.UnifiedChatRequest) (*mo
 		return nil, fmt.Errorf("streaming is not yet supported for provider %s: %w", p.name, provider.ErrUnsupportedOperation)
 	}
 
-	payload, err := buildChatPayload(req)
-	if err != nil {
-		return nil, err
-	}
-
-	httpReq, err := p.newRequest(ctx, http.MethodPost, p.chatURL, payload)
+	httpReq, err := p.newChatRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -110,6 +143,26 @@ func (p *Provider) Chat(ctx context.Context, req models.UnifiedChatRequest) (*mo
 	return providerResp.toUnified()
 }
 
+// newChatRequest builds the upstream request for a chat completion.
+func (p *Provider) newChatRequest(ctx context.Context, req models.UnifiedChatRequest) (*http.Request, error) {
+	payload, err := buildChatPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	payload.Metadata = withTags(payload.Metadata, provider.UpstreamTagsFrom(ctx))
+
+	// Large prompts are encoded as they are sent rather than up front.
+	var body any = payload
+	if provider.PromptBytes(req.Messages) >= provider.StreamThreshold {
+		messages := payload.Messages
+		payload.Messages = []openAIMessage{}
+		if body, err = provider.StreamJSON(payload, "messages", messages); err != nil {
+			return nil, err
+		}
+	}
+	return p.newRequest(ctx, http.MethodPost, p.endpointURL(req.Model, "/chat/completions"), body)
+}
+
 func (p *Provider) Completion(ctx context.Context, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, error) {
 	if req.Stream {
 		return nil, fmt.Errorf("streaming is not yet supported for provider %s: %w", p.name, provider.ErrUnsupportedOperation)
@@ -120,7 +173,7 @@ func (p *Provider) Completion(ctx context.Context, req models.UnifiedCompletionR
 		return nil, err
 	}
 
-	httpReq, err := p.newRequest(ctx, http.MethodPost, p.legacyURL, payload)
+	httpReq, err := p.newRequest(ctx, http.MethodPost, p.endpointURL(req.Model, "/completions"), payload)
 	if err != nil {
 		return nil, err
 	}
@@ -143,64 +196,211 @@ func (p *Provider) Completion(ctx context.Context, req models.UnifiedCompletionR
 	return providerResp.toUnified()
 }
 
-func (p *Provider) newRequest(ctx context.Context, method, url string, payload any) (*http.Request, error) {
-	body, err := json.Marshal(payload)
+// Passthrough implements provider.Passthrougher.
+func (p *Provider) Passthrough(ctx context.Context, req provider.PassthroughRequest) (*http.Response, error) {
+	httpReq, err := p.newRequest(ctx, http.MethodPost, p.endpointURL(req.Model, req.Operation), bytes.NewReader(req.Body))
 	if err != nil {
-		return nil, fmt.Errorf("marshal payload: %w", err)
+		return nil, err
+	}
+	return provider.SendPassthrough(p.client, httpReq, req)
+}
+
+// endpointURL returns the URL of an operation, such as /chat/completions,
+// on model.
+func (p *Provider) endpointURL(model, operation string) string {
+	if p.azure != nil {
+		return p.azure.deploymentURL(p.baseURL, model, operation)
+	}
+	return p.baseURL + operation
+}
+
+func (p *Provider) newRequest(ctx context.Context, method, url string, payload any) (_ *http.Request, err error) {
+	// A payload that is already a body, such as a streamed one, is sent as
+	// is.
+	var body io.Reader
+	switch payload := payload.(type) {
+	case nil:
+	case io.Reader:
+		body = payload
+		// The transport only closes the body of requests it sends.
+		if closer, ok := payload.(io.Closer); ok {
+			defer func() {
+				if err != nil {
+					closer.Close()
+				}
+			}()
+		}
+	default:
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("marshal payload: %w", err)
+		}
+		body = bytes.NewReader(data)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, fmt.Errorf("construct request: %w", err)
 	}
 
-	req.Header.Set("Content-Type", contentTypeJSON)
+	if body != nil {
+		req.Header.Set("Content-Type", contentTypeJSON)
+	}
 	req.Header.Set("Accept", contentTypeJSON)
 	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	credential := p.apiKey
+	if p.tokens != nil {
+		if credential, err = p.tokens.Token(ctx); err != nil {
+			return nil, fmt.Errorf("acquire upstream token: %w", err)
+		}
+	}
+	if p.azure != nil && p.tokens == nil {
+		req.Header.Set("api-key", credential)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+credential)
+	}
 
 	for k, v := range p.headers {
 		req.Header.Set(k, v)
 	}
 
+	// Per-key attribution carried by the context wins over the provider's.
+	organization, project := p.organization, p.project
+	if override, ok := provider.AttributionFor(ctx, p.name); ok {
+		if override.Organization != "" {
+			organization = override.Organization
+		}
+		if override.Project != "" {
+			project = override.Project
+		}
+	}
+	if organization != "" {
+		req.Header.Set("OpenAI-Organization", organization)
+	}
+	if project != "" {
+		req.Header.Set("OpenAI-Project", project)
+	}
+
 	return req, nil
 }
 
 type chatPayload struct {
-	Model            string             `json:"model"`
-	Messages         []openAIMessage    `json:"messages"`
-	Stream           bool               `json:"stream,omitempty"`
-	MaxTokens        *int               `json:"max_tokens,omitempty"`
-	Temperature      *float64           `json:"temperature,omitempty"`
-	TopP             *float64           `json:"top_p,omitempty"`
-	FrequencyPenalty *float64           `json:"frequency_penalty,omitempty"`
-	PresencePenalty  *float64           `json:"presence_penalty,omitempty"`
-	Stop             []string           `json:"stop,omitempty"`
-	ResponseFormat   map[string]any     `json:"response_format,omitempty"`
-	Tools            json.RawMessage    `json:"tools,omitempty"`
-	ToolChoice       json.RawMessage    `json:"tool_choice,omitempty"`
-	LogitBias        map[string]float64 `json:"logit_bias,omitempty"`
-	Metadata         map[string]any     `json:"metadata,omitempty"`
-	User             string             `json:"user,omitempty"`
+	Model             string             `json:"model"`
+	Messages          []openAIMessage    `json:"messages"`
+	Stream            bool               `json:"stream,omitempty"`
+	StreamOptions     *streamOptions     `json:"stream_options,omitempty"`
+	MaxTokens         *int               `json:"max_tokens,omitempty"`
+	N                 *int               `json:"n,omitempty"`
+	Temperature       *float64           `json:"temperature,omitempty"`
+	TopP              *float64           `json:"top_p,omitempty"`
+	FrequencyPenalty  *float64           `json:"frequency_penalty,omitempty"`
+	PresencePenalty   *float64           `json:"presence_penalty,omitempty"`
+	Stop              []string           `json:"stop,omitempty"`
+	ResponseFormat    map[string]any     `json:"response_format,omitempty"`
+	Tools             []openAITool       `json:"tools,omitempty"`
+	ToolChoice        json.RawMessage    `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool              `json:"parallel_tool_calls,omitempty"`
+	LogitBias         map[string]float64 `json:"logit_bias,omitempty"`
+	Metadata          map[string]any     `json:"metadata,omitempty"`
+	Store             bool               `json:"store,omitempty"`
+	User              string             `json:"user,omitempty"`
 }
 
+// openAIMessage is a chat message. An assistant message that only calls
+// tools has no content, and a message with images sends its parts as the
+// content instead.
 type openAIMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-	Name    string `json:"name,omitempty"`
+	Role       string              `json:"role"`
+	Content    string              `json:"content,omitempty"`
+	Name       string              `json:"name,omitempty"`
+	ToolCalls  []openAIToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID string              `json:"tool_call_id,omitempty"`
+	Parts      []openAIContentPart `json:"-"`
+}
+
+// MarshalJSON sends the parts of a message with images as its content.
+func (m openAIMessage) MarshalJSON() ([]byte, error) {
+	type plain openAIMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []openAIContentPart `json:"content"`
+	}{plain(m), m.Parts})
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openAIImageURL `json:"image_url,omitempty"`
+}
+
+type openAIImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// contentParts renders the parts of a message in the vision format. Inline
+// images go as data URIs.
+func contentParts(parts []models.ContentPart) []openAIContentPart {
+	out := make([]openAIContentPart, 0, len(parts))
+	for _, part := range parts {
+		if part.Image == nil {
+			out = append(out, openAIContentPart{Type: "text", Text: part.Text})
+			continue
+		}
+		url := part.Image.URL
+		if url == "" {
+			url = "data:" + part.Image.MediaType + ";base64," + part.Image.Data
+		}
+		out = append(out, openAIContentPart{Type: "image_url", ImageURL: &openAIImageURL{URL: url, Detail: part.Image.Detail}})
+	}
+	return out
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string             `json:"type"`
+	Function openAIToolFunction `json:"function"`
+}
+
+type openAIToolFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
 }
 
 func buildChatPayload(req models.UnifiedChatRequest) (chatPayload, error) {
 	messages := make([]openAIMessage, 0, len(req.Messages))
 	for _, msg := range req.Messages {
-		if strings.TrimSpace(msg.Content) == "" {
+		if strings.TrimSpace(msg.Content) == "" && len(msg.ToolCalls) == 0 && !msg.HasImages() {
 			return chatPayload{}, errors.New("message content must not be empty")
 		}
-		messages = append(messages, openAIMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-			Name:    msg.Name,
-		})
+		out := openAIMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			Name:       msg.Name,
+			ToolCallID: msg.ToolCallID,
+		}
+		if msg.HasImages() {
+			out.Parts = contentParts(msg.Parts)
+		}
+		for _, call := range msg.ToolCalls {
+			toolCall := openAIToolCall{ID: call.ID, Type: "function"}
+			toolCall.Function.Name = call.Name
+			toolCall.Function.Arguments = call.Arguments
+			out.ToolCalls = append(out.ToolCalls, toolCall)
+		}
+		messages = append(messages, out)
 	}
 
 	payload := chatPayload{
@@ -208,10 +408,17 @@ func buildChatPayload(req models.UnifiedChatRequest) (chatPayload, error) {
 		Messages: messages,
 		Stream:   req.Stream,
 	}
+	if req.Stream {
+		// Usage is only reported on streams that ask for it.
+		payload.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
 
 	if v, ok := extractInt(req.Options, "max_tokens"); ok {
 		payload.MaxTokens = &v
 	}
+	if v, ok := extractInt(req.Options, "n"); ok && v > 1 {
+		payload.N = &v
+	}
 	if v, ok := extractFloat(req.Options, "temperature"); ok {
 		payload.Temperature = &v
 	}
@@ -230,18 +437,27 @@ func buildChatPayload(req models.UnifiedChatRequest) (chatPayload, error) {
 	if responseFormat, ok := extractMap(req.Options, "response_format"); ok {
 		payload.ResponseFormat = responseFormat
 	}
-	if tools, ok := extractRaw(req.Options, "tools"); ok {
-		payload.Tools = tools
+	for _, tool := range req.Tools {
+		payload.Tools = append(payload.Tools, openAITool{
+			Type:     "function",
+			Function: openAIToolFunction{Name: tool.Name, Description: tool.Description, Parameters: tool.Parameters},
+		})
 	}
 	if toolChoice, ok := extractRaw(req.Options, "tool_choice"); ok {
 		payload.ToolChoice = toolChoice
 	}
+	if parallel, ok := extractBool(req.Options, "parallel_tool_calls"); ok {
+		payload.ParallelToolCalls = &parallel
+	}
 	if logitBias, ok := extractLogitBias(req.Options); ok {
 		payload.LogitBias = logitBias
 	}
 	if metadata, ok := extractMap(req.Options, "metadata"); ok {
 		payload.Metadata = metadata
 	}
+	if store, ok := extractBool(req.Options, "store"); ok {
+		payload.Store = store
+	}
 	if user, ok := extractString(req.Options, "user"); ok {
 		payload.User = user
 	}
@@ -251,6 +467,7 @@ func buildChatPayload(req models.UnifiedChatRequest) (chatPayload, error) {
 
 type chatResponse struct {
 	ID      string          `json:"id"`
+	Model   string          `json:"model"`
 	Choices []chatChoice    `json:"choices"`
 	Usage   *usageBlock     `json:"usage,omitempty"`
 	Error   *apiErrorObject `json:"error,omitempty"`
@@ -263,9 +480,20 @@ type chatChoice struct {
 }
 
 type usageBlock struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// cachedTokens returns the prompt tokens served from the prompt cache.
+func (u *usageBlock) cachedTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
 }
 
 func (r chatResponse) toUnified() (*models.UnifiedChatResponse, error) {
@@ -273,26 +501,44 @@ func (r chatResponse) toUnified() (*models.UnifiedChatResponse, error) {
 		return nil, errors.New("openai response did not include choices")
 	}
 
-	choice := r.Choices[0]
-	return &models.UnifiedChatResponse{
-		ID: r.ID,
-		Message: models.Message{
-			Role:    choice.Message.Role,
-			Content: choice.Message.Content,
-			Name:    choice.Message.Name,
-		},
-		FinishReason: choice.FinishReason,
+	// Choices after the first become extra choices, in index order.
+	choices := slices.Clone(r.Choices)
+	slices.SortStableFunc(choices, func(a, b chatChoice) int { return a.Index - b.Index })
+	resp := &models.UnifiedChatResponse{
+		ID:           r.ID,
+		Model:        r.Model,
+		Message:      choices[0].Message.toUnified(),
+		FinishReason: choices[0].FinishReason,
 		Usage: models.Usage{
 			PromptTokens:     valueOrZero(r.Usage, func(u *usageBlock) int { return u.PromptTokens }),
 			CompletionTokens: valueOrZero(r.Usage, func(u *usageBlock) int { return u.CompletionTokens }),
 			TotalTokens:      valueOrZero(r.Usage, func(u *usageBlock) int { return u.TotalTokens }),
+			CacheReadTokens:  valueOrZero(r.Usage, (*usageBlock).cachedTokens),
 		},
-	}, nil
+	}
+	for _, extra := range choices[1:] {
+		resp.ExtraChoices = append(resp.ExtraChoices, models.Choice{Message: extra.Message.toUnified(), FinishReason: extra.FinishReason})
+	}
+	return resp, nil
+}
+
+func (m openAIMessage) toUnified() models.Message {
+	var toolCalls []models.ToolCall
+	for _, call := range m.ToolCalls {
+		toolCalls = append(toolCalls, models.ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+	return models.Message{
+		Role:      m.Role,
+		Content:   m.Content,
+		Name:      m.Name,
+		ToolCalls: toolCalls,
+	}
 }
 
 type completionPayload struct {
 	Model       string             `json:"model"`
 	Prompt      string             `json:"prompt"`
+	Suffix      string             `json:"suffix,omitempty"`
 	Stream      bool               `json:"stream,omitempty"`
 	MaxTokens   *int               `json:"max_tokens,omitempty"`
 	Temperature *float64           `json:"temperature,omitempty"`
@@ -300,6 +546,8 @@ type completionPayload struct {
 	Stop        []string           `json:"stop,omitempty"`
 	LogitBias   map[string]float64 `json:"logit_bias,omitempty"`
 	User        string             `json:"user,omitempty"`
+
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
 }
 
 func buildCompletionPayload(req models.UnifiedCompletionRequest) (completionPayload, error) {
@@ -311,6 +559,9 @@ func buildCompletionPayload(req models.UnifiedCompletionRequest) (completionPayl
 		Prompt: req.Prompt,
 		Stream: req.Stream,
 	}
+	if req.Stream {
+		payload.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
 
 	if req.MaxTokens > 0 {
 		v := req.MaxTokens
@@ -332,12 +583,16 @@ func buildCompletionPayload(req models.UnifiedCompletionRequest) (completionPayl
 	if user, ok := extractString(req.Options, "user"); ok {
 		payload.User = user
 	}
+	if suffix, ok := extractString(req.Options, "suffix"); ok {
+		payload.Suffix = suffix
+	}
 
 	return payload, nil
 }
 
 type completionResponse struct {
 	ID      string             `json:"id"`
+	Model   string             `json:"model"`
 	Choices []completionChoice `json:"choices"`
 	Usage   *usageBlock        `json:"usage,omitempty"`
 	Error   *apiErrorObject    `json:"error,omitempty"`
@@ -357,12 +612,14 @@ func (r completionResponse) toUnified() (*models.UnifiedCompletionResponse, erro
 	choice := r.Choices[0]
 	return &models.UnifiedCompletionResponse{
 		ID:           r.ID,
+		Model:        r.Model,
 		Text:         choice.Text,
 		FinishReason: choice.FinishReason,
 		Usage: models.Usage{
 			PromptTokens:     valueOrZero(r.Usage, func(u *usageBlock) int { return u.PromptTokens }),
 			CompletionTokens: valueOrZero(r.Usage, func(u *usageBlock) int { return u.CompletionTokens }),
 			TotalTokens:      valueOrZero(r.Usage, func(u *usageBlock) int { return u.TotalTokens }),
+			CacheReadTokens:  valueOrZero(r.Usage, (*usageBlock).cachedTokens),
 		},
 	}, nil
 }
@@ -385,10 +642,10 @@ func parseAPIError(resp *http.Response) error {
 
 	var apiErr apiErrorResponse
 	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error.Message != "" {
-		return fmt.Errorf("openai error (%s): %s", apiErr.Error.Type, apiErr.Error.Message)
+		return &provider.StatusError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("openai error (%s): %s", apiErr.Error.Type, apiErr.Error.Message)}
 	}
 
-	return fmt.Errorf("upstream error status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	return &provider.StatusError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("upstream error status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))}
 }
 
 func decodeJSON(reader io.Reader, target any) error {
@@ -452,6 +709,18 @@ func extractString(options map[string]any, key string) (string, bool) {
 	return "", false
 }
 
+func extractBool(options map[string]any, key string) (bool, bool) {
+	if options == nil {
+		return false, false
+	}
+	if value, ok := options[key]; ok {
+		if b, ok := value.(bool); ok {
+			return b, true
+		}
+	}
+	return false, false
+}
+
 func extractStringSlice(options map[string]any, key string) ([]string, bool) {
 	if options == nil {
 		return nil, false
@@ -477,6 +746,29 @@ func extractStringSlice(options map[string]any, key string) ([]string, bool) {
 	return nil, false
 }
 
+// maxMetadataEntries is the most metadata entries OpenAI accepts.
+const maxMetadataEntries = 16
+
+// withTags adds request tags to the upstream metadata. Metadata sent by the
+// client takes precedence, and tags that would exceed the entry limit are
+// left out.
+func withTags(metadata map[string]any, tags map[string]string) map[string]any {
+	if len(tags) == 0 {
+		return metadata
+	}
+	out := make(map[string]any, len(metadata)+len(tags))
+	for k, v := range metadata {
+		out[k] = v
+	}
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		if _, ok := out[k]; ok || len(out) >= maxMetadataEntries {
+			continue
+		}
+		out[k] = tags[k]
+	}
+	return out
+}
+
 func extractMap(options map[string]any, key string) (map[string]any, bool) {
 	if options == nil {
 		return nil, false
@@ -0,0 +1,226 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+const contentTypeEventStream = "text/event-stream"
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ChatStream implements provider.Provider, streaming the chat completion
+// chunks of the upstream as unified chunks.
+func (p *Provider) ChatStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, error) {
+	req.Stream = true
+	httpReq, err := p.newChatRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", contentTypeEventStream)
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("openai chat stream request failed: %w", err)
+	}
+	if httpResp.StatusCode >= 400 {
+		defer httpResp.Body.Close()
+		return nil, parseAPIError(httpResp)
+	}
+	return &chatStream{body: httpResp.Body, events: provider.NewEventReader(httpResp.Body), decode: decodeChatChunk}, nil
+}
+
+// CompletionStream implements provider.Provider, streaming the text of a
+// legacy completion as unified chunks carrying Content.
+func (p *Provider) CompletionStream(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedChatStream, error) {
+	req.Stream = true
+	payload, err := buildCompletionPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := p.newRequest(ctx, http.MethodPost, p.endpointURL(req.Model, "/completions"), payload)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", contentTypeEventStream)
+
+	httpResp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("openai completion stream request failed: %w", err)
+	}
+	if httpResp.StatusCode >= 400 {
+		defer httpResp.Body.Close()
+		return nil, parseAPIError(httpResp)
+	}
+	return &chatStream{body: httpResp.Body, events: provider.NewEventReader(httpResp.Body), decode: decodeCompletionChunk}, nil
+}
+
+type chatChunk struct {
+	ID      string          `json:"id"`
+	Model   string          `json:"model"`
+	Choices []chunkChoice   `json:"choices"`
+	Usage   *usageBlock     `json:"usage,omitempty"`
+	Error   *apiErrorObject `json:"error,omitempty"`
+}
+
+type chunkChoice struct {
+	Index int `json:"index"`
+	Delta struct {
+		Role      string          `json:"role"`
+		Content   string          `json:"content"`
+		ToolCalls []toolCallChunk `json:"tool_calls"`
+	} `json:"delta"`
+	FinishReason string `json:"finish_reason"`
+}
+
+type toolCallChunk struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// completionChunk is an event of a legacy completion stream.
+type completionChunk struct {
+	ID      string             `json:"id"`
+	Model   string             `json:"model"`
+	Choices []completionChoice `json:"choices"`
+	Usage   *usageBlock        `json:"usage,omitempty"`
+	Error   *apiErrorObject    `json:"error,omitempty"`
+}
+
+// chatStream turns the chunks of an upstream stream, chat or completion
+// ones as decode parses them, into unified chunks. An upstream chunk may
+// carry several choices, so they are queued until read.
+type chatStream struct {
+	mu      sync.Mutex
+	body    io.ReadCloser
+	events  *provider.EventReader
+	decode  func(data []byte) ([]models.UnifiedChatChunk, error)
+	pending []models.UnifiedChatChunk
+	done    bool
+}
+
+func (s *chatStream) Recv() (models.UnifiedChatChunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.pending) == 0 {
+		if s.done {
+			return models.UnifiedChatChunk{}, io.EOF
+		}
+		if err := s.read(); err != nil {
+			return models.UnifiedChatChunk{}, err
+		}
+	}
+	d := s.pending[0]
+	s.pending = s.pending[1:]
+	return d, nil
+}
+
+// read queues the unified chunks of the next upstream chunk.
+func (s *chatStream) read() error {
+	_, data, err := s.events.Next()
+	if err == io.EOF {
+		s.done = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read openai stream: %w", err)
+	}
+	if string(data) == "[DONE]" {
+		s.done = true
+		return nil
+	}
+	chunks, err := s.decode(data)
+	if err != nil {
+		return err
+	}
+	s.pending = append(s.pending, chunks...)
+	return nil
+}
+
+func decodeChatChunk(data []byte) ([]models.UnifiedChatChunk, error) {
+	var chunk chatChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return nil, fmt.Errorf("decode openai stream chunk: %w", err)
+	}
+	if chunk.Error != nil {
+		return nil, fmt.Errorf("openai error (%s): %s", chunk.Error.Type, chunk.Error.Message)
+	}
+	var out []models.UnifiedChatChunk
+	for _, choice := range chunk.Choices {
+		c := models.UnifiedChatChunk{
+			ID:           chunk.ID,
+			Model:        chunk.Model,
+			Index:        choice.Index,
+			Role:         choice.Delta.Role,
+			Content:      choice.Delta.Content,
+			FinishReason: choice.FinishReason,
+		}
+		for _, call := range choice.Delta.ToolCalls {
+			c.ToolCalls = append(c.ToolCalls, models.ToolCallChunk{
+				Index:     call.Index,
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
+		out = append(out, c)
+	}
+	if chunk.Usage != nil {
+		out = append(out, usageChunk(chunk.ID, chunk.Model, chunk.Usage))
+	}
+	return out, nil
+}
+
+func decodeCompletionChunk(data []byte) ([]models.UnifiedChatChunk, error) {
+	var chunk completionChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return nil, fmt.Errorf("decode openai stream chunk: %w", err)
+	}
+	if chunk.Error != nil {
+		return nil, fmt.Errorf("openai error (%s): %s", chunk.Error.Type, chunk.Error.Message)
+	}
+	var out []models.UnifiedChatChunk
+	for _, choice := range chunk.Choices {
+		out = append(out, models.UnifiedChatChunk{
+			ID:           chunk.ID,
+			Model:        chunk.Model,
+			Index:        choice.Index,
+			Content:      choice.Text,
+			FinishReason: choice.FinishReason,
+		})
+	}
+	if chunk.Usage != nil {
+		out = append(out, usageChunk(chunk.ID, chunk.Model, chunk.Usage))
+	}
+	return out, nil
+}
+
+func usageChunk(id, model string, usage *usageBlock) models.UnifiedChatChunk {
+	return models.UnifiedChatChunk{
+		ID:    id,
+		Model: model,
+		Usage: &models.Usage{
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.TotalTokens,
+			CacheReadTokens:  usage.cachedTokens(),
+		},
+	}
+}
+
+func (s *chatStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Passthrougher is implemented by providers that can forward a client's
+// request body to their upstream as it was sent.
+type Passthrougher interface {
+	// Passthrough sends the request and returns the upstream response,
+	// whatever its status. The caller must close its body.
+	Passthrough(ctx context.Context, req PassthroughRequest) (*http.Response, error)
+}
+
+// PassthroughRequest is a client request forwarded without translation.
+type PassthroughRequest struct {
+	// Model is the upstream model ID; Body must already name it.
+	Model string
+	// Operation is the endpoint of an OpenAI-style upstream, such as
+	// /chat/completions. Claude-style upstreams only take messages.
+	Operation string
+	Body      []byte
+	Stream    bool
+	// MaxTokens is the client's output token cap; zero means none.
+	MaxTokens int
+	// Header holds client headers to forward. The provider's own
+	// credentials and configured headers take precedence.
+	Header http.Header
+}
+
+// ReplaceModel returns body with its top-level model field set to model.
+// The rest of the body, field order and whitespace included, is kept byte
+// for byte.
+func ReplaceModel(body []byte, model string) ([]byte, error) {
+	start, end, err := fieldValue(body, "model")
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return nil, errors.New("request body has no model field")
+	}
+	quoted, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	return splice(body, start, end, quoted), nil
+}
+
+// CapMaxTokens returns body with its max_tokens, or OpenAI's
+// max_completion_tokens, lowered to limit, or set to it when the body asks
+// for none. Like ReplaceModel, it keeps the rest of the body as it was. A
+// non-positive limit leaves the body untouched.
+func CapMaxTokens(body []byte, limit int) ([]byte, error) {
+	if limit <= 0 {
+		return body, nil
+	}
+	capped := []byte(strconv.Itoa(limit))
+	for _, name := range []string{"max_tokens", "max_completion_tokens"} {
+		start, end, err := fieldValue(body, name)
+		if err != nil {
+			return nil, err
+		}
+		if start < 0 {
+			continue
+		}
+		var requested float64
+		if err := json.Unmarshal(body[start:end], &requested); err == nil && requested > 0 && requested <= float64(limit) {
+			return body, nil
+		}
+		return splice(body, start, end, capped), nil
+	}
+	open := bytes.IndexByte(body, '{')
+	if open < 0 {
+		return nil, errors.New("request body must be a JSON object")
+	}
+	field := append([]byte(`"max_tokens":`), capped...)
+	if len(bytes.TrimSpace(body[open+1:])) > 0 && bytes.TrimSpace(body[open+1:])[0] != '}' {
+		field = append(field, ',')
+	}
+	return splice(body, open+1, open+1, field), nil
+}
+
+// fieldValue returns where the value of body's top-level field name
+// starts and ends, or -1 and -1 when body has no such field.
+func fieldValue(body []byte, name string) (int, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return 0, 0, errors.New("request body must be a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, 0, err
+		}
+		keyEnd := dec.InputOffset()
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return 0, 0, err
+		}
+		if tok != name {
+			continue
+		}
+		end := int(dec.InputOffset())
+		start := int(keyEnd) + bytes.IndexByte(body[keyEnd:end], ':') + 1
+		start += len(body[start:end]) - len(bytes.TrimLeft(body[start:end], " \t\r\n"))
+		return start, end, nil
+	}
+	return -1, -1, nil
+}
+
+// splice returns body with body[start:end] replaced by value.
+func splice(body []byte, start, end int, value []byte) []byte {
+	out := make([]byte, 0, len(body)-(end-start)+len(value))
+	out = append(out, body[:start]...)
+	out = append(out, value...)
+	return append(out, body[end:]...)
+}
+
+// SendPassthrough sends a passthrough request a provider has built with
+// its credentials, adding the client headers it does not set itself.
+func SendPassthrough(client *http.Client, httpReq *http.Request, req PassthroughRequest) (*http.Response, error) {
+	for k, v := range req.Header {
+		if httpReq.Header.Get(k) == "" {
+			httpReq.Header[http.CanonicalHeaderKey(k)] = v
+		}
+	}
+	if req.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("passthrough request failed: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,51 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// Prober is implemented by providers that can cheaply check their upstream
+// is reachable.
+type Prober interface {
+	Probe(ctx context.Context) error
+}
+
+// IdleCloser is implemented by providers that keep connections to their
+// upstream open between requests.
+type IdleCloser interface {
+	// CloseIdleConnections closes the connections not carrying a request.
+	CloseIdleConnections()
+}
+
+// ProbeRequest sends a probe and reports the upstream unhealthy when it
+// cannot be reached or answers with a server error. Client errors such as a
+// missing /models route, and 501 Not Implemented, still show a live
+// upstream.
+func ProbeRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= http.StatusInternalServerError && resp.StatusCode != http.StatusNotImplemented {
+		return fmt.Errorf("upstream answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Providers returns the registered providers, sorted by name.
+func (r *Registry) Providers() []Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Provider, 0, len(r.byName))
+	for _, p := range r.byName {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out
+}
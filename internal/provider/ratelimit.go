@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimits tracks the rate-limit budget upstreams report in their
+// response headers, so requests can be held back, or routed elsewhere,
+// before the upstream starts answering 429.
+type RateLimits struct {
+	mu        sync.Mutex
+	providers map[string]*rateLimitState
+	reserves  map[string]RateLimitReserve
+}
+
+// RateLimitReserve is the budget kept back for other clients: a provider
+// counts as limited once no more than this is left.
+type RateLimitReserve struct {
+	Requests, Tokens int
+}
+
+// rateLimitState is a provider's budget as last reported. A negative
+// remaining count is unknown.
+type rateLimitState struct {
+	remainingRequests, remainingTokens int
+	resetRequests, resetTokens         time.Time
+	// retryAt is when a 429 asked to be retried.
+	retryAt time.Time
+}
+
+// NewRateLimits returns a tracker that knows no provider's budget.
+func NewRateLimits() *RateLimits {
+	return &RateLimits{
+		providers: make(map[string]*rateLimitState),
+		reserves:  make(map[string]RateLimitReserve),
+	}
+}
+
+// SetReserve sets the budget kept back for other clients of a provider.
+func (l *RateLimits) SetReserve(provider string, reserve RateLimitReserve) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reserves[provider] = reserve
+}
+
+// Observe records the budget a provider's response reports. OpenAI, Groq
+// and most compatible services send x-ratelimit-remaining-requests and
+// x-ratelimit-reset-requests (a duration such as 2m59.56s), and the same
+// for tokens; Anthropic sends anthropic-ratelimit-requests-remaining and
+// an RFC 3339 anthropic-ratelimit-requests-reset. A 429 also records its
+// Retry-After.
+func (l *RateLimits) Observe(provider string, header http.Header, status int, now time.Time) {
+	requests, requestsReset, okRequests := reportedBudget(header, "requests", now)
+	tokens, tokensReset, okTokens := reportedBudget(header, "tokens", now)
+	var retryAt time.Time
+	if status == http.StatusTooManyRequests {
+		wait, ok := retryAfter(header.Get("Retry-After"))
+		if !ok || wait == 0 {
+			wait = time.Second
+		}
+		retryAt = now.Add(wait)
+	}
+	if !okRequests && !okTokens && retryAt.IsZero() {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.providers[provider]
+	if !ok {
+		s = &rateLimitState{remainingRequests: -1, remainingTokens: -1}
+		l.providers[provider] = s
+	}
+	if okRequests {
+		s.remainingRequests, s.resetRequests = requests, requestsReset
+	}
+	if okTokens {
+		s.remainingTokens, s.resetTokens = tokens, tokensReset
+	}
+	if !retryAt.IsZero() {
+		s.retryAt = retryAt
+	}
+}
+
+// Limited reports whether a provider's budget is spent, down to its
+// reserve, and until when.
+func (l *RateLimits) Limited(provider string, now time.Time) (time.Time, bool) {
+	if l == nil {
+		return time.Time{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.providers[provider]
+	if !ok {
+		return time.Time{}, false
+	}
+	reserve := l.reserves[provider]
+	var until time.Time
+	if now.Before(s.retryAt) {
+		until = s.retryAt
+	}
+	if s.remainingRequests >= 0 && s.remainingRequests <= reserve.Requests && now.Before(s.resetRequests) && s.resetRequests.After(until) {
+		until = s.resetRequests
+	}
+	if s.remainingTokens >= 0 && s.remainingTokens <= reserve.Tokens && now.Before(s.resetTokens) && s.resetTokens.After(until) {
+		until = s.resetTokens
+	}
+	return until, !until.IsZero()
+}
+
+// Transport records the budget reported by every response to a provider
+// before passing it on.
+func (l *RateLimits) Transport(provider string, base http.RoundTripper) http.RoundTripper {
+	return &rateLimitTransport{limits: l, provider: provider, base: base}
+}
+
+type rateLimitTransport struct {
+	limits   *RateLimits
+	provider string
+	base     http.RoundTripper
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.limits.Observe(t.provider, resp.Header, resp.StatusCode, time.Now())
+	}
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the base transport.
+func (t *rateLimitTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// reportedBudget reads the remaining count and reset time of one kind of
+// budget, requests or tokens, in either header family.
+func reportedBudget(header http.Header, kind string, now time.Time) (int, time.Time, bool) {
+	if v := header.Get("x-ratelimit-remaining-" + kind); v != "" {
+		remaining, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+		reset, err := time.ParseDuration(strings.TrimSpace(header.Get("x-ratelimit-reset-" + kind)))
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+		return remaining, now.Add(reset), true
+	}
+	if v := header.Get("anthropic-ratelimit-" + kind + "-remaining"); v != "" {
+		remaining, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+		reset, err := time.Parse(time.RFC3339, strings.TrimSpace(header.Get("anthropic-ratelimit-"+kind+"-reset")))
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+		return remaining, reset, true
+	}
+	return 0, time.Time{}, false
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"gocode-router/internal/models"
@@ -18,17 +19,41 @@ var ErrDuplicateModel = errors.New("model already registered")
 // ErrUnsupportedOperation indicates the provider cannot fulfill the requested action.
 var ErrUnsupportedOperation = errors.New("unsupported provider operation")
 
+// StatusError is an error response from an upstream, with its HTTP status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
 // Provider defines the behaviour required to serve unified chat requests.
 type Provider interface {
 	Name() string
 	ListModels(ctx context.Context) ([]models.Model, error)
 	Chat(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, error)
+	// ChatStream sends a chat request and streams the response as it is
+	// generated.
+	ChatStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, error)
 	Completion(ctx context.Context, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, error)
+	// CompletionStream sends a text completion request and streams the
+	// text as it is generated, in chunks carrying Content.
+	CompletionStream(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedChatStream, error)
 }
 
 type modelEntry struct {
 	model    models.Model
 	provider Provider
+	// virtual marks a model served under a name other than its ID.
+	virtual bool
+}
+
+// Deployment is one provider serving a model.
+type Deployment struct {
+	Model    models.Model
+	Provider Provider
 }
 
 // Registry maintains a mapping of model IDs to providers.
@@ -36,16 +61,51 @@ type Registry struct {
 	mu     sync.RWMutex
 	models map[string]modelEntry
 	byName map[string]Provider
+	// deployments holds every provider serving a model ID that more than
+	// one provider registered. models keeps the first of them.
+	deployments map[string][]modelEntry
+	// discovery holds the providers whose models are discovered upstream.
+	discovery map[string]*discovery
+	// patterns serve the model IDs no entry in models claims.
+	patterns []patternEntry
+	// rateLimits holds the budget the providers' responses report.
+	rateLimits *RateLimits
+	// timeouts holds each provider's timeouts.
+	timeouts map[string]Timeouts
+}
+
+// ModelPattern serves every model ID it matches. Model describes the
+// models served; its ID is replaced by the one requested.
+type ModelPattern struct {
+	Match func(id string) bool
+	// Specificity ranks the patterns matching an ID; the highest wins and
+	// the first registered breaks a tie.
+	Specificity int
+	Model       models.Model
+}
+
+type patternEntry struct {
+	pattern  ModelPattern
+	provider Provider
 }
 
 // NewRegistry constructs an empty provider registry.
 func NewRegistry() *Registry {
 	return &Registry{
-		models: make(map[string]modelEntry),
-		byName: make(map[string]Provider),
+		models:      make(map[string]modelEntry),
+		byName:      make(map[string]Provider),
+		deployments: make(map[string][]modelEntry),
+		rateLimits:  NewRateLimits(),
+		timeouts:    make(map[string]Timeouts),
 	}
 }
 
+// RateLimits returns the rate-limit budget the registered providers'
+// responses report.
+func (r *Registry) RateLimits() *RateLimits {
+	return r.rateLimits
+}
+
 // RegisterProvider adds the provider and its models to the registry, wiring optional aliases.
 func (r *Registry) RegisterProvider(ctx context.Context, p Provider, aliases map[string]string) error {
 	if p == nil {
@@ -66,8 +126,17 @@ func (r *Registry) RegisterProvider(ctx context.Context, p Provider, aliases map
 	r.byName[p.Name()] = p
 
 	for _, model := range modelsList {
-		if _, exists := r.models[model.ID]; exists {
-			return fmt.Errorf("%w: %s", ErrDuplicateModel, model.ID)
+		if existing, exists := r.models[model.ID]; exists {
+			// Another provider serving the same ID adds a deployment of
+			// the model; one provider may not list it twice.
+			if existing.provider == p || existing.model.ID != model.ID {
+				return fmt.Errorf("%w: %s", ErrDuplicateModel, model.ID)
+			}
+			if len(r.deployments[model.ID]) == 0 {
+				r.deployments[model.ID] = []modelEntry{existing}
+			}
+			r.deployments[model.ID] = append(r.deployments[model.ID], modelEntry{model: model, provider: p})
+			continue
 		}
 
 		r.models[model.ID] = modelEntry{
@@ -76,12 +145,80 @@ func (r *Registry) RegisterProvider(ctx context.Context, p Provider, aliases map
 		}
 	}
 
+	return r.addAliases(aliases)
+}
+
+// RegisterPattern adds a pattern served by a registered provider.
+func (r *Registry) RegisterPattern(p Provider, pattern ModelPattern) error {
+	if pattern.Match == nil {
+		return errors.New("model pattern must have a match function")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.byName[p.Name()] != p {
+		return fmt.Errorf("provider %q is not registered", p.Name())
+	}
+	r.patterns = append(r.patterns, patternEntry{pattern: pattern, provider: p})
+	return nil
+}
+
+// RegisterVirtual serves a model of a registered provider under another
+// name. Unlike an alias, the name is a model of its own: requests for it
+// get model, which may carry its own system prompt, and always go to p.
+func (r *Registry) RegisterVirtual(p Provider, name string, model models.Model) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.byName[p.Name()] != p {
+		return fmt.Errorf("provider %q is not registered", p.Name())
+	}
+	if _, exists := r.models[name]; exists {
+		return fmt.Errorf("%w: %s", ErrDuplicateModel, name)
+	}
+	r.models[name] = modelEntry{model: model, provider: p, virtual: true}
+	return nil
+}
+
+// matchPattern returns the most specific pattern serving a model ID, with the
+// model's ID set to it.
+func (r *Registry) matchPattern(modelID string) (modelEntry, bool) {
+	var best *patternEntry
+	for i := range r.patterns {
+		entry := &r.patterns[i]
+		if !entry.pattern.Match(modelID) {
+			continue
+		}
+		if best == nil || entry.pattern.Specificity > best.pattern.Specificity {
+			best = entry
+		}
+	}
+	if best == nil {
+		return modelEntry{}, false
+	}
+	model := best.pattern.Model
+	model.ID = modelID
+	return modelEntry{model: model, provider: best.provider}, true
+}
+
+// RegisterAliases wires aliases to models of any registered provider.
+func (r *Registry) RegisterAliases(aliases map[string]string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addAliases(aliases)
+}
+
+func (r *Registry) addAliases(aliases map[string]string) error {
 	for alias, target := range aliases {
 		if _, exists := r.models[alias]; exists {
 			return fmt.Errorf("alias %q conflicts with existing model", alias)
 		}
 
 		targetEntry, ok := r.models[target]
+		if !ok {
+			targetEntry, ok = r.matchPattern(target)
+		}
 		if !ok {
 			return fmt.Errorf("alias %q references unknown model %q", alias, target)
 		}
@@ -92,14 +229,61 @@ func (r *Registry) RegisterProvider(ctx context.Context, p Provider, aliases map
 	return nil
 }
 
-// LookupModel returns the provider and metadata for a given model ID.
+// LookupModel returns the provider and metadata for a given model ID. An ID
+// no model or alias claims falls back to the patterns.
 func (r *Registry) LookupModel(modelID string) (models.Model, Provider, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	entry, ok := r.models[modelID]
+	if !ok {
+		entry, ok = r.matchPattern(modelID)
+	}
 	if !ok {
 		return models.Model{}, nil, fmt.Errorf("%w: %s", ErrUnknownModel, modelID)
 	}
 	return entry.model, entry.provider, nil
 }
+
+// Deployments returns every provider serving the model an ID or alias
+// resolves to, or nil when a single provider serves it.
+func (r *Registry) Deployments(modelID string) []Deployment {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.models[modelID]
+	if !ok || entry.virtual {
+		return nil
+	}
+	entries := r.deployments[entry.model.ID]
+	if len(entries) < 2 {
+		return nil
+	}
+	out := make([]Deployment, len(entries))
+	for i, e := range entries {
+		out[i] = Deployment{Model: e.model, Provider: e.provider}
+	}
+	return out
+}
+
+// Models lists every name the registry resolves, aliases included, sorted by
+// name. Patterns are not listed. Aliases carry the metadata of the model they resolve to, with ID set
+// to the alias and no display name; virtual models keep theirs.
+func (r *Registry) Models() []models.Model {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]models.Model, 0, len(r.models))
+	for name, entry := range r.models {
+		model := entry.model
+		if name != model.ID {
+			model.ID = name
+			if !entry.virtual {
+				model.DisplayName = ""
+			}
+		}
+		out = append(out, model)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
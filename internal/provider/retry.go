@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+// RetryTransport resends requests that failed before any response arrived,
+// or that were answered with a retryable status, waiting with exponential
+// backoff in between. A response is only returned once it is final, so a
+// stream that has started is never retried. Requests whose body cannot be
+// replayed are sent once.
+type RetryTransport struct {
+	Base   http.RoundTripper
+	Config config.RetryConfig
+}
+
+// CloseIdleConnections closes the idle connections of the base transport,
+// so http.Client.CloseIdleConnections reaches it.
+func (t *RetryTransport) CloseIdleConnections() {
+	if closer, ok := t.Base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if !t.Config.Enabled() || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		return base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := base.RoundTrip(attemptReq)
+		if attempt >= t.Config.Attempts || ctx.Err() != nil {
+			return resp, err
+		}
+		wait, retry := t.backoff(resp, err, attempt)
+		if !retry {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+		slog.Warn("retrying upstream request", "url", req.URL.Redacted(), "attempt", attempt+1, "wait", wait, "status", statusOf(resp), "error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff decides whether a failed attempt is retried and how long to wait
+// first. A Retry-After header is honoured as given, unless it asks for a
+// longer wait than max_backoff, in which case the failure is returned.
+func (t *RetryTransport) backoff(resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err == nil && !slices.Contains(t.Config.RetryStatuses(), resp.StatusCode) {
+		return 0, false
+	}
+	limit := t.Config.BackoffLimit()
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return after, after <= limit
+		}
+	}
+
+	wait := t.Config.InitialBackoff() << (attempt - 1)
+	if wait <= 0 || wait > limit {
+		wait = limit
+	}
+	if jitter := t.Config.Jitter; jitter > 0 {
+		wait += time.Duration(float64(wait) * jitter * (2*rand.Float64() - 1))
+	}
+	return wait, true
+}
+
+// retryAfter reads a Retry-After header in seconds or as an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	return resp.StatusCode
+}
@@ -0,0 +1,262 @@
+package sigv4
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	imdsEndpoint   = "http://169.254.169.254"
+	imdsTokenTTL   = "21600"
+	refreshMargin  = 5 * time.Minute
+	defaultProfile = "default"
+)
+
+// ErrNoCredentials is returned by a source that has nothing to offer, so the
+// chain moves on to the next one.
+var ErrNoCredentials = errors.New("no aws credentials found")
+
+// Credentials are an AWS access key pair, optionally temporary.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expires is zero for long-lived keys.
+	Expires time.Time
+}
+
+// CredentialsProvider returns credentials for signing.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// CredentialsFunc adapts a function to CredentialsProvider.
+type CredentialsFunc func(ctx context.Context) (Credentials, error)
+
+// Retrieve implements CredentialsProvider.
+func (f CredentialsFunc) Retrieve(ctx context.Context) (Credentials, error) { return f(ctx) }
+
+// StaticCredentials always returns the same keys.
+func StaticCredentials(accessKeyID, secretAccessKey, sessionToken string) CredentialsProvider {
+	return CredentialsFunc(func(context.Context) (Credentials, error) {
+		return Credentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey, SessionToken: sessionToken}, nil
+	})
+}
+
+// NewDefaultChain resolves credentials like the AWS SDKs: environment
+// variables, then the shared credentials and config files for profile (or
+// AWS_PROFILE), then the EC2 instance metadata service. Whatever source
+// answers first is cached until shortly before its credentials expire.
+func NewDefaultChain(client *http.Client, profile string) CredentialsProvider {
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = defaultProfile
+	}
+	return &cachedCredentials{chain: []CredentialsProvider{
+		CredentialsFunc(envCredentials),
+		CredentialsFunc(func(context.Context) (Credentials, error) { return sharedFileCredentials(profile) }),
+		CredentialsFunc(func(ctx context.Context) (Credentials, error) { return imdsCredentials(ctx, client) }),
+	}}
+}
+
+type cachedCredentials struct {
+	chain []CredentialsProvider
+
+	mu    sync.Mutex
+	creds Credentials
+}
+
+func (c *cachedCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.creds.AccessKeyID != "" && (c.creds.Expires.IsZero() || time.Now().Add(refreshMargin).Before(c.creds.Expires)) {
+		return c.creds, nil
+	}
+	for _, source := range c.chain {
+		creds, err := source.Retrieve(ctx)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return Credentials{}, err
+		}
+		c.creds = creds
+		return creds, nil
+	}
+	return Credentials{}, ErrNoCredentials
+}
+
+func envCredentials(context.Context) (Credentials, error) {
+	id := os.Getenv("AWS_ACCESS_KEY_ID")
+	secret := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	return Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+}
+
+// sharedFileCredentials reads static keys for profile from the shared
+// credentials file, falling back to the config file, where profiles other
+// than the default are written as "[profile name]".
+func sharedFileCredentials(profile string) (Credentials, error) {
+	home, _ := os.UserHomeDir()
+	credsPath := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credsPath == "" && home != "" {
+		credsPath = filepath.Join(home, ".aws", "credentials")
+	}
+	configPath := os.Getenv("AWS_CONFIG_FILE")
+	if configPath == "" && home != "" {
+		configPath = filepath.Join(home, ".aws", "config")
+	}
+
+	configSection := "profile " + profile
+	if profile == defaultProfile {
+		configSection = defaultProfile
+	}
+
+	for _, candidate := range []struct{ path, section string }{
+		{credsPath, profile},
+		{configPath, configSection},
+	} {
+		if candidate.path == "" {
+			continue
+		}
+		values, err := readINISection(candidate.path, candidate.section)
+		if err != nil {
+			return Credentials{}, err
+		}
+		if values["aws_access_key_id"] != "" && values["aws_secret_access_key"] != "" {
+			return Credentials{
+				AccessKeyID:     values["aws_access_key_id"],
+				SecretAccessKey: values["aws_secret_access_key"],
+				SessionToken:    values["aws_session_token"],
+			}, nil
+		}
+	}
+	return Credentials{}, ErrNoCredentials
+}
+
+// readINISection returns the key/value pairs of one section, or nothing
+// when the file does not exist.
+func readINISection(path, section string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	inSection := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == section
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// imdsCredentials fetches the instance role's credentials using IMDSv2
+// session tokens. Hosts without a metadata service yield ErrNoCredentials.
+func imdsCredentials(ctx context.Context, client *http.Client) (Credentials, error) {
+	if client == nil || os.Getenv("AWS_EC2_METADATA_DISABLED") == "true" {
+		return Credentials{}, ErrNoCredentials
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imdsEndpoint+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("construct imds token request: %w", err)
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", imdsTokenTTL)
+	token, err := imdsGet(client, req)
+	if err != nil {
+		// Anything short of an answer means this is not an EC2 host.
+		return Credentials{}, ErrNoCredentials
+	}
+
+	get := func(path string) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, imdsEndpoint+path, nil)
+		if err != nil {
+			return "", fmt.Errorf("construct imds request: %w", err)
+		}
+		req.Header.Set("X-Aws-Ec2-Metadata-Token", token)
+		return imdsGet(client, req)
+	}
+
+	roles, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return Credentials{}, fmt.Errorf("list instance roles: %w", err)
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(roles), "\n")
+	if role == "" {
+		return Credentials{}, ErrNoCredentials
+	}
+	doc, err := get("/latest/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("fetch instance role credentials: %w", err)
+	}
+
+	var parsed struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(doc), &parsed); err != nil {
+		return Credentials{}, fmt.Errorf("decode instance role credentials: %w", err)
+	}
+	return Credentials{
+		AccessKeyID:     parsed.AccessKeyID,
+		SecretAccessKey: parsed.SecretAccessKey,
+		SessionToken:    parsed.Token,
+		Expires:         parsed.Expiration,
+	}, nil
+}
+
+func imdsGet(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("imds returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
@@ -0,0 +1,178 @@
+package sigv4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+const (
+	preludeLength    = 12
+	maxMessageLength = 16 << 20
+)
+
+// Message is one frame of an application/vnd.amazon.eventstream response.
+type Message struct {
+	Headers map[string]any
+	Payload []byte
+}
+
+// StringHeader returns a string-valued header, such as ":event-type".
+func (m Message) StringHeader(name string) string {
+	v, _ := m.Headers[name].(string)
+	return v
+}
+
+// EventStreamDecoder reads event-stream frames, as returned by Bedrock's
+// streaming APIs.
+type EventStreamDecoder struct {
+	r *bufio.Reader
+}
+
+// NewEventStreamDecoder wraps a response body.
+func NewEventStreamDecoder(r io.Reader) *EventStreamDecoder {
+	return &EventStreamDecoder{r: bufio.NewReader(r)}
+}
+
+// Next returns the next message, or io.EOF once the stream ends cleanly.
+func (d *EventStreamDecoder) Next() (Message, error) {
+	prelude := make([]byte, preludeLength)
+	if _, err := io.ReadFull(d.r, prelude); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Message{}, io.EOF
+		}
+		return Message{}, fmt.Errorf("read event stream prelude: %w", err)
+	}
+
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[0:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return Message{}, errors.New("event stream prelude checksum mismatch")
+	}
+	if totalLength > maxMessageLength || totalLength < preludeLength+4 {
+		return Message{}, fmt.Errorf("event stream message length %d is invalid", totalLength)
+	}
+	// Checked against what's left of the message, since a corrupt headers
+	// length could wrap around if added to the prelude's.
+	if headersLength > totalLength-preludeLength-4 {
+		return Message{}, fmt.Errorf("event stream headers length %d exceeds message length %d", headersLength, totalLength)
+	}
+
+	frame := make([]byte, totalLength)
+	copy(frame, prelude)
+	if _, err := io.ReadFull(d.r, frame[preludeLength:]); err != nil {
+		return Message{}, fmt.Errorf("read event stream message: %w", err)
+	}
+	end := totalLength - 4
+	if crc32.ChecksumIEEE(frame[:end]) != binary.BigEndian.Uint32(frame[end:]) {
+		return Message{}, errors.New("event stream message checksum mismatch")
+	}
+
+	headers, err := decodeHeaders(frame[preludeLength : preludeLength+headersLength])
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Headers: headers, Payload: frame[preludeLength+headersLength : end]}, nil
+}
+
+// Header value types defined by the event-stream encoding.
+const (
+	headerBoolTrue  = 0
+	headerBoolFalse = 1
+	headerByte      = 2
+	headerInt16     = 3
+	headerInt32     = 4
+	headerInt64     = 5
+	headerBytes     = 6
+	headerString    = 7
+	headerTimestamp = 8
+	headerUUID      = 9
+)
+
+func decodeHeaders(data []byte) (map[string]any, error) {
+	headers := make(map[string]any)
+	truncated := errors.New("event stream header truncated")
+
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 1+nameLen+1 {
+			return nil, truncated
+		}
+		name := string(data[1 : 1+nameLen])
+		valueType := data[1+nameLen]
+		data = data[2+nameLen:]
+
+		fixed := func(n int) ([]byte, error) {
+			if len(data) < n {
+				return nil, truncated
+			}
+			v := data[:n]
+			data = data[n:]
+			return v, nil
+		}
+
+		switch valueType {
+		case headerBoolTrue:
+			headers[name] = true
+		case headerBoolFalse:
+			headers[name] = false
+		case headerByte:
+			v, err := fixed(1)
+			if err != nil {
+				return nil, err
+			}
+			headers[name] = int8(v[0])
+		case headerInt16:
+			v, err := fixed(2)
+			if err != nil {
+				return nil, err
+			}
+			headers[name] = int16(binary.BigEndian.Uint16(v))
+		case headerInt32:
+			v, err := fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			headers[name] = int32(binary.BigEndian.Uint32(v))
+		case headerInt64:
+			v, err := fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			headers[name] = int64(binary.BigEndian.Uint64(v))
+		case headerTimestamp:
+			v, err := fixed(8)
+			if err != nil {
+				return nil, err
+			}
+			headers[name] = time.UnixMilli(int64(binary.BigEndian.Uint64(v)))
+		case headerUUID:
+			v, err := fixed(16)
+			if err != nil {
+				return nil, err
+			}
+			headers[name] = append([]byte(nil), v...)
+		case headerBytes, headerString:
+			lenBytes, err := fixed(2)
+			if err != nil {
+				return nil, err
+			}
+			v, err := fixed(int(binary.BigEndian.Uint16(lenBytes)))
+			if err != nil {
+				return nil, err
+			}
+			if valueType == headerString {
+				headers[name] = string(v)
+			} else {
+				headers[name] = append([]byte(nil), v...)
+			}
+		default:
+			return nil, fmt.Errorf("event stream header %q has unknown type %d", name, valueType)
+		}
+	}
+	return headers, nil
+}
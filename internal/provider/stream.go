@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+
+	"gocode-router/internal/models"
+)
+
+// EventReader reads server-sent events from an upstream response body.
+type EventReader struct {
+	r *bufio.Reader
+}
+
+// NewEventReader returns a reader for the events in r.
+func NewEventReader(r io.Reader) *EventReader {
+	return &EventReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the name and data of the next event with data, joining
+// multi-line data with newlines. It returns io.EOF once the body ends;
+// a final event without its blank line is still returned.
+func (e *EventReader) Next() (name string, data []byte, err error) {
+	var buf bytes.Buffer
+	hasData := false
+	for {
+		line, err := e.r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", nil, err
+		}
+		eof := err != nil
+		line = bytes.TrimRight(line, "\r\n")
+
+		switch {
+		case len(line) == 0:
+			if hasData {
+				return name, buf.Bytes(), nil
+			}
+			if eof {
+				return "", nil, io.EOF
+			}
+			name = ""
+		case line[0] == ':':
+			// A comment, such as a keep-alive.
+		default:
+			field, value, _ := bytes.Cut(line, []byte(":"))
+			value = bytes.TrimPrefix(value, []byte(" "))
+			switch string(field) {
+			case "event":
+				name = string(value)
+			case "data":
+				if hasData {
+					buf.WriteByte('\n')
+				}
+				buf.Write(value)
+				hasData = true
+			}
+		}
+		if eof {
+			if hasData {
+				return name, buf.Bytes(), nil
+			}
+			return "", nil, io.EOF
+		}
+	}
+}
+
+// ReplayStream returns a stream of a response that has already been
+// received in full: one chunk per choice with its text, a final one with
+// the finish reason and usage.
+func ReplayStream(resp *models.UnifiedChatResponse) models.UnifiedChatStream {
+	choices := append([]models.Choice{{Message: resp.Message, FinishReason: resp.FinishReason}}, resp.ExtraChoices...)
+	chunks := make([]models.UnifiedChatChunk, 0, len(choices)+1)
+	for i, choice := range choices {
+		role := choice.Message.Role
+		if role == "" {
+			role = "assistant"
+		}
+		chunks = append(chunks, models.UnifiedChatChunk{ID: resp.ID, Model: resp.Model, Index: i, Role: role, Content: choice.Message.Content})
+	}
+	for i, choice := range choices {
+		chunks = append(chunks, models.UnifiedChatChunk{ID: resp.ID, Model: resp.Model, Index: i, FinishReason: choice.FinishReason})
+	}
+	usage := resp.Usage
+	chunks[len(chunks)-1].Usage = &usage
+	return &replayStream{chunks: chunks}
+}
+
+// ReplayCompletion returns a stream of a completion that has already been
+// received in full, as ReplayStream does for a chat.
+func ReplayCompletion(resp *models.UnifiedCompletionResponse) models.UnifiedChatStream {
+	return ReplayStream(&models.UnifiedChatResponse{
+		ID:           resp.ID,
+		Model:        resp.Model,
+		Message:      models.Message{Role: "assistant", Content: resp.Text},
+		FinishReason: resp.FinishReason,
+		Usage:        resp.Usage,
+	})
+}
+
+type replayStream struct {
+	mu     sync.Mutex
+	chunks []models.UnifiedChatChunk
+}
+
+func (s *replayStream) Recv() (models.UnifiedChatChunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.chunks) == 0 {
+		return models.UnifiedChatChunk{}, io.EOF
+	}
+	d := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return d, nil
+}
+
+func (s *replayStream) Close() error {
+	return nil
+}
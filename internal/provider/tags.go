@@ -0,0 +1,33 @@
+package provider
+
+import "context"
+
+type tagsKey struct{}
+
+type upstreamTagsKey struct{}
+
+// WithTags returns a context carrying the request's tags. When upstream is
+// set, providers that accept arbitrary metadata also forward them.
+func WithTags(ctx context.Context, tags map[string]string, upstream bool) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, tagsKey{}, tags)
+	if upstream {
+		ctx = context.WithValue(ctx, upstreamTagsKey{}, tags)
+	}
+	return ctx
+}
+
+// TagsFrom returns the tags carried by ctx.
+func TagsFrom(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(tagsKey{}).(map[string]string)
+	return tags
+}
+
+// UpstreamTagsFrom returns the tags carried by ctx that should be sent to
+// the upstream.
+func UpstreamTagsFrom(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(upstreamTagsKey{}).(map[string]string)
+	return tags
+}
@@ -0,0 +1,38 @@
+package provider
+
+import (
+	"cmp"
+	"time"
+
+	"gocode-router/internal/models"
+)
+
+// DefaultTimeout bounds a request to a provider that configures none.
+const DefaultTimeout = 60 * time.Second
+
+// Timeouts bound a request to an upstream: Request the whole of it, or a
+// stream's wait for its first chunk, and StreamIdle the wait for each later
+// chunk. Zero values take the defaults.
+type Timeouts struct {
+	Request    time.Duration
+	StreamIdle time.Duration
+}
+
+// SetTimeouts sets the timeouts of a provider's models that configure none
+// of their own.
+func (r *Registry) SetTimeouts(provider string, timeouts Timeouts) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeouts[provider] = timeouts
+}
+
+// Timeouts returns the timeouts of a model: its own, else its provider's,
+// else the defaults. The idle timeout defaults to the request timeout.
+func (r *Registry) Timeouts(modelInfo models.Model) Timeouts {
+	r.mu.RLock()
+	t := r.timeouts[modelInfo.Provider]
+	r.mu.RUnlock()
+	t.Request = cmp.Or(modelInfo.Timeout, t.Request, DefaultTimeout)
+	t.StreamIdle = cmp.Or(modelInfo.StreamIdleTimeout, t.StreamIdle, t.Request)
+	return t
+}
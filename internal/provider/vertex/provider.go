@@ -0,0 +1,256 @@
+// Package vertex serves Claude and Gemini models from Google Cloud Vertex AI.
+package vertex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+	claudeProvider "gocode-router/internal/provider/claude"
+	openaiProvider "gocode-router/internal/provider/openai"
+)
+
+const (
+	apiStyleOpenAI = "openai"
+	apiStyleClaude = "claude"
+)
+
+// Provider routes Claude-style models to Anthropic's publisher endpoints on
+// Vertex AI and OpenAI-style models, such as Gemini, to Vertex AI's
+// OpenAI-compatible endpoint.
+type Provider struct {
+	name        string
+	models      []models.Model
+	modelStyles map[string]string
+	// patterns give the API style of models no ID is configured for.
+	patterns []config.ModelConfig
+
+	openaiAdapter *openaiProvider.Provider
+	claudeAdapter *claudeProvider.Provider
+}
+
+// New constructs a Vertex AI provider for the configured project and
+// region. The base URL defaults to the region's API host.
+func New(name string, cfg config.ProviderConfig, client *http.Client) (*Provider, error) {
+	if client == nil {
+		return nil, errors.New("http client must not be nil")
+	}
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = cfg.Vertex.Endpoint()
+	}
+
+	var (
+		openaiModels []config.ModelConfig
+		claudeModels []config.ModelConfig
+		allModels    []models.Model
+		modelStyles  = make(map[string]string)
+		patterns     []config.ModelConfig
+	)
+	for _, model := range cfg.Models {
+		style := strings.TrimSpace(strings.ToLower(model.APIStyle))
+		if model.IsPattern() {
+			patterns = append(patterns, model)
+		} else {
+			modelStyles[model.ID] = style
+			allModels = append(allModels, models.Model{
+				ID:                model.ID,
+				Provider:          name,
+				APIStyle:          style,
+				MaxOutputTokens:   model.MaxOutputTokens,
+				ContextWindow:     model.ContextWindow,
+				Timeout:           model.Timeout,
+				StreamIdleTimeout: model.StreamIdleTimeout,
+				DisplayName:       model.DisplayName,
+				Weight:            model.Weight,
+				Passthrough:       model.Passthrough,
+				SystemPrompt:      model.SystemPrompt,
+				PrependSystem:     model.Prepend,
+				DefaultOptions:    model.DefaultOptions(),
+				OverrideOptions:   model.OverrideOptions(),
+				Concurrency: models.ConcurrencyLimit{
+					Max:          model.Concurrency.Max,
+					Queue:        model.Concurrency.Queue,
+					QueueTimeout: model.Concurrency.QueueTimeout,
+				},
+			})
+		}
+
+		switch style {
+		case apiStyleOpenAI:
+			openaiModels = append(openaiModels, model)
+		case apiStyleClaude:
+			claudeModels = append(claudeModels, model)
+		default:
+			return nil, fmt.Errorf("model %s: unsupported api_style %q", model.Name(), model.APIStyle)
+		}
+	}
+
+	p := &Provider{
+		name:        name,
+		models:      allModels,
+		modelStyles: modelStyles,
+		patterns:    patterns,
+	}
+
+	if len(openaiModels) > 0 {
+		openaiCfg := cfg
+		openaiCfg.BaseURL = baseURL + cfg.Vertex.LocationPath() + "/endpoints/openapi"
+		openaiCfg.Models = openaiModels
+		// Organization and project are OpenAI billing headers.
+		openaiCfg.Organization, openaiCfg.Project = "", ""
+
+		adapter, err := openaiProvider.New(name, openaiCfg, client)
+		if err != nil {
+			return nil, fmt.Errorf("initialize openai adapter: %w", err)
+		}
+		p.openaiAdapter = adapter
+	}
+
+	if len(claudeModels) > 0 {
+		claudeCfg := cfg
+		claudeCfg.BaseURL = baseURL
+		claudeCfg.Models = claudeModels
+
+		adapter, err := claudeProvider.NewVertex(name, claudeCfg, client)
+		if err != nil {
+			return nil, fmt.Errorf("initialize claude adapter: %w", err)
+		}
+		p.claudeAdapter = adapter
+	}
+
+	return p, nil
+}
+
+// style returns the API style of a model, falling back to the patterns
+// matching it.
+func (p *Provider) style(model string) (string, bool) {
+	if style, ok := p.modelStyles[model]; ok {
+		return style, true
+	}
+	pattern, ok := config.MatchModelPattern(p.patterns, model)
+	if !ok {
+		return "", false
+	}
+	return strings.TrimSpace(strings.ToLower(pattern.APIStyle)), true
+}
+
+// openAIModel returns the ID the OpenAI-compatible endpoint knows a model
+// by. It names models by publisher, so Gemini IDs without one are Google's.
+func openAIModel(model string) string {
+	if strings.Contains(model, "/") {
+		return model
+	}
+	return "google/" + model
+}
+
+func (p *Provider) Name() string {
+	return p.name
+}
+
+// CloseIdleConnections implements provider.IdleCloser through the
+// configured adapters.
+func (p *Provider) CloseIdleConnections() {
+	if p.openaiAdapter != nil {
+		p.openaiAdapter.CloseIdleConnections()
+	}
+	if p.claudeAdapter != nil {
+		p.claudeAdapter.CloseIdleConnections()
+	}
+}
+
+func (p *Provider) ListModels(ctx context.Context) ([]models.Model, error) {
+	result := make([]models.Model, len(p.models))
+	copy(result, p.models)
+	return result, nil
+}
+
+func (p *Provider) Chat(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, error) {
+	style, err := p.adapterStyle(req.Model)
+	if err != nil {
+		return nil, err
+	}
+	if style == apiStyleClaude {
+		return p.claudeAdapter.Chat(ctx, req)
+	}
+	req.Model = openAIModel(req.Model)
+	return p.openaiAdapter.Chat(ctx, req)
+}
+
+// ChatStream implements provider.Provider through the adapter for the
+// model's API style.
+func (p *Provider) ChatStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, error) {
+	style, err := p.adapterStyle(req.Model)
+	if err != nil {
+		return nil, err
+	}
+	if style == apiStyleClaude {
+		return p.claudeAdapter.ChatStream(ctx, req)
+	}
+	req.Model = openAIModel(req.Model)
+	return p.openaiAdapter.ChatStream(ctx, req)
+}
+
+// Completion implements provider.Provider. Vertex AI serves no legacy
+// completions endpoint.
+func (p *Provider) Completion(ctx context.Context, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, error) {
+	return nil, fmt.Errorf("completions are not supported by provider %s: %w", p.name, provider.ErrUnsupportedOperation)
+}
+
+// CompletionStream implements provider.Provider; see Completion.
+func (p *Provider) CompletionStream(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedChatStream, error) {
+	return nil, fmt.Errorf("completions are not supported by provider %s: %w", p.name, provider.ErrUnsupportedOperation)
+}
+
+// Passthrough implements provider.Passthrougher through the adapter for the
+// model's API style.
+func (p *Provider) Passthrough(ctx context.Context, req provider.PassthroughRequest) (*http.Response, error) {
+	style, err := p.adapterStyle(req.Model)
+	if err != nil {
+		return nil, err
+	}
+	if style == apiStyleClaude {
+		return p.claudeAdapter.Passthrough(ctx, req)
+	}
+	if req.Operation != "/chat/completions" {
+		return nil, fmt.Errorf("vertex ai only serves chat completions: %w", provider.ErrUnsupportedOperation)
+	}
+	req.Model = openAIModel(req.Model)
+	if req.Body, err = provider.ReplaceModel(req.Body, req.Model); err != nil {
+		return nil, err
+	}
+	return p.openaiAdapter.Passthrough(ctx, req)
+}
+
+// adapterStyle returns the API style of a model whose adapter is
+// configured.
+func (p *Provider) adapterStyle(model string) (string, error) {
+	style, ok := p.style(model)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", provider.ErrUnknownModel, model)
+	}
+	switch {
+	case style == apiStyleOpenAI && p.openaiAdapter != nil:
+	case style == apiStyleClaude && p.claudeAdapter != nil:
+	default:
+		return "", fmt.Errorf("model %s configured as %s style but adapter missing", model, style)
+	}
+	return style, nil
+}
+
+// Probe implements provider.Prober through whichever adapter is configured;
+// both need a Google token first, so a probe also checks the credentials.
+func (p *Provider) Probe(ctx context.Context) error {
+	if p.claudeAdapter != nil {
+		return p.claudeAdapter.Probe(ctx)
+	}
+	if p.openaiAdapter != nil {
+		return p.openaiAdapter.Probe(ctx)
+	}
+	return nil
+}
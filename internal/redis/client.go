@@ -0,0 +1,259 @@
+// Package redis implements the small subset of the Redis protocol (RESP2)
+// the router needs for state shared between instances.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDialTimeout = 5 * time.Second
+	defaultIOTimeout   = 5 * time.Second
+	maxIdleConns       = 8
+)
+
+// ErrNil is returned when a key does not exist.
+var ErrNil = errors.New("redis: nil reply")
+
+// Error is an error reply returned by the server.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Options configures a Client.
+type Options struct {
+	Addr     string
+	Password string
+	DB       int
+}
+
+// Client is a minimal pooled Redis client safe for concurrent use.
+type Client struct {
+	opts Options
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+}
+
+// New constructs a client. Connections are established lazily.
+func New(opts Options) (*Client, error) {
+	if opts.Addr == "" {
+		return nil, errors.New("redis address must not be empty")
+	}
+	return &Client{opts: opts}, nil
+}
+
+// Do sends a command and returns its reply: string, int64, []any or nil.
+func (c *Client) Do(ctx context.Context, args ...any) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(ctx, args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		cn.netConn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Int runs a command whose reply is an integer.
+func (c *Client) Int(ctx context.Context, args ...any) (int64, error) {
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case nil:
+		return 0, ErrNil
+	default:
+		return 0, fmt.Errorf("redis: unexpected reply type %T", reply)
+	}
+}
+
+// String runs a command whose reply is a bulk or simple string.
+func (c *Client) String(ctx context.Context, args ...any) (string, error) {
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case nil:
+		return "", ErrNil
+	default:
+		return "", fmt.Errorf("redis: unexpected reply type %T", reply)
+	}
+}
+
+// Close releases idle connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cn := range c.idle {
+		cn.netConn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: defaultDialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis dial %s: %w", c.opts.Addr, err)
+	}
+	cn := &conn{netConn: netConn, reader: bufio.NewReader(netConn)}
+
+	if c.opts.Password != "" {
+		if _, err := cn.do(ctx, []any{"AUTH", c.opts.Password}); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := cn.do(ctx, []any{"SELECT", c.opts.DB}); err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("redis select db %d: %w", c.opts.DB, err)
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdleConns {
+		cn.netConn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+func (cn *conn) do(ctx context.Context, args []any) (any, error) {
+	deadline := time.Now().Add(defaultIOTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := cn.netConn.SetDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("redis set deadline: %w", err)
+	}
+
+	if _, err := cn.netConn.Write(encodeCommand(args)); err != nil {
+		return nil, fmt.Errorf("redis write: %w", err)
+	}
+	return readReply(cn.reader)
+}
+
+func encodeCommand(args []any) []byte {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		default:
+			s = fmt.Sprint(v)
+		}
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(s)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, s...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis read: %w", err)
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, Error(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", payload)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("redis read: %w", err)
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", payload)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]any, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := readReply(r)
+			var replyErr Error
+			if err != nil && !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
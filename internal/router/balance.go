@@ -0,0 +1,279 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+// balancer spreads a virtual model across its targets. Outcomes are kept as
+// exponentially decayed sums, so recent requests dominate the weights.
+type balancer struct {
+	name     string
+	targets  []config.BalancerTarget
+	adaptive config.AdaptiveConfig
+	now      func() time.Time
+
+	mu    sync.Mutex
+	stats []targetStats
+}
+
+type targetStats struct {
+	updated   time.Time
+	requests  float64
+	successes float64
+	// latency sums the seconds of successful requests.
+	latency float64
+}
+
+// decayed returns the stats as seen at now.
+func (s targetStats) decayed(now time.Time, halfLife time.Duration) targetStats {
+	if s.updated.IsZero() {
+		return s
+	}
+	f := math.Exp2(-float64(now.Sub(s.updated)) / float64(halfLife))
+	return targetStats{updated: now, requests: s.requests * f, successes: s.successes * f, latency: s.latency * f}
+}
+
+func compileBalancers(cfgs map[string]config.BalancerConfig) map[string]*balancer {
+	balancers := make(map[string]*balancer, len(cfgs))
+	for name, cfg := range cfgs {
+		balancers[name] = &balancer{
+			name:     name,
+			targets:  cfg.Targets,
+			adaptive: cfg.Adaptive,
+			now:      time.Now,
+			stats:    make([]targetStats, len(cfg.Targets)),
+		}
+	}
+	return balancers
+}
+
+// BalancerTargetState reports a balancer target's current weight.
+type BalancerTargetState struct {
+	Model       string  `json:"model"`
+	Weight      float64 `json:"weight"`
+	BaseWeight  float64 `json:"base_weight"`
+	Pinned      bool    `json:"pinned,omitempty"`
+	Samples     float64 `json:"samples"`
+	SuccessRate float64 `json:"success_rate"`
+	LatencyMS   float64 `json:"latency_ms,omitempty"`
+}
+
+// BalancerState reports a balancer's targets.
+type BalancerState struct {
+	Name     string                `json:"name"`
+	Adaptive bool                  `json:"adaptive"`
+	Targets  []BalancerTargetState `json:"targets"`
+}
+
+// Balancers reports the current weights of every balancer.
+func (r *Router) Balancers() []BalancerState {
+	out := make([]BalancerState, 0, len(r.balancers))
+	for _, b := range r.balancers {
+		out = append(out, b.state())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (b *balancer) state() BalancerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := BalancerState{Name: b.name, Adaptive: b.adaptive.Enabled}
+	weights := b.weights()
+	now := b.now()
+	for i, target := range b.targets {
+		s := b.stats[i].decayed(now, b.adaptive.DecayHalfLife())
+		t := BalancerTargetState{
+			Model:      target.Model,
+			Weight:     weights[i],
+			BaseWeight: target.BaseWeight(),
+			Pinned:     target.Pinned,
+			Samples:    s.requests,
+		}
+		if s.requests > 0 {
+			t.SuccessRate = s.successes / s.requests
+		}
+		if s.successes > 0 {
+			t.LatencyMS = s.latency / s.successes * 1000
+		}
+		state.Targets = append(state.Targets, t)
+	}
+	return state
+}
+
+// weights returns each target's effective weight. Adaptive weights scale the
+// configured weight by the target's score relative to the average score,
+// where a score is the squared success rate times how close the target's
+// latency is to the fastest target's. Targets with too few recent samples
+// keep their configured weight. The caller holds b.mu.
+func (b *balancer) weights() []float64 {
+	weights := make([]float64, len(b.targets))
+	for i, target := range b.targets {
+		weights[i] = target.BaseWeight()
+	}
+	if !b.adaptive.Enabled {
+		return weights
+	}
+
+	now := b.now()
+	halfLife := b.adaptive.DecayHalfLife()
+	minSamples := float64(b.adaptive.SampleFloor())
+	stats := make([]targetStats, len(b.stats))
+	fastest := math.Inf(1)
+	for i := range b.stats {
+		stats[i] = b.stats[i].decayed(now, halfLife)
+		if stats[i].requests >= minSamples && stats[i].successes > 0 {
+			fastest = math.Min(fastest, stats[i].latency/stats[i].successes)
+		}
+	}
+
+	scores := make([]float64, len(stats))
+	var sum float64
+	var scored int
+	for i, s := range stats {
+		if s.requests < minSamples || b.targets[i].Pinned {
+			scores[i] = -1
+			continue
+		}
+		success := s.successes / s.requests
+		score := success * success
+		if s.successes > 0 {
+			if latency := s.latency / s.successes; latency > 0 {
+				score *= fastest / latency
+			}
+		}
+		scores[i] = score
+		sum += score
+		scored++
+	}
+	if scored == 0 {
+		return weights
+	}
+
+	mean := sum / float64(scored)
+	floor, ceiling := b.adaptive.Bounds()
+	for i, score := range scores {
+		if score < 0 {
+			continue
+		}
+		factor := floor
+		if mean > 0 {
+			factor = math.Max(floor, math.Min(ceiling, score/mean))
+		}
+		weights[i] *= factor
+	}
+	return weights
+}
+
+// heaviest returns the target with the largest effective weight.
+func (b *balancer) heaviest() int {
+	b.mu.Lock()
+	weights := b.weights()
+	b.mu.Unlock()
+	best := 0
+	for i, w := range weights {
+		if w > weights[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// pick chooses a target at random in proportion to the effective weights,
+// passing over targets that are not usable unless none is.
+func (b *balancer) pick(usable func(model string) bool) int {
+	b.mu.Lock()
+	weights := b.weights()
+	b.mu.Unlock()
+
+	skipped := make([]float64, len(weights))
+	for i, target := range b.targets {
+		if !usable(target.Model) {
+			skipped[i], weights[i] = weights[i], 0
+		}
+	}
+	if !slices.ContainsFunc(weights, func(w float64) bool { return w > 0 }) {
+		weights = skipped
+	}
+	return weightedPick(weights)
+}
+
+// weightedPick draws an index at random in proportion to weights.
+func weightedPick(weights []float64) int {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	n := rand.Float64() * total
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(weights) - 1
+}
+
+// observe records the outcome of a request to target i. Requests the
+// client cancelled say nothing about the target and are not counted.
+func (b *balancer) observe(i int, latency time.Duration, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	s := b.stats[i].decayed(now, b.adaptive.DecayHalfLife())
+	s.updated = now
+	s.requests++
+	if err == nil {
+		s.successes++
+		s.latency += latency.Seconds()
+	}
+	b.stats[i] = s
+}
+
+// balancedChat sends the request to one of the balancer's targets.
+func (r *Router) balancedChat(ctx context.Context, b *balancer, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	i := b.pick(r.available)
+	req.Model = b.targets[i].Model
+	start := time.Now()
+	resp, modelInfo, err := r.chatModel(ctx, req)
+	b.observe(i, time.Since(start), err)
+	if err != nil || resp == nil {
+		return resp, modelInfo, err
+	}
+	resp.Annotate("balancer", map[string]any{
+		"name":  b.name,
+		"model": req.Model,
+	})
+	return resp, modelInfo, nil
+}
+
+// balancedCompletion sends a completion request to one of the balancer's
+// targets.
+func (r *Router) balancedCompletion(ctx context.Context, b *balancer, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, models.Model, error) {
+	i := b.pick(r.available)
+	req.Model = b.targets[i].Model
+	start := time.Now()
+	resp, modelInfo, err := r.completionModel(ctx, req)
+	b.observe(i, time.Since(start), err)
+	if err != nil || resp == nil {
+		return resp, modelInfo, err
+	}
+	resp.Annotate("balancer", map[string]any{
+		"name":  b.name,
+		"model": req.Model,
+	})
+	return resp, modelInfo, nil
+}
@@ -0,0 +1,37 @@
+package router
+
+import (
+	"context"
+	"time"
+
+	"gocode-router/internal/chaos"
+	"gocode-router/internal/models"
+)
+
+// UseChaos makes the router delay or fail upstream calls with the faults
+// faults chooses for each model.
+func (r *Router) UseChaos(faults func(model string) chaos.Faults) {
+	r.chaos = faults
+}
+
+// injectFaults applies the chaos faults chosen for a call to the model: it
+// waits out the injected delay, then fails the call if an error was chosen.
+func (r *Router) injectFaults(ctx context.Context, modelInfo models.Model) error {
+	if r.chaos == nil {
+		return nil
+	}
+	faults := r.chaos(modelInfo.ID)
+	if faults.Delay > 0 {
+		timer := time.NewTimer(faults.Delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	if faults.Status != 0 {
+		return &chaos.Error{Status: faults.Status, Model: modelInfo.ID}
+	}
+	return nil
+}
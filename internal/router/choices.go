@@ -0,0 +1,90 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+// choiceCount returns the n option, or 1 when it is absent.
+func choiceCount(options map[string]any) int {
+	switch v := options["n"].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 1
+}
+
+// emulateChoices answers a request for n choices from a model whose API
+// returns one, with n calls of which at most the configured number run at
+// once. The first answer is the response; the rest become its extra
+// choices, and usage is summed over every call.
+func (r *Router) emulateChoices(ctx context.Context, providerImpl provider.Provider, modelInfo models.Model, req models.UnifiedChatRequest, n int) (*models.UnifiedChatResponse, models.Model, error) {
+	emulation := r.routing.ChoiceEmulation
+	if !emulation.Enabled {
+		return nil, models.Model{}, fmt.Errorf("model %s does not support n > 1 and n emulation is disabled: %w", modelInfo.ID, provider.ErrUnsupportedOperation)
+	}
+	if n > emulation.Limit() {
+		return nil, models.Model{}, fmt.Errorf("n is %d, but at most %d choices are emulated for model %s: %w", n, emulation.Limit(), modelInfo.ID, provider.ErrUnsupportedOperation)
+	}
+
+	delete(req.Options, "n")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	responses := make([]*models.UnifiedChatResponse, n)
+	slots := make(chan struct{}, emulation.Concurrency())
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+			resp, err := r.callChat(ctx, providerImpl, modelInfo, req)
+			if err != nil {
+				// The first failure fails the request and cancels the rest.
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				cancel()
+				return
+			}
+			responses[i] = resp
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, models.Model{}, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, models.Model{}, err
+	}
+
+	resp := responses[0]
+	for _, extra := range responses[1:] {
+		resp.ExtraChoices = append(resp.ExtraChoices, models.Choice{Message: extra.Message, FinishReason: extra.FinishReason})
+		resp.Usage.PromptTokens += extra.Usage.PromptTokens
+		resp.Usage.CompletionTokens += extra.Usage.CompletionTokens
+		resp.Usage.TotalTokens += extra.Usage.TotalTokens
+	}
+	resp.Annotate("n_emulation", map[string]any{"calls": n})
+	return resp, modelInfo, nil
+}
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+const defaultQueueTimeout = 30 * time.Second
+
+// ConcurrencyError reports that a model, or the provider serving it, was
+// at its concurrency cap and its queue was full, or that the request waited
+// too long for a slot. Provider is set when the provider's cap was hit.
+type ConcurrencyError struct {
+	Model    string
+	Provider string
+	Max      int
+	Queued   bool
+}
+
+func (e *ConcurrencyError) Error() string {
+	subject := "model " + e.Model
+	if e.Provider != "" {
+		subject = "provider " + e.Provider
+	}
+	if e.Queued {
+		return fmt.Sprintf("%s is busy: timed out waiting for one of its %d concurrent request slots", subject, e.Max)
+	}
+	return fmt.Sprintf("%s is busy: all %d concurrent request slots and its queue are in use", subject, e.Max)
+}
+
+// UseProviderConcurrency caps the requests in flight to each provider in
+// limits, across all of its models.
+func (r *Router) UseProviderConcurrency(limits map[string]config.ConcurrencyConfig) {
+	r.providerLimits = make(map[string]models.ConcurrencyLimit, len(limits))
+	for name, limit := range limits {
+		if limit.Max > 0 {
+			r.providerLimits[name] = models.ConcurrencyLimit{Max: limit.Max, Queue: limit.Queue, QueueTimeout: limit.QueueTimeout}
+		}
+	}
+}
+
+// modelLimiter holds a model's or a provider's request slots and counts the
+// requests waiting for one.
+type modelLimiter struct {
+	slots   chan struct{}
+	mu      sync.Mutex
+	waiting int
+}
+
+// limiter returns the limiter under key for a cap, creating it on first use.
+// Without a cap it returns nil.
+func (r *Router) limiter(key string, limit models.ConcurrencyLimit) *modelLimiter {
+	if limit.Max <= 0 {
+		return nil
+	}
+	r.limitersMu.Lock()
+	defer r.limitersMu.Unlock()
+	if r.limiters == nil {
+		r.limiters = make(map[string]*modelLimiter)
+	}
+	l, ok := r.limiters[key]
+	if !ok {
+		l = &modelLimiter{slots: make(chan struct{}, limit.Max)}
+		r.limiters[key] = l
+	}
+	return l
+}
+
+// acquireModel takes one of the model's request slots and then one of its
+// provider's, queueing for each if the configuration allows it. The
+// returned function gives the slots back.
+func (r *Router) acquireModel(ctx context.Context, modelInfo models.Model) (func(), error) {
+	limit := modelInfo.Concurrency
+	modelSlots := r.limiter("model:"+modelInfo.Provider+"/"+modelInfo.ID, limit)
+	releaseModel, err := modelSlots.acquire(ctx, limit, &ConcurrencyError{Model: modelInfo.ID, Max: limit.Max})
+	if err != nil {
+		return nil, err
+	}
+	limit = r.providerLimits[modelInfo.Provider]
+	providerSlots := r.limiter("provider:"+modelInfo.Provider, limit)
+	releaseProvider, err := providerSlots.acquire(ctx, limit, &ConcurrencyError{Model: modelInfo.ID, Provider: modelInfo.Provider, Max: limit.Max})
+	if err != nil {
+		releaseModel()
+		return nil, err
+	}
+	return func() {
+		releaseProvider()
+		releaseModel()
+	}, nil
+}
+
+// acquire takes one of the limiter's slots, queueing if limit allows it. A
+// nil limiter has no cap. busy is returned, marked as queued when the wait
+// timed out, when no slot can be had.
+func (l *modelLimiter) acquire(ctx context.Context, limit models.ConcurrencyLimit, busy *ConcurrencyError) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.waiting >= limit.Queue {
+		l.mu.Unlock()
+		return nil, busy
+	}
+	l.waiting++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+
+	timeout := limit.QueueTimeout
+	if timeout <= 0 {
+		timeout = defaultQueueTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		busy.Queued = true
+		return nil, busy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
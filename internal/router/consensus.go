@@ -0,0 +1,97 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+type voteGroup struct {
+	first  int
+	models []string
+}
+
+// consensusChat samples the configured models and returns the answer most
+// samples agree on, with the vote breakdown attached as metadata.
+func (r *Router) consensusChat(ctx context.Context, name string, consensus config.ConsensusConfig, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	candidates := r.fanOut(ctx, consensus.SampleModels(), req)
+
+	var succeeded []candidate
+	for _, cand := range candidates {
+		if cand.err != nil {
+			slog.Warn("consensus sample failed", "consensus", name, "model", cand.model, "error", cand.err)
+			continue
+		}
+		succeeded = append(succeeded, cand)
+	}
+	if len(succeeded) == 0 {
+		return nil, models.Model{}, fmt.Errorf("consensus %s: all samples failed: %w", name, candidates[0].err)
+	}
+
+	groups := make(map[string]*voteGroup)
+	var order []string
+	for i, cand := range succeeded {
+		key := normaliseAnswer(answerText(cand.resp.Message))
+		group, ok := groups[key]
+		if !ok {
+			group = &voteGroup{first: i}
+			groups[key] = group
+			order = append(order, key)
+		}
+		group.models = append(group.models, cand.model)
+	}
+
+	winnerKey := order[0]
+	for _, key := range order[1:] {
+		if len(groups[key].models) > len(groups[winnerKey].models) {
+			winnerKey = key
+		}
+	}
+	winner := groups[winnerKey]
+	selected := succeeded[winner.first]
+
+	votes := make([]map[string]any, 0, len(order))
+	for _, key := range order {
+		group := groups[key]
+		votes = append(votes, map[string]any{
+			"votes":   len(group.models),
+			"models":  group.models,
+			"answer":  answerText(succeeded[group.first].resp.Message),
+			"winning": key == winnerKey,
+		})
+	}
+
+	agreement := float64(len(winner.models)) / float64(len(succeeded))
+	slog.Info("consensus selection",
+		"consensus", name,
+		"samples", len(succeeded),
+		"distinct_answers", len(order),
+		"agreement", agreement,
+		"selected", selected.model,
+	)
+
+	resp := *selected.resp
+	resp.Usage = sumUsage(succeeded)
+	resp.Metadata = nil
+	for k, v := range selected.resp.Metadata {
+		resp.Annotate(k, v)
+	}
+	resp.Annotate("consensus", map[string]any{
+		"name":      name,
+		"samples":   len(succeeded),
+		"agreement": agreement,
+		"votes":     votes,
+	})
+
+	return &resp, selected.modelInfo, nil
+}
+
+// normaliseAnswer folds case and whitespace so trivially different answers
+// count as the same vote.
+func normaliseAnswer(content string) string {
+	return strings.ToLower(strings.Join(strings.Fields(content), " "))
+}
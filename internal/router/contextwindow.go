@@ -0,0 +1,145 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/tokens"
+)
+
+// ContextLengthError reports that a request was not sent because its
+// prompt, with the output it asks for, does not fit the model's context
+// window.
+type ContextLengthError struct {
+	Model      string
+	Limit      int
+	Prompt     int
+	Completion int
+}
+
+func (e *ContextLengthError) Error() string {
+	return fmt.Sprintf("model %s has a context window of %d tokens, but the request needs %d: %d in the prompt and %d for the completion", e.Model, e.Limit, e.Prompt+e.Completion, e.Prompt, e.Completion)
+}
+
+// checkChatContext counts a chat request with the model's local tokenizer
+// and fails when it does not fit the model's context window.
+func (r *Router) checkChatContext(modelInfo models.Model, req models.UnifiedChatRequest) error {
+	if modelInfo.ContextWindow <= 0 {
+		return nil
+	}
+	prompt := tokens.CountPrompt(r.Tokenizer(modelInfo.ID), req.Messages, req.Tools)
+	completion, _ := maxTokensOption(req.Options)
+	return fitContext(modelInfo, prompt, completion)
+}
+
+// checkCompletionContext is checkChatContext for a text completion.
+func (r *Router) checkCompletionContext(modelInfo models.Model, req models.UnifiedCompletionRequest) error {
+	if modelInfo.ContextWindow <= 0 {
+		return nil
+	}
+	prompt := len(r.Tokenizer(modelInfo.ID).Encode(req.Prompt))
+	return fitContext(modelInfo, prompt, req.MaxTokens)
+}
+
+func fitContext(modelInfo models.Model, prompt, completion int) error {
+	if prompt+completion <= modelInfo.ContextWindow {
+		return nil
+	}
+	return &ContextLengthError{Model: modelInfo.ID, Limit: modelInfo.ContextWindow, Prompt: prompt, Completion: completion}
+}
+
+// estimateChatUsage fills in the usage of a response whose upstream
+// reported none, counting the request and the answers with the model's
+// local tokenizer. The response is annotated as estimated.
+func (r *Router) estimateChatUsage(modelInfo models.Model, req models.UnifiedChatRequest, resp *models.UnifiedChatResponse) {
+	if resp.Usage != (models.Usage{}) {
+		return
+	}
+	tokenizer := r.Tokenizer(modelInfo.ID)
+	prompt := tokens.CountPrompt(tokenizer, req.Messages, req.Tools)
+	completion := answerTokens(tokenizer, resp.Message)
+	for _, choice := range resp.ExtraChoices {
+		completion += answerTokens(tokenizer, choice.Message)
+	}
+	resp.Usage = models.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+	resp.Annotate("usage", map[string]any{"estimated": true, "tokenizer": tokenizer.Name()})
+}
+
+// estimateCompletionUsage is estimateChatUsage for a text completion.
+func (r *Router) estimateCompletionUsage(modelInfo models.Model, req models.UnifiedCompletionRequest, resp *models.UnifiedCompletionResponse) {
+	if resp.Usage != (models.Usage{}) {
+		return
+	}
+	tokenizer := r.Tokenizer(modelInfo.ID)
+	prompt, completion := len(tokenizer.Encode(req.Prompt)), len(tokenizer.Encode(resp.Text))
+	resp.Usage = models.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+	resp.Annotate("usage", map[string]any{"estimated": true, "tokenizer": tokenizer.Name()})
+}
+
+func answerTokens(tokenizer tokens.Tokenizer, msg models.Message) int {
+	total := len(tokenizer.Encode(msg.Content))
+	for _, call := range msg.ToolCalls {
+		total += len(tokenizer.Encode(call.Name)) + len(tokenizer.Encode(call.Arguments))
+	}
+	return total
+}
+
+// usageEstimatingStream ends a stream whose upstream reported no usage
+// with a chunk carrying usage counted with the model's local tokenizer.
+type usageEstimatingStream struct {
+	models.UnifiedChatStream
+	tokenizer tokens.Tokenizer
+	prompt    int
+	answer    strings.Builder
+	id, model string
+	reported  bool
+}
+
+func (r *Router) estimateStreamUsage(modelInfo models.Model, req models.UnifiedChatRequest, stream models.UnifiedChatStream) models.UnifiedChatStream {
+	tokenizer := r.Tokenizer(modelInfo.ID)
+	return &usageEstimatingStream{
+		UnifiedChatStream: stream,
+		tokenizer:         tokenizer,
+		prompt:            tokens.CountPrompt(tokenizer, req.Messages, req.Tools),
+	}
+}
+
+// estimateCompletionStreamUsage is estimateStreamUsage for a text
+// completion.
+func (r *Router) estimateCompletionStreamUsage(modelInfo models.Model, req models.UnifiedCompletionRequest, stream models.UnifiedChatStream) models.UnifiedChatStream {
+	tokenizer := r.Tokenizer(modelInfo.ID)
+	return &usageEstimatingStream{
+		UnifiedChatStream: stream,
+		tokenizer:         tokenizer,
+		prompt:            len(tokenizer.Encode(req.Prompt)),
+	}
+}
+
+func (s *usageEstimatingStream) Recv() (models.UnifiedChatChunk, error) {
+	c, err := s.UnifiedChatStream.Recv()
+	if errors.Is(err, io.EOF) && !s.reported {
+		s.reported = true
+		completion := len(s.tokenizer.Encode(s.answer.String()))
+		return models.UnifiedChatChunk{ID: s.id, Model: s.model, Usage: &models.Usage{
+			PromptTokens:     s.prompt,
+			CompletionTokens: completion,
+			TotalTokens:      s.prompt + completion,
+		}}, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if s.id == "" {
+		s.id, s.model = c.ID, c.Model
+	}
+	s.reported = s.reported || c.Usage != nil
+	s.answer.WriteString(c.Content)
+	for _, call := range c.ToolCalls {
+		s.answer.WriteString(call.Name)
+		s.answer.WriteString(call.Arguments)
+	}
+	return c, nil
+}
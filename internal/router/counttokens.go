@@ -0,0 +1,50 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+// CountTokens asks the upstream of a Claude-style model to count the input
+// tokens of body, a Claude messages request naming model. It reports false
+// when the model is a routing policy, is not Claude-style, has a system
+// prompt the upstream would not see, or its provider cannot count, so the
+// caller can estimate locally instead.
+func (r *Router) CountTokens(ctx context.Context, model string, body []byte, header http.Header) (int, models.Model, bool, error) {
+	if r.hasPolicy(model) {
+		return 0, models.Model{}, false, nil
+	}
+	modelInfo, providerImpl, err := r.lookupModel(model)
+	if err != nil {
+		return 0, models.Model{}, false, err
+	}
+	counter, ok := providerImpl.(provider.TokenCounter)
+	if !ok || modelInfo.APIStyle != "claude" || modelInfo.SystemPrompt != "" {
+		return 0, modelInfo, false, nil
+	}
+	if modelInfo.ID != model {
+		if body, err = provider.ReplaceModel(body, modelInfo.ID); err != nil {
+			return 0, modelInfo, false, fmt.Errorf("rewrite model of count_tokens request: %w", err)
+		}
+	}
+	if err := r.checkAvailable(modelInfo); err != nil {
+		return 0, modelInfo, false, nil
+	}
+
+	ctx, deadline := r.startDeadline(ctx, modelInfo)
+	defer deadline.stop()
+	count, err := counter.CountTokens(ctx, modelInfo.ID, body, header)
+	err = deadline.err(ctx, err)
+	if errors.Is(err, provider.ErrUnsupportedOperation) {
+		return 0, modelInfo, false, nil
+	}
+	if err != nil {
+		return 0, modelInfo, false, fmt.Errorf("provider %s count_tokens request: %w", providerImpl.Name(), err)
+	}
+	return count, modelInfo, true, nil
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+const (
+	degenerateEmpty      = "empty"
+	degenerateTruncated  = "truncated"
+	degenerateRepetition = "repetition"
+
+	// repetitionMinWords avoids flagging short answers, where repeated
+	// phrases are normal.
+	repetitionMinWords = 40
+	repetitionGram     = 4
+)
+
+// chatWithDegenerateRetry retries a degenerate response once, on the
+// configured fallback model or the same model.
+func (r *Router) chatWithDegenerateRetry(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	policy := r.routing.DegenerateRetry
+
+	resp, modelInfo, err := r.chatModel(ctx, req)
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+
+	reason := degenerateReason(policy, resp)
+	if reason == "" {
+		return resp, modelInfo, nil
+	}
+
+	retryModel := req.Model
+	if fallback, ok := policy.Fallbacks[req.Model]; ok {
+		retryModel = fallback
+	} else if fallback, ok := policy.Fallbacks[modelInfo.ID]; ok {
+		retryModel = fallback
+	}
+
+	slog.Warn("degenerate response, retrying", "model", modelInfo.ID, "reason", reason, "retry_model", retryModel)
+
+	retryReq := req
+	retryReq.Model = retryModel
+	retryReq.Options = cloneOptions(req.Options)
+
+	retryResp, retryInfo, err := r.chatModel(ctx, retryReq)
+	if err != nil {
+		slog.Warn("degenerate retry failed, returning original response", "retry_model", retryModel, "error", err)
+		resp.Annotate("retry", map[string]any{
+			"reason":         reason,
+			"original_model": modelInfo.ID,
+			"error":          err.Error(),
+		})
+		return resp, modelInfo, nil
+	}
+
+	retryResp.Usage = addUsage(retryResp.Usage, resp.Usage)
+	retryResp.Annotate("retry", map[string]any{
+		"reason":           reason,
+		"original_model":   modelInfo.ID,
+		"model":            retryInfo.ID,
+		"still_degenerate": degenerateReason(policy, retryResp) != "",
+	})
+	return retryResp, retryInfo, nil
+}
+
+// degenerateReason reports why a response is considered degenerate, or an
+// empty string when it looks fine.
+func degenerateReason(policy config.DegenerateRetryConfig, resp *models.UnifiedChatResponse) string {
+	content := strings.TrimSpace(resp.Message.Content)
+	if content == "" && len(resp.Message.ToolCalls) == 0 {
+		return degenerateEmpty
+	}
+	if policy.Truncated {
+		switch resp.FinishReason {
+		case "length", "max_tokens":
+			return degenerateTruncated
+		}
+	}
+	if policy.RepetitionThreshold > 0 && repetitionRatio(content) > policy.RepetitionThreshold {
+		return degenerateRepetition
+	}
+	return ""
+}
+
+// repetitionRatio returns the share of word n-grams that repeat an earlier
+// n-gram. Text stuck in a loop approaches 1.
+func repetitionRatio(content string) float64 {
+	words := strings.Fields(content)
+	if len(words) < repetitionMinWords {
+		return 0
+	}
+
+	total := len(words) - repetitionGram + 1
+	seen := make(map[string]struct{}, total)
+	repeated := 0
+	for i := 0; i < total; i++ {
+		gram := strings.Join(words[i:i+repetitionGram], " ")
+		if _, ok := seen[gram]; ok {
+			repeated++
+			continue
+		}
+		seen[gram] = struct{}{}
+	}
+	return float64(repeated) / float64(total)
+}
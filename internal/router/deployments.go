@@ -0,0 +1,81 @@
+package router
+
+import (
+	"slices"
+	"sync"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+// roundRobin keeps the smooth weighted round robin state of each model
+// served by several providers: every pick adds each deployment's weight
+// to its credit and takes the deployment with the most, which then pays
+// the total. Picks follow the weights exactly, interleaved.
+type roundRobin struct {
+	mu     sync.Mutex
+	credit map[string][]float64
+}
+
+func (rr *roundRobin) next(model string, weights []float64) int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if rr.credit == nil {
+		rr.credit = make(map[string][]float64)
+	}
+	credit := rr.credit[model]
+	if len(credit) != len(weights) {
+		credit = make([]float64, len(weights))
+		rr.credit[model] = credit
+	}
+
+	var total float64
+	best := -1
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		total += w
+		credit[i] += w
+		if best < 0 || credit[i] > credit[best] {
+			best = i
+		}
+	}
+	credit[best] -= total
+	return best
+}
+
+// lookupModel resolves a model to the provider serving this request. When
+// several providers serve the model, one is picked in proportion to their
+// weights, leaving out those health probes report down or that are rate
+// limited, unless all are.
+func (r *Router) lookupModel(model string) (models.Model, provider.Provider, error) {
+	deployments := r.registry.Deployments(model)
+	if len(deployments) == 0 {
+		return r.registry.LookupModel(model)
+	}
+
+	weights := make([]float64, len(deployments))
+	all := make([]float64, len(deployments))
+	for i, d := range deployments {
+		all[i] = d.Model.Weight
+		if all[i] <= 0 {
+			all[i] = 1
+		}
+		if !r.isDisabled(d.Model.ID) && !r.unavailable(d.Model.Provider) {
+			weights[i] = all[i]
+		}
+	}
+	if !slices.ContainsFunc(weights, func(w float64) bool { return w > 0 }) {
+		weights = all
+	}
+
+	var i int
+	if r.routing.DeploymentSelection == config.DeploymentSelectionRoundRobin {
+		i = r.roundRobin.next(deployments[0].Model.ID, weights)
+	} else {
+		i = weightedPick(weights)
+	}
+	return deployments[i].Model, deployments[i].Provider, nil
+}
@@ -0,0 +1,23 @@
+package router
+
+import "fmt"
+
+// DisabledError reports that a request was not sent because the model was
+// switched off at runtime.
+type DisabledError struct {
+	Model string
+}
+
+func (e *DisabledError) Error() string {
+	return fmt.Sprintf("model %s is disabled", e.Model)
+}
+
+// UseDisabled makes the router refuse the models disabled reports switched
+// off, and steer balancers and deployments away from them.
+func (r *Router) UseDisabled(disabled func(model string) bool) {
+	r.disabled = disabled
+}
+
+func (r *Router) isDisabled(model string) bool {
+	return r.disabled != nil && r.disabled(model)
+}
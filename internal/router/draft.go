@@ -0,0 +1,80 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+const defaultVerifyInstructions = "Review the draft answer above for correctness and completeness. " +
+	"Reply with the final answer only: return the draft unchanged if it is correct, otherwise return a corrected version."
+
+// draftVerifyChat lets the drafter answer first and has the verifier review
+// and edit that draft. If drafting fails the verifier answers on its own;
+// an empty draft was still paid for, so its usage is counted either way.
+func (r *Router) draftVerifyChat(ctx context.Context, name string, pair config.DraftVerifyConfig, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	draftReq := req
+	draftReq.Model = pair.Drafter
+	draftReq.Options = cloneOptions(req.Options)
+
+	draft, _, err := r.chatModel(ctx, draftReq)
+	if err != nil || draft == nil || strings.TrimSpace(draft.Message.Content) == "" {
+		slog.Warn("draft failed, sending request straight to verifier", "policy", name, "drafter", pair.Drafter, "error", err)
+
+		verifyReq := req
+		verifyReq.Model = pair.Verifier
+		resp, modelInfo, err := r.chatModel(ctx, verifyReq)
+		if err != nil {
+			return nil, models.Model{}, fmt.Errorf("draft_verify %s: %w", name, err)
+		}
+		if draft != nil {
+			resp.Usage = addUsage(resp.Usage, draft.Usage)
+		}
+		resp.Annotate("draft_verify", map[string]any{
+			"name":     name,
+			"drafter":  pair.Drafter,
+			"verifier": pair.Verifier,
+			"drafted":  false,
+		})
+		return resp, modelInfo, nil
+	}
+
+	instructions := pair.Instructions
+	if strings.TrimSpace(instructions) == "" {
+		instructions = defaultVerifyInstructions
+	}
+
+	messages := make([]models.Message, 0, len(req.Messages)+2)
+	messages = append(messages, req.Messages...)
+	messages = append(messages,
+		models.Message{Role: "assistant", Content: draft.Message.Content},
+		models.Message{Role: "user", Content: instructions},
+	)
+
+	verifyReq := req
+	verifyReq.Model = pair.Verifier
+	verifyReq.Messages = messages
+	verifyReq.Options = cloneOptions(req.Options)
+
+	resp, modelInfo, err := r.chatModel(ctx, verifyReq)
+	if err != nil {
+		return nil, models.Model{}, fmt.Errorf("draft_verify %s: verify: %w", name, err)
+	}
+
+	edited := normaliseAnswer(resp.Message.Content) != normaliseAnswer(draft.Message.Content)
+	slog.Info("draft verified", "policy", name, "drafter", pair.Drafter, "verifier", pair.Verifier, "edited", edited)
+
+	resp.Usage = addUsage(resp.Usage, draft.Usage)
+	resp.Annotate("draft_verify", map[string]any{
+		"name":     name,
+		"drafter":  pair.Drafter,
+		"verifier": pair.Verifier,
+		"drafted":  true,
+		"edited":   edited,
+	})
+	return resp, modelInfo, nil
+}
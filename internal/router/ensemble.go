@@ -0,0 +1,241 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+const judgeMaxTokens = 16
+
+type candidate struct {
+	model     string
+	resp      *models.UnifiedChatResponse
+	modelInfo models.Model
+	err       error
+	latency   time.Duration
+}
+
+// ensembleChat sends the request to every ensemble member in parallel and
+// returns the answer picked by the configured selection strategy.
+func (r *Router) ensembleChat(ctx context.Context, name string, ensemble config.EnsembleConfig, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	candidates := r.fanOut(ctx, ensemble.Models, req)
+
+	var succeeded []candidate
+	for _, cand := range candidates {
+		if cand.err != nil {
+			slog.Warn("ensemble candidate failed", "ensemble", name, "model", cand.model, "error", cand.err)
+			continue
+		}
+		slog.Info("ensemble candidate",
+			"ensemble", name,
+			"model", cand.model,
+			"latency_ms", cand.latency.Milliseconds(),
+			"finish_reason", cand.resp.FinishReason,
+			"content", cand.resp.Message.Content,
+		)
+		succeeded = append(succeeded, cand)
+	}
+	if len(succeeded) == 0 {
+		return nil, models.Model{}, fmt.Errorf("ensemble %s: all candidates failed: %w", name, candidates[0].err)
+	}
+
+	usage := sumUsage(succeeded)
+	var winner int
+	strategy := ensemble.SelectionStrategy()
+	switch strategy {
+	case config.EnsembleStrategyJudge:
+		index, judgeUsage, err := r.judge(ctx, ensemble.Judge, req, succeeded)
+		if err != nil {
+			slog.Warn("ensemble judge failed, falling back to longest answer", "ensemble", name, "judge", ensemble.Judge, "error", err)
+			strategy = config.EnsembleStrategyLongest
+			winner = longestCandidate(succeeded, false)
+		} else {
+			winner = index
+		}
+		usage = addUsage(usage, judgeUsage)
+	case config.EnsembleStrategyLongestJSON:
+		winner = longestCandidate(succeeded, true)
+	default:
+		winner = longestCandidate(succeeded, false)
+	}
+
+	selected := succeeded[winner]
+	slog.Info("ensemble selection", "ensemble", name, "strategy", strategy, "selected", selected.model)
+
+	resp := *selected.resp
+	resp.Usage = usage
+	resp.Metadata = nil
+	for k, v := range selected.resp.Metadata {
+		resp.Annotate(k, v)
+	}
+
+	memberModels := make([]string, 0, len(succeeded))
+	for _, cand := range succeeded {
+		memberModels = append(memberModels, cand.model)
+	}
+	resp.Annotate("ensemble", map[string]any{
+		"name":       name,
+		"strategy":   strategy,
+		"selected":   selected.model,
+		"candidates": memberModels,
+	})
+
+	return &resp, selected.modelInfo, nil
+}
+
+// fanOut issues the request to each model concurrently, preserving order.
+func (r *Router) fanOut(ctx context.Context, modelIDs []string, req models.UnifiedChatRequest) []candidate {
+	candidates := make([]candidate, len(modelIDs))
+
+	var wg sync.WaitGroup
+	for i, modelID := range modelIDs {
+		wg.Add(1)
+		go func(i int, modelID string) {
+			defer wg.Done()
+
+			memberReq := req
+			memberReq.Model = modelID
+			memberReq.Options = cloneOptions(req.Options)
+
+			start := time.Now()
+			resp, modelInfo, err := r.chatModel(ctx, memberReq)
+			if err == nil && resp == nil {
+				err = errors.New("empty response")
+			}
+			candidates[i] = candidate{
+				model:     modelID,
+				resp:      resp,
+				modelInfo: modelInfo,
+				err:       err,
+				latency:   time.Since(start),
+			}
+		}(i, modelID)
+	}
+	wg.Wait()
+
+	return candidates
+}
+
+// judge asks the judge model which candidate best answers the conversation.
+func (r *Router) judge(ctx context.Context, judgeModel string, req models.UnifiedChatRequest, candidates []candidate) (int, models.Usage, error) {
+	var prompt strings.Builder
+	prompt.WriteString("You are judging candidate answers to the conversation below. ")
+	prompt.WriteString("Reply with only the number of the best candidate.\n\n")
+	prompt.WriteString("Conversation:\n")
+	for _, msg := range req.Messages {
+		fmt.Fprintf(&prompt, "[%s] %s\n", msg.Role, msg.Content)
+	}
+	for i, cand := range candidates {
+		fmt.Fprintf(&prompt, "\nCandidate %d:\n%s\n", i+1, answerText(cand.resp.Message))
+	}
+
+	resp, _, err := r.chatModel(ctx, models.UnifiedChatRequest{
+		Model: judgeModel,
+		Messages: []models.Message{
+			{Role: "user", Content: prompt.String()},
+		},
+		Options: map[string]any{"max_tokens": judgeMaxTokens, "temperature": 0.0},
+	})
+	if err != nil {
+		return 0, models.Usage{}, err
+	}
+	if resp == nil {
+		return 0, models.Usage{}, errors.New("judge returned an empty response")
+	}
+
+	choice, err := parseJudgeChoice(resp.Message.Content, len(candidates))
+	if err != nil {
+		return 0, resp.Usage, err
+	}
+	return choice, resp.Usage, nil
+}
+
+func parseJudgeChoice(text string, count int) (int, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool { return r < '0' || r > '9' })
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err == nil && n >= 1 && n <= count {
+			return n - 1, nil
+		}
+	}
+	return 0, fmt.Errorf("judge reply %q did not name a candidate", strings.TrimSpace(text))
+}
+
+// longestCandidate returns the index of the longest answer, tool calls
+// included. When jsonOnly is set, answers that parse as JSON are preferred
+// over those that do not.
+func longestCandidate(candidates []candidate, jsonOnly bool) int {
+	best, bestLen := -1, -1
+	for i, cand := range candidates {
+		content := strings.TrimSpace(cand.resp.Message.Content)
+		if jsonOnly && !json.Valid([]byte(stripCodeFence(content))) {
+			continue
+		}
+		if n := len(answerText(cand.resp.Message)); n > bestLen {
+			best, bestLen = i, n
+		}
+	}
+	if best < 0 {
+		return longestCandidate(candidates, false)
+	}
+	return best
+}
+
+// answerText renders an answer for comparison: its text followed by its
+// tool calls, one per line with canonical JSON arguments, so answers that
+// only call tools are neither empty nor alike.
+func answerText(msg models.Message) string {
+	var b strings.Builder
+	b.WriteString(strings.TrimSpace(msg.Content))
+	for _, call := range msg.ToolCalls {
+		arguments := call.Arguments
+		var parsed any
+		if err := json.Unmarshal([]byte(arguments), &parsed); err == nil {
+			if canonical, err := json.Marshal(parsed); err == nil {
+				arguments = string(canonical)
+			}
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%s(%s)", call.Name, arguments)
+	}
+	return b.String()
+}
+
+func stripCodeFence(content string) string {
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```")
+	if newline := strings.IndexByte(content, '\n'); newline >= 0 {
+		content = content[newline+1:]
+	}
+	return strings.TrimSuffix(strings.TrimSpace(content), "```")
+}
+
+func sumUsage(candidates []candidate) models.Usage {
+	var total models.Usage
+	for _, cand := range candidates {
+		total = addUsage(total, cand.resp.Usage)
+	}
+	return total
+}
+
+func addUsage(a, b models.Usage) models.Usage {
+	return models.Usage{
+		PromptTokens:     a.PromptTokens + b.PromptTokens,
+		CompletionTokens: a.CompletionTokens + b.CompletionTokens,
+		TotalTokens:      a.TotalTokens + b.TotalTokens,
+	}
+}
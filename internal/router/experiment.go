@@ -0,0 +1,123 @@
+package router
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+// Assignment is the arm of an experiment a request was routed to.
+type Assignment struct {
+	Experiment string
+	Arm        string
+	Model      string
+}
+
+// assignments collects the experiment arms chosen while routing a request.
+type assignments struct {
+	mu   sync.Mutex
+	list []Assignment
+}
+
+type assignmentsKey struct{}
+
+// WithAssignments returns a context that records the experiment arms the
+// request is routed to, for AssignmentsFrom.
+func WithAssignments(ctx context.Context) context.Context {
+	return context.WithValue(ctx, assignmentsKey{}, &assignments{})
+}
+
+// AssignmentsFrom returns the experiment arms recorded so far for the
+// request ctx belongs to.
+func AssignmentsFrom(ctx context.Context) []Assignment {
+	a, _ := ctx.Value(assignmentsKey{}).(*assignments)
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Assignment(nil), a.list...)
+}
+
+// withoutAssignments stops calls made with ctx from recording arms on the
+// request it belongs to.
+func withoutAssignments(ctx context.Context) context.Context {
+	return context.WithValue(ctx, assignmentsKey{}, (*assignments)(nil))
+}
+
+func recordAssignment(ctx context.Context, assignment Assignment) {
+	a, _ := ctx.Value(assignmentsKey{}).(*assignments)
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.list = append(a.list, assignment)
+	a.mu.Unlock()
+}
+
+// IsExperiment reports whether name is an experiment.
+func (r *Router) IsExperiment(name string) bool {
+	_, ok := r.routing.Experiments[name]
+	return ok
+}
+
+// experimentArm picks the arm of the experiment for a request. The subject
+// and the experiment name are hashed into one of 10,000 buckets, so a
+// subject stays in its arm for as long as the percentages do not change
+// and is bucketed independently in each experiment.
+func experimentArm(name string, experiment config.ExperimentConfig, subject string) config.ExperimentArm {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(subject))
+	point := float64(h.Sum64()%10000) / 100
+	for _, arm := range experiment.Arms {
+		if point < arm.Percent {
+			return arm
+		}
+		point -= arm.Percent
+	}
+	return experiment.Arms[len(experiment.Arms)-1]
+}
+
+// experimentSubject returns what a request is bucketed by: its user field
+// or, for key bucketing and requests without one, the client key ID.
+func experimentSubject(ctx context.Context, experiment config.ExperimentConfig, options map[string]any) string {
+	if experiment.Bucket() == config.ExperimentBucketUser {
+		if user, ok := options["user"].(string); ok && user != "" {
+			return "user:" + user
+		}
+	}
+	return "key:" + keyIDFrom(ctx)
+}
+
+// assignArm picks and records the experiment arm for a request.
+func assignArm(ctx context.Context, name string, experiment config.ExperimentConfig, options map[string]any) Assignment {
+	arm := experimentArm(name, experiment, experimentSubject(ctx, experiment, options))
+	assignment := Assignment{Experiment: name, Arm: arm.Name, Model: arm.Model}
+	recordAssignment(ctx, assignment)
+	return assignment
+}
+
+func (a Assignment) annotation() map[string]any {
+	return map[string]any{
+		"name":  a.Experiment,
+		"arm":   a.Arm,
+		"model": a.Model,
+	}
+}
+
+// experimentChat sends the request to the model of the arm it falls in.
+func (r *Router) experimentChat(ctx context.Context, name string, experiment config.ExperimentConfig, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	assignment := assignArm(ctx, name, experiment, req.Options)
+	req.Model = assignment.Model
+	resp, modelInfo, err := r.dispatchChat(ctx, req)
+	if err != nil || resp == nil {
+		return resp, modelInfo, err
+	}
+	resp.Annotate("experiment", assignment.annotation())
+	return resp, modelInfo, nil
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"gocode-router/internal/chaos"
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+// fallbackChat sends the request to the model it names and, while the
+// answer is a failure another model may not share, to each model of the
+// chain in turn. The response is annotated with the models that failed.
+func (r *Router) fallbackChat(ctx context.Context, req models.UnifiedChatRequest, chain []string) (*models.UnifiedChatResponse, models.Model, error) {
+	primary := req.Model
+	var failed []map[string]any
+	var err error
+	for _, model := range append([]string{primary}, chain...) {
+		req.Model = model
+		var resp *models.UnifiedChatResponse
+		var modelInfo models.Model
+		resp, modelInfo, err = r.modelChat(ctx, req)
+		if err == nil {
+			if len(failed) > 0 && resp != nil {
+				resp.Annotate("fallback", map[string]any{
+					"model":  primary,
+					"served": modelInfo.ID,
+					"failed": failed,
+				})
+			}
+			return resp, modelInfo, nil
+		}
+		if !shouldFallBack(ctx, err) {
+			return nil, models.Model{}, err
+		}
+		slog.Warn("model failed, falling back", "model", model, "primary", primary, "error", err)
+		failed = append(failed, map[string]any{"model": model, "error": err.Error()})
+	}
+	return nil, models.Model{}, err
+}
+
+// fallbackChatStream is fallbackChat for streams. Only failures before the
+// stream starts fall back.
+func (r *Router) fallbackChatStream(ctx context.Context, req models.UnifiedChatRequest, chain []string) (models.UnifiedChatStream, models.Model, error) {
+	primary := req.Model
+	var err error
+	for _, model := range append([]string{primary}, chain...) {
+		req.Model = model
+		var stream models.UnifiedChatStream
+		var modelInfo models.Model
+		stream, modelInfo, err = r.chatModelStream(ctx, req)
+		if err == nil {
+			return stream, modelInfo, nil
+		}
+		if !shouldFallBack(ctx, err) {
+			return nil, models.Model{}, err
+		}
+		slog.Warn("model failed, falling back", "model", model, "primary", primary, "error", err)
+	}
+	return nil, models.Model{}, err
+}
+
+// shouldFallBack reports whether a failed request is worth sending to
+// another model: the upstream was rate limited, failed or timed out, or
+// the router held the request back for the model's sake. Nothing falls
+// back once the client has gone.
+func shouldFallBack(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var status *provider.StatusError
+	if errors.As(err, &status) {
+		return retryableStatus(status.StatusCode)
+	}
+	var injected *chaos.Error
+	if errors.As(err, &injected) {
+		return retryableStatus(injected.Status)
+	}
+	var down *UnavailableError
+	var busy *ConcurrencyError
+	var limited *RateLimitedError
+	var tooLong *ContextLengthError
+	var timedOut *TimeoutError
+	var disabled *DisabledError
+	if errors.As(err, &down) || errors.As(err, &busy) || errors.As(err, &limited) || errors.As(err, &tooLong) ||
+		errors.As(err, &timedOut) || errors.As(err, &disabled) {
+		return true
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
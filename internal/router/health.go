@@ -0,0 +1,76 @@
+package router
+
+import (
+	"fmt"
+	"time"
+
+	"gocode-router/internal/health"
+	"gocode-router/internal/models"
+)
+
+// UnavailableError reports that a request was not sent because background
+// probes found the model's provider down.
+type UnavailableError struct {
+	Provider string
+	Model    string
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("provider %s serving model %s is down according to health probes", e.Provider, e.Model)
+}
+
+// RateLimitedError reports that a request was not sent because the model's
+// provider reported its rate-limit budget spent until Until.
+type RateLimitedError struct {
+	Provider string
+	Model    string
+	Until    time.Time
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("provider %s serving model %s reported its rate limit reached until %s", e.Provider, e.Model, e.Until.UTC().Format(time.RFC3339))
+}
+
+// UseHealth makes the router fail fast on models whose provider the tracker
+// reports down, and steer balancers away from them.
+func (r *Router) UseHealth(tracker *health.Tracker) {
+	r.health = tracker
+}
+
+// checkAvailable fails when the model was disabled, or its provider is
+// known to be down or to have spent its rate-limit budget.
+func (r *Router) checkAvailable(modelInfo models.Model) error {
+	if r.isDisabled(modelInfo.ID) {
+		return &DisabledError{Model: modelInfo.ID}
+	}
+	if r.health.Down(modelInfo.Provider) {
+		return &UnavailableError{Provider: modelInfo.Provider, Model: modelInfo.ID}
+	}
+	if until, limited := r.registry.RateLimits().Limited(modelInfo.Provider, time.Now()); limited {
+		return &RateLimitedError{Provider: modelInfo.Provider, Model: modelInfo.ID, Until: until}
+	}
+	return nil
+}
+
+// RateLimitedUntil reports until when the provider has spent its
+// rate-limit budget, if it has.
+func (r *Router) RateLimitedUntil(provider string) (time.Time, bool) {
+	return r.registry.RateLimits().Limited(provider, time.Now())
+}
+
+// unavailable reports whether a provider is known to be down or to have
+// spent its rate-limit budget.
+func (r *Router) unavailable(provider string) bool {
+	if r.health.Down(provider) {
+		return true
+	}
+	_, limited := r.registry.RateLimits().Limited(provider, time.Now())
+	return limited
+}
+
+// available reports whether a model is enabled and resolves to a provider
+// that is not known to be unavailable.
+func (r *Router) available(model string) bool {
+	modelInfo, _, err := r.registry.LookupModel(model)
+	return err != nil || (!r.isDisabled(modelInfo.ID) && !r.unavailable(modelInfo.Provider))
+}
@@ -0,0 +1,217 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+// hedgeLeg is the outcome of one of the calls of a hedged request.
+type hedgeLeg[T any] struct {
+	value     T
+	modelInfo models.Model
+	err       error
+	hedged    bool
+}
+
+// hedge makes call for the primary model and, once it has gone after
+// without answering or has failed in a way the hedge model may not share,
+// for the hedge model too. The first call to succeed wins: the other is
+// cancelled and whatever it still returns is passed to discard. The
+// returned cancel ends the winner's context, and must be called once its
+// value is no longer in use. When every call fails the primary's error is
+// returned.
+func hedge[T any](ctx context.Context, after time.Duration, call func(ctx context.Context, hedged bool) (T, models.Model, error), discard func(T)) (hedgeLeg[T], bool, context.CancelFunc) {
+	results := make(chan hedgeLeg[T], 2)
+	var cancels [2]context.CancelFunc
+	start := func(hedged bool) {
+		legCtx, cancel := context.WithCancel(ctx)
+		if hedged {
+			cancels[1] = cancel
+		} else {
+			cancels[0] = cancel
+		}
+		go func() {
+			value, modelInfo, err := call(legCtx, hedged)
+			results <- hedgeLeg[T]{value: value, modelInfo: modelInfo, err: err, hedged: hedged}
+		}()
+	}
+	// settle cancels every call but the winner's and discards the answers
+	// still to come.
+	settle := func(winner hedgeLeg[T], running int) (hedgeLeg[T], bool, context.CancelFunc) {
+		keep := func() {}
+		for i, cancel := range cancels {
+			switch {
+			case cancel == nil:
+			case winner.err == nil && (i == 1) == winner.hedged:
+				keep = cancel
+			default:
+				cancel()
+			}
+		}
+		if running > 0 {
+			go func() {
+				for range running {
+					if loser := <-results; loser.err == nil {
+						discard(loser.value)
+					}
+				}
+			}()
+		}
+		return winner, cancels[1] != nil, keep
+	}
+
+	start(false)
+	timer := time.NewTimer(after)
+	defer timer.Stop()
+	running := 1
+	var primary *hedgeLeg[T]
+	for {
+		select {
+		case <-timer.C:
+			if cancels[1] == nil {
+				start(true)
+				running++
+			}
+		case leg := <-results:
+			running--
+			if leg.err == nil {
+				return settle(leg, running)
+			}
+			if !leg.hedged {
+				primary = &leg
+				if !shouldFallBack(ctx, leg.err) {
+					return settle(leg, running)
+				}
+				if cancels[1] == nil {
+					start(true)
+					running++
+				}
+			}
+			if running == 0 {
+				if primary == nil {
+					primary = &leg
+				}
+				return settle(*primary, 0)
+			}
+		}
+	}
+}
+
+// hedgedChat sends the request to its model and, if that is slow to
+// answer, to the hedge model too, returning the first answer.
+func (r *Router) hedgedChat(ctx context.Context, req models.UnifiedChatRequest, cfg config.HedgeConfig) (*models.UnifiedChatResponse, models.Model, error) {
+	primary := req.Model
+	leg, hedged, cancel := hedge(ctx, cfg.Delay(), func(ctx context.Context, hedged bool) (*models.UnifiedChatResponse, models.Model, error) {
+		req := req
+		if hedged {
+			req.Model = cfg.Model
+			return r.modelChat(ctx, req)
+		}
+		if chain, ok := r.routing.Fallbacks[req.Model]; ok {
+			return r.fallbackChat(ctx, req, chain)
+		}
+		return r.modelChat(ctx, req)
+	}, func(*models.UnifiedChatResponse) {})
+	cancel()
+	if leg.err != nil {
+		return nil, models.Model{}, leg.err
+	}
+	if hedged && leg.value != nil {
+		leg.value.Annotate("hedge", hedgeAnnotation(primary, cfg, leg))
+	}
+	return leg.value, leg.modelInfo, nil
+}
+
+// hedgedCompletion is hedgedChat for text completions.
+func (r *Router) hedgedCompletion(ctx context.Context, req models.UnifiedCompletionRequest, cfg config.HedgeConfig) (*models.UnifiedCompletionResponse, models.Model, error) {
+	primary := req.Model
+	leg, hedged, cancel := hedge(ctx, cfg.Delay(), func(ctx context.Context, hedged bool) (*models.UnifiedCompletionResponse, models.Model, error) {
+		req := req
+		if hedged {
+			req.Model = cfg.Model
+		}
+		return r.completionModel(ctx, req)
+	}, func(*models.UnifiedCompletionResponse) {})
+	cancel()
+	if leg.err != nil {
+		return nil, models.Model{}, leg.err
+	}
+	if hedged && leg.value != nil {
+		leg.value.Annotate("hedge", hedgeAnnotation(primary, cfg, leg))
+	}
+	return leg.value, leg.modelInfo, nil
+}
+
+func hedgeAnnotation[T any](primary string, cfg config.HedgeConfig, leg hedgeLeg[T]) map[string]any {
+	return map[string]any{
+		"model":    primary,
+		"hedge":    cfg.Model,
+		"after_ms": cfg.Delay().Milliseconds(),
+		"served":   leg.modelInfo.ID,
+	}
+}
+
+// hedgedChatStream is hedgedChat for streams: a model has answered once
+// its first chunk arrives.
+func (r *Router) hedgedChatStream(ctx context.Context, req models.UnifiedChatRequest, cfg config.HedgeConfig) (models.UnifiedChatStream, models.Model, error) {
+	leg, _, cancel := hedge(ctx, cfg.Delay(), func(ctx context.Context, hedged bool) (*primedStream, models.Model, error) {
+		req := req
+		var stream models.UnifiedChatStream
+		var modelInfo models.Model
+		var err error
+		if chain, ok := r.routing.Fallbacks[req.Model]; ok && !hedged {
+			stream, modelInfo, err = r.fallbackChatStream(ctx, req, chain)
+		} else {
+			if hedged {
+				req.Model = cfg.Model
+			}
+			stream, modelInfo, err = r.chatModelStream(ctx, req)
+		}
+		if err != nil {
+			return nil, models.Model{}, err
+		}
+		chunk, err := stream.Recv()
+		if err != nil && !errors.Is(err, io.EOF) {
+			stream.Close()
+			return nil, models.Model{}, err
+		}
+		return &primedStream{UnifiedChatStream: stream, first: chunk, firstErr: err}, modelInfo, nil
+	}, func(s *primedStream) { s.Close() })
+	if leg.err != nil {
+		cancel()
+		return nil, models.Model{}, leg.err
+	}
+	leg.value.cancel = cancel
+	return leg.value, leg.modelInfo, nil
+}
+
+// primedStream replays the chunk already read from a stream before the
+// rest, and ends the stream's context when it is closed.
+type primedStream struct {
+	models.UnifiedChatStream
+	first    models.UnifiedChatChunk
+	firstErr error
+	primed   bool
+	cancel   context.CancelFunc
+}
+
+func (s *primedStream) Recv() (models.UnifiedChatChunk, error) {
+	if !s.primed {
+		s.primed = true
+		return s.first, s.firstErr
+	}
+	return s.UnifiedChatStream.Recv()
+}
+
+func (s *primedStream) Close() error {
+	err := s.UnifiedChatStream.Close()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return err
+}
@@ -0,0 +1,226 @@
+package router
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/expr"
+	"gocode-router/internal/models"
+)
+
+type keyIDContextKey struct{}
+
+// WithKeyID records the client key ID (see usage.KeyID) a request was made
+// with, so hooks can match on it.
+func WithKeyID(ctx context.Context, keyID string) context.Context {
+	return context.WithValue(ctx, keyIDContextKey{}, keyID)
+}
+
+func keyIDFrom(ctx context.Context) string {
+	keyID, _ := ctx.Value(keyIDContextKey{}).(string)
+	return keyID
+}
+
+// hook is a compiled routing.hooks entry.
+type hook struct {
+	cfg          config.HookConfig
+	when         *expr.Program
+	responseWhen *expr.Program
+	replace      []*regexp.Regexp
+}
+
+// compileHooks compiles the configured hooks. Configuration validation has
+// already compiled them once, so a failure here only drops the hook.
+func compileHooks(cfgs []config.HookConfig) []hook {
+	hooks := make([]hook, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		h, err := compileHook(cfg)
+		if err != nil {
+			slog.Error("skipping invalid hook", "hook", cfg.Name, "error", err)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks
+}
+
+func compileHook(cfg config.HookConfig) (hook, error) {
+	h := hook{cfg: cfg}
+	var err error
+	if strings.TrimSpace(cfg.When) != "" {
+		if h.when, err = expr.Compile(cfg.When); err != nil {
+			return hook{}, err
+		}
+	}
+	if strings.TrimSpace(cfg.Response.When) != "" {
+		if h.responseWhen, err = expr.Compile(cfg.Response.When); err != nil {
+			return hook{}, err
+		}
+	}
+	for _, r := range cfg.Response.Replace {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return hook{}, err
+		}
+		h.replace = append(h.replace, re)
+	}
+	return h, nil
+}
+
+// matches evaluates a hook condition. A condition that fails to evaluate
+// is logged and treated as not matching.
+func (h hook) matches(program *expr.Program, vars map[string]any) bool {
+	if program == nil {
+		return true
+	}
+	ok, err := program.Bool(vars)
+	if err != nil {
+		slog.Warn("hook condition failed", "hook", h.cfg.Name, "error", err)
+		return false
+	}
+	return ok
+}
+
+// hookRun tracks the hooks that matched a request so their response
+// actions can run once the upstream answers.
+type hookRun struct {
+	matched []hook
+	vars    map[string]any
+}
+
+// requestVars exposes a request to hook conditions.
+func requestVars(ctx context.Context, kind, model, prompt string, stream bool, options map[string]any) map[string]any {
+	return map[string]any{
+		"kind":    kind,
+		"model":   model,
+		"key":     keyIDFrom(ctx),
+		"stream":  stream,
+		"prompt":  prompt,
+		"options": options,
+	}
+}
+
+func (r *Router) matchHooks(vars map[string]any) *hookRun {
+	if len(r.hooks) == 0 {
+		return nil
+	}
+	run := &hookRun{vars: vars}
+	for _, h := range r.hooks {
+		if h.matches(h.when, vars) {
+			run.matched = append(run.matched, h)
+		}
+	}
+	return run
+}
+
+// applyRequest applies the request actions of a hook to the model and
+// options, returning the updated values.
+func (h hook) applyRequest(model string, options map[string]any) (string, map[string]any) {
+	if m := strings.TrimSpace(h.cfg.Request.Model); m != "" {
+		model = m
+	}
+	if len(h.cfg.Request.Set) > 0 || len(h.cfg.Request.Unset) > 0 {
+		options = cloneOptions(options)
+		if options == nil {
+			options = make(map[string]any, len(h.cfg.Request.Set))
+		}
+		for _, name := range h.cfg.Request.Unset {
+			delete(options, name)
+		}
+		for name, value := range h.cfg.Request.Set {
+			options[name] = value
+		}
+	}
+	return model, options
+}
+
+// hookChatRequest applies request hooks to a chat request.
+func (r *Router) hookChatRequest(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatRequest, *hookRun) {
+	var prompt string
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			prompt = req.Messages[i].Content
+			break
+		}
+	}
+	run := r.matchHooks(requestVars(ctx, "chat", req.Model, prompt, req.Stream, req.Options))
+	if run == nil {
+		return req, nil
+	}
+	for _, h := range run.matched {
+		req.Model, req.Options = h.applyRequest(req.Model, req.Options)
+		if system := h.cfg.Request.System; system != "" {
+			req.Messages = append([]models.Message{{Role: "system", Content: system}}, req.Messages...)
+		}
+	}
+	return req, run
+}
+
+// hookCompletionRequest applies request hooks to a completion request. The
+// max_tokens and temperature options also update their dedicated fields.
+func (r *Router) hookCompletionRequest(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedCompletionRequest, *hookRun) {
+	run := r.matchHooks(requestVars(ctx, "completion", req.Model, req.Prompt, req.Stream, req.Options))
+	if run == nil {
+		return req, nil
+	}
+	for _, h := range run.matched {
+		req.Model, req.Options = h.applyRequest(req.Model, req.Options)
+	}
+	if v, ok := maxTokensOption(req.Options); ok {
+		req.MaxTokens = v
+	}
+	switch v := req.Options["temperature"].(type) {
+	case float64:
+		req.Temperature = v
+	case int:
+		req.Temperature = float64(v)
+	}
+	return req, run
+}
+
+// responseText applies the response actions of the matched hooks to text.
+// rewritesResponse reports whether a matched hook may change the response,
+// which it can only do once the response is complete.
+func (run *hookRun) rewritesResponse() bool {
+	if run == nil {
+		return false
+	}
+	for _, h := range run.matched {
+		response := h.cfg.Response
+		if len(response.Replace) > 0 || response.Prepend != "" || response.Append != "" || len(response.Annotate) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (run *hookRun) responseText(text, finishReason string, modelInfo models.Model, annotate func(key string, value any)) string {
+	if run == nil || len(run.matched) == 0 {
+		return text
+	}
+	vars := make(map[string]any, len(run.vars)+4)
+	for k, v := range run.vars {
+		vars[k] = v
+	}
+	vars["provider"] = modelInfo.Provider
+	vars["served_model"] = modelInfo.ID
+	vars["finish_reason"] = finishReason
+
+	for _, h := range run.matched {
+		vars["content"] = text
+		if !h.matches(h.responseWhen, vars) {
+			continue
+		}
+		for i, re := range h.replace {
+			text = re.ReplaceAllString(text, h.cfg.Response.Replace[i].With)
+		}
+		text = h.cfg.Response.Prepend + text + h.cfg.Response.Append
+		for key, value := range h.cfg.Response.Annotate {
+			annotate(key, value)
+		}
+	}
+	return text
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+// mirrorTimeout bounds a mirrored call that its provider's own timeout
+// does not.
+const mirrorTimeout = 5 * time.Minute
+
+// MirrorResult is the outcome of a mirrored call. Exactly one of Chat and
+// Completion is set when Err is nil.
+type MirrorResult struct {
+	// Model is the model the client asked for; Served is the one that
+	// answered the mirrored call.
+	Model   string
+	Served  models.Model
+	Latency time.Duration
+	Err     error
+
+	ChatRequest       models.UnifiedChatRequest
+	Chat              *models.UnifiedChatResponse
+	CompletionRequest models.UnifiedCompletionRequest
+	Completion        *models.UnifiedCompletionResponse
+}
+
+type mirrorKey struct{}
+
+// UseMirror hands the outcome of every mirrored call to observe, which
+// runs on the mirroring goroutine with the original request's context.
+func (r *Router) UseMirror(observe func(ctx context.Context, result MirrorResult)) {
+	r.mirrorObserver = observe
+}
+
+// mirrorSlots counts the mirrored calls in flight for each model.
+type mirrorSlots struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func (m *mirrorSlots) acquire(model string, limit int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.inFlight[model] >= limit {
+		return false
+	}
+	if m.inFlight == nil {
+		m.inFlight = make(map[string]int)
+	}
+	m.inFlight[model]++
+	return true
+}
+
+func (m *mirrorSlots) release(model string) {
+	m.mu.Lock()
+	m.inFlight[model]--
+	m.mu.Unlock()
+}
+
+// mirrorFor returns the mirror policy for a request to model, if this one
+// is to be mirrored. Mirrored calls are never mirrored again.
+func (r *Router) mirrorFor(ctx context.Context, model string) (config.MirrorConfig, bool) {
+	mirror, ok := r.routing.Mirrors[model]
+	if !ok || ctx.Value(mirrorKey{}) != nil {
+		return config.MirrorConfig{}, false
+	}
+	if rand.Float64() >= mirror.Rate() {
+		return config.MirrorConfig{}, false
+	}
+	if !r.mirrors.acquire(model, mirror.Limit()) {
+		return config.MirrorConfig{}, false
+	}
+	return mirror, true
+}
+
+// mirrorContext detaches a mirrored call from the client's request, so it
+// outlives the response, while keeping its values. Experiment arms the
+// mirror is routed to are not recorded on the request.
+func mirrorContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(withoutAssignments(context.WithoutCancel(ctx)), mirrorKey{}, true)
+	return context.WithTimeout(ctx, mirrorTimeout)
+}
+
+// mirrorChat sends a copy of req to the model's mirror in the background.
+func (r *Router) mirrorChat(ctx context.Context, req models.UnifiedChatRequest) {
+	mirror, ok := r.mirrorFor(ctx, req.Model)
+	if !ok {
+		return
+	}
+	model := req.Model
+	req.Model = mirror.MirrorTo
+	req.Stream = false
+	req.Messages = slices.Clone(req.Messages)
+	req.Tools = slices.Clone(req.Tools)
+	req.Options = cloneOptions(req.Options)
+	go func() {
+		defer r.mirrors.release(model)
+		ctx, cancel := mirrorContext(ctx)
+		defer cancel()
+		start := time.Now()
+		resp, modelInfo, err := r.Chat(ctx, req)
+		r.observeMirror(ctx, MirrorResult{
+			Model:       model,
+			Served:      modelInfo,
+			Latency:     time.Since(start),
+			Err:         err,
+			ChatRequest: req,
+			Chat:        resp,
+		})
+	}()
+}
+
+// mirrorCompletion sends a copy of req to the model's mirror in the
+// background.
+func (r *Router) mirrorCompletion(ctx context.Context, req models.UnifiedCompletionRequest) {
+	mirror, ok := r.mirrorFor(ctx, req.Model)
+	if !ok {
+		return
+	}
+	model := req.Model
+	req.Model = mirror.MirrorTo
+	req.Stream = false
+	req.Options = cloneOptions(req.Options)
+	go func() {
+		defer r.mirrors.release(model)
+		ctx, cancel := mirrorContext(ctx)
+		defer cancel()
+		start := time.Now()
+		resp, modelInfo, err := r.Completion(ctx, req)
+		r.observeMirror(ctx, MirrorResult{
+			Model:             model,
+			Served:            modelInfo,
+			Latency:           time.Since(start),
+			Err:               err,
+			CompletionRequest: req,
+			Completion:        resp,
+		})
+	}()
+}
+
+func (r *Router) observeMirror(ctx context.Context, result MirrorResult) {
+	if r.mirrorObserver != nil {
+		r.mirrorObserver(ctx, result)
+	}
+}
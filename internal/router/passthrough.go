@@ -0,0 +1,141 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+// Passthrough forwards a request made in apiStyle to the model it names
+// without translating it, when that model has passthrough enabled and its
+// provider can forward requests. It reports false, and sends nothing, when
+// the request should be translated as usual. Hooks, plugins and routing
+// policies such as fallbacks do not apply to forwarded requests; the
+// model's health, faults and concurrency limit do.
+func (r *Router) Passthrough(ctx context.Context, apiStyle string, req provider.PassthroughRequest) (*http.Response, models.Model, bool, error) {
+	if r.hasPolicy(req.Model) {
+		return nil, models.Model{}, false, nil
+	}
+	modelInfo, providerImpl, err := r.lookupModel(req.Model)
+	// A system prompt and option defaults are applied to the translated
+	// request.
+	if err != nil || !modelInfo.Passthrough || modelInfo.APIStyle != apiStyle || modelInfo.SystemPrompt != "" || len(modelInfo.DefaultOptions) > 0 || len(modelInfo.OverrideOptions) > 0 {
+		return nil, models.Model{}, false, nil
+	}
+	forwarder, ok := providerImpl.(provider.Passthrougher)
+	if !ok {
+		return nil, models.Model{}, false, nil
+	}
+
+	if modelInfo.ID != req.Model {
+		if req.Body, err = provider.ReplaceModel(req.Body, modelInfo.ID); err != nil {
+			return nil, models.Model{}, true, fmt.Errorf("rewrite model of passthrough request: %w", err)
+		}
+		req.Model = modelInfo.ID
+	}
+	// Output caps hold for forwarded requests too: the client's and the
+	// model's, whichever is lower.
+	limit := req.MaxTokens
+	if modelLimit := modelInfo.MaxOutputTokens; modelLimit > 0 && (limit <= 0 || modelLimit < limit) {
+		limit = modelLimit
+	}
+	if req.Body, err = provider.CapMaxTokens(req.Body, limit); err != nil {
+		return nil, models.Model{}, true, fmt.Errorf("cap max_tokens of passthrough request: %w", err)
+	}
+
+	if err := r.checkAvailable(modelInfo); err != nil {
+		return nil, models.Model{}, true, err
+	}
+	if err := r.injectFaults(ctx, modelInfo); err != nil {
+		return nil, models.Model{}, true, err
+	}
+	release, err := r.acquireModel(ctx, modelInfo)
+	if err != nil {
+		return nil, models.Model{}, true, err
+	}
+
+	ctx, deadline := r.startDeadline(ctx, modelInfo)
+	resp, err := forwarder.Passthrough(ctx, req)
+	if err != nil {
+		deadline.stop()
+		release()
+		return nil, models.Model{}, true, fmt.Errorf("provider %s passthrough request: %w", providerImpl.Name(), deadline.err(ctx, err))
+	}
+	// The model's concurrency slot is held until the response is read.
+	resp.Body = &releasingBody{
+		ReadCloser: &timedBody{ReadCloser: resp.Body, ctx: ctx, deadline: deadline, stream: req.Stream},
+		release:    release,
+	}
+	return resp, modelInfo, true, nil
+}
+
+// hasPolicy reports whether a routing policy is registered under a name.
+func (r *Router) hasPolicy(name string) bool {
+	if _, ok := r.routing.Experiments[name]; ok {
+		return true
+	}
+	if _, ok := r.schedules[name]; ok {
+		return true
+	}
+	if _, ok := r.balancers[name]; ok {
+		return true
+	}
+	if _, ok := r.routing.Ensembles[name]; ok {
+		return true
+	}
+	if _, ok := r.routing.Consensus[name]; ok {
+		return true
+	}
+	if _, ok := r.routing.DraftVerify[name]; ok {
+		return true
+	}
+	if _, ok := r.routing.Hedging[name]; ok {
+		return true
+	}
+	_, ok := r.routing.Fallbacks[name]
+	return ok
+}
+
+// releasingBody releases a concurrency slot when the body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// timedBody applies a deadline to a passthrough response. The body of a
+// stream restarts the deadline's clock whenever it delivers data.
+type timedBody struct {
+	io.ReadCloser
+	ctx      context.Context
+	deadline *deadline
+	stream   bool
+}
+
+func (b *timedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.stream {
+		b.deadline.kick()
+	}
+	if err != nil && err != io.EOF {
+		err = b.deadline.err(b.ctx, err)
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.deadline.stop()
+	return err
+}
@@ -0,0 +1,142 @@
+package router
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/plugin"
+)
+
+// Upstream call roles reported in a plan.
+const (
+	RoleModel    = "model"
+	RoleMember   = "member"
+	RoleJudge    = "judge"
+	RoleSample   = "sample"
+	RoleDrafter  = "drafter"
+	RoleVerifier = "verifier"
+)
+
+// PlannedCall is one upstream request a chat request would make.
+type PlannedCall struct {
+	Role  string
+	Model models.Model
+	// Messages are the messages sent, with the model's system prompt.
+	Messages []models.Message
+	// MaxOutputTokens is the output ceiling sent upstream, or zero when
+	// the request leaves it to the provider.
+	MaxOutputTokens int
+	// SeesAnswers marks calls whose prompt also carries other models'
+	// answers, which cannot be known in advance.
+	SeesAnswers bool
+}
+
+// Plan describes how a chat request would be routed.
+type Plan struct {
+	// Request is the request after hooks and plugins.
+	Request models.UnifiedChatRequest
+	// Policy names the routing policy applied, if any, and Schedule the
+	// schedule that picked the target. Experiment is the experiment arm
+	// the request falls in.
+	Policy     string
+	Schedule   string
+	Experiment *Assignment
+	Calls      []PlannedCall
+	Warnings   []string
+}
+
+// PlanChat runs the request hooks and plugins on req and resolves the
+// upstream calls routing it would make, without calling any provider.
+func (r *Router) PlanChat(ctx context.Context, req models.UnifiedChatRequest) (Plan, error) {
+	var plan Plan
+	req, _ = r.hookChatRequest(ctx, req)
+	out, _, err := r.pluginChatRequest(ctx, req)
+	var denied *plugin.DeniedError
+	switch {
+	case errors.As(err, &denied):
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("plugin %s would deny the request with status %d: %s", denied.Plugin, denied.Status, denied.Message))
+	case err != nil:
+		return Plan{}, err
+	default:
+		req = out
+	}
+	plan.Request = req
+
+	name := req.Model
+	if experiment, ok := r.routing.Experiments[name]; ok {
+		a := experimentArm(name, experiment, experimentSubject(ctx, experiment, req.Options))
+		plan.Experiment = &Assignment{Experiment: name, Arm: a.Name, Model: a.Model}
+		name = a.Model
+	}
+	if s, ok := r.schedules[name]; ok {
+		plan.Schedule = name
+		name = s.modelAt(time.Now())
+	}
+
+	var lookupErr error
+	add := func(role, model string, options map[string]any, seesAnswers bool) {
+		modelInfo, _, err := r.registry.LookupModel(model)
+		if err != nil {
+			lookupErr = cmp.Or(lookupErr, err)
+			return
+		}
+		limit, _ := maxTokensOption(chatOptions(options, modelInfo))
+		if r.health.Down(modelInfo.Provider) {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("provider %s of model %s is down according to health probes", modelInfo.Provider, modelInfo.ID))
+		}
+		if until, limited := r.registry.RateLimits().Limited(modelInfo.Provider, time.Now()); limited {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("provider %s of model %s reported its rate limit reached until %s", modelInfo.Provider, modelInfo.ID, until.UTC().Format(time.RFC3339)))
+		}
+		// Emulated choices cost a call each.
+		calls := 1
+		if n := choiceCount(options); n > 1 && modelInfo.APIStyle == "claude" && r.routing.ChoiceEmulation.Enabled {
+			calls = n
+		}
+		for range calls {
+			plan.Calls = append(plan.Calls, PlannedCall{Role: role, Model: modelInfo, Messages: applySystemPrompt(req.Messages, modelInfo), MaxOutputTokens: limit, SeesAnswers: seesAnswers})
+		}
+	}
+
+	if b, ok := r.balancers[name]; ok {
+		plan.Policy = "balancer"
+		target := b.targets[b.heaviest()].Model
+		add(RoleModel, target, req.Options, false)
+		if len(b.targets) > 1 {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("balancer %s picks a target by weight; estimated for the heaviest, %s", name, target))
+		}
+	} else if ensemble, ok := r.routing.Ensembles[name]; ok {
+		plan.Policy = "ensemble"
+		for _, member := range ensemble.Models {
+			add(RoleMember, member, req.Options, false)
+		}
+		if ensemble.SelectionStrategy() == config.EnsembleStrategyJudge {
+			add(RoleJudge, ensemble.Judge, map[string]any{"max_tokens": judgeMaxTokens}, true)
+		}
+	} else if consensus, ok := r.routing.Consensus[name]; ok {
+		plan.Policy = "consensus"
+		for _, model := range consensus.SampleModels() {
+			add(RoleSample, model, req.Options, false)
+		}
+	} else if pair, ok := r.routing.DraftVerify[name]; ok {
+		plan.Policy = "draft_verify"
+		add(RoleDrafter, pair.Drafter, req.Options, false)
+		add(RoleVerifier, pair.Verifier, req.Options, true)
+	} else {
+		add(RoleModel, name, req.Options, false)
+		if r.routing.DegenerateRetry.Enabled {
+			plan.Warnings = append(plan.Warnings, "degenerate_retry may send the request a second time")
+		}
+		if hedge, ok := r.routing.Hedging[name]; ok {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("the request is also sent to %s if %s has not answered after %s", hedge.Model, name, hedge.Delay()))
+		}
+	}
+	if lookupErr != nil {
+		return Plan{}, lookupErr
+	}
+	return plan, nil
+}
@@ -0,0 +1,156 @@
+package router
+
+import (
+	"context"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/plugin"
+)
+
+// UsePlugins installs the plugins run after the hooks. It must be
+// called before the router serves requests.
+func (r *Router) UsePlugins(chain *plugin.Chain) {
+	r.plugins = chain
+}
+
+func pluginMessages(messages []models.Message) []plugin.Message {
+	out := make([]plugin.Message, len(messages))
+	for i, m := range messages {
+		out[i] = plugin.Message{Role: m.Role, Content: m.Content, Name: m.Name, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			out[i].ToolCalls = append(out[i].ToolCalls, plugin.ToolCall{ID: call.ID, Name: call.Name, Arguments: call.Arguments})
+		}
+		for _, part := range m.Parts {
+			p := plugin.Part{Text: part.Text}
+			if image := part.Image; image != nil {
+				p.Image = &plugin.Image{URL: image.URL, MediaType: image.MediaType, Data: image.Data, Detail: image.Detail}
+			}
+			out[i].Parts = append(out[i].Parts, p)
+		}
+		if c := m.CacheControl; c != nil {
+			out[i].CacheControl = &plugin.CacheControl{Type: c.Type, TTL: c.TTL}
+		}
+	}
+	return out
+}
+
+func unifiedMessages(messages []plugin.Message) []models.Message {
+	out := make([]models.Message, len(messages))
+	for i, m := range messages {
+		out[i] = models.Message{Role: m.Role, Content: m.Content, Name: m.Name, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			out[i].ToolCalls = append(out[i].ToolCalls, models.ToolCall{ID: call.ID, Name: call.Name, Arguments: call.Arguments})
+		}
+		for _, part := range m.Parts {
+			p := models.ContentPart{Text: part.Text}
+			if image := part.Image; image != nil {
+				p.Image = &models.Image{URL: image.URL, MediaType: image.MediaType, Data: image.Data, Detail: image.Detail}
+			}
+			out[i].Parts = append(out[i].Parts, p)
+		}
+		if c := m.CacheControl; c != nil {
+			out[i].CacheControl = &models.CacheControl{Type: c.Type, TTL: c.TTL}
+		}
+	}
+	return out
+}
+
+func pluginResponse(id, content, finishReason string, usage models.Usage, modelInfo models.Model) plugin.Response {
+	return plugin.Response{
+		ID:           id,
+		Model:        modelInfo.ID,
+		Provider:     modelInfo.Provider,
+		Content:      content,
+		FinishReason: finishReason,
+		Usage: plugin.Usage{
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.TotalTokens,
+		},
+	}
+}
+
+// pluginChatRequest runs the request plugins on a chat request, returning
+// the call to pass on to the response plugins.
+func (r *Router) pluginChatRequest(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatRequest, plugin.Call, error) {
+	call := plugin.Call{
+		Kind: "chat",
+		Key:  keyIDFrom(ctx),
+		Request: plugin.Request{
+			Model:    req.Model,
+			Messages: pluginMessages(req.Messages),
+			Stream:   req.Stream,
+			Options:  req.Options,
+		},
+	}
+	if r.plugins == nil {
+		return req, call, nil
+	}
+	out, err := r.plugins.OnRequest(ctx, call)
+	if err != nil {
+		return req, call, err
+	}
+	call.Request = out
+	req.Model = out.Model
+	req.Messages = unifiedMessages(out.Messages)
+	req.Options = out.Options
+	return req, call, nil
+}
+
+func (r *Router) pluginChatResponse(ctx context.Context, call plugin.Call, resp *models.UnifiedChatResponse, modelInfo models.Model) error {
+	if r.plugins == nil {
+		return nil
+	}
+	out, annotations, err := r.plugins.OnResponse(ctx, call, pluginResponse(resp.ID, resp.Message.Content, resp.FinishReason, resp.Usage, modelInfo))
+	if err != nil {
+		return err
+	}
+	resp.Message.Content = out.Content
+	resp.FinishReason = out.FinishReason
+	for k, v := range annotations {
+		resp.Annotate(k, v)
+	}
+	return nil
+}
+
+// pluginCompletionRequest runs the request plugins on a completion request.
+func (r *Router) pluginCompletionRequest(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedCompletionRequest, plugin.Call, error) {
+	call := plugin.Call{
+		Kind: "completion",
+		Key:  keyIDFrom(ctx),
+		Request: plugin.Request{
+			Model:   req.Model,
+			Prompt:  req.Prompt,
+			Stream:  req.Stream,
+			Options: req.Options,
+		},
+	}
+	if r.plugins == nil {
+		return req, call, nil
+	}
+	out, err := r.plugins.OnRequest(ctx, call)
+	if err != nil {
+		return req, call, err
+	}
+	call.Request = out
+	req.Model = out.Model
+	req.Prompt = out.Prompt
+	req.Options = out.Options
+	return req, call, nil
+}
+
+func (r *Router) pluginCompletionResponse(ctx context.Context, call plugin.Call, resp *models.UnifiedCompletionResponse, modelInfo models.Model) error {
+	if r.plugins == nil {
+		return nil
+	}
+	out, annotations, err := r.plugins.OnResponse(ctx, call, pluginResponse(resp.ID, resp.Text, resp.FinishReason, resp.Usage, modelInfo))
+	if err != nil {
+		return err
+	}
+	resp.Text = out.Content
+	resp.FinishReason = out.FinishReason
+	for k, v := range annotations {
+		resp.Annotate(k, v)
+	}
+	return nil
+}
@@ -3,59 +3,365 @@ package router
 import (
 	"context"
 	"fmt"
+	"maps"
+	"sync"
+	"time"
 
+	"gocode-router/internal/chaos"
+	"gocode-router/internal/config"
+	"gocode-router/internal/health"
 	"gocode-router/internal/models"
+	"gocode-router/internal/plugin"
 	"gocode-router/internal/provider"
+	"gocode-router/internal/tokens"
 )
 
 // Router dispatches unified requests to the appropriate provider.
 type Router struct {
-	registry *provider.Registry
+	registry   *provider.Registry
+	routing    config.RoutingConfig
+	hooks      []hook
+	schedules  map[string]schedule
+	balancers  map[string]*balancer
+	plugins    *plugin.Chain
+	tokenizers *tokens.Registry
+	health     *health.Tracker
+	chaos      func(model string) chaos.Faults
+	disabled   func(model string) bool
+
+	mirrorObserver func(ctx context.Context, result MirrorResult)
+	mirrors        mirrorSlots
+
+	providerLimits map[string]models.ConcurrencyLimit
+	limitersMu     sync.Mutex
+	limiters       map[string]*modelLimiter
+
+	roundRobin roundRobin
 }
 
-// New constructs a router backed by the provided registry.
-func New(registry *provider.Registry) *Router {
+// New constructs a router backed by the provided registry and routing policies.
+func New(registry *provider.Registry, routing config.RoutingConfig) *Router {
 	return &Router{
-		registry: registry,
+		registry:  registry,
+		routing:   routing,
+		hooks:     compileHooks(routing.Hooks),
+		schedules: compileSchedules(routing.Schedules),
+		balancers: compileBalancers(routing.Balancers),
 	}
 }
 
-// Chat routes a chat completion request to the configured provider.
+// Chat routes a chat completion request to the configured provider, applying
+// the configured hooks and plugins and any routing policy registered for the
+// requested model name.
 func (r *Router) Chat(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
-	modelInfo, providerImpl, err := r.registry.LookupModel(req.Model)
+	ctx, span := startChatSpan(ctx, "router.chat", req.Model)
+	r.mirrorChat(ctx, req)
+	req, run := r.hookChatRequest(ctx, req)
+	resp, modelInfo, err := r.chat(ctx, req, run)
+	endChatSpan(span, resp, modelInfo, err)
+	return resp, modelInfo, err
+}
+
+// chat runs a chat request that has been through the request hooks.
+func (r *Router) chat(ctx context.Context, req models.UnifiedChatRequest, run *hookRun) (*models.UnifiedChatResponse, models.Model, error) {
+	req, call, err := r.pluginChatRequest(ctx, req)
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+	resp, modelInfo, err := r.dispatchChat(ctx, req)
+	if err != nil || resp == nil {
+		return resp, modelInfo, err
+	}
+	if err := r.pluginChatResponse(ctx, call, resp, modelInfo); err != nil {
+		return nil, models.Model{}, err
+	}
+	resp.Message.Content = run.responseText(resp.Message.Content, resp.FinishReason, modelInfo, resp.Annotate)
+	return resp, modelInfo, nil
+}
+
+func (r *Router) dispatchChat(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	if experiment, ok := r.routing.Experiments[req.Model]; ok {
+		return r.experimentChat(ctx, req.Model, experiment, req)
+	}
+	if s, ok := r.schedules[req.Model]; ok {
+		return r.scheduledChat(ctx, req.Model, s, req)
+	}
+	if b, ok := r.balancers[req.Model]; ok {
+		return r.balancedChat(ctx, b, req)
+	}
+	if ensemble, ok := r.routing.Ensembles[req.Model]; ok {
+		return r.ensembleChat(ctx, req.Model, ensemble, req)
+	}
+	if consensus, ok := r.routing.Consensus[req.Model]; ok {
+		return r.consensusChat(ctx, req.Model, consensus, req)
+	}
+	if pair, ok := r.routing.DraftVerify[req.Model]; ok {
+		return r.draftVerifyChat(ctx, req.Model, pair, req)
+	}
+	if hedge, ok := r.routing.Hedging[req.Model]; ok {
+		return r.hedgedChat(ctx, req, hedge)
+	}
+	if chain, ok := r.routing.Fallbacks[req.Model]; ok {
+		return r.fallbackChat(ctx, req, chain)
+	}
+	return r.modelChat(ctx, req)
+}
+
+// modelChat sends the request to the model it names, retrying degenerate
+// responses when configured.
+func (r *Router) modelChat(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	if r.routing.DegenerateRetry.Enabled {
+		return r.chatWithDegenerateRetry(ctx, req)
+	}
+	return r.chatModel(ctx, req)
+}
+
+// Models lists the models clients can request by name.
+func (r *Router) Models() []models.Model {
+	return r.registry.Models()
+}
+
+// Providers lists the registered providers.
+func (r *Router) Providers() []provider.Provider {
+	return r.registry.Providers()
+}
+
+// CloseIdleConnections closes the idle upstream connections of every
+// provider, as when a reload has replaced the router.
+func (r *Router) CloseIdleConnections() {
+	for _, p := range r.registry.Providers() {
+		if closer, ok := p.(provider.IdleCloser); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+}
+
+// RefreshModels refetches the upstream model lists that are due for a
+// refresh, for providers with discovery enabled.
+func (r *Router) RefreshModels(ctx context.Context) {
+	r.registry.RefreshModels(ctx)
+}
+
+// chatModel sends the request to the single model it names, in a span of
+// its own so each model a policy tries shows in the trace.
+func (r *Router) chatModel(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	ctx, span := startChatSpan(ctx, "chat "+req.Model, req.Model)
+	resp, modelInfo, err := r.sendChatModel(ctx, req)
+	endChatSpan(span, resp, modelInfo, err)
+	return resp, modelInfo, err
+}
+
+func (r *Router) sendChatModel(ctx context.Context, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	modelInfo, providerImpl, err := r.lookupModel(req.Model)
 	if err != nil {
 		return nil, models.Model{}, err
 	}
 
 	sanitisedReq := req
 	sanitisedReq.Model = modelInfo.ID
-	sanitisedReq.Options = cloneOptions(req.Options)
+	sanitisedReq.Options = chatOptions(req.Options, modelInfo)
+	sanitisedReq.Messages = applySystemPrompt(req.Messages, modelInfo)
+	if err := r.checkChatContext(modelInfo, sanitisedReq); err != nil {
+		return nil, models.Model{}, err
+	}
+
+	if n := choiceCount(sanitisedReq.Options); n > 1 && modelInfo.APIStyle == "claude" {
+		return r.emulateChoices(ctx, providerImpl, modelInfo, sanitisedReq, n)
+	}
 
-	resp, err := providerImpl.Chat(ctx, sanitisedReq)
+	resp, err := r.callChat(ctx, providerImpl, modelInfo, sanitisedReq)
 	if err != nil {
-		return nil, models.Model{}, fmt.Errorf("provider %s chat request: %w", providerImpl.Name(), err)
+		return nil, models.Model{}, err
 	}
 	return resp, modelInfo, nil
 }
 
-// Completion routes a text completion request to the configured provider.
+// applySystemPrompt returns messages with the model's system prompt in
+// place of their system messages or, when the model prepends it, ahead of
+// them. Messages are returned as they are when the model has none.
+func applySystemPrompt(messages []models.Message, modelInfo models.Model) []models.Message {
+	if modelInfo.SystemPrompt == "" {
+		return messages
+	}
+	out := make([]models.Message, 0, len(messages)+1)
+	out = append(out, models.Message{Role: "system", Content: modelInfo.SystemPrompt})
+	for _, msg := range messages {
+		if msg.Role == "system" && !modelInfo.PrependSystem {
+			continue
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+// defaultClaudeMaxTokens is the max_tokens sent to Claude-style models,
+// which require one, when neither the client, the model's defaults nor its
+// max_output_tokens give one.
+const defaultClaudeMaxTokens = 4096
+
+// chatOptions returns a copy of options as sent to the model: with its
+// defaults and overrides applied and max_tokens capped, or filled in for
+// Claude-style models.
+func chatOptions(options map[string]any, modelInfo models.Model) map[string]any {
+	out := CapOutputTokens(applyModelOptions(options, modelInfo), modelInfo.MaxOutputTokens)
+	if modelInfo.APIStyle != "claude" {
+		return out
+	}
+	if requested, ok := maxTokensOption(out); ok && requested > 0 {
+		return out
+	}
+	if out == nil {
+		out = make(map[string]any, 1)
+	}
+	out["max_tokens"] = defaultClaudeMaxTokens
+	return out
+}
+
+// applyModelOptions returns a copy of options with the model's defaults
+// filled in where options leave them out and its overrides in place of
+// theirs.
+func applyModelOptions(options map[string]any, modelInfo models.Model) map[string]any {
+	out := cloneOptions(options)
+	if len(modelInfo.DefaultOptions) == 0 && len(modelInfo.OverrideOptions) == 0 {
+		return out
+	}
+	if out == nil {
+		out = make(map[string]any, len(modelInfo.DefaultOptions)+len(modelInfo.OverrideOptions))
+	}
+	for name, value := range modelInfo.DefaultOptions {
+		if _, ok := out[name]; !ok {
+			out[name] = value
+		}
+	}
+	maps.Copy(out, modelInfo.OverrideOptions)
+	return out
+}
+
+// callChat sends a prepared request to the provider within the model's
+// concurrency limit.
+func (r *Router) callChat(ctx context.Context, providerImpl provider.Provider, modelInfo models.Model, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, error) {
+	if err := r.checkAvailable(modelInfo); err != nil {
+		return nil, err
+	}
+	if err := r.injectFaults(ctx, modelInfo); err != nil {
+		return nil, err
+	}
+	release, err := r.acquireModel(ctx, modelInfo)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, deadline := r.startDeadline(ctx, modelInfo)
+	defer deadline.stop()
+	resp, err := providerImpl.Chat(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s chat request: %w", providerImpl.Name(), deadline.err(ctx, err))
+	}
+	if resp != nil {
+		r.estimateChatUsage(modelInfo, req, resp)
+	}
+	return resp, nil
+}
+
+// Completion routes a text completion request to the configured provider,
+// applying the configured hooks.
 func (r *Router) Completion(ctx context.Context, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, models.Model, error) {
-	modelInfo, providerImpl, err := r.registry.LookupModel(req.Model)
+	r.mirrorCompletion(ctx, req)
+	req, run := r.hookCompletionRequest(ctx, req)
+	return r.completion(ctx, req, run)
+}
+
+// completion runs a completion request that has been through the request
+// hooks.
+func (r *Router) completion(ctx context.Context, req models.UnifiedCompletionRequest, run *hookRun) (*models.UnifiedCompletionResponse, models.Model, error) {
+	req, call, err := r.pluginCompletionRequest(ctx, req)
 	if err != nil {
 		return nil, models.Model{}, err
 	}
+	var assignment *Assignment
+	if experiment, ok := r.routing.Experiments[req.Model]; ok {
+		a := assignArm(ctx, req.Model, experiment, req.Options)
+		req.Model, assignment = a.Model, &a
+	}
+	scheduleName, scheduled := req.Model, false
+	if s, ok := r.schedules[req.Model]; ok {
+		req.Model, scheduled = s.modelAt(time.Now()), true
+	}
+	var resp *models.UnifiedCompletionResponse
+	var modelInfo models.Model
+	if b, ok := r.balancers[req.Model]; ok {
+		resp, modelInfo, err = r.balancedCompletion(ctx, b, req)
+	} else if hedge, ok := r.routing.Hedging[req.Model]; ok {
+		resp, modelInfo, err = r.hedgedCompletion(ctx, req, hedge)
+	} else {
+		resp, modelInfo, err = r.completionModel(ctx, req)
+	}
+	if err != nil || resp == nil {
+		return resp, modelInfo, err
+	}
+	if scheduled {
+		resp.Annotate("schedule", map[string]any{
+			"name":  scheduleName,
+			"model": req.Model,
+		})
+	}
+	if assignment != nil {
+		resp.Annotate("experiment", assignment.annotation())
+	}
+	if err := r.pluginCompletionResponse(ctx, call, resp, modelInfo); err != nil {
+		return nil, models.Model{}, err
+	}
+	resp.Text = run.responseText(resp.Text, resp.FinishReason, modelInfo, resp.Annotate)
+	return resp, modelInfo, nil
+}
 
-	sanitisedReq := req
-	sanitisedReq.Model = modelInfo.ID
-	sanitisedReq.Options = cloneOptions(req.Options)
+func (r *Router) completionModel(ctx context.Context, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, models.Model, error) {
+	modelInfo, providerImpl, err := r.lookupModel(req.Model)
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+
+	sanitisedReq := completionRequestFor(req, modelInfo)
+	if err := r.checkCompletionContext(modelInfo, sanitisedReq); err != nil {
+		return nil, models.Model{}, err
+	}
 
+	if err := r.checkAvailable(modelInfo); err != nil {
+		return nil, models.Model{}, err
+	}
+	if err := r.injectFaults(ctx, modelInfo); err != nil {
+		return nil, models.Model{}, err
+	}
+	release, err := r.acquireModel(ctx, modelInfo)
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+	defer release()
+
+	ctx, deadline := r.startDeadline(ctx, modelInfo)
+	defer deadline.stop()
 	resp, err := providerImpl.Completion(ctx, sanitisedReq)
 	if err != nil {
-		return nil, models.Model{}, fmt.Errorf("provider %s completion request: %w", providerImpl.Name(), err)
+		return nil, models.Model{}, fmt.Errorf("provider %s completion request: %w", providerImpl.Name(), deadline.err(ctx, err))
+	}
+	if resp != nil {
+		r.estimateCompletionUsage(modelInfo, sanitisedReq, resp)
 	}
 	return resp, modelInfo, nil
 }
 
+// completionRequestFor returns req as sent to modelInfo, under its ID and
+// within its output cap.
+func completionRequestFor(req models.UnifiedCompletionRequest, modelInfo models.Model) models.UnifiedCompletionRequest {
+	req.Model = modelInfo.ID
+	req.Options = CapOutputTokens(cloneOptions(req.Options), modelInfo.MaxOutputTokens)
+	if limit := modelInfo.MaxOutputTokens; limit > 0 && (req.MaxTokens <= 0 || req.MaxTokens > limit) {
+		req.MaxTokens = limit
+	}
+	return req
+}
+
 func cloneOptions(options map[string]any) map[string]any {
 	if len(options) == 0 {
 		return nil
@@ -66,3 +372,31 @@ func cloneOptions(options map[string]any) map[string]any {
 	}
 	return out
 }
+
+// CapOutputTokens clamps the max_tokens option to limit, filling it in when
+// the client did not request one. A non-positive limit leaves options untouched.
+func CapOutputTokens(options map[string]any, limit int) map[string]any {
+	if limit <= 0 {
+		return options
+	}
+	if requested, ok := maxTokensOption(options); ok && requested > 0 && requested <= limit {
+		return options
+	}
+	if options == nil {
+		options = make(map[string]any, 1)
+	}
+	options["max_tokens"] = limit
+	return options
+}
+
+func maxTokensOption(options map[string]any) (int, bool) {
+	switch v := options["max_tokens"].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
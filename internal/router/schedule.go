@@ -0,0 +1,99 @@
+package router
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+// schedule is a compiled routing.schedules entry.
+type schedule struct {
+	loc          *time.Location
+	rules        []scheduleRule
+	defaultModel string
+}
+
+type scheduleRule struct {
+	days     [7]bool
+	from, to int
+	model    string
+}
+
+// compileSchedules compiles the configured schedules. Configuration
+// validation has already parsed them once, so a failure here only drops the
+// schedule.
+func compileSchedules(cfgs map[string]config.ScheduleConfig) map[string]schedule {
+	schedules := make(map[string]schedule, len(cfgs))
+	for name, cfg := range cfgs {
+		s, err := compileSchedule(cfg)
+		if err != nil {
+			slog.Error("skipping invalid schedule", "schedule", name, "error", err)
+			continue
+		}
+		schedules[name] = s
+	}
+	return schedules
+}
+
+func compileSchedule(cfg config.ScheduleConfig) (schedule, error) {
+	loc, err := cfg.Location()
+	if err != nil {
+		return schedule{}, err
+	}
+	s := schedule{loc: loc, defaultModel: cfg.Default}
+	for _, rule := range cfg.Rules {
+		days, err := config.ParseWeekdays(rule.Days)
+		if err != nil {
+			return schedule{}, err
+		}
+		from, err := config.ParseClock(rule.From)
+		if err != nil {
+			return schedule{}, err
+		}
+		to, err := config.ParseClock(rule.To)
+		if err != nil {
+			return schedule{}, err
+		}
+		s.rules = append(s.rules, scheduleRule{days: days, from: from, to: to, model: rule.Model})
+	}
+	return s, nil
+}
+
+// modelAt returns the model the schedule routes to at t.
+func (s schedule) modelAt(t time.Time) string {
+	t = t.In(s.loc)
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	for _, rule := range s.rules {
+		if rule.from < rule.to {
+			if rule.days[today] && minute >= rule.from && minute < rule.to {
+				return rule.model
+			}
+			continue
+		}
+		// The window runs past midnight: it either started today or is the
+		// tail of one that started yesterday.
+		if (rule.days[today] && minute >= rule.from) || (rule.days[yesterday] && minute < rule.to) {
+			return rule.model
+		}
+	}
+	return s.defaultModel
+}
+
+// scheduledChat sends the request to the model the schedule picks now.
+func (r *Router) scheduledChat(ctx context.Context, name string, s schedule, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, error) {
+	req.Model = s.modelAt(time.Now())
+	resp, modelInfo, err := r.dispatchChat(ctx, req)
+	if err != nil || resp == nil {
+		return resp, modelInfo, err
+	}
+	resp.Annotate("schedule", map[string]any{
+		"name":  name,
+		"model": req.Model,
+	})
+	return resp, modelInfo, nil
+}
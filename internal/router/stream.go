@@ -0,0 +1,238 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+)
+
+// ChatStream routes a chat request like Chat, returning the response as a
+// stream. Models reached directly or through schedules and balancers
+// stream from the upstream. Policies, hooks and plugins that need the whole
+// response run as for Chat, and the response is replayed as a stream.
+func (r *Router) ChatStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, models.Model, error) {
+	// Hooks can tell streamed requests apart; providers are sent a plain
+	// request and stream it themselves.
+	ctx, span := startChatSpan(ctx, "router.chat_stream", req.Model)
+	r.mirrorChat(ctx, req)
+	req.Stream = true
+	req, run := r.hookChatRequest(ctx, req)
+	req.Stream = false
+	var stream models.UnifiedChatStream
+	var modelInfo models.Model
+	var err error
+	if r.plugins != nil || run.rewritesResponse() {
+		stream, modelInfo, err = r.replayChat(r.chat(ctx, req, run))
+	} else {
+		stream, modelInfo, err = r.dispatchChatStream(ctx, req)
+	}
+	endChatSpan(span, nil, modelInfo, err)
+	return stream, modelInfo, err
+}
+
+func (r *Router) dispatchChatStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, models.Model, error) {
+	if experiment, ok := r.routing.Experiments[req.Model]; ok {
+		req.Model = assignArm(ctx, req.Model, experiment, req.Options).Model
+		return r.dispatchChatStream(ctx, req)
+	}
+	if s, ok := r.schedules[req.Model]; ok {
+		req.Model = s.modelAt(time.Now())
+		return r.dispatchChatStream(ctx, req)
+	}
+	if b, ok := r.balancers[req.Model]; ok {
+		i := b.pick(r.available)
+		req.Model = b.targets[i].Model
+		start := time.Now()
+		stream, modelInfo, err := r.chatModelStream(ctx, req)
+		// Balancers see the time to the start of the stream.
+		b.observe(i, time.Since(start), err)
+		return stream, modelInfo, err
+	}
+	if r.streamsWhole(req.Model) {
+		return r.replayChat(r.dispatchChat(ctx, req))
+	}
+	if hedge, ok := r.routing.Hedging[req.Model]; ok {
+		return r.hedgedChatStream(ctx, req, hedge)
+	}
+	if chain, ok := r.routing.Fallbacks[req.Model]; ok {
+		return r.fallbackChatStream(ctx, req, chain)
+	}
+	return r.chatModelStream(ctx, req)
+}
+
+// streamsWhole reports whether a model name is served by a policy that
+// combines or checks whole responses.
+func (r *Router) streamsWhole(model string) bool {
+	_, ensemble := r.routing.Ensembles[model]
+	_, consensus := r.routing.Consensus[model]
+	_, draft := r.routing.DraftVerify[model]
+	return ensemble || consensus || draft || r.routing.DegenerateRetry.Enabled
+}
+
+// replayChat streams the result of a buffered chat.
+func (r *Router) replayChat(resp *models.UnifiedChatResponse, modelInfo models.Model, err error) (models.UnifiedChatStream, models.Model, error) {
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+	if resp == nil {
+		return nil, models.Model{}, errors.New("upstream provider returned an empty response")
+	}
+	return provider.ReplayStream(resp), modelInfo, nil
+}
+
+// chatModelStream streams from the single model the request names, in a
+// span that ends once the stream has started. Emulated choices need whole
+// responses and are replayed.
+func (r *Router) chatModelStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, models.Model, error) {
+	ctx, span := startChatSpan(ctx, "chat_stream "+req.Model, req.Model)
+	stream, modelInfo, err := r.sendChatModelStream(ctx, req)
+	endChatSpan(span, nil, modelInfo, err)
+	return stream, modelInfo, err
+}
+
+func (r *Router) sendChatModelStream(ctx context.Context, req models.UnifiedChatRequest) (models.UnifiedChatStream, models.Model, error) {
+	modelInfo, providerImpl, err := r.lookupModel(req.Model)
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+
+	sanitisedReq := req
+	sanitisedReq.Model = modelInfo.ID
+	sanitisedReq.Options = chatOptions(req.Options, modelInfo)
+	sanitisedReq.Messages = applySystemPrompt(req.Messages, modelInfo)
+	if err := r.checkChatContext(modelInfo, sanitisedReq); err != nil {
+		return nil, models.Model{}, err
+	}
+
+	if n := choiceCount(sanitisedReq.Options); n > 1 && modelInfo.APIStyle == "claude" {
+		return r.replayChat(r.emulateChoices(ctx, providerImpl, modelInfo, sanitisedReq, n))
+	}
+
+	if err := r.checkAvailable(modelInfo); err != nil {
+		return nil, models.Model{}, err
+	}
+	if err := r.injectFaults(ctx, modelInfo); err != nil {
+		return nil, models.Model{}, err
+	}
+	release, err := r.acquireModel(ctx, modelInfo)
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+
+	ctx, deadline := r.startDeadline(ctx, modelInfo)
+	stream, err := providerImpl.ChatStream(ctx, sanitisedReq)
+	if err != nil {
+		deadline.stop()
+		release()
+		return nil, models.Model{}, fmt.Errorf("provider %s chat stream request: %w", providerImpl.Name(), deadline.err(ctx, err))
+	}
+	stream = &timedStream{UnifiedChatStream: stream, ctx: ctx, deadline: deadline}
+	stream = r.estimateStreamUsage(modelInfo, sanitisedReq, stream)
+	// The model's concurrency slot is held until the stream is closed.
+	return &releasingStream{UnifiedChatStream: stream, release: release}, modelInfo, nil
+}
+
+// releasingStream releases a concurrency slot when the stream is closed.
+type releasingStream struct {
+	models.UnifiedChatStream
+	once    sync.Once
+	release func()
+}
+
+func (s *releasingStream) Close() error {
+	err := s.UnifiedChatStream.Close()
+	s.once.Do(s.release)
+	return err
+}
+
+// CompletionStream routes a text completion request like Completion,
+// returning the text as a stream of chunks carrying Content. Models reached
+// directly or through experiments, schedules and balancers stream from the
+// upstream. Hedging, plugins and hooks that rewrite the response need the
+// whole completion, which is then replayed as a stream.
+func (r *Router) CompletionStream(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedChatStream, models.Model, error) {
+	r.mirrorCompletion(ctx, req)
+	req.Stream = true
+	req, run := r.hookCompletionRequest(ctx, req)
+	req.Stream = false
+	if r.plugins != nil || run.rewritesResponse() {
+		return r.replayCompletion(r.completion(ctx, req, run))
+	}
+	return r.dispatchCompletionStream(ctx, req)
+}
+
+func (r *Router) dispatchCompletionStream(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedChatStream, models.Model, error) {
+	if experiment, ok := r.routing.Experiments[req.Model]; ok {
+		req.Model = assignArm(ctx, req.Model, experiment, req.Options).Model
+		return r.dispatchCompletionStream(ctx, req)
+	}
+	if s, ok := r.schedules[req.Model]; ok {
+		req.Model = s.modelAt(time.Now())
+		return r.dispatchCompletionStream(ctx, req)
+	}
+	if b, ok := r.balancers[req.Model]; ok {
+		i := b.pick(r.available)
+		req.Model = b.targets[i].Model
+		start := time.Now()
+		stream, modelInfo, err := r.completionModelStream(ctx, req)
+		b.observe(i, time.Since(start), err)
+		return stream, modelInfo, err
+	}
+	if hedge, ok := r.routing.Hedging[req.Model]; ok {
+		return r.replayCompletion(r.hedgedCompletion(ctx, req, hedge))
+	}
+	return r.completionModelStream(ctx, req)
+}
+
+// replayCompletion streams the result of a buffered completion.
+func (r *Router) replayCompletion(resp *models.UnifiedCompletionResponse, modelInfo models.Model, err error) (models.UnifiedChatStream, models.Model, error) {
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+	if resp == nil {
+		return nil, models.Model{}, errors.New("upstream provider returned an empty response")
+	}
+	return provider.ReplayCompletion(resp), modelInfo, nil
+}
+
+// completionModelStream streams from the single model the request names,
+// as completionModel sends to it.
+func (r *Router) completionModelStream(ctx context.Context, req models.UnifiedCompletionRequest) (models.UnifiedChatStream, models.Model, error) {
+	modelInfo, providerImpl, err := r.lookupModel(req.Model)
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+
+	sanitisedReq := completionRequestFor(req, modelInfo)
+	if err := r.checkCompletionContext(modelInfo, sanitisedReq); err != nil {
+		return nil, models.Model{}, err
+	}
+
+	if err := r.checkAvailable(modelInfo); err != nil {
+		return nil, models.Model{}, err
+	}
+	if err := r.injectFaults(ctx, modelInfo); err != nil {
+		return nil, models.Model{}, err
+	}
+	release, err := r.acquireModel(ctx, modelInfo)
+	if err != nil {
+		return nil, models.Model{}, err
+	}
+
+	ctx, deadline := r.startDeadline(ctx, modelInfo)
+	stream, err := providerImpl.CompletionStream(ctx, sanitisedReq)
+	if err != nil {
+		deadline.stop()
+		release()
+		return nil, models.Model{}, fmt.Errorf("provider %s completion stream request: %w", providerImpl.Name(), deadline.err(ctx, err))
+	}
+	stream = &timedStream{UnifiedChatStream: stream, ctx: ctx, deadline: deadline}
+	stream = r.estimateCompletionStreamUsage(modelInfo, sanitisedReq, stream)
+	// The model's concurrency slot is held until the stream is closed.
+	return &releasingStream{UnifiedChatStream: stream, release: release}, modelInfo, nil
+}
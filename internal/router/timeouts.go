@@ -0,0 +1,102 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"gocode-router/internal/models"
+)
+
+// TimeoutError reports that a model's upstream took longer than its
+// timeout to answer or, with Idle, to send the next chunk of a stream.
+type TimeoutError struct {
+	Model string
+	After time.Duration
+	Idle  bool
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Idle {
+		return fmt.Sprintf("model %s sent nothing for %s mid-stream", e.Model, e.After)
+	}
+	return fmt.Sprintf("model %s did not answer within %s", e.Model, e.After)
+}
+
+// deadline bounds a request to a model by cancelling its context with a
+// *TimeoutError: once the request timeout passes or, after the first
+// kick, once a stream's idle timeout passes without another.
+type deadline struct {
+	timer   *time.Timer
+	idle    time.Duration
+	started atomic.Bool
+	cancel  context.CancelCauseFunc
+}
+
+// startDeadline starts the clock on a request to a model and returns the
+// context to send it with. The deadline must be stopped.
+func (r *Router) startDeadline(ctx context.Context, modelInfo models.Model) (context.Context, *deadline) {
+	timeouts := r.registry.Timeouts(modelInfo)
+	ctx, cancel := context.WithCancelCause(ctx)
+	d := &deadline{idle: timeouts.StreamIdle, cancel: cancel}
+	d.timer = time.AfterFunc(timeouts.Request, func() {
+		if d.started.Load() {
+			cancel(&TimeoutError{Model: modelInfo.ID, After: timeouts.StreamIdle, Idle: true})
+			return
+		}
+		cancel(&TimeoutError{Model: modelInfo.ID, After: timeouts.Request})
+	})
+	return ctx, d
+}
+
+// kick restarts the clock when a stream delivers something; from then on
+// it runs for the idle timeout.
+func (d *deadline) kick() {
+	d.started.Store(true)
+	d.timer.Reset(d.idle)
+}
+
+// stop ends the deadline and releases its context.
+func (d *deadline) stop() {
+	d.timer.Stop()
+	d.cancel(nil)
+}
+
+// err returns the timeout that cut a request short in place of the error
+// it failed with, which only tells of a cancelled context.
+func (d *deadline) err(ctx context.Context, err error) error {
+	var timeout *TimeoutError
+	if err != nil && errors.As(context.Cause(ctx), &timeout) {
+		return timeout
+	}
+	return err
+}
+
+// timedStream applies a deadline to a stream, restarting its clock on each
+// chunk.
+type timedStream struct {
+	models.UnifiedChatStream
+	ctx      context.Context
+	deadline *deadline
+}
+
+func (s *timedStream) Recv() (models.UnifiedChatChunk, error) {
+	c, err := s.UnifiedChatStream.Recv()
+	if errors.Is(err, io.EOF) {
+		return c, err
+	}
+	if err != nil {
+		return c, s.deadline.err(s.ctx, err)
+	}
+	s.deadline.kick()
+	return c, nil
+}
+
+func (s *timedStream) Close() error {
+	err := s.UnifiedChatStream.Close()
+	s.deadline.stop()
+	return err
+}
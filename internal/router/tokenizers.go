@@ -0,0 +1,29 @@
+package router
+
+import (
+	"gocode-router/internal/models"
+	"gocode-router/internal/tokens"
+)
+
+// UseTokenizers installs the local tokenizers of each model family. It must
+// be called before the router serves requests.
+func (r *Router) UseTokenizers(registry *tokens.Registry) {
+	r.tokenizers = registry
+}
+
+// Tokenizer returns the tokenizer counting tokens for a model ID.
+func (r *Router) Tokenizer(modelID string) tokens.Tokenizer {
+	return r.tokenizers.ForModel(modelID)
+}
+
+// LookupModel resolves a model name or alias to the model it serves.
+func (r *Router) LookupModel(name string) (models.Model, error) {
+	modelInfo, _, err := r.registry.LookupModel(name)
+	return modelInfo, err
+}
+
+// PromptMessages returns messages as a model receives them, with its
+// system prompt applied.
+func (r *Router) PromptMessages(modelInfo models.Model, messages []models.Message) []models.Message {
+	return applySystemPrompt(messages, modelInfo)
+}
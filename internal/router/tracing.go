@@ -0,0 +1,32 @@
+package router
+
+import (
+	"context"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/telemetry"
+)
+
+// startChatSpan starts a span for a chat request to model.
+func startChatSpan(ctx context.Context, name, model string) (context.Context, *telemetry.Span) {
+	ctx, span := telemetry.StartSpan(ctx, name, telemetry.SpanInternal)
+	span.SetAttributes("gen_ai.request.model", model)
+	return ctx, span
+}
+
+// endChatSpan records the outcome of a chat request on its span and ends
+// it. Streams have no response to report usage from.
+func endChatSpan(span *telemetry.Span, resp *models.UnifiedChatResponse, modelInfo models.Model, err error) {
+	if err != nil {
+		span.RecordError(err)
+	} else {
+		span.SetAttributes("gen_ai.response.model", modelInfo.ID, "gen_ai.provider.name", modelInfo.Provider)
+	}
+	if resp != nil {
+		span.SetAttributes(
+			"gen_ai.usage.input_tokens", resp.Usage.PromptTokens,
+			"gen_ai.usage.output_tokens", resp.Usage.CompletionTokens,
+		)
+	}
+	span.End()
+}
@@ -0,0 +1,437 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/budget"
+	"gocode-router/internal/health"
+	"gocode-router/internal/usage"
+)
+
+// requireAdmin hides the admin API unless admin.token is set, and checks
+// the bearer token against it.
+func (s *Server) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := s.currentConfig().Admin.Token
+		if token == "" {
+			return echo.ErrNotFound
+		}
+		if subtle.ConstantTimeCompare([]byte(clientKey(c)), []byte(token)) != 1 {
+			return requestError{
+				Status:  http.StatusUnauthorized,
+				Message: "invalid admin token",
+				Type:    "authentication_error",
+			}
+		}
+		return next(c)
+	}
+}
+
+// handleAdminQuotas returns the current quota windows of the key ID given
+// in ?key=, or of every key named in the configuration.
+func (s *Server) handleAdminQuotas(c echo.Context) error {
+	keyIDs := []string{c.QueryParam("key")}
+	if keyIDs[0] == "" {
+		keyIDs = s.configuredKeyIDs()
+	}
+
+	states := []budget.QuotaState{}
+	for _, keyID := range keyIDs {
+		states = append(states, s.quotaStates(c.Request().Context(), keyID)...)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   states,
+	})
+}
+
+// handleAdminBalancers returns the current target weights of every
+// balancer.
+func (s *Server) handleAdminBalancers(c echo.Context) error {
+	rt := s.currentRouter()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   rt.Balancers(),
+	})
+}
+
+// handleAdminHealth returns the probe history of every provider.
+func (s *Server) handleAdminHealth(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   s.health.States(),
+	})
+}
+
+// chaosOverride is the body of POST /admin/chaos. A null enabled returns
+// control to the configuration.
+type chaosOverride struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// handleAdminChaos reports whether chaos faults are injected and, on POST,
+// switches them on or off until the next override or restart.
+func (s *Server) handleAdminChaos(c echo.Context) error {
+	if c.Request().Method == http.MethodPost {
+		var body chaosOverride
+		if err := decodeRequestBody(c, &body); err != nil {
+			return err
+		}
+		s.chaos.Override(body.Enabled)
+		slog.Warn("chaos override changed", "enabled", body.Enabled)
+	}
+	cfg := s.currentConfig().Chaos
+	return c.JSON(http.StatusOK, map[string]any{
+		"enabled":  s.chaos.Enabled(cfg),
+		"override": s.chaos.Overridden(),
+		"rules":    len(cfg.Rules),
+	})
+}
+
+// configuredKeyIDs returns the IDs of the client keys listed under keys or
+// named by a quota, in a stable order.
+func (s *Server) configuredKeyIDs() []string {
+	cfg := s.currentConfig()
+	seen := make(map[string]bool)
+	for key := range cfg.Keys {
+		seen[usage.KeyID(key)] = true
+	}
+	for _, q := range cfg.Budgets.Quotas {
+		for _, key := range q.Keys {
+			seen[usage.KeyID(key)] = true
+		}
+	}
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// disabledModels holds the models switched off through the admin API. They
+// stay off across config reloads until switched back on or the process
+// restarts.
+type disabledModels struct {
+	mu  sync.RWMutex
+	ids map[string]bool
+}
+
+func (d *disabledModels) has(model string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.ids[model]
+}
+
+func (d *disabledModels) set(model string, disabled bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !disabled {
+		delete(d.ids, model)
+		return
+	}
+	if d.ids == nil {
+		d.ids = make(map[string]bool)
+	}
+	d.ids[model] = true
+}
+
+// adminProvider describes a provider in GET /admin/providers.
+type adminProvider struct {
+	Name             string        `json:"name"`
+	Type             string        `json:"type,omitempty"`
+	Models           []string      `json:"models"`
+	Health           *health.State `json:"health,omitempty"`
+	RateLimitedUntil *time.Time    `json:"rate_limited_until,omitempty"`
+}
+
+// adminModel describes a model in GET /admin/models. Available is false
+// when the model is disabled or its provider is down or rate limited.
+type adminModel struct {
+	ID        string `json:"id"`
+	Provider  string `json:"provider"`
+	APIStyle  string `json:"api_style,omitempty"`
+	Disabled  bool   `json:"disabled"`
+	Available bool   `json:"available"`
+}
+
+// handleAdminProviders lists the providers with their models, probe state
+// and any rate limit they are waiting out.
+func (s *Server) handleAdminProviders(c echo.Context) error {
+	rt := s.currentRouter()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+	configured := s.currentConfig().Providers.Named()
+	states := make(map[string]health.State)
+	for _, state := range s.health.States() {
+		states[state.Provider] = state
+	}
+	modelIDs := make(map[string][]string)
+	for _, model := range rt.Models() {
+		modelIDs[model.Provider] = append(modelIDs[model.Provider], model.ID)
+	}
+
+	data := []adminProvider{}
+	for _, p := range rt.Providers() {
+		name := p.Name()
+		entry := adminProvider{Name: name, Type: configured[name].Type, Models: modelIDs[name]}
+		if entry.Models == nil {
+			entry.Models = []string{}
+		}
+		slices.Sort(entry.Models)
+		if state, ok := states[name]; ok {
+			entry.Health = &state
+		}
+		if until, limited := rt.RateLimitedUntil(name); limited {
+			entry.RateLimitedUntil = &until
+		}
+		data = append(data, entry)
+	}
+	slices.SortFunc(data, func(a, b adminProvider) int { return strings.Compare(a.Name, b.Name) })
+	return c.JSON(http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// handleAdminModels lists the models clients can request and whether each
+// is disabled or otherwise unavailable.
+func (s *Server) handleAdminModels(c echo.Context) error {
+	rt := s.currentRouter()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+	data := []adminModel{}
+	for _, model := range rt.Models() {
+		disabled := s.disabled.has(model.ID)
+		_, limited := rt.RateLimitedUntil(model.Provider)
+		data = append(data, adminModel{
+			ID:        model.ID,
+			Provider:  model.Provider,
+			APIStyle:  model.APIStyle,
+			Disabled:  disabled,
+			Available: !disabled && !limited && !s.health.Down(model.Provider),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// modelSwitch is the body of POST /admin/models/disable and enable. Model
+// IDs may contain slashes, so the model is named in the body rather than
+// the path.
+type modelSwitch struct {
+	Model string `json:"model"`
+}
+
+// handleAdminSwitchModel switches a model, or the model an alias names, on
+// or off. Requests for a disabled model fail with a 503, or go to the next
+// model in routing.fallbacks.
+func (s *Server) handleAdminSwitchModel(enabled bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var body modelSwitch
+		if err := decodeRequestBody(c, &body); err != nil {
+			return err
+		}
+		if body.Model == "" {
+			return requestError{
+				Status:  http.StatusBadRequest,
+				Message: "model is required",
+				Type:    "invalid_request_error",
+			}
+		}
+		rt := s.currentRouter()
+		if rt == nil {
+			return requestError{
+				Status:  http.StatusServiceUnavailable,
+				Message: "router not initialised",
+				Type:    "server_error",
+			}
+		}
+		modelInfo, err := rt.LookupModel(body.Model)
+		if err != nil {
+			return requestError{
+				Status:  http.StatusNotFound,
+				Message: "model " + strconv.Quote(body.Model) + " not found",
+				Type:    "invalid_request_error",
+				Code:    "model_not_found",
+			}
+		}
+		s.disabled.set(modelInfo.ID, !enabled)
+		slog.Warn("model switched through the admin API", "model", modelInfo.ID, "enabled", enabled)
+		return c.JSON(http.StatusOK, map[string]any{
+			"model":    modelInfo.ID,
+			"disabled": !enabled,
+		})
+	}
+}
+
+// adminKey describes a client key in GET /admin/keys. Source is config for
+// keys listed under auth.keys, key_file for those in auth.key_file and api
+// for those issued through the admin API; only the last can be revoked
+// through the API.
+type adminKey struct {
+	ID        string     `json:"id"`
+	Source    string     `json:"source"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// handleAdminKeys lists the IDs of the accepted client keys.
+func (s *Server) handleAdminKeys(c echo.Context) error {
+	auth := s.currentConfig().Auth
+	data := []adminKey{}
+	for _, key := range auth.Keys {
+		data = append(data, adminKey{ID: usage.KeyID(strings.TrimSpace(key)), Source: "config"})
+	}
+	if auth.KeyFile != "" {
+		keys, err := readKeyFile(auth.KeyFile)
+		if err != nil {
+			return keyStoreError(err)
+		}
+		for _, key := range keys {
+			data = append(data, adminKey{ID: usage.KeyID(key), Source: "key_file"})
+		}
+	}
+	issued, err := s.storage.Keys().List(c.Request().Context())
+	if err != nil {
+		return keyStoreError(err)
+	}
+	for _, key := range issued {
+		data = append(data, adminKey{ID: key.ID, Source: "api", CreatedAt: &key.CreatedAt})
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// handleAdminCreateKey issues a client key and returns it. The key itself
+// is shown only in this response; the storage backend keeps its hash.
+func (s *Server) handleAdminCreateKey(c echo.Context) error {
+	issued, key, err := s.createClientKey(c.Request().Context())
+	if err != nil {
+		return keyStoreError(err)
+	}
+	slog.Warn("client key created through the admin API", "key_id", issued.ID)
+	return c.JSON(http.StatusCreated, map[string]any{
+		"id":         issued.ID,
+		"key":        key,
+		"created_at": issued.CreatedAt,
+	})
+}
+
+// handleAdminRevokeKey revokes a key issued through the admin API by its
+// ID.
+func (s *Server) handleAdminRevokeKey(c echo.Context) error {
+	id := c.Param("id")
+	auth := s.currentConfig().Auth
+	for _, key := range auth.Keys {
+		if usage.KeyID(strings.TrimSpace(key)) == id {
+			return requestError{
+				Status:  http.StatusConflict,
+				Message: "key " + id + " is listed in auth.keys; remove it from the config instead",
+				Type:    "invalid_request_error",
+			}
+		}
+	}
+	if auth.KeyFile != "" {
+		keys, err := readKeyFile(auth.KeyFile)
+		if err != nil {
+			return keyStoreError(err)
+		}
+		for _, key := range keys {
+			if usage.KeyID(key) == id {
+				return requestError{
+					Status:  http.StatusConflict,
+					Message: "key " + id + " is listed in auth.key_file; remove it from the file instead",
+					Type:    "invalid_request_error",
+				}
+			}
+		}
+	}
+	revoked, err := s.revokeClientKey(c.Request().Context(), id)
+	if err != nil {
+		return keyStoreError(err)
+	}
+	if !revoked {
+		return requestError{
+			Status:  http.StatusNotFound,
+			Message: "key " + id + " not found",
+			Type:    "invalid_request_error",
+		}
+	}
+	slog.Warn("client key revoked through the admin API", "key_id", id)
+	return c.JSON(http.StatusOK, map[string]any{
+		"id":      id,
+		"revoked": true,
+	})
+}
+
+func keyStoreError(err error) error {
+	if errors.Is(err, errAuthDisabled) {
+		return requestError{
+			Status:  http.StatusConflict,
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+		}
+	}
+	return requestError{
+		Status:  http.StatusInternalServerError,
+		Message: err.Error(),
+		Type:    "server_error",
+	}
+}
+
+// handleAdminReload reloads the configuration as SIGHUP does and reports
+// whether it was applied. A configuration that fails to load leaves the
+// running one in place.
+func (s *Server) handleAdminReload(c echo.Context) error {
+	if s.reload == nil {
+		return requestError{
+			Status:  http.StatusNotImplemented,
+			Message: "this server has no configuration to reload",
+			Type:    "server_error",
+		}
+	}
+	if err := s.reload(c.Request().Context()); err != nil {
+		return requestError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: "config reload failed: " + err.Error(),
+			Type:    "invalid_request_error",
+			Code:    "reload_failed",
+		}
+	}
+	body := map[string]any{"reloaded": true}
+	if version := s.ConfigVersion(); version != "" {
+		body["config_version"] = version
+	}
+	return c.JSON(http.StatusOK, body)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/provider"
+	"gocode-router/internal/router"
+	"gocode-router/internal/usage"
+)
+
+// keyAttribution returns the upstream attribution configured for a client
+// key, keyed by provider name.
+func keyAttribution(keys map[string]config.ClientKeyConfig, key string) map[string]provider.Attribution {
+	if key == "" {
+		return nil
+	}
+	keyCfg, ok := keys[key]
+	if !ok || len(keyCfg.Attribution) == 0 {
+		return nil
+	}
+	out := make(map[string]provider.Attribution, len(keyCfg.Attribution))
+	for name, a := range keyCfg.Attribution {
+		out[name] = provider.Attribution{Organization: a.Organization, Project: a.Project}
+	}
+	return out
+}
+
+// attributeRequest carries the client key's ID, upstream attribution, tags
+// and arrival time in the request context, so routing hooks can match on
+// the key, providers bill the request to the right organization and
+// project, and usage is attributed to the tags and records its latency.
+func (s *Server) attributeRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := clientKey(c)
+		req := c.Request()
+		cfg := s.currentConfig()
+		ctx := withRequestStart(req.Context(), time.Now())
+		ctx = router.WithKeyID(ctx, usage.KeyID(key))
+		if attribution := keyAttribution(cfg.Keys, key); attribution != nil {
+			ctx = provider.WithAttribution(ctx, attribution)
+		}
+		ctx = provider.WithTags(ctx, headerTags(cfg.Tags, req.Header.Get(tagsHeader)), cfg.Tags.Upstream)
+		ctx = withCaptureOrigin(ctx, c)
+		ctx = withExperiments(ctx, c)
+		c.SetRequest(req.WithContext(ctx))
+		return next(c)
+	}
+}
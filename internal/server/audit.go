@@ -0,0 +1,55 @@
+package server
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"gocode-router/internal/audit"
+	"gocode-router/internal/usage"
+)
+
+// auditRequest attaches an audit entry to every request except health
+// checks and metric scrapes, for handlers to fill in as they learn what
+// the request did.
+func (s *Server) auditRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.auditor == nil || !audited(c.Request().URL.Path) {
+			return next(c)
+		}
+		ctx, _ := audit.WithEntry(c.Request().Context())
+		c.SetRequest(c.Request().WithContext(ctx))
+		return next(c)
+	}
+}
+
+func audited(path string) bool {
+	return !strings.HasPrefix(path, "/health") && path != "/metrics"
+}
+
+// finishAudit completes the audit entry of a finished request with its
+// outcome and writes it. Failures are logged rather than failing a request
+// that has already been answered.
+func (s *Server) finishAudit(c echo.Context, v middleware.RequestLoggerValues) {
+	entry := audit.FromContext(c.Request().Context())
+	if entry == nil {
+		return
+	}
+	rec := entry.Record()
+	rec.Time = v.StartTime.UTC()
+	rec.RequestID = v.RequestID
+	rec.Method = v.Method
+	rec.Endpoint = c.Path()
+	rec.Status = v.Status
+	rec.LatencyMS = v.Latency.Milliseconds()
+	rec.KeyID = usage.KeyID(clientKey(c))
+	rec.RemoteIP = v.RemoteIP
+	if v.Error != nil {
+		rec.Error = v.Error.Error()
+	}
+	if err := s.auditor.Write(rec); err != nil {
+		slog.Warn("failed to write audit record", "request_id", rec.RequestID, "error", err)
+	}
+}
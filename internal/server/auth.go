@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/keys"
+	"gocode-router/internal/usage"
+)
+
+// clientKeySet holds the SHA-256 of each allowed client key, so a lookup
+// takes the same time whatever the presented key shares with a real one.
+type clientKeySet map[[sha256.Size]byte]struct{}
+
+// clientKeyRefresh is how often the keys issued through the admin API are
+// reloaded, so keys issued or revoked by another instance sharing the
+// storage backend take effect here too.
+const clientKeyRefresh = 30 * time.Second
+
+// loadClientKeys reads the keys of the auth section, its key file and those
+// issued through the admin API. It returns nil when auth is off.
+func loadClientKeys(ctx context.Context, cfg config.AuthConfig, store keys.Store) (clientKeySet, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	set := make(clientKeySet)
+	for _, key := range cfg.Keys {
+		set[sha256.Sum256([]byte(strings.TrimSpace(key)))] = struct{}{}
+	}
+	if cfg.KeyFile != "" {
+		fileKeys, err := readKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range fileKeys {
+			set[sha256.Sum256([]byte(key))] = struct{}{}
+		}
+	}
+
+	issued, err := store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load client keys: %w", err)
+	}
+	for _, key := range issued {
+		var hash [sha256.Size]byte
+		if n, err := hex.Decode(hash[:], []byte(key.Hash)); err != nil || n != len(hash) {
+			slog.Warn("skipping client key with a malformed hash", "key_id", key.ID)
+			continue
+		}
+		set[hash] = struct{}{}
+	}
+	return set, nil
+}
+
+// readKeyFile returns the keys in a key file, skipping blank lines and
+// comments.
+func readKeyFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read auth.key_file: %w", err)
+	}
+	defer file.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+		if key == "" || strings.HasPrefix(key, "#") {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read auth.key_file: %w", err)
+	}
+	return keys, nil
+}
+
+// errAuthDisabled reports that client keys cannot be issued because the
+// router does not require them.
+var errAuthDisabled = errors.New("client keys can only be issued with auth enabled: set auth.keys, auth.key_file or auth.required")
+
+// createClientKey issues a new random key, keeping its hash in the storage
+// backend, and starts accepting it.
+func (s *Server) createClientKey(ctx context.Context) (keys.Key, string, error) {
+	if !s.currentConfig().Auth.Enabled() {
+		return keys.Key{}, "", errAuthDisabled
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return keys.Key{}, "", fmt.Errorf("generate client key: %w", err)
+	}
+	key := "sk-" + hex.EncodeToString(secret)
+
+	issued := keys.Key{ID: usage.KeyID(key), Hash: keys.Hash(key), CreatedAt: time.Now().UTC()}
+	if err := s.storage.Keys().Add(ctx, issued); err != nil {
+		return keys.Key{}, "", err
+	}
+	return issued, key, s.reloadClientKeys(ctx)
+}
+
+// revokeClientKey removes an issued key from the storage backend and stops
+// accepting it, reporting whether there was one.
+func (s *Server) revokeClientKey(ctx context.Context, id string) (bool, error) {
+	revoked, err := s.storage.Keys().Delete(ctx, id)
+	if err != nil || !revoked {
+		return false, err
+	}
+	return true, s.reloadClientKeys(ctx)
+}
+
+func (s *Server) reloadClientKeys(ctx context.Context) error {
+	set, err := loadClientKeys(ctx, s.currentConfig().Auth, s.storage.Keys())
+	if err != nil {
+		return err
+	}
+	s.cfgMu.Lock()
+	s.clientKeys = set
+	s.cfgMu.Unlock()
+	return nil
+}
+
+// refreshClientKeys reloads the client keys every clientKeyRefresh until
+// ctx ends. The keys already loaded stay in force when a reload fails.
+func (s *Server) refreshClientKeys(ctx context.Context) {
+	ticker := time.NewTicker(clientKeyRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.reloadClientKeys(ctx); err != nil {
+				slog.Warn("client key refresh failed; keeping existing keys", "error", err)
+			}
+		}
+	}
+}
+
+func (k clientKeySet) allows(key string) bool {
+	_, ok := k[sha256.Sum256([]byte(key))]
+	return ok
+}
+
+// requireClientKey rejects API requests without an allowed client key,
+// sent as a bearer token, in x-api-key or in x-goog-api-key, when auth is
+// configured.
+func (s *Server) requireClientKey(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		keys := s.currentClientKeys()
+		if keys == nil || !isAPIPath(c.Request().URL.Path) {
+			return next(c)
+		}
+		key := clientKey(c)
+		if key == "" {
+			return requestError{
+				Status:  http.StatusUnauthorized,
+				Message: "missing API key: send it as a bearer token in Authorization or in the x-api-key or x-goog-api-key header",
+				Type:    "invalid_request_error",
+				Code:    "missing_api_key",
+			}
+		}
+		if !keys.allows(key) {
+			return requestError{
+				Status:  http.StatusUnauthorized,
+				Message: "incorrect API key provided",
+				Type:    "invalid_request_error",
+				Code:    "invalid_api_key",
+			}
+		}
+		return next(c)
+	}
+}
+
+func (s *Server) currentClientKeys() clientKeySet {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.clientKeys
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/models"
+)
+
+// checkConversationBudget refuses requests for conversations that have
+// already exhausted their token ceiling. It returns the conversation ID so
+// the caller can record usage once the upstream responds.
+func (s *Server) checkConversationBudget(c echo.Context) (string, error) {
+	policy := s.currentConfig().Budgets.Conversation
+	if !policy.Enabled() {
+		return "", nil
+	}
+
+	conversationID := strings.TrimSpace(c.Request().Header.Get(policy.HeaderName()))
+	if conversationID == "" {
+		return "", nil
+	}
+
+	used, err := s.conversations.Used(c.Request().Context(), conversationID)
+	if err != nil {
+		// Fail open: an unavailable state backend should not take the proxy down.
+		slog.Warn("conversation budget lookup failed", "conversation", conversationID, "error", err)
+		return conversationID, nil
+	}
+	if used >= policy.MaxTokens {
+		return "", requestError{
+			Status:  http.StatusTooManyRequests,
+			Message: fmt.Sprintf("conversation %q exhausted its token budget (%d of %d tokens used)", conversationID, used, policy.MaxTokens),
+			Type:    "invalid_request_error",
+			Code:    "conversation_budget_exceeded",
+		}
+	}
+	return conversationID, nil
+}
+
+// recordConversationUsage charges the response usage to the conversation and
+// annotates the response with the remaining budget.
+func (s *Server) recordConversationUsage(c echo.Context, conversationID string, usage models.Usage) {
+	if conversationID == "" {
+		return
+	}
+
+	policy := s.currentConfig().Budgets.Conversation
+	if !policy.Enabled() {
+		return
+	}
+
+	used, err := s.conversations.Add(c.Request().Context(), conversationID, usage.TotalTokens, policy.TTL)
+	if err != nil {
+		slog.Warn("conversation budget update failed", "conversation", conversationID, "error", err)
+		return
+	}
+	remaining := policy.MaxTokens - used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	header := c.Response().Header()
+	header.Set("X-Conversation-Tokens-Used", strconv.Itoa(used))
+	header.Set("X-Conversation-Tokens-Remaining", strconv.Itoa(remaining))
+	if used >= policy.WarnThreshold() {
+		header.Set("X-Conversation-Budget-Warning", fmt.Sprintf("conversation has used %d of %d tokens", used, policy.MaxTokens))
+	}
+}
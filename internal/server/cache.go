@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/cache"
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/router"
+)
+
+// peerCacheTimeout bounds a lookup on another instance; a slow peer should
+// cost less than the upstream call a miss falls back to.
+const peerCacheTimeout = 2 * time.Second
+
+// cacheHeader reports whether a response came from the response cache.
+const cacheHeader = "X-Cache"
+
+// Values of cacheHeader. Requests the cache does not apply to get none.
+const (
+	cacheHit  = "HIT"
+	cacheMiss = "MISS"
+)
+
+// setCacheHeader reports the cache outcome of a request, if it was looked
+// up.
+func setCacheHeader(c echo.Context, result string) {
+	if result != "" {
+		c.Response().Header().Set(cacheHeader, result)
+	}
+}
+
+// routeChat dispatches a chat request through the router, answering from
+// the response cache when caching is enabled for the model and an entry
+// exists. Experiments are not cached, as their arms answer differently.
+// It returns cacheHit or cacheMiss when the cache was consulted.
+func (s *Server) routeChat(ctx context.Context, rt *router.Router, req models.UnifiedChatRequest) (*models.UnifiedChatResponse, models.Model, string, error) {
+	enabled, ttl, maxEntryBytes := s.currentConfig().Cache.ForModel(req.Model)
+	if !enabled || req.Stream || rt.IsExperiment(req.Model) {
+		resp, modelInfo, err := rt.Chat(ctx, req)
+		return resp, modelInfo, "", err
+	}
+
+	key, err := cache.ChatKey(req)
+	if err != nil {
+		slog.Warn("cache key derivation failed", "error", err)
+		resp, modelInfo, err := rt.Chat(ctx, req)
+		return resp, modelInfo, "", err
+	}
+
+	if data, ok := s.cacheLookup(ctx, key); ok {
+		resp, modelInfo, err := cache.DecodeChat(data)
+		if err == nil {
+			return resp, modelInfo, cacheHit, nil
+		}
+		slog.Warn("discarding unreadable cache entry", "error", err)
+	}
+
+	resp, modelInfo, err := rt.Chat(ctx, req)
+	if err != nil || resp == nil {
+		return resp, modelInfo, cacheMiss, err
+	}
+
+	data, err := cache.EncodeChat(modelInfo, resp)
+	if err != nil {
+		slog.Warn("cache encode failed", "error", err)
+		return resp, modelInfo, cacheMiss, nil
+	}
+	s.cacheStore(ctx, key, data, ttl, maxEntryBytes)
+	return resp, modelInfo, cacheMiss, nil
+}
+
+// routeCompletion is routeChat for legacy completion requests.
+func (s *Server) routeCompletion(ctx context.Context, rt *router.Router, req models.UnifiedCompletionRequest) (*models.UnifiedCompletionResponse, models.Model, string, error) {
+	enabled, ttl, maxEntryBytes := s.currentConfig().Cache.ForModel(req.Model)
+	if !enabled || req.Stream || rt.IsExperiment(req.Model) {
+		resp, modelInfo, err := rt.Completion(ctx, req)
+		return resp, modelInfo, "", err
+	}
+
+	key, err := cache.CompletionKey(req)
+	if err != nil {
+		slog.Warn("cache key derivation failed", "error", err)
+		resp, modelInfo, err := rt.Completion(ctx, req)
+		return resp, modelInfo, "", err
+	}
+
+	if data, ok := s.cacheLookup(ctx, key); ok {
+		resp, modelInfo, err := cache.DecodeCompletion(data)
+		if err == nil {
+			return resp, modelInfo, cacheHit, nil
+		}
+		slog.Warn("discarding unreadable cache entry", "error", err)
+	}
+
+	resp, modelInfo, err := rt.Completion(ctx, req)
+	if err != nil || resp == nil {
+		return resp, modelInfo, cacheMiss, err
+	}
+
+	data, err := cache.EncodeCompletion(modelInfo, resp)
+	if err != nil {
+		slog.Warn("cache encode failed", "error", err)
+		return resp, modelInfo, cacheMiss, nil
+	}
+	s.cacheStore(ctx, key, data, ttl, maxEntryBytes)
+	return resp, modelInfo, cacheMiss, nil
+}
+
+func (s *Server) cacheLookup(ctx context.Context, key string) ([]byte, bool) {
+	data, ok, err := s.cache.Get(ctx, key)
+	if err != nil {
+		slog.Warn("cache lookup failed", "error", err)
+		return nil, false
+	}
+	return data, ok
+}
+
+// cacheStore keeps an entry unless it is larger than maxEntryBytes.
+func (s *Server) cacheStore(ctx context.Context, key string, data []byte, ttl time.Duration, maxEntryBytes int) {
+	if maxEntryBytes > 0 && len(data) > maxEntryBytes {
+		slog.Debug("response too large to cache", "bytes", len(data), "limit", maxEntryBytes)
+		return
+	}
+	if err := s.cache.Set(ctx, key, data, ttl); err != nil {
+		slog.Warn("cache store failed", "error", err)
+	}
+}
+
+// partitionCache spreads the local cache across the configured peers. The
+// local store stays reachable on the peer endpoint for the keys this
+// instance owns.
+func (s *Server) partitionCache(peers config.CachePeersConfig) error {
+	client := &http.Client{Timeout: peerCacheTimeout}
+	partitioned, err := cache.NewPeerStore(s.cache, peers.Self, peers.URLs, peers.VirtualNodes, peers.Secret, client)
+	if err != nil {
+		return fmt.Errorf("initialise cache peers: %w", err)
+	}
+	s.peerCache = s.cache
+	s.cache = partitioned
+	return nil
+}
+
+// handleCachePeerGet serves a locally owned cache entry to another instance.
+func (s *Server) handleCachePeerGet(c echo.Context) error {
+	if err := s.authorizeCachePeer(c); err != nil {
+		return err
+	}
+	data, ok, err := s.peerCache.Get(c.Request().Context(), c.Param("key"))
+	if err != nil {
+		slog.Warn("peer cache lookup failed", "error", err)
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "cache lookup failed",
+			Type:    "server_error",
+		}
+	}
+	if !ok {
+		return c.NoContent(http.StatusNotFound)
+	}
+	return c.Blob(http.StatusOK, echo.MIMEOctetStream, data)
+}
+
+// handleCachePeerSet stores an entry another instance computed for a key
+// this instance owns.
+func (s *Server) handleCachePeerSet(c echo.Context) error {
+	if err := s.authorizeCachePeer(c); err != nil {
+		return err
+	}
+
+	req := c.Request()
+	ttlMillis, err := strconv.ParseInt(req.Header.Get(cache.PeerTTLHeader), 10, 64)
+	if err != nil || ttlMillis <= 0 {
+		return requestError{
+			Status:  http.StatusBadRequest,
+			Message: cache.PeerTTLHeader + " must be a positive number of milliseconds",
+			Type:    "invalid_request_error",
+		}
+	}
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return requestError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Message: "cache entry too large",
+			Type:    "invalid_request_error",
+		}
+	}
+
+	if err := s.peerCache.Set(req.Context(), c.Param("key"), data, time.Duration(ttlMillis)*time.Millisecond); err != nil {
+		slog.Warn("peer cache store failed", "error", err)
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "cache store failed",
+			Type:    "server_error",
+		}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// authorizeCachePeer hides the peer endpoint unless the cache is
+// partitioned, and checks the shared secret, which partitioning requires.
+func (s *Server) authorizeCachePeer(c echo.Context) error {
+	if s.peerCache == nil {
+		return echo.ErrNotFound
+	}
+	secret := s.currentConfig().Cache.Peers.Secret
+	presented := c.Request().Header.Get(cache.PeerSecretHeader)
+	if secret == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(secret)) != 1 {
+		return requestError{
+			Status:  http.StatusUnauthorized,
+			Message: "invalid cache peer secret",
+			Type:    "authentication_error",
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/audit"
+	"gocode-router/internal/capture"
+	"gocode-router/internal/models"
+	"gocode-router/internal/usage"
+)
+
+// captureChat records a chat exchange when the capture policy wants it.
+func (s *Server) captureChat(c echo.Context, req models.UnifiedChatRequest, modelInfo models.Model, resp *models.UnifiedChatResponse) {
+	options, metadata := capture.SplitOptions(req.Options)
+	s.capture(c, capture.Record{
+		Model:         req.Model,
+		Provider:      modelInfo.Provider,
+		UpstreamModel: modelInfo.ID,
+		Messages:      capture.Messages(req.Messages),
+		Options:       options,
+		Metadata:      metadata,
+		Response:      resp.Message.Content,
+		FinishReason:  resp.FinishReason,
+		Usage:         capture.UsageOf(resp.Usage),
+	})
+}
+
+// captureCompletion records a text completion exchange when the capture
+// policy wants it.
+func (s *Server) captureCompletion(c echo.Context, req models.UnifiedCompletionRequest, modelInfo models.Model, resp *models.UnifiedCompletionResponse) {
+	options, metadata := capture.SplitOptions(req.Options)
+	s.capture(c, capture.Record{
+		Model:         req.Model,
+		Provider:      modelInfo.Provider,
+		UpstreamModel: modelInfo.ID,
+		Prompt:        req.Prompt,
+		Options:       options,
+		Metadata:      metadata,
+		Response:      resp.Text,
+		FinishReason:  resp.FinishReason,
+		Usage:         capture.UsageOf(resp.Usage),
+	})
+}
+
+// capture fills in the request details of rec and writes it. Requests
+// carrying the opt-out header are never captured, though they are still
+// audited, and failures are logged rather than failing a request that has
+// already been answered.
+func (s *Server) capture(c echo.Context, rec capture.Record) {
+	audit.FromContext(c.Request().Context()).SetExchange(rec)
+	if c.Request().Header.Get(capture.OptOutHeader) != "" {
+		return
+	}
+	rec.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	rec.KeyID = usage.KeyID(clientKey(c))
+	rec.Endpoint = c.Path()
+	s.writeCapture(rec)
+}
+
+// writeCapture writes rec, whose request details are filled in, if the
+// capture policy wants it.
+func (s *Server) writeCapture(rec capture.Record) {
+	if s.recorder == nil {
+		return
+	}
+	policy := s.capturePolicy()
+	if !policy.Wants(rec.RequestID, rec.KeyID, rec.UpstreamModel) {
+		return
+	}
+	rec.Time = time.Now().UTC()
+	policy.Apply(&rec)
+	if err := s.recorder.Write(rec); err != nil {
+		slog.Warn("failed to capture exchange", "request_id", rec.RequestID, "error", err)
+	}
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/router"
+	"gocode-router/internal/tokens"
+	"gocode-router/internal/translator"
+	"gocode-router/internal/usage"
+)
+
+// chatEncoder writes the chunks of a chat stream in one API's event format.
+type chatEncoder interface {
+	// chunk writes a chunk; the first one opens the response.
+	chunk(c models.UnifiedChatChunk) error
+	// finish closes a response that streamed to the end.
+	finish(resp *models.UnifiedChatResponse) error
+	// fail reports an upstream failure after the response has started.
+	fail(err error)
+}
+
+// streamedRequest is a request relayed as a stream by relayStream.
+type streamedRequest struct {
+	// open routes the request and starts the upstream stream.
+	open func(ctx context.Context) (models.UnifiedChatStream, models.Model, error)
+	// promptTokens counts the prompt as modelInfo receives it.
+	promptTokens func(modelInfo models.Model) int
+	// capture records the finished exchange.
+	capture func(modelInfo models.Model, resp *models.UnifiedChatResponse)
+}
+
+// relayChatStream routes req as a stream and writes it to the client with
+// the encoder newEncoder returns.
+func (s *Server) relayChatStream(c echo.Context, rt *router.Router, req models.UnifiedChatRequest, conversationID string, newEncoder func(stream *sseWriter, modelID string) chatEncoder) error {
+	return s.relayStream(c, rt, streamedRequest{
+		open: func(ctx context.Context) (models.UnifiedChatStream, models.Model, error) {
+			return rt.ChatStream(ctx, req)
+		},
+		promptTokens: func(modelInfo models.Model) int {
+			return tokens.CountPrompt(rt.Tokenizer(modelInfo.ID), rt.PromptMessages(modelInfo, req.Messages), req.Tools)
+		},
+		capture: func(modelInfo models.Model, resp *models.UnifiedChatResponse) {
+			s.captureChat(c, req, modelInfo, resp)
+		},
+	}, conversationID, newEncoder)
+}
+
+// relayCompletionStream routes a text completion as a stream and writes it
+// to the client with the encoder newEncoder returns.
+func (s *Server) relayCompletionStream(c echo.Context, rt *router.Router, req models.UnifiedCompletionRequest, conversationID string, newEncoder func(stream *sseWriter, modelID string) chatEncoder) error {
+	return s.relayStream(c, rt, streamedRequest{
+		open: func(ctx context.Context) (models.UnifiedChatStream, models.Model, error) {
+			return rt.CompletionStream(ctx, req)
+		},
+		promptTokens: func(modelInfo models.Model) int {
+			return len(rt.Tokenizer(modelInfo.ID).Encode(req.Prompt))
+		},
+		capture: func(modelInfo models.Model, resp *models.UnifiedChatResponse) {
+			s.captureCompletion(c, req, modelInfo, &models.UnifiedCompletionResponse{
+				Text:         resp.Message.Content,
+				Usage:        resp.Usage,
+				FinishReason: resp.FinishReason,
+				ID:           resp.ID,
+				Model:        resp.Model,
+			})
+		},
+	}, conversationID, newEncoder)
+}
+
+// relayStream routes req as a stream and writes it to the client with the
+// encoder newEncoder returns. The first chunk is awaited before the
+// response starts, so a request that fails upstream still gets an error
+// status. A stream whose answer runs past the client's or the model's
+// output cap is cut off there and finishes with reason length. Usage is
+// accounted for however the stream ends, including when the client goes
+// away or the upstream fails part way.
+func (s *Server) relayStream(c echo.Context, rt *router.Router, req streamedRequest, conversationID string, newEncoder func(stream *sseWriter, modelID string) chatEncoder) error {
+	ctx := c.Request().Context()
+	upstream, modelInfo, err := req.open(ctx)
+	if err != nil {
+		return toHTTPError(err)
+	}
+	defer upstream.Close()
+
+	first, err := upstream.Recv()
+	if errors.Is(err, io.EOF) {
+		return requestError{
+			Status:  http.StatusBadGateway,
+			Message: "upstream provider returned an empty response",
+			Type:    "upstream_error",
+		}
+	}
+	if err != nil {
+		return toHTTPError(err)
+	}
+
+	s.recordProvenance(c, modelInfo, first.Model, first.ID)
+	stream, err := startStream(c, s.currentConfig().Server.Streaming, s.streamFaults(modelInfo.ID))
+	if err != nil {
+		return err
+	}
+	defer stream.close()
+	encoder := newEncoder(stream, modelInfo.ID)
+
+	// Tokens spent upstream count against budgets and quotas however the
+	// stream ends; a client can't dodge them by disconnecting early.
+	resp := &models.UnifiedChatResponse{}
+	status, finished := http.StatusOK, false
+	defer func() {
+		spent := resp.Usage
+		if !finished {
+			spent = spentUsage(rt, modelInfo, req, resp)
+		}
+		// The request's context is done once the client has gone.
+		ctx := context.WithoutCancel(ctx)
+		c.SetRequest(c.Request().WithContext(ctx))
+		s.recordConversationUsage(c, conversationID, spent)
+		s.recordUsageStatus(ctx, usage.KeyID(clientKey(c)), modelInfo, spent, status)
+	}()
+
+	guard := s.newOutputGuard(c, rt, modelInfo)
+	for chunk := first; ; {
+		if guard.exceeded(chunk) {
+			slog.Warn("stream cut off past the output cap", "model", modelInfo.ID, "limit", guard.limit)
+			chunk = models.UnifiedChatChunk{ID: resp.ID, Model: resp.Model, FinishReason: "length"}
+			resp.AddChunk(chunk)
+			resp.Usage = spentUsage(rt, modelInfo, req, resp)
+			if err := encoder.chunk(chunk); err != nil {
+				slog.Error("failed to write SSE chunk", "err", err)
+				return err
+			}
+			break
+		}
+		resp.AddChunk(chunk)
+		if err := encoder.chunk(chunk); err != nil {
+			slog.Error("failed to write SSE chunk", "err", err)
+			return err
+		}
+		chunk, err = upstream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// The status has been sent; the client learns of the failure
+			// from an error event instead.
+			slog.Warn("upstream stream failed", "model", modelInfo.ID, "error", err)
+			encoder.fail(err)
+			status = streamFailure(err).Status
+			return nil
+		}
+	}
+	finished = true
+	if err := encoder.finish(resp); err != nil {
+		slog.Error("failed to finish SSE stream", "err", err)
+		return err
+	}
+
+	req.capture(modelInfo, resp)
+	return nil
+}
+
+// spentUsage returns the usage of a stream cut short: what the upstream
+// reported so far, with the prompt and the answer sent until then counted
+// with the model's local tokenizer where it reported none.
+func spentUsage(rt *router.Router, modelInfo models.Model, req streamedRequest, resp *models.UnifiedChatResponse) models.Usage {
+	spent := resp.Usage
+	tokenizer := rt.Tokenizer(modelInfo.ID)
+	if spent.PromptTokens == 0 {
+		spent.PromptTokens = req.promptTokens(modelInfo)
+	}
+	if spent.CompletionTokens == 0 {
+		spent.CompletionTokens = len(tokenizer.Encode(resp.Message.Content))
+		for _, call := range resp.Message.ToolCalls {
+			spent.CompletionTokens += len(tokenizer.Encode(call.Name)) + len(tokenizer.Encode(call.Arguments))
+		}
+	}
+	spent.TotalTokens = spent.PromptTokens + spent.CompletionTokens
+	return spent
+}
+
+// streamFailure is how a failure mid-stream is reported to the client.
+func streamFailure(err error) requestError {
+	var httpErr requestError
+	errors.As(toHTTPError(err), &httpErr)
+	return httpErr
+}
+
+// openAIChunks writes chat.completion.chunk events ending with [DONE],
+// preceded by a usage chunk when the client asked for one.
+type openAIChunks struct {
+	stream       *sseWriter
+	encoder      *translator.ChatChunkEncoder
+	includeUsage bool
+}
+
+// newOpenAIChunks returns a constructor of OpenAI chunk encoders.
+func newOpenAIChunks(includeUsage bool) func(stream *sseWriter, modelID string) chatEncoder {
+	return func(stream *sseWriter, modelID string) chatEncoder {
+		return &openAIChunks{
+			stream:       stream,
+			encoder:      translator.NewChatChunkEncoder(modelID, time.Now().Unix()),
+			includeUsage: includeUsage,
+		}
+	}
+}
+
+func (e *openAIChunks) chunk(c models.UnifiedChatChunk) error {
+	if chunk, ok := e.encoder.Encode(c); ok {
+		return e.stream.data(chunk)
+	}
+	return nil
+}
+
+func (e *openAIChunks) finish(resp *models.UnifiedChatResponse) error {
+	if e.includeUsage {
+		if err := e.stream.data(e.encoder.Usage(resp.Usage)); err != nil {
+			return err
+		}
+	}
+	return e.stream.done()
+}
+
+func (e *openAIChunks) fail(err error) {
+	writeOpenAIStreamError(e.stream, err)
+}
+
+// writeOpenAIStreamError reports a failure mid-stream as OpenAI does, in an
+// event holding only the error.
+func writeOpenAIStreamError(stream *sseWriter, err error) {
+	httpErr := streamFailure(err)
+	if err := stream.data(map[string]any{"error": map[string]any{
+		"message": httpErr.Message,
+		"type":    httpErr.Type,
+	}}); err != nil {
+		slog.Error("failed to write SSE error", "err", err)
+	}
+}
+
+// completionChunks writes a legacy text_completion stream: a chunk per text
+// delta, a final chunk with each choice's finish reason and the usage, then
+// [DONE]. Every chunk carries the ID of the first.
+type completionChunks struct {
+	stream  *sseWriter
+	id      string
+	modelID string
+	created int64
+}
+
+func newCompletionChunks(stream *sseWriter, modelID string) chatEncoder {
+	return &completionChunks{stream: stream, modelID: modelID, created: time.Now().Unix()}
+}
+
+func (e *completionChunks) chunk(c models.UnifiedChatChunk) error {
+	if e.id == "" {
+		e.id = c.ID
+	}
+	if c.Content == "" {
+		return nil
+	}
+	return e.stream.data(e.response([]translator.CompletionChoice{{Text: c.Content, Index: c.Index}}, nil))
+}
+
+func (e *completionChunks) finish(resp *models.UnifiedChatResponse) error {
+	final := []translator.CompletionChoice{{Index: 0, FinishReason: resp.FinishReason}}
+	for i, choice := range resp.ExtraChoices {
+		final = append(final, translator.CompletionChoice{Index: i + 1, FinishReason: choice.FinishReason})
+	}
+	var u *translator.OpenAIUsage
+	if resp.Usage != (models.Usage{}) {
+		u = &translator.OpenAIUsage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+			TotalTokens:      resp.Usage.PromptTokens + resp.Usage.CompletionTokens,
+		}
+	}
+	if err := e.stream.data(e.response(final, u)); err != nil {
+		return err
+	}
+	return e.stream.done()
+}
+
+func (e *completionChunks) fail(err error) {
+	writeOpenAIStreamError(e.stream, err)
+}
+
+func (e *completionChunks) response(choices []translator.CompletionChoice, u *translator.OpenAIUsage) translator.CompletionResponse {
+	return translator.CompletionResponse{
+		ID:      e.id,
+		Object:  "text_completion",
+		Created: e.created,
+		Model:   e.modelID,
+		Choices: choices,
+		Usage:   u,
+	}
+}
+
+// claudeEvents writes Anthropic message stream events.
+type claudeEvents struct {
+	stream  *sseWriter
+	encoder *translator.ClaudeStreamEncoder
+}
+
+func newClaudeEvents(stream *sseWriter, modelID string) chatEncoder {
+	return &claudeEvents{stream: stream, encoder: translator.NewClaudeStreamEncoder(modelID)}
+}
+
+func (e *claudeEvents) chunk(c models.UnifiedChatChunk) error {
+	return e.write(e.encoder.Encode(c))
+}
+
+func (e *claudeEvents) finish(resp *models.UnifiedChatResponse) error {
+	return e.write(e.encoder.Finish(resp.FinishReason, resp.Usage))
+}
+
+func (e *claudeEvents) fail(err error) {
+	if err := e.write([]translator.ClaudeStreamEvent{translator.ClaudeStreamError(streamFailure(err).Message)}); err != nil {
+		slog.Error("failed to write SSE error", "err", err)
+	}
+}
+
+func (e *claudeEvents) write(events []translator.ClaudeStreamEvent) error {
+	for _, event := range events {
+		if err := e.stream.event(event.Name, event.Payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
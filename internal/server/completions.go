@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/completions"
+	"gocode-router/internal/translator"
+	"gocode-router/internal/usage"
+)
+
+// storeCompletion saves a chat completion the client asked to store under
+// an ID of its own, which replaces the upstream's in resp. Upstream IDs are
+// not unique per request: a cached or fixture response repeats one, and
+// would overwrite another key's completion. Failures are logged rather than
+// failing a request that has already been answered upstream.
+func (s *Server) storeCompletion(ctx context.Context, keyID string, req translator.ChatCompletionRequest, resp *translator.ChatCompletionResponse) {
+	if !req.Store {
+		return
+	}
+	id, err := newCompletionID()
+	if err != nil {
+		slog.Warn("failed to store completion", "error", err)
+		return
+	}
+	resp.ID = id
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		slog.Warn("failed to store completion", "id", resp.ID, "error", err)
+		return
+	}
+	err = s.storage.Completions().Save(ctx, completions.Completion{
+		ID:        resp.ID,
+		KeyID:     keyID,
+		Model:     resp.Model,
+		Metadata:  req.Metadata,
+		Response:  body,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		slog.Warn("failed to store completion", "id", resp.ID, "error", err)
+	}
+}
+
+// handleGetStoredCompletion returns a completion saved with store=true. Only
+// the client key that created it can read it back.
+func (s *Server) handleGetStoredCompletion(c echo.Context) error {
+	id := c.Param("id")
+	completion, ok, err := s.storage.Completions().Get(c.Request().Context(), id)
+	if err != nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "failed to load completion",
+			Type:    "server_error",
+		}
+	}
+	if !ok || completion.KeyID != usage.KeyID(clientKey(c)) {
+		return requestError{
+			Status:  http.StatusNotFound,
+			Message: fmt.Sprintf("completion %q not found", id),
+			Type:    "invalid_request_error",
+		}
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(completion.Response, &body); err != nil {
+		return fmt.Errorf("decode stored completion %s: %w", id, err)
+	}
+	if completion.Metadata != nil {
+		body["metadata"] = completion.Metadata
+	}
+	return c.JSON(http.StatusOK, body)
+}
+
+func newCompletionID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate completion id: %w", err)
+	}
+	return "chatcmpl-" + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/translator"
+	"gocode-router/internal/usage"
+)
+
+// handleCopilotCompletions serves /v1/engines/:engine/completions for editors
+// pointed at a Copilot proxy, resolving the engine to a configured model.
+func (s *Server) handleCopilotCompletions(c echo.Context) error {
+	cfg := s.currentConfig()
+	if !cfg.Copilot.Enabled {
+		return echo.ErrNotFound
+	}
+
+	var req translator.CopilotCompletionRequest
+	if err := decodeRequestBody(c, &req); err != nil {
+		return err
+	}
+
+	conversationID, err := s.checkConversationBudget(c)
+	if err != nil {
+		return err
+	}
+
+	model := copilotModel(cfg.Copilot, c.Param("engine"))
+	requestedStream, err := s.streamRequested(c, req.Stream)
+	if err != nil {
+		return err
+	}
+	unifiedReq := req.ToUnified(model)
+	unifiedReq.Stream = false
+	s.capCompletionOutput(c, &unifiedReq)
+
+	rt, release := s.acquireRouter()
+	defer release()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+
+	if requestedStream {
+		return s.relayCompletionStream(c, rt, unifiedReq, conversationID, newCompletionChunks)
+	}
+
+	resp, modelInfo, cached, err := s.routeCompletion(c.Request().Context(), rt, unifiedReq)
+	setCacheHeader(c, cached)
+	if err != nil {
+		return toHTTPError(err)
+	}
+	if resp == nil {
+		return requestError{
+			Status:  http.StatusBadGateway,
+			Message: "upstream provider returned an empty response",
+			Type:    "upstream_error",
+		}
+	}
+
+	s.recordConversationUsage(c, conversationID, resp.Usage)
+	s.recordUsage(c.Request().Context(), usage.KeyID(clientKey(c)), modelInfo, resp.Usage)
+	s.annotateCompletionProvenance(c, modelInfo, resp)
+	s.captureCompletion(c, unifiedReq, modelInfo, resp)
+
+	openAIResp := translator.FromUnifiedCompletion(modelInfo.ID, time.Now().Unix(), resp)
+	return c.JSON(http.StatusOK, openAIResp)
+}
+
+func copilotModel(copilot config.CopilotConfig, engine string) string {
+	if model, ok := copilot.Engines[engine]; ok {
+		return strings.TrimSpace(model)
+	}
+	if model := strings.TrimSpace(copilot.DefaultModel); model != "" {
+		return model
+	}
+	return engine
+}
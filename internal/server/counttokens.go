@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/tokens"
+	"gocode-router/internal/translator"
+)
+
+type countTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+// handleClaudeCountTokens serves Anthropic's messages count_tokens
+// endpoint. Claude-style models ask their upstream; other models, routing
+// policies and upstreams that fail to answer get an estimate from the local
+// tokenizer.
+func (s *Server) handleClaudeCountTokens(c echo.Context) error {
+	req := c.Request()
+	raw, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		if tooLarge, ok := bodyTooLarge(err); ok {
+			return tooLarge
+		}
+		return requestError{
+			Status:  http.StatusBadRequest,
+			Message: "request body could not be read: " + err.Error(),
+			Type:    "invalid_request_error",
+		}
+	}
+	req.Body = io.NopCloser(bytes.NewReader(raw))
+	var msgReq translator.ClaudeMessageRequest
+	if err := decodeRequestBody(c, &msgReq); err != nil {
+		return err
+	}
+
+	rt, release := s.acquireRouter()
+	defer release()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+
+	header := make(http.Header)
+	for _, name := range passthroughHeaders {
+		if value := req.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	count, modelInfo, counted, err := rt.CountTokens(req.Context(), msgReq.Model, raw, header)
+	switch {
+	case counted:
+		return c.JSON(http.StatusOK, countTokensResponse{InputTokens: count})
+	case err != nil && modelInfo.ID == "":
+		return toHTTPError(err)
+	case err != nil:
+		slog.Warn("upstream token count failed; estimating locally", "model", modelInfo.ID, "error", err)
+	}
+
+	// A routing policy has no single model; its name picks the tokenizer.
+	unified := msgReq.ToUnified()
+	messages, tokenizer := unified.Messages, rt.Tokenizer(msgReq.Model)
+	if modelInfo.ID != "" {
+		messages, tokenizer = rt.PromptMessages(modelInfo, messages), rt.Tokenizer(modelInfo.ID)
+	}
+	count = tokens.CountPrompt(tokenizer, messages, unified.Tools)
+	return c.JSON(http.StatusOK, countTokensResponse{InputTokens: count})
+}
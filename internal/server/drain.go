@@ -0,0 +1,90 @@
+package server
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"gocode-router/internal/router"
+)
+
+// routerDrainTimeout bounds how long a replaced router waits for its
+// requests before its idle connections are closed regardless. Closing idle
+// connections never cuts a request short; it only stops connections the
+// late requests free from lingering until their idle timeout.
+const routerDrainTimeout = 10 * time.Minute
+
+// routerRef counts the requests in flight on one router. A reload swaps in
+// a new router for new requests while requests already admitted finish on
+// the old one, which is then retired.
+type routerRef struct {
+	rt *router.Router
+
+	mu       sync.Mutex
+	inflight int
+	retired  bool
+	drained  chan struct{}
+}
+
+func newRouterRef(rt *router.Router) *routerRef {
+	return &routerRef{rt: rt, drained: make(chan struct{})}
+}
+
+func (r *routerRef) acquire() func() {
+	r.mu.Lock()
+	r.inflight++
+	r.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.inflight--
+			if r.retired && r.inflight == 0 {
+				close(r.drained)
+			}
+		})
+	}
+}
+
+// retire waits, in the background, for the requests in flight on the
+// router to finish and then closes its providers' idle connections. The
+// caller must have swapped the router out so no request acquires it again.
+func (r *routerRef) retire() {
+	r.mu.Lock()
+	r.retired = true
+	inflight := r.inflight
+	if inflight == 0 {
+		close(r.drained)
+	}
+	r.mu.Unlock()
+
+	go func() {
+		start := time.Now()
+		select {
+		case <-r.drained:
+			if inflight > 0 {
+				slog.Info("previous router drained", "requests", inflight, "waited", time.Since(start))
+			}
+		case <-time.After(routerDrainTimeout):
+			r.mu.Lock()
+			left := r.inflight
+			r.mu.Unlock()
+			slog.Warn("previous router still serving requests after reload; closing its idle connections", "requests", left)
+		}
+		r.rt.CloseIdleConnections()
+	}()
+}
+
+// acquireRouter returns the current router and holds it until release is
+// called, so a reload meanwhile lets the request finish on it. Handlers
+// that call providers use it in place of currentRouter. The router is nil
+// before one is set; release may be called either way.
+func (s *Server) acquireRouter() (rt *router.Router, release func()) {
+	s.routerMu.RLock()
+	defer s.routerMu.RUnlock()
+	if s.router == nil {
+		return nil, func() {}
+	}
+	return s.router.rt, s.router.acquire()
+}
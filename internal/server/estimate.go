@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/tokens"
+	"gocode-router/internal/translator"
+)
+
+type estimateCall struct {
+	Role            string   `json:"role"`
+	Provider        string   `json:"provider"`
+	Model           string   `json:"model"`
+	Tokenizer       string   `json:"tokenizer"`
+	PromptTokens    int      `json:"prompt_tokens"`
+	MaxOutputTokens int      `json:"max_output_tokens,omitempty"`
+	Cost            *costEst `json:"cost,omitempty"`
+}
+
+// costEst prices a request in US dollars: the prompt alone, and the prompt
+// plus the full output allowance when every call has one.
+type costEst struct {
+	Prompt float64 `json:"prompt"`
+	Max    float64 `json:"max,omitempty"`
+}
+
+type estimateExperiment struct {
+	Name  string `json:"name"`
+	Arm   string `json:"arm"`
+	Model string `json:"model"`
+}
+
+type estimateResponse struct {
+	Object   string `json:"object"`
+	Model    string `json:"model"`
+	Schedule string `json:"schedule,omitempty"`
+	// Experiment is the experiment arm the request falls in.
+	Experiment *estimateExperiment `json:"experiment,omitempty"`
+	Policy     string              `json:"policy,omitempty"`
+	// PromptTokens is the largest prompt of any call.
+	PromptTokens int            `json:"prompt_tokens"`
+	Calls        []estimateCall `json:"calls"`
+	Cost         *costEst       `json:"cost,omitempty"`
+	Warnings     []string       `json:"warnings"`
+}
+
+// handleEstimate dry-runs a chat completion request: it applies the same
+// limits, hooks, plugins and routing as /v1/chat/completions, then reports
+// the upstream calls it would make with estimated tokens and cost instead
+// of sending them.
+func (s *Server) handleEstimate(c echo.Context) error {
+	var req translator.ChatCompletionRequest
+	if err := decodeRequestBody(c, &req); err != nil {
+		return err
+	}
+
+	unifiedReq := req.ToUnified()
+	requested, hadMax := maxTokensOf(unifiedReq.Options)
+	s.capChatOutput(c, &unifiedReq)
+	warnings := []string{}
+	if capped, _ := maxTokensOf(unifiedReq.Options); hadMax && capped != requested {
+		warnings = append(warnings, fmt.Sprintf("max_tokens would be capped from %d to %d by limits", requested, capped))
+	}
+
+	rt := s.currentRouter()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+	plan, err := rt.PlanChat(c.Request().Context(), unifiedReq)
+	if err != nil {
+		return toHTTPError(err)
+	}
+
+	cfg := s.currentConfig()
+	resp := estimateResponse{
+		Object:   "estimate",
+		Model:    req.Model,
+		Schedule: plan.Schedule,
+		Policy:   plan.Policy,
+		Calls:    make([]estimateCall, 0, len(plan.Calls)),
+		Warnings: append(warnings, plan.Warnings...),
+	}
+	if a := plan.Experiment; a != nil {
+		resp.Experiment = &estimateExperiment{Name: a.Experiment, Arm: a.Arm, Model: a.Model}
+	}
+	var total costEst
+	priced, bounded := false, true
+	for _, call := range plan.Calls {
+		tokenizer := rt.Tokenizer(call.Model.ID)
+		prompt := tokens.CountMessages(tokenizer, call.Messages)
+		resp.PromptTokens = max(resp.PromptTokens, prompt)
+		est := estimateCall{
+			Role:            call.Role,
+			Provider:        call.Model.Provider,
+			Model:           call.Model.ID,
+			Tokenizer:       tokenizer.Name(),
+			PromptTokens:    prompt,
+			MaxOutputTokens: call.MaxOutputTokens,
+		}
+		if window := call.Model.ContextWindow; window > 0 && prompt+call.MaxOutputTokens > window {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("%s has a context window of %d tokens; the request needs %d and would be rejected", call.Model.ID, window, prompt+call.MaxOutputTokens))
+		}
+		if call.SeesAnswers {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("the %s prompt also carries the other answers, which are not counted", call.Role))
+		}
+		if pricing, ok := cfg.Providers.Pricing(call.Model.Provider, call.Model.ID); ok {
+			est.Cost = &costEst{Prompt: pricing.Cost(prompt, 0)}
+			if call.MaxOutputTokens > 0 {
+				est.Cost.Max = pricing.Cost(prompt, call.MaxOutputTokens)
+			} else {
+				bounded = false
+			}
+			total.Prompt += est.Cost.Prompt
+			total.Max += est.Cost.Max
+			priced = true
+		} else {
+			resp.Warnings = append(resp.Warnings, fmt.Sprintf("%s has no pricing configured; it is left out of the cost", call.Model.ID))
+		}
+		resp.Calls = append(resp.Calls, est)
+	}
+	if priced {
+		if !bounded {
+			total.Max = 0
+		}
+		resp.Cost = &total
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func maxTokensOf(options map[string]any) (int, bool) {
+	switch v := options["max_tokens"].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"context"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/router"
+)
+
+// experimentHeader reports each experiment arm a request was routed to as
+// experiment=arm.
+const experimentHeader = "X-Router-Experiment"
+
+// withExperiments records the experiment arms the request is routed to and
+// reports them in the response headers.
+func withExperiments(ctx context.Context, c echo.Context) context.Context {
+	ctx = router.WithAssignments(ctx)
+	c.Response().Before(func() {
+		for _, a := range router.AssignmentsFrom(ctx) {
+			c.Response().Header().Add(experimentHeader, a.Experiment+"="+a.Arm)
+		}
+	})
+	return ctx
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/tokens"
+	"gocode-router/internal/translator"
+	"gocode-router/internal/usage"
+)
+
+// geminiMethods are the model methods the Gemini API surface serves.
+var geminiMethods = []string{"generateContent", "streamGenerateContent", "countTokens"}
+
+// handleGeminiMethod serves a method of a model in the Gemini API, which
+// names it after a colon: POST /v1beta/models/{model}:generateContent.
+func (s *Server) handleGeminiMethod(c echo.Context) error {
+	name := c.Param("*")
+	i := strings.LastIndex(name, ":")
+	if i <= 0 {
+		return geminiNotFound(name)
+	}
+	model, method := name[:i], name[i+1:]
+	switch method {
+	case "generateContent":
+		return s.handleGeminiGenerate(c, model, false)
+	case "streamGenerateContent":
+		return s.handleGeminiGenerate(c, model, true)
+	case "countTokens":
+		return s.handleGeminiCountTokens(c, model)
+	}
+	return geminiNotFound(name)
+}
+
+func geminiNotFound(name string) error {
+	return requestError{
+		Status:  http.StatusNotFound,
+		Message: "unknown Gemini method " + strconv.Quote(name) + "; expected models/{model}:" + strings.Join(geminiMethods, ", :"),
+		Type:    "invalid_request_error",
+	}
+}
+
+// handleGeminiGenerate serves generateContent and streamGenerateContent.
+// Streams are sent as server-sent events with alt=sse, as the Gemini SDKs
+// ask; without it the whole answer comes back as a one-element array.
+func (s *Server) handleGeminiGenerate(c echo.Context, model string, stream bool) error {
+	var req translator.GeminiRequest
+	if err := decodeRequestBody(c, &req); err != nil {
+		return err
+	}
+	req.Model = model
+
+	conversationID, err := s.checkConversationBudget(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	if stream {
+		if _, err := s.streamRequested(c, true); err != nil {
+			return err
+		}
+	}
+	unifiedReq := req.ToUnified()
+	s.capChatOutput(c, &unifiedReq)
+
+	rt, release := s.acquireRouter()
+	defer release()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+
+	if stream && c.QueryParam("alt") == "sse" {
+		return s.relayChatStream(c, rt, unifiedReq, conversationID, newGeminiResponses)
+	}
+
+	resp, modelInfo, cached, err := s.routeChat(ctx, rt, unifiedReq)
+	setCacheHeader(c, cached)
+	if err != nil {
+		return toHTTPError(err)
+	}
+	if resp == nil {
+		return requestError{
+			Status:  http.StatusBadGateway,
+			Message: "upstream provider returned an empty response",
+			Type:    "upstream_error",
+		}
+	}
+
+	s.recordConversationUsage(c, conversationID, resp.Usage)
+	s.recordUsage(ctx, usage.KeyID(clientKey(c)), modelInfo, resp.Usage)
+	s.annotateChatProvenance(c, modelInfo, resp)
+	s.captureChat(c, unifiedReq, modelInfo, resp)
+
+	geminiResp := translator.FromUnifiedGemini(modelInfo.ID, resp)
+	if stream {
+		return c.JSON(http.StatusOK, []translator.GeminiResponse{geminiResp})
+	}
+	return c.JSON(http.StatusOK, geminiResp)
+}
+
+type geminiCountTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+// handleGeminiCountTokens counts the prompt of a Gemini request, sent as
+// is or wrapped in generateContentRequest, with the local tokenizer.
+func (s *Server) handleGeminiCountTokens(c echo.Context, model string) error {
+	var body json.RawMessage
+	if err := decodeRequestBody(c, &body); err != nil {
+		return err
+	}
+	var wrapper struct {
+		GenerateContentRequest json.RawMessage `json:"generateContentRequest"`
+	}
+	if err := json.Unmarshal(body, &wrapper); err == nil && len(wrapper.GenerateContentRequest) > 0 {
+		body = wrapper.GenerateContentRequest
+	}
+	var req translator.GeminiRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return requestError{
+			Status:  http.StatusBadRequest,
+			Message: "invalid JSON payload: " + err.Error(),
+			Type:    "invalid_request_error",
+		}
+	}
+
+	rt, release := s.acquireRouter()
+	defer release()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+
+	// A routing policy has no single model; its name picks the tokenizer.
+	messages, tokenizer := req.Messages, rt.Tokenizer(model)
+	if modelInfo, err := rt.LookupModel(model); err == nil {
+		messages, tokenizer = rt.PromptMessages(modelInfo, messages), rt.Tokenizer(modelInfo.ID)
+	}
+	return c.JSON(http.StatusOK, geminiCountTokensResponse{TotalTokens: tokens.CountPrompt(tokenizer, messages, req.Tools)})
+}
+
+type geminiModel struct {
+	Name                       string   `json:"name"`
+	DisplayName                string   `json:"displayName"`
+	InputTokenLimit            int      `json:"inputTokenLimit,omitempty"`
+	OutputTokenLimit           int      `json:"outputTokenLimit,omitempty"`
+	SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+}
+
+type geminiModelList struct {
+	Models []geminiModel `json:"models"`
+}
+
+// handleGeminiListModels lists every configured model and alias in the
+// Gemini API's shape.
+func (s *Server) handleGeminiListModels(c echo.Context) error {
+	rt := s.currentRouter()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+	list := rt.Models()
+	resp := geminiModelList{Models: make([]geminiModel, 0, len(list))}
+	for _, model := range list {
+		resp.Models = append(resp.Models, toGeminiModel(model))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// handleGeminiGetModel describes a single model in the Gemini API's shape.
+func (s *Server) handleGeminiGetModel(c echo.Context) error {
+	rt := s.currentRouter()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+	id := c.Param("*")
+	for _, model := range rt.Models() {
+		if model.ID == id {
+			return c.JSON(http.StatusOK, toGeminiModel(model))
+		}
+	}
+	return requestError{
+		Status:  http.StatusNotFound,
+		Message: "model " + strconv.Quote(id) + " not found",
+		Type:    "invalid_request_error",
+		Code:    "model_not_found",
+	}
+}
+
+func toGeminiModel(model models.Model) geminiModel {
+	name := model.DisplayName
+	if name == "" {
+		name = model.ID
+	}
+	input := model.ContextWindow
+	if input > 0 && model.MaxOutputTokens > 0 {
+		input -= model.MaxOutputTokens
+	}
+	return geminiModel{
+		Name:                       "models/" + model.ID,
+		DisplayName:                name,
+		InputTokenLimit:            input,
+		OutputTokenLimit:           model.MaxOutputTokens,
+		SupportedGenerationMethods: geminiMethods,
+	}
+}
+
+// geminiResponses writes the responses of a streamGenerateContent stream
+// as server-sent events.
+type geminiResponses struct {
+	stream  *sseWriter
+	encoder *translator.GeminiStreamEncoder
+}
+
+func newGeminiResponses(stream *sseWriter, modelID string) chatEncoder {
+	return &geminiResponses{stream: stream, encoder: translator.NewGeminiStreamEncoder(modelID)}
+}
+
+func (e *geminiResponses) chunk(c models.UnifiedChatChunk) error {
+	if resp, ok := e.encoder.Encode(c); ok {
+		return e.stream.data(resp)
+	}
+	return nil
+}
+
+func (e *geminiResponses) finish(resp *models.UnifiedChatResponse) error {
+	return e.stream.data(e.encoder.Finish(resp.FinishReason, resp.Usage))
+}
+
+func (e *geminiResponses) fail(err error) {
+	httpErr := streamFailure(err)
+	if err := e.stream.data(translator.NewGeminiError(httpErr.Status, httpErr.Message)); err != nil {
+		slog.Error("failed to write SSE error", "err", err)
+	}
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/jobs"
+	"gocode-router/internal/provider"
+	"gocode-router/internal/router"
+	"gocode-router/internal/translator"
+	"gocode-router/internal/usage"
+)
+
+const (
+	jobKindChat       = "/v1/chat/completions"
+	jobKindCompletion = "/v1/completions"
+)
+
+// jobRequest mirrors a line of an OpenAI batch input file.
+type jobRequest struct {
+	URL  string          `json:"url"`
+	Body json.RawMessage `json:"body"`
+}
+
+// jobPayload is what the job store keeps for a queued request. The output
+// cap, usage key, attribution and header tags are resolved at submission
+// time because neither the client key nor the headers are stored.
+type jobPayload struct {
+	Body            json.RawMessage                 `json:"body"`
+	MaxOutputTokens int                             `json:"max_output_tokens,omitempty"`
+	KeyID           string                          `json:"key_id,omitempty"`
+	Attribution     map[string]provider.Attribution `json:"attribution,omitempty"`
+	Tags            map[string]string               `json:"tags,omitempty"`
+}
+
+type jobResponse struct {
+	ID        string          `json:"id"`
+	Object    string          `json:"object"`
+	URL       string          `json:"url"`
+	Status    jobs.Status     `json:"status"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt int64           `json:"created_at"`
+	UpdatedAt int64           `json:"updated_at"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+func newJobResponse(job jobs.Job) jobResponse {
+	return jobResponse{
+		ID:        job.ID,
+		Object:    "job",
+		URL:       job.Kind,
+		Status:    job.Status,
+		Attempts:  job.Attempts,
+		CreatedAt: job.CreatedAt.Unix(),
+		UpdatedAt: job.UpdatedAt.Unix(),
+		Response:  job.Result,
+		Error:     job.Error,
+	}
+}
+
+// newJobRunner builds the job runner when async jobs are enabled. The store
+// is fixed for the lifetime of the process.
+func (s *Server) newJobRunner() (*jobs.Runner, error) {
+	cfg := s.currentConfig().Jobs
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	store := s.storage.Jobs()
+	if cfg.Backend != "" {
+		var err error
+		if store, err = jobs.NewStore(cfg); err != nil {
+			return nil, fmt.Errorf("initialise job store: %w", err)
+		}
+	}
+	runner, err := jobs.NewRunner(store, jobs.Options{
+		Workers:     cfg.WorkerCount(),
+		MaxAttempts: cfg.Attempts(),
+		Retention:   cfg.RetentionPeriod(),
+	})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	runner.Handle(jobKindChat, s.runChatJob)
+	runner.Handle(jobKindCompletion, s.runCompletionJob)
+	return runner, nil
+}
+
+// handleCreateJob queues a chat or completion request for asynchronous
+// execution and returns immediately with the job ID.
+func (s *Server) handleCreateJob(c echo.Context) error {
+	if s.jobs == nil {
+		return echo.ErrNotFound
+	}
+
+	var req jobRequest
+	if err := decodeRequestBody(c, &req); err != nil {
+		return err
+	}
+
+	stream, err := decodeJobBody(req.URL, req.Body)
+	if err != nil {
+		return err
+	}
+	if stream {
+		return invalidJobRequest("streaming requests cannot run as jobs")
+	}
+
+	payload, err := s.newJobPayload(c, req.Body)
+	if err != nil {
+		return err
+	}
+
+	job, err := s.jobs.Submit(c.Request().Context(), req.URL, payload)
+	if err != nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "failed to queue job",
+			Type:    "server_error",
+		}
+	}
+	return c.JSON(http.StatusAccepted, newJobResponse(job))
+}
+
+func (s *Server) newJobPayload(c echo.Context, body json.RawMessage) (json.RawMessage, error) {
+	key := clientKey(c)
+	cfg := s.currentConfig()
+	payload, err := json.Marshal(jobPayload{
+		Body:            body,
+		MaxOutputTokens: outputTokenLimit(cfg.Limits, key),
+		KeyID:           usage.KeyID(key),
+		Attribution:     keyAttribution(cfg.Keys, key),
+		Tags:            provider.TagsFrom(c.Request().Context()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal job payload: %w", err)
+	}
+	return payload, nil
+}
+
+// handleGetJob reports the state of a job and, once finished, its response.
+// Only the client key that submitted the job can read it.
+func (s *Server) handleGetJob(c echo.Context) error {
+	if s.jobs == nil {
+		return echo.ErrNotFound
+	}
+
+	job, ok, err := s.jobs.Get(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "failed to load job",
+			Type:    "server_error",
+		}
+	}
+	if !ok || jobKeyID(job) != usage.KeyID(clientKey(c)) {
+		return requestError{
+			Status:  http.StatusNotFound,
+			Message: fmt.Sprintf("job %q not found", c.Param("id")),
+			Type:    "invalid_request_error",
+		}
+	}
+	return c.JSON(http.StatusOK, newJobResponse(job))
+}
+
+// jobKeyID returns the key ID of the client that submitted a job.
+func jobKeyID(job jobs.Job) string {
+	var payload jobPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return ""
+	}
+	return payload.KeyID
+}
+
+func (s *Server) runChatJob(ctx context.Context, raw json.RawMessage) (json.RawMessage, error) {
+	var payload jobPayload
+	var req translator.ChatCompletionRequest
+	if err := decodeJobPayload(raw, &payload, &req); err != nil {
+		return nil, err
+	}
+
+	unifiedReq := req.ToUnified()
+	unifiedReq.Options = router.CapOutputTokens(unifiedReq.Options, payload.MaxOutputTokens)
+	ctx = s.jobContext(ctx, payload)
+	ctx = withMetadataTags(ctx, s.currentConfig().Tags, unifiedReq.Options)
+
+	rt, release := s.acquireRouter()
+	defer release()
+	if rt == nil {
+		return nil, errors.New("router not initialised")
+	}
+	resp, modelInfo, _, err := s.routeChat(ctx, rt, unifiedReq)
+	if err != nil {
+		return nil, jobError(err)
+	}
+	if resp == nil {
+		return nil, errors.New("upstream provider returned an empty response")
+	}
+	s.recordUsage(ctx, payload.KeyID, modelInfo, resp.Usage)
+	openAIResp := translator.FromUnifiedChat(modelInfo.ID, time.Now().Unix(), resp)
+	s.storeCompletion(ctx, payload.KeyID, req, &openAIResp)
+	return json.Marshal(openAIResp)
+}
+
+func (s *Server) runCompletionJob(ctx context.Context, raw json.RawMessage) (json.RawMessage, error) {
+	var payload jobPayload
+	var req translator.CompletionRequest
+	if err := decodeJobPayload(raw, &payload, &req); err != nil {
+		return nil, err
+	}
+
+	unifiedReq := req.ToUnified()
+	if limit := payload.MaxOutputTokens; limit > 0 {
+		unifiedReq.Options = router.CapOutputTokens(unifiedReq.Options, limit)
+		if unifiedReq.MaxTokens <= 0 || unifiedReq.MaxTokens > limit {
+			unifiedReq.MaxTokens = limit
+		}
+	}
+	ctx = s.jobContext(ctx, payload)
+
+	rt, release := s.acquireRouter()
+	defer release()
+	if rt == nil {
+		return nil, errors.New("router not initialised")
+	}
+	resp, modelInfo, _, err := s.routeCompletion(ctx, rt, unifiedReq)
+	if err != nil {
+		return nil, jobError(err)
+	}
+	if resp == nil {
+		return nil, errors.New("upstream provider returned an empty response")
+	}
+	s.recordUsage(ctx, payload.KeyID, modelInfo, resp.Usage)
+	return json.Marshal(translator.FromUnifiedCompletion(modelInfo.ID, time.Now().Unix(), resp))
+}
+
+// jobContext restores the request context recorded in a job payload. The
+// job's latency is counted from when it starts running.
+func (s *Server) jobContext(ctx context.Context, payload jobPayload) context.Context {
+	ctx = withRequestStart(ctx, time.Now())
+	ctx = router.WithKeyID(provider.WithAttribution(ctx, payload.Attribution), payload.KeyID)
+	return provider.WithTags(ctx, payload.Tags, s.currentConfig().Tags.Upstream)
+}
+
+// decodeJobBody validates a request body for the given endpoint and reports
+// whether it asks for streaming.
+func decodeJobBody(url string, raw json.RawMessage) (stream bool, err error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return false, invalidJobRequest("body must be provided")
+	}
+
+	switch url {
+	case jobKindChat:
+		var body translator.ChatCompletionRequest
+		if err := json.Unmarshal(raw, &body); err != nil {
+			return false, invalidJobRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		return body.Stream, nil
+	case jobKindCompletion:
+		var body translator.CompletionRequest
+		if err := json.Unmarshal(raw, &body); err != nil {
+			return false, invalidJobRequest(fmt.Sprintf("invalid JSON payload: %v", err))
+		}
+		return body.Stream, nil
+	default:
+		return false, invalidJobRequest(fmt.Sprintf("url must be %q or %q", jobKindChat, jobKindCompletion))
+	}
+}
+
+func decodeJobPayload(raw json.RawMessage, payload *jobPayload, body any) error {
+	if err := json.Unmarshal(raw, payload); err != nil {
+		return jobs.Permanent(fmt.Errorf("decode job payload: %w", err))
+	}
+	if err := json.Unmarshal(payload.Body, body); err != nil {
+		return jobs.Permanent(fmt.Errorf("decode job body: %w", err))
+	}
+	return nil
+}
+
+// jobError marks errors caused by the request itself as permanent so the
+// runner does not retry them.
+func jobError(err error) error {
+	if errors.Is(err, provider.ErrUnknownModel) || errors.Is(err, provider.ErrUnsupportedOperation) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+func invalidJobRequest(message string) error {
+	return requestError{
+		Status:  http.StatusBadRequest,
+		Message: message,
+		Type:    "invalid_request_error",
+	}
+}
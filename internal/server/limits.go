@@ -0,0 +1,104 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/router"
+	"gocode-router/internal/tokens"
+)
+
+// clientKey extracts the credential presented by the client, preferring a
+// bearer token and falling back to the Anthropic-style x-api-key header,
+// then to Google's x-goog-api-key.
+func clientKey(c echo.Context) string {
+	header := c.Request().Header
+	if auth := strings.TrimSpace(header.Get("Authorization")); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	if key := strings.TrimSpace(header.Get("x-api-key")); key != "" {
+		return key
+	}
+	return strings.TrimSpace(header.Get("x-goog-api-key"))
+}
+
+// outputTokenLimit returns the strictest output cap that applies to the
+// request's client key, or zero when no cap is configured.
+func outputTokenLimit(limits config.LimitsConfig, key string) int {
+	limit := limits.MaxOutputTokens
+	if key == "" {
+		return limit
+	}
+	if keyLimits, ok := limits.Keys[key]; ok && keyLimits.MaxOutputTokens > 0 {
+		if limit <= 0 || keyLimits.MaxOutputTokens < limit {
+			limit = keyLimits.MaxOutputTokens
+		}
+	}
+	return limit
+}
+
+// capChatOutput applies the client's output token cap to a chat request.
+func (s *Server) capChatOutput(c echo.Context, req *models.UnifiedChatRequest) {
+	limit := outputTokenLimit(s.currentConfig().Limits, clientKey(c))
+	req.Options = router.CapOutputTokens(req.Options, limit)
+}
+
+// capCompletionOutput applies the client's output token cap to a completion request.
+func (s *Server) capCompletionOutput(c echo.Context, req *models.UnifiedCompletionRequest) {
+	limit := outputTokenLimit(s.currentConfig().Limits, clientKey(c))
+	if limit <= 0 {
+		return
+	}
+	req.Options = router.CapOutputTokens(req.Options, limit)
+	if req.MaxTokens <= 0 || req.MaxTokens > limit {
+		req.MaxTokens = limit
+	}
+}
+
+// streamCapSlack is how far past its output cap, as a fraction of the cap,
+// a streamed answer may run before it is cut. Answers are counted with the
+// local tokenizer, which only approximates the model's, so an upstream that
+// honours max_tokens is never cut.
+const streamCapSlack = 0.1
+
+// outputGuard cuts off a streamed answer that runs well past its output
+// cap, for upstreams that ignore max_tokens.
+type outputGuard struct {
+	tokenizer tokens.Tokenizer
+	limit     int
+	used      int
+}
+
+// newOutputGuard returns the guard of a stream from modelInfo for the
+// client's key, or nil when no cap applies.
+func (s *Server) newOutputGuard(c echo.Context, rt *router.Router, modelInfo models.Model) *outputGuard {
+	limit := outputTokenLimit(s.currentConfig().Limits, clientKey(c))
+	if modelInfo.MaxOutputTokens > 0 && (limit <= 0 || modelInfo.MaxOutputTokens < limit) {
+		limit = modelInfo.MaxOutputTokens
+	}
+	if limit <= 0 {
+		return nil
+	}
+	return &outputGuard{
+		tokenizer: rt.Tokenizer(modelInfo.ID),
+		limit:     limit + int(float64(limit)*streamCapSlack),
+	}
+}
+
+// exceeded counts the answer c carries and reports whether the stream has
+// run past its cap.
+func (g *outputGuard) exceeded(c models.UnifiedChatChunk) bool {
+	if g == nil {
+		return false
+	}
+	g.used += len(g.tokenizer.Encode(c.Content))
+	for _, call := range c.ToolCalls {
+		g.used += len(g.tokenizer.Encode(call.Name)) + len(g.tokenizer.Encode(call.Arguments))
+	}
+	return g.used > g.limit
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/usage"
+)
+
+const (
+	mcpProtocolVersion = "2025-03-26"
+	mcpServerName      = "gocode-router"
+	mcpServerVersion   = "0.1"
+
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpCallResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+// handleMCP serves the MCP streamable HTTP transport, exposing configured
+// tools that forward prompts to any registered model.
+func (s *Server) handleMCP(c echo.Context) error {
+	cfg := s.currentConfig()
+	if !cfg.MCP.Enabled {
+		return echo.ErrNotFound
+	}
+
+	req := c.Request()
+	defer req.Body.Close()
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return c.JSON(http.StatusOK, rpcFailure(nil, rpcParseError, "failed to read request body"))
+	}
+
+	var rpcReq rpcRequest
+	if err := json.Unmarshal(body, &rpcReq); err != nil {
+		return c.JSON(http.StatusOK, rpcFailure(nil, rpcParseError, "invalid JSON-RPC payload"))
+	}
+	if rpcReq.JSONRPC != "2.0" || rpcReq.Method == "" {
+		return c.JSON(http.StatusOK, rpcFailure(rpcReq.ID, rpcInvalidRequest, "invalid JSON-RPC request"))
+	}
+
+	// Notifications carry no id and expect no response body.
+	if len(rpcReq.ID) == 0 {
+		return c.NoContent(http.StatusAccepted)
+	}
+
+	switch rpcReq.Method {
+	case "initialize":
+		return c.JSON(http.StatusOK, rpcSuccess(rpcReq.ID, mcpInitializeResult(rpcReq.Params)))
+	case "ping":
+		return c.JSON(http.StatusOK, rpcSuccess(rpcReq.ID, struct{}{}))
+	case "tools/list":
+		return c.JSON(http.StatusOK, rpcSuccess(rpcReq.ID, map[string]any{"tools": mcpTools(cfg.MCP)}))
+	case "tools/call":
+		result, rpcErr := s.callMCPTool(c, cfg.MCP, rpcReq.Params)
+		if rpcErr != nil {
+			return c.JSON(http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: rpcReq.ID, Error: rpcErr})
+		}
+		return c.JSON(http.StatusOK, rpcSuccess(rpcReq.ID, result))
+	default:
+		return c.JSON(http.StatusOK, rpcFailure(rpcReq.ID, rpcMethodNotFound, fmt.Sprintf("method %q not found", rpcReq.Method)))
+	}
+}
+
+func (s *Server) callMCPTool(c echo.Context, mcp config.MCPConfig, rawParams json.RawMessage) (*mcpCallResult, *rpcError) {
+	var params struct {
+		Name      string `json:"name"`
+		Arguments struct {
+			Prompt    string `json:"prompt"`
+			System    string `json:"system"`
+			MaxTokens int    `json:"max_tokens"`
+		} `json:"arguments"`
+	}
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "invalid tools/call params"}
+	}
+
+	tool, ok := findMCPTool(mcp, params.Name)
+	if !ok {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("unknown tool %q", params.Name)}
+	}
+	if strings.TrimSpace(params.Arguments.Prompt) == "" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "argument prompt must not be empty"}
+	}
+
+	rt, release := s.acquireRouter()
+	defer release()
+	if rt == nil {
+		return mcpToolError("router not initialised"), nil
+	}
+
+	system := tool.System
+	if params.Arguments.System != "" {
+		system = params.Arguments.System
+	}
+
+	var messages []models.Message
+	if strings.TrimSpace(system) != "" {
+		messages = append(messages, models.Message{Role: "system", Content: system})
+	}
+	messages = append(messages, models.Message{Role: "user", Content: params.Arguments.Prompt})
+
+	options := make(map[string]any)
+	maxTokens := tool.MaxTokens
+	if params.Arguments.MaxTokens > 0 {
+		maxTokens = params.Arguments.MaxTokens
+	}
+	if maxTokens > 0 {
+		options["max_tokens"] = maxTokens
+	}
+
+	unifiedReq := models.UnifiedChatRequest{
+		Model:    tool.Model,
+		Messages: messages,
+		Options:  options,
+	}
+	s.capChatOutput(c, &unifiedReq)
+
+	resp, modelInfo, err := rt.Chat(c.Request().Context(), unifiedReq)
+	if err != nil {
+		var reqErr requestError
+		if errors.As(toHTTPError(err), &reqErr) {
+			return mcpToolError(reqErr.Message), nil
+		}
+		return mcpToolError("upstream provider error"), nil
+	}
+	if resp == nil {
+		return mcpToolError("upstream provider returned an empty response"), nil
+	}
+	s.recordUsage(c.Request().Context(), usage.KeyID(clientKey(c)), modelInfo, resp.Usage)
+
+	return &mcpCallResult{
+		Content: []mcpContent{{Type: "text", Text: resp.Message.Content}},
+	}, nil
+}
+
+func mcpInitializeResult(rawParams json.RawMessage) map[string]any {
+	version := mcpProtocolVersion
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	if err := json.Unmarshal(rawParams, &params); err == nil && params.ProtocolVersion != "" {
+		version = params.ProtocolVersion
+	}
+
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities": map[string]any{
+			"tools": map[string]any{"listChanged": false},
+		},
+		"serverInfo": map[string]any{
+			"name":    mcpServerName,
+			"version": mcpServerVersion,
+		},
+	}
+}
+
+func mcpTools(mcp config.MCPConfig) []mcpTool {
+	tools := make([]mcpTool, 0, len(mcp.Tools))
+	for _, tool := range mcp.Tools {
+		description := tool.Description
+		if description == "" {
+			description = fmt.Sprintf("Send a prompt to model %s", tool.Model)
+		}
+		tools = append(tools, mcpTool{
+			Name:        tool.Name,
+			Description: description,
+			InputSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"prompt":     map[string]any{"type": "string", "description": "User prompt to send to the model"},
+					"system":     map[string]any{"type": "string", "description": "Optional system prompt override"},
+					"max_tokens": map[string]any{"type": "integer", "description": "Optional output token limit"},
+				},
+				"required": []string{"prompt"},
+			},
+		})
+	}
+	return tools
+}
+
+func findMCPTool(mcp config.MCPConfig, name string) (config.MCPToolConfig, bool) {
+	for _, tool := range mcp.Tools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return config.MCPToolConfig{}, false
+}
+
+func mcpToolError(message string) *mcpCallResult {
+	return &mcpCallResult{
+		Content: []mcpContent{{Type: "text", Text: message}},
+		IsError: true,
+	}
+}
+
+func rpcSuccess(id json.RawMessage, result any) rpcResponse {
+	return rpcResponse{JSONRPC: "2.0", ID: id, Result: result}
+}
+
+func rpcFailure(id json.RawMessage, code int, message string) rpcResponse {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+	return rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/router"
+	"gocode-router/internal/telemetry"
+)
+
+// routerMetrics are the counters exported on /metrics.
+type routerMetrics struct {
+	registry *telemetry.Registry
+	requests *telemetry.Counter
+	latency  *telemetry.Counter
+	tokens   *telemetry.Counter
+	tagged   *telemetry.Counter
+	spend    *telemetry.Counter
+
+	experimentRequests *telemetry.Counter
+	experimentTokens   *telemetry.Counter
+}
+
+func newRouterMetrics() *routerMetrics {
+	registry := telemetry.NewRegistry()
+	return &routerMetrics{
+		registry: registry,
+		requests: registry.Counter("gocode_router_requests_total", "HTTP requests served.", "method", "route", "status"),
+		latency:  registry.Counter("gocode_router_request_duration_seconds_total", "Time spent serving HTTP requests.", "method", "route"),
+		tokens:   registry.Counter("gocode_router_tokens_total", "Tokens processed by upstream models.", "key", "provider", "model", "type"),
+		tagged:   registry.Counter("gocode_router_tagged_tokens_total", "Tokens processed by upstream models, by request tag.", "tag", "value", "type"),
+		spend:    registry.Counter("gocode_router_spend_dollars_total", "Spend on upstream models in US dollars, from configured pricing.", "key", "provider", "model"),
+
+		experimentRequests: registry.Counter("gocode_router_experiment_requests_total", "Requests routed to each experiment arm.", "experiment", "arm", "status"),
+		experimentTokens:   registry.Counter("gocode_router_experiment_tokens_total", "Tokens processed by upstream models, by experiment arm.", "experiment", "arm", "type"),
+	}
+}
+
+// observeRequest counts a finished request. Every request is counted,
+// whatever the log sampling policy keeps.
+func (s *Server) observeRequest(c echo.Context, v middleware.RequestLoggerValues) {
+	policy := s.currentConfig().Observability.Metrics
+	if !policy.Enabled {
+		return
+	}
+
+	route := c.Path()
+	if policy.RawPaths {
+		route = c.Request().URL.Path
+	}
+	if route == "" {
+		route = "unmatched"
+	}
+	status := strconv.Itoa(v.Status)
+	s.metrics.requests.Add(1, v.Method, route, status)
+	s.metrics.latency.Add(v.Latency.Seconds(), v.Method, route)
+	for _, a := range router.AssignmentsFrom(c.Request().Context()) {
+		s.metrics.experimentRequests.Add(1, a.Experiment, a.Arm, status)
+	}
+}
+
+// observeTokens counts the tokens of a completed upstream call. Each tag
+// listed in tags.metrics is counted in a series of its own rather than as a
+// label on every token series, so tags do not multiply the key and model
+// series.
+func (s *Server) observeTokens(keyID string, modelInfo models.Model, u models.Usage, cost float64, tags map[string]string) {
+	cfg := s.currentConfig()
+	policy := cfg.Observability.Metrics
+	if !policy.Enabled {
+		return
+	}
+
+	key := metricsKeyLabel(policy, keyID)
+	model := metricsModelLabel(policy, modelInfo.ID)
+	s.metrics.tokens.Add(float64(u.PromptTokens), key, modelInfo.Provider, model, "prompt")
+	s.metrics.tokens.Add(float64(u.CompletionTokens), key, modelInfo.Provider, model, "completion")
+	// Cache series only appear once a provider reports prompt caching.
+	if u.CacheCreationTokens > 0 {
+		s.metrics.tokens.Add(float64(u.CacheCreationTokens), key, modelInfo.Provider, model, "cache_creation")
+	}
+	if u.CacheReadTokens > 0 {
+		s.metrics.tokens.Add(float64(u.CacheReadTokens), key, modelInfo.Provider, model, "cache_read")
+	}
+	if cost > 0 {
+		s.metrics.spend.Add(cost, key, modelInfo.Provider, model)
+	}
+
+	for _, tag := range cfg.Tags.Metrics {
+		value, ok := tags[tag]
+		if !ok {
+			continue
+		}
+		s.metrics.tagged.Add(float64(u.PromptTokens), tag, value, "prompt")
+		s.metrics.tagged.Add(float64(u.CompletionTokens), tag, value, "completion")
+	}
+}
+
+// observeExperimentTokens counts the tokens of a completed upstream call
+// against the experiment arms the request was routed to.
+func (s *Server) observeExperimentTokens(ctx context.Context, u models.Usage) {
+	if !s.currentConfig().Observability.Metrics.Enabled {
+		return
+	}
+	for _, a := range router.AssignmentsFrom(ctx) {
+		s.metrics.experimentTokens.Add(float64(u.PromptTokens), a.Experiment, a.Arm, "prompt")
+		s.metrics.experimentTokens.Add(float64(u.CompletionTokens), a.Experiment, a.Arm, "completion")
+	}
+}
+
+// metricsKeyLabel collapses a hashed client key according to the policy.
+func metricsKeyLabel(policy config.MetricsConfig, keyID string) string {
+	switch policy.KeyLabelMode() {
+	case config.MetricsKeyLabelNone:
+		return ""
+	case config.MetricsKeyLabelHash:
+		return keyID
+	}
+	hash, ok := strings.CutPrefix(keyID, "key_")
+	if !ok {
+		return keyID
+	}
+	if n := policy.PrefixLength(); n < len(hash) {
+		hash = hash[:n]
+	}
+	return "key_" + hash
+}
+
+// metricsModelLabel reports models outside the allowlist as "other".
+func metricsModelLabel(policy config.MetricsConfig, model string) string {
+	if len(policy.Models) == 0 || slices.Contains(policy.Models, model) {
+		return model
+	}
+	return telemetry.OverflowLabel
+}
+
+func (s *Server) handleMetrics(c echo.Context) error {
+	if !s.currentConfig().Observability.Metrics.Enabled {
+		return echo.ErrNotFound
+	}
+	c.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	c.Response().WriteHeader(http.StatusOK)
+	return s.metrics.registry.Write(c.Response())
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/capture"
+	"gocode-router/internal/router"
+	"gocode-router/internal/usage"
+)
+
+type captureOriginKey struct{}
+
+// captureOrigin holds the request details a capture record needs, for
+// exchanges such as mirrored calls that finish after the request has.
+type captureOrigin struct {
+	requestID string
+	keyID     string
+	endpoint  string
+	optedOut  bool
+}
+
+func withCaptureOrigin(ctx context.Context, c echo.Context) context.Context {
+	return context.WithValue(ctx, captureOriginKey{}, captureOrigin{
+		requestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		keyID:     usage.KeyID(clientKey(c)),
+		endpoint:  c.Path(),
+		optedOut:  c.Request().Header.Get(capture.OptOutHeader) != "",
+	})
+}
+
+func captureOriginFrom(ctx context.Context) captureOrigin {
+	origin, _ := ctx.Value(captureOriginKey{}).(captureOrigin)
+	return origin
+}
+
+// observeMirror logs how a mirrored call went next to the request it
+// shadowed and captures its exchange. Mirrored calls are not charged to
+// the client's key.
+func (s *Server) observeMirror(ctx context.Context, res router.MirrorResult) {
+	origin := captureOriginFrom(ctx)
+	mirrorTo := res.ChatRequest.Model
+	if mirrorTo == "" {
+		mirrorTo = res.CompletionRequest.Model
+	}
+	attrs := []any{
+		"request_id", origin.requestID,
+		"model", res.Model,
+		"mirror", mirrorTo,
+		"latency_ms", res.Latency.Milliseconds(),
+	}
+	if res.Err != nil {
+		slog.Warn("mirrored request failed", append(attrs, "error", res.Err)...)
+		return
+	}
+
+	rec := capture.Record{
+		RequestID:     origin.requestID,
+		Endpoint:      origin.endpoint,
+		KeyID:         origin.keyID,
+		Model:         mirrorTo,
+		Provider:      res.Served.Provider,
+		UpstreamModel: res.Served.ID,
+		MirrorOf:      res.Model,
+	}
+	switch {
+	case res.Chat != nil:
+		options, metadata := capture.SplitOptions(res.ChatRequest.Options)
+		rec.Messages = capture.Messages(res.ChatRequest.Messages)
+		rec.Options, rec.Metadata = options, metadata
+		rec.Response = res.Chat.Message.Content
+		rec.FinishReason = res.Chat.FinishReason
+		rec.Usage = capture.UsageOf(res.Chat.Usage)
+	case res.Completion != nil:
+		options, metadata := capture.SplitOptions(res.CompletionRequest.Options)
+		rec.Prompt = res.CompletionRequest.Prompt
+		rec.Options, rec.Metadata = options, metadata
+		rec.Response = res.Completion.Text
+		rec.FinishReason = res.Completion.FinishReason
+		rec.Usage = capture.UsageOf(res.Completion.Usage)
+	default:
+		return
+	}
+	slog.Info("mirrored request", append(attrs,
+		"provider", rec.Provider,
+		"upstream_model", rec.UpstreamModel,
+		"prompt_tokens", rec.Usage.PromptTokens,
+		"completion_tokens", rec.Usage.CompletionTokens,
+		"finish_reason", rec.FinishReason,
+	)...)
+	if !origin.optedOut {
+		s.writeCapture(rec)
+	}
+}
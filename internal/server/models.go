@@ -0,0 +1,188 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/models"
+)
+
+const (
+	defaultModelPageSize = 20
+	maxModelPageSize     = 1000
+)
+
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type openAIModelList struct {
+	Object string        `json:"object"`
+	Data   []openAIModel `json:"data"`
+}
+
+type anthropicModel struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type anthropicModelList struct {
+	Data    []anthropicModel `json:"data"`
+	HasMore bool             `json:"has_more"`
+	FirstID *string          `json:"first_id"`
+	LastID  *string          `json:"last_id"`
+}
+
+// wantsAnthropicShape reports whether the client speaks Anthropic's API.
+// Anthropic SDKs and Claude Code always send the anthropic-version header.
+func wantsAnthropicShape(c echo.Context) bool {
+	return c.Request().Header.Get("anthropic-version") != ""
+}
+
+// handleListModels lists the models the router can serve, in Anthropic's
+// shape for Anthropic clients and OpenAI's otherwise.
+func (s *Server) handleListModels(c echo.Context) error {
+	rt := s.currentRouter()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+	list := rt.Models()
+
+	if !wantsAnthropicShape(c) {
+		data := make([]openAIModel, 0, len(list))
+		for _, model := range list {
+			data = append(data, s.toOpenAIModel(model))
+		}
+		return c.JSON(http.StatusOK, openAIModelList{Object: "list", Data: data})
+	}
+
+	page, hasMore, err := paginateModels(list, c.QueryParam("limit"), c.QueryParam("after_id"), c.QueryParam("before_id"))
+	if err != nil {
+		return err
+	}
+	resp := anthropicModelList{Data: make([]anthropicModel, 0, len(page)), HasMore: hasMore}
+	for _, model := range page {
+		resp.Data = append(resp.Data, s.toAnthropicModel(model))
+	}
+	if len(page) > 0 {
+		resp.FirstID = &page[0].ID
+		resp.LastID = &page[len(page)-1].ID
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// handleGetModel describes a single model in the shape the client expects.
+func (s *Server) handleGetModel(c echo.Context) error {
+	rt := s.currentRouter()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+
+	id := c.Param("id")
+	for _, model := range rt.Models() {
+		if model.ID != id {
+			continue
+		}
+		if wantsAnthropicShape(c) {
+			return c.JSON(http.StatusOK, s.toAnthropicModel(model))
+		}
+		return c.JSON(http.StatusOK, s.toOpenAIModel(model))
+	}
+	return requestError{
+		Status:  http.StatusNotFound,
+		Message: "model " + strconv.Quote(id) + " not found",
+		Type:    "invalid_request_error",
+		Code:    "model_not_found",
+	}
+}
+
+// Upstream release dates are not known to the router, so models report the
+// time this instance started.
+func (s *Server) toOpenAIModel(model models.Model) openAIModel {
+	return openAIModel{
+		ID:      model.ID,
+		Object:  "model",
+		Created: s.started.Unix(),
+		OwnedBy: model.Provider,
+	}
+}
+
+func (s *Server) toAnthropicModel(model models.Model) anthropicModel {
+	name := model.DisplayName
+	if name == "" {
+		name = model.ID
+	}
+	return anthropicModel{
+		Type:        "model",
+		ID:          model.ID,
+		DisplayName: name,
+		CreatedAt:   s.started.UTC().Format(time.RFC3339),
+	}
+}
+
+// paginateModels applies Anthropic's cursor pagination to a sorted list.
+func paginateModels(list []models.Model, limitParam, afterID, beforeID string) ([]models.Model, bool, error) {
+	limit := defaultModelPageSize
+	if limitParam != "" {
+		n, err := strconv.Atoi(limitParam)
+		if err != nil || n < 1 || n > maxModelPageSize {
+			return nil, false, requestError{
+				Status:  http.StatusBadRequest,
+				Message: "limit must be between 1 and " + strconv.Itoa(maxModelPageSize),
+				Type:    "invalid_request_error",
+			}
+		}
+		limit = n
+	}
+
+	if beforeID != "" {
+		end, err := indexOfModel(list, "before_id", beforeID)
+		if err != nil {
+			return nil, false, err
+		}
+		start := max(end-limit, 0)
+		return list[start:end], start > 0, nil
+	}
+
+	start := 0
+	if afterID != "" {
+		i, err := indexOfModel(list, "after_id", afterID)
+		if err != nil {
+			return nil, false, err
+		}
+		start = i + 1
+	}
+	end := min(start+limit, len(list))
+	return list[start:end], end < len(list), nil
+}
+
+// indexOfModel returns the position of the model a pagination cursor names
+// in param, or an error when no listed model has that ID.
+func indexOfModel(list []models.Model, param, id string) (int, error) {
+	for i, model := range list {
+		if model.ID == id {
+			return i, nil
+		}
+	}
+	return 0, requestError{
+		Status:  http.StatusBadRequest,
+		Message: param + " " + strconv.Quote(id) + " does not name a listed model",
+		Type:    "invalid_request_error",
+	}
+}
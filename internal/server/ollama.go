@@ -0,0 +1,150 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/translator"
+	"gocode-router/internal/usage"
+)
+
+// ndjsonMIME is the Content-Type of an Ollama stream: one JSON object per
+// line.
+const ndjsonMIME = "application/x-ndjson"
+
+// handleOllamaChat serves Ollama's /api/chat. Ollama streams unless the
+// request sets stream to false, and streams newline-delimited JSON rather
+// than server-sent events.
+func (s *Server) handleOllamaChat(c echo.Context) error {
+	var req translator.OllamaChatRequest
+	if err := decodeRequestBody(c, &req); err != nil {
+		return err
+	}
+
+	conversationID, err := s.checkConversationBudget(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	if req.Stream {
+		if _, err := s.streamRequested(c, true); err != nil {
+			return err
+		}
+	}
+	unifiedReq := req.ToUnified()
+	s.capChatOutput(c, &unifiedReq)
+
+	rt, release := s.acquireRouter()
+	defer release()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+
+	if req.Stream {
+		c.Response().Header().Set("Content-Type", ndjsonMIME)
+		return s.relayChatStream(c, rt, unifiedReq, conversationID, newOllamaLines)
+	}
+
+	started := time.Now()
+	resp, modelInfo, cached, err := s.routeChat(ctx, rt, unifiedReq)
+	setCacheHeader(c, cached)
+	if err != nil {
+		return toHTTPError(err)
+	}
+	if resp == nil {
+		return requestError{
+			Status:  http.StatusBadGateway,
+			Message: "upstream provider returned an empty response",
+			Type:    "upstream_error",
+		}
+	}
+
+	s.recordConversationUsage(c, conversationID, resp.Usage)
+	s.recordUsage(ctx, usage.KeyID(clientKey(c)), modelInfo, resp.Usage)
+	s.annotateChatProvenance(c, modelInfo, resp)
+	s.captureChat(c, unifiedReq, modelInfo, resp)
+
+	return c.JSON(http.StatusOK, translator.FromUnifiedOllama(modelInfo.ID, resp, time.Since(started)))
+}
+
+type ollamaModelDetails struct {
+	Format string `json:"format"`
+	Family string `json:"family"`
+}
+
+type ollamaModel struct {
+	Name       string             `json:"name"`
+	Model      string             `json:"model"`
+	ModifiedAt string             `json:"modified_at"`
+	Size       int64              `json:"size"`
+	Digest     string             `json:"digest"`
+	Details    ollamaModelDetails `json:"details"`
+}
+
+type ollamaModelList struct {
+	Models []ollamaModel `json:"models"`
+}
+
+// handleOllamaTags lists every configured model and alias as Ollama lists
+// its local models. None is stored locally, so sizes and digests are empty
+// and the family is the provider serving the model.
+func (s *Server) handleOllamaTags(c echo.Context) error {
+	rt := s.currentRouter()
+	if rt == nil {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+	list := rt.Models()
+	modified := s.started.UTC().Format(time.RFC3339)
+	resp := ollamaModelList{Models: make([]ollamaModel, 0, len(list))}
+	for _, model := range list {
+		resp.Models = append(resp.Models, ollamaModel{
+			Name:       model.ID,
+			Model:      model.ID,
+			ModifiedAt: modified,
+			Details:    ollamaModelDetails{Format: "api", Family: model.Provider},
+		})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// ollamaLines writes the lines of an /api/chat stream.
+type ollamaLines struct {
+	stream  *sseWriter
+	encoder *translator.OllamaStreamEncoder
+}
+
+func newOllamaLines(stream *sseWriter, modelID string) chatEncoder {
+	return &ollamaLines{stream: stream, encoder: translator.NewOllamaStreamEncoder(modelID, time.Now())}
+}
+
+func (e *ollamaLines) chunk(c models.UnifiedChatChunk) error {
+	if resp, ok := e.encoder.Encode(c); ok {
+		return e.stream.line(resp)
+	}
+	return nil
+}
+
+func (e *ollamaLines) finish(resp *models.UnifiedChatResponse) error {
+	return e.stream.line(e.encoder.Finish(resp.FinishReason, resp.Usage))
+}
+
+// fail ends the stream with a line holding only the error, as Ollama does.
+func (e *ollamaLines) fail(err error) {
+	httpErr := streamFailure(err)
+	if err := e.stream.line(map[string]string{"error": httpErr.Message}); err != nil {
+		slog.Error("failed to write stream error", "err", err)
+	}
+}
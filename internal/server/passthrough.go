@@ -0,0 +1,211 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+	"gocode-router/internal/router"
+	"gocode-router/internal/usage"
+)
+
+// passthroughHeaders are the client headers forwarded with a passthrough
+// request, for upstream features the translation does not know.
+var passthroughHeaders = []string{"anthropic-beta", "anthropic-version", "OpenAI-Beta"}
+
+// passthroughResponseHeaders are the upstream headers relayed to the client
+// of a passthrough request.
+var passthroughResponseHeaders = []string{"Content-Type", "Cache-Control", "Request-Id", "Openai-Processing-Ms", "Retry-After"}
+
+// bufferPassthroughBody reads the request body when some model forwards
+// requests untranslated, so the body can be sent as it came. The body is
+// put back for decoding. It returns nil when no model does.
+func (s *Server) bufferPassthroughBody(c echo.Context) ([]byte, error) {
+	if !s.currentConfig().Providers.HasPassthrough() {
+		return nil, nil
+	}
+	req := c.Request()
+	raw, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		if tooLarge, ok := bodyTooLarge(err); ok {
+			return nil, tooLarge
+		}
+		return nil, requestError{
+			Status:  http.StatusBadRequest,
+			Message: "request body could not be read: " + err.Error(),
+			Type:    "invalid_request_error",
+		}
+	}
+	req.Body = io.NopCloser(bytes.NewReader(raw))
+	return raw, nil
+}
+
+// servePassthrough forwards the request to the model it names when that
+// model passes requests in apiStyle through, and relays the upstream
+// response as it is. Usage reported in the response is recorded. It reports
+// whether the request was handled.
+func (s *Server) servePassthrough(c echo.Context, rt *router.Router, apiStyle, operation, model string, raw []byte, stream bool, conversationID string) (bool, error) {
+	if raw == nil {
+		return false, nil
+	}
+	header := make(http.Header)
+	for _, name := range passthroughHeaders {
+		if value := c.Request().Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	ctx := c.Request().Context()
+	resp, modelInfo, handled, err := rt.Passthrough(ctx, apiStyle, provider.PassthroughRequest{
+		Model:     model,
+		Operation: operation,
+		Body:      raw,
+		Stream:    stream,
+		Header:    header,
+		MaxTokens: outputTokenLimit(s.currentConfig().Limits, clientKey(c)),
+	})
+	if !handled {
+		return false, nil
+	}
+	if err != nil {
+		return true, toHTTPError(err)
+	}
+	defer resp.Body.Close()
+
+	out := c.Response()
+	for _, name := range passthroughResponseHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			out.Header().Set(name, value)
+		}
+	}
+	out.WriteHeader(resp.StatusCode)
+
+	var counter passthroughUsage
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), eventStreamMIME) {
+		err = relayPassthroughEvents(out, resp.Body, &counter)
+	} else {
+		var body []byte
+		if body, err = io.ReadAll(resp.Body); err == nil {
+			counter.observe(body)
+			_, err = out.Write(body)
+		}
+	}
+	if err != nil && !errors.Is(err, ctx.Err()) {
+		slog.Warn("passthrough relay failed", "model", modelInfo.ID, "error", err)
+	}
+
+	u := counter.usage()
+	if resp.StatusCode < http.StatusBadRequest {
+		s.recordConversationUsage(c, conversationID, u)
+	}
+	s.recordUsageStatus(ctx, usage.KeyID(clientKey(c)), modelInfo, u, resp.StatusCode)
+	return true, nil
+}
+
+// relayPassthroughEvents copies an event stream line by line, flushing
+// after each event, and counts the usage the events report.
+func relayPassthroughEvents(out *echo.Response, body io.Reader, counter *passthroughUsage) error {
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+				counter.observe(bytes.TrimSpace(data))
+			}
+			if _, werr := out.Write(line); werr != nil {
+				return werr
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				out.Flush()
+			}
+		}
+		if err == io.EOF {
+			out.Flush()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// passthroughUsage collects the token counts an upstream reports in
+// OpenAI or Claude form. Claude streams report input tokens in
+// message_start and output tokens in message_delta, so the latest non-zero
+// count of each kind is kept.
+type passthroughUsage struct {
+	prompt, completion, total int
+	cacheCreation, cacheRead  int
+}
+
+type reportedUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	InputTokens      int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	PromptTokensDetails      struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+func (p *passthroughUsage) observe(data []byte) {
+	if len(data) == 0 || data[0] != '{' {
+		return
+	}
+	var body struct {
+		Usage   *reportedUsage `json:"usage"`
+		Message struct {
+			Usage *reportedUsage `json:"usage"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return
+	}
+	for _, u := range []*reportedUsage{body.Usage, body.Message.Usage} {
+		if u == nil {
+			continue
+		}
+		if n := max(u.PromptTokens, u.InputTokens); n > 0 {
+			p.prompt = n
+		}
+		if n := max(u.CompletionTokens, u.OutputTokens); n > 0 {
+			p.completion = n
+		}
+		if u.TotalTokens > 0 {
+			p.total = u.TotalTokens
+		}
+		if u.CacheCreationInputTokens > 0 {
+			p.cacheCreation = u.CacheCreationInputTokens
+		}
+		if n := max(u.CacheReadInputTokens, u.PromptTokensDetails.CachedTokens); n > 0 {
+			p.cacheRead = n
+		}
+	}
+}
+
+func (p *passthroughUsage) usage() models.Usage {
+	total := p.total
+	if total == 0 {
+		total = p.prompt + p.completion
+	}
+	return models.Usage{
+		PromptTokens:        p.prompt,
+		CompletionTokens:    p.completion,
+		TotalTokens:         total,
+		CacheCreationTokens: p.cacheCreation,
+		CacheReadTokens:     p.cacheRead,
+	}
+}
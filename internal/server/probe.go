@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gocode-router/internal/health"
+	"gocode-router/internal/provider"
+)
+
+// probeProviders probes every provider of the current router while probing
+// is enabled, until ctx ends. Turning probing off forgets the history, so
+// no provider stays marked down.
+func (s *Server) probeProviders(ctx context.Context) {
+	for {
+		cfg := s.currentConfig().Health.Probe
+		if cfg.Enabled {
+			if rt := s.currentRouter(); rt != nil {
+				probeAll(ctx, s.health, rt.Providers(), cfg.ProbeTimeout(), cfg.FailureThreshold())
+			}
+		} else {
+			s.health.Reset()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.Every()):
+		}
+	}
+}
+
+// probeAll probes every provider that can be probed at once and records
+// the outcomes in tracker.
+func probeAll(ctx context.Context, tracker *health.Tracker, providers []provider.Provider, timeout time.Duration, threshold int) {
+	var wg sync.WaitGroup
+	for _, p := range providers {
+		prober, ok := p.(provider.Prober)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := prober.Probe(probeCtx)
+			if ctx.Err() != nil {
+				return
+			}
+			tracker.Observe(p.Name(), time.Since(start), err, threshold)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/models"
+)
+
+// provenanceRecord identifies the upstream that generated a response.
+type provenanceRecord struct {
+	RequestID     string    `json:"request_id"`
+	Provider      string    `json:"provider"`
+	Model         string    `json:"model"`
+	UpstreamModel string    `json:"upstream_model,omitempty"`
+	UpstreamID    string    `json:"upstream_id,omitempty"`
+	GeneratedAt   time.Time `json:"generated_at"`
+}
+
+// provenanceLog appends provenance records to a JSONL file. The file is only
+// ever opened for appending so existing entries are never rewritten.
+type provenanceLog struct {
+	mu sync.Mutex
+}
+
+func (l *provenanceLog) append(path string, record provenanceRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal provenance record: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open provenance log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write provenance log: %w", err)
+	}
+	return nil
+}
+
+// recordProvenance sets provenance headers, logs the generation and returns
+// the record when it should also be embedded in the response body.
+func (s *Server) recordProvenance(c echo.Context, modelInfo models.Model, upstreamModel, upstreamID string) *provenanceRecord {
+	policy := s.currentConfig().Provenance
+	if !policy.Enabled {
+		return nil
+	}
+
+	record := provenanceRecord{
+		RequestID:     c.Response().Header().Get(echo.HeaderXRequestID),
+		Provider:      modelInfo.Provider,
+		Model:         modelInfo.ID,
+		UpstreamModel: upstreamModel,
+		UpstreamID:    upstreamID,
+		GeneratedAt:   time.Now().UTC(),
+	}
+
+	header := c.Response().Header()
+	header.Set("X-Router-Provider", record.Provider)
+	header.Set("X-Router-Model", record.Model)
+	if record.UpstreamModel != "" {
+		header.Set("X-Router-Upstream-Model", record.UpstreamModel)
+	}
+
+	slog.Info("provenance",
+		"request_id", record.RequestID,
+		"provider", record.Provider,
+		"model", record.Model,
+		"upstream_model", record.UpstreamModel,
+		"upstream_id", record.UpstreamID,
+	)
+
+	if policy.LogFile != "" {
+		if err := s.provenance.append(policy.LogFile, record); err != nil {
+			slog.Error("failed to append provenance record", "path", policy.LogFile, "err", err)
+		}
+	}
+
+	if !policy.Metadata {
+		return nil
+	}
+	return &record
+}
+
+func (s *Server) annotateChatProvenance(c echo.Context, modelInfo models.Model, resp *models.UnifiedChatResponse) {
+	if record := s.recordProvenance(c, modelInfo, resp.Model, resp.ID); record != nil {
+		resp.Annotate("provenance", record)
+	}
+}
+
+func (s *Server) annotateCompletionProvenance(c echo.Context, modelInfo models.Model, resp *models.UnifiedCompletionResponse) {
+	if record := s.recordProvenance(c, modelInfo, resp.Model, resp.ID); record != nil {
+		resp.Annotate("provenance", record)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/jobs"
+	"gocode-router/internal/usage"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+
+// servePersisted runs a synchronous request as a job when the client sent
+// an Idempotency-Key and jobs.persist_requests is on. The request then
+// survives a restart, and retrying with the same key returns the stored
+// result instead of calling the upstream again. It reports handled=false,
+// with the body restored, when the request should take the normal path.
+func (s *Server) servePersisted(c echo.Context, kind string) (bool, error) {
+	if s.jobs == nil {
+		return false, nil
+	}
+	policy := s.currentConfig().Jobs
+	if !policy.PersistRequests {
+		return false, nil
+	}
+	idempotencyKey := strings.TrimSpace(c.Request().Header.Get(idempotencyHeader))
+	if idempotencyKey == "" {
+		return false, nil
+	}
+
+	req := c.Request()
+	raw, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		if tooLarge, ok := bodyTooLarge(err); ok {
+			return true, tooLarge
+		}
+		return true, requestError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid JSON payload: %v", err),
+			Type:    "invalid_request_error",
+		}
+	}
+
+	stream, err := decodeJobBody(kind, raw)
+	if err != nil {
+		return true, err
+	}
+	if wantsStream(c, stream) {
+		req.Body = io.NopCloser(bytes.NewReader(raw))
+		return false, nil
+	}
+
+	payload, err := s.newJobPayload(c, raw)
+	if err != nil {
+		return true, err
+	}
+
+	ctx := req.Context()
+	id := idempotentJobID(usage.KeyID(clientKey(c)), kind, idempotencyKey)
+	job, created, err := s.jobs.SubmitWithID(ctx, id, kind, payload)
+	if err != nil {
+		return true, requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "failed to queue request",
+			Type:    "server_error",
+		}
+	}
+	if !created && !sameJobBody(job.Payload, raw) {
+		return true, requestError{
+			Status:  http.StatusUnprocessableEntity,
+			Message: fmt.Sprintf("%s was already used with a different request body", idempotencyHeader),
+			Type:    "invalid_request_error",
+			Code:    "idempotency_key_reused",
+		}
+	}
+
+	header := c.Response().Header()
+	header.Set("X-Job-Id", job.ID)
+	if !created {
+		header.Set("Idempotent-Replayed", "true")
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, policy.WaitTimeout())
+	defer cancel()
+	job, err = s.jobs.Await(waitCtx, id)
+	if err != nil {
+		return true, requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "failed to load queued request",
+			Type:    "server_error",
+		}
+	}
+
+	switch job.Status {
+	case jobs.StatusSucceeded:
+		return true, c.JSONBlob(http.StatusOK, job.Result)
+	case jobs.StatusFailed:
+		return true, requestError{
+			Status:  http.StatusBadGateway,
+			Message: fmt.Sprintf("request failed after %d attempts; see GET /v1/jobs/%s", job.Attempts, job.ID),
+			Type:    "upstream_error",
+			Code:    "job_failed",
+		}
+	default:
+		// Still queued behind a backlog: hand the client the job to poll.
+		return true, c.JSON(http.StatusAccepted, newJobResponse(job))
+	}
+}
+
+// idempotentJobID scopes an idempotency key to the client and endpoint so
+// different callers cannot collide.
+func idempotentJobID(keyID, kind, idempotencyKey string) string {
+	sum := sha256.Sum256([]byte(keyID + "\x00" + kind + "\x00" + idempotencyKey))
+	return "req_" + hex.EncodeToString(sum[:])[:24]
+}
+
+// sameJobBody reports whether a stored job payload carries the given body,
+// ignoring insignificant whitespace.
+func sameJobBody(stored json.RawMessage, body []byte) bool {
+	var payload jobPayload
+	if err := json.Unmarshal(stored, &payload); err != nil {
+		return false
+	}
+	var a, b bytes.Buffer
+	if json.Compact(&a, payload.Body) != nil || json.Compact(&b, body) != nil {
+		return false
+	}
+	return bytes.Equal(a.Bytes(), b.Bytes())
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/budget"
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+	"gocode-router/internal/usage"
+)
+
+// quotaApplies reports whether q counts the usage of the key with keyID.
+func quotaApplies(q config.QuotaConfig, keyID string) bool {
+	return len(q.Keys) == 0 || slices.ContainsFunc(q.Keys, func(key string) bool {
+		return usage.KeyID(key) == keyID
+	})
+}
+
+// quotaStates reads the key's standing in every quota that applies to it.
+// Quotas whose state cannot be read are left out, so an unavailable state
+// backend fails open.
+func (s *Server) quotaStates(ctx context.Context, keyID string) []budget.QuotaState {
+	var states []budget.QuotaState
+	for _, q := range s.currentConfig().Budgets.Quotas {
+		if !quotaApplies(q, keyID) {
+			continue
+		}
+		state, err := s.quotas.State(ctx, q, keyID)
+		if err != nil {
+			slog.Warn("quota lookup failed", "quota", q.Name, "error", err)
+			continue
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// enforceQuotas refuses requests from keys that used up a quota window and
+// counts the request against the rest. Responses carry the tightest
+// remaining limits in x-ratelimit headers.
+func (s *Server) enforceQuotas(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		quotas := s.currentConfig().Budgets.Quotas
+		if len(quotas) == 0 {
+			return next(c)
+		}
+
+		ctx := c.Request().Context()
+		keyID := usage.KeyID(clientKey(c))
+		states := s.quotaStates(ctx, keyID)
+		for _, state := range states {
+			if state.Exceeded() {
+				setRateLimitHeaders(c.Response().Header(), states)
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(time.Until(state.Reset).Seconds())+1))
+				return requestError{
+					Status:  http.StatusTooManyRequests,
+					Message: fmt.Sprintf("quota %q is exhausted for this key until %s", state.Quota, state.Reset.UTC().Format(time.RFC3339)),
+					Type:    "rate_limit_error",
+					Code:    "quota_exceeded",
+				}
+			}
+		}
+
+		for _, q := range quotas {
+			if !quotaApplies(q, keyID) {
+				continue
+			}
+			if err := s.quotas.Charge(ctx, q, keyID, budget.Amount{Requests: 1}); err != nil {
+				slog.Warn("quota update failed", "quota", q.Name, "error", err)
+			}
+		}
+		for i := range states {
+			states[i].Used.Requests++
+		}
+		setRateLimitHeaders(c.Response().Header(), states)
+		return next(c)
+	}
+}
+
+// chargeQuotas counts the tokens and spend of a completed request against
+// the key's quotas.
+func (s *Server) chargeQuotas(ctx context.Context, keyID string, u models.Usage, cost float64) {
+	cfg := s.currentConfig()
+	amount := budget.Amount{Tokens: int64(u.TotalTokens), Cost: cost}
+	for _, q := range cfg.Budgets.Quotas {
+		if !quotaApplies(q, keyID) {
+			continue
+		}
+		if err := s.quotas.Charge(ctx, q, keyID, amount); err != nil {
+			slog.Warn("quota update failed", "quota", q.Name, "error", err)
+		}
+	}
+}
+
+// setRateLimitHeaders reports, for requests, tokens and cost, the quota with
+// the least remaining in the OpenAI x-ratelimit header style.
+func setRateLimitHeaders(header http.Header, states []budget.QuotaState) {
+	type dimension struct {
+		name   string
+		amount func(budget.Amount) float64
+		format func(float64) string
+	}
+	count := func(v float64) string { return strconv.FormatInt(int64(v), 10) }
+	dollars := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	dimensions := []dimension{
+		{"requests", func(a budget.Amount) float64 { return float64(a.Requests) }, count},
+		{"tokens", func(a budget.Amount) float64 { return float64(a.Tokens) }, count},
+		{"cost", func(a budget.Amount) float64 { return a.Cost }, dollars},
+	}
+
+	for _, d := range dimensions {
+		var tightest *budget.QuotaState
+		for i := range states {
+			state := &states[i]
+			if d.amount(state.Limit) <= 0 {
+				continue
+			}
+			if tightest == nil || d.amount(state.Remaining()) < d.amount(tightest.Remaining()) {
+				tightest = state
+			}
+		}
+		if tightest == nil {
+			continue
+		}
+		header.Set("x-ratelimit-limit-"+d.name, d.format(d.amount(tightest.Limit)))
+		header.Set("x-ratelimit-remaining-"+d.name, d.format(d.amount(tightest.Remaining())))
+		header.Set("x-ratelimit-reset-"+d.name, time.Until(tightest.Reset).Round(time.Second).String())
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/usage"
+)
+
+// enforceRateLimit paces each client key to its configured request rate.
+// Like quotas, it fails open when the state backend is unavailable.
+func (s *Server) enforceRateLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := clientKey(c)
+		perMinute, burst := s.currentConfig().Limits.RateFor(key)
+		if perMinute <= 0 {
+			return next(c)
+		}
+
+		ok, wait, err := s.rateLimiter.Take(c.Request().Context(), usage.KeyID(key), perMinute, burst)
+		if err != nil {
+			slog.Warn("rate limit check failed", "error", err)
+			return next(c)
+		}
+		if !ok {
+			return requestError{
+				Status:     http.StatusTooManyRequests,
+				Message:    fmt.Sprintf("rate limit of %d requests per minute reached for this key", perMinute),
+				Type:       "rate_limit_error",
+				Code:       "rate_limit_exceeded",
+				RetryAfter: int(math.Ceil(wait.Seconds())),
+			}
+		}
+		return next(c)
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/health"
+	"gocode-router/internal/provider"
+	"gocode-router/internal/router"
+)
+
+// readiness holds the outcome of the probes /health/ready runs itself when
+// background probes are off. They run at most once per probe interval.
+type readiness struct {
+	mu      sync.Mutex
+	tracker *health.Tracker
+	probed  time.Time
+}
+
+// probe probes the providers unless the last probe is recent, and returns
+// the tracker holding the outcomes. Concurrent callers wait for one probe.
+func (r *readiness) probe(ctx context.Context, providers []provider.Provider, cfg config.ProbeConfig) *health.Tracker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.probed) >= cfg.Every() {
+		probeAll(ctx, r.tracker, providers, cfg.ProbeTimeout(), cfg.FailureThreshold())
+		r.probed = time.Now()
+	}
+	return r.tracker
+}
+
+// readyResponse is the body of /health/ready. Status is healthy when every
+// provider is, down when none can serve and degraded otherwise.
+type readyResponse struct {
+	Status        string         `json:"status"`
+	ConfigVersion string         `json:"config_version,omitempty"`
+	Providers     []health.State `json:"providers"`
+}
+
+// handleReady reports whether the router can serve requests, from probes of
+// every provider that can be probed: the background probes when they are
+// enabled, or probes of its own otherwise. It answers 503 when no provider
+// is up.
+func (s *Server) handleReady(c echo.Context) error {
+	resp := readyResponse{
+		Status:        health.StatusDown,
+		ConfigVersion: s.ConfigVersion(),
+		Providers:     []health.State{},
+	}
+	rt := s.currentRouter()
+	if rt == nil {
+		return c.JSON(http.StatusServiceUnavailable, resp)
+	}
+
+	cfg := s.currentConfig().Health.Probe
+	tracker := s.health
+	if !cfg.Enabled {
+		// The probes finish and are cached even if this client goes away.
+		ctx := context.WithoutCancel(c.Request().Context())
+		tracker = s.readiness.probe(ctx, rt.Providers(), cfg)
+	}
+	resp.Providers = readyStates(rt, tracker)
+
+	up, healthy := 0, 0
+	for _, state := range resp.Providers {
+		if state.Status != health.StatusDown {
+			up++
+		}
+		if state.Status == health.StatusHealthy {
+			healthy++
+		}
+	}
+	switch {
+	case healthy == len(resp.Providers):
+		resp.Status = health.StatusHealthy
+	case up > 0:
+		resp.Status = health.StatusDegraded
+	default:
+		return c.JSON(http.StatusServiceUnavailable, resp)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// readyStates returns the probe state of the router's providers that can be
+// probed. Providers not probed yet are reported healthy, as routing treats
+// them.
+func readyStates(rt *router.Router, tracker *health.Tracker) []health.State {
+	probed := make(map[string]health.State)
+	for _, state := range tracker.States() {
+		probed[state.Provider] = state
+	}
+	out := []health.State{}
+	for _, p := range rt.Providers() {
+		if _, ok := p.(provider.Prober); !ok {
+			continue
+		}
+		state, ok := probed[p.Name()]
+		if !ok {
+			state = health.State{Provider: p.Name(), Status: health.StatusHealthy, Score: 1}
+		}
+		out = append(out, state)
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// enforceDisabledRoutes answers 404 on the routes listed under
+// server.disabled_routes, as if they were never registered.
+func (s *Server) enforceDisabledRoutes(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		for _, route := range s.currentConfig().Server.DisabledRoutes {
+			if route == c.Path() || matchPath(route, c.Request().URL.Path) {
+				return requestError{
+					Status:  http.StatusNotFound,
+					Message: "endpoint " + c.Request().URL.Path + " is disabled on this server",
+					Type:    "invalid_request_error",
+					Code:    "endpoint_disabled",
+				}
+			}
+		}
+		return next(c)
+	}
+}
+
+// limitRequestBody caps the request body at the limit configured for the
+// route. Reads past it fail with an *http.MaxBytesError, which handlers
+// turn into a 413 with bodyTooLarge.
+func (s *Server) limitRequestBody(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		limit := s.currentConfig().Server.BodyLimit(c.Path(), req.URL.Path)
+		if req.ContentLength > limit {
+			return bodyLimitError(limit)
+		}
+		req.Body = http.MaxBytesReader(c.Response(), req.Body, limit)
+		return next(c)
+	}
+}
+
+// bodyTooLarge returns the error for a body read that failed because the
+// body was over its limit.
+func bodyTooLarge(err error) (requestError, bool) {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return requestError{}, false
+	}
+	return bodyLimitError(tooLarge.Limit), true
+}
+
+func bodyLimitError(limit int64) requestError {
+	return requestError{
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("request body is larger than the %d byte limit", limit),
+		Type:    "invalid_request_error",
+		Code:    "request_too_large",
+	}
+}
+
+// isAPIPath reports whether a path belongs to the model APIs: the OpenAI and
+// Anthropic routes under /v1, the Gemini ones under /v1beta, the Ollama
+// ones under /api and the MCP endpoint, whose tools call models too.
+func isAPIPath(p string) bool {
+	return strings.HasPrefix(p, "/v1/") || strings.HasPrefix(p, "/v1beta/") || strings.HasPrefix(p, "/api/") || p == "/mcp"
+}
+
+func matchPath(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
@@ -2,42 +2,87 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 
+	"gocode-router/internal/alerts"
+	"gocode-router/internal/audit"
+	"gocode-router/internal/budget"
+	"gocode-router/internal/cache"
+	"gocode-router/internal/capture"
+	"gocode-router/internal/chaos"
 	"gocode-router/internal/config"
-	"gocode-router/internal/models"
+	"gocode-router/internal/health"
+	"gocode-router/internal/jobs"
+	"gocode-router/internal/plugin"
 	"gocode-router/internal/provider"
+	"gocode-router/internal/provider/fixture"
 	"gocode-router/internal/router"
+	"gocode-router/internal/storage"
+	"gocode-router/internal/telemetry"
 	"gocode-router/internal/translator"
+	"gocode-router/internal/usage"
 )
 
 const (
-	maxBodyBytes        = 1 << 20 // 1 MiB
 	shutdownGracePeriod = 10 * time.Second
 	readTimeout         = 30 * time.Second
 	writeTimeout        = 45 * time.Second
 	idleTimeout         = 120 * time.Second
+	// modelRefreshTick is how often due model discovery refreshes are checked.
+	modelRefreshTick = 5 * time.Second
 )
 
 type Server struct {
-	cfgMu sync.RWMutex
-	cfg   config.Config
+	cfgMu         sync.RWMutex
+	cfg           config.Config
+	configVersion string
+	clientKeys    clientKeySet
+	// reload reloads the configuration at the admin API's request; nil
+	// when the server was started without a reloadable configuration.
+	reload   func(ctx context.Context) error
+	disabled disabledModels
 
 	routerMu sync.RWMutex
-	router   *router.Router
+	router   *routerRef
+
+	conversations *budget.ConversationTracker
+	quotas        *budget.QuotaTracker
+	rateLimiter   budget.RateLimiter
+	provenance    provenanceLog
+	storage       storage.Backend
+	cache         cache.Store
+	peerCache     cache.Store
+	jobs          *jobs.Runner
+	usage         *usage.Aggregator
+	alerts        *alerts.Monitor
+	health        *health.Tracker
+	readiness     *readiness
+	chaos         *chaos.Injector
+	recorder      *capture.Recorder
+	captures      *capture.Policy
+	auditor       *audit.Logger
+	metrics       *routerMetrics
 
 	app     *echo.Echo
 	address string
+	// tlsConfig is set when the server terminates TLS, and acmeChallenges
+	// when it answers ACME HTTP-01 challenges on plain HTTP.
+	tlsConfig      *tls.Config
+	acmeChallenges *http.Server
+	started        time.Time
 }
 
 // New constructs an HTTP server wired with routing and middleware.
@@ -50,6 +95,25 @@ func New(cfg config.Config, rt *router.Router) (*Server, error) {
 		return nil, err
 	}
 
+	// The state backend is fixed for the lifetime of the process; reloads
+	// only change the limits applied on top of it.
+	counter, err := budget.NewCounter(cfg.State)
+	if err != nil {
+		return nil, err
+	}
+	conversations, err := budget.NewConversationTracker(counter)
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := budget.NewQuotaTracker(counter)
+	if err != nil {
+		return nil, err
+	}
+	rateLimiter, err := budget.NewRateLimiter(cfg.State)
+	if err != nil {
+		return nil, err
+	}
+
 	e := echo.New()
 	e.HideBanner = true
 	e.HidePort = true
@@ -57,22 +121,53 @@ func New(cfg config.Config, rt *router.Router) (*Server, error) {
 
 	e.Pre(middleware.RemoveTrailingSlash())
 	e.Use(middleware.Recover())
+	e.Use(middleware.RequestID())
+	srv := &Server{
+		conversations: conversations,
+		quotas:        quotas,
+		rateLimiter:   rateLimiter,
+		health:        health.NewTracker(),
+		readiness:     &readiness{tracker: health.NewTracker()},
+		chaos:         chaos.NewInjector(),
+		metrics:       newRouterMetrics(),
+		app:           e,
+		address:       fmt.Sprintf(":%d", cfg.Server.Port),
+		started:       time.Now(),
+	}
+	if tlsCfg := cfg.Server.TLS; tlsCfg.Enabled() {
+		tlsConfig, challenges, err := newTLSConfig(tlsCfg)
+		if err != nil {
+			return nil, err
+		}
+		srv.tlsConfig = tlsConfig
+		if challenges != nil && tlsCfg.ACME.HTTPPort != 0 {
+			srv.acmeChallenges = &http.Server{
+				Addr:              fmt.Sprintf(":%d", tlsCfg.ACME.HTTPPort),
+				Handler:           challenges,
+				ReadHeaderTimeout: readTimeout,
+			}
+		}
+	}
+	srv.setConfig(cfg)
+	srv.setRouter(rt)
+	srv.applyObservability(cfg.Observability)
+
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
-		LogLatency: true,
-		LogMethod:  true,
-		LogURI:     true,
-		LogStatus:  true,
-		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
-			slog.Info("request",
-				"method", v.Method,
-				"uri", v.URI,
-				"status", v.Status,
-				"latency_ms", v.Latency.Milliseconds(),
-				"error", v.Error,
-			)
-			return nil
-		},
+		HandleError:      true,
+		LogError:         true,
+		LogLatency:       true,
+		LogMethod:        true,
+		LogURI:           true,
+		LogStatus:        true,
+		LogRequestID:     true,
+		LogRemoteIP:      true,
+		LogUserAgent:     true,
+		LogContentLength: true,
+		LogResponseSize:  true,
+		LogValuesFunc:    srv.logRequest,
 	}))
+	e.Use(srv.auditRequest)
+	e.Use(srv.traceRequest)
 	e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
 		XSSProtection:         "1; mode=block",
 		ContentTypeNosniff:    "nosniff",
@@ -80,23 +175,136 @@ func New(cfg config.Config, rt *router.Router) (*Server, error) {
 		HSTSMaxAge:            31536000,
 		ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'; form-action 'none'",
 	}))
+	// Preflight requests carry no client key, so CORS is answered first.
+	if cors := cfg.Server.CORS; cors.Enabled() {
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:     cors.AllowedOrigins,
+			AllowMethods:     cors.AllowedMethods,
+			AllowHeaders:     cors.AllowedHeaders,
+			AllowCredentials: cors.AllowCredentials,
+			MaxAge:           int(cors.MaxAge / time.Second),
+		}))
+	}
+	e.Use(srv.requireClientKey)
+	e.Use(srv.attributeRequest)
+	e.Use(srv.enforceDisabledRoutes)
+	e.Use(srv.limitRequestBody)
 
-	srv := &Server{
-		app:     e,
-		address: fmt.Sprintf(":%d", cfg.Server.Port),
+	backend, err := storage.Open(cfg.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("initialise storage: %w", err)
+	}
+
+	srv.storage = backend
+	if srv.clientKeys, err = loadClientKeys(context.Background(), cfg.Auth, backend.Keys()); err != nil {
+		backend.Close()
+		return nil, err
+	}
+
+	if err := srv.initStores(); err != nil {
+		backend.Close()
+		return nil, err
 	}
 
-	srv.setConfig(cfg)
-	srv.setRouter(rt)
 	srv.registerRoutes()
 
 	return srv, nil
 }
 
+// logRequest writes the access log for a finished request, subject to the
+// configured sampling policy. Connection details are only logged at debug
+// level.
+func (s *Server) logRequest(c echo.Context, v middleware.RequestLoggerValues) error {
+	s.observeRequest(c, v)
+	s.finishAudit(c, v)
+
+	failed := v.Error != nil || v.Status >= http.StatusInternalServerError
+	if isAPIPath(c.Path()) {
+		s.alerts.ObserveRequest(failed)
+	}
+	if !telemetry.Keep(s.currentConfig().Observability.Sampling, v.RequestID, failed) {
+		return nil
+	}
+
+	attrs := []any{
+		"method", v.Method,
+		"uri", v.URI,
+		"status", v.Status,
+		"latency_ms", v.Latency.Milliseconds(),
+		"error", v.Error,
+	}
+	if tags := provider.TagsFrom(c.Request().Context()); len(tags) > 0 {
+		attrs = append(attrs, "tags", tags)
+	}
+	slog.Info("request", attrs...)
+	slog.Debug("request detail",
+		"request_id", v.RequestID,
+		"remote_ip", v.RemoteIP,
+		"user_agent", v.UserAgent,
+		"request_bytes", v.ContentLength,
+		"response_bytes", v.ResponseSize,
+	)
+	return nil
+}
+
+// initStores builds the response cache, usage aggregator and job runner.
+// Sections without a backend of their own use the shared storage backend,
+// except a cache partitioned across peers, which is always process-local.
+func (s *Server) initStores() error {
+	cfg := s.currentConfig()
+
+	var err error
+	s.cache = s.storage.Cache()
+	if cfg.Cache.Backend != "" || s.cache == nil || cfg.Cache.Peers.Enabled() {
+		if s.cache, err = cache.NewStore(cfg.Cache); err != nil {
+			return err
+		}
+	}
+	if cfg.Cache.Peers.Enabled() {
+		if err := s.partitionCache(cfg.Cache.Peers); err != nil {
+			return err
+		}
+	}
+
+	if s.usage, err = s.newUsageAggregator(); err != nil {
+		return err
+	}
+	if s.jobs, err = s.newJobRunner(); err != nil {
+		return err
+	}
+
+	// The monitor always exists so error rates are tracked from startup;
+	// alerts.enabled only gates evaluation.
+	var source alerts.UsageSource
+	if s.usage != nil {
+		source = s.usage
+	}
+	s.alerts = alerts.NewMonitor(s.currentConfig, source)
+
+	// The capture file is fixed for the lifetime of the process; reloads
+	// can pause capture or change what is kept.
+	if cfg.Capture.Enabled {
+		if s.recorder, err = capture.Open(cfg.Capture.Path, cfg.Capture.MaxBytes); err != nil {
+			return err
+		}
+	}
+	// Likewise the audit sink; reloads can change what is kept.
+	if cfg.Audit.Enabled {
+		if s.auditor, err = audit.Open(cfg.Audit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Run starts the HTTP server and blocks until the context is cancelled.
 func (s *Server) Run(ctx context.Context) error {
-	printStartupBanner(s.port())
-	slog.Info("starting server", "addr", s.address)
+	scheme := "http"
+	if s.tlsConfig != nil {
+		scheme = "https"
+	}
+	printStartupBanner(scheme, s.port())
+	slog.Info("starting server", "addr", s.address, "tls", s.tlsConfig != nil)
 
 	httpServer := &http.Server{
 		Addr:         s.address,
@@ -104,19 +312,99 @@ func (s *Server) Run(ctx context.Context) error {
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
 		IdleTimeout:  idleTimeout,
+		TLSConfig:    s.tlsConfig,
 	}
 
+	// Background workers stop with the server and must finish before the
+	// storage they write to is closed.
+	bgCtx, stopBackground := context.WithCancel(ctx)
+	var background sync.WaitGroup
+	defer func() {
+		stopBackground()
+		background.Wait()
+		if err := s.storage.Close(); err != nil {
+			slog.Warn("storage close failed", "error", err)
+		}
+		if s.recorder != nil {
+			if err := s.recorder.Close(); err != nil {
+				slog.Warn("capture file close failed", "error", err)
+			}
+		}
+		if s.auditor != nil {
+			if err := s.auditor.Close(); err != nil {
+				slog.Warn("audit log close failed", "error", err)
+			}
+		}
+		flushCtx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+		defer cancel()
+		if err := telemetry.ShutdownTracing(flushCtx); err != nil {
+			slog.Warn("trace export did not finish", "error", err)
+		}
+	}()
+
 	errCh := make(chan error, 1)
+	if s.usage != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := s.usage.Run(bgCtx); err != nil {
+				slog.Warn("usage aggregator stopped", "error", err)
+			}
+		}()
+	}
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		s.alerts.Run(bgCtx)
+	}()
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		s.refreshModels(bgCtx)
+	}()
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		s.probeProviders(bgCtx)
+	}()
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		s.refreshClientKeys(bgCtx)
+	}()
+	if s.jobs != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			if err := s.jobs.Run(bgCtx); err != nil {
+				select {
+				case errCh <- fmt.Errorf("job runner: %w", err):
+				default:
+				}
+			}
+		}()
+	}
 	go func() {
 		if err := s.app.StartServer(httpServer); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errCh <- err
 		}
 	}()
+	if s.acmeChallenges != nil {
+		slog.Info("answering ACME challenges", "addr", s.acmeChallenges.Addr)
+		go func() {
+			if err := s.acmeChallenges.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- fmt.Errorf("acme challenge listener: %w", err)
+			}
+		}()
+	}
 
 	select {
 	case <-ctx.Done():
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
 		defer cancel()
+		if s.acmeChallenges != nil {
+			s.acmeChallenges.Close()
+		}
 		if err := s.app.Shutdown(shutdownCtx); err != nil {
 			return fmt.Errorf("graceful shutdown failed: %w", err)
 		}
@@ -129,25 +417,86 @@ func (s *Server) Run(ctx context.Context) error {
 
 func (s *Server) registerRoutes() {
 	s.app.GET("/health", s.handleHealth)
-	s.app.POST("/v1/chat/completions", s.handleChatCompletions)
-	s.app.POST("/v1/completions", s.handleCompletions)
-	s.app.POST("/v1/messages", s.handleClaudeMessages)
+	s.app.GET("/health/ready", s.handleReady)
+	s.app.GET("/metrics", s.handleMetrics)
+	s.app.GET("/v1/models", s.handleListModels)
+	s.app.GET("/v1/models/:id", s.handleGetModel)
+	s.app.POST("/v1/chat/completions", s.handleChatCompletions, s.enforceRateLimit, s.enforceQuotas)
+	s.app.GET("/v1/chat/completions/:id", s.handleGetStoredCompletion)
+	s.app.POST("/v1/completions", s.handleCompletions, s.enforceRateLimit, s.enforceQuotas)
+	s.app.POST("/v1/estimate", s.handleEstimate)
+	s.app.POST("/v1/tokenize", s.handleTokenize)
+	s.app.POST("/v1/detokenize", s.handleDetokenize)
+	s.app.POST("/v1/messages", s.handleClaudeMessages, s.enforceRateLimit, s.enforceQuotas)
+	s.app.POST("/v1/messages/count_tokens", s.handleClaudeCountTokens, s.enforceRateLimit)
+	s.app.GET("/v1beta/models", s.handleGeminiListModels)
+	s.app.GET("/v1beta/models/*", s.handleGeminiGetModel)
+	s.app.POST("/v1beta/models/*", s.handleGeminiMethod, s.enforceRateLimit, s.enforceQuotas)
+	s.app.GET("/api/tags", s.handleOllamaTags)
+	s.app.POST("/api/chat", s.handleOllamaChat, s.enforceRateLimit, s.enforceQuotas)
+	s.app.POST("/v1/engines/:engine/completions", s.handleCopilotCompletions, s.enforceRateLimit, s.enforceQuotas)
+	s.app.POST("/mcp", s.handleMCP, s.enforceRateLimit, s.enforceQuotas)
+	s.app.POST("/v1/jobs", s.handleCreateJob, s.enforceRateLimit, s.enforceQuotas)
+	s.app.GET("/v1/jobs/:id", s.handleGetJob)
+	s.app.GET("/v1/usage", s.handleUsage)
+	s.app.GET(cache.PeerPath+":key", s.handleCachePeerGet)
+	s.app.PUT(cache.PeerPath+":key", s.handleCachePeerSet)
+
+	admin := s.app.Group("/admin", s.requireAdmin)
+	admin.GET("/quotas", s.handleAdminQuotas)
+	admin.GET("/usage", s.handleAdminUsage)
+	admin.GET("/balancers", s.handleAdminBalancers)
+	admin.GET("/health", s.handleAdminHealth)
+	admin.GET("/chaos", s.handleAdminChaos)
+	admin.POST("/chaos", s.handleAdminChaos)
+	admin.GET("/providers", s.handleAdminProviders)
+	admin.GET("/models", s.handleAdminModels)
+	admin.POST("/models/disable", s.handleAdminSwitchModel(false))
+	admin.POST("/models/enable", s.handleAdminSwitchModel(true))
+	admin.GET("/keys", s.handleAdminKeys)
+	admin.POST("/keys", s.handleAdminCreateKey)
+	admin.DELETE("/keys/:id", s.handleAdminRevokeKey)
+	admin.POST("/reload", s.handleAdminReload)
 }
 
 func (s *Server) handleHealth(c echo.Context) error {
-	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	body := map[string]string{"status": "ok"}
+	if version := s.ConfigVersion(); version != "" {
+		body["config_version"] = version
+	}
+	return c.JSON(http.StatusOK, body)
 }
 
 func (s *Server) handleChatCompletions(c echo.Context) error {
+	if handled, err := s.servePersisted(c, jobKindChat); handled {
+		return err
+	}
+
+	raw, err := s.bufferPassthroughBody(c)
+	if err != nil {
+		return err
+	}
 	var req translator.ChatCompletionRequest
 	if err := decodeRequestBody(c, &req); err != nil {
 		return err
 	}
+	requestedStream, err := s.streamRequested(c, req.Stream)
+	if err != nil {
+		return err
+	}
+
+	conversationID, err := s.checkConversationBudget(c)
+	if err != nil {
+		return err
+	}
 
-	ctx := c.Request().Context()
 	unifiedReq := req.ToUnified()
+	unifiedReq.Stream = false
+	ctx := s.tagRequest(c, unifiedReq.Options)
+	s.capChatOutput(c, &unifiedReq)
 
-	rt := s.currentRouter()
+	rt, release := s.acquireRouter()
+	defer release()
 	if rt == nil {
 		return requestError{
 			Status:  http.StatusServiceUnavailable,
@@ -155,8 +504,15 @@ func (s *Server) handleChatCompletions(c echo.Context) error {
 			Type:    "server_error",
 		}
 	}
+	if handled, err := s.servePassthrough(c, rt, "openai", "/chat/completions", req.Model, raw, requestedStream, conversationID); handled {
+		return err
+	}
+	if requestedStream {
+		return s.relayChatStream(c, rt, unifiedReq, conversationID, newOpenAIChunks(req.IncludeUsage))
+	}
 
-	resp, modelInfo, err := rt.Chat(ctx, unifiedReq)
+	resp, modelInfo, cached, err := s.routeChat(ctx, rt, unifiedReq)
+	setCacheHeader(c, cached)
 	if err != nil {
 		return toHTTPError(err)
 	}
@@ -168,20 +524,46 @@ func (s *Server) handleChatCompletions(c echo.Context) error {
 		}
 	}
 
+	s.recordConversationUsage(c, conversationID, resp.Usage)
+	s.recordUsage(ctx, usage.KeyID(clientKey(c)), modelInfo, resp.Usage)
+	s.annotateChatProvenance(c, modelInfo, resp)
+	s.captureChat(c, unifiedReq, modelInfo, resp)
+
 	openAIResp := translator.FromUnifiedChat(modelInfo.ID, time.Now().Unix(), resp)
+	s.storeCompletion(ctx, usage.KeyID(clientKey(c)), req, &openAIResp)
 	return c.JSON(http.StatusOK, openAIResp)
 }
 
 func (s *Server) handleCompletions(c echo.Context) error {
+	if handled, err := s.servePersisted(c, jobKindCompletion); handled {
+		return err
+	}
+
+	raw, err := s.bufferPassthroughBody(c)
+	if err != nil {
+		return err
+	}
 	var req translator.CompletionRequest
 	if err := decodeRequestBody(c, &req); err != nil {
 		return err
 	}
 
+	conversationID, err := s.checkConversationBudget(c)
+	if err != nil {
+		return err
+	}
+
 	ctx := c.Request().Context()
+	requestedStream, err := s.streamRequested(c, req.Stream)
+	if err != nil {
+		return err
+	}
 	unifiedReq := req.ToUnified()
+	unifiedReq.Stream = false
+	s.capCompletionOutput(c, &unifiedReq)
 
-	rt := s.currentRouter()
+	rt, release := s.acquireRouter()
+	defer release()
 	if rt == nil {
 		return requestError{
 			Status:  http.StatusServiceUnavailable,
@@ -190,7 +572,15 @@ func (s *Server) handleCompletions(c echo.Context) error {
 		}
 	}
 
-	resp, modelInfo, err := rt.Completion(ctx, unifiedReq)
+	if handled, err := s.servePassthrough(c, rt, "openai", "/completions", req.Model, raw, requestedStream, conversationID); handled {
+		return err
+	}
+	if requestedStream {
+		return s.relayCompletionStream(c, rt, unifiedReq, conversationID, newCompletionChunks)
+	}
+
+	resp, modelInfo, cached, err := s.routeCompletion(ctx, rt, unifiedReq)
+	setCacheHeader(c, cached)
 	if err != nil {
 		return toHTTPError(err)
 	}
@@ -202,22 +592,41 @@ func (s *Server) handleCompletions(c echo.Context) error {
 		}
 	}
 
+	s.recordConversationUsage(c, conversationID, resp.Usage)
+	s.recordUsage(ctx, usage.KeyID(clientKey(c)), modelInfo, resp.Usage)
+	s.annotateCompletionProvenance(c, modelInfo, resp)
+	s.captureCompletion(c, unifiedReq, modelInfo, resp)
+
 	openAIResp := translator.FromUnifiedCompletion(modelInfo.ID, time.Now().Unix(), resp)
 	return c.JSON(http.StatusOK, openAIResp)
 }
 
 func (s *Server) handleClaudeMessages(c echo.Context) error {
+	raw, err := s.bufferPassthroughBody(c)
+	if err != nil {
+		return err
+	}
 	var req translator.ClaudeMessageRequest
 	if err := decodeRequestBody(c, &req); err != nil {
 		return err
 	}
 
+	conversationID, err := s.checkConversationBudget(c)
+	if err != nil {
+		return err
+	}
+
 	ctx := c.Request().Context()
-	requestedStream := req.Stream
+	requestedStream, err := s.streamRequested(c, req.Stream)
+	if err != nil {
+		return err
+	}
 	unifiedReq := req.ToUnified()
 	unifiedReq.Stream = false
+	s.capChatOutput(c, &unifiedReq)
 
-	rt := s.currentRouter()
+	rt, release := s.acquireRouter()
+	defer release()
 	if rt == nil {
 		return requestError{
 			Status:  http.StatusServiceUnavailable,
@@ -226,7 +635,15 @@ func (s *Server) handleClaudeMessages(c echo.Context) error {
 		}
 	}
 
-	resp, modelInfo, err := rt.Chat(ctx, unifiedReq)
+	if handled, err := s.servePassthrough(c, rt, "claude", "/v1/messages", req.Model, raw, requestedStream, conversationID); handled {
+		return err
+	}
+	if requestedStream {
+		return s.relayChatStream(c, rt, unifiedReq, conversationID, newClaudeEvents)
+	}
+
+	resp, modelInfo, cached, err := s.routeChat(ctx, rt, unifiedReq)
+	setCacheHeader(c, cached)
 	if err != nil {
 		return toHTTPError(err)
 	}
@@ -238,30 +655,83 @@ func (s *Server) handleClaudeMessages(c echo.Context) error {
 		}
 	}
 
-	if requestedStream {
-		return writeClaudeStream(c, modelInfo.ID, resp)
-	}
+	s.recordConversationUsage(c, conversationID, resp.Usage)
+	s.recordUsage(ctx, usage.KeyID(clientKey(c)), modelInfo, resp.Usage)
+	s.annotateChatProvenance(c, modelInfo, resp)
+	s.captureChat(c, unifiedReq, modelInfo, resp)
 
 	claudeResp := translator.FromUnifiedClaude(modelInfo.ID, resp)
 	return c.JSON(http.StatusOK, claudeResp)
 }
 
 func (s *Server) setRouter(rt *router.Router) {
+	rt.UseHealth(s.health)
+	rt.UseDisabled(s.disabled.has)
+	rt.UseMirror(s.observeMirror)
+	rt.UseChaos(func(model string) chaos.Faults {
+		return s.chaos.Faults(s.currentConfig().Chaos, model)
+	})
 	s.routerMu.Lock()
-	defer s.routerMu.Unlock()
-	s.router = rt
+	old := s.router
+	s.router = newRouterRef(rt)
+	s.routerMu.Unlock()
+	if old != nil {
+		old.retire()
+	}
 }
 
 func (s *Server) currentRouter() *router.Router {
 	s.routerMu.RLock()
 	defer s.routerMu.RUnlock()
-	return s.router
+	if s.router == nil {
+		return nil
+	}
+	return s.router.rt
 }
 
 func (s *Server) setConfig(cfg config.Config) {
 	s.cfgMu.Lock()
 	defer s.cfgMu.Unlock()
 	s.cfg = cfg
+	s.captures = capture.NewPolicy(cfg.Capture)
+	if s.auditor != nil {
+		s.auditor.Configure(cfg.Audit)
+	}
+}
+
+// capturePolicy returns the capture policy compiled from the current
+// configuration.
+func (s *Server) capturePolicy() *capture.Policy {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.captures
+}
+
+func (s *Server) currentConfig() config.Config {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.cfg
+}
+
+// SetReloader sets how POST /admin/reload reloads the configuration. It
+// must be called before Run.
+func (s *Server) SetReloader(reload func(ctx context.Context) error) {
+	s.reload = reload
+}
+
+// SetConfigVersion records the version of the configuration currently in
+// effect so instances sharing a remote configuration can be compared.
+func (s *Server) SetConfigVersion(version string) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	s.configVersion = version
+}
+
+// ConfigVersion reports the version recorded by SetConfigVersion.
+func (s *Server) ConfigVersion() string {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.configVersion
 }
 
 func (s *Server) port() int {
@@ -281,18 +751,55 @@ func (s *Server) UpdateRouting(cfg config.Config, rt *router.Router) {
 		cfg.Server.Port = currentPort
 	}
 
+	// An unreadable key file or key store keeps the keys already loaded
+	// rather than opening or closing the API.
+	if clientKeys, err := loadClientKeys(context.Background(), cfg.Auth, s.storage.Keys()); err != nil {
+		slog.Warn("config reload could not load client keys; keeping existing keys", "error", err)
+	} else {
+		s.cfgMu.Lock()
+		s.clientKeys = clientKeys
+		s.cfgMu.Unlock()
+	}
+
 	s.setConfig(cfg)
 	s.setRouter(rt)
+	s.applyObservability(cfg.Observability)
+}
+
+// refreshModels keeps the discovered models of the current router up to
+// date until ctx ends. The router decides which providers are due.
+func (s *Server) refreshModels(ctx context.Context) {
+	ticker := time.NewTicker(modelRefreshTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if rt := s.currentRouter(); rt != nil {
+				rt.RefreshModels(ctx)
+			}
+		}
+	}
+}
+
+// applyObservability applies the parts of the observability policy that
+// live outside the request path.
+func (s *Server) applyObservability(obs config.ObservabilityConfig) {
+	telemetry.ApplyLogLevel(obs.LogLevel)
+	telemetry.ApplyTracing(obs.Tracing, obs.Sampling)
+	s.metrics.registry.SetMaxSeries(obs.Metrics.SeriesLimit())
 }
 
 func decodeRequestBody[T any](c echo.Context, target *T) error {
 	req := c.Request()
 	defer req.Body.Close()
 
-	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxBodyBytes)
-
 	decoder := json.NewDecoder(req.Body)
 	if err := decoder.Decode(target); err != nil {
+		if tooLarge, ok := bodyTooLarge(err); ok {
+			return tooLarge
+		}
 		if errors.Is(err, io.EOF) {
 			return requestError{
 				Status:  http.StatusBadRequest,
@@ -322,6 +829,9 @@ type requestError struct {
 	Message string
 	Type    string
 	Code    string
+	// RetryAfter, when positive, is sent as the Retry-After header in
+	// seconds.
+	RetryAfter int
 }
 
 func (e requestError) Error() string {
@@ -345,8 +855,17 @@ func writeError(c echo.Context, status int, message, errType, code string) error
 }
 
 func openAIErrorHandler(err error, c echo.Context) {
+	// The request logger hands errors to this handler itself and then
+	// returns them up the chain; only the first call may write.
+	if c.Response().Committed {
+		return
+	}
+
 	var reqErr requestError
 	if errors.As(err, &reqErr) {
+		if reqErr.RetryAfter > 0 {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(reqErr.RetryAfter))
+		}
 		_ = writeError(c, reqErr.Status, reqErr.Message, reqErr.Type, reqErr.Code)
 		return
 	}
@@ -384,6 +903,103 @@ func toHTTPError(err error) error {
 			Type:    "invalid_request_error",
 		}
 	}
+	var denied *plugin.DeniedError
+	if errors.As(err, &denied) {
+		return requestError{
+			Status:  denied.Status,
+			Message: denied.Error(),
+			Type:    "invalid_request_error",
+			Code:    "plugin_denied",
+		}
+	}
+	if errors.Is(err, fixture.ErrNoFixture) {
+		return requestError{
+			Status:  http.StatusNotFound,
+			Message: err.Error(),
+			Type:    "invalid_request_error",
+			Code:    "fixture_not_found",
+		}
+	}
+	var injected *chaos.Error
+	if errors.As(err, &injected) {
+		reqErr := requestError{
+			Status:  injected.Status,
+			Message: injected.Error(),
+			Type:    "upstream_error",
+			Code:    "chaos_injected",
+		}
+		if injected.Status == http.StatusTooManyRequests {
+			reqErr.RetryAfter = 1
+		}
+		return reqErr
+	}
+	var down *router.UnavailableError
+	if errors.As(err, &down) {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: down.Error(),
+			Type:    "upstream_error",
+			Code:    "provider_unavailable",
+		}
+	}
+	var limited *router.RateLimitedError
+	if errors.As(err, &limited) {
+		return requestError{
+			Status:     http.StatusTooManyRequests,
+			Message:    limited.Error(),
+			Type:       "rate_limit_error",
+			Code:       "upstream_rate_limited",
+			RetryAfter: max(1, int(math.Ceil(time.Until(limited.Until).Seconds()))),
+		}
+	}
+	var tooLong *router.ContextLengthError
+	if errors.As(err, &tooLong) {
+		return requestError{
+			Status:  http.StatusBadRequest,
+			Message: tooLong.Error(),
+			Type:    "invalid_request_error",
+			Code:    "context_length_exceeded",
+		}
+	}
+	var timedOut *router.TimeoutError
+	if errors.As(err, &timedOut) {
+		return requestError{
+			Status:  http.StatusGatewayTimeout,
+			Message: timedOut.Error(),
+			Type:    "upstream_error",
+			Code:    "upstream_timeout",
+		}
+	}
+	var disabled *router.DisabledError
+	if errors.As(err, &disabled) {
+		return requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: disabled.Error(),
+			Type:    "upstream_error",
+			Code:    "model_disabled",
+		}
+	}
+	var busy *router.ConcurrencyError
+	if errors.As(err, &busy) {
+		code := "model_concurrency_exceeded"
+		if busy.Provider != "" {
+			code = "provider_concurrency_exceeded"
+		}
+		return requestError{
+			Status:     http.StatusTooManyRequests,
+			Message:    busy.Error(),
+			Type:       "rate_limit_error",
+			Code:       code,
+			RetryAfter: 1,
+		}
+	}
+	if errors.Is(err, plugin.ErrFailed) {
+		return requestError{
+			Status:  http.StatusInternalServerError,
+			Message: "request plugin failed",
+			Type:    "server_error",
+		}
+	}
 
 	return requestError{
 		Status:  http.StatusBadGateway,
@@ -406,121 +1022,31 @@ func writeSSEEvent(w io.Writer, event string, payload any) error {
 	return nil
 }
 
-func printStartupBanner(port int) {
+func writeSSEData(w io.Writer, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal SSE payload: %w", err)
+	}
+	if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return fmt.Errorf("write SSE data: %w", err)
+	}
+	return nil
+}
+
+func printStartupBanner(scheme string, port int) {
 	host := "127.0.0.1"
 	fmt.Println()
 	fmt.Println("gocode-router ready")
-	fmt.Printf("Listening on http://%s:%d\n", host, port)
+	fmt.Printf("Listening on %s://%s:%d\n", scheme, host, port)
 	fmt.Println("Endpoints:")
 	fmt.Println("  GET  /health")
+	fmt.Println("  GET  /v1/models")
 	fmt.Println("  POST /v1/chat/completions")
 	fmt.Println("  POST /v1/completions")
 	fmt.Println("  POST /v1/messages")
+	fmt.Println("  POST /v1beta/models/{model}:generateContent")
+	fmt.Println("  POST /api/chat")
 	fmt.Println("Use OpenAI-compatible clients or Claude CLI; configured providers handle translation automatically.")
-	fmt.Printf("OpenAI-style example:\n  curl http://%s:%d/v1/chat/completions -H 'Content-Type: application/json' -d '{\"model\":\"claude-3-sonnet\",\"messages\":[{\"role\":\"user\",\"content\":\"hello\"}]}'\n", host, port)
-	fmt.Printf("Claude CLI example:\n  ANTHROPIC_API_URL=http://%s:%d claude chat -m claude-3-sonnet \"Hello\"\n\n", host, port)
-}
-
-func writeClaudeStream(c echo.Context, modelID string, resp *models.UnifiedChatResponse) error {
-	writer := c.Response().Writer
-	flusher, ok := writer.(http.Flusher)
-	if !ok {
-		slog.Error("http writer does not support flushing")
-		return requestError{
-			Status:  http.StatusInternalServerError,
-			Message: "server does not support streaming responses",
-			Type:    "server_error",
-		}
-	}
-
-	header := c.Response().Header()
-	header.Set("Content-Type", "text/event-stream")
-	header.Set("Cache-Control", "no-cache")
-	header.Set("Connection", "keep-alive")
-
-	c.Response().WriteHeader(http.StatusOK)
-
-	usage := map[string]int{
-		"input_tokens":  resp.Usage.PromptTokens,
-		"output_tokens": resp.Usage.CompletionTokens,
-		"total_tokens":  resp.Usage.TotalTokens,
-	}
-
-	events := []struct {
-		name    string
-		payload any
-	}{
-		{
-			name: "message_start",
-			payload: map[string]any{
-				"type": "message_start",
-				"message": map[string]any{
-					"id":            resp.ID,
-					"type":          "message",
-					"role":          resp.Message.Role,
-					"model":         modelID,
-					"content":       []any{},
-					"stop_reason":   nil,
-					"stop_sequence": nil,
-					"usage":         usage,
-				},
-			},
-		},
-		{
-			name: "content_block_start",
-			payload: map[string]any{
-				"type":  "content_block_start",
-				"index": 0,
-				"content_block": map[string]any{
-					"type": "text",
-					"text": "",
-				},
-			},
-		},
-		{
-			name: "content_block_delta",
-			payload: map[string]any{
-				"type":  "content_block_delta",
-				"index": 0,
-				"delta": map[string]any{
-					"type": "text_delta",
-					"text": resp.Message.Content,
-				},
-			},
-		},
-		{
-			name: "content_block_stop",
-			payload: map[string]any{
-				"type":  "content_block_stop",
-				"index": 0,
-			},
-		},
-		{
-			name: "message_delta",
-			payload: map[string]any{
-				"type": "message_delta",
-				"delta": map[string]any{
-					"stop_reason":   resp.FinishReason,
-					"stop_sequence": nil,
-				},
-				"usage": usage,
-			},
-		},
-		{
-			name: "message_stop",
-			payload: map[string]any{
-				"type": "message_stop",
-			},
-		},
-	}
-
-	for _, event := range events {
-		if err := writeSSEEvent(writer, event.name, event.payload); err != nil {
-			slog.Error("failed to write SSE event", "event", event.name, "err", err)
-			return err
-		}
-		flusher.Flush()
-	}
-
-	return nil
+	fmt.Printf("OpenAI-style example:\n  curl %s://%s:%d/v1/chat/completions -H 'Content-Type: application/json' -d '{\"model\":\"claude-3-sonnet\",\"messages\":[{\"role\":\"user\",\"content\":\"hello\"}]}'\n", scheme, host, port)
+	fmt.Printf("Claude CLI example:\n  ANTHROPIC_API_URL=%s://%s:%d claude chat -m claude-3-sonnet \"Hello\"\n\n", scheme, host, port)
 }
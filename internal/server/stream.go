@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/chaos"
+	"gocode-router/internal/config"
+)
+
+const eventStreamMIME = "text/event-stream"
+
+// wantsStream reports whether the client asked for a streamed response,
+// either with stream=true in the body or by preferring text/event-stream in
+// its Accept header. Some clients only do the latter.
+func wantsStream(c echo.Context, requested bool) bool {
+	return requested || acceptsEventStream(c.Request().Header.Get(echo.HeaderAccept))
+}
+
+// streamRequested reports whether the client asked for a streamed response,
+// failing when streaming is disabled.
+func (s *Server) streamRequested(c echo.Context, requested bool) (bool, error) {
+	if !wantsStream(c, requested) {
+		return false, nil
+	}
+	if s.currentConfig().Server.Streaming.Disabled {
+		return false, streamingDisabled()
+	}
+	return true, nil
+}
+
+// streamFaults rolls the chaos faults for a stream from the model.
+func (s *Server) streamFaults(model string) chaos.Faults {
+	return s.chaos.Faults(s.currentConfig().Chaos, model)
+}
+
+// acceptsEventStream reports whether an Accept header prefers an event
+// stream over JSON. Listing both at the same quality, as MCP-style clients
+// do, keeps the JSON default.
+func acceptsEventStream(accept string) bool {
+	if accept == "" {
+		return false
+	}
+	var streamQ, jsonQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if raw, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(raw, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case eventStreamMIME:
+			streamQ = max(streamQ, q)
+		case echo.MIMEApplicationJSON:
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return streamQ > 0 && streamQ > jsonQ
+}
+
+func streamingDisabled() error {
+	return requestError{
+		Status:  http.StatusNotAcceptable,
+		Message: fmt.Sprintf("streaming is disabled on this server; retry without stream=true or Accept: %s", eventStreamMIME),
+		Type:    "invalid_request_error",
+		Code:    "streaming_disabled",
+	}
+}
+
+// sseWriter writes the events of a streamed response, flushing them under
+// the configured policy. With coalescing, events are held until enough
+// bytes are pending or the oldest has waited the flush interval.
+type sseWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	flusher http.Flusher
+	policy  config.StreamingConfig
+	pending int
+	timer   *time.Timer
+	buf     bytes.Buffer
+
+	// faults are the chaos faults injected into the stream; events counts
+	// the events sent so far and cut is set once the stream is truncated.
+	faults chaos.Faults
+	events int
+	cut    bool
+}
+
+// startStream sends the event stream headers and returns a writer for the
+// events. A Content-Type the handler set already is kept, for streams
+// that are not server-sent events.
+func startStream(c echo.Context, policy config.StreamingConfig, faults chaos.Faults) (*sseWriter, error) {
+	writer := c.Response().Writer
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		slog.Error("http writer does not support flushing")
+		return nil, requestError{
+			Status:  http.StatusInternalServerError,
+			Message: "server does not support streaming responses",
+			Type:    "server_error",
+		}
+	}
+
+	header := c.Response().Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", eventStreamMIME)
+	}
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+
+	c.Response().WriteHeader(http.StatusOK)
+	return &sseWriter{w: writer, flusher: flusher, policy: policy, faults: faults}, nil
+}
+
+// send writes one event, rendered by write, as a single write to the
+// client.
+func (s *sseWriter) send(write func(io.Writer) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cut {
+		return nil
+	}
+	s.buf.Reset()
+	if err := write(&s.buf); err != nil {
+		return err
+	}
+	s.injectFaults()
+	n, err := s.w.Write(s.buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	if !s.policy.Coalesce() {
+		s.flusher.Flush()
+		return nil
+	}
+	s.pending += n
+	if s.pending >= s.policy.Bytes() {
+		s.flushLocked()
+		return nil
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.policy.Interval(), s.flush)
+	}
+	return nil
+}
+
+// injectFaults corrupts or cuts the event in the buffer when chaos chose
+// to. A malformed event loses the closing brace of its JSON; a truncated
+// stream ends half way through an event, and every later event, including
+// the final ones, is dropped.
+func (s *sseWriter) injectFaults() {
+	s.events++
+	if s.events == s.faults.MalformAt {
+		if i := bytes.LastIndexByte(s.buf.Bytes(), '}'); i >= 0 {
+			event := s.buf.Bytes()
+			s.buf.Truncate(copy(event[i:], event[i+1:]) + i)
+		}
+	}
+	if s.events == s.faults.TruncateAt {
+		s.buf.Truncate(s.buf.Len() / 2)
+		s.cut = true
+	}
+}
+
+func (s *sseWriter) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+func (s *sseWriter) flushLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.pending > 0 {
+		s.pending = 0
+		s.flusher.Flush()
+	}
+}
+
+// event writes a named event.
+func (s *sseWriter) event(name string, payload any) error {
+	return s.send(func(w io.Writer) error { return writeSSEEvent(w, name, payload) })
+}
+
+// data writes an unnamed event.
+func (s *sseWriter) data(payload any) error {
+	return s.send(func(w io.Writer) error { return writeSSEData(w, payload) })
+}
+
+// line writes payload as a line of newline-delimited JSON.
+func (s *sseWriter) line(payload any) error {
+	return s.send(func(w io.Writer) error {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("marshal stream line: %w", err)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	})
+}
+
+// done writes the OpenAI [DONE] sentinel.
+func (s *sseWriter) done() error {
+	return s.send(func(w io.Writer) error {
+		_, err := io.WriteString(w, "data: [DONE]\n\n")
+		return err
+	})
+}
+
+// close flushes whatever is still pending. The writer must not be used
+// afterwards.
+func (s *sseWriter) close() {
+	s.flush()
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/provider"
+)
+
+// tagsHeader carries request tags as comma-separated name=value pairs, such
+// as "team=search, repo=web".
+const tagsHeader = "X-Router-Tags"
+
+// maxTagValueLength bounds tag values, matching OpenAI's metadata limit.
+const maxTagValueLength = 512
+
+// headerTags parses the tags header, keeping the tags the policy accepts.
+func headerTags(policy config.TagsConfig, header string) map[string]string {
+	if header == "" {
+		return nil
+	}
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		if ok {
+			addTag(policy, tags, name, value)
+		}
+	}
+	return tags
+}
+
+// addTag adds a tag unless it is invalid, not accepted, already set or
+// over the limit.
+func addTag(policy config.TagsConfig, tags map[string]string, name, value string) {
+	name = strings.TrimSpace(name)
+	value = strings.TrimSpace(value)
+	if value == "" || !config.ValidTagName(name) || !policy.Accepts(name) {
+		return
+	}
+	if _, ok := tags[name]; ok || len(tags) >= config.MaxTags {
+		return
+	}
+	if len(value) > maxTagValueLength {
+		value = strings.ToValidUTF8(value[:maxTagValueLength], "")
+	}
+	tags[name] = value
+}
+
+// withMetadataTags adds the string entries of the request metadata to the
+// tags carried by ctx. Tags from the header take precedence.
+func withMetadataTags(ctx context.Context, policy config.TagsConfig, options map[string]any) context.Context {
+	metadata, _ := options["metadata"].(map[string]any)
+	if len(metadata) == 0 {
+		return ctx
+	}
+	tags := maps.Clone(provider.TagsFrom(ctx))
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	for _, name := range slices.Sorted(maps.Keys(metadata)) {
+		if value, ok := metadata[name].(string); ok {
+			addTag(policy, tags, name, value)
+		}
+	}
+	return provider.WithTags(ctx, tags, policy.Upstream)
+}
+
+// tagRequest adds the tags in the request metadata to the request context
+// and returns the context.
+func (s *Server) tagRequest(c echo.Context, options map[string]any) context.Context {
+	req := c.Request()
+	ctx := withMetadataTags(req.Context(), s.currentConfig().Tags, options)
+	c.SetRequest(req.WithContext(ctx))
+	return ctx
+}
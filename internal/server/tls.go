@@ -0,0 +1,141 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"gocode-router/internal/config"
+)
+
+// certCheckInterval is how often certificate files are checked for a
+// renewed certificate.
+const certCheckInterval = 10 * time.Second
+
+// newTLSConfig builds the listener's TLS configuration. With ACME it also
+// returns the handler answering HTTP-01 challenges on plain HTTP.
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, http.Handler, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	var challenges http.Handler
+	if len(cfg.ACME.Domains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.ACME.Domains...),
+			Cache:      autocert.DirCache(cfg.ACME.Cache()),
+			Email:      cfg.ACME.Email,
+		}
+		if cfg.ACME.DirectoryURL != "" {
+			manager.Client = &acme.Client{DirectoryURL: cfg.ACME.DirectoryURL}
+		}
+		tlsConfig.GetCertificate = manager.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		challenges = manager.HTTPHandler(nil)
+	} else {
+		certs := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+		if _, err := certs.load(); err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.GetCertificate = certs.GetCertificate
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("client CA file %s holds no PEM certificates", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if challenges != nil {
+			// The ACME authority presents no client certificate when it
+			// checks a TLS-ALPN-01 challenge.
+			challengeConfig := tlsConfig.Clone()
+			challengeConfig.ClientAuth = tls.NoClientCert
+			tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+					return challengeConfig, nil
+				}
+				return nil, nil
+			}
+		}
+	}
+	return tlsConfig, challenges, nil
+}
+
+// certReloader serves a certificate from files, reading them again when
+// they change so a renewed certificate is picked up without a restart.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+	checked  time.Time
+}
+
+// GetCertificate implements tls.Config.GetCertificate. A renewed
+// certificate that fails to load is logged and the previous one kept.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	due := time.Since(r.checked) >= certCheckInterval
+	cert := r.cert
+	r.mu.Unlock()
+	if !due {
+		return cert, nil
+	}
+	reloaded, err := r.load()
+	if err != nil && cert != nil {
+		return cert, nil
+	}
+	return reloaded, err
+}
+
+// load reads the key pair if either file changed since it was last read.
+func (r *certReloader) load() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checked = time.Now()
+
+	var modTimes [2]time.Time
+	for i, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("tls certificate: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if r.cert != nil && modTimes == r.modTimes {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		err = fmt.Errorf("load tls certificate: %w", err)
+		if r.cert != nil {
+			slog.Warn("tls certificate reload failed; keeping the previous one", "error", err)
+		}
+		return nil, err
+	}
+	if r.cert != nil {
+		slog.Info("tls certificate reloaded", "path", r.certFile)
+	}
+	r.cert = &cert
+	r.modTimes = modTimes
+	return r.cert, nil
+}
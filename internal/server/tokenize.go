@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/tokens"
+	"gocode-router/internal/translator"
+)
+
+type tokenizeRequest struct {
+	Model    string                   `json:"model"`
+	Prompt   *string                  `json:"prompt"`
+	Messages []translator.ChatMessage `json:"messages"`
+}
+
+type tokenizeResponse struct {
+	Model     string `json:"model"`
+	Tokenizer string `json:"tokenizer"`
+	Count     int    `json:"count"`
+	Tokens    []int  `json:"tokens,omitempty"`
+}
+
+type detokenizeRequest struct {
+	Model  string `json:"model"`
+	Tokens []int  `json:"tokens"`
+}
+
+type detokenizeResponse struct {
+	Model     string `json:"model"`
+	Tokenizer string `json:"tokenizer"`
+	Prompt    string `json:"prompt"`
+}
+
+// handleTokenize counts a prompt or chat messages with the model's local
+// tokenizer. A prompt also returns its token IDs; messages return only the
+// count, which includes the chat framing.
+func (s *Server) handleTokenize(c echo.Context) error {
+	var req tokenizeRequest
+	if err := decodeRequestBody(c, &req); err != nil {
+		return err
+	}
+	if (req.Prompt == nil) == (len(req.Messages) == 0) {
+		return invalidTokenizeRequest("provide either prompt or messages")
+	}
+	tokenizer, modelID, err := s.tokenizerFor(req.Model)
+	if err != nil {
+		return err
+	}
+
+	resp := tokenizeResponse{Model: modelID, Tokenizer: tokenizer.Name()}
+	if req.Prompt != nil {
+		resp.Tokens = tokenizer.Encode(*req.Prompt)
+		resp.Count = len(resp.Tokens)
+	} else {
+		chat := translator.ChatCompletionRequest{Model: req.Model, Messages: req.Messages}.ToUnified()
+		resp.Count = tokens.CountMessages(tokenizer, chat.Messages)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// handleDetokenize turns token IDs back into text with the model's local
+// tokenizer.
+func (s *Server) handleDetokenize(c echo.Context) error {
+	var req detokenizeRequest
+	if err := decodeRequestBody(c, &req); err != nil {
+		return err
+	}
+	tokenizer, modelID, err := s.tokenizerFor(req.Model)
+	if err != nil {
+		return err
+	}
+	prompt, err := tokenizer.Decode(req.Tokens)
+	if err != nil {
+		return invalidTokenizeRequest(err.Error())
+	}
+	return c.JSON(http.StatusOK, detokenizeResponse{Model: modelID, Tokenizer: tokenizer.Name(), Prompt: prompt})
+}
+
+func (s *Server) tokenizerFor(model string) (tokens.Tokenizer, string, error) {
+	if model == "" {
+		return nil, "", invalidTokenizeRequest("model is required")
+	}
+	rt := s.currentRouter()
+	if rt == nil {
+		return nil, "", requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "router not initialised",
+			Type:    "server_error",
+		}
+	}
+	// Tokenizers match the upstream model ID an alias resolves to.
+	modelInfo, err := rt.LookupModel(model)
+	if err != nil {
+		return nil, "", toHTTPError(err)
+	}
+	return rt.Tokenizer(modelInfo.ID), modelInfo.ID, nil
+}
+
+func invalidTokenizeRequest(message string) error {
+	return requestError{
+		Status:  http.StatusBadRequest,
+		Message: message,
+		Type:    "invalid_request_error",
+	}
+}
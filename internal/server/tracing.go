@@ -0,0 +1,52 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/telemetry"
+)
+
+// traceRequest records each request as a server span, continuing the
+// client's trace when it sends a traceparent header.
+func (s *Server) traceRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx := telemetry.WithTraceparent(req.Context(), req.Header.Get("traceparent"))
+		ctx, span := telemetry.StartSpan(ctx, req.Method+" "+route, telemetry.SpanServer)
+		if span == nil {
+			return next(c)
+		}
+		defer span.End()
+		span.SetAttributes(
+			"http.request.method", req.Method,
+			"http.route", route,
+			"url.path", req.URL.Path,
+			"request.id", c.Response().Header().Get(echo.HeaderXRequestID),
+		)
+		c.SetRequest(req.WithContext(ctx))
+
+		err := next(c)
+		if err != nil {
+			// Write the error response now so its status is known; the
+			// request logger's own call is then a no-op.
+			c.Error(err)
+		}
+		status := c.Response().Status
+		span.SetAttributes("http.response.status_code", status)
+		// Failures are those the request log counts as failed.
+		switch {
+		case err != nil:
+			span.RecordError(err)
+		case status >= http.StatusInternalServerError:
+			span.RecordError(errors.New(http.StatusText(status)))
+		}
+		return err
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"gocode-router/internal/audit"
+	"gocode-router/internal/models"
+	"gocode-router/internal/provider"
+	"gocode-router/internal/usage"
+)
+
+type usageListResponse struct {
+	Object      string         `json:"object"`
+	Granularity string         `json:"granularity"`
+	Data        []usage.Bucket `json:"data"`
+	// Total sums Data.
+	Total usage.Total `json:"total"`
+}
+
+type adminUsageResponse struct {
+	Object      string        `json:"object"`
+	Granularity string        `json:"granularity"`
+	GroupBy     string        `json:"group_by"`
+	Data        []usage.Total `json:"data"`
+	Total       usage.Total   `json:"total"`
+}
+
+// newUsageAggregator builds the usage aggregator when usage recording is
+// enabled. The store is fixed for the lifetime of the process.
+func (s *Server) newUsageAggregator() (*usage.Aggregator, error) {
+	cfg := s.currentConfig().Usage
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	store := s.storage.Usage()
+	if cfg.Backend != "" {
+		var err error
+		if store, err = usage.NewStore(cfg); err != nil {
+			return nil, fmt.Errorf("initialise usage store: %w", err)
+		}
+	}
+	aggregator, err := usage.NewAggregator(store, cfg.FlushInterval(), cfg.RawRetentionPeriod())
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return aggregator, nil
+}
+
+// requestStartKey carries the time a request arrived, so its usage record
+// can tell how long it took.
+type requestStartKey struct{}
+
+func withRequestStart(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, requestStartKey{}, t)
+}
+
+// requestLatency returns how long ago the request carried by ctx arrived,
+// or zero when ctx does not say.
+func requestLatency(ctx context.Context) time.Duration {
+	start, ok := ctx.Value(requestStartKey{}).(time.Time)
+	if !ok {
+		return 0
+	}
+	return time.Since(start)
+}
+
+// recordUsage records the usage of a request that succeeded.
+func (s *Server) recordUsage(ctx context.Context, keyID string, modelInfo models.Model, u models.Usage) {
+	s.recordUsageStatus(ctx, keyID, modelInfo, u, http.StatusOK)
+}
+
+// recordUsageStatus prices the usage of a finished request, queues it for
+// aggregation with the status it ended with, charges it to the key's quotas
+// and counts it in the token and spend metrics, attributed to the tags
+// carried by ctx.
+func (s *Server) recordUsageStatus(ctx context.Context, keyID string, modelInfo models.Model, u models.Usage, status int) {
+	tags := provider.TagsFrom(ctx)
+	cost := s.usageCost(modelInfo, u)
+	s.observeTokens(keyID, modelInfo, u, cost, tags)
+	s.observeExperimentTokens(ctx, u)
+	audit.FromContext(ctx).AddUsage(modelInfo, u, cost)
+	s.chargeQuotas(ctx, keyID, u, cost)
+	if s.usage == nil {
+		return
+	}
+	s.usage.Add(usage.Record{
+		Key:              keyID,
+		Tags:             tags,
+		Model:            modelInfo.ID,
+		Provider:         modelInfo.Provider,
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+		Cost:             cost,
+		Status:           status,
+		LatencyMS:        requestLatency(ctx).Milliseconds(),
+	})
+}
+
+// usageCost prices usage with the serving model's pricing, or returns zero
+// when it has none.
+func (s *Server) usageCost(modelInfo models.Model, u models.Usage) float64 {
+	pricing, ok := s.currentConfig().Providers.Pricing(modelInfo.Provider, modelInfo.ID)
+	if !ok {
+		return 0
+	}
+	return pricing.Cost(u.PromptTokens, u.CompletionTokens)
+}
+
+// handleUsage returns hourly or daily usage rollups for the calling key.
+func (s *Server) handleUsage(c echo.Context) error {
+	if s.usage == nil {
+		return echo.ErrNotFound
+	}
+	query, err := usageQuery(c)
+	if err != nil {
+		return err
+	}
+	query.Key = usage.KeyID(clientKey(c))
+
+	buckets, err := s.queryUsage(c, query)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, usageListResponse{
+		Object:      "list",
+		Granularity: string(query.Granularity),
+		Data:        buckets,
+		Total:       usage.Overall(buckets),
+	})
+}
+
+// handleAdminUsage totals usage and spend across keys, grouped by key,
+// model, provider or bucket. ?key= narrows it to one key ID.
+func (s *Server) handleAdminUsage(c echo.Context) error {
+	if s.usage == nil {
+		return echo.ErrNotFound
+	}
+	query, err := usageQuery(c)
+	if err != nil {
+		return err
+	}
+	query.Key = c.QueryParam("key")
+
+	groupBy := c.QueryParam("group_by")
+	switch groupBy {
+	case "":
+		groupBy = usage.GroupByKey
+	case usage.GroupByKey, usage.GroupByModel, usage.GroupByProvider, usage.GroupByTime:
+	default:
+		return invalidUsageQuery(fmt.Sprintf("group_by must be %q, %q, %q or %q",
+			usage.GroupByKey, usage.GroupByModel, usage.GroupByProvider, usage.GroupByTime))
+	}
+
+	buckets, err := s.queryUsage(c, query)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, adminUsageResponse{
+		Object:      "list",
+		Granularity: string(query.Granularity),
+		GroupBy:     groupBy,
+		Data:        usage.Sum(buckets, groupBy),
+		Total:       usage.Overall(buckets),
+	})
+}
+
+// usageQuery reads the granularity, time range, model and provider filters
+// shared by the usage endpoints.
+func usageQuery(c echo.Context) (usage.Query, error) {
+	query := usage.Query{
+		Granularity: usage.Hourly,
+		Model:       c.QueryParam("model"),
+		Provider:    c.QueryParam("provider"),
+	}
+
+	switch g := c.QueryParam("granularity"); g {
+	case "", string(usage.Hourly):
+	case string(usage.Daily):
+		query.Granularity = usage.Daily
+	default:
+		return usage.Query{}, invalidUsageQuery(fmt.Sprintf("granularity must be %q or %q", usage.Hourly, usage.Daily))
+	}
+
+	var err error
+	if query.From, err = parseUsageTime(c.QueryParam("from")); err != nil {
+		return usage.Query{}, invalidUsageQuery(fmt.Sprintf("from: %v", err))
+	}
+	if query.To, err = parseUsageTime(c.QueryParam("to")); err != nil {
+		return usage.Query{}, invalidUsageQuery(fmt.Sprintf("to: %v", err))
+	}
+	return query, nil
+}
+
+func (s *Server) queryUsage(c echo.Context, query usage.Query) ([]usage.Bucket, error) {
+	buckets, err := s.usage.Query(c.Request().Context(), query)
+	if err != nil {
+		return nil, requestError{
+			Status:  http.StatusServiceUnavailable,
+			Message: "failed to load usage",
+			Type:    "server_error",
+		}
+	}
+	return buckets, nil
+}
+
+// parseUsageTime accepts RFC 3339 timestamps or Unix seconds.
+func parseUsageTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC 3339 nor Unix seconds", value)
+	}
+	return time.Unix(seconds, 0), nil
+}
+
+func invalidUsageQuery(message string) error {
+	return requestError{
+		Status:  http.StatusBadRequest,
+		Message: message,
+		Type:    "invalid_request_error",
+	}
+}
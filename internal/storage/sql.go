@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+
+	"gocode-router/internal/cache"
+	"gocode-router/internal/completions"
+	"gocode-router/internal/jobs"
+	"gocode-router/internal/keys"
+	"gocode-router/internal/usage"
+)
+
+const (
+	sqlOpenTimeout = 10 * time.Second
+	sqlMaxConns    = 16
+)
+
+// dialect captures the differences between the supported SQL databases.
+type dialect struct {
+	name       string
+	driver     string
+	blobType   string
+	numbered   bool
+	singleConn bool
+}
+
+var (
+	sqliteDialect = dialect{
+		name:     "sqlite",
+		driver:   "sqlite",
+		blobType: "BLOB",
+		// SQLite serialises writers; one connection avoids SQLITE_BUSY.
+		singleConn: true,
+	}
+	postgresDialect = dialect{
+		name:     "postgres",
+		driver:   "postgres",
+		blobType: "BYTEA",
+		numbered: true,
+	}
+)
+
+// rebind rewrites ? placeholders into the dialect's syntax.
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (d dialect) schema() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			created_at BIGINT NOT NULL,
+			updated_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS jobs_status_idx ON jobs (status, created_at)`,
+		`CREATE TABLE IF NOT EXISTS usage_records (
+			recorded_at BIGINT NOT NULL,
+			key_id TEXT NOT NULL,
+			model TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			prompt_tokens BIGINT NOT NULL,
+			completion_tokens BIGINT NOT NULL,
+			total_tokens BIGINT NOT NULL,
+			tags TEXT NOT NULL DEFAULT '',
+			cost DOUBLE PRECISION NOT NULL DEFAULT 0,
+			status INTEGER NOT NULL DEFAULT 0,
+			latency_ms BIGINT NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS usage_records_time_idx ON usage_records (recorded_at)`,
+		`CREATE TABLE IF NOT EXISTS usage_rollups (
+			granularity TEXT NOT NULL,
+			bucket_start BIGINT NOT NULL,
+			key_id TEXT NOT NULL,
+			model TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			requests BIGINT NOT NULL,
+			prompt_tokens BIGINT NOT NULL,
+			completion_tokens BIGINT NOT NULL,
+			total_tokens BIGINT NOT NULL,
+			cost DOUBLE PRECISION NOT NULL DEFAULT 0,
+			failed BIGINT NOT NULL DEFAULT 0,
+			latency_ms BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (granularity, bucket_start, key_id, model, provider)
+		)`,
+		`CREATE TABLE IF NOT EXISTS response_cache (
+			cache_key TEXT PRIMARY KEY,
+			value ` + d.blobType + ` NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS stored_completions (
+			id TEXT PRIMARY KEY,
+			created_at BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS client_keys (
+			id TEXT PRIMARY KEY,
+			hash TEXT NOT NULL,
+			created_at BIGINT NOT NULL
+		)`,
+	}
+}
+
+// addedColumn is a column added to a table after its first release, which
+// CREATE TABLE IF NOT EXISTS does not add to existing databases.
+type addedColumn struct {
+	table      string
+	column     string
+	definition string
+}
+
+var addedColumns = []addedColumn{
+	{table: "usage_records", column: "tags", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "usage_records", column: "cost", definition: "DOUBLE PRECISION NOT NULL DEFAULT 0"},
+	{table: "usage_rollups", column: "cost", definition: "DOUBLE PRECISION NOT NULL DEFAULT 0"},
+	{table: "usage_records", column: "status", definition: "INTEGER NOT NULL DEFAULT 0"},
+	{table: "usage_records", column: "latency_ms", definition: "BIGINT NOT NULL DEFAULT 0"},
+	{table: "usage_rollups", column: "failed", definition: "BIGINT NOT NULL DEFAULT 0"},
+	{table: "usage_rollups", column: "latency_ms", definition: "BIGINT NOT NULL DEFAULT 0"},
+}
+
+// hasColumnQuery counts the columns of a table with a given name.
+func (d dialect) hasColumnQuery() string {
+	if d.numbered {
+		return `SELECT COUNT(*) FROM information_schema.columns WHERE table_name = $1 AND column_name = $2`
+	}
+	return `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
+}
+
+// addColumns adds the columns missing from tables created by an earlier
+// release.
+func addColumns(ctx context.Context, db *sql.DB, d dialect) error {
+	for _, col := range addedColumns {
+		var n int
+		if err := db.QueryRowContext(ctx, d.hasColumnQuery(), col.table, col.column).Scan(&n); err != nil {
+			return fmt.Errorf("inspect %s.%s: %w", col.table, col.column, err)
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `ALTER TABLE `+col.table+` ADD COLUMN `+col.column+` `+col.definition); err != nil {
+			return fmt.Errorf("add %s.%s: %w", col.table, col.column, err)
+		}
+	}
+	return nil
+}
+
+// sqlBackend stores everything in one SQL database. The individual stores
+// share the connection pool, which is closed with the backend.
+type sqlBackend struct {
+	db          *sql.DB
+	dialect     dialect
+	completions *sqlCompletionStore
+}
+
+func openSQLBackend(d dialect, dsn string, completionRetention time.Duration) (*sqlBackend, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open %s storage: %w", d.name, err)
+	}
+	if d.singleConn {
+		db.SetMaxOpenConns(1)
+	} else {
+		db.SetMaxOpenConns(sqlMaxConns)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sqlOpenTimeout)
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect %s storage: %w", d.name, err)
+	}
+	for _, stmt := range d.schema() {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("migrate %s storage: %w", d.name, err)
+		}
+	}
+	if err := addColumns(ctx, db, d); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate %s storage: %w", d.name, err)
+	}
+	return &sqlBackend{
+		db:          db,
+		dialect:     d,
+		completions: &sqlCompletionStore{db: db, dialect: d, retention: completionRetention},
+	}, nil
+}
+
+func (b *sqlBackend) Jobs() jobs.Store               { return &sqlJobStore{db: b.db, dialect: b.dialect} }
+func (b *sqlBackend) Usage() usage.Store             { return &sqlUsageStore{db: b.db, dialect: b.dialect} }
+func (b *sqlBackend) Cache() cache.Store             { return &sqlCacheStore{db: b.db, dialect: b.dialect} }
+func (b *sqlBackend) Completions() completions.Store { return b.completions }
+func (b *sqlBackend) Keys() keys.Store               { return &sqlKeyStore{db: b.db, dialect: b.dialect} }
+
+func (b *sqlBackend) Close() error {
+	return b.db.Close()
+}
+
+// withTx runs fn in a transaction, committing when it succeeds.
+func withTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
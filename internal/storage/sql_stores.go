@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gocode-router/internal/completions"
+	"gocode-router/internal/jobs"
+	"gocode-router/internal/keys"
+	"gocode-router/internal/usage"
+)
+
+// cacheSweepEvery controls how many cache writes pass between deletions of
+// expired rows.
+const cacheSweepEvery = 1000
+
+// sqlJobStore implements jobs.Store. The job is kept as JSON alongside the
+// columns needed to query it.
+type sqlJobStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (s *sqlJobStore) Save(ctx context.Context, job jobs.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshal job: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO jobs (id, status, created_at, updated_at, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			status = excluded.status,
+			updated_at = excluded.updated_at,
+			data = excluded.data`),
+		job.ID, string(job.Status), job.CreatedAt.UnixNano(), job.UpdatedAt.UnixNano(), string(data))
+	if err != nil {
+		return fmt.Errorf("save job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+func (s *sqlJobStore) Get(ctx context.Context, id string) (jobs.Job, bool, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT data FROM jobs WHERE id = ?`), id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return jobs.Job{}, false, nil
+	}
+	if err != nil {
+		return jobs.Job{}, false, fmt.Errorf("load job %s: %w", id, err)
+	}
+
+	var job jobs.Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return jobs.Job{}, false, fmt.Errorf("decode job %s: %w", id, err)
+	}
+	return job, true, nil
+}
+
+func (s *sqlJobStore) List(ctx context.Context, statuses ...jobs.Status) ([]jobs.Job, error) {
+	query := `SELECT data FROM jobs`
+	args := make([]any, 0, len(statuses))
+	if len(statuses) > 0 {
+		query += ` WHERE status IN (` + placeholders(len(statuses)) + `)`
+		for _, status := range statuses {
+			args = append(args, string(status))
+		}
+	}
+	query += ` ORDER BY created_at, id`
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var out []jobs.Job
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("list jobs: %w", err)
+		}
+		var job jobs.Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return nil, fmt.Errorf("decode job: %w", err)
+		}
+		out = append(out, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	return out, nil
+}
+
+func (s *sqlJobStore) Delete(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := `DELETE FROM jobs WHERE id IN (` + placeholders(len(ids)) + `)`
+	if _, err := s.db.ExecContext(ctx, s.dialect.rebind(query), args...); err != nil {
+		return fmt.Errorf("delete jobs: %w", err)
+	}
+	return nil
+}
+
+// Close is a no-op; the connection pool belongs to the backend.
+func (s *sqlJobStore) Close() error { return nil }
+
+// sqlUsageStore implements usage.Store.
+type sqlUsageStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (s *sqlUsageStore) Append(ctx context.Context, records ...usage.Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+	insert := s.dialect.rebind(`
+		INSERT INTO usage_records (recorded_at, key_id, model, provider, prompt_tokens, completion_tokens, total_tokens, tags, cost, status, latency_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		for _, rec := range records {
+			var tags string
+			if len(rec.Tags) > 0 {
+				data, err := json.Marshal(rec.Tags)
+				if err != nil {
+					return fmt.Errorf("marshal usage tags: %w", err)
+				}
+				tags = string(data)
+			}
+			if _, err := tx.ExecContext(ctx, insert,
+				rec.Time.UnixNano(), rec.Key, rec.Model, rec.Provider,
+				rec.PromptTokens, rec.CompletionTokens, rec.TotalTokens, tags, rec.Cost,
+				rec.Status, rec.LatencyMS); err != nil {
+				return fmt.Errorf("insert usage record: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlUsageStore) AddBuckets(ctx context.Context, buckets ...usage.Bucket) error {
+	if len(buckets) == 0 {
+		return nil
+	}
+	upsert := s.dialect.rebind(`
+		INSERT INTO usage_rollups (granularity, bucket_start, key_id, model, provider, requests, prompt_tokens, completion_tokens, total_tokens, cost, failed, latency_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (granularity, bucket_start, key_id, model, provider) DO UPDATE SET
+			requests = usage_rollups.requests + excluded.requests,
+			prompt_tokens = usage_rollups.prompt_tokens + excluded.prompt_tokens,
+			completion_tokens = usage_rollups.completion_tokens + excluded.completion_tokens,
+			total_tokens = usage_rollups.total_tokens + excluded.total_tokens,
+			cost = usage_rollups.cost + excluded.cost,
+			failed = usage_rollups.failed + excluded.failed,
+			latency_ms = usage_rollups.latency_ms + excluded.latency_ms`)
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		for _, b := range buckets {
+			if _, err := tx.ExecContext(ctx, upsert,
+				string(b.Granularity), b.Start.Unix(), b.Key, b.Model, b.Provider,
+				b.Requests, b.PromptTokens, b.CompletionTokens, b.TotalTokens, b.Cost,
+				b.Failed, b.LatencyMS); err != nil {
+				return fmt.Errorf("upsert usage rollup: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlUsageStore) Buckets(ctx context.Context, q usage.Query) ([]usage.Bucket, error) {
+	query := `SELECT bucket_start, key_id, model, provider, requests, prompt_tokens, completion_tokens, total_tokens, cost, failed, latency_ms
+		FROM usage_rollups WHERE granularity = ?`
+	args := []any{string(q.Granularity)}
+	if q.Key != "" {
+		query += ` AND key_id = ?`
+		args = append(args, q.Key)
+	}
+	if q.Model != "" {
+		query += ` AND model = ?`
+		args = append(args, q.Model)
+	}
+	if q.Provider != "" {
+		query += ` AND provider = ?`
+		args = append(args, q.Provider)
+	}
+	if !q.From.IsZero() {
+		query += ` AND bucket_start >= ?`
+		args = append(args, q.Granularity.Truncate(q.From).Unix())
+	}
+	if !q.To.IsZero() {
+		query += ` AND bucket_start < ?`
+		args = append(args, q.To.Unix())
+	}
+	query += ` ORDER BY bucket_start, key_id, model, provider`
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("query usage rollups: %w", err)
+	}
+	defer rows.Close()
+
+	out := make([]usage.Bucket, 0)
+	for rows.Next() {
+		b := usage.Bucket{Granularity: q.Granularity}
+		var start int64
+		if err := rows.Scan(&start, &b.Key, &b.Model, &b.Provider,
+			&b.Requests, &b.PromptTokens, &b.CompletionTokens, &b.TotalTokens, &b.Cost,
+			&b.Failed, &b.LatencyMS); err != nil {
+			return nil, fmt.Errorf("query usage rollups: %w", err)
+		}
+		b.Start = time.Unix(start, 0).UTC()
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query usage rollups: %w", err)
+	}
+	return out, nil
+}
+
+func (s *sqlUsageStore) PruneRecords(ctx context.Context, before time.Time) (int, error) {
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(`DELETE FROM usage_records WHERE recorded_at < ?`), before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("prune usage records: %w", err)
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil
+	}
+	return int(pruned), nil
+}
+
+// Close is a no-op; the connection pool belongs to the backend.
+func (s *sqlUsageStore) Close() error { return nil }
+
+// sqlCacheStore implements cache.Store. Expired rows are ignored on read
+// and swept periodically on write.
+type sqlCacheStore struct {
+	db      *sql.DB
+	dialect dialect
+	writes  atomic.Int64
+}
+
+func (s *sqlCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT value FROM response_cache WHERE cache_key = ? AND expires_at > ?`),
+		key, time.Now().UnixNano()).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("cache get: %w", err)
+	}
+	return value, true, nil
+}
+
+func (s *sqlCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO response_cache (cache_key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (cache_key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`),
+		key, value, now.Add(ttl).UnixNano())
+	if err != nil {
+		return fmt.Errorf("cache set: %w", err)
+	}
+
+	if s.writes.Add(1)%cacheSweepEvery == 0 {
+		if _, err := s.db.ExecContext(ctx, s.dialect.rebind(`DELETE FROM response_cache WHERE expires_at <= ?`), now.UnixNano()); err != nil {
+			return fmt.Errorf("cache sweep: %w", err)
+		}
+	}
+	return nil
+}
+
+// sqlCompletionStore implements completions.Store. Expired rows are
+// hidden from Get and deleted every completions.SweepEvery saves.
+type sqlCompletionStore struct {
+	db        *sql.DB
+	dialect   dialect
+	retention time.Duration
+	saves     atomic.Int64
+}
+
+func (s *sqlCompletionStore) Save(ctx context.Context, completion completions.Completion) error {
+	data, err := json.Marshal(completion)
+	if err != nil {
+		return fmt.Errorf("marshal completion: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO stored_completions (id, created_at, data) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET created_at = excluded.created_at, data = excluded.data`),
+		completion.ID, completion.CreatedAt.UnixNano(), string(data))
+	if err != nil {
+		return fmt.Errorf("save completion %s: %w", completion.ID, err)
+	}
+
+	if s.saves.Add(1)%completions.SweepEvery == 0 {
+		cutoff := time.Now().Add(-s.retention).UnixNano()
+		if _, err := s.db.ExecContext(ctx, s.dialect.rebind(`DELETE FROM stored_completions WHERE created_at <= ?`), cutoff); err != nil {
+			return fmt.Errorf("completions sweep: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlCompletionStore) Get(ctx context.Context, id string) (completions.Completion, bool, error) {
+	var data string
+	err := s.db.QueryRowContext(ctx, s.dialect.rebind(`SELECT data FROM stored_completions WHERE id = ?`), id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return completions.Completion{}, false, nil
+	}
+	if err != nil {
+		return completions.Completion{}, false, fmt.Errorf("load completion %s: %w", id, err)
+	}
+
+	var completion completions.Completion
+	if err := json.Unmarshal([]byte(data), &completion); err != nil {
+		return completions.Completion{}, false, fmt.Errorf("decode completion %s: %w", id, err)
+	}
+	if completions.Expired(completion.CreatedAt, s.retention, time.Now()) {
+		return completions.Completion{}, false, nil
+	}
+	return completion, true, nil
+}
+
+// Close is a no-op; the connection pool belongs to the backend.
+func (s *sqlCompletionStore) Close() error { return nil }
+
+// sqlKeyStore implements keys.Store.
+type sqlKeyStore struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+func (s *sqlKeyStore) Add(ctx context.Context, key keys.Key) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO client_keys (id, hash, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET hash = excluded.hash, created_at = excluded.created_at`),
+		key.ID, key.Hash, key.CreatedAt.UnixNano())
+	if err != nil {
+		return fmt.Errorf("save key %s: %w", key.ID, err)
+	}
+	return nil
+}
+
+func (s *sqlKeyStore) Delete(ctx context.Context, id string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, s.dialect.rebind(`DELETE FROM client_keys WHERE id = ?`), id)
+	if err != nil {
+		return false, fmt.Errorf("delete key %s: %w", id, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("delete key %s: %w", id, err)
+	}
+	return n > 0, nil
+}
+
+func (s *sqlKeyStore) List(ctx context.Context) ([]keys.Key, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, hash, created_at FROM client_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	defer rows.Close()
+
+	var out []keys.Key
+	for rows.Next() {
+		var key keys.Key
+		var createdAt int64
+		if err := rows.Scan(&key.ID, &key.Hash, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan key: %w", err)
+		}
+		key.CreatedAt = time.Unix(0, createdAt).UTC()
+		out = append(out, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	return out, nil
+}
+
+// Close is a no-op; the connection pool belongs to the backend.
+func (s *sqlKeyStore) Close() error { return nil }
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,111 @@
+// Package storage provides the shared persistence backend for jobs, usage,
+// cached responses, stored completions and client keys, so small deployments can run
+// without an external database while larger ones point every instance at
+// Postgres.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gocode-router/internal/cache"
+	"gocode-router/internal/completions"
+	"gocode-router/internal/config"
+	"gocode-router/internal/jobs"
+	"gocode-router/internal/keys"
+	"gocode-router/internal/usage"
+)
+
+// Backend bundles the stores a deployment persists state in.
+type Backend interface {
+	// Jobs returns the job store.
+	Jobs() jobs.Store
+	// Usage returns the usage store.
+	Usage() usage.Store
+	// Cache returns the response cache, or nil when the backend keeps no
+	// cache of its own and callers should use a process-local one.
+	Cache() cache.Store
+	// Completions returns the store for chat completions saved with
+	// store=true.
+	Completions() completions.Store
+	// Keys returns the store for client keys issued through the admin API.
+	Keys() keys.Store
+	// Close releases the backend. Stores obtained from it must not be used
+	// afterwards.
+	Close() error
+}
+
+// Open builds the backend selected by the storage configuration.
+func Open(cfg config.StorageConfig) (Backend, error) {
+	retention := cfg.CompletionRetentionPeriod()
+	switch cfg.Backend {
+	case "", config.StorageBackendMemory:
+		return &localBackend{
+			jobs:        jobs.NewMemoryStore(),
+			usage:       usage.NewMemoryStore(),
+			completions: completions.NewMemoryStore(retention),
+			keys:        keys.NewMemoryStore(),
+		}, nil
+	case config.StorageBackendFile:
+		return openFileBackend(cfg.Path, retention)
+	case config.StorageBackendSQLite:
+		if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+			return nil, fmt.Errorf("create storage directory: %w", err)
+		}
+		// WAL lets readers proceed during writes; the busy timeout covers
+		// several router processes sharing one database file.
+		return openSQLBackend(sqliteDialect, "file:"+cfg.Path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", retention)
+	case config.StorageBackendPostgres:
+		return openSQLBackend(postgresDialect, cfg.DSN, retention)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// localBackend serves the memory and file backends, which keep no shared
+// cache.
+type localBackend struct {
+	jobs        jobs.Store
+	usage       usage.Store
+	completions completions.Store
+	keys        keys.Store
+}
+
+func openFileBackend(dir string, retention time.Duration) (*localBackend, error) {
+	jobStore, err := jobs.OpenFileStore(filepath.Join(dir, "jobs.log"))
+	if err != nil {
+		return nil, err
+	}
+	usageStore, err := usage.OpenFileStore(filepath.Join(dir, "usage"))
+	if err != nil {
+		jobStore.Close()
+		return nil, err
+	}
+	completionStore, err := completions.OpenFileStore(filepath.Join(dir, "completions.jsonl"), retention)
+	if err != nil {
+		jobStore.Close()
+		usageStore.Close()
+		return nil, err
+	}
+	keyStore, err := keys.OpenFileStore(filepath.Join(dir, "keys.json"))
+	if err != nil {
+		jobStore.Close()
+		usageStore.Close()
+		completionStore.Close()
+		return nil, err
+	}
+	return &localBackend{jobs: jobStore, usage: usageStore, completions: completionStore, keys: keyStore}, nil
+}
+
+func (b *localBackend) Jobs() jobs.Store               { return b.jobs }
+func (b *localBackend) Usage() usage.Store             { return b.usage }
+func (b *localBackend) Cache() cache.Store             { return nil }
+func (b *localBackend) Completions() completions.Store { return b.completions }
+func (b *localBackend) Keys() keys.Store               { return b.keys }
+
+func (b *localBackend) Close() error {
+	return errors.Join(b.jobs.Close(), b.usage.Close(), b.completions.Close(), b.keys.Close())
+}
@@ -0,0 +1,157 @@
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowLabel replaces every label value of a sample that would grow a
+// metric past its series limit, and any value collapsed by a label policy.
+const OverflowLabel = "other"
+
+// Registry holds the router's counters and renders them in the Prometheus
+// text exposition format.
+type Registry struct {
+	maxSeries atomic.Int64
+
+	mu       sync.Mutex
+	counters []*Counter
+}
+
+// NewRegistry constructs an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// SetMaxSeries bounds how many label combinations each counter tracks.
+// Samples beyond the limit are folded into a single overflow series. A
+// non-positive limit removes the bound.
+func (r *Registry) SetMaxSeries(limit int) {
+	r.maxSeries.Store(int64(limit))
+}
+
+// Counter registers a monotonically increasing metric.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		registry: r,
+		name:     name,
+		help:     help,
+		labels:   labels,
+		series:   make(map[string]*counterSeries),
+	}
+	r.mu.Lock()
+	r.counters = append(r.counters, c)
+	r.mu.Unlock()
+	return c
+}
+
+// Write renders every registered metric.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	counters := append([]*Counter(nil), r.counters...)
+	r.mu.Unlock()
+
+	for _, c := range counters {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counter is a labelled counter family.
+type Counter struct {
+	registry *Registry
+	name     string
+	help     string
+	labels   []string
+
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	values []string
+	value  float64
+}
+
+// Add increments the series identified by values, which must match the
+// counter's labels in number and order.
+func (c *Counter) Add(delta float64, values ...string) {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", c.name, len(values), len(c.labels)))
+	}
+	key := strings.Join(values, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.series[key]
+	if !ok {
+		if limit := c.registry.maxSeries.Load(); limit > 0 && int64(len(c.series)) >= limit {
+			values = make([]string, len(c.labels))
+			for i := range values {
+				values[i] = OverflowLabel
+			}
+			key = strings.Join(values, "\xff")
+			s, ok = c.series[key]
+		}
+		if !ok {
+			s = &counterSeries{values: append([]string(nil), values...)}
+			c.series[key] = s
+		}
+	}
+	s.value += delta
+}
+
+func (c *Counter) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := c.series[key]
+		var b strings.Builder
+		b.WriteString(c.name)
+		if len(c.labels) > 0 {
+			b.WriteByte('{')
+			for i, label := range c.labels {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				b.WriteString(label)
+				b.WriteString(`="`)
+				b.WriteString(escapeLabelValue(s.values[i]))
+				b.WriteByte('"')
+			}
+			b.WriteByte('}')
+		}
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+		b.WriteByte('\n')
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(value string) string {
+	return labelEscaper.Replace(value)
+}
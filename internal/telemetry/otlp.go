@@ -0,0 +1,236 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+const (
+	// exportInterval is how often queued spans are sent.
+	exportInterval = 5 * time.Second
+	// exportBatch sends the queue early once it holds this many spans.
+	exportBatch = 512
+	// maxQueuedSpans bounds the queue while the collector is unreachable;
+	// newer spans are dropped beyond it.
+	maxQueuedSpans = 8192
+)
+
+// exporter sends spans to an OTLP/HTTP collector in the JSON encoding.
+type exporter struct {
+	cfg    config.TracingConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	queue   []spanData
+	dropped int
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newExporter(cfg config.TracingConfig) *exporter {
+	e := &exporter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.ExportTimeout()},
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *exporter) add(spans ...spanData) {
+	e.mu.Lock()
+	room := maxQueuedSpans - len(e.queue)
+	if room < len(spans) {
+		e.dropped += len(spans) - max(room, 0)
+		spans = spans[:max(room, 0)]
+	}
+	e.queue = append(e.queue, spans...)
+	full := len(e.queue) >= exportBatch
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			e.flush(context.Background())
+			return
+		case <-ticker.C:
+		case <-e.kick:
+		}
+		e.flush(context.Background())
+	}
+}
+
+// shutdown stops the exporter once the queue has been sent, or ctx ends.
+func (e *exporter) shutdown(ctx context.Context) error {
+	e.once.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *exporter) flush(ctx context.Context) {
+	e.mu.Lock()
+	spans := e.queue
+	dropped := e.dropped
+	e.queue, e.dropped = nil, 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		slog.Warn("trace export queue full, spans dropped", "dropped", dropped)
+	}
+	for len(spans) > 0 {
+		n := min(len(spans), exportBatch)
+		if err := e.send(ctx, spans[:n]); err != nil {
+			slog.Warn("trace export failed", "spans", n, "error", err)
+		}
+		spans = spans[n:]
+	}
+}
+
+func (e *exporter) send(ctx context.Context, spans []spanData) error {
+	body, err := json.Marshal(e.payload(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON payload, as accepted on /v1/traces.
+type (
+	otlpPayload struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+// otlpStatusError is STATUS_CODE_ERROR.
+const otlpStatusError = 2
+
+func (e *exporter) payload(spans []spanData) otlpPayload {
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, attr := range s.attrs {
+			span.Attributes = append(span.Attributes, otlpAttr(attr.key, attr.value))
+		}
+		if s.err != "" {
+			span.Status = &otlpStatus{Code: otlpStatusError, Message: s.err}
+		}
+		out[i] = span
+	}
+	return otlpPayload{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{otlpAttr("service.name", e.cfg.Service())}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "gocode-router"}, Spans: out}},
+	}}}
+}
+
+// otlpAttr encodes an attribute value. OTLP JSON carries 64-bit integers
+// as strings.
+func otlpAttr(key string, value any) otlpAttribute {
+	var v map[string]any
+	switch value := value.(type) {
+	case string:
+		v = map[string]any{"stringValue": value}
+	case bool:
+		v = map[string]any{"boolValue": value}
+	case int:
+		v = map[string]any{"intValue": strconv.Itoa(value)}
+	case int64:
+		v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		v = map[string]any{"doubleValue": value}
+	default:
+		v = map[string]any{"stringValue": fmt.Sprint(value)}
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,54 @@
+// Package telemetry holds the logging and sampling policy shared by the
+// router's observability signals.
+package telemetry
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"math"
+
+	"gocode-router/internal/config"
+)
+
+// Sampled reports whether the request identified by id falls within rate.
+// The decision is derived from the ID rather than drawn at random, so every
+// signal emitted for one request makes the same choice.
+func Sampled(id string, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return float64(h.Sum64())/float64(math.MaxUint64) < rate
+}
+
+// Keep applies the sampling policy to a finished request.
+func Keep(policy config.SamplingConfig, id string, failed bool) bool {
+	if failed && !policy.SampleErrors {
+		return true
+	}
+	return Sampled(id, policy.SampleRate())
+}
+
+// ApplyLogLevel sets the level of the default logger.
+func ApplyLogLevel(level string) {
+	slog.SetLogLoggerLevel(ParseLevel(level))
+}
+
+// ParseLevel maps a configured level name to a slog level, defaulting to
+// info.
+func ParseLevel(level string) slog.Level {
+	switch level {
+	case config.LogLevelDebug:
+		return slog.LevelDebug
+	case config.LogLevelWarn:
+		return slog.LevelWarn
+	case config.LogLevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
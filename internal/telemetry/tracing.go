@@ -0,0 +1,316 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+// SpanKind is the OpenTelemetry kind of a span.
+type SpanKind int
+
+const (
+	SpanInternal SpanKind = 1
+	SpanServer   SpanKind = 2
+	SpanClient   SpanKind = 3
+)
+
+// traceparentHeader carries the W3C trace context.
+const traceparentHeader = "traceparent"
+
+// tracer records spans for one tracing configuration.
+type tracer struct {
+	cfg      config.TracingConfig
+	sampling config.SamplingConfig
+	exporter *exporter
+}
+
+var current atomic.Pointer[tracer]
+
+// ApplyTracing starts, reconfigures or stops span export. Spans already
+// recorded are exported by the configuration they started under.
+func ApplyTracing(cfg config.TracingConfig, sampling config.SamplingConfig) {
+	old := current.Load()
+	if old != nil && sameExport(old.cfg, cfg) {
+		current.Store(&tracer{cfg: cfg, sampling: sampling, exporter: old.exporter})
+		return
+	}
+
+	var next *tracer
+	if cfg.Enabled {
+		next = &tracer{cfg: cfg, sampling: sampling, exporter: newExporter(cfg)}
+	}
+	current.Store(next)
+	if old != nil {
+		go old.exporter.shutdown(context.Background())
+	}
+}
+
+// ShutdownTracing exports the spans still queued and stops the exporter.
+func ShutdownTracing(ctx context.Context) error {
+	t := current.Swap(nil)
+	if t == nil {
+		return nil
+	}
+	return t.exporter.shutdown(ctx)
+}
+
+func sameExport(a, b config.TracingConfig) bool {
+	if a.Enabled != b.Enabled || a.Endpoint != b.Endpoint || a.Service() != b.Service() || a.ExportTimeout() != b.ExportTimeout() || len(a.Headers) != len(b.Headers) {
+		return false
+	}
+	for k, v := range a.Headers {
+		if b.Headers[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// spanContext identifies a span across processes.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+func (sc spanContext) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sc.traceID[:]), hex.EncodeToString(sc.spanID[:]))
+}
+
+// parseTraceparent reads a W3C traceparent header.
+func parseTraceparent(value string) (spanContext, bool) {
+	fields := strings.Split(strings.TrimSpace(value), "-")
+	if len(fields) < 4 || len(fields[0]) != 2 || fields[0] == "ff" || len(fields[1]) != 32 || len(fields[2]) != 16 {
+		return spanContext{}, false
+	}
+	var sc spanContext
+	if _, err := hex.Decode(sc.traceID[:], []byte(fields[1])); err != nil {
+		return spanContext{}, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(fields[2])); err != nil {
+		return spanContext{}, false
+	}
+	if sc.traceID == [16]byte{} || sc.spanID == [8]byte{} {
+		return spanContext{}, false
+	}
+	return sc, true
+}
+
+// localTrace gathers the spans this process records for one trace, so the
+// sampling policy can be applied once the request has finished.
+type localTrace struct {
+	tracer *tracer
+
+	mu     sync.Mutex
+	spans  []spanData
+	failed bool
+	done   bool
+	kept   bool
+}
+
+func (lt *localTrace) finish(s spanData, root bool) {
+	lt.mu.Lock()
+	lt.failed = lt.failed || s.err != ""
+	switch {
+	case lt.done:
+		// A span that outlived its request joins the trace it belonged to.
+		if lt.kept {
+			lt.mu.Unlock()
+			lt.tracer.exporter.add(s)
+			return
+		}
+	case root:
+		lt.done = true
+		lt.kept = Keep(lt.tracer.sampling, hex.EncodeToString(s.traceID[:]), lt.failed)
+		spans := append(lt.spans, s)
+		lt.spans = nil
+		lt.mu.Unlock()
+		if lt.kept {
+			lt.tracer.exporter.add(spans...)
+		}
+		return
+	default:
+		lt.spans = append(lt.spans, s)
+	}
+	lt.mu.Unlock()
+}
+
+// spanData is a finished span.
+type spanData struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	kind       SpanKind
+	start, end time.Time
+	attrs      []attribute
+	err        string
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+// Span is an operation being traced. A nil Span, returned while tracing is
+// off, ignores every call.
+type Span struct {
+	trace *localTrace
+	root  bool
+
+	mu    sync.Mutex
+	data  spanData
+	ended bool
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// StartSpan starts a span as a child of the span in ctx, or of a remote
+// parent read by WithTraceparent, and returns a context carrying it.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	t := current.Load()
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{data: spanData{name: name, kind: kind, start: time.Now()}}
+	rand.Read(span.data.spanID[:])
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok && parent != nil {
+		span.trace = parent.trace
+		span.data.traceID = parent.data.traceID
+		span.data.parentID = parent.data.spanID
+	} else {
+		span.trace = &localTrace{tracer: t}
+		span.root = true
+		if remote, ok := ctx.Value(remoteKey{}).(spanContext); ok {
+			span.data.traceID = remote.traceID
+			span.data.parentID = remote.spanID
+		} else {
+			rand.Read(span.data.traceID[:])
+		}
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// WithTraceparent makes the trace context of an incoming traceparent
+// header the parent of the next span started from ctx. Malformed headers
+// are ignored.
+func WithTraceparent(ctx context.Context, header string) context.Context {
+	sc, ok := parseTraceparent(header)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// InjectTraceparent sets the traceparent header of an outgoing request to
+// the span in ctx.
+func InjectTraceparent(ctx context.Context, header http.Header) {
+	span, ok := ctx.Value(spanKey{}).(*Span)
+	if !ok || span == nil {
+		return
+	}
+	header.Set(traceparentHeader, spanContext{traceID: span.data.traceID, spanID: span.data.spanID}.traceparent())
+}
+
+// SetAttributes records alternating keys and values on the span. Values
+// are strings, booleans, integers or floats; anything else is formatted as
+// a string.
+func (s *Span) SetAttributes(kv ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok || key == "" {
+			continue
+		}
+		s.data.attrs = append(s.data.attrs, attribute{key: key, value: kv[i+1]})
+	}
+}
+
+// RecordError marks the span as failed. A failed span keeps its trace
+// unless errors are sampled.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.end = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.trace.finish(data, s.root)
+}
+
+// Transport traces the requests it sends as client spans and passes the
+// trace on to the upstream in a traceparent header. A span ends once the
+// response headers arrive, so streamed bodies are not included.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// CloseIdleConnections closes the idle connections of the base transport,
+// so http.Client.CloseIdleConnections reaches it.
+func (t *Transport) CloseIdleConnections() {
+	if closer, ok := t.Base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	ctx, span := StartSpan(req.Context(), req.Method+" "+req.URL.Host, SpanClient)
+	if span == nil {
+		return base.RoundTrip(req)
+	}
+	defer span.End()
+	span.SetAttributes(
+		"http.request.method", req.Method,
+		"server.address", req.URL.Hostname(),
+		"url.path", req.URL.Path,
+	)
+
+	req = req.Clone(ctx)
+	InjectTraceparent(ctx, req.Header)
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+	span.SetAttributes("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.RecordError(fmt.Errorf("upstream returned %s", resp.Status))
+	}
+	return resp, nil
+}
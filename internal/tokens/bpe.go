@@ -0,0 +1,166 @@
+package tokens
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BPE is a byte-pair encoding tokenizer in the tiktoken format, where a
+// token's ID is its merge rank. Special tokens such as <|endoftext|> are
+// not recognised and encode as plain text.
+type BPE struct {
+	name   string
+	ranks  map[string]int
+	tokens map[int]string
+}
+
+// LoadTiktoken reads a tiktoken rank file: one base64 token and its rank
+// per line, as published for cl100k_base and o200k_base.
+func LoadTiktoken(name, file string) (*BPE, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	b := &BPE{name: name, ranks: make(map[string]int), tokens: make(map[int]string)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		encoded, rankText, ok := strings.Cut(text, " ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected a token and a rank", file, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		rank, err := strconv.Atoi(rankText)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		b.ranks[string(token)] = rank
+		b.tokens[rank] = string(token)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < 256; i++ {
+		if _, ok := b.ranks[string([]byte{byte(i)})]; !ok {
+			return nil, fmt.Errorf("%s: byte %#02x has no token", file, i)
+		}
+	}
+	return b, nil
+}
+
+// Name implements Tokenizer.
+func (b *BPE) Name() string { return b.name }
+
+// Encode implements Tokenizer.
+func (b *BPE) Encode(text string) []int {
+	var ids []int
+	for _, piece := range split(text) {
+		if rank, ok := b.ranks[piece]; ok {
+			ids = append(ids, rank)
+			continue
+		}
+		ids = append(ids, b.merge(piece)...)
+	}
+	return ids
+}
+
+// merge encodes a piece by repeatedly merging the adjacent pair with the
+// lowest rank, starting from single bytes.
+func (b *BPE) merge(piece string) []int {
+	parts := make([]string, len(piece))
+	for i := range piece {
+		parts[i] = piece[i : i+1]
+	}
+	for len(parts) > 1 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i+1 < len(parts); i++ {
+			if rank, ok := b.ranks[parts[i]+parts[i+1]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	ids := make([]int, len(parts))
+	for i, part := range parts {
+		ids[i] = b.ranks[part]
+	}
+	return ids
+}
+
+// Decode implements Tokenizer.
+func (b *BPE) Decode(ids []int) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		token, ok := b.tokens[id]
+		if !ok {
+			return "", fmt.Errorf("unknown token %d", id)
+		}
+		sb.WriteString(token)
+	}
+	return sb.String(), nil
+}
+
+// Approximate stands in for models without a tokenizer file. It splits text
+// as cl100k_base does and cuts each piece into chunks of up to four bytes,
+// which tracks real BPE counts closely enough for budgeting. A token's ID
+// is its bytes behind a marker bit, so any sequence decodes back.
+var Approximate Tokenizer = approximate{}
+
+type approximate struct{}
+
+func (approximate) Name() string { return "approximate" }
+
+func (approximate) Encode(text string) []int {
+	var ids []int
+	for _, piece := range split(text) {
+		for piece != "" {
+			n := 0
+			for n < len(piece) {
+				_, size := utf8.DecodeRuneInString(piece[n:])
+				if n > 0 && n+size > 4 {
+					break
+				}
+				n += size
+			}
+			id := 1
+			for i := 0; i < n; i++ {
+				id = id<<8 | int(piece[i])
+			}
+			ids = append(ids, id)
+			piece = piece[n:]
+		}
+	}
+	return ids
+}
+
+func (approximate) Decode(ids []int) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		length := (bits.Len(uint(id)) - 1) / 8
+		if id <= 0 || length < 1 || length > 4 || bits.Len(uint(id))-1 != length*8 {
+			return "", fmt.Errorf("unknown token %d", id)
+		}
+		for i := length - 1; i >= 0; i-- {
+			sb.WriteByte(byte(id >> (8 * i)))
+		}
+	}
+	return sb.String(), nil
+}
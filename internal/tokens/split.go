@@ -0,0 +1,95 @@
+package tokens
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// split cuts text into the pieces BPE merges within, following the
+// cl100k_base pre-tokenization pattern:
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}|
+//	 ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// Go's regexp lacks the lookahead, so the pattern is matched by hand.
+func split(text string) []string {
+	var pieces []string
+	for text != "" {
+		n := nextPiece(text)
+		pieces = append(pieces, text[:n])
+		text = text[n:]
+	}
+	return pieces
+}
+
+// nextPiece returns the byte length of the piece text starts with.
+func nextPiece(text string) int {
+	r, size := utf8.DecodeRuneInString(text)
+	next, nextSize := utf8.DecodeRuneInString(text[size:])
+
+	if r == '\'' {
+		if n := contraction(text[size:]); n > 0 {
+			return size + n
+		}
+	}
+	if unicode.IsLetter(r) {
+		return size + span(text[size:], unicode.IsLetter, -1)
+	}
+	if r != '\r' && r != '\n' && !unicode.IsNumber(r) && nextSize > 0 && unicode.IsLetter(next) {
+		return size + span(text[size:], unicode.IsLetter, -1)
+	}
+	if unicode.IsNumber(r) {
+		return size + span(text[size:], unicode.IsNumber, 2)
+	}
+
+	punct := func(r rune) bool { return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r) }
+	if punct(r) || (r == ' ' && nextSize > 0 && punct(next)) {
+		n := size
+		if r == ' ' {
+			n += nextSize
+		}
+		n += span(text[n:], punct, -1)
+		return n + span(text[n:], isNewline, -1)
+	}
+
+	// A whitespace run.
+	run := size + span(text[size:], unicode.IsSpace, -1)
+	if i := strings.LastIndexAny(text[:run], "\r\n"); i >= 0 {
+		return i + 1
+	}
+	if run == len(text) || run == size {
+		return run
+	}
+	// Leave the last space to start the next piece.
+	_, last := utf8.DecodeLastRuneInString(text[:run])
+	return run - last
+}
+
+func isNewline(r rune) bool { return r == '\r' || r == '\n' }
+
+// span returns the byte length of the prefix of text whose runes satisfy
+// ok, stopping after limit runes when limit is not negative.
+func span(text string, ok func(rune) bool, limit int) int {
+	n := 0
+	for n < len(text) && limit != 0 {
+		r, size := utf8.DecodeRuneInString(text[n:])
+		if !ok(r) {
+			break
+		}
+		n += size
+		limit--
+	}
+	return n
+}
+
+// contraction returns the length of the English contraction suffix text
+// starts with, after an apostrophe, or zero.
+func contraction(text string) int {
+	for _, suffix := range []string{"re", "ve", "ll", "s", "t", "m", "d"} {
+		if len(text) >= len(suffix) && strings.EqualFold(text[:len(suffix)], suffix) {
+			return len(suffix)
+		}
+	}
+	return 0
+}
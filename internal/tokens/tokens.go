@@ -0,0 +1,92 @@
+// Package tokens counts tokens locally, with BPE tokenizers loaded from
+// tiktoken rank files or, for models without one, an approximation.
+package tokens
+
+import (
+	"fmt"
+	"path"
+
+	"gocode-router/internal/config"
+	"gocode-router/internal/models"
+)
+
+// Per-message framing tokens, as in OpenAI's chat format: each message
+// costs a few tokens for its role and delimiters, and the reply is primed
+// with a few more.
+const (
+	perMessage = 4
+	perReply   = 3
+)
+
+// Tokenizer converts between text and token IDs.
+type Tokenizer interface {
+	Name() string
+	Encode(text string) []int
+	// Decode fails on IDs the tokenizer does not know.
+	Decode(ids []int) (string, error)
+}
+
+// CountMessages returns the prompt tokens of a chat request.
+func CountMessages(t Tokenizer, messages []models.Message) int {
+	total := perReply
+	for _, m := range messages {
+		total += perMessage + len(t.Encode(m.Content))
+		if m.Name != "" {
+			total += len(t.Encode(m.Name))
+		}
+	}
+	return total
+}
+
+// CountPrompt returns the prompt tokens of a chat request with tools: its
+// messages, the tool calls they carry and the tools it offers.
+func CountPrompt(t Tokenizer, messages []models.Message, tools []models.ToolDefinition) int {
+	total := CountMessages(t, messages)
+	for _, m := range messages {
+		for _, call := range m.ToolCalls {
+			total += len(t.Encode(call.Name)) + len(t.Encode(call.Arguments))
+		}
+	}
+	for _, tool := range tools {
+		total += len(t.Encode(tool.Name)) + len(t.Encode(tool.Description)) + len(t.Encode(string(tool.Parameters)))
+	}
+	return total
+}
+
+type family struct {
+	patterns  []string
+	tokenizer Tokenizer
+}
+
+// Registry picks the tokenizer of each model family.
+type Registry struct {
+	families []family
+}
+
+// Load reads the configured tokenizer files.
+func Load(cfgs []config.TokenizerConfig) (*Registry, error) {
+	r := &Registry{}
+	for _, cfg := range cfgs {
+		bpe, err := LoadTiktoken(cfg.Name, cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("tokenizer %s: %w", cfg.Name, err)
+		}
+		r.families = append(r.families, family{patterns: cfg.Models, tokenizer: bpe})
+	}
+	return r, nil
+}
+
+// ForModel returns the tokenizer for a model ID. A nil registry, like a
+// model no family claims, gets the approximate tokenizer.
+func (r *Registry) ForModel(modelID string) Tokenizer {
+	if r != nil {
+		for _, f := range r.families {
+			for _, pattern := range f.patterns {
+				if ok, _ := path.Match(pattern, modelID); ok {
+					return f.tokenizer
+				}
+			}
+		}
+	}
+	return Approximate
+}
@@ -4,18 +4,22 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"sort"
 	"strings"
 
 	"gocode-router/internal/models"
 )
 
 var (
-	errClaudeEmptyModel      = errors.New("model must be provided")
-	errClaudeEmptyMessages   = errors.New("at least one message is required")
-	errClaudeInvalidRole     = errors.New("invalid role")
-	errClaudeInvalidContent  = errors.New("invalid message content")
-	errClaudeInvalidSystem   = errors.New("invalid system prompt")
-	errClaudeUnsupportedStop = errors.New("unsupported stop sequences")
+	errClaudeEmptyModel        = errors.New("model must be provided")
+	errClaudeEmptyMessages     = errors.New("at least one message is required")
+	errClaudeInvalidRole       = errors.New("invalid role")
+	errClaudeInvalidContent    = errors.New("invalid message content")
+	errClaudeInvalidSystem     = errors.New("invalid system prompt")
+	errClaudeUnsupportedStop   = errors.New("unsupported stop sequences")
+	errClaudeInvalidTools      = errors.New("invalid tools")
+	errClaudeInvalidToolChoice = errors.New("invalid tool_choice")
 )
 
 // ClaudeMessageRequest models the Anthropic Claude /v1/messages payload.
@@ -23,15 +27,24 @@ type ClaudeMessageRequest struct {
 	Model         string
 	MaxTokens     *int
 	Messages      []ClaudeMessage
-	System        []string
+	System        []ClaudeSystemPrompt
 	Stream        bool
 	Temperature   *float64
 	TopP          *float64
 	StopSequences []string
 	Metadata      map[string]any
+	Tools         []models.ToolDefinition
+	ToolChoiceRaw json.RawMessage
 	Options       map[string]any
 }
 
+// ClaudeSystemPrompt is a system prompt of a Claude request, with the
+// cache breakpoint it ends in, if any.
+type ClaudeSystemPrompt struct {
+	Text         string
+	CacheControl *models.CacheControl
+}
+
 // UnmarshalJSON enforces validation and normalises fields.
 func (r *ClaudeMessageRequest) UnmarshalJSON(data []byte) error {
 	type alias struct {
@@ -44,6 +57,8 @@ func (r *ClaudeMessageRequest) UnmarshalJSON(data []byte) error {
 		TopP          *float64        `json:"top_p"`
 		StopSequences json.RawMessage `json:"stop_sequences"`
 		Metadata      map[string]any  `json:"metadata"`
+		Tools         json.RawMessage `json:"tools"`
+		ToolChoice    json.RawMessage `json:"tool_choice"`
 	}
 
 	var raw alias
@@ -61,6 +76,16 @@ func (r *ClaudeMessageRequest) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	tools, err := parseClaudeTools(raw.Tools)
+	if err != nil {
+		return err
+	}
+
+	toolChoice, parallel, err := claudeToolChoiceToOpenAI(raw.ToolChoice)
+	if err != nil {
+		return err
+	}
+
 	r.Model = strings.TrimSpace(raw.Model)
 	r.MaxTokens = raw.MaxTokens
 	r.Messages = raw.Messages
@@ -70,6 +95,8 @@ func (r *ClaudeMessageRequest) UnmarshalJSON(data []byte) error {
 	r.TopP = raw.TopP
 	r.StopSequences = stopSequences
 	r.Metadata = raw.Metadata
+	r.Tools = tools
+	r.ToolChoiceRaw = raw.ToolChoice
 	r.Options = make(map[string]any)
 
 	if raw.MaxTokens != nil {
@@ -84,8 +111,23 @@ func (r *ClaudeMessageRequest) UnmarshalJSON(data []byte) error {
 	if len(stopSequences) > 0 {
 		r.Options["stop"] = stopSequences
 	}
-	if raw.Metadata != nil {
-		r.Options["metadata"] = raw.Metadata
+	// The end-user ID travels as the OpenAI user option, so providers
+	// without Anthropic's metadata still see it.
+	if userID, ok := raw.Metadata["user_id"].(string); ok && userID != "" {
+		r.Options["user"] = userID
+	}
+	metadata := maps.Clone(raw.Metadata)
+	delete(metadata, "user_id")
+	if len(metadata) > 0 {
+		r.Options["metadata"] = metadata
+	}
+	// The tool choice travels in OpenAI form, which is what every provider
+	// reads.
+	if len(toolChoice) > 0 {
+		r.Options["tool_choice"] = toolChoice
+	}
+	if parallel != nil {
+		r.Options["parallel_tool_calls"] = *parallel
 	}
 
 	if err := r.validate(); err != nil {
@@ -115,19 +157,36 @@ func (r ClaudeMessageRequest) ToUnified() models.UnifiedChatRequest {
 	msgs := make([]models.Message, 0, len(r.Messages)+len(r.System))
 
 	for _, systemMsg := range r.System {
-		if strings.TrimSpace(systemMsg) != "" {
+		if strings.TrimSpace(systemMsg.Text) != "" {
 			msgs = append(msgs, models.Message{
-				Role:    "system",
-				Content: systemMsg,
+				Role:         "system",
+				Content:      systemMsg.Text,
+				CacheControl: systemMsg.CacheControl,
 			})
 		}
 	}
 
 	for _, m := range r.Messages {
+		// Tool results become tool messages, which come straight after
+		// the calls they answer.
+		for _, result := range m.ToolResults {
+			msgs = append(msgs, models.Message{
+				Role:         "tool",
+				Content:      result.Content,
+				ToolCallID:   result.ToolUseID,
+				CacheControl: result.CacheControl,
+			})
+		}
+		if m.Content == "" && len(m.ToolCalls) == 0 && len(m.Parts) == 0 {
+			continue
+		}
 		msgs = append(msgs, models.Message{
-			Role:    m.Role,
-			Content: m.Content,
-			Name:    m.Name,
+			Role:         m.Role,
+			Content:      m.Content,
+			Name:         m.Name,
+			ToolCalls:    m.ToolCalls,
+			Parts:        m.Parts,
+			CacheControl: m.CacheControl,
 		})
 	}
 
@@ -140,6 +199,7 @@ func (r ClaudeMessageRequest) ToUnified() models.UnifiedChatRequest {
 		Model:    r.Model,
 		Messages: msgs,
 		Stream:   r.Stream,
+		Tools:    r.Tools,
 		Options:  options,
 	}
 }
@@ -149,6 +209,22 @@ type ClaudeMessage struct {
 	Role    string
 	Content string
 	Name    string
+	// ToolCalls are the tool_use blocks of an assistant message.
+	ToolCalls []models.ToolCall
+	// ToolResults are the tool_result blocks of a user message.
+	ToolResults []ClaudeToolResult
+	// Parts holds the text and images of a message with images.
+	Parts []models.ContentPart
+	// CacheControl is the last cache breakpoint among the message's text,
+	// image and tool_use blocks; it moves to the end of the message.
+	CacheControl *models.CacheControl
+}
+
+// ClaudeToolResult is the answer to a tool call.
+type ClaudeToolResult struct {
+	ToolUseID    string
+	Content      string
+	CacheControl *models.CacheControl
 }
 
 // UnmarshalJSON normalises the Claude message content structure.
@@ -170,8 +246,12 @@ func (m *ClaudeMessage) UnmarshalJSON(data []byte) error {
 	}
 
 	m.Role = strings.TrimSpace(raw.Role)
-	m.Content = content
+	m.Content = content.text
 	m.Name = strings.TrimSpace(raw.Name)
+	m.ToolCalls = content.toolCalls
+	m.ToolResults = content.toolResults
+	m.Parts = content.parts
+	m.CacheControl = content.cacheControl
 
 	return m.validate()
 }
@@ -182,15 +262,21 @@ func (m *ClaudeMessage) validate() error {
 	default:
 		return fmt.Errorf("%w: %s", errClaudeInvalidRole, m.Role)
 	}
+	if len(m.ToolCalls) > 0 && m.Role != "assistant" {
+		return fmt.Errorf("%w: tool_use blocks belong to assistant messages", errClaudeInvalidContent)
+	}
+	if len(m.ToolResults) > 0 && m.Role != "user" {
+		return fmt.Errorf("%w: tool_result blocks belong to user messages", errClaudeInvalidContent)
+	}
 
-	if strings.TrimSpace(m.Content) == "" {
+	if strings.TrimSpace(m.Content) == "" && len(m.ToolCalls) == 0 && len(m.ToolResults) == 0 && len(m.Parts) == 0 {
 		return errClaudeInvalidContent
 	}
 
 	return nil
 }
 
-func parseClaudeSystem(raw json.RawMessage) ([]string, error) {
+func parseClaudeSystem(raw json.RawMessage) ([]ClaudeSystemPrompt, error) {
 	if len(raw) == 0 || string(raw) == "null" {
 		return nil, nil
 	}
@@ -201,18 +287,18 @@ func parseClaudeSystem(raw json.RawMessage) ([]string, error) {
 		if s == "" {
 			return nil, nil
 		}
-		return []string{s}, nil
+		return []ClaudeSystemPrompt{{Text: s}}, nil
 	}
 
 	var multiple []string
 	if err := json.Unmarshal(raw, &multiple); err == nil {
-		out := make([]string, 0, len(multiple))
+		out := make([]ClaudeSystemPrompt, 0, len(multiple))
 		for _, item := range multiple {
 			item = strings.TrimSpace(item)
 			if item == "" {
 				continue
 			}
-			out = append(out, item)
+			out = append(out, ClaudeSystemPrompt{Text: item})
 		}
 		if len(out) == 0 {
 			return nil, nil
@@ -222,28 +308,28 @@ func parseClaudeSystem(raw json.RawMessage) ([]string, error) {
 
 	var singleBlock claudeSystemBlock
 	if err := json.Unmarshal(raw, &singleBlock); err == nil && singleBlock.Type != "" {
-		text, err := extractSystemBlock(singleBlock)
+		prompt, err := extractSystemBlock(singleBlock)
 		if err != nil {
 			return nil, err
 		}
-		if text == "" {
+		if prompt.Text == "" {
 			return nil, nil
 		}
-		return []string{text}, nil
+		return []ClaudeSystemPrompt{prompt}, nil
 	}
 
 	var blocks []claudeSystemBlock
 	if err := json.Unmarshal(raw, &blocks); err == nil {
-		out := make([]string, 0, len(blocks))
+		out := make([]ClaudeSystemPrompt, 0, len(blocks))
 		for _, block := range blocks {
-			text, err := extractSystemBlock(block)
+			prompt, err := extractSystemBlock(block)
 			if err != nil {
 				return nil, err
 			}
-			if text == "" {
+			if prompt.Text == "" {
 				continue
 			}
-			out = append(out, text)
+			out = append(out, prompt)
 		}
 		if len(out) == 0 {
 			return nil, nil
@@ -275,64 +361,268 @@ func parseClaudeStops(raw json.RawMessage) ([]string, error) {
 	return out, nil
 }
 
-func extractClaudeContent(raw json.RawMessage) (string, error) {
+// claudeTool is a tool definition in an Anthropic request.
+type claudeTool struct {
+	Type         string              `json:"type"`
+	Name         string              `json:"name"`
+	Description  string              `json:"description"`
+	InputSchema  json.RawMessage     `json:"input_schema"`
+	CacheControl *claudeCacheControl `json:"cache_control"`
+}
+
+// parseClaudeTools reads Anthropic tool definitions. Anthropic server
+// tools have no counterpart elsewhere.
+func parseClaudeTools(raw json.RawMessage) ([]models.ToolDefinition, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var tools []claudeTool
+	if err := json.Unmarshal(raw, &tools); err != nil {
+		return nil, fmt.Errorf("%w: %v", errClaudeInvalidTools, err)
+	}
+
+	out := make([]models.ToolDefinition, 0, len(tools))
+	for i, tool := range tools {
+		if tool.Type != "" && tool.Type != "custom" {
+			return nil, fmt.Errorf("%w: tools[%d] has unsupported type %q", errClaudeInvalidTools, i, tool.Type)
+		}
+		if strings.TrimSpace(tool.Name) == "" {
+			return nil, fmt.Errorf("%w: tools[%d] is missing a name", errClaudeInvalidTools, i)
+		}
+		out = append(out, models.ToolDefinition{
+			Name:         tool.Name,
+			Description:  tool.Description,
+			Parameters:   tool.InputSchema,
+			CacheControl: tool.CacheControl.unified(),
+		})
+	}
+	return out, nil
+}
+
+// claudeToolChoiceToOpenAI maps an Anthropic tool_choice onto OpenAI's:
+// auto stays auto, any becomes required, tool names a function and none
+// stays none. disable_parallel_tool_use becomes parallel_tool_calls=false.
+func claudeToolChoiceToOpenAI(raw json.RawMessage) (json.RawMessage, *bool, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil, nil
+	}
+
+	var choice struct {
+		Type                   string `json:"type"`
+		Name                   string `json:"name"`
+		DisableParallelToolUse bool   `json:"disable_parallel_tool_use"`
+	}
+	if err := json.Unmarshal(raw, &choice); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errClaudeInvalidToolChoice, err)
+	}
+
+	var out any
+	switch choice.Type {
+	case "auto", "none":
+		out = choice.Type
+	case "any":
+		out = "required"
+	case "tool":
+		if strings.TrimSpace(choice.Name) == "" {
+			return nil, nil, fmt.Errorf("%w: type tool requires a name", errClaudeInvalidToolChoice)
+		}
+		out = map[string]any{"type": "function", "function": map[string]any{"name": choice.Name}}
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported type %q", errClaudeInvalidToolChoice, choice.Type)
+	}
+
+	encoded, err := json.Marshal(out)
+	if err != nil {
+		return nil, nil, err
+	}
+	var parallel *bool
+	if choice.DisableParallelToolUse {
+		disabled := false
+		parallel = &disabled
+	}
+	return encoded, parallel, nil
+}
+
+// claudeContent is the content of a request message: its text, joined
+// across text blocks, its tool blocks and, when it has images, its parts.
+type claudeContent struct {
+	text         string
+	toolCalls    []models.ToolCall
+	toolResults  []ClaudeToolResult
+	parts        []models.ContentPart
+	cacheControl *models.CacheControl
+}
+
+// claudeContentBlock is a content block of a request message.
+type claudeContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content"`
+	Source    json.RawMessage `json:"source"`
+
+	CacheControl *claudeCacheControl `json:"cache_control"`
+}
+
+func extractClaudeContent(raw json.RawMessage) (claudeContent, error) {
 	if len(raw) == 0 || string(raw) == "null" {
-		return "", errClaudeInvalidContent
+		return claudeContent{}, errClaudeInvalidContent
 	}
 
 	var text string
 	if err := json.Unmarshal(raw, &text); err == nil {
-		return strings.TrimSpace(text), nil
+		return claudeContent{text: strings.TrimSpace(text)}, nil
 	}
 
-	var blocks []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+	var blocks []claudeContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return claudeContent{}, errClaudeInvalidContent
 	}
-	if err := json.Unmarshal(raw, &blocks); err == nil {
-		var builder strings.Builder
-		for _, block := range blocks {
-			if block.Type != "text" {
-				return "", fmt.Errorf("%w: unsupported block type %q", errClaudeInvalidContent, block.Type)
-			}
+
+	var content claudeContent
+	var builder strings.Builder
+	var parts []models.ContentPart
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
 			if builder.Len() > 0 {
 				builder.WriteString("\n")
 			}
 			builder.WriteString(strings.TrimSpace(block.Text))
+			parts = append(parts, models.ContentPart{Text: strings.TrimSpace(block.Text)})
+		case "image":
+			image, err := parseClaudeImage(block.Source)
+			if err != nil {
+				return claudeContent{}, err
+			}
+			parts = append(parts, models.ContentPart{Image: image})
+		case "tool_use":
+			if strings.TrimSpace(block.ID) == "" || strings.TrimSpace(block.Name) == "" {
+				return claudeContent{}, fmt.Errorf("%w: tool_use blocks need an id and a name", errClaudeInvalidContent)
+			}
+			arguments := "{}"
+			if len(block.Input) > 0 && string(block.Input) != "null" {
+				arguments = string(block.Input)
+			}
+			content.toolCalls = append(content.toolCalls, models.ToolCall{ID: block.ID, Name: block.Name, Arguments: arguments})
+		case "tool_result":
+			if strings.TrimSpace(block.ToolUseID) == "" {
+				return claudeContent{}, fmt.Errorf("%w: tool_result blocks need a tool_use_id", errClaudeInvalidContent)
+			}
+			result, err := toolResultText(block.Content)
+			if err != nil {
+				return claudeContent{}, err
+			}
+			content.toolResults = append(content.toolResults, ClaudeToolResult{ToolUseID: block.ToolUseID, Content: result, CacheControl: block.CacheControl.unified()})
+			continue
+		default:
+			return claudeContent{}, fmt.Errorf("%w: unsupported block type %q", errClaudeInvalidContent, block.Type)
 		}
-		result := strings.TrimSpace(builder.String())
-		if result == "" {
-			return "", errClaudeInvalidContent
+		if cache := block.CacheControl.unified(); cache != nil {
+			content.cacheControl = cache
 		}
-		return result, nil
 	}
+	content.text = strings.TrimSpace(builder.String())
+	content.parts = messageParts(parts)
+	if content.text == "" && len(content.toolCalls) == 0 && len(content.toolResults) == 0 && len(content.parts) == 0 {
+		return claudeContent{}, errClaudeInvalidContent
+	}
+	return content, nil
+}
 
-	return "", errClaudeInvalidContent
+// toolResultText returns the content of a tool_result block, a string or
+// text blocks.
+func toolResultText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var blocks []claudeContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", fmt.Errorf("%w: unsupported tool_result content", errClaudeInvalidContent)
+	}
+	var builder strings.Builder
+	for _, block := range blocks {
+		if block.Type != "text" {
+			return "", fmt.Errorf("%w: unsupported tool_result block type %q", errClaudeInvalidContent, block.Type)
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\n")
+		}
+		builder.WriteString(block.Text)
+	}
+	return builder.String(), nil
 }
 
-// ClaudeMessageResponse models the Anthropic response payload.
+// ClaudeMessageResponse models the Anthropic response payload. Content
+// holds ClaudeTextBlock, ClaudeToolUseBlock, ClaudeServerToolUseBlock and
+// ClaudeServerToolResultBlock values.
 type ClaudeMessageResponse struct {
-	ID         string            `json:"id"`
-	Type       string            `json:"type"`
-	Role       string            `json:"role"`
-	Model      string            `json:"model"`
-	Content    []ClaudeTextBlock `json:"content"`
-	StopReason string            `json:"stop_reason,omitempty"`
-	Usage      ClaudeUsage       `json:"usage"`
-	StopSeq    string            `json:"stop_sequence,omitempty"`
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Role       string      `json:"role"`
+	Model      string      `json:"model"`
+	Content    []any       `json:"content"`
+	StopReason string      `json:"stop_reason,omitempty"`
+	Usage      ClaudeUsage `json:"usage"`
+	StopSeq    string      `json:"stop_sequence,omitempty"`
+
+	RouterMetadata map[string]any `json:"router_metadata,omitempty"`
 }
 
 // ClaudeTextBlock represents a text content block in the response.
 type ClaudeTextBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type      string           `json:"type"`
+	Text      string           `json:"text"`
+	Citations []ClaudeCitation `json:"citations,omitempty"`
+}
+
+// ClaudeCitation attributes a text block to a source.
+type ClaudeCitation struct {
+	Type      string `json:"type"`
+	CitedText string `json:"cited_text,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Title     string `json:"title,omitempty"`
+}
+
+// ClaudeToolUseBlock is a call of a client tool.
+type ClaudeToolUseBlock struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+// ClaudeServerToolUseBlock is a tool call the upstream ran itself.
+type ClaudeServerToolUseBlock struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// ClaudeServerToolResultBlock carries the result of a server tool call,
+// such as web_search_tool_result.
+type ClaudeServerToolResultBlock struct {
+	Type      string          `json:"type"`
+	ToolUseID string          `json:"tool_use_id"`
+	Content   json.RawMessage `json:"content,omitempty"`
 }
 
 // ClaudeUsage mirrors Anthropic usage format.
 type ClaudeUsage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
-	TotalTokens  int `json:"total_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	TotalTokens              int `json:"total_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
 // FromUnifiedClaude converts the unified response to Anthropic format.
@@ -347,34 +637,98 @@ func FromUnifiedClaude(modelID string, resp *models.UnifiedChatResponse) ClaudeM
 		contentText = ""
 	}
 
+	content := make([]any, 0, 2*len(resp.ServerTools)+len(resp.Message.ToolCalls)+1)
+	for _, call := range resp.ServerTools {
+		content = append(content, ClaudeServerToolUseBlock{Type: "server_tool_use", ID: call.ID, Name: call.Name, Input: call.Input})
+		if call.ResultType != "" {
+			content = append(content, ClaudeServerToolResultBlock{Type: call.ResultType, ToolUseID: call.ID, Content: call.Result})
+		}
+	}
+	// A message that only calls tools has no text block.
+	if contentText != "" || len(resp.Message.ToolCalls) == 0 {
+		content = append(content, claudeTextBlocks(contentText, resp.Citations)...)
+	}
+	for _, call := range resp.Message.ToolCalls {
+		content = append(content, ClaudeToolUseBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: toolInput(call.Arguments)})
+	}
+
 	return ClaudeMessageResponse{
-		ID:    resp.ID,
-		Type:  "message",
-		Role:  role,
-		Model: modelID,
-		Content: []ClaudeTextBlock{
-			{
-				Type: "text",
-				Text: contentText,
-			},
-		},
-		StopReason: resp.FinishReason,
+		ID:         resp.ID,
+		Type:       "message",
+		Role:       role,
+		Model:      modelID,
+		Content:    content,
+		StopReason: claudeStopReason(resp.FinishReason),
 		Usage: ClaudeUsage{
-			InputTokens:  resp.Usage.PromptTokens,
-			OutputTokens: resp.Usage.CompletionTokens,
-			TotalTokens:  resp.Usage.TotalTokens,
+			InputTokens:              resp.Usage.PromptTokens,
+			OutputTokens:             resp.Usage.CompletionTokens,
+			TotalTokens:              resp.Usage.TotalTokens,
+			CacheCreationInputTokens: resp.Usage.CacheCreationTokens,
+			CacheReadInputTokens:     resp.Usage.CacheReadTokens,
 		},
+		RouterMetadata: resp.Metadata,
 	}
 }
 
+// claudeTextBlocks splits text back into blocks at citation boundaries so
+// each citation lands on the span it covers.
+func claudeTextBlocks(text string, citations []models.Citation) []any {
+	runes := []rune(text)
+	bounds := []int{0, len(runes)}
+	for _, c := range citations {
+		if c.Start >= 0 && c.End <= len(runes) && c.Start < c.End {
+			bounds = append(bounds, c.Start, c.End)
+		}
+	}
+	sort.Ints(bounds)
+
+	var blocks []any
+	for i := 1; i < len(bounds); i++ {
+		start, end := bounds[i-1], bounds[i]
+		if start == end {
+			continue
+		}
+		block := ClaudeTextBlock{Type: "text", Text: string(runes[start:end])}
+		for _, c := range citations {
+			if c.Start == start && c.End == end {
+				block.Citations = append(block.Citations, ClaudeCitation{
+					Type:      c.Type,
+					CitedText: c.CitedText,
+					URL:       c.URL,
+					Title:     c.Title,
+				})
+			}
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		blocks = append(blocks, ClaudeTextBlock{Type: "text", Text: text})
+	}
+	return blocks
+}
+
 type claudeSystemBlock struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type         string              `json:"type"`
+	Text         string              `json:"text"`
+	CacheControl *claudeCacheControl `json:"cache_control"`
 }
 
-func extractSystemBlock(block claudeSystemBlock) (string, error) {
+func extractSystemBlock(block claudeSystemBlock) (ClaudeSystemPrompt, error) {
 	if block.Type != "" && block.Type != "text" {
-		return "", fmt.Errorf("%w: unsupported block type %q", errClaudeInvalidSystem, block.Type)
+		return ClaudeSystemPrompt{}, fmt.Errorf("%w: unsupported block type %q", errClaudeInvalidSystem, block.Type)
+	}
+	return ClaudeSystemPrompt{Text: strings.TrimSpace(block.Text), CacheControl: block.CacheControl.unified()}, nil
+}
+
+// claudeCacheControl is the cache_control marker of a block or tool.
+type claudeCacheControl struct {
+	Type string `json:"type"`
+	TTL  string `json:"ttl"`
+}
+
+func (c *claudeCacheControl) unified() *models.CacheControl {
+	if c == nil || c.Type == "" {
+		return nil
 	}
-	return strings.TrimSpace(block.Text), nil
+	return &models.CacheControl{Type: c.Type, TTL: c.TTL}
 }
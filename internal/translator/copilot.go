@@ -0,0 +1,99 @@
+package translator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gocode-router/internal/models"
+)
+
+// CopilotCompletionRequest models the engine-scoped completion payload sent
+// by editors configured for a Copilot proxy. The model is implied by the
+// engine in the URL rather than carried in the body.
+type CopilotCompletionRequest struct {
+	Prompt      string
+	Suffix      string
+	Stream      bool
+	MaxTokens   *int
+	Temperature *float64
+	TopP        *float64
+	Stop        []string
+	Options     map[string]any
+}
+
+// UnmarshalJSON performs validation for Copilot completion requests.
+func (r *CopilotCompletionRequest) UnmarshalJSON(data []byte) error {
+	type alias struct {
+		Prompt      json.RawMessage `json:"prompt"`
+		Suffix      string          `json:"suffix"`
+		Stream      bool            `json:"stream"`
+		MaxTokens   *int            `json:"max_tokens"`
+		Temperature *float64        `json:"temperature"`
+		TopP        *float64        `json:"top_p"`
+		Stop        json.RawMessage `json:"stop"`
+	}
+
+	var raw alias
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("decode copilot request: %w", err)
+	}
+
+	prompt, err := extractPrompt(raw.Prompt)
+	if err != nil {
+		return err
+	}
+
+	stopValues, err := parseStop(raw.Stop)
+	if err != nil {
+		return err
+	}
+
+	r.Prompt = prompt
+	r.Suffix = raw.Suffix
+	r.Stream = raw.Stream
+	r.MaxTokens = raw.MaxTokens
+	r.Temperature = raw.Temperature
+	r.TopP = raw.TopP
+	r.Stop = stopValues
+	r.Options = make(map[string]any)
+
+	if raw.MaxTokens != nil {
+		r.Options["max_tokens"] = *raw.MaxTokens
+	}
+	if raw.Temperature != nil {
+		r.Options["temperature"] = *raw.Temperature
+	}
+	if raw.TopP != nil {
+		r.Options["top_p"] = *raw.TopP
+	}
+	if len(stopValues) > 0 {
+		r.Options["stop"] = stopValues
+	}
+	if raw.Suffix != "" {
+		r.Options["suffix"] = raw.Suffix
+	}
+
+	if strings.TrimSpace(r.Prompt) == "" {
+		return errors.New("prompt must not be empty")
+	}
+
+	return nil
+}
+
+// ToUnified converts the Copilot request into unified form for the given model.
+func (r CopilotCompletionRequest) ToUnified(model string) models.UnifiedCompletionRequest {
+	options := make(map[string]any, len(r.Options))
+	for k, v := range r.Options {
+		options[k] = v
+	}
+	return models.UnifiedCompletionRequest{
+		Model:       model,
+		Prompt:      r.Prompt,
+		Stream:      r.Stream,
+		MaxTokens:   firstOrDefaultInt(r.MaxTokens),
+		Temperature: firstOrDefaultFloat(r.Temperature),
+		Options:     options,
+	}
+}
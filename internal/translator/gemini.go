@@ -0,0 +1,622 @@
+package translator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gocode-router/internal/models"
+)
+
+var (
+	errGeminiEmptyContents     = errors.New("at least one content is required")
+	errGeminiInvalidRole       = errors.New("invalid role")
+	errGeminiInvalidPart       = errors.New("invalid part")
+	errGeminiInvalidSystem     = errors.New("invalid systemInstruction")
+	errGeminiInvalidTools      = errors.New("invalid tools")
+	errGeminiInvalidToolConfig = errors.New("invalid toolConfig")
+	errGeminiInvalidConfig     = errors.New("invalid generationConfig")
+)
+
+// GeminiRequest models a Gemini generateContent payload. The model is named
+// in the URL rather than the body, so Model is set by the caller.
+type GeminiRequest struct {
+	Model    string
+	Messages []models.Message
+	Tools    []models.ToolDefinition
+	Options  map[string]any
+}
+
+// UnmarshalJSON converts the contents, tools and generation config into
+// unified messages and OpenAI-style options, validating them on the way.
+func (r *GeminiRequest) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Contents          []geminiContent              `json:"contents"`
+		SystemInstruction *geminiContent               `json:"systemInstruction"`
+		Tools             []map[string]json.RawMessage `json:"tools"`
+		ToolConfig        *geminiToolConfig            `json:"toolConfig"`
+		GenerationConfig  *geminiGenerationConfig      `json:"generationConfig"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("decode gemini request: %w", err)
+	}
+	if len(raw.Contents) == 0 {
+		return errGeminiEmptyContents
+	}
+
+	var msgs []models.Message
+	if raw.SystemInstruction != nil {
+		system, err := geminiSystemText(*raw.SystemInstruction)
+		if err != nil {
+			return err
+		}
+		if system != "" {
+			msgs = append(msgs, models.Message{Role: "system", Content: system})
+		}
+	}
+	var calls namedCalls
+	for i, content := range raw.Contents {
+		converted, err := geminiMessages(&calls, content)
+		if err != nil {
+			return fmt.Errorf("contents[%d]: %w", i, err)
+		}
+		msgs = append(msgs, converted...)
+	}
+
+	tools, err := parseGeminiTools(raw.Tools)
+	if err != nil {
+		return err
+	}
+	options, err := geminiOptions(raw.GenerationConfig)
+	if err != nil {
+		return err
+	}
+	toolChoice, err := geminiToolChoice(raw.ToolConfig)
+	if err != nil {
+		return err
+	}
+	if len(toolChoice) > 0 {
+		options["tool_choice"] = toolChoice
+	}
+
+	r.Messages = msgs
+	r.Tools = tools
+	r.Options = options
+	return nil
+}
+
+// ToUnified converts the Gemini request into the canonical format.
+func (r GeminiRequest) ToUnified() models.UnifiedChatRequest {
+	options := make(map[string]any, len(r.Options))
+	for k, v := range r.Options {
+		options[k] = v
+	}
+	return models.UnifiedChatRequest{
+		Model:    r.Model,
+		Messages: r.Messages,
+		Tools:    r.Tools,
+		Options:  options,
+	}
+}
+
+// geminiContent is a turn of a Gemini conversation.
+type geminiContent struct {
+	Role  string       `json:"role"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiPart is a part of a request content. Exactly one field is set,
+// besides Thought, which marks the model's own reasoning.
+type geminiPart struct {
+	Text             *string                 `json:"text"`
+	InlineData       *geminiBlob             `json:"inlineData"`
+	FileData         *geminiFileData         `json:"fileData"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse"`
+	Thought          bool                    `json:"thought"`
+}
+
+type geminiBlob struct {
+	MIMEType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFileData struct {
+	MIMEType string `json:"mimeType"`
+	FileURI  string `json:"fileUri"`
+}
+
+type geminiFunctionResponse struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+// geminiSystemText joins the text parts of a system instruction.
+func geminiSystemText(content geminiContent) (string, error) {
+	texts := make([]string, 0, len(content.Parts))
+	for _, part := range content.Parts {
+		if part.Text == nil {
+			return "", fmt.Errorf("%w: only text parts are supported", errGeminiInvalidSystem)
+		}
+		if text := strings.TrimSpace(*part.Text); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// geminiMessages converts a content into unified messages: tool messages
+// for its function responses, which must come straight after the calls
+// they answer, then a message for the rest.
+func geminiMessages(g *namedCalls, content geminiContent) ([]models.Message, error) {
+	var role string
+	switch content.Role {
+	case "", "user", "function":
+		role = "user"
+	case "model":
+		role = "assistant"
+	default:
+		return nil, fmt.Errorf("%w: %s", errGeminiInvalidRole, content.Role)
+	}
+	if len(content.Parts) == 0 {
+		return nil, fmt.Errorf("%w: content has no parts", errGeminiInvalidPart)
+	}
+
+	var out []models.Message
+	msg := models.Message{Role: role}
+	var texts []string
+	var parts []models.ContentPart
+	for i, part := range content.Parts {
+		switch {
+		case part.Thought:
+		case part.Text != nil:
+			if text := strings.TrimSpace(*part.Text); text != "" {
+				texts = append(texts, text)
+				parts = append(parts, models.ContentPart{Text: text})
+			}
+		case part.InlineData != nil:
+			if !strings.HasPrefix(part.InlineData.MIMEType, "image/") || part.InlineData.Data == "" {
+				return nil, fmt.Errorf("%w: parts[%d]: only inline images are supported", errGeminiInvalidPart, i)
+			}
+			parts = append(parts, models.ContentPart{Image: &models.Image{MediaType: part.InlineData.MIMEType, Data: part.InlineData.Data}})
+		case part.FileData != nil:
+			uri := part.FileData.FileURI
+			if !strings.HasPrefix(uri, "https://") && !strings.HasPrefix(uri, "http://") {
+				return nil, fmt.Errorf("%w: parts[%d]: fileUri must be an http(s) URL", errGeminiInvalidPart, i)
+			}
+			if mimeType := part.FileData.MIMEType; mimeType != "" && !strings.HasPrefix(mimeType, "image/") {
+				return nil, fmt.Errorf("%w: parts[%d]: only image files are supported", errGeminiInvalidPart, i)
+			}
+			parts = append(parts, models.ContentPart{Image: &models.Image{URL: uri}})
+		case part.FunctionCall != nil:
+			if role != "assistant" {
+				return nil, fmt.Errorf("%w: parts[%d]: functionCall parts belong to model contents", errGeminiInvalidPart, i)
+			}
+			if strings.TrimSpace(part.FunctionCall.Name) == "" {
+				return nil, fmt.Errorf("%w: parts[%d]: functionCall needs a name", errGeminiInvalidPart, i)
+			}
+			arguments := "{}"
+			if args := part.FunctionCall.Args; len(args) > 0 && string(args) != "null" {
+				arguments = string(args)
+			}
+			msg.ToolCalls = append(msg.ToolCalls, models.ToolCall{
+				ID:        g.call(part.FunctionCall.Name, part.FunctionCall.ID),
+				Name:      part.FunctionCall.Name,
+				Arguments: arguments,
+			})
+		case part.FunctionResponse != nil:
+			if role != "user" {
+				return nil, fmt.Errorf("%w: parts[%d]: functionResponse parts belong to user contents", errGeminiInvalidPart, i)
+			}
+			id, ok := g.answer(part.FunctionResponse.Name, part.FunctionResponse.ID)
+			if !ok {
+				return nil, fmt.Errorf("%w: parts[%d]: functionResponse %q answers no functionCall", errGeminiInvalidPart, i, part.FunctionResponse.Name)
+			}
+			result := "{}"
+			if response := part.FunctionResponse.Response; len(response) > 0 && string(response) != "null" {
+				result = string(response)
+			}
+			out = append(out, models.Message{Role: "tool", Content: result, ToolCallID: id})
+		default:
+			return nil, fmt.Errorf("%w: parts[%d] has no supported field", errGeminiInvalidPart, i)
+		}
+	}
+	msg.Content = strings.TrimSpace(strings.Join(texts, "\n"))
+	msg.Parts = messageParts(parts)
+	if msg.Content != "" || len(msg.ToolCalls) > 0 || len(msg.Parts) > 0 {
+		out = append(out, msg)
+	}
+	return out, nil
+}
+
+// geminiFunctionDeclaration is a function a Gemini model may call.
+// Parameters is an OpenAPI schema; ParametersJSONSchema a JSON schema.
+type geminiFunctionDeclaration struct {
+	Name                 string          `json:"name"`
+	Description          string          `json:"description"`
+	Parameters           json.RawMessage `json:"parameters"`
+	ParametersJSONSchema json.RawMessage `json:"parametersJsonSchema"`
+}
+
+// parseGeminiTools reads Gemini function declarations. Google's own tools,
+// such as search and code execution, have no counterpart elsewhere.
+func parseGeminiTools(tools []map[string]json.RawMessage) ([]models.ToolDefinition, error) {
+	var out []models.ToolDefinition
+	for i, tool := range tools {
+		for kind, raw := range tool {
+			if kind != "functionDeclarations" {
+				return nil, fmt.Errorf("%w: tools[%d] has unsupported tool %q", errGeminiInvalidTools, i, kind)
+			}
+			var declarations []geminiFunctionDeclaration
+			if err := json.Unmarshal(raw, &declarations); err != nil {
+				return nil, fmt.Errorf("%w: %v", errGeminiInvalidTools, err)
+			}
+			for j, decl := range declarations {
+				if strings.TrimSpace(decl.Name) == "" {
+					return nil, fmt.Errorf("%w: tools[%d].functionDeclarations[%d] is missing a name", errGeminiInvalidTools, i, j)
+				}
+				schema := decl.ParametersJSONSchema
+				if len(schema) == 0 {
+					converted, err := geminiSchema(decl.Parameters)
+					if err != nil {
+						return nil, fmt.Errorf("%w: tools[%d].functionDeclarations[%d]: %v", errGeminiInvalidTools, i, j, err)
+					}
+					schema = converted
+				}
+				out = append(out, models.ToolDefinition{Name: decl.Name, Description: decl.Description, Parameters: schema})
+			}
+		}
+	}
+	return out, nil
+}
+
+// geminiSchema turns a Gemini OpenAPI schema into a JSON schema. The two
+// differ mostly in Gemini's upper-case type names.
+func geminiSchema(raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var schema any
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, err
+	}
+	return json.Marshal(lowerSchemaTypes(schema))
+}
+
+func lowerSchemaTypes(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if name, ok := value.(string); ok && key == "type" {
+				v[key] = strings.ToLower(name)
+				continue
+			}
+			v[key] = lowerSchemaTypes(value)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = lowerSchemaTypes(item)
+		}
+	}
+	return v
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig *struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames"`
+	} `json:"functionCallingConfig"`
+}
+
+// geminiToolChoice maps Gemini's function calling mode onto OpenAI's
+// tool_choice: AUTO stays auto, ANY becomes required, or names the one
+// function allowed, and NONE stays none.
+func geminiToolChoice(cfg *geminiToolConfig) (json.RawMessage, error) {
+	if cfg == nil || cfg.FunctionCallingConfig == nil {
+		return nil, nil
+	}
+	calling := cfg.FunctionCallingConfig
+	var out any
+	switch calling.Mode {
+	case "", "MODE_UNSPECIFIED", "AUTO":
+		out = "auto"
+	case "NONE":
+		out = "none"
+	case "ANY":
+		switch len(calling.AllowedFunctionNames) {
+		case 0:
+			out = "required"
+		case 1:
+			out = map[string]any{"type": "function", "function": map[string]any{"name": calling.AllowedFunctionNames[0]}}
+		default:
+			return nil, fmt.Errorf("%w: allowedFunctionNames may name at most one function", errGeminiInvalidToolConfig)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported mode %q", errGeminiInvalidToolConfig, calling.Mode)
+	}
+	return json.Marshal(out)
+}
+
+type geminiGenerationConfig struct {
+	Temperature        *float64        `json:"temperature"`
+	TopP               *float64        `json:"topP"`
+	MaxOutputTokens    *int            `json:"maxOutputTokens"`
+	StopSequences      []string        `json:"stopSequences"`
+	CandidateCount     *int            `json:"candidateCount"`
+	PresencePenalty    *float64        `json:"presencePenalty"`
+	FrequencyPenalty   *float64        `json:"frequencyPenalty"`
+	ResponseMIMEType   string          `json:"responseMimeType"`
+	ResponseSchema     json.RawMessage `json:"responseSchema"`
+	ResponseJSONSchema json.RawMessage `json:"responseJsonSchema"`
+}
+
+// geminiOptions maps a generation config onto OpenAI-style options. JSON
+// output becomes a response_format.
+func geminiOptions(cfg *geminiGenerationConfig) (map[string]any, error) {
+	options := make(map[string]any)
+	if cfg == nil {
+		return options, nil
+	}
+	if cfg.Temperature != nil {
+		options["temperature"] = *cfg.Temperature
+	}
+	if cfg.TopP != nil {
+		options["top_p"] = *cfg.TopP
+	}
+	if cfg.MaxOutputTokens != nil {
+		options["max_tokens"] = *cfg.MaxOutputTokens
+	}
+	if len(cfg.StopSequences) > 0 {
+		options["stop"] = cfg.StopSequences
+	}
+	if cfg.CandidateCount != nil {
+		if *cfg.CandidateCount < 1 {
+			return nil, fmt.Errorf("%w: candidateCount must be at least 1", errGeminiInvalidConfig)
+		}
+		options["n"] = *cfg.CandidateCount
+	}
+	if cfg.PresencePenalty != nil {
+		options["presence_penalty"] = *cfg.PresencePenalty
+	}
+	if cfg.FrequencyPenalty != nil {
+		options["frequency_penalty"] = *cfg.FrequencyPenalty
+	}
+
+	switch cfg.ResponseMIMEType {
+	case "", "text/plain":
+	case "application/json":
+		schema := cfg.ResponseJSONSchema
+		if len(schema) == 0 {
+			converted, err := geminiSchema(cfg.ResponseSchema)
+			if err != nil {
+				return nil, fmt.Errorf("%w: responseSchema: %v", errGeminiInvalidConfig, err)
+			}
+			schema = converted
+		}
+		if len(schema) == 0 {
+			options["response_format"] = map[string]any{"type": "json_object"}
+			break
+		}
+		var decoded any
+		if err := json.Unmarshal(schema, &decoded); err != nil {
+			return nil, fmt.Errorf("%w: responseJsonSchema: %v", errGeminiInvalidConfig, err)
+		}
+		options["response_format"] = map[string]any{
+			"type":        "json_schema",
+			"json_schema": map[string]any{"name": "response", "schema": decoded},
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported responseMimeType %q", errGeminiInvalidConfig, cfg.ResponseMIMEType)
+	}
+	return options, nil
+}
+
+// GeminiResponse models a Gemini generateContent response, and each
+// response of a streamGenerateContent stream.
+type GeminiResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+	ResponseID    string               `json:"responseId,omitempty"`
+
+	RouterMetadata map[string]any `json:"router_metadata,omitempty"`
+}
+
+// GeminiCandidate is one answer of a Gemini response.
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+// GeminiContent is the content of a candidate.
+type GeminiContent struct {
+	Role  string       `json:"role"`
+	Parts []GeminiPart `json:"parts,omitempty"`
+}
+
+// GeminiPart is a text or functionCall part of a response.
+type GeminiPart struct {
+	Text         string              `json:"text,omitempty"`
+	FunctionCall *GeminiFunctionCall `json:"functionCall,omitempty"`
+}
+
+// GeminiFunctionCall is a call of a function the client declared.
+type GeminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args"`
+}
+
+// GeminiUsageMetadata mirrors Gemini usage. Prompt tokens read from a
+// provider's prompt cache are reported as cachedContentTokenCount.
+type GeminiUsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+}
+
+// FromUnifiedGemini converts the unified response to Gemini format.
+func FromUnifiedGemini(modelID string, resp *models.UnifiedChatResponse) GeminiResponse {
+	candidates := []GeminiCandidate{geminiCandidate(0, resp.Message, resp.FinishReason)}
+	for i, extra := range resp.ExtraChoices {
+		candidates = append(candidates, geminiCandidate(i+1, extra.Message, extra.FinishReason))
+	}
+	return GeminiResponse{
+		Candidates:     candidates,
+		UsageMetadata:  geminiUsage(resp.Usage),
+		ModelVersion:   modelID,
+		ResponseID:     resp.ID,
+		RouterMetadata: resp.Metadata,
+	}
+}
+
+func geminiCandidate(index int, msg models.Message, finishReason string) GeminiCandidate {
+	var parts []GeminiPart
+	if msg.Content != "" {
+		parts = append(parts, GeminiPart{Text: msg.Content})
+	}
+	parts = append(parts, geminiFunctionCalls(msg.ToolCalls)...)
+	return GeminiCandidate{
+		Content:      GeminiContent{Role: "model", Parts: parts},
+		FinishReason: geminiFinishReason(finishReason),
+		Index:        index,
+	}
+}
+
+func geminiFunctionCalls(calls []models.ToolCall) []GeminiPart {
+	parts := make([]GeminiPart, 0, len(calls))
+	for _, call := range calls {
+		parts = append(parts, GeminiPart{FunctionCall: &GeminiFunctionCall{ID: call.ID, Name: call.Name, Args: toolInput(call.Arguments)}})
+	}
+	return parts
+}
+
+func geminiUsage(u models.Usage) *GeminiUsageMetadata {
+	total := u.TotalTokens
+	if total == 0 {
+		total = u.PromptTokens + u.CompletionTokens
+	}
+	return &GeminiUsageMetadata{
+		PromptTokenCount:        u.PromptTokens,
+		CandidatesTokenCount:    u.CompletionTokens,
+		TotalTokenCount:         total,
+		CachedContentTokenCount: u.CacheReadTokens,
+	}
+}
+
+// geminiFinishReason reports a finish reason as Gemini does. Gemini stops
+// normally to call functions too.
+func geminiFinishReason(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "stop", "end_turn", "stop_sequence", finishToolCalls, stopReasonToolUse:
+		return "STOP"
+	case "length", "max_tokens":
+		return "MAX_TOKENS"
+	case "content_filter", "refusal":
+		return "SAFETY"
+	default:
+		return "OTHER"
+	}
+}
+
+// GeminiStreamEncoder renders a unified chat stream as the responses of a
+// Gemini streamGenerateContent stream, one per content delta. Gemini sends
+// each function call whole, so tool call pieces are gathered and sent with
+// the last response. Candidates after the first are dropped.
+type GeminiStreamEncoder struct {
+	modelID string
+	id      string
+	calls   []models.ToolCall
+}
+
+// NewGeminiStreamEncoder returns an encoder for a stream from modelID.
+func NewGeminiStreamEncoder(modelID string) *GeminiStreamEncoder {
+	return &GeminiStreamEncoder{modelID: modelID}
+}
+
+// Encode returns the response for c, if it carries any text.
+func (e *GeminiStreamEncoder) Encode(c models.UnifiedChatChunk) (GeminiResponse, bool) {
+	if c.ID != "" {
+		e.id = c.ID
+	}
+	if c.Index != 0 {
+		return GeminiResponse{}, false
+	}
+	for _, call := range c.ToolCalls {
+		for len(e.calls) <= call.Index {
+			e.calls = append(e.calls, models.ToolCall{})
+		}
+		gathered := &e.calls[call.Index]
+		if call.ID != "" {
+			gathered.ID = call.ID
+		}
+		if call.Name != "" {
+			gathered.Name = call.Name
+		}
+		gathered.Arguments += call.Arguments
+	}
+	if c.Content == "" {
+		return GeminiResponse{}, false
+	}
+	return e.response(GeminiCandidate{Content: GeminiContent{Role: "model", Parts: []GeminiPart{{Text: c.Content}}}}, nil), true
+}
+
+// Finish returns the last response: the function calls, the finish reason
+// and the usage.
+func (e *GeminiStreamEncoder) Finish(finishReason string, usage models.Usage) GeminiResponse {
+	return e.response(GeminiCandidate{
+		Content:      GeminiContent{Role: "model", Parts: geminiFunctionCalls(e.calls)},
+		FinishReason: geminiFinishReason(finishReason),
+	}, geminiUsage(usage))
+}
+
+func (e *GeminiStreamEncoder) response(candidate GeminiCandidate, usage *GeminiUsageMetadata) GeminiResponse {
+	return GeminiResponse{
+		Candidates:    []GeminiCandidate{candidate},
+		UsageMetadata: usage,
+		ModelVersion:  e.modelID,
+		ResponseID:    e.id,
+	}
+}
+
+// GeminiError is a Google API error body.
+type GeminiError struct {
+	Error GeminiErrorStatus `json:"error"`
+}
+
+// GeminiErrorStatus is the status of a failed Google API call.
+type GeminiErrorStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// NewGeminiError returns the error body Google sends for an HTTP status.
+func NewGeminiError(status int, message string) GeminiError {
+	var name string
+	switch status {
+	case 400:
+		name = "INVALID_ARGUMENT"
+	case 401:
+		name = "UNAUTHENTICATED"
+	case 403:
+		name = "PERMISSION_DENIED"
+	case 404:
+		name = "NOT_FOUND"
+	case 429:
+		name = "RESOURCE_EXHAUSTED"
+	case 503:
+		name = "UNAVAILABLE"
+	case 504:
+		name = "DEADLINE_EXCEEDED"
+	default:
+		name = "INTERNAL"
+	}
+	return GeminiError{Error: GeminiErrorStatus{Code: status, Message: message, Status: name}}
+}
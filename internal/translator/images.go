@@ -0,0 +1,66 @@
+package translator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gocode-router/internal/models"
+)
+
+// parseImageURL reads the url of an OpenAI image_url part: a base64 data
+// URI or an http(s) URL.
+func parseImageURL(url, detail string) (*models.Image, error) {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		mediaType, data, ok := strings.Cut(rest, ";base64,")
+		if !ok || mediaType == "" || data == "" {
+			return nil, fmt.Errorf("%w: image data URIs must be base64 encoded with a media type", errInvalidContent)
+		}
+		return &models.Image{MediaType: mediaType, Data: data, Detail: detail}, nil
+	}
+	if strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") {
+		return &models.Image{URL: url, Detail: detail}, nil
+	}
+	return nil, fmt.Errorf("%w: image url must be an http(s) URL or a data URI", errInvalidContent)
+}
+
+// claudeImageSource is the source of an Anthropic image block.
+type claudeImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// parseClaudeImage reads the source of an Anthropic image block.
+func parseClaudeImage(raw json.RawMessage) (*models.Image, error) {
+	var source claudeImageSource
+	if err := json.Unmarshal(raw, &source); err != nil {
+		return nil, fmt.Errorf("%w: invalid image source", errClaudeInvalidContent)
+	}
+	switch source.Type {
+	case "base64":
+		if source.MediaType == "" || source.Data == "" {
+			return nil, fmt.Errorf("%w: base64 images need media_type and data", errClaudeInvalidContent)
+		}
+		return &models.Image{MediaType: source.MediaType, Data: source.Data}, nil
+	case "url":
+		if source.URL == "" {
+			return nil, fmt.Errorf("%w: url images need a url", errClaudeInvalidContent)
+		}
+		return &models.Image{URL: source.URL}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported image source type %q", errClaudeInvalidContent, source.Type)
+	}
+}
+
+// messageParts returns the parts of a message when it has images, and nil
+// for text alone.
+func messageParts(parts []models.ContentPart) []models.ContentPart {
+	for _, part := range parts {
+		if part.Image != nil {
+			return parts
+		}
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package translator
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gocode-router/internal/models"
+)
+
+var (
+	errOllamaEmptyModel    = errors.New("model must be provided")
+	errOllamaEmptyMessages = errors.New("at least one message is required")
+	errOllamaInvalidRole   = errors.New("invalid role")
+	errOllamaInvalidImage  = errors.New("invalid image")
+	errOllamaInvalidFormat = errors.New("invalid format")
+	errOllamaInvalidTool   = errors.New("invalid tool message")
+)
+
+// OllamaChatRequest models an Ollama /api/chat payload. Ollama streams
+// unless told not to, so Stream defaults to true.
+type OllamaChatRequest struct {
+	Model    string
+	Messages []models.Message
+	Tools    []models.ToolDefinition
+	Stream   bool
+	Options  map[string]any
+}
+
+// UnmarshalJSON converts the messages, tools and options into their
+// unified forms, validating them on the way.
+func (r *OllamaChatRequest) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Model    string          `json:"model"`
+		Messages []ollamaMessage `json:"messages"`
+		Tools    json.RawMessage `json:"tools"`
+		Format   json.RawMessage `json:"format"`
+		Stream   *bool           `json:"stream"`
+		Options  *ollamaOptions  `json:"options"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("decode ollama request: %w", err)
+	}
+	r.Model = strings.TrimSpace(raw.Model)
+	if r.Model == "" {
+		return errOllamaEmptyModel
+	}
+	if len(raw.Messages) == 0 {
+		return errOllamaEmptyMessages
+	}
+
+	var calls namedCalls
+	r.Messages = make([]models.Message, 0, len(raw.Messages))
+	for i, m := range raw.Messages {
+		msg, err := m.toUnified(&calls)
+		if err != nil {
+			return fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		r.Messages = append(r.Messages, msg)
+	}
+
+	tools, err := parseOpenAITools(raw.Tools)
+	if err != nil {
+		return err
+	}
+	r.Tools = tools
+	r.Stream = raw.Stream == nil || *raw.Stream
+	r.Options = raw.Options.unified()
+	format, err := ollamaFormat(raw.Format)
+	if err != nil {
+		return err
+	}
+	if format != nil {
+		r.Options["response_format"] = format
+	}
+	return nil
+}
+
+// ToUnified converts the Ollama request into the canonical format.
+func (r OllamaChatRequest) ToUnified() models.UnifiedChatRequest {
+	options := make(map[string]any, len(r.Options))
+	for k, v := range r.Options {
+		options[k] = v
+	}
+	return models.UnifiedChatRequest{
+		Model:    r.Model,
+		Messages: r.Messages,
+		Stream:   r.Stream,
+		Tools:    r.Tools,
+		Options:  options,
+	}
+}
+
+// ollamaMessage is a message of an Ollama chat. Images are base64 without
+// a media type; tool messages name the function they answer in ToolName.
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images"`
+	ToolCalls []OllamaToolCall `json:"tool_calls"`
+	ToolName  string           `json:"tool_name"`
+}
+
+func (m ollamaMessage) toUnified(calls *namedCalls) (models.Message, error) {
+	msg := models.Message{Role: strings.TrimSpace(m.Role), Content: m.Content}
+	switch msg.Role {
+	case "system", "user", "assistant":
+	case "tool":
+		id, ok := calls.answer(m.ToolName, "")
+		if !ok {
+			return models.Message{}, fmt.Errorf("%w: it answers no tool call", errOllamaInvalidTool)
+		}
+		msg.ToolCallID = id
+		return msg, nil
+	default:
+		return models.Message{}, fmt.Errorf("%w: %s", errOllamaInvalidRole, m.Role)
+	}
+
+	for _, call := range m.ToolCalls {
+		arguments := "{}"
+		if args := call.Function.Arguments; len(args) > 0 && string(args) != "null" {
+			arguments = string(args)
+		}
+		msg.ToolCalls = append(msg.ToolCalls, models.ToolCall{
+			ID:        calls.call(call.Function.Name, ""),
+			Name:      call.Function.Name,
+			Arguments: arguments,
+		})
+	}
+	if len(m.Images) == 0 {
+		return msg, nil
+	}
+	if text := strings.TrimSpace(m.Content); text != "" {
+		msg.Parts = append(msg.Parts, models.ContentPart{Text: text})
+	}
+	for i, data := range m.Images {
+		image, err := sniffImage(data)
+		if err != nil {
+			return models.Message{}, fmt.Errorf("images[%d]: %w", i, err)
+		}
+		msg.Parts = append(msg.Parts, models.ContentPart{Image: image})
+	}
+	return msg, nil
+}
+
+// sniffImage returns a base64 image with the media type its first bytes
+// show, which other APIs require.
+func sniffImage(data string) (*models.Image, error) {
+	head := data[:min(len(data), 64)]
+	head = head[:len(head)/4*4]
+	decoded, err := base64.StdEncoding.DecodeString(head)
+	if err != nil || len(decoded) == 0 {
+		return nil, fmt.Errorf("%w: images must be base64 encoded", errOllamaInvalidImage)
+	}
+	mediaType := http.DetectContentType(decoded)
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, fmt.Errorf("%w: unrecognised image type %s", errOllamaInvalidImage, mediaType)
+	}
+	return &models.Image{MediaType: mediaType, Data: data}, nil
+}
+
+// ollamaOptions are the model options of an Ollama request the other APIs
+// have a counterpart for. A negative num_predict means no limit.
+type ollamaOptions struct {
+	Temperature      *float64 `json:"temperature"`
+	TopP             *float64 `json:"top_p"`
+	NumPredict       *int     `json:"num_predict"`
+	Stop             []string `json:"stop"`
+	PresencePenalty  *float64 `json:"presence_penalty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty"`
+}
+
+func (o *ollamaOptions) unified() map[string]any {
+	options := make(map[string]any)
+	if o == nil {
+		return options
+	}
+	if o.Temperature != nil {
+		options["temperature"] = *o.Temperature
+	}
+	if o.TopP != nil {
+		options["top_p"] = *o.TopP
+	}
+	if o.NumPredict != nil && *o.NumPredict > 0 {
+		options["max_tokens"] = *o.NumPredict
+	}
+	if len(o.Stop) > 0 {
+		options["stop"] = o.Stop
+	}
+	if o.PresencePenalty != nil {
+		options["presence_penalty"] = *o.PresencePenalty
+	}
+	if o.FrequencyPenalty != nil {
+		options["frequency_penalty"] = *o.FrequencyPenalty
+	}
+	return options
+}
+
+// ollamaFormat maps Ollama's format, "json" or a JSON schema, onto an
+// OpenAI response_format.
+func ollamaFormat(raw json.RawMessage) (map[string]any, error) {
+	if len(raw) == 0 || string(raw) == "null" || string(raw) == `""` {
+		return nil, nil
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err == nil {
+		if name != "json" {
+			return nil, fmt.Errorf("%w: %q", errOllamaInvalidFormat, name)
+		}
+		return map[string]any{"type": "json_object"}, nil
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, fmt.Errorf("%w: must be \"json\" or a JSON schema", errOllamaInvalidFormat)
+	}
+	return map[string]any{
+		"type":        "json_schema",
+		"json_schema": map[string]any{"name": "response", "schema": schema},
+	}, nil
+}
+
+// OllamaChatResponse models an Ollama /api/chat response, and each line of
+// a streamed one. Only the last, with Done set, carries the counts.
+type OllamaChatResponse struct {
+	Model           string        `json:"model"`
+	CreatedAt       string        `json:"created_at"`
+	Message         OllamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason,omitempty"`
+	TotalDuration   int64         `json:"total_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+	EvalCount       int           `json:"eval_count,omitempty"`
+
+	RouterMetadata map[string]any `json:"router_metadata,omitempty"`
+}
+
+// OllamaMessage is the message of an Ollama response.
+type OllamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+}
+
+// OllamaToolCall is a call of a function; its arguments are a JSON object
+// rather than a string.
+type OllamaToolCall struct {
+	Function OllamaFunctionCall `json:"function"`
+}
+
+// OllamaFunctionCall names the function of a tool call.
+type OllamaFunctionCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// FromUnifiedOllama converts the unified response to Ollama format. Ollama
+// has no choices after the first, so theirs are dropped.
+func FromUnifiedOllama(modelID string, resp *models.UnifiedChatResponse, elapsed time.Duration) OllamaChatResponse {
+	out := ollamaDone(modelID, resp.Message.ToolCalls, resp.FinishReason, resp.Usage, elapsed)
+	out.Message.Content = resp.Message.Content
+	out.RouterMetadata = resp.Metadata
+	return out
+}
+
+func ollamaDone(modelID string, calls []models.ToolCall, finishReason string, usage models.Usage, elapsed time.Duration) OllamaChatResponse {
+	out := OllamaChatResponse{
+		Model:           modelID,
+		CreatedAt:       ollamaNow(),
+		Message:         OllamaMessage{Role: "assistant"},
+		Done:            true,
+		DoneReason:      ollamaDoneReason(finishReason),
+		TotalDuration:   elapsed.Nanoseconds(),
+		PromptEvalCount: usage.PromptTokens,
+		EvalCount:       usage.CompletionTokens,
+	}
+	for _, call := range calls {
+		out.Message.ToolCalls = append(out.Message.ToolCalls, OllamaToolCall{Function: OllamaFunctionCall{Name: call.Name, Arguments: toolInput(call.Arguments)}})
+	}
+	return out
+}
+
+func ollamaNow() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// ollamaDoneReason reports a finish reason as Ollama does: stop, calls of
+// tools included, or length.
+func ollamaDoneReason(reason string) string {
+	switch reason {
+	case "", "end_turn", "stop_sequence", finishToolCalls, stopReasonToolUse:
+		return "stop"
+	case "max_tokens":
+		return "length"
+	default:
+		return reason
+	}
+}
+
+// OllamaStreamEncoder renders a unified chat stream as the lines of an
+// Ollama chat stream, one per content delta. Ollama sends each tool call
+// whole, so tool call pieces are gathered and sent with the last line.
+// Choices after the first are dropped.
+type OllamaStreamEncoder struct {
+	modelID string
+	started time.Time
+	calls   []models.ToolCall
+}
+
+// NewOllamaStreamEncoder returns an encoder for a stream from modelID that
+// started at started.
+func NewOllamaStreamEncoder(modelID string, started time.Time) *OllamaStreamEncoder {
+	return &OllamaStreamEncoder{modelID: modelID, started: started}
+}
+
+// Encode returns the line for c, if it carries any content.
+func (e *OllamaStreamEncoder) Encode(c models.UnifiedChatChunk) (OllamaChatResponse, bool) {
+	if c.Index != 0 {
+		return OllamaChatResponse{}, false
+	}
+	for _, call := range c.ToolCalls {
+		for len(e.calls) <= call.Index {
+			e.calls = append(e.calls, models.ToolCall{})
+		}
+		gathered := &e.calls[call.Index]
+		if call.Name != "" {
+			gathered.Name = call.Name
+		}
+		gathered.Arguments += call.Arguments
+	}
+	if c.Content == "" {
+		return OllamaChatResponse{}, false
+	}
+	return OllamaChatResponse{
+		Model:     e.modelID,
+		CreatedAt: ollamaNow(),
+		Message:   OllamaMessage{Role: "assistant", Content: c.Content},
+	}, true
+}
+
+// Finish returns the last line: the tool calls, the done reason and the
+// counts.
+func (e *OllamaStreamEncoder) Finish(finishReason string, usage models.Usage) OllamaChatResponse {
+	return ollamaDone(e.modelID, e.calls, finishReason, usage, time.Since(e.started))
+}
@@ -15,6 +15,7 @@ var (
 	errUnsupportedStop = errors.New("unsupported stop value")
 	errInvalidRole     = errors.New("invalid role")
 	errInvalidContent  = errors.New("invalid message content")
+	errInvalidN        = errors.New("n must be at least 1")
 )
 
 var allowedRoles = map[string]struct{}{
@@ -26,43 +27,56 @@ var allowedRoles = map[string]struct{}{
 
 // ChatCompletionRequest models the OpenAI chat/completions request payload.
 type ChatCompletionRequest struct {
-	Model            string
-	Messages         []ChatMessage
-	Stream           bool
-	MaxTokens        *int
-	Temperature      *float64
-	TopP             *float64
-	FrequencyPenalty *float64
-	PresencePenalty  *float64
-	Stop             []string
-	ResponseFormat   map[string]any
-	ToolsRaw         json.RawMessage
-	ToolChoiceRaw    json.RawMessage
-	LogitBias        map[string]float64
-	Metadata         map[string]any
-	User             string
-	Options          map[string]any
+	Model    string
+	Messages []ChatMessage
+	Stream   bool
+	// IncludeUsage asks for a usage chunk at the end of the stream
+	// (stream_options.include_usage).
+	IncludeUsage      bool
+	MaxTokens         *int
+	Temperature       *float64
+	TopP              *float64
+	FrequencyPenalty  *float64
+	PresencePenalty   *float64
+	Stop              []string
+	ResponseFormat    map[string]any
+	Tools             []models.ToolDefinition
+	ToolChoiceRaw     json.RawMessage
+	ParallelToolCalls *bool
+	LogitBias         map[string]float64
+	Metadata          map[string]any
+	Store             bool
+	User              string
+	// N is how many choices to generate; nil means one.
+	N       *int
+	Options map[string]any
 }
 
 // UnmarshalJSON implements custom parsing to enforce validation.
 func (r *ChatCompletionRequest) UnmarshalJSON(data []byte) error {
 	type alias struct {
-		Model            string             `json:"model"`
-		Messages         []ChatMessage      `json:"messages"`
-		Stream           bool               `json:"stream"`
-		MaxTokens        *int               `json:"max_tokens"`
-		Temperature      *float64           `json:"temperature"`
-		TopP             *float64           `json:"top_p"`
-		FrequencyPenalty *float64           `json:"frequency_penalty"`
-		PresencePenalty  *float64           `json:"presence_penalty"`
-		Stop             json.RawMessage    `json:"stop"`
-		ResponseFormat   map[string]any     `json:"response_format"`
-		Tools            json.RawMessage    `json:"tools"`
-		ToolChoice       json.RawMessage    `json:"tool_choice"`
-		LogitBias        map[string]float64 `json:"logit_bias"`
-		Metadata         map[string]any     `json:"metadata"`
-		User             string             `json:"user"`
-		Seed             json.RawMessage    `json:"seed"`
+		Model         string        `json:"model"`
+		Messages      []ChatMessage `json:"messages"`
+		Stream        bool          `json:"stream"`
+		StreamOptions *struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+		MaxTokens         *int               `json:"max_tokens"`
+		Temperature       *float64           `json:"temperature"`
+		TopP              *float64           `json:"top_p"`
+		FrequencyPenalty  *float64           `json:"frequency_penalty"`
+		PresencePenalty   *float64           `json:"presence_penalty"`
+		Stop              json.RawMessage    `json:"stop"`
+		ResponseFormat    map[string]any     `json:"response_format"`
+		Tools             json.RawMessage    `json:"tools"`
+		ToolChoice        json.RawMessage    `json:"tool_choice"`
+		ParallelToolCalls *bool              `json:"parallel_tool_calls"`
+		LogitBias         map[string]float64 `json:"logit_bias"`
+		Metadata          map[string]any     `json:"metadata"`
+		Store             bool               `json:"store"`
+		User              string             `json:"user"`
+		Seed              json.RawMessage    `json:"seed"`
+		N                 *int               `json:"n"`
 	}
 
 	var raw alias
@@ -75,9 +89,15 @@ func (r *ChatCompletionRequest) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	tools, err := parseOpenAITools(raw.Tools)
+	if err != nil {
+		return err
+	}
+
 	r.Model = strings.TrimSpace(raw.Model)
 	r.Messages = raw.Messages
 	r.Stream = raw.Stream
+	r.IncludeUsage = raw.StreamOptions != nil && raw.StreamOptions.IncludeUsage
 	r.MaxTokens = raw.MaxTokens
 	r.Temperature = raw.Temperature
 	r.TopP = raw.TopP
@@ -85,11 +105,14 @@ func (r *ChatCompletionRequest) UnmarshalJSON(data []byte) error {
 	r.PresencePenalty = raw.PresencePenalty
 	r.Stop = stopValues
 	r.ResponseFormat = raw.ResponseFormat
-	r.ToolsRaw = raw.Tools
+	r.Tools = tools
 	r.ToolChoiceRaw = raw.ToolChoice
+	r.ParallelToolCalls = raw.ParallelToolCalls
 	r.LogitBias = raw.LogitBias
 	r.Metadata = raw.Metadata
+	r.Store = raw.Store
 	r.User = raw.User
+	r.N = raw.N
 
 	r.Options = make(map[string]any)
 	if raw.Temperature != nil {
@@ -113,21 +136,27 @@ func (r *ChatCompletionRequest) UnmarshalJSON(data []byte) error {
 	if raw.ResponseFormat != nil {
 		r.Options["response_format"] = raw.ResponseFormat
 	}
-	if len(raw.Tools) > 0 {
-		r.Options["tools"] = json.RawMessage(raw.Tools)
-	}
 	if len(raw.ToolChoice) > 0 {
 		r.Options["tool_choice"] = json.RawMessage(raw.ToolChoice)
 	}
+	if raw.ParallelToolCalls != nil {
+		r.Options["parallel_tool_calls"] = *raw.ParallelToolCalls
+	}
 	if raw.LogitBias != nil {
 		r.Options["logit_bias"] = raw.LogitBias
 	}
 	if raw.Metadata != nil {
 		r.Options["metadata"] = raw.Metadata
 	}
+	if raw.Store {
+		r.Options["store"] = true
+	}
 	if raw.User != "" {
 		r.Options["user"] = raw.User
 	}
+	if raw.N != nil {
+		r.Options["n"] = *raw.N
+	}
 
 	return r.validate()
 }
@@ -139,6 +168,9 @@ func (r *ChatCompletionRequest) validate() error {
 	if len(r.Messages) == 0 {
 		return errEmptyMessages
 	}
+	if r.N != nil && *r.N < 1 {
+		return errInvalidN
+	}
 	for i, msg := range r.Messages {
 		if err := msg.validate(); err != nil {
 			return fmt.Errorf("message[%d]: %w", i, err)
@@ -152,9 +184,12 @@ func (r ChatCompletionRequest) ToUnified() models.UnifiedChatRequest {
 	msgs := make([]models.Message, 0, len(r.Messages))
 	for _, m := range r.Messages {
 		msgs = append(msgs, models.Message{
-			Role:    m.Role,
-			Content: m.Content,
-			Name:    m.Name,
+			Role:       m.Role,
+			Content:    m.Content,
+			Name:       m.Name,
+			ToolCalls:  toUnifiedToolCalls(m.ToolCalls),
+			ToolCallID: m.ToolCallID,
+			Parts:      m.Parts,
 		})
 	}
 
@@ -167,6 +202,7 @@ func (r ChatCompletionRequest) ToUnified() models.UnifiedChatRequest {
 		Model:    r.Model,
 		Messages: msgs,
 		Stream:   r.Stream,
+		Tools:    r.Tools,
 		Options:  options,
 	}
 }
@@ -176,14 +212,59 @@ type ChatMessage struct {
 	Role    string
 	Content string
 	Name    string
+	// ToolCalls are the tools an assistant message calls.
+	ToolCalls []ChatToolCall
+	// ToolCallID ties a tool message to the call it answers.
+	ToolCallID string
+	// Parts holds the text and images of a request message with images.
+	Parts []models.ContentPart
+	// Annotations are only set on response messages.
+	Annotations []Annotation
+}
+
+// Annotation is an OpenAI message annotation. Only url_citation is
+// produced.
+type Annotation struct {
+	Type        string       `json:"type"`
+	URLCitation *URLCitation `json:"url_citation,omitempty"`
 }
 
-// UnmarshalJSON supports string and array-of-text content formats.
+// URLCitation cites a web page for the characters [StartIndex, EndIndex)
+// of the message content.
+type URLCitation struct {
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+	URL        string `json:"url"`
+	Title      string `json:"title"`
+}
+
+// MarshalJSON writes the message in OpenAI's wire format. The content of a
+// message that only calls tools is null.
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type wire struct {
+		Role        string         `json:"role"`
+		Content     *string        `json:"content"`
+		Name        string         `json:"name,omitempty"`
+		ToolCalls   []ChatToolCall `json:"tool_calls,omitempty"`
+		ToolCallID  string         `json:"tool_call_id,omitempty"`
+		Annotations []Annotation   `json:"annotations,omitempty"`
+	}
+	out := wire{Role: m.Role, Name: m.Name, ToolCalls: m.ToolCalls, ToolCallID: m.ToolCallID, Annotations: m.Annotations}
+	if m.Content != "" || len(m.ToolCalls) == 0 {
+		out.Content = &m.Content
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON supports string and array-of-text content formats. An
+// assistant message that calls tools may have no content.
 func (m *ChatMessage) UnmarshalJSON(data []byte) error {
 	type alias struct {
-		Role    string          `json:"role"`
-		Content json.RawMessage `json:"content"`
-		Name    string          `json:"name"`
+		Role       string          `json:"role"`
+		Content    json.RawMessage `json:"content"`
+		Name       string          `json:"name"`
+		ToolCalls  []ChatToolCall  `json:"tool_calls"`
+		ToolCallID string          `json:"tool_call_id"`
 	}
 
 	var raw alias
@@ -191,14 +272,26 @@ func (m *ChatMessage) UnmarshalJSON(data []byte) error {
 		return fmt.Errorf("decode message: %w", err)
 	}
 
-	content, err := extractMessageContent(raw.Content)
-	if err != nil {
+	if err := validateToolCalls(raw.ToolCalls); err != nil {
 		return err
 	}
 
+	var content string
+	var parts []models.ContentPart
+	if len(raw.ToolCalls) == 0 || (len(raw.Content) > 0 && string(raw.Content) != "null") {
+		text, contentParts, err := extractMessageContent(raw.Content)
+		if err != nil {
+			return err
+		}
+		content, parts = text, contentParts
+	}
+
 	m.Role = strings.TrimSpace(raw.Role)
 	m.Content = content
 	m.Name = strings.TrimSpace(raw.Name)
+	m.ToolCalls = raw.ToolCalls
+	m.ToolCallID = strings.TrimSpace(raw.ToolCallID)
+	m.Parts = parts
 
 	return m.validate()
 }
@@ -207,38 +300,61 @@ func (m *ChatMessage) validate() error {
 	if _, ok := allowedRoles[m.Role]; !ok {
 		return fmt.Errorf("%w: %s", errInvalidRole, m.Role)
 	}
-	if strings.TrimSpace(m.Content) == "" {
+	if len(m.ToolCalls) > 0 && m.Role != "assistant" {
+		return fmt.Errorf("%w: only assistant messages may call tools", errInvalidToolCalls)
+	}
+	if m.Role == "tool" && m.ToolCallID == "" {
+		return fmt.Errorf("%w: tool messages need a tool_call_id", errInvalidContent)
+	}
+	// A tool may have returned nothing.
+	if strings.TrimSpace(m.Content) == "" && len(m.ToolCalls) == 0 && len(m.Parts) == 0 && m.Role != "tool" {
 		return fmt.Errorf("%w: message content must not be empty", errInvalidContent)
 	}
 	return nil
 }
 
-func extractMessageContent(raw json.RawMessage) (string, error) {
+// extractMessageContent returns the text of message content, and its
+// parts too when it has images.
+func extractMessageContent(raw json.RawMessage) (string, []models.ContentPart, error) {
 	if raw == nil {
-		return "", fmt.Errorf("%w: missing content", errInvalidContent)
+		return "", nil, fmt.Errorf("%w: missing content", errInvalidContent)
 	}
 
 	var text string
 	if err := json.Unmarshal(raw, &text); err == nil {
-		return text, nil
+		return text, nil, nil
 	}
 
 	var segments []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL struct {
+			URL    string `json:"url"`
+			Detail string `json:"detail"`
+		} `json:"image_url"`
 	}
 	if err := json.Unmarshal(raw, &segments); err == nil {
 		var builder strings.Builder
+		parts := make([]models.ContentPart, 0, len(segments))
 		for _, segment := range segments {
-			if segment.Type != "text" {
-				return "", fmt.Errorf("%w: segment type %q not supported", errInvalidContent, segment.Type)
+			switch segment.Type {
+			case "text":
+				builder.WriteString(segment.Text)
+				parts = append(parts, models.ContentPart{Text: segment.Text})
+			case "image_url":
+				image, err := parseImageURL(segment.ImageURL.URL, segment.ImageURL.Detail)
+				if err != nil {
+					return "", nil, err
+				}
+				parts = append(parts, models.ContentPart{Image: image})
+			default:
+				return "", nil, fmt.Errorf("%w: segment type %q not supported", errInvalidContent, segment.Type)
 			}
-			builder.WriteString(segment.Text)
 		}
-		return builder.String(), nil
+		return builder.String(), messageParts(parts), nil
 	}
 
-	return "", fmt.Errorf("%w: unsupported content structure", errInvalidContent)
+	return "", nil, fmt.Errorf("%w: unsupported content structure", errInvalidContent)
 }
 
 func parseStop(raw json.RawMessage) ([]string, error) {
@@ -246,9 +362,11 @@ func parseStop(raw json.RawMessage) ([]string, error) {
 		return nil, nil
 	}
 
+	// Stop sequences are matched verbatim upstream, so whitespace such as
+	// "\n" is significant and must not be trimmed away.
 	var single string
 	if err := json.Unmarshal(raw, &single); err == nil {
-		if strings.TrimSpace(single) == "" {
+		if single == "" {
 			return nil, errUnsupportedStop
 		}
 		return []string{single}, nil
@@ -258,7 +376,6 @@ func parseStop(raw json.RawMessage) ([]string, error) {
 	if err := json.Unmarshal(raw, &multi); err == nil {
 		out := make([]string, 0, len(multi))
 		for _, item := range multi {
-			item = strings.TrimSpace(item)
 			if item == "" {
 				return nil, errUnsupportedStop
 			}
@@ -277,6 +394,8 @@ type ChatCompletionResponse struct {
 	Model   string       `json:"model"`
 	Choices []ChatChoice `json:"choices"`
 	Usage   *OpenAIUsage `json:"usage,omitempty"`
+
+	RouterMetadata map[string]any `json:"router_metadata,omitempty"`
 }
 
 // ChatChoice represents a single choice in the response payload.
@@ -290,9 +409,29 @@ type ChatChoice struct {
 
 // OpenAIUsage mirrors the token usage block in OpenAI responses.
 type OpenAIUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+// PromptTokensDetails breaks down the prompt tokens of OpenAI usage.
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// openAIUsage renders unified usage in OpenAI form. Prompt tokens read
+// from the cache are reported as cached_tokens.
+func openAIUsage(u models.Usage) *OpenAIUsage {
+	usage := &OpenAIUsage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+	if u.CacheReadTokens > 0 {
+		usage.PromptTokensDetails = &PromptTokensDetails{CachedTokens: u.CacheReadTokens}
+	}
+	return usage
 }
 
 // FromUnifiedChat constructs the OpenAI response shape from the unified data.
@@ -300,20 +439,32 @@ func FromUnifiedChat(modelID string, createdUnix int64, resp *models.UnifiedChat
 	choice := ChatChoice{
 		Index: 0,
 		Message: ChatMessage{
-			Role:    resp.Message.Role,
-			Content: resp.Message.Content,
-			Name:    resp.Message.Name,
+			Role:        resp.Message.Role,
+			Content:     resp.Message.Content,
+			Name:        resp.Message.Name,
+			ToolCalls:   chatToolCalls(resp.Message.ToolCalls),
+			Annotations: citationAnnotations(resp.Citations),
 		},
-		FinishReason: resp.FinishReason,
+		FinishReason: openAIFinishReason(resp.FinishReason),
 	}
 
 	var usage *OpenAIUsage
 	if resp.Usage.TotalTokens != 0 || resp.Usage.PromptTokens != 0 || resp.Usage.CompletionTokens != 0 {
-		usage = &OpenAIUsage{
-			PromptTokens:     resp.Usage.PromptTokens,
-			CompletionTokens: resp.Usage.CompletionTokens,
-			TotalTokens:      resp.Usage.TotalTokens,
-		}
+		usage = openAIUsage(resp.Usage)
+	}
+
+	choices := []ChatChoice{choice}
+	for i, extra := range resp.ExtraChoices {
+		choices = append(choices, ChatChoice{
+			Index: i + 1,
+			Message: ChatMessage{
+				Role:      extra.Message.Role,
+				Content:   extra.Message.Content,
+				Name:      extra.Message.Name,
+				ToolCalls: chatToolCalls(extra.Message.ToolCalls),
+			},
+			FinishReason: openAIFinishReason(extra.FinishReason),
+		})
 	}
 
 	return ChatCompletionResponse{
@@ -321,9 +472,32 @@ func FromUnifiedChat(modelID string, createdUnix int64, resp *models.UnifiedChat
 		Object:  "chat.completion",
 		Created: createdUnix,
 		Model:   modelID,
-		Choices: []ChatChoice{choice},
+		Choices: choices,
 		Usage:   usage,
+
+		RouterMetadata: resp.Metadata,
+	}
+}
+
+// citationAnnotations renders citations that point at a URL as
+// url_citation annotations; OpenAI has no form for document citations.
+func citationAnnotations(citations []models.Citation) []Annotation {
+	var out []Annotation
+	for _, c := range citations {
+		if c.URL == "" {
+			continue
+		}
+		out = append(out, Annotation{
+			Type: "url_citation",
+			URLCitation: &URLCitation{
+				StartIndex: c.Start,
+				EndIndex:   c.End,
+				URL:        c.URL,
+				Title:      c.Title,
+			},
+		})
 	}
+	return out
 }
 
 // CompletionRequest models the legacy OpenAI text completions request payload.
@@ -410,6 +584,8 @@ type CompletionResponse struct {
 	Model   string             `json:"model"`
 	Choices []CompletionChoice `json:"choices"`
 	Usage   *OpenAIUsage       `json:"usage,omitempty"`
+
+	RouterMetadata map[string]any `json:"router_metadata,omitempty"`
 }
 
 // CompletionChoice represents a single completion choice.
@@ -424,11 +600,7 @@ type CompletionChoice struct {
 func FromUnifiedCompletion(modelID string, createdUnix int64, resp *models.UnifiedCompletionResponse) CompletionResponse {
 	var usage *OpenAIUsage
 	if resp.Usage.TotalTokens != 0 || resp.Usage.PromptTokens != 0 || resp.Usage.CompletionTokens != 0 {
-		usage = &OpenAIUsage{
-			PromptTokens:     resp.Usage.PromptTokens,
-			CompletionTokens: resp.Usage.CompletionTokens,
-			TotalTokens:      resp.Usage.TotalTokens,
-		}
+		usage = openAIUsage(resp.Usage)
 	}
 
 	return CompletionResponse{
@@ -444,6 +616,8 @@ func FromUnifiedCompletion(modelID string, createdUnix int64, resp *models.Unifi
 			},
 		},
 		Usage: usage,
+
+		RouterMetadata: resp.Metadata,
 	}
 }
 
@@ -0,0 +1,278 @@
+package translator
+
+import (
+	"gocode-router/internal/models"
+)
+
+// ChatCompletionChunk is one event of a streamed chat response.
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *OpenAIUsage  `json:"usage,omitempty"`
+}
+
+// ChunkChoice carries the delta of one choice. FinishReason is null until
+// the choice's last chunk.
+type ChunkChoice struct {
+	Index        int        `json:"index"`
+	Delta        ChunkDelta `json:"delta"`
+	FinishReason *string    `json:"finish_reason"`
+}
+
+// ChunkDelta is what a chunk adds to a choice.
+type ChunkDelta struct {
+	Role      string          `json:"role,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	ToolCalls []ChunkToolCall `json:"tool_calls,omitempty"`
+}
+
+// ChunkToolCall is part of a tool call; see models.ToolCallChunk.
+type ChunkToolCall struct {
+	Index    int               `json:"index"`
+	ID       string            `json:"id,omitempty"`
+	Type     string            `json:"type,omitempty"`
+	Function ChunkToolFunction `json:"function"`
+}
+
+// ChunkToolFunction names the function of a tool call and carries a piece
+// of its arguments.
+type ChunkToolFunction struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// ChatChunkEncoder renders a unified chat stream as OpenAI
+// chat.completion.chunk objects. Every chunk carries the ID of the first.
+type ChatChunkEncoder struct {
+	id      string
+	modelID string
+	created int64
+}
+
+// NewChatChunkEncoder returns an encoder for a stream from modelID.
+func NewChatChunkEncoder(modelID string, createdUnix int64) *ChatChunkEncoder {
+	return &ChatChunkEncoder{modelID: modelID, created: createdUnix}
+}
+
+// Encode returns the OpenAI chunk for c. Chunks that only report usage have
+// no chunk of their own, and Encode reports false for them.
+func (e *ChatChunkEncoder) Encode(c models.UnifiedChatChunk) (ChatCompletionChunk, bool) {
+	if e.id == "" {
+		e.id = c.ID
+	}
+	if c.Role == "" && c.Content == "" && len(c.ToolCalls) == 0 && c.FinishReason == "" {
+		return ChatCompletionChunk{}, false
+	}
+
+	choice := ChunkChoice{
+		Index: c.Index,
+		Delta: ChunkDelta{Role: c.Role, Content: c.Content},
+	}
+	for _, call := range c.ToolCalls {
+		out := ChunkToolCall{
+			Index:    call.Index,
+			ID:       call.ID,
+			Function: ChunkToolFunction{Name: call.Name, Arguments: call.Arguments},
+		}
+		if call.ID != "" {
+			out.Type = "function"
+		}
+		choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, out)
+	}
+	if c.FinishReason != "" {
+		reason := openAIFinishReason(c.FinishReason)
+		choice.FinishReason = &reason
+	}
+	return ChatCompletionChunk{
+		ID:      e.id,
+		Object:  "chat.completion.chunk",
+		Created: e.created,
+		Model:   e.modelID,
+		Choices: []ChunkChoice{choice},
+	}, true
+}
+
+// Usage returns the chunk that ends a stream whose client asked for usage
+// with stream_options.include_usage: no choices, only the usage.
+func (e *ChatChunkEncoder) Usage(u models.Usage) ChatCompletionChunk {
+	if u.TotalTokens == 0 {
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+	}
+	return ChatCompletionChunk{
+		ID:      e.id,
+		Object:  "chat.completion.chunk",
+		Created: e.created,
+		Model:   e.modelID,
+		Choices: []ChunkChoice{},
+		Usage:   openAIUsage(u),
+	}
+}
+
+// ClaudeStreamEvent is a named event of an Anthropic message stream.
+type ClaudeStreamEvent struct {
+	Name    string
+	Payload any
+}
+
+// ClaudeStreamEncoder renders a unified chat stream as Anthropic message
+// stream events. Text and each tool call get a content block of their own,
+// in the order they start; tool call arguments arrive as input_json_delta
+// pieces. Upstreams may interleave the arguments of parallel tool calls, so
+// tool_use blocks stay open until Finish. Claude has no choices after the
+// first, so theirs are dropped.
+type ClaudeStreamEncoder struct {
+	modelID string
+	started bool
+	// blocks counts the content blocks started; text is the index of the
+	// open text block, or -1 when none is.
+	blocks int
+	text   int
+	// calls maps the index of each tool call to its content block, and
+	// toolBlocks lists those blocks in the order they started.
+	calls      map[int]int
+	toolBlocks []int
+}
+
+// NewClaudeStreamEncoder returns an encoder for a stream from modelID.
+func NewClaudeStreamEncoder(modelID string) *ClaudeStreamEncoder {
+	return &ClaudeStreamEncoder{modelID: modelID, text: -1, calls: make(map[int]int)}
+}
+
+// Encode returns the events for c; the first chunk also opens the message.
+func (e *ClaudeStreamEncoder) Encode(c models.UnifiedChatChunk) []ClaudeStreamEvent {
+	var events []ClaudeStreamEvent
+	if !e.started {
+		e.started = true
+		var usage models.Usage
+		if c.Usage != nil {
+			usage = *c.Usage
+		}
+		events = append(events, ClaudeStreamEvent{Name: "message_start", Payload: map[string]any{
+			"type": "message_start",
+			"message": map[string]any{
+				"id":            c.ID,
+				"type":          "message",
+				"role":          "assistant",
+				"model":         e.modelID,
+				"content":       []any{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         claudeStreamUsage(models.Usage{PromptTokens: usage.PromptTokens, CacheCreationTokens: usage.CacheCreationTokens, CacheReadTokens: usage.CacheReadTokens}),
+			},
+		}})
+	}
+	if c.Index != 0 {
+		return events
+	}
+
+	if c.Content != "" {
+		if e.text < 0 {
+			events, e.text = e.startBlock(events, map[string]any{"type": "text", "text": ""})
+		}
+		events = append(events, claudeBlockDelta(e.text, map[string]any{"type": "text_delta", "text": c.Content}))
+	}
+	for _, call := range c.ToolCalls {
+		block, ok := e.calls[call.Index]
+		if !ok {
+			events = e.stopText(events)
+			events, block = e.startBlock(events, map[string]any{
+				"type":  "tool_use",
+				"id":    call.ID,
+				"name":  call.Name,
+				"input": map[string]any{},
+			})
+			e.calls[call.Index] = block
+			e.toolBlocks = append(e.toolBlocks, block)
+		}
+		if call.Arguments != "" {
+			events = append(events, claudeBlockDelta(block, map[string]any{"type": "input_json_delta", "partial_json": call.Arguments}))
+		}
+	}
+	return events
+}
+
+// Finish returns the events that close the message. A message that
+// streamed nothing still gets an empty text block.
+func (e *ClaudeStreamEncoder) Finish(finishReason string, usage models.Usage) []ClaudeStreamEvent {
+	var events []ClaudeStreamEvent
+	if e.blocks == 0 {
+		events, e.text = e.startBlock(events, map[string]any{"type": "text", "text": ""})
+	}
+	events = e.stopText(events)
+	for _, block := range e.toolBlocks {
+		events = append(events, claudeBlockStop(block))
+	}
+	e.toolBlocks = nil
+	return append(events,
+		ClaudeStreamEvent{Name: "message_delta", Payload: map[string]any{
+			"type": "message_delta",
+			"delta": map[string]any{
+				"stop_reason":   claudeStopReason(finishReason),
+				"stop_sequence": nil,
+			},
+			"usage": claudeStreamUsage(usage),
+		}},
+		ClaudeStreamEvent{Name: "message_stop", Payload: map[string]any{"type": "message_stop"}},
+	)
+}
+
+// claudeStreamUsage renders usage for message_start and message_delta
+// events, with the cache counts when the prompt used the cache.
+func claudeStreamUsage(u models.Usage) map[string]int {
+	usage := map[string]int{
+		"input_tokens":  u.PromptTokens,
+		"output_tokens": u.CompletionTokens,
+	}
+	if u.CacheCreationTokens > 0 || u.CacheReadTokens > 0 {
+		usage["cache_creation_input_tokens"] = u.CacheCreationTokens
+		usage["cache_read_input_tokens"] = u.CacheReadTokens
+	}
+	return usage
+}
+
+// startBlock starts the next content block and returns its index.
+func (e *ClaudeStreamEncoder) startBlock(events []ClaudeStreamEvent, block map[string]any) ([]ClaudeStreamEvent, int) {
+	index := e.blocks
+	e.blocks++
+	return append(events, ClaudeStreamEvent{Name: "content_block_start", Payload: map[string]any{
+		"type":          "content_block_start",
+		"index":         index,
+		"content_block": block,
+	}}), index
+}
+
+// stopText closes the open text block, if any.
+func (e *ClaudeStreamEncoder) stopText(events []ClaudeStreamEvent) []ClaudeStreamEvent {
+	if e.text < 0 {
+		return events
+	}
+	events = append(events, claudeBlockStop(e.text))
+	e.text = -1
+	return events
+}
+
+func claudeBlockStop(index int) ClaudeStreamEvent {
+	return ClaudeStreamEvent{Name: "content_block_stop", Payload: map[string]any{
+		"type":  "content_block_stop",
+		"index": index,
+	}}
+}
+
+func claudeBlockDelta(index int, delta map[string]any) ClaudeStreamEvent {
+	return ClaudeStreamEvent{Name: "content_block_delta", Payload: map[string]any{
+		"type":  "content_block_delta",
+		"index": index,
+		"delta": delta,
+	}}
+}
+
+// ClaudeStreamError is the event that reports a failure mid-stream.
+func ClaudeStreamError(message string) ClaudeStreamEvent {
+	return ClaudeStreamEvent{Name: "error", Payload: map[string]any{
+		"type":  "error",
+		"error": map[string]any{"type": "api_error", "message": message},
+	}}
+}
@@ -0,0 +1,173 @@
+package translator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gocode-router/internal/models"
+)
+
+var (
+	errInvalidTools     = errors.New("invalid tools")
+	errInvalidToolCalls = errors.New("invalid tool_calls")
+)
+
+// Finish reasons of a response that stopped to call tools.
+const (
+	finishToolCalls   = "tool_calls"
+	stopReasonToolUse = "tool_use"
+)
+
+// ChatToolCall is a tool call in an OpenAI message.
+type ChatToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ChatToolFunction `json:"function"`
+}
+
+// ChatToolFunction names the function of a tool call and carries its JSON
+// encoded arguments.
+type ChatToolFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// namedCalls ties tool results to the calls they answer for APIs, such as
+// Gemini and Ollama, that match them by function name and only sometimes
+// send call IDs. Calls without an ID are given one, and a result without
+// one takes the oldest unanswered call of its name, or of any name when it
+// names none.
+type namedCalls struct {
+	next    int
+	pending []models.ToolCall
+}
+
+func (n *namedCalls) call(name, id string) string {
+	if id == "" {
+		n.next++
+		id = fmt.Sprintf("call_%d", n.next)
+	}
+	n.pending = append(n.pending, models.ToolCall{ID: id, Name: name})
+	return id
+}
+
+func (n *namedCalls) answer(name, id string) (string, bool) {
+	for i, call := range n.pending {
+		if (id != "" && call.ID == id) || (id == "" && (name == "" || call.Name == name)) {
+			n.pending = append(n.pending[:i:i], n.pending[i+1:]...)
+			return call.ID, true
+		}
+	}
+	return id, id != ""
+}
+
+// parseOpenAITools reads OpenAI function tools.
+func parseOpenAITools(raw json.RawMessage) ([]models.ToolDefinition, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	var tools []struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			Parameters  json.RawMessage `json:"parameters"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &tools); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidTools, err)
+	}
+
+	out := make([]models.ToolDefinition, 0, len(tools))
+	for i, tool := range tools {
+		if tool.Type != "function" {
+			return nil, fmt.Errorf("%w: tools[%d] has unsupported type %q", errInvalidTools, i, tool.Type)
+		}
+		if strings.TrimSpace(tool.Function.Name) == "" {
+			return nil, fmt.Errorf("%w: tools[%d] is missing a function name", errInvalidTools, i)
+		}
+		out = append(out, models.ToolDefinition{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+	return out, nil
+}
+
+// validateToolCalls checks the tool calls of an OpenAI message.
+func validateToolCalls(calls []ChatToolCall) error {
+	for i, call := range calls {
+		if call.Type != "" && call.Type != "function" {
+			return fmt.Errorf("%w: tool_calls[%d] has unsupported type %q", errInvalidToolCalls, i, call.Type)
+		}
+		if strings.TrimSpace(call.ID) == "" || strings.TrimSpace(call.Function.Name) == "" {
+			return fmt.Errorf("%w: tool_calls[%d] needs an id and a function name", errInvalidToolCalls, i)
+		}
+	}
+	return nil
+}
+
+// toUnifiedToolCalls converts the tool calls of an OpenAI message.
+func toUnifiedToolCalls(calls []ChatToolCall) []models.ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	out := make([]models.ToolCall, len(calls))
+	for i, call := range calls {
+		out[i] = models.ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments}
+	}
+	return out
+}
+
+// chatToolCalls renders unified tool calls for an OpenAI message.
+func chatToolCalls(calls []models.ToolCall) []ChatToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	out := make([]ChatToolCall, len(calls))
+	for i, call := range calls {
+		arguments := call.Arguments
+		if arguments == "" {
+			arguments = "{}"
+		}
+		out[i] = ChatToolCall{ID: call.ID, Type: "function", Function: ChatToolFunction{Name: call.Name, Arguments: arguments}}
+	}
+	return out
+}
+
+// openAIFinishReason reports a stop to call tools, or at the token limit,
+// as OpenAI does, whatever the upstream called it.
+func openAIFinishReason(reason string) string {
+	switch reason {
+	case stopReasonToolUse:
+		return finishToolCalls
+	case "max_tokens":
+		return "length"
+	}
+	return reason
+}
+
+// claudeStopReason reports a stop to call tools, or at the token limit, as
+// Anthropic does.
+func claudeStopReason(reason string) string {
+	switch reason {
+	case finishToolCalls:
+		return stopReasonToolUse
+	case "length":
+		return "max_tokens"
+	}
+	return reason
+}
+
+// toolInput returns the arguments of a tool call as the JSON object
+// Anthropic expects for a tool_use input.
+func toolInput(arguments string) json.RawMessage {
+	if strings.TrimSpace(arguments) == "" || !json.Valid([]byte(arguments)) {
+		return json.RawMessage(`{}`)
+	}
+	return json.RawMessage(arguments)
+}
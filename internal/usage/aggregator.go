@@ -0,0 +1,151 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const pruneInterval = time.Hour
+
+// Aggregator buffers usage records from the request path and, in the
+// background, writes them to the store and folds them into hourly and daily
+// rollups. Raw records older than the retention window are pruned.
+type Aggregator struct {
+	store     Store
+	interval  time.Duration
+	retention time.Duration
+
+	mu      sync.Mutex
+	pending []Record
+	now     func() time.Time
+}
+
+// NewAggregator constructs an aggregator over store.
+func NewAggregator(store Store, interval, retention time.Duration) (*Aggregator, error) {
+	if store == nil {
+		return nil, errors.New("usage store must not be nil")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("usage flush interval %s must be positive", interval)
+	}
+	return &Aggregator{
+		store:     store,
+		interval:  interval,
+		retention: retention,
+		now:       time.Now,
+	}, nil
+}
+
+// Add queues a record. It never blocks on the store. A record without a
+// key is filed under AnonymousKey: an empty key in a query matches every
+// key, so it must never identify a caller.
+func (a *Aggregator) Add(rec Record) {
+	if rec.Time.IsZero() {
+		rec.Time = a.now()
+	}
+	if rec.Key == "" {
+		rec.Key = AnonymousKey
+	}
+	rec.Time = rec.Time.UTC()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = append(a.pending, rec)
+}
+
+// Query returns rollups matching q. Records still buffered are not included
+// until the next flush.
+func (a *Aggregator) Query(ctx context.Context, q Query) ([]Bucket, error) {
+	return a.store.Buckets(ctx, q)
+}
+
+// Run flushes on every interval until ctx is cancelled, then flushes once
+// more and closes the store.
+func (a *Aggregator) Run(ctx context.Context) error {
+	flushTicker := time.NewTicker(a.interval)
+	defer flushTicker.Stop()
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
+	a.prune(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			a.flush(context.Background())
+			return a.store.Close()
+		case <-flushTicker.C:
+			a.flush(ctx)
+		case <-pruneTicker.C:
+			a.prune(ctx)
+		}
+	}
+}
+
+func (a *Aggregator) flush(ctx context.Context) {
+	a.mu.Lock()
+	records := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+
+	if len(records) == 0 {
+		return
+	}
+
+	if err := a.store.Append(ctx, records...); err != nil {
+		slog.Warn("usage record write failed", "records", len(records), "error", err)
+	}
+	if err := a.store.AddBuckets(ctx, rollup(records)...); err != nil {
+		slog.Warn("usage rollup failed", "records", len(records), "error", err)
+	}
+}
+
+func (a *Aggregator) prune(ctx context.Context) {
+	if a.retention <= 0 {
+		return
+	}
+	pruned, err := a.store.PruneRecords(ctx, a.now().Add(-a.retention))
+	if err != nil {
+		slog.Warn("usage record pruning failed", "error", err)
+		return
+	}
+	if pruned > 0 {
+		slog.Debug("pruned raw usage records", "count", pruned)
+	}
+}
+
+// rollup folds records into hourly and daily buckets.
+func rollup(records []Record) []Bucket {
+	merged := make(map[string]Bucket)
+	for _, rec := range records {
+		failed := 0
+		if rec.Status >= 400 {
+			failed = 1
+		}
+		for _, g := range []Granularity{Hourly, Daily} {
+			mergeBuckets(merged, []Bucket{{
+				Granularity:      g,
+				Start:            g.Truncate(rec.Time),
+				Key:              rec.Key,
+				Model:            rec.Model,
+				Provider:         rec.Provider,
+				Requests:         1,
+				PromptTokens:     rec.PromptTokens,
+				CompletionTokens: rec.CompletionTokens,
+				TotalTokens:      rec.TotalTokens,
+				Cost:             rec.Cost,
+				Failed:           failed,
+				LatencyMS:        rec.LatencyMS,
+			}})
+		}
+	}
+
+	out := make([]Bucket, 0, len(merged))
+	for _, b := range merged {
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,220 @@
+package usage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	recordsFile = "records.jsonl"
+	rollupsFile = "rollups.json"
+)
+
+// FileStore keeps usage in a directory: raw records are appended to a JSONL
+// file and rollups are held in memory and snapshotted after every update.
+type FileStore struct {
+	mu      sync.Mutex
+	dir     string
+	records *os.File
+	buckets map[string]Bucket
+}
+
+// OpenFileStore opens or creates a usage store in dir.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("usage directory must not be empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create usage directory: %w", err)
+	}
+
+	s := &FileStore{dir: dir, buckets: make(map[string]Bucket)}
+	if err := s.loadRollups(); err != nil {
+		return nil, err
+	}
+
+	records, err := os.OpenFile(s.recordsPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open usage records: %w", err)
+	}
+	s.records = records
+	return s, nil
+}
+
+// Append implements Store.
+func (s *FileStore) Append(_ context.Context, records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var buf []byte
+	for _, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("marshal usage record: %w", err)
+		}
+		buf = append(buf, data...)
+		buf = append(buf, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		return errors.New("usage store is closed")
+	}
+	if _, err := s.records.Write(buf); err != nil {
+		return fmt.Errorf("write usage records: %w", err)
+	}
+	return nil
+}
+
+// AddBuckets implements Store.
+func (s *FileStore) AddBuckets(_ context.Context, buckets ...Bucket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mergeBuckets(s.buckets, buckets)
+	return s.saveRollups()
+}
+
+// Buckets implements Store.
+func (s *FileStore) Buckets(_ context.Context, q Query) ([]Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return selectBuckets(s.buckets, q), nil
+}
+
+// PruneRecords implements Store. The records file is rewritten without the
+// expired rows and swapped into place.
+func (s *FileStore) PruneRecords(_ context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		return 0, errors.New("usage store is closed")
+	}
+
+	src, err := os.Open(s.recordsPath())
+	if err != nil {
+		return 0, fmt.Errorf("open usage records: %w", err)
+	}
+	defer src.Close()
+
+	tmpPath := s.recordsPath() + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("create usage records: %w", err)
+	}
+	writer := bufio.NewWriter(tmp)
+
+	pruned := 0
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Time.Before(before) {
+			pruned++
+			continue
+		}
+		writer.Write(scanner.Bytes())
+		writer.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return 0, fmt.Errorf("read usage records: %w", err)
+	}
+	if pruned == 0 {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return 0, nil
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("write usage records: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("close usage records: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.recordsPath()); err != nil {
+		return 0, fmt.Errorf("replace usage records: %w", err)
+	}
+
+	s.records.Close()
+	records, err := os.OpenFile(s.recordsPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		s.records = nil
+		return pruned, fmt.Errorf("open usage records: %w", err)
+	}
+	s.records = records
+	return pruned, nil
+}
+
+// Close implements Store.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		return nil
+	}
+	err := s.records.Close()
+	s.records = nil
+	return err
+}
+
+func (s *FileStore) recordsPath() string {
+	return filepath.Join(s.dir, recordsFile)
+}
+
+func (s *FileStore) rollupsPath() string {
+	return filepath.Join(s.dir, rollupsFile)
+}
+
+func (s *FileStore) loadRollups() error {
+	data, err := os.ReadFile(s.rollupsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read usage rollups: %w", err)
+	}
+
+	var buckets []Bucket
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return fmt.Errorf("parse usage rollups %q: %w", s.rollupsPath(), err)
+	}
+	// Older snapshots left keyless usage without a key.
+	for i := range buckets {
+		if buckets[i].Key == "" {
+			buckets[i].Key = AnonymousKey
+		}
+	}
+	mergeBuckets(s.buckets, buckets)
+	return nil
+}
+
+// saveRollups writes the rollups beside the current snapshot and renames it
+// into place so a crash never leaves a partial snapshot.
+func (s *FileStore) saveRollups() error {
+	buckets := make([]Bucket, 0, len(s.buckets))
+	for _, b := range s.buckets {
+		buckets = append(buckets, b)
+	}
+	data, err := json.Marshal(buckets)
+	if err != nil {
+		return fmt.Errorf("marshal usage rollups: %w", err)
+	}
+
+	tmpPath := s.rollupsPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("write usage rollups: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.rollupsPath()); err != nil {
+		return fmt.Errorf("replace usage rollups: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+// Package usage records per-request token usage and rolls it up into
+// hourly and daily aggregates per key, model and provider.
+package usage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"gocode-router/internal/config"
+)
+
+// Granularity is the width of a rollup bucket.
+type Granularity string
+
+// Supported rollup granularities.
+const (
+	Hourly Granularity = "hour"
+	Daily  Granularity = "day"
+)
+
+// Truncate returns the start of the bucket containing t.
+func (g Granularity) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	if g == Daily {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// Record is the usage of a single request.
+type Record struct {
+	Time             time.Time `json:"time"`
+	Key              string    `json:"key"`
+	Model            string    `json:"model"`
+	Provider         string    `json:"provider"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	// Cost is the price of the request in US dollars, from the model's
+	// pricing when it was served; zero when the model has none.
+	Cost float64 `json:"cost,omitempty"`
+	// Status is the HTTP status the request ended with, and LatencyMS how
+	// long it took to serve. Zero in records written before they existed.
+	Status    int   `json:"status,omitempty"`
+	LatencyMS int64 `json:"latency_ms,omitempty"`
+	// Tags are the request tags. Rollups do not break usage down by tag.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Bucket aggregates usage for one key, model and provider over a period.
+type Bucket struct {
+	Granularity      Granularity `json:"granularity"`
+	Start            time.Time   `json:"start"`
+	Key              string      `json:"key"`
+	Model            string      `json:"model"`
+	Provider         string      `json:"provider"`
+	Requests         int         `json:"requests"`
+	PromptTokens     int         `json:"prompt_tokens"`
+	CompletionTokens int         `json:"completion_tokens"`
+	TotalTokens      int         `json:"total_tokens"`
+	Cost             float64     `json:"cost"`
+	// Failed counts the requests that ended with an error status, and
+	// LatencyMS sums the latency of every request.
+	Failed    int   `json:"failed"`
+	LatencyMS int64 `json:"latency_ms"`
+}
+
+func (b Bucket) id() string {
+	return fmt.Sprintf("%s|%d|%s|%s|%s", b.Granularity, b.Start.Unix(), b.Key, b.Model, b.Provider)
+}
+
+func (b *Bucket) merge(other Bucket) {
+	b.Requests += other.Requests
+	b.PromptTokens += other.PromptTokens
+	b.CompletionTokens += other.CompletionTokens
+	b.TotalTokens += other.TotalTokens
+	b.Cost += other.Cost
+	b.Failed += other.Failed
+	b.LatencyMS += other.LatencyMS
+}
+
+// Ways Sum can group buckets.
+const (
+	GroupByKey      = "key"
+	GroupByModel    = "model"
+	GroupByProvider = "provider"
+	GroupByTime     = "time"
+)
+
+// Total is the usage of a group of buckets.
+type Total struct {
+	// Group is the key ID, model, provider or bucket start the total
+	// covers; empty for an overall total.
+	Group            string  `json:"group,omitempty"`
+	Requests         int     `json:"requests"`
+	Failed           int     `json:"failed"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+	AvgLatencyMS     float64 `json:"avg_latency_ms"`
+}
+
+// Overall totals every bucket.
+func Overall(buckets []Bucket) Total {
+	if totals := Sum(buckets, ""); len(totals) > 0 {
+		return totals[0]
+	}
+	return Total{}
+}
+
+// Sum totals buckets by key, model, provider or bucket start, or overall
+// when groupBy is empty. Totals by time are in time order; the others are
+// ordered by cost, highest first.
+func Sum(buckets []Bucket, groupBy string) []Total {
+	totals := make(map[string]*Total)
+	latency := make(map[string]int64)
+	for _, b := range buckets {
+		var group string
+		switch groupBy {
+		case GroupByKey:
+			group = b.Key
+		case GroupByModel:
+			group = b.Model
+		case GroupByProvider:
+			group = b.Provider
+		case GroupByTime:
+			group = b.Start.UTC().Format(time.RFC3339)
+		}
+		t, ok := totals[group]
+		if !ok {
+			t = &Total{Group: group}
+			totals[group] = t
+		}
+		t.Requests += b.Requests
+		t.Failed += b.Failed
+		t.PromptTokens += b.PromptTokens
+		t.CompletionTokens += b.CompletionTokens
+		t.TotalTokens += b.TotalTokens
+		t.Cost += b.Cost
+		latency[group] += b.LatencyMS
+	}
+
+	out := make([]Total, 0, len(totals))
+	for group, t := range totals {
+		if t.Requests > 0 {
+			t.AvgLatencyMS = float64(latency[group]) / float64(t.Requests)
+		}
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if groupBy == GroupByTime {
+			return out[i].Group < out[j].Group
+		}
+		if out[i].Cost != out[j].Cost {
+			return out[i].Cost > out[j].Cost
+		}
+		return out[i].Group < out[j].Group
+	})
+	return out
+}
+
+// Query selects rollup buckets. Zero-valued fields match everything.
+type Query struct {
+	Granularity Granularity
+	Key         string
+	Model       string
+	Provider    string
+	From        time.Time
+	To          time.Time
+}
+
+func (q Query) matches(b Bucket) bool {
+	if b.Granularity != q.Granularity {
+		return false
+	}
+	if q.Key != "" && b.Key != q.Key {
+		return false
+	}
+	if q.Model != "" && b.Model != q.Model {
+		return false
+	}
+	if q.Provider != "" && b.Provider != q.Provider {
+		return false
+	}
+	if !q.From.IsZero() && b.Start.Before(q.Granularity.Truncate(q.From)) {
+		return false
+	}
+	if !q.To.IsZero() && !b.Start.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// AnonymousKey attributes usage from requests that presented no key.
+const AnonymousKey = "anonymous"
+
+// KeyID derives a stable, non-reversible identifier for a client key so
+// usage can be attributed without storing the secret itself.
+func KeyID(secret string) string {
+	if secret == "" {
+		return AnonymousKey
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return "key_" + hex.EncodeToString(sum[:])[:16]
+}
+
+// Store persists raw usage records and rollup buckets.
+type Store interface {
+	// Append stores raw records.
+	Append(ctx context.Context, records ...Record) error
+	// AddBuckets merges counts into the stored rollups.
+	AddBuckets(ctx context.Context, buckets ...Bucket) error
+	// Buckets returns the rollups matching q, ordered by start time.
+	Buckets(ctx context.Context, q Query) ([]Bucket, error)
+	// PruneRecords deletes raw records older than before.
+	PruneRecords(ctx context.Context, before time.Time) (int, error)
+	// Close releases resources held by the store.
+	Close() error
+}
+
+// NewStore builds the backend selected by the usage configuration.
+func NewStore(cfg config.UsageConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", config.UsageBackendMemory:
+		return NewMemoryStore(), nil
+	case config.UsageBackendFile:
+		return OpenFileStore(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown usage backend %q", cfg.Backend)
+	}
+}
+
+// MemoryStore keeps usage in process memory. It is lost on restart.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records []Record
+	buckets map[string]Bucket
+}
+
+// NewMemoryStore constructs an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]Bucket)}
+}
+
+// Append implements Store.
+func (m *MemoryStore) Append(_ context.Context, records ...Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, records...)
+	return nil
+}
+
+// AddBuckets implements Store.
+func (m *MemoryStore) AddBuckets(_ context.Context, buckets ...Bucket) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mergeBuckets(m.buckets, buckets)
+	return nil
+}
+
+// Buckets implements Store.
+func (m *MemoryStore) Buckets(_ context.Context, q Query) ([]Bucket, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return selectBuckets(m.buckets, q), nil
+}
+
+// PruneRecords implements Store.
+func (m *MemoryStore) PruneRecords(_ context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept, pruned := pruneRecords(m.records, before)
+	m.records = kept
+	return pruned, nil
+}
+
+// Close implements Store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func mergeBuckets(dst map[string]Bucket, buckets []Bucket) {
+	for _, b := range buckets {
+		id := b.id()
+		if existing, ok := dst[id]; ok {
+			existing.merge(b)
+			dst[id] = existing
+			continue
+		}
+		dst[id] = b
+	}
+}
+
+func selectBuckets(buckets map[string]Bucket, q Query) []Bucket {
+	out := make([]Bucket, 0)
+	for _, b := range buckets {
+		if q.matches(b) {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Start.Equal(out[j].Start) {
+			return out[i].Start.Before(out[j].Start)
+		}
+		return out[i].id() < out[j].id()
+	})
+	return out
+}
+
+func pruneRecords(records []Record, before time.Time) ([]Record, int) {
+	kept := records[:0]
+	for _, rec := range records {
+		if rec.Time.Before(before) {
+			continue
+		}
+		kept = append(kept, rec)
+	}
+	return kept, len(records) - len(kept)
+}